@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// version is the server version, set at build time with
+// -ldflags "-X main.version=1.2.3"
+var version = "1.0.0"
+
+// options are the command-line flags of the server
+type options struct {
+	configPath string
+	version    bool
+
+	// printConfig prints the effective configuration instead of serving,
+	// with the config print subcommand or --print-config
+	printConfig bool
+
+	// migrate applies the pending migrations of the persistent stores
+	// instead of serving, with --migrate
+	migrate bool
+
+	// overrides apply the configuration flags that were set, which take
+	// precedence over the environment, the config file and the defaults
+	overrides []func(cfg *config.Config)
+}
+
+// parseFlags parses the command-line arguments, without the program name:
+// the flags, optionally after the config print subcommand. The config path
+// defaults to SERVER_CONFIG_PATH or the default file in the config directory.
+func parseFlags(args []string, output io.Writer) (*options, error) {
+	opts := &options{}
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		opts.printConfig = true
+		args = args[2:]
+	}
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.configPath, "config", config.GetConfigPath(), "path of the config file: .yaml, .yml, .toml or .json")
+	fs.BoolVar(&opts.version, "version", false, "print the version and exit")
+	fs.BoolVar(&opts.printConfig, "print-config", opts.printConfig, "print the effective configuration, secrets redacted, and exit")
+	fs.BoolVar(&opts.migrate, "migrate", false, "apply the pending migrations of the persistent stores and exit (MIGRATIONS_MANUAL)")
+
+	port := fs.Int("port", 0, "HTTP server port (SERVER_PORT)")
+	host := fs.String("host", "", "HTTP server host (SERVER_HOST)")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error (LOGGING_LEVEL)")
+	logFormat := fs.String("log-format", "", "log format: json or text (LOGGING_FORMAT)")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file, enables TLS with --tls-key (SERVER_TLS_CERT_FILE)")
+	tlsKey := fs.String("tls-key", "", "TLS key file, enables TLS with --tls-cert (SERVER_TLS_KEY_FILE)")
+	nodeID := fs.String("node-id", "", "identifier of this instance in a cluster (CLUSTER_NODE_ID)")
+
+	settings := map[string]func(cfg *config.Config){
+		"port":       func(cfg *config.Config) { cfg.Server.Port = *port },
+		"host":       func(cfg *config.Config) { cfg.Server.Host = *host },
+		"log-level":  func(cfg *config.Config) { cfg.Logging.Level = *logLevel },
+		"log-format": func(cfg *config.Config) { cfg.Logging.Format = *logFormat },
+		"tls-cert": func(cfg *config.Config) {
+			cfg.Server.TLS.Enabled = true
+			cfg.Server.TLS.CertFile = *tlsCert
+		},
+		"tls-key": func(cfg *config.Config) {
+			cfg.Server.TLS.Enabled = true
+			cfg.Server.TLS.KeyFile = *tlsKey
+		},
+		"node-id": func(cfg *config.Config) { cfg.Cluster.NodeID = *nodeID },
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := settings[f.Name]; ok {
+			opts.overrides = append(opts.overrides, apply)
+		}
+	})
+	return opts, nil
+}
+
+// apply overrides the configuration with the flags that were set
+func (o *options) apply(cfg *config.Config) {
+	for _, override := range o.overrides {
+		override(cfg)
+	}
+}
+
+// loadConfig loads the configuration, overridden by the flags, and validates it
+func (o *options) loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(o.configPath)
+	if err != nil {
+		return nil, err
+	}
+	o.apply(cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// printEffectiveConfig writes the configuration resolved from the defaults,
+// the config file, the environment and the flags as YAML to out, with the
+// secrets redacted. It is written even if invalid, returning the problems
+// Validate finds, which include the malformed environment variables.
+func (o *options) printEffectiveConfig(out io.Writer) error {
+	cfg, err := config.LoadConfig(o.configPath)
+	if err != nil {
+		return err
+	}
+	o.apply(cfg)
+
+	if _, err := out.Write(cfg.Redacted().YAML()); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
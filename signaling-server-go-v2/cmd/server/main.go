@@ -2,7 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,36 +16,116 @@ import (
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/grpc"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/handlers/health"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/router/chi"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/gorilla"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/audit"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/cluster"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/events"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/geoip"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/ice"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/breaker"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/debug"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging/kitlog"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging/otellog"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging/zerolog"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing/otel"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/roomstore"
 )
 
 func main() {
-	// Get the configuration path from environment variables
-	configPath := config.GetConfigPath()
+	// Parse the command-line flags, which override the environment and the config file
+	opts, err := parseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid arguments: %v\n", err)
+		os.Exit(2)
+	}
+	if opts.version {
+		fmt.Printf("signaling-server %s\n", version)
+		return
+	}
+	if opts.printConfig {
+		if err := opts.printEffectiveConfig(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Load the configuration
-	cfg, err := config.LoadConfig(configPath)
+	cfg, err := opts.loadConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
 		os.Exit(1)
 	}
+	if opts.migrate {
+		if err := runMigrations(cfg, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Open the configured log outputs
+	logOutput, err := logging.NewOutput(cfg.Logging)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open log outputs: %v\n", err)
+		os.Exit(1)
+	}
+	defer logOutput.Close()
 
-	// Initialize logger
-	logger, err := kitlog.NewKitLogger(cfg.Logging)
+	// Initialize logger; the loggers of the pipeline share the level, which may change on reload
+	logLevel := logging.NewLevel(cfg.Logging.Level)
+	var baseLogger logging.Logger
+	if cfg.Logging.Backend == "zerolog" {
+		baseLogger, err = zerolog.NewZerologLogger(cfg.Logging, zerolog.WithOutput(logOutput), zerolog.WithLevel(logLevel))
+	} else {
+		baseLogger, err = kitlog.NewKitLogger(cfg.Logging, kitlog.WithOutput(logOutput), kitlog.WithLevel(logLevel))
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Exporters run behind circuit breakers so unreachable backends cannot slow the server down
+	exportCooldown := time.Duration(cfg.Exporters.Cooldown) * time.Second
+	logBreaker := breaker.New("otlp_logs", cfg.Exporters.FailureThreshold, exportCooldown)
+	traceBreaker := breaker.New("otlp_traces", cfg.Exporters.FailureThreshold, exportCooldown)
+	eventsBreaker := breaker.New("kafka_events", cfg.Exporters.FailureThreshold, exportCooldown)
+	warehouseBreaker := breaker.New("warehouse", cfg.Exporters.FailureThreshold, exportCooldown)
+
+	// Export logs through the OTLP pipeline shared with traces
+	if cfg.Logging.OTLP.Enabled {
+		logExporter := otellog.NewBufferedExporter(otellog.NewOTLPExporter(cfg.Logging, cfg.Tracing), logBreaker, cfg.Exporters.BufferSize)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			logExporter.Shutdown(ctx)
+		}()
+		baseLogger = otellog.NewLogger(baseLogger, logExporter, logLevel)
+	}
+
+	// Keep recent log entries in memory for debug bundles
+	logRecorder := logging.NewRecorder(baseLogger, 1000, logLevel)
+
+	// Redact personal data and credentials before entries reach the recorder or any output
+	logger := logging.NewRedactor(logRecorder, cfg.Logging.Redaction)
+
+	// Sample the entries of high-volume messages first, so that dropped entries cost nothing more
+	logger = logging.NewSampler(logger, cfg.Logging.Sampling)
+
 	// Set the default logger instance
 	logging.SetDefaultLogger(logger)
 
-	logger.Info("Starting signaling server", "version", "1.0.0")
+	logger.Info("Starting signaling server", "version", version, "profile", cfg.Profile())
 
 	// Initialize tracer
 	logger.Info("Initializing tracer")
@@ -60,29 +146,547 @@ func main() {
 		}()
 	}
 
-	tracer, err := otel.NewOTelTracer(cfg.Tracing)
+	tracer, err := otel.NewOTelTracer(cfg.Tracing, otel.WithExportBreaker(traceBreaker, cfg.Exporters.BufferSize))
 	if err != nil {
 		logger.Error("Failed to create tracer", "error", err)
 		os.Exit(1)
 	}
+	if t, ok := tracer.(*otel.OTelTracer); ok {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := t.Shutdown(ctx); err != nil {
+				logger.Error("Failed to export buffered spans", "error", err)
+			}
+		}()
+	}
+
+	// Keep long-lived connections from idling out behind the fronting proxy
+	for _, adjustment := range cfg.FitProxyIdleTimeout() {
+		logger.Warn("Adjusted keepalive for reverse proxy", "adjustment", adjustment)
+	}
 
 	// Initialize metrics
 	logger.Info("Initializing metrics")
 	m := metrics.NewMetrics(cfg.Metrics)
 
+	// Keep an audit trail of room lifecycle events and admin operations apart from the logs
+	var auditLog *audit.Log
+	if cfg.Audit.Enabled {
+		auditLog, err = audit.Open(cfg.Audit, audit.WithDropHandler(func(count int) {
+			m.EventsDropped("audit", count)
+		}))
+		if err != nil {
+			logger.Error("Failed to open audit log", "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := auditLog.Close(ctx); err != nil {
+				logger.Error("Failed to close audit log", "error", err)
+			}
+		}()
+	}
+
 	// Create router
 	router := chi.NewChiRouter()
 
+	// Route client messages to the signaling manager and remove clients whose
+	// connection dropped from their rooms
+	var signalingManager *protocol.SignalingManager
+	var wsHandler websocket.WebSocketHandler
+	wsOpts := []gorilla.Option{
+		gorilla.WithConnectHandler(func(clientID string, r *http.Request) {
+			if auditLog != nil {
+				auditLog.Connected(clientID, remoteHost(r))
+			}
+			// A client ID taken from a certificate already identifies the client
+			if _, ok := websocket.CertificateClientID(r); ok && cfg.Server.TLS.ClientIDFromCert {
+				return
+			}
+			// Bans follow the client's address across reconnects
+			signalingManager.SetClientIdentity(clientID, remoteHost(r))
+		}),
+		gorilla.WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			return signalingManager.ProcessMessageContext(ctx, message, clientID, wsHandler.SendMessage)
+		}),
+		gorilla.WithDisconnectHandler(func(clientID string) {
+			signalingManager.RemoveClient(clientID)
+			if auditLog != nil {
+				auditLog.Disconnected(clientID)
+			}
+		}),
+	}
+
+	// Identify authenticated clients by their principal; identifying them by
+	// certificate subject with SERVER_TLS_CLIENT_ID_FROM_CERT tries it first
+	identitySources := cfg.WebSocket.IdentitySources
+	if cfg.Server.TLS.ClientIDFromCert {
+		identitySources = append([]string{websocket.IdentityCertificate}, identitySources...)
+	}
+	if len(identitySources) > 0 {
+		identifier := websocket.NewIdentifier(identitySources, cfg.WebSocket.IdentityTokenSecret, cfg.WebSocket.IdentityAPIKeys, time.Now)
+		wsOpts = append(wsOpts, gorilla.WithIdentity(identifier.Identify, cfg.WebSocket.IdentityConflict))
+	}
+
+	// Partition rooms by the tenant of each connection, from its token claim
+	// or the /ws/{tenant} path
+	if len(cfg.WebSocket.TenantSources) > 0 {
+		identifier := websocket.NewIdentifier(nil, cfg.WebSocket.IdentityTokenSecret, nil, time.Now)
+		wsOpts = append(wsOpts, gorilla.WithTenants(websocket.TenantResolver(cfg.WebSocket.TenantSources, identifier, cfg.WebSocket.Path)))
+	}
+
+	// storePings check the Redis stores, for the stores readiness gate
+	var storePings []func(context.Context) error
+
+	// Let disconnected clients resume their sessions within the grace period
+	var sessions *websocket.Sessions
+	sessionGrace := time.Duration(cfg.WebSocket.SessionGracePeriod) * time.Second
+	if sessionGrace > 0 {
+		// Session tokens are single-use; reject replays of a token on any
+		// instance rather than only on the one that consumed it
+		var sessionOpts []websocket.SessionOption
+		switch cfg.WebSocket.ReplayCacheBackend {
+		case "", "memory":
+		case "redis":
+			nonces := cluster.NewRedisNonceCache(cfg.Cluster.Redis)
+			storePings = append(storePings, nonces.Ping)
+			sessionOpts = append(sessionOpts, websocket.WithNonceCache(nonces))
+		default:
+			logger.Error("Unknown replay cache backend", "backend", cfg.WebSocket.ReplayCacheBackend)
+			os.Exit(1)
+		}
+		sessions = websocket.NewSessions(sessionGrace, cfg.WebSocket.SessionQueueSize, sessionOpts...)
+		wsOpts = append(wsOpts, gorilla.WithSessions(sessions))
+	}
+
+	// Locate connections for logs, metrics and the per-ASN cap; signaling
+	// works without the databases, so a failure only disables the enrichment
+	if cfg.GeoIP.Enabled {
+		resolver, err := geoip.NewResolver(cfg.GeoIP, logger)
+		if err != nil {
+			logger.Error("Failed to open GeoIP databases, connections will not be located", "error", err)
+		} else {
+			geoCtx, stopGeo := context.WithCancel(context.Background())
+			defer stopGeo()
+			go resolver.Run(geoCtx, time.Duration(cfg.GeoIP.ReloadInterval)*time.Second)
+			wsOpts = append(wsOpts, gorilla.WithGeoIP(resolver.Lookup))
+		}
+	}
+
+	// Enforce message rate limits across the cluster, allowing messages when
+	// Redis is slow or unreachable rather than stalling every client
+	switch cfg.WebSocket.RateLimitBackend {
+	case "", "memory":
+	case "redis":
+		wsConfig := websocket.NewWebSocketConfig(cfg.WebSocket)
+		failOpen := func(limiter websocket.Limiter) websocket.Limiter {
+			return websocket.FailOpen(limiter, wsConfig.RateLimitBudget, func(key string, err error) {
+				logger.Debug("Rate limit check failed open", "error", err, "key", key)
+				m.WebSocketError("rate_limit_fail_open")
+			})
+		}
+		var clientLimiter, ipLimiter websocket.Limiter
+		if wsConfig.MessageRate > 0 {
+			limiter := cluster.NewRedisLimiter(cfg.Cluster.Redis, "message", wsConfig.MessageRate, wsConfig.MessageBurst)
+			storePings = append(storePings, limiter.Ping)
+			clientLimiter = failOpen(limiter)
+		}
+		if wsConfig.IPMessageRate > 0 {
+			limiter := cluster.NewRedisLimiter(cfg.Cluster.Redis, "ip-message", wsConfig.IPMessageRate, wsConfig.IPMessageBurst)
+			storePings = append(storePings, limiter.Ping)
+			ipLimiter = failOpen(limiter)
+		}
+		wsOpts = append(wsOpts, gorilla.WithRateLimiters(clientLimiter, ipLimiter))
+	default:
+		logger.Error("Unknown rate limit backend", "backend", cfg.WebSocket.RateLimitBackend)
+		os.Exit(1)
+	}
+
+	// Let high-message-rate clients negotiate binary frames instead of JSON
+	if len(cfg.WebSocket.Encodings) > 0 {
+		codecs := make([]protocol.Codec, 0, len(cfg.WebSocket.Encodings))
+		for _, encoding := range cfg.WebSocket.Encodings {
+			codec, err := protocol.NewCodec(encoding)
+			if err != nil {
+				logger.Error("Invalid WebSocket encoding", "error", err)
+				os.Exit(1)
+			}
+			codecs = append(codecs, codec)
+		}
+		wsOpts = append(wsOpts, gorilla.WithCodecs(codecs...))
+	}
+
+	// Compress large messages such as SDP for clients offering permessage-deflate
+	if compression := websocket.NewWebSocketConfig(cfg.WebSocket).Compression; compression.Enabled && !compression.ValidLevel() {
+		logger.Error("Invalid WebSocket compression level", "level", compression.Level)
+		os.Exit(1)
+	}
+
+	// Re-check connected clients so that permissions revoked after connect,
+	// e.g. a user banned in the identity provider, take effect
+	if cfg.WebSocket.ReauthorizeURL != "" {
+		authorizer := websocket.NewHTTPAuthorizer(cfg.WebSocket.ReauthorizeURL, time.Duration(cfg.WebSocket.ReauthorizeTimeout)*time.Second)
+		wsOpts = append(wsOpts, gorilla.WithReauthorization(authorizer,
+			func(clientID string) []string { return signalingManager.ClientRooms(clientID) },
+			func(clientID string, rooms []string, reason string) {
+				signalingManager.RevokeRooms(clientID, rooms, reason)
+			},
+		), gorilla.WithObserverRestriction(func(clientID string, rooms []string) {
+			signalingManager.RestrictToObserver(clientID, rooms)
+		}))
+	}
+
+	// Stamp the messages written to clients of the envelope protocol version
+	// with the server time, their sequence number and the node serving them
+	timestamps, err := protocol.NewTimestampFormat(cfg.Signaling.EnvelopeTimestampFormat)
+	if err != nil {
+		logger.Error("Invalid envelope timestamp format", "error", err)
+		os.Exit(1)
+	}
+	serverID := cfg.Cluster.NodeID
+	if serverID == "" {
+		serverID, _ = os.Hostname()
+	}
+	wsOpts = append(wsOpts, gorilla.WithEnvelopes(protocol.NewStamper(serverID, timestamps, time.Now), func(clientID string) bool {
+		return signalingManager.ReceivesEnvelopes(clientID)
+	}))
+
 	// Create WebSocket handler
-	wsHandler := gorilla.NewHandler(cfg.WebSocket, logger, m, tracer)
+	wsHandler = gorilla.NewHandler(cfg.WebSocket, logger, m, tracer, wsOpts...)
+
+	managerOpts := []protocol.ManagerOption{
+		protocol.WithMetrics(m),
+		protocol.WithTracer(tracer),
+		protocol.WithConnections(wsHandler),
+		protocol.WithBanDuration(time.Duration(cfg.Signaling.BanDuration) * time.Second),
+		protocol.WithClosedRoomRetention(time.Duration(cfg.Signaling.ClosedRoomRetention) * time.Second),
+	}
+
+	// Validate and sanitize the SDP of offers and answers before relaying them
+	if sdp := cfg.Signaling.SDP; sdp.Validate {
+		managerOpts = append(managerOpts, protocol.WithSDPPolicy(protocol.SDPPolicy{
+			MaxSize:         sdp.MaxSize,
+			AllowedCodecs:   sdp.AllowedCodecs,
+			DeniedCodecs:    sdp.DeniedCodecs,
+			StripAttributes: sdp.StripAttributes,
+		}))
+	}
+
+	// Tell clients still using deprecated protocol features to migrate
+	if len(cfg.Signaling.Deprecations) > 0 {
+		deprecations := make([]protocol.Deprecation, 0, len(cfg.Signaling.Deprecations))
+		for _, deprecation := range cfg.Signaling.Deprecations {
+			deprecations = append(deprecations, protocol.Deprecation{
+				MessageType: protocol.MessageType(deprecation.MessageType),
+				Field:       deprecation.Field,
+				Message:     deprecation.Message,
+			})
+		}
+		managerOpts = append(managerOpts, protocol.WithDeprecations(deprecations, time.Duration(cfg.Signaling.DeprecationNoticeInterval)*time.Second))
+	}
+
+	// Start with room creation disabled, as during an incident; the admin API switches it at runtime
+	if cfg.Signaling.RoomCreationDisabled {
+		managerOpts = append(managerOpts, protocol.WithRoomCreationDisabled(cfg.Signaling.RoomCreationDisabledMessage))
+	}
+
+	// Expose per-room pseudonyms instead of client IDs to other peers
+	if cfg.Signaling.ObfuscatePeerIDs {
+		key := []byte(cfg.Signaling.PeerIDSecret)
+		if len(key) == 0 {
+			if cfg.Cluster.Backend != "" {
+				logger.Warn("Peer IDs obfuscated without SIGNALING_PEER_ID_SECRET, peers on other instances cannot be addressed")
+			}
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				logger.Error("Failed to generate peer ID key", "error", err)
+				os.Exit(1)
+			}
+		}
+		managerOpts = append(managerOpts, protocol.WithPeerIDObfuscator(protocol.NewHMACPeerIDs(key)))
+	}
+
+	// Absorb join storms of large rooms: pace joins and batch the notifications of peers
+	if cfg.Signaling.JoinRate > 0 {
+		managerOpts = append(managerOpts, protocol.WithJoinPacing(cfg.Signaling.JoinRate, cfg.Signaling.JoinBurst))
+	}
+	managerOpts = append(managerOpts, protocol.WithJoinBatchWindow(time.Duration(cfg.Signaling.JoinBatchWindow)*time.Millisecond))
+
+	// Keep the display names of the peers of each room unique
+	nameCollision, err := protocol.ParseDisplayNameCollision(cfg.Signaling.DisplayNameCollision)
+	if err != nil {
+		logger.Error("Invalid display name collision policy", "error", err)
+		os.Exit(1)
+	}
+	managerOpts = append(managerOpts, protocol.WithDisplayNameCollision(nameCollision))
+
+	// Warn the peers of rooms with a time limit before they close
+	closeWarnings := make([]time.Duration, 0, len(cfg.Signaling.RoomCloseWarnings))
+	for _, warning := range cfg.Signaling.RoomCloseWarnings {
+		closeWarnings = append(closeWarnings, time.Duration(warning)*time.Second)
+	}
+	managerOpts = append(managerOpts, protocol.WithRoomCloseWarnings(closeWarnings))
+
+	// Score calls from the quality reports of clients, posting degraded rooms to the webhook
+	if cfg.Quality.Enabled {
+		var handler protocol.QualityHandler
+		if cfg.Quality.WebhookURL != "" {
+			handler = events.NewQualityWebhook(cfg.Quality.WebhookURL, logger).Notify
+		}
+		managerOpts = append(managerOpts, protocol.WithCallQuality(cfg.Quality.Threshold, handler))
+	}
+
+	// Hand out the ICE servers with each join, saving clients a round trip to /ice-config
+	if iceProvider := ice.NewProvider(cfg.ICE); iceProvider.Enabled() {
+		managerOpts = append(managerOpts, protocol.WithICEServers(iceProvider.Servers))
+	}
+
+	// Share rooms with other instances, delivering their relays to local clients
+	transport, err := cluster.NewTransport(cfg.Cluster)
+	if err != nil {
+		logger.Error("Failed to create cluster transport", "error", err)
+		os.Exit(1)
+	}
+	var backend *cluster.Backend
+	if transport != nil {
+		// Rooms shared with other instances are read-only while the bus is unreachable
+		backendOpts := []cluster.Option{
+			cluster.WithNodeID(cfg.Cluster.NodeID),
+			cluster.WithChannelPrefix(cluster.ChannelPrefix(cfg.Cluster)),
+			cluster.WithPartitionHandler(func(roomIDs []string, degraded bool) {
+				signalingManager.SetDegraded(roomIDs, degraded)
+			}),
+			cluster.WithMetrics(m),
+		}
+
+		// Prefer duplicates, which clients ignore by their ID, over losing
+		// relays such as SDP answers to another instance
+		if cfg.Cluster.AtLeastOnce {
+			backendOpts = append(backendOpts, cluster.WithAtLeastOnce(time.Duration(cfg.Cluster.RelayRetryInterval)*time.Millisecond, cfg.Cluster.RelayMaxAttempts))
+			managerOpts = append(managerOpts, protocol.WithRelayIDs())
+		}
+
+		// Route events through the owner of each room rather than to every
+		// instance with peers in it
+		membership, err := cluster.NewMembership(cfg.Cluster)
+		if err != nil {
+			logger.Error("Failed to create cluster membership", "error", err)
+			os.Exit(1)
+		}
+		if membership != nil {
+			backendOpts = append(backendOpts, cluster.WithSharding(membership, cfg.Cluster.Sharding.Replicas))
+		}
+		backend = cluster.NewBackend(transport, wsHandler.SendMessage, logger, backendOpts...)
+		defer func() {
+			if err := backend.Close(); err != nil {
+				logger.Error("Failed to close cluster backend", "error", err)
+			}
+		}()
+		managerOpts = append(managerOpts, protocol.WithRoomBackend(backend))
+		logger.Info("Sharing rooms through cluster backend", "backend", cfg.Cluster.Backend, "node_id", backend.NodeID(), "sharding", cfg.Cluster.Sharding.Enabled)
+	}
+
+	// Export signaling activity for analytics
+	if cfg.Events.Kafka.Enabled {
+		producer := events.NewKafkaProducer(cfg.Events.Kafka, eventsBreaker, cfg.Exporters.BufferSize, m)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			producer.Close(ctx)
+		}()
+		managerOpts = append(managerOpts, protocol.WithActivitySink(producer))
+	}
+	if cfg.Events.Webhooks.Enabled {
+		webhooks := events.NewWebhooks(cfg.Events.Webhooks, cfg.Exporters, m, logger)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			webhooks.Close(ctx)
+		}()
+		managerOpts = append(managerOpts, protocol.WithActivitySink(webhooks))
+	}
+	var roomWebhooks *events.RoomWebhooks
+	if cfg.Events.RoomWebhooks.Enabled {
+		store, err := roomstore.OpenWebhookStore(cfg.Events.RoomWebhooks.StorePath, migrateOptions(cfg.Migrations)...)
+		if err != nil {
+			logger.Error("Failed to open webhook store", "error", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		roomWebhooks, err = events.NewRoomWebhooks(store, cfg.Events.Webhooks, cfg.Exporters, m, logger)
+		if err != nil {
+			logger.Error("Failed to load room webhooks", "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			roomWebhooks.Close(ctx)
+		}()
+		managerOpts = append(managerOpts, protocol.WithActivitySink(roomWebhooks))
+	}
+	if cfg.Events.Warehouse.Enabled {
+		writer, err := events.NewWarehouseWriter(cfg.Events.Warehouse)
+		if err != nil {
+			logger.Error("Failed to create warehouse writer", "error", err)
+			os.Exit(1)
+		}
+		outbox, err := roomstore.OpenOutboxStore(cfg.Events.Warehouse.OutboxPath, migrateOptions(cfg.Migrations)...)
+		if err != nil {
+			logger.Error("Failed to open warehouse outbox", "error", err)
+			os.Exit(1)
+		}
+		defer outbox.Close()
+		warehouse := events.NewWarehouse(cfg.Events.Warehouse, outbox, writer, warehouseBreaker, cfg.Exporters.BufferSize, m, logger)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			warehouse.Close(ctx)
+		}()
+		managerOpts = append(managerOpts, protocol.WithActivitySink(warehouse))
+	}
+	if auditLog != nil {
+		managerOpts = append(managerOpts, protocol.WithActivitySink(auditLog))
+	}
+
+	// Bound resident memory by spilling cold rooms to disk
+	if cfg.Signaling.MaxResidentRooms > 0 {
+		store, err := roomstore.OpenBolt(cfg.Signaling.SpillPath)
+		if err != nil {
+			logger.Error("Failed to open room store", "error", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		managerOpts = append(managerOpts, protocol.WithRoomStore(store, cfg.Signaling.MaxResidentRooms))
+	}
+
+	// Create signaling manager
+	signalingManager = protocol.NewSignalingManager(logger, managerOpts...)
+
+	// Apply namespace policies from the configuration
+	signalingManager.SetNamespacePolicies(namespacePolicies(cfg.Signaling.NamespacePolicies))
+
+	// Reload the log level, rate limits, allowed origins and room caps without
+	// dropping connections, on SIGHUP or through the admin API
+	reloadSettings := []config.Setting{
+		{
+			Name:  "logging.level",
+			Value: func(cfg *config.Config) interface{} { return cfg.Logging.Level },
+			Apply: func(cfg *config.Config) error { return logLevel.Set(cfg.Logging.Level) },
+		},
+		{
+			Name:  "signaling.namespacePolicies",
+			Value: func(cfg *config.Config) interface{} { return cfg.Signaling.NamespacePolicies },
+			Apply: func(cfg *config.Config) error {
+				signalingManager.SetNamespacePolicies(namespacePolicies(cfg.Signaling.NamespacePolicies))
+				return nil
+			},
+		},
+	}
+	if reconfigurer, ok := wsHandler.(websocket.Reconfigurer); ok {
+		reloadSettings = append(reloadSettings, config.Setting{
+			Name: "websocket.rateLimits",
+			Value: func(cfg *config.Config) interface{} {
+				return map[string]int{
+					"messageRateLimit":   cfg.WebSocket.MessageRateLimit,
+					"messageRateBurst":   cfg.WebSocket.MessageRateBurst,
+					"ipMessageRateLimit": cfg.WebSocket.IPMessageRateLimit,
+					"ipMessageRateBurst": cfg.WebSocket.IPMessageRateBurst,
+				}
+			},
+			Apply: func(cfg *config.Config) error {
+				wsConfig := websocket.NewWebSocketConfig(cfg.WebSocket)
+				reconfigurer.SetRateLimits(wsConfig.MessageRate, wsConfig.MessageBurst, wsConfig.IPMessageRate, wsConfig.IPMessageBurst)
+				return nil
+			},
+		}, config.Setting{
+			Name:  "websocket.allowedOrigins",
+			Value: func(cfg *config.Config) interface{} { return cfg.WebSocket.AllowedOrigins },
+			Apply: func(cfg *config.Config) error {
+				reconfigurer.SetAllowedOrigins(cfg.WebSocket.AllowedOrigins)
+				return nil
+			},
+		})
+	}
+	reloader := config.NewReloader(opts.loadConfig, cfg, reloadSettings...)
+
+	// Report live occupancy on each scrape
+	m.ObserveOccupancy(signalingManager.Occupancy)
+	if reporter, ok := wsHandler.(websocket.StatsReporter); ok {
+		m.ObserveClientsConnected(func() int {
+			return reporter.Stats().Connections
+		})
+	}
+	if reporter, ok := wsHandler.(websocket.QueueReporter); ok {
+		m.ObserveSendQueueDepths(reporter.QueueDepths)
+	}
+
+	// Expire idle rooms in the background
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	defer stopJanitor()
+	go signalingManager.RunJanitor(janitorCtx,
+		time.Duration(cfg.Signaling.RoomIdleTTL)*time.Second,
+		time.Duration(cfg.Signaling.JanitorInterval)*time.Second,
+	)
+
+	// Detect partitions from the cluster bus, reconciling membership once it is reachable again
+	if backend != nil {
+		go backend.Run(janitorCtx, time.Duration(cfg.Cluster.HealthCheckInterval)*time.Second)
+	}
+
+	// Remove clients whose sessions expired from their rooms
+	if sessions != nil {
+		go sessions.Run(janitorCtx, sessionGrace, func(clientID string) {
+			signalingManager.RemoveClient(clientID)
+		})
+	}
+
+	// Keep the instance out of rotation until its warm-up tasks complete
+	serverOpts := readinessGates(janitorCtx, cfg.Monitoring, storePings, backend, logger)
 
 	// Create server
-	server := api.NewServer(cfg, router, logger, m, tracer, wsHandler)
+	server := api.NewServer(cfg, router, logger, m, tracer, wsHandler, append(serverOpts,
+		api.WithSignalingManager(signalingManager),
+		api.WithLogRecorder(logRecorder),
+		api.WithAuditLog(auditLog),
+		api.WithConfigReloader(reloader),
+		api.WithRoomWebhooks(roomWebhooks),
+		api.WithHealthCheck("exporters", func() (health.Status, string) {
+			if degraded, message := breaker.Summary(logBreaker, traceBreaker, eventsBreaker, warehouseBreaker); degraded {
+				return health.StatusDegraded, message
+			}
+			return health.StatusUp, ""
+		}),
+		api.WithHealthCheck("cluster", func() (health.Status, string) {
+			if backend != nil && backend.Degraded() {
+				return health.StatusDegraded, "cluster bus unreachable, shared rooms are read-only"
+			}
+			return health.StatusUp, ""
+		}),
+	)...)
 
 	// Handle signals for graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	// Reload the configuration on SIGHUP
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			changes, err := reloader.Reload()
+			if err != nil {
+				logger.Error("Failed to reload configuration", "error", err)
+			}
+			logger.Info("Reloaded configuration", "changes", len(changes))
+			if auditLog != nil {
+				auditLog.ConfigReload("signal", "", changes)
+			}
+		}
+	}()
+
 	// Start the server in a goroutine
 	logger.Info("Starting server")
 	go func() {
@@ -92,6 +696,38 @@ func main() {
 		}
 	}()
 
+	// Serve native and backend clients preferring gRPC, sharing the WebSocket clients' registry
+	var grpcServer *grpc.Server
+	if cfg.GRPC.Enabled {
+		attacher, ok := wsHandler.(websocket.Attacher)
+		if !ok {
+			logger.Error("WebSocket handler cannot attach gRPC streams")
+			os.Exit(1)
+		}
+		grpcServer = grpc.NewServer(fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.GRPC.Port), attacher, logger)
+		go func() {
+			if err := grpcServer.Start(); err != nil {
+				logger.Error("gRPC server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Serve runtime diagnostics on their own listener, off the public port by default
+	var debugServer *debug.Server
+	if cfg.Debug.Enabled {
+		host := "127.0.0.1"
+		if !cfg.Debug.LocalhostOnly {
+			host = cfg.Server.Host
+		}
+		debugServer = debug.NewServer(fmt.Sprintf("%s:%d", host, cfg.Debug.Port), logger)
+		go func() {
+			if err := debugServer.Start(); err != nil {
+				logger.Error("Debug server error", "error", err)
+			}
+		}()
+	}
+
 	// Wait for signal
 	sig := <-sigCh
 	logger.Info("Received signal", "signal", sig.String())
@@ -101,6 +737,16 @@ func main() {
 	defer cancel()
 
 	// Perform graceful shutdown
+	if grpcServer != nil {
+		if err := grpcServer.Shutdown(ctx); err != nil {
+			logger.Error("Failed to shutdown gRPC server gracefully", "error", err)
+		}
+	}
+	if debugServer != nil {
+		if err := debugServer.Shutdown(ctx); err != nil {
+			logger.Error("Failed to shutdown debug server", "error", err)
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Error("Failed to shutdown server gracefully", "error", err)
 		os.Exit(1)
@@ -108,3 +754,106 @@ func main() {
 
 	logger.Info("Server stopped")
 }
+
+// namespacePolicies converts the namespace policies of the configuration,
+// keyed by namespace
+func namespacePolicies(policies []config.NamespacePolicyConfig) map[string]protocol.NamespacePolicy {
+	converted := make(map[string]protocol.NamespacePolicy, len(policies))
+	for _, policy := range policies {
+		converted[policy.Namespace] = protocol.NamespacePolicy{
+			MaxPeers:        policy.MaxPeers,
+			RequirePassword: policy.RequirePassword,
+			MaxDuration:     time.Duration(policy.MaxDuration) * time.Second,
+			ObserverJoins:   policy.ObserverJoins,
+		}
+	}
+	return converted
+}
+
+// remoteHost returns the host of a request's remote address
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// readinessGates starts the warm-up tasks of the configured readiness gates,
+// returning the options keeping the readiness endpoint DOWN until they complete
+func readinessGates(ctx context.Context, cfg config.MonitoringConfig, storePings []func(context.Context) error, backend *cluster.Backend, logger logging.Logger) []api.Option {
+	interval := time.Duration(cfg.WarmUpInterval) * time.Millisecond
+
+	var opts []api.Option
+	for _, name := range cfg.ReadinessGates {
+		gate := health.NewGate(name)
+		opts = append(opts, api.WithReadinessGate(gate))
+
+		var task func() error
+		switch name {
+		case "stores":
+			task = func() error {
+				pingCtx, cancel := context.WithTimeout(ctx, interval)
+				defer cancel()
+				for _, ping := range storePings {
+					if err := ping(pingCtx); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		case "cluster":
+			// Checking the bus reconciles the rooms shared with other instances
+			task = func() error {
+				if backend != nil && !backend.Check() {
+					return errors.New("cluster bus unreachable")
+				}
+				return nil
+			}
+		}
+
+		go func() {
+			if gate.WarmUp(ctx, interval, task) {
+				logger.Info("Warm-up complete", "gate", gate.Name())
+			}
+		}()
+	}
+	return opts
+}
+
+// migrateOptions returns how the persistent stores are migrated when opened
+func migrateOptions(cfg config.MigrationsConfig) []roomstore.MigrateOption {
+	opts := []roomstore.MigrateOption{
+		roomstore.WithMigrationLockTimeout(time.Duration(cfg.LockTimeout) * time.Second),
+	}
+	if cfg.Manual {
+		opts = append(opts, roomstore.WithManualMigrations())
+	}
+	return opts
+}
+
+// runMigrations applies the pending migrations of the configured persistent
+// stores, reporting them to out
+func runMigrations(cfg *config.Config, out io.Writer) error {
+	if !cfg.Events.RoomWebhooks.Enabled && !cfg.Events.Warehouse.Enabled {
+		fmt.Fprintln(out, "No persistent stores are configured")
+		return nil
+	}
+
+	lockTimeout := time.Duration(cfg.Migrations.LockTimeout) * time.Second
+	if cfg.Events.RoomWebhooks.Enabled {
+		applied, err := roomstore.MigrateWebhookStore(cfg.Events.RoomWebhooks.StorePath, roomstore.WithMigrationLockTimeout(lockTimeout))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Webhook store %s: %d migrations applied\n", cfg.Events.RoomWebhooks.StorePath, applied)
+	}
+	if cfg.Events.Warehouse.Enabled {
+		applied, err := roomstore.MigrateOutboxStore(cfg.Events.Warehouse.OutboxPath, roomstore.WithMigrationLockTimeout(lockTimeout))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Warehouse outbox %s: %d migrations applied\n", cfg.Events.Warehouse.OutboxPath, applied)
+	}
+	return nil
+}
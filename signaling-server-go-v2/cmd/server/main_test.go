@@ -1,7 +1,9 @@
 package main
 
 import (
+	"io"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -73,4 +75,72 @@ func TestSignalHandling(t *testing.T) {
 	if !shutdownCalled {
 		t.Error("Expected shutdown to be called")
 	}
-}
\ No newline at end of file
+}
+func TestParseFlags(t *testing.T) {
+	os.Setenv("SERVER_PORT", "9000")
+	os.Setenv("LOGGING_LEVEL", "warn")
+	defer os.Unsetenv("SERVER_PORT")
+	defer os.Unsetenv("LOGGING_LEVEL")
+
+	opts, err := parseFlags([]string{"--port", "7000", "--config=custom.yaml", "-node-id", "edge-1"}, io.Discard)
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if opts.configPath != "custom.yaml" || opts.version {
+		t.Errorf("Expected the config path to be set, got %+v", opts)
+	}
+
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Server.Port != 7000 || cfg.Cluster.NodeID != "edge-1" {
+		t.Errorf("Expected the flags to override the environment, got port %d and node %q", cfg.Server.Port, cfg.Cluster.NodeID)
+	}
+	if cfg.Logging.Level != "warn" {
+		t.Errorf("Expected unset flags to keep the environment, got log level %q", cfg.Logging.Level)
+	}
+
+	// Overridden settings are validated
+	opts, _ = parseFlags([]string{"--tls-cert", "server.crt"}, io.Discard)
+	if _, err := opts.loadConfig(); err == nil || !strings.Contains(err.Error(), "SERVER_TLS_KEY_FILE") {
+		t.Errorf("Expected a TLS certificate without key to be rejected, got %v", err)
+	}
+
+	if opts, _ := parseFlags([]string{"--version"}, io.Discard); !opts.version {
+		t.Error("Expected --version to be parsed")
+	}
+	if _, err := parseFlags([]string{"--port", "http"}, io.Discard); err == nil {
+		t.Error("Expected a malformed flag to be rejected")
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", "s3cret")
+	os.Setenv("SERVER_PORT", "80a")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	defer os.Unsetenv("SERVER_PORT")
+
+	opts, err := parseFlags([]string{"config", "print", "--log-level", "debug"}, io.Discard)
+	if err != nil || !opts.printConfig {
+		t.Fatalf("Expected the config print subcommand to be parsed, got %+v, %v", opts, err)
+	}
+
+	var out strings.Builder
+	err = opts.printEffectiveConfig(&out)
+	if err == nil || !strings.Contains(err.Error(), `SERVER_PORT: "80a" is not an integer`) {
+		t.Errorf("Expected the malformed port to be reported, got %v", err)
+	}
+	for _, fragment := range []string{"  port: 8080\n", "  level: \"debug\"\n", "  token: \"[REDACTED]\"\n"} {
+		if !strings.Contains(out.String(), fragment) {
+			t.Errorf("Expected the configuration to contain %q, got:\n%s", fragment, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Error("Expected the secrets to be redacted")
+	}
+
+	if opts, _ := parseFlags([]string{"--print-config"}, io.Discard); !opts.printConfig {
+		t.Error("Expected --print-config to be parsed")
+	}
+}
@@ -16,6 +16,281 @@ type Config struct {
 	Tracing    TracingConfig    `mapstructure:"tracing"`
 	WebSocket  WebSocketConfig  `mapstructure:"websocket"`
 	Monitoring MonitoringConfig `mapstructure:"monitoring"`
+	Signaling  SignalingConfig  `mapstructure:"signaling"`
+	Admin      AdminConfig      `mapstructure:"admin"`
+	Exporters  ExportersConfig  `mapstructure:"exporters"`
+	Cluster    ClusterConfig    `mapstructure:"cluster"`
+	Events     EventsConfig     `mapstructure:"events"`
+	Audit      AuditConfig      `mapstructure:"audit"`
+	ICE        ICEConfig        `mapstructure:"ice"`
+	GeoIP      GeoIPConfig      `mapstructure:"geoip"`
+	GRPC       GRPCConfig       `mapstructure:"grpc"`
+	SSE        SSEConfig        `mapstructure:"sse"`
+	LongPoll   LongPollConfig   `mapstructure:"longPoll"`
+	Quality    QualityConfig    `mapstructure:"quality"`
+	CORS       CORSConfig       `mapstructure:"cors"`
+	Debug      DebugConfig      `mapstructure:"debug"`
+	Migrations MigrationsConfig `mapstructure:"migrations"`
+
+	// problems holds the environment variables that could not be parsed
+	problems []string
+
+	// profile is the config profile selected by APP_ENV, "" without one
+	profile string
+}
+
+// Profile returns the config profile selected by APP_ENV, "" without one
+func (c *Config) Profile() string {
+	return c.profile
+}
+
+// DebugConfig holds the listener of the runtime diagnostics: pprof profiles,
+// expvar variables and goroutine dumps. Anyone reaching it can profile the
+// process, so it is bound to localhost unless LocalhostOnly is unset, in
+// which case it listens on the server host.
+type DebugConfig struct {
+	Enabled       bool `mapstructure:"enabled"`
+	Port          int  `mapstructure:"port"`
+	LocalhostOnly bool `mapstructure:"localhostOnly"`
+}
+
+// MigrationsConfig holds how the schema of the stores kept across restarts,
+// such as the room webhook store, is migrated. Replicas starting at once
+// take turns under a lock on the store, so each migration is applied once.
+type MigrationsConfig struct {
+	// Manual refuses to start with pending migrations rather than applying
+	// them, for environments running them with the -migrate flag
+	Manual      bool `mapstructure:"manual"`
+	LockTimeout int  `mapstructure:"lockTimeout"` // in seconds to wait for another replica migrating
+}
+
+// CORSConfig holds the cross-origin resource sharing policy of the HTTP
+// endpoints, for browser apps fetching /ice-config or calling the admin API
+// from another origin. WebSocket upgrades are checked against
+// WebSocketConfig.AllowedOrigins instead.
+type CORSConfig struct {
+	Enabled          bool     `mapstructure:"enabled"`
+	AllowedOrigins   []string `mapstructure:"allowedOrigins"` // e.g. https://app.example.com, https://*.example.com or *
+	AllowedMethods   []string `mapstructure:"allowedMethods"`
+	AllowedHeaders   []string `mapstructure:"allowedHeaders"` // * allows any request header
+	MaxAge           int      `mapstructure:"maxAge"`         // in seconds browsers cache preflight results
+	AllowCredentials bool     `mapstructure:"allowCredentials"`
+}
+
+// QualityConfig holds the scoring of calls from the quality reports of
+// clients. Rooms whose score falls below Threshold are degraded, which is
+// counted in the metrics and posted to WebhookURL.
+type QualityConfig struct {
+	Enabled    bool    `mapstructure:"enabled"`
+	Threshold  float64 `mapstructure:"threshold"`  // mean opinion score from 1 to 4.5
+	WebhookURL string  `mapstructure:"webhookURL"` // empty for no webhook
+}
+
+// LongPollConfig holds the HTTP long-polling transport, for restrictive
+// networks where neither WebSocket nor event streams get through
+type LongPollConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	PollPath    string `mapstructure:"pollPath"`
+	SendPath    string `mapstructure:"sendPath"`
+	PollTimeout int    `mapstructure:"pollTimeout"` // in seconds a poll waits for messages
+	IdleTimeout int    `mapstructure:"idleTimeout"` // in seconds without a poll before a session is closed
+}
+
+// SSEConfig holds the Server-Sent Events transport, a fallback for clients
+// behind proxies that strip WebSocket upgrades: messages are received as
+// events of a GET request and sent as POST requests to the same path
+type SSEConfig struct {
+	Enabled           bool   `mapstructure:"enabled"`
+	Path              string `mapstructure:"path"`
+	KeepaliveInterval int    `mapstructure:"keepaliveInterval"` // in seconds between comments sent on idle streams, 0 disables them
+}
+
+// GRPCConfig holds the gRPC signaling service, served on its own port for
+// clients preferring a gRPC stream over WebSocket
+type GRPCConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	Port    int  `mapstructure:"port"` // listened on at the server host
+}
+
+// GeoIPConfig holds the MaxMind databases locating the remote IPs of
+// connections by country and autonomous system
+type GeoIPConfig struct {
+	Enabled             bool   `mapstructure:"enabled"`
+	CountryDatabasePath string `mapstructure:"countryDatabasePath"` // GeoIP2/GeoLite2 Country or City, empty to skip
+	ASNDatabasePath     string `mapstructure:"asnDatabasePath"`     // GeoLite2 ASN, empty to skip
+	ReloadInterval      int    `mapstructure:"reloadInterval"`      // in seconds between checks for replaced files, 0 disables reload
+}
+
+// ICEConfig holds the STUN and TURN servers served to clients at /ice-config
+type ICEConfig struct {
+	STUNURLs []string `mapstructure:"stunURLs"`
+	TURNURLs []string `mapstructure:"turnURLs"`
+
+	// TURNSecret is shared with the TURN servers, which verify the issued
+	// credentials with it; TURN servers are not served without it
+	TURNSecret    string `mapstructure:"turnSecret"`
+	CredentialTTL int    `mapstructure:"credentialTTL"` // in seconds the TURN credentials are valid
+}
+
+// EventsConfig holds the export of signaling activity events to analytics pipelines
+type EventsConfig struct {
+	Kafka    KafkaConfig    `mapstructure:"kafka"`
+	Webhooks WebhooksConfig `mapstructure:"webhooks"`
+
+	// RoomWebhooks are webhooks registered through the admin API for the
+	// rooms matching a pattern, retried like the global webhooks
+	RoomWebhooks RoomWebhooksConfig `mapstructure:"roomWebhooks"`
+
+	// Warehouse ships room, peer and message count rows to an analytics
+	// warehouse on a schedule, through an outbox kept on disk
+	Warehouse WarehouseConfig `mapstructure:"warehouse"`
+}
+
+// KafkaConfig holds the producer exporting join, leave and relay events to a
+// Kafka topic. Buffering and circuit breaking follow the exporters settings.
+type KafkaConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	Brokers      []string `mapstructure:"brokers"`
+	Topic        string   `mapstructure:"topic"`
+	BatchSize    int      `mapstructure:"batchSize"`    // events produced per request
+	Retries      int      `mapstructure:"retries"`      // attempts after a failed produce before dropping the batch
+	RetryBackoff int      `mapstructure:"retryBackoff"` // in milliseconds, multiplied by the attempt
+}
+
+// WebhooksConfig holds the webhooks posting room created, emptied and closed
+// and peer joined and left events to URLs, signed with the secret. Buffering
+// and circuit breaking follow the exporters settings, per URL.
+type WebhooksConfig struct {
+	Enabled         bool     `mapstructure:"enabled"`
+	URLs            []string `mapstructure:"urls"`
+	Secret          string   `mapstructure:"secret"`          // key of the HMAC-SHA256 signatures, unsigned if empty
+	Events          []string `mapstructure:"events"`          // events posted, all if empty
+	Retries         int      `mapstructure:"retries"`         // attempts after a failed delivery before dropping the event
+	RetryBackoff    int      `mapstructure:"retryBackoff"`    // in milliseconds, doubled by each retry
+	MaxRetryBackoff int      `mapstructure:"maxRetryBackoff"` // in milliseconds
+}
+
+// WarehouseConfig holds the export of room and peer events and per-room
+// message counts to an analytics warehouse. Rows are staged in the outbox
+// file and shipped in batches every interval, so an unreachable warehouse
+// delays rows rather than losing them.
+type WarehouseConfig struct {
+	Enabled    bool             `mapstructure:"enabled"`
+	Backend    string           `mapstructure:"backend"`    // "clickhouse", "bigquery" or "s3"
+	Interval   int              `mapstructure:"interval"`   // in seconds between exports, also the window of message counts
+	BatchSize  int              `mapstructure:"batchSize"`  // rows shipped per request
+	OutboxPath string           `mapstructure:"outboxPath"` // BoltDB file staging the rows until shipped
+	ClickHouse ClickHouseConfig `mapstructure:"clickhouse"`
+	BigQuery   BigQueryConfig   `mapstructure:"bigquery"`
+	S3         S3Config         `mapstructure:"s3"`
+}
+
+// ClickHouseConfig holds the table rows are inserted into over the ClickHouse HTTP interface
+type ClickHouseConfig struct {
+	URL      string `mapstructure:"url"`
+	Table    string `mapstructure:"table"` // database.table
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// BigQueryConfig holds the table rows are streamed into
+type BigQueryConfig struct {
+	Project         string `mapstructure:"project"`
+	Dataset         string `mapstructure:"dataset"`
+	Table           string `mapstructure:"table"`
+	CredentialsFile string `mapstructure:"credentialsFile"` // service account key, application default credentials if empty
+}
+
+// S3Config holds the bucket rows are written to as Parquet files
+type S3Config struct {
+	Bucket string `mapstructure:"bucket"`
+	Prefix string `mapstructure:"prefix"` // files are written under <prefix>/dt=<date>/
+	Region string `mapstructure:"region"`
+}
+
+// RoomWebhooksConfig holds the registrations of room webhooks, kept across
+// restarts in a BoltDB file
+type RoomWebhooksConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	StorePath string `mapstructure:"storePath"`
+}
+
+// AuditConfig holds the audit log, a trail of joins, leaves, kicks, bans and
+// admin operations written as JSON lines to a file of its own, rotated apart
+// from the application logs
+type AuditConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	File       LogFileConfig `mapstructure:"file"`
+	BufferSize int           `mapstructure:"bufferSize"` // records queued for writing, beyond which records are dropped
+}
+
+// ClusterConfig holds the backend that shares rooms between server instances,
+// so that peers of a room may be connected to different instances
+type ClusterConfig struct {
+	Backend string      `mapstructure:"backend"` // "" for a single instance, "redis" or "nats"
+	NodeID  string      `mapstructure:"nodeID"`  // identifies this instance, defaults to the host name
+	Redis   RedisConfig `mapstructure:"redis"`
+	NATS    NATSConfig  `mapstructure:"nats"`
+
+	// HealthCheckInterval is how often the bus is pinged, in seconds. Rooms
+	// shared with other instances are read-only while it is unreachable.
+	HealthCheckInterval int `mapstructure:"healthCheckInterval"`
+
+	// AtLeastOnce publishes the relays to peers on other instances again
+	// every RelayRetryInterval until the instance of the peer acknowledges
+	// them, up to RelayMaxAttempts times, and sets an idempotency key on
+	// relayed messages. Peers may receive a message more than once rather
+	// than not at all.
+	AtLeastOnce        bool `mapstructure:"atLeastOnce"`
+	RelayRetryInterval int  `mapstructure:"relayRetryInterval"` // in milliseconds
+	RelayMaxAttempts   int  `mapstructure:"relayMaxAttempts"`
+
+	// Sharding gives each room an owner instance, which keeps the room's
+	// authoritative membership, so that events only reach the instances
+	// they concern instead of every instance with peers in the room
+	Sharding ShardingConfig `mapstructure:"sharding"`
+}
+
+// ShardingConfig holds the consistent hash ring assigning each room an owner
+// instance, built from the live instances listed by the membership backend
+type ShardingConfig struct {
+	Enabled    bool             `mapstructure:"enabled"`
+	Membership string           `mapstructure:"membership"` // "redis" or "memberlist"
+	Replicas   int              `mapstructure:"replicas"`   // points per instance on the ring
+	MemberTTL  int              `mapstructure:"memberTTL"`  // in seconds an instance stays listed without renewing
+	Memberlist MemberlistConfig `mapstructure:"memberlist"`
+}
+
+// MemberlistConfig holds the gossip listener of the memberlist membership
+type MemberlistConfig struct {
+	BindAddress string   `mapstructure:"bindAddress"`
+	Seeds       []string `mapstructure:"seeds"` // addresses of instances to join the cluster through
+}
+
+// RedisConfig holds the Redis connection used by the redis cluster backend
+type RedisConfig struct {
+	Address       string `mapstructure:"address"`
+	Password      string `mapstructure:"password"`
+	DB            int    `mapstructure:"db"`
+	ChannelPrefix string `mapstructure:"channelPrefix"` // prefix of the per-room pub/sub channels
+}
+
+// NATSConfig holds the NATS connection used by the nats cluster backend
+type NATSConfig struct {
+	URL           string `mapstructure:"url"` // comma-separated server URLs
+	Token         string `mapstructure:"token"`
+	SubjectPrefix string `mapstructure:"subjectPrefix"` // prefix of the per-room subjects
+	ReconnectWait int    `mapstructure:"reconnectWait"` // in seconds between reconnect attempts
+	MaxReconnects int    `mapstructure:"maxReconnects"` // -1 retries forever
+}
+
+// ExportersConfig holds the buffering and circuit breaking applied to the
+// trace and log exporters, so that unreachable backends do not slow down
+// message processing
+type ExportersConfig struct {
+	BufferSize       int `mapstructure:"bufferSize"`       // items buffered per exporter, dropped when full
+	FailureThreshold int `mapstructure:"failureThreshold"` // consecutive failures that open the breaker
+	Cooldown         int `mapstructure:"cooldown"`         // in seconds before an open breaker retries
 }
 
 // ServerConfig holds HTTP server related configuration
@@ -26,6 +301,38 @@ type ServerConfig struct {
 	ReadTimeout     int    `mapstructure:"readTimeout"`     // in seconds
 	WriteTimeout    int    `mapstructure:"writeTimeout"`    // in seconds
 	IdleTimeout     int    `mapstructure:"idleTimeout"`     // in seconds
+
+	// TrustedProxies are the CIDRs or IPs of the reverse proxies whose
+	// Forwarded, X-Forwarded-For and X-Real-IP headers are believed when
+	// resolving client IPs. Empty ignores the headers.
+	TrustedProxies []string `mapstructure:"trustedProxies"`
+
+	// ProxyIdleTimeout is the idle timeout of the fronting proxy, e.g. 60 for
+	// nginx's proxy_read_timeout or an AWS load balancer. The keepalive
+	// intervals of WebSocket, event stream and long-polling connections are
+	// lowered to fit under it. 0 if there is no proxy.
+	ProxyIdleTimeout int `mapstructure:"proxyIdleTimeout"` // in seconds
+
+	TLS TLSConfig `mapstructure:"tls"`
+}
+
+// TLSConfig holds the TLS listener configuration. With TLS enabled the
+// signaling endpoint is served over wss://.
+type TLSConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	CertFile     string   `mapstructure:"certFile"`
+	KeyFile      string   `mapstructure:"keyFile"`
+	MinVersion   string   `mapstructure:"minVersion"`   // 1.2 or 1.3
+	CipherSuites []string `mapstructure:"cipherSuites"` // Go cipher suite names for TLS 1.2, empty for Go's defaults
+	HSTSMaxAge   int      `mapstructure:"hstsMaxAge"`   // in seconds, 0 disables the Strict-Transport-Security header
+
+	// ClientAuth is none, optional or require; client certificates are
+	// verified against the CA bundle in ClientCAFile
+	ClientAuth   string `mapstructure:"clientAuth"`
+	ClientCAFile string `mapstructure:"clientCAFile"`
+
+	// ClientIDFromCert uses the subject of a verified client certificate as the signaling client ID
+	ClientIDFromCert bool `mapstructure:"clientIDFromCert"`
 }
 
 // LoggingConfig holds logging related configuration
@@ -33,12 +340,71 @@ type LoggingConfig struct {
 	Level      string `mapstructure:"level"`
 	Format     string `mapstructure:"format"`
 	TimeFormat string `mapstructure:"timeFormat"`
+
+	// Backend is the logger implementation: "kit", or "zerolog" for JSON
+	// lines with few allocations on the message path
+	Backend string `mapstructure:"backend"`
+
+	// Sampling logs one in N debug and info entries of each message, e.g.
+	// {"Message relayed": 100}; warnings and errors are always logged
+	Sampling map[string]int `mapstructure:"sampling"`
+
+	// Outputs lists the sinks logs are written to: stdout, file and syslog
+	Outputs []string      `mapstructure:"outputs"`
+	File    LogFileConfig `mapstructure:"file"`
+	Syslog  SyslogConfig  `mapstructure:"syslog"`
+
+	Redaction RedactionConfig `mapstructure:"redaction"`
+
+	OTLP LogOTLPConfig `mapstructure:"otlp"`
+}
+
+// LogOTLPConfig holds configuration of the OpenTelemetry log export, which
+// sends logs in addition to the outputs
+type LogOTLPConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Endpoint string `mapstructure:"endpoint"` // collector endpoint, empty for the tracing endpoint
+}
+
+// RedactionConfig holds the rules applied to log fields before they reach any
+// output. Field patterns are matched case-insensitively against field keys
+// with path.Match syntax, e.g. "*token*".
+type RedactionConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	ScrubFields []string `mapstructure:"scrubFields"` // values replaced entirely, e.g. credentials
+	IPFields    []string `mapstructure:"ipFields"`    // IP addresses truncated to their network prefix
+	MaskEmails  bool     `mapstructure:"maskEmails"`  // mask email addresses in any string field
+}
+
+// LogFileConfig holds configuration of the rotating log file output
+type LogFileConfig struct {
+	Path       string `mapstructure:"path"`
+	MaxSizeMB  int    `mapstructure:"maxSizeMB"`  // rotate once the file exceeds this size
+	MaxAgeDays int    `mapstructure:"maxAgeDays"` // remove rotated files older than this, 0 keeps them
+	MaxBackups int    `mapstructure:"maxBackups"` // rotated files to keep, 0 keeps all
+
+	RotateHours int  `mapstructure:"rotateHours"` // also rotate once the file is this old, 0 rotates by size only
+	Compress    bool `mapstructure:"compress"`    // gzip rotated files
+}
+
+// SyslogConfig holds configuration of the syslog output. On systemd hosts the
+// local syslog socket is read by journald.
+type SyslogConfig struct {
+	Network string `mapstructure:"network"` // udp or tcp, empty for the local syslog socket
+	Address string `mapstructure:"address"`
+	Tag     string `mapstructure:"tag"`
 }
 
 // MetricsConfig holds Prometheus metrics related configuration
 type MetricsConfig struct {
 	Enabled bool   `mapstructure:"enabled"`
 	Path    string `mapstructure:"path"`
+
+	// Token and AllowedCIDRs restrict scraping to requests bearing the token
+	// and coming from an allowed network. Each check applies only when
+	// configured; with neither, the endpoint is open.
+	Token        string   `mapstructure:"token"`
+	AllowedCIDRs []string `mapstructure:"allowedCIDRs"`
 }
 
 // TracingConfig holds OpenTelemetry tracing related configuration
@@ -52,62 +418,613 @@ type TracingConfig struct {
 // WebSocketConfig holds WebSocket related configuration
 type WebSocketConfig struct {
 	Path           string `mapstructure:"path"`
-	PingInterval   int    `mapstructure:"pingInterval"`    // in seconds
-	PongWait       int    `mapstructure:"pongWait"`        // in seconds
-	WriteWait      int    `mapstructure:"writeWait"`       // in seconds
-	MaxMessageSize int64  `mapstructure:"maxMessageSize"`  // in bytes
+	PingInterval   int    `mapstructure:"pingInterval"`   // in seconds, 0 disables keepalive
+	PongWait       int    `mapstructure:"pongWait"`       // in seconds, connections silent for longer are reaped
+	WriteWait      int    `mapstructure:"writeWait"`      // in seconds
+	MaxMessageSize int64  `mapstructure:"maxMessageSize"` // in bytes
+
+	// MessageRateLimit is the number of messages per second a client may send,
+	// with bursts of up to MessageRateBurst. Clients over the limit are warned,
+	// then disconnected. 0 disables the limit.
+	MessageRateLimit int `mapstructure:"messageRateLimit"`
+	MessageRateBurst int `mapstructure:"messageRateBurst"`
+
+	// IPMessageRateLimit is the number of messages per second the clients of
+	// one remote IP may send together, with bursts of up to IPMessageRateBurst.
+	// 0 disables the limit.
+	IPMessageRateLimit int `mapstructure:"ipMessageRateLimit"`
+	IPMessageRateBurst int `mapstructure:"ipMessageRateBurst"`
+
+	// RateLimitBackend stores the rate limits: "memory" limits each instance
+	// separately, "redis" enforces the limits across the cluster using the
+	// cluster's Redis server
+	RateLimitBackend string `mapstructure:"rateLimitBackend"`
+	RateLimitBudget  int    `mapstructure:"rateLimitBudget"` // in milliseconds, slower checks allow the message
+
+	// MaxConnectionsPerIP caps the concurrent connections from one remote IP, 0 for no cap
+	MaxConnectionsPerIP int `mapstructure:"maxConnectionsPerIP"`
+
+	// MaxConnectionsPerASN caps the concurrent connections from one autonomous
+	// system, 0 for no cap. It requires the GeoIP ASN database.
+	MaxConnectionsPerASN int `mapstructure:"maxConnectionsPerASN"`
+
+	// AllowedOrigins lists the browser origins allowed to connect: exact
+	// origins, wildcard subdomains such as "https://*.example.com", or "*".
+	// Empty allows same-origin requests only.
+	AllowedOrigins []string `mapstructure:"allowedOrigins"`
+
+	// SessionGracePeriod is how long a disconnected client may resume its session, 0 disables resume
+	SessionGracePeriod int `mapstructure:"sessionGracePeriod"` // in seconds
+	SessionQueueSize   int `mapstructure:"sessionQueueSize"`   // messages queued per disconnected session
+
+	// ReplayCacheBackend stores the consumed one-time tokens, such as session
+	// tokens: "memory" rejects replays on the instance that saw the token,
+	// "redis" rejects them across the cluster using the cluster's Redis server
+	ReplayCacheBackend string `mapstructure:"replayCacheBackend"`
+
+	// Encodings lists the binary message encodings clients may negotiate
+	// with the Sec-WebSocket-Protocol header: "protobuf" and "msgpack". Clients negotiating
+	// none exchange JSON text frames.
+	Encodings []string `mapstructure:"encodings"`
+
+	// ReauthorizeURL is the authorization service asked every
+	// ReauthorizeInterval whether connected clients keep their permissions,
+	// empty to authorize clients only on connect
+	ReauthorizeURL      string `mapstructure:"reauthorizeURL"`
+	ReauthorizeInterval int    `mapstructure:"reauthorizeInterval"` // in seconds, 0 disables reauthorization
+	ReauthorizeTimeout  int    `mapstructure:"reauthorizeTimeout"`  // in seconds
+
+	// EnableCompression negotiates permessage-deflate with clients offering
+	// it. Messages of at least CompressionThreshold bytes are compressed at
+	// CompressionLevel, from -2 (Huffman only) to 9 (best compression), unless
+	// CompressionThresholds sets a threshold for their message type; negative
+	// thresholds never compress.
+	EnableCompression     bool           `mapstructure:"enableCompression"`
+	CompressionLevel      int            `mapstructure:"compressionLevel"`
+	CompressionThreshold  int            `mapstructure:"compressionThreshold"`  // in bytes
+	CompressionThresholds map[string]int `mapstructure:"compressionThresholds"` // in bytes by message type
+
+	// ServerNoContextTakeover and ClientNoContextTakeover reset the compression
+	// context after each message, saving the memory of a context per connection
+	ServerNoContextTakeover bool `mapstructure:"serverNoContextTakeover"`
+	ClientNoContextTakeover bool `mapstructure:"clientNoContextTakeover"`
+
+	// LowPowerPingInterval and LowPowerPongWait replace PingInterval and
+	// PongWait for clients declaring the low power mode on connect, bounding
+	// how far battery-sensitive clients may relax the keepalive. 0 disables
+	// the low power mode.
+	LowPowerPingInterval int `mapstructure:"lowPowerPingInterval"` // in seconds
+	LowPowerPongWait     int `mapstructure:"lowPowerPongWait"`     // in seconds
+
+	// SendLatencySampling times one in SendLatencySampling messages sent to
+	// clients from their enqueue to their flush, 0 disables the timing
+	SendLatencySampling int `mapstructure:"sendLatencySampling"`
+
+	// SendBufferSize is the number of messages queued for each client, and
+	// SendMemoryBudget bounds the bytes queued for all clients together. When
+	// either is exhausted, BackpressurePolicy sheds load: "disconnect" closes
+	// the slowest client, "drop" drops the message.
+	SendBufferSize     int    `mapstructure:"sendBufferSize"`
+	SendMemoryBudget   int64  `mapstructure:"sendMemoryBudget"` // in bytes, 0 for no budget
+	BackpressurePolicy string `mapstructure:"backpressurePolicy"`
+
+	// IdentitySources derive the client ID from the principal authenticated
+	// on the upgrade request, tried in order: "certificate" is the subject of
+	// the client certificate, "token" the sub claim of a bearer token signed
+	// with IdentityTokenSecret (HS256), "apiKey" the owner of the X-API-Key
+	// header among the owner:key entries of IdentityAPIKeys. Clients none of
+	// them identifies get random IDs. IdentityConflict applies when an
+	// identity connects twice: "replace" closes the previous connection,
+	// "reject" refuses the new one, "multiDevice" keeps both.
+	IdentitySources     []string `mapstructure:"identitySources"`
+	IdentityTokenSecret string   `mapstructure:"identityTokenSecret"`
+	IdentityAPIKeys     []string `mapstructure:"identityAPIKeys"`
+	IdentityConflict    string   `mapstructure:"identityConflict"`
+
+	// TenantSources partition rooms by tenant, taking the tenant of a
+	// connection from the first source naming one: "claim" is the tenant
+	// claim of a bearer token signed with IdentityTokenSecret, "path" the
+	// segment following Path, as in /ws/{tenant}, or the tenant query
+	// parameter. Connections without a tenant are rejected. Empty disables
+	// tenancy.
+	TenantSources []string `mapstructure:"tenantSources"`
+
+	// TenantMessageRateLimit is the number of messages per second the
+	// clients of one tenant may send together, with bursts of up to
+	// TenantMessageRateBurst. 0 disables the limit.
+	TenantMessageRateLimit int `mapstructure:"tenantMessageRateLimit"`
+	TenantMessageRateBurst int `mapstructure:"tenantMessageRateBurst"`
 }
 
 // MonitoringConfig holds health checking related configuration
 type MonitoringConfig struct {
 	LivenessPath  string `mapstructure:"livenessPath"`
 	ReadinessPath string `mapstructure:"readinessPath"`
+
+	// ReadinessGates are the warm-up tasks the readiness endpoint waits for:
+	// "stores" until the Redis stores are reachable and "cluster" until the
+	// rooms of the other instances are synced
+	ReadinessGates []string `mapstructure:"readinessGates"`
+	// WarmUpInterval is how often a warm-up task that failed is retried
+	WarmUpInterval int `mapstructure:"warmUpInterval"` // in milliseconds
+}
+
+// SignalingConfig holds signaling room management related configuration
+type SignalingConfig struct {
+	// RoomIdleTTL expires rooms without joins or relays. A call in progress sends
+	// no signaling once negotiated, so it must exceed the longest expected call.
+	RoomIdleTTL     int `mapstructure:"roomIdleTTL"`     // in seconds, 0 disables expiry
+	JanitorInterval int `mapstructure:"janitorInterval"` // in seconds, also prunes expired bans
+	BanDuration     int `mapstructure:"banDuration"`     // in seconds
+
+	// ClosedRoomRetention keeps rooms closed by an operator restorable
+	ClosedRoomRetention int `mapstructure:"closedRoomRetention"` // in seconds, 0 forgets them at once
+
+	// MaxResidentRooms bounds the rooms held in memory; the janitor spills the
+	// least recently active rooms beyond it to the file at SpillPath
+	MaxResidentRooms int    `mapstructure:"maxResidentRooms"` // 0 keeps all rooms in memory
+	SpillPath        string `mapstructure:"spillPath"`
+
+	// NamespacePolicies are applied to the rooms of each namespace and its descendants
+	NamespacePolicies []NamespacePolicyConfig `mapstructure:"namespacePolicies"`
+
+	// RoomCloseWarnings are how long before their time limit the peers of
+	// rooms are warned that they close
+	RoomCloseWarnings []int `mapstructure:"roomCloseWarnings"` // in seconds
+
+	// SDP restricts the session descriptions relayed in offers and answers
+	SDP SDPConfig `mapstructure:"sdp"`
+
+	// Deprecations lists the deprecated message types and fields; clients
+	// using them are notified once per DeprecationNoticeInterval
+	Deprecations              []DeprecationConfig `mapstructure:"deprecations"`
+	DeprecationNoticeInterval int                 `mapstructure:"deprecationNoticeInterval"` // in seconds
+
+	// RoomCreationDisabled rejects joins to new rooms with
+	// RoomCreationDisabledMessage while existing calls continue. It can be
+	// switched at runtime through the admin API.
+	RoomCreationDisabled        bool   `mapstructure:"roomCreationDisabled"`
+	RoomCreationDisabledMessage string `mapstructure:"roomCreationDisabledMessage"`
+
+	// ObfuscatePeerIDs exposes per-room pseudonyms instead of client IDs in
+	// peer lists and relayed messages. The pseudonyms are keyed with
+	// PeerIDSecret, which instances of a cluster must share.
+	ObfuscatePeerIDs bool   `mapstructure:"obfuscatePeerIDs"`
+	PeerIDSecret     string `mapstructure:"peerIDSecret"` // empty for a random key per process
+
+	// JoinRate paces the joins of new peers to each room at JoinRate per
+	// second, with bursts of up to JoinBurst; joins beyond it are rejected
+	// and retried by the client. 0 disables pacing.
+	JoinRate  float64 `mapstructure:"joinRate"`
+	JoinBurst int     `mapstructure:"joinBurst"`
+
+	// JoinBatchWindow collects the joins to a room before notifying its
+	// peers, so that a room many clients join at once sends a notification
+	// per window rather than per join
+	JoinBatchWindow int `mapstructure:"joinBatchWindow"` // in milliseconds, 0 notifies each join at once
+
+	// DisplayNameCollision resolves a display name another peer of the room
+	// holds: "suffix" gives the peer the name with the lowest free suffix,
+	// e.g. "Alice (2)", "reject" rejects the join or rename
+	DisplayNameCollision string `mapstructure:"displayNameCollision"`
+
+	// EnvelopeTimestampFormat serializes the server timestamp of the
+	// envelope metadata of messages sent to clients of protocol version 3:
+	// "rfc3339" for RFC 3339 strings, "unix_ms" for Unix milliseconds
+	EnvelopeTimestampFormat string `mapstructure:"envelopeTimestampFormat"`
+}
+
+// DeprecationConfig marks a message type, or a field of messages, as deprecated
+type DeprecationConfig struct {
+	MessageType string `mapstructure:"messageType"` // empty for messages of any type
+	Field       string `mapstructure:"field"`       // e.g. "password" or "payload.metadata", empty to deprecate the type
+	Message     string `mapstructure:"message"`     // what clients should use instead
 }
 
-// LoadConfig loads the configuration from environment variables and returns defaults for missing values
+// SDPConfig holds the validation and sanitization of relayed SDP
+type SDPConfig struct {
+	Validate        bool     `mapstructure:"validate"`        // reject malformed SDP and apply the lists below
+	MaxSize         int      `mapstructure:"maxSize"`         // in bytes, 0 for no limit
+	AllowedCodecs   []string `mapstructure:"allowedCodecs"`   // rtpmap encoding names, empty allows all
+	DeniedCodecs    []string `mapstructure:"deniedCodecs"`    // removed from the media descriptions
+	StripAttributes []string `mapstructure:"stripAttributes"` // attribute names removed from the SDP
+}
+
+// NamespacePolicyConfig holds the policy of a room namespace
+type NamespacePolicyConfig struct {
+	Namespace       string `mapstructure:"namespace"`
+	MaxPeers        int    `mapstructure:"maxPeers"` // 0 means unlimited
+	RequirePassword bool   `mapstructure:"requirePassword"`
+	MaxDuration     int    `mapstructure:"maxDuration"`   // in seconds after their creation rooms close, 0 means unlimited
+	ObserverJoins   bool   `mapstructure:"observerJoins"` // peers joining after the room creator are observers
+}
+
+// AdminConfig holds administrative API related configuration
+type AdminConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	PathPrefix string `mapstructure:"pathPrefix"`
+	Token      string `mapstructure:"token"` // bearer token, admin routes are not served without one
+
+	// TenantTokens are tenant:token entries of bearer tokens scoped to the
+	// rooms and clients of one tenant, accepted by the room and tenant
+	// endpoints besides Token
+	TenantTokens []string `mapstructure:"tenantTokens"`
+}
+
+// LoadConfig loads the configuration from environment variables and returns defaults for missing values.
+// The config file may be YAML, TOML or JSON, detected by its extension.
 func LoadConfig(configPath string) (*Config, error) {
-	// Create a default configuration
-	cfg := &Config{
+	var format string
+	if configPath != "" {
+		var err error
+		if format, err = FileFormat(configPath); err != nil {
+			return nil, err
+		}
+	}
+	profile, err := configProfile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &environment{}
+	cfg := newConfig(env)
+	cfg.problems = env.problems
+	cfg.profile = profile
+
+	// JSON and TOML files are decoded by the mapstructure tags, with the
+	// overlay of the profile merged in, the environment variables overriding
+	// both.
+	// In a real implementation, we would parse YAML files too, with
+	// viper.SetConfigType(format) and the overlay merged with
+	// viper.MergeInConfig.
+	switch format {
+	case "":
+		fmt.Fprintln(os.Stderr, "No config file found. Using environment variables and defaults.")
+	case FormatJSON, FormatTOML:
+		values, err := readConfigFile(configPath, format)
+		if err != nil {
+			return nil, err
+		}
+		if profile != "" {
+			overlay, err := readConfigFile(ProfilePath(configPath, profile), format)
+			if err != nil {
+				return nil, err
+			}
+			mergeSettings(values, overlay)
+		}
+		if cfg, err = withConfigFile(cfg, values); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", configPath, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// newConfig returns the defaults of the configuration, overridden by the
+// environment variables
+func newConfig(env *environment) *Config {
+	return &Config{
 		Server: ServerConfig{
-			Port:            getEnvInt("SERVER_PORT", 8080),
-			Host:            getEnvString("SERVER_HOST", "0.0.0.0"),
-			ShutdownTimeout: getEnvInt("SERVER_SHUTDOWN_TIMEOUT", 30),
-			ReadTimeout:     getEnvInt("SERVER_READ_TIMEOUT", 15),
-			WriteTimeout:    getEnvInt("SERVER_WRITE_TIMEOUT", 15),
-			IdleTimeout:     getEnvInt("SERVER_IDLE_TIMEOUT", 60),
+			Port:            env.Int("SERVER_PORT", 8080),
+			Host:            env.String("SERVER_HOST", "0.0.0.0"),
+			ShutdownTimeout: env.Int("SERVER_SHUTDOWN_TIMEOUT", 30),
+			ReadTimeout:     env.Int("SERVER_READ_TIMEOUT", 15),
+			WriteTimeout:    env.Int("SERVER_WRITE_TIMEOUT", 15),
+			IdleTimeout:     env.Int("SERVER_IDLE_TIMEOUT", 60),
+
+			TrustedProxies:   env.StringSlice("SERVER_TRUSTED_PROXIES", nil),
+			ProxyIdleTimeout: env.Int("SERVER_PROXY_IDLE_TIMEOUT", 0),
+			TLS: TLSConfig{
+				Enabled:      env.Bool("SERVER_TLS_ENABLED", false),
+				CertFile:     env.String("SERVER_TLS_CERT_FILE", ""),
+				KeyFile:      env.String("SERVER_TLS_KEY_FILE", ""),
+				MinVersion:   env.String("SERVER_TLS_MIN_VERSION", "1.2"),
+				CipherSuites: env.StringSlice("SERVER_TLS_CIPHER_SUITES", nil),
+				HSTSMaxAge:   env.Int("SERVER_TLS_HSTS_MAX_AGE", 31536000), // 1 year
+
+				ClientAuth:       env.String("SERVER_TLS_CLIENT_AUTH", "none"),
+				ClientCAFile:     env.String("SERVER_TLS_CLIENT_CA_FILE", ""),
+				ClientIDFromCert: env.Bool("SERVER_TLS_CLIENT_ID_FROM_CERT", false),
+			},
 		},
 		Logging: LoggingConfig{
-			Level:      getEnvString("LOGGING_LEVEL", "info"),
-			Format:     getEnvString("LOGGING_FORMAT", "json"),
-			TimeFormat: getEnvString("LOGGING_TIME_FORMAT", "RFC3339"),
+			Level:      env.String("LOGGING_LEVEL", "info"),
+			Format:     env.String("LOGGING_FORMAT", "json"),
+			TimeFormat: env.String("LOGGING_TIME_FORMAT", "RFC3339"),
+			Backend:    env.String("LOGGING_BACKEND", "kit"),
+			Sampling:   env.IntMap("LOGGING_SAMPLING"),
+			Outputs:    env.StringSlice("LOGGING_OUTPUTS", []string{"stdout"}),
+			File: LogFileConfig{
+				Path:       env.String("LOGGING_FILE_PATH", "signaling-server.log"),
+				MaxSizeMB:  env.Int("LOGGING_FILE_MAX_SIZE_MB", 100),
+				MaxAgeDays: env.Int("LOGGING_FILE_MAX_AGE_DAYS", 7),
+				MaxBackups: env.Int("LOGGING_FILE_MAX_BACKUPS", 5),
+
+				RotateHours: env.Int("LOGGING_FILE_ROTATE_HOURS", 0),
+				Compress:    env.Bool("LOGGING_FILE_COMPRESS", false),
+			},
+			Syslog: SyslogConfig{
+				Network: env.String("LOGGING_SYSLOG_NETWORK", ""),
+				Address: env.String("LOGGING_SYSLOG_ADDRESS", ""),
+				Tag:     env.String("LOGGING_SYSLOG_TAG", "signaling-server"),
+			},
+			Redaction: RedactionConfig{
+				Enabled:     env.Bool("LOGGING_REDACTION_ENABLED", true),
+				ScrubFields: env.StringSlice("LOGGING_REDACTION_SCRUB_FIELDS", []string{"*token*", "*secret*", "*password*", "authorization", "cookie"}),
+				IPFields:    env.StringSlice("LOGGING_REDACTION_IP_FIELDS", []string{"remote_addr", "addr", "ip", "*_ip"}),
+				MaskEmails:  env.Bool("LOGGING_REDACTION_MASK_EMAILS", true),
+			},
+			OTLP: LogOTLPConfig{
+				Enabled:  env.Bool("LOGGING_OTLP_ENABLED", false),
+				Endpoint: env.String("LOGGING_OTLP_ENDPOINT", ""),
+			},
 		},
 		Metrics: MetricsConfig{
-			Enabled: getEnvBool("METRICS_ENABLED", true),
-			Path:    getEnvString("METRICS_PATH", "/metrics"),
+			Enabled:      env.Bool("METRICS_ENABLED", true),
+			Path:         env.String("METRICS_PATH", "/metrics"),
+			Token:        env.String("METRICS_TOKEN", ""),
+			AllowedCIDRs: env.StringSlice("METRICS_ALLOWED_CIDRS", nil),
 		},
 		Tracing: TracingConfig{
-			Enabled:     getEnvBool("TRACING_ENABLED", true),
-			Exporter:    getEnvString("TRACING_EXPORTER", "otlp"),
-			Endpoint:    getEnvString("TRACING_ENDPOINT", "localhost:4317"),
-			ServiceName: getEnvString("TRACING_SERVICE_NAME", "signaling-server"),
+			Enabled:     env.Bool("TRACING_ENABLED", true),
+			Exporter:    env.String("TRACING_EXPORTER", "otlp"),
+			Endpoint:    env.String("TRACING_ENDPOINT", "localhost:4317"),
+			ServiceName: env.String("TRACING_SERVICE_NAME", "signaling-server"),
 		},
 		WebSocket: WebSocketConfig{
-			Path:           getEnvString("WEBSOCKET_PATH", "/ws"),
-			PingInterval:   getEnvInt("WEBSOCKET_PING_INTERVAL", 30),
-			PongWait:       getEnvInt("WEBSOCKET_PONG_WAIT", 60),
-			WriteWait:      getEnvInt("WEBSOCKET_WRITE_WAIT", 10),
-			MaxMessageSize: getEnvInt64("WEBSOCKET_MAX_MESSAGE_SIZE", 1024*1024), // 1MB
+			Path:           env.String("WEBSOCKET_PATH", "/ws"),
+			PingInterval:   env.Int("WEBSOCKET_PING_INTERVAL", 30),
+			PongWait:       env.Int("WEBSOCKET_PONG_WAIT", 60),
+			WriteWait:      env.Int("WEBSOCKET_WRITE_WAIT", 10),
+			MaxMessageSize: env.Int64("WEBSOCKET_MAX_MESSAGE_SIZE", 1024*1024), // 1MB
+
+			SessionGracePeriod: env.Int("WEBSOCKET_SESSION_GRACE_PERIOD", 30),
+			SessionQueueSize:   env.Int("WEBSOCKET_SESSION_QUEUE_SIZE", 64),
+			ReplayCacheBackend: env.String("WEBSOCKET_REPLAY_CACHE_BACKEND", "memory"),
+
+			MessageRateLimit: env.Int("WEBSOCKET_MESSAGE_RATE_LIMIT", 20),
+			MessageRateBurst: env.Int("WEBSOCKET_MESSAGE_RATE_BURST", 50),
+
+			IPMessageRateLimit: env.Int("WEBSOCKET_IP_MESSAGE_RATE_LIMIT", 0),
+			IPMessageRateBurst: env.Int("WEBSOCKET_IP_MESSAGE_RATE_BURST", 200),
+			RateLimitBackend:   env.String("WEBSOCKET_RATE_LIMIT_BACKEND", "memory"),
+			RateLimitBudget:    env.Int("WEBSOCKET_RATE_LIMIT_BUDGET", 5),
+
+			MaxConnectionsPerIP:  env.Int("WEBSOCKET_MAX_CONNECTIONS_PER_IP", 100),
+			MaxConnectionsPerASN: env.Int("WEBSOCKET_MAX_CONNECTIONS_PER_ASN", 0),
+			AllowedOrigins:       env.StringSlice("WEBSOCKET_ALLOWED_ORIGINS", nil),
+
+			Encodings: env.StringSlice("WEBSOCKET_ENCODINGS", []string{"protobuf", "msgpack"}),
+
+			ReauthorizeURL:      env.String("WEBSOCKET_REAUTHORIZE_URL", ""),
+			ReauthorizeInterval: env.Int("WEBSOCKET_REAUTHORIZE_INTERVAL", 300),
+			ReauthorizeTimeout:  env.Int("WEBSOCKET_REAUTHORIZE_TIMEOUT", 5),
+
+			EnableCompression:       env.Bool("WEBSOCKET_ENABLE_COMPRESSION", true),
+			CompressionLevel:        env.Int("WEBSOCKET_COMPRESSION_LEVEL", 1),
+			CompressionThreshold:    env.Int("WEBSOCKET_COMPRESSION_THRESHOLD", 512),
+			CompressionThresholds:   env.IntMap("WEBSOCKET_COMPRESSION_THRESHOLDS"),
+			ServerNoContextTakeover: env.Bool("WEBSOCKET_SERVER_NO_CONTEXT_TAKEOVER", true),
+			ClientNoContextTakeover: env.Bool("WEBSOCKET_CLIENT_NO_CONTEXT_TAKEOVER", true),
+
+			LowPowerPingInterval: env.Int("WEBSOCKET_LOW_POWER_PING_INTERVAL", 120),
+			LowPowerPongWait:     env.Int("WEBSOCKET_LOW_POWER_PONG_WAIT", 300),
+
+			SendLatencySampling: env.Int("WEBSOCKET_SEND_LATENCY_SAMPLING", 100),
+
+			SendBufferSize:     env.Int("WEBSOCKET_SEND_BUFFER_SIZE", 256),
+			SendMemoryBudget:   env.Int64("WEBSOCKET_SEND_MEMORY_BUDGET", 0),
+			BackpressurePolicy: env.String("WEBSOCKET_BACKPRESSURE_POLICY", "disconnect"),
+
+			IdentitySources:     env.StringSlice("WEBSOCKET_IDENTITY_SOURCES", nil),
+			IdentityTokenSecret: env.String("WEBSOCKET_IDENTITY_TOKEN_SECRET", ""),
+			IdentityAPIKeys:     env.StringSlice("WEBSOCKET_IDENTITY_API_KEYS", nil),
+			IdentityConflict:    env.String("WEBSOCKET_IDENTITY_CONFLICT", "replace"),
+
+			TenantSources:          env.StringSlice("WEBSOCKET_TENANT_SOURCES", nil),
+			TenantMessageRateLimit: env.Int("WEBSOCKET_TENANT_MESSAGE_RATE_LIMIT", 0),
+			TenantMessageRateBurst: env.Int("WEBSOCKET_TENANT_MESSAGE_RATE_BURST", 2000),
 		},
 		Monitoring: MonitoringConfig{
-			LivenessPath:  getEnvString("MONITORING_LIVENESS_PATH", "/health/live"),
-			ReadinessPath: getEnvString("MONITORING_READINESS_PATH", "/health/ready"),
+			LivenessPath:  env.String("MONITORING_LIVENESS_PATH", "/health/live"),
+			ReadinessPath: env.String("MONITORING_READINESS_PATH", "/health/ready"),
+
+			ReadinessGates: env.StringSlice("MONITORING_READINESS_GATES", nil),
+			WarmUpInterval: env.Int("MONITORING_WARM_UP_INTERVAL", 1000),
 		},
-	}
+		Signaling: SignalingConfig{
+			RoomIdleTTL:     env.Int("SIGNALING_ROOM_IDLE_TTL", 0),
+			JanitorInterval: env.Int("SIGNALING_JANITOR_INTERVAL", 60),
+			BanDuration:     env.Int("SIGNALING_BAN_DURATION", 3600),
 
-	// In a real implementation, we would parse a config file here if one was provided
-	fmt.Println("No config file found. Using environment variables and defaults.")
+			ClosedRoomRetention: env.Int("SIGNALING_CLOSED_ROOM_RETENTION", 900),
 
-	return cfg, nil
+			MaxResidentRooms: env.Int("SIGNALING_MAX_RESIDENT_ROOMS", 0),
+			SpillPath:        env.String("SIGNALING_SPILL_PATH", "rooms.db"),
+
+			NamespacePolicies: env.NamespacePolicies("SIGNALING_NAMESPACE_POLICIES"),
+			RoomCloseWarnings: env.IntSlice("SIGNALING_ROOM_CLOSE_WARNINGS", []int{300, 60}),
+
+			SDP: SDPConfig{
+				Validate:        env.Bool("SIGNALING_SDP_VALIDATE", false),
+				MaxSize:         env.Int("SIGNALING_SDP_MAX_SIZE", 64*1024),
+				AllowedCodecs:   env.StringSlice("SIGNALING_SDP_ALLOWED_CODECS", nil),
+				DeniedCodecs:    env.StringSlice("SIGNALING_SDP_DENIED_CODECS", nil),
+				StripAttributes: env.StringSlice("SIGNALING_SDP_STRIP_ATTRIBUTES", nil),
+			},
+
+			Deprecations:              env.Deprecations("SIGNALING_DEPRECATIONS"),
+			DeprecationNoticeInterval: env.Int("SIGNALING_DEPRECATION_NOTICE_INTERVAL", 3600),
+
+			RoomCreationDisabled:        env.Bool("SIGNALING_ROOM_CREATION_DISABLED", false),
+			RoomCreationDisabledMessage: env.String("SIGNALING_ROOM_CREATION_DISABLED_MESSAGE", ""),
+
+			ObfuscatePeerIDs: env.Bool("SIGNALING_OBFUSCATE_PEER_IDS", false),
+			PeerIDSecret:     env.String("SIGNALING_PEER_ID_SECRET", ""),
+
+			JoinRate:        env.Float64("SIGNALING_JOIN_RATE", 0),
+			JoinBurst:       env.Int("SIGNALING_JOIN_BURST", 50),
+			JoinBatchWindow: env.Int("SIGNALING_JOIN_BATCH_WINDOW", 100),
+
+			DisplayNameCollision: env.String("SIGNALING_DISPLAY_NAME_COLLISION", "suffix"),
+
+			EnvelopeTimestampFormat: env.String("SIGNALING_ENVELOPE_TIMESTAMP_FORMAT", "rfc3339"),
+		},
+		Admin: AdminConfig{
+			Enabled:    env.Bool("ADMIN_ENABLED", false),
+			PathPrefix: env.String("ADMIN_PATH_PREFIX", "/admin"),
+			Token:      env.String("ADMIN_TOKEN", ""),
+
+			TenantTokens: env.StringSlice("ADMIN_TENANT_TOKENS", nil),
+		},
+		Exporters: ExportersConfig{
+			BufferSize:       env.Int("EXPORTERS_BUFFER_SIZE", 2048),
+			FailureThreshold: env.Int("EXPORTERS_FAILURE_THRESHOLD", 5),
+			Cooldown:         env.Int("EXPORTERS_COOLDOWN", 30),
+		},
+		Events: EventsConfig{
+			Kafka: KafkaConfig{
+				Enabled:      env.Bool("EVENTS_KAFKA_ENABLED", false),
+				Brokers:      env.StringSlice("EVENTS_KAFKA_BROKERS", []string{"localhost:9092"}),
+				Topic:        env.String("EVENTS_KAFKA_TOPIC", "signaling-activity"),
+				BatchSize:    env.Int("EVENTS_KAFKA_BATCH_SIZE", 500),
+				Retries:      env.Int("EVENTS_KAFKA_RETRIES", 3),
+				RetryBackoff: env.Int("EVENTS_KAFKA_RETRY_BACKOFF", 100),
+			},
+			Webhooks: WebhooksConfig{
+				Enabled:         env.Bool("EVENTS_WEBHOOKS_ENABLED", false),
+				URLs:            env.StringSlice("EVENTS_WEBHOOKS_URLS", nil),
+				Secret:          env.String("EVENTS_WEBHOOKS_SECRET", ""),
+				Events:          env.StringSlice("EVENTS_WEBHOOKS_EVENTS", nil),
+				Retries:         env.Int("EVENTS_WEBHOOKS_RETRIES", 5),
+				RetryBackoff:    env.Int("EVENTS_WEBHOOKS_RETRY_BACKOFF", 500),
+				MaxRetryBackoff: env.Int("EVENTS_WEBHOOKS_MAX_RETRY_BACKOFF", 30000),
+			},
+			RoomWebhooks: RoomWebhooksConfig{
+				Enabled:   env.Bool("EVENTS_ROOM_WEBHOOKS_ENABLED", false),
+				StorePath: env.String("EVENTS_ROOM_WEBHOOKS_STORE_PATH", "webhooks.db"),
+			},
+			Warehouse: WarehouseConfig{
+				Enabled:    env.Bool("EVENTS_WAREHOUSE_ENABLED", false),
+				Backend:    env.String("EVENTS_WAREHOUSE_BACKEND", "clickhouse"),
+				Interval:   env.Int("EVENTS_WAREHOUSE_INTERVAL", 60),
+				BatchSize:  env.Int("EVENTS_WAREHOUSE_BATCH_SIZE", 5000),
+				OutboxPath: env.String("EVENTS_WAREHOUSE_OUTBOX_PATH", "warehouse-outbox.db"),
+				ClickHouse: ClickHouseConfig{
+					URL:      env.String("EVENTS_WAREHOUSE_CLICKHOUSE_URL", "http://localhost:8123"),
+					Table:    env.String("EVENTS_WAREHOUSE_CLICKHOUSE_TABLE", "signaling.activity"),
+					Username: env.String("EVENTS_WAREHOUSE_CLICKHOUSE_USERNAME", "default"),
+					Password: env.String("EVENTS_WAREHOUSE_CLICKHOUSE_PASSWORD", ""),
+				},
+				BigQuery: BigQueryConfig{
+					Project:         env.String("EVENTS_WAREHOUSE_BIGQUERY_PROJECT", ""),
+					Dataset:         env.String("EVENTS_WAREHOUSE_BIGQUERY_DATASET", "signaling"),
+					Table:           env.String("EVENTS_WAREHOUSE_BIGQUERY_TABLE", "activity"),
+					CredentialsFile: env.String("EVENTS_WAREHOUSE_BIGQUERY_CREDENTIALS_FILE", ""),
+				},
+				S3: S3Config{
+					Bucket: env.String("EVENTS_WAREHOUSE_S3_BUCKET", ""),
+					Prefix: env.String("EVENTS_WAREHOUSE_S3_PREFIX", "signaling/activity"),
+					Region: env.String("EVENTS_WAREHOUSE_S3_REGION", "us-east-1"),
+				},
+			},
+		},
+		Audit: AuditConfig{
+			Enabled: env.Bool("AUDIT_ENABLED", false),
+			File: LogFileConfig{
+				Path:       env.String("AUDIT_FILE_PATH", "audit.log"),
+				MaxSizeMB:  env.Int("AUDIT_FILE_MAX_SIZE_MB", 100),
+				MaxAgeDays: env.Int("AUDIT_FILE_MAX_AGE_DAYS", 365),
+				MaxBackups: env.Int("AUDIT_FILE_MAX_BACKUPS", 0),
+
+				RotateHours: env.Int("AUDIT_FILE_ROTATE_HOURS", 0),
+				Compress:    env.Bool("AUDIT_FILE_COMPRESS", false),
+			},
+			BufferSize: env.Int("AUDIT_BUFFER_SIZE", 10000),
+		},
+		GeoIP: GeoIPConfig{
+			Enabled:             env.Bool("GEOIP_ENABLED", false),
+			CountryDatabasePath: env.String("GEOIP_COUNTRY_DATABASE_PATH", "GeoLite2-Country.mmdb"),
+			ASNDatabasePath:     env.String("GEOIP_ASN_DATABASE_PATH", "GeoLite2-ASN.mmdb"),
+			ReloadInterval:      env.Int("GEOIP_RELOAD_INTERVAL", 300),
+		},
+		ICE: ICEConfig{
+			STUNURLs:      env.StringSlice("ICE_STUN_URLS", nil),
+			TURNURLs:      env.StringSlice("ICE_TURN_URLS", nil),
+			TURNSecret:    env.String("ICE_TURN_SECRET", ""),
+			CredentialTTL: env.Int("ICE_CREDENTIAL_TTL", 86400),
+		},
+		Cluster: ClusterConfig{
+			Backend: env.String("CLUSTER_BACKEND", ""),
+			NodeID:  env.String("CLUSTER_NODE_ID", ""),
+			Redis: RedisConfig{
+				Address:       env.String("CLUSTER_REDIS_ADDRESS", "localhost:6379"),
+				Password:      env.String("CLUSTER_REDIS_PASSWORD", ""),
+				DB:            env.Int("CLUSTER_REDIS_DB", 0),
+				ChannelPrefix: env.String("CLUSTER_REDIS_CHANNEL_PREFIX", "signaling"),
+			},
+			NATS: NATSConfig{
+				URL:           env.String("CLUSTER_NATS_URL", "nats://localhost:4222"),
+				Token:         env.String("CLUSTER_NATS_TOKEN", ""),
+				SubjectPrefix: env.String("CLUSTER_NATS_SUBJECT_PREFIX", "signaling"),
+				ReconnectWait: env.Int("CLUSTER_NATS_RECONNECT_WAIT", 2),
+				MaxReconnects: env.Int("CLUSTER_NATS_MAX_RECONNECTS", -1),
+			},
+			HealthCheckInterval: env.Int("CLUSTER_HEALTH_CHECK_INTERVAL", 5),
+
+			AtLeastOnce:        env.Bool("CLUSTER_AT_LEAST_ONCE", false),
+			RelayRetryInterval: env.Int("CLUSTER_RELAY_RETRY_INTERVAL", 500),
+			RelayMaxAttempts:   env.Int("CLUSTER_RELAY_MAX_ATTEMPTS", 5),
+			Sharding: ShardingConfig{
+				Enabled:    env.Bool("CLUSTER_SHARDING_ENABLED", false),
+				Membership: env.String("CLUSTER_SHARDING_MEMBERSHIP", "redis"),
+				Replicas:   env.Int("CLUSTER_SHARDING_REPLICAS", 128),
+				MemberTTL:  env.Int("CLUSTER_SHARDING_MEMBER_TTL", 15),
+				Memberlist: MemberlistConfig{
+					BindAddress: env.String("CLUSTER_SHARDING_MEMBERLIST_BIND_ADDRESS", "0.0.0.0:7946"),
+					Seeds:       env.StringSlice("CLUSTER_SHARDING_MEMBERLIST_SEEDS", nil),
+				},
+			},
+		},
+		GRPC: GRPCConfig{
+			Enabled: env.Bool("GRPC_ENABLED", false),
+			Port:    env.Int("GRPC_PORT", 9090),
+		},
+		SSE: SSEConfig{
+			Enabled:           env.Bool("SSE_ENABLED", false),
+			Path:              env.String("SSE_PATH", "/events"),
+			KeepaliveInterval: env.Int("SSE_KEEPALIVE_INTERVAL", 15),
+		},
+		LongPoll: LongPollConfig{
+			Enabled:     env.Bool("LONGPOLL_ENABLED", false),
+			PollPath:    env.String("LONGPOLL_POLL_PATH", "/poll"),
+			SendPath:    env.String("LONGPOLL_SEND_PATH", "/send"),
+			PollTimeout: env.Int("LONGPOLL_POLL_TIMEOUT", 25),
+			IdleTimeout: env.Int("LONGPOLL_IDLE_TIMEOUT", 60),
+		},
+		Quality: QualityConfig{
+			Enabled:    env.Bool("QUALITY_ENABLED", false),
+			Threshold:  env.Float64("QUALITY_THRESHOLD", 3.5),
+			WebhookURL: env.String("QUALITY_WEBHOOK_URL", ""),
+		},
+		CORS: CORSConfig{
+			Enabled:          env.Bool("CORS_ENABLED", false),
+			AllowedOrigins:   env.StringSlice("CORS_ALLOWED_ORIGINS", nil),
+			AllowedMethods:   env.StringSlice("CORS_ALLOWED_METHODS", []string{"GET", "POST"}),
+			AllowedHeaders:   env.StringSlice("CORS_ALLOWED_HEADERS", []string{"Authorization", "Content-Type", "X-Signaling-Stream-Token", "X-Signaling-Session-Token"}),
+			MaxAge:           env.Int("CORS_MAX_AGE", 600),
+			AllowCredentials: env.Bool("CORS_ALLOW_CREDENTIALS", false),
+		},
+		Debug: DebugConfig{
+			Enabled:       env.Bool("DEBUG_ENABLED", false),
+			Port:          env.Int("DEBUG_PORT", 6060),
+			LocalhostOnly: env.Bool("DEBUG_LOCALHOST_ONLY", true),
+		},
+		Migrations: MigrationsConfig{
+			Manual:      env.Bool("MIGRATIONS_MANUAL", false),
+			LockTimeout: env.Int("MIGRATIONS_LOCK_TIMEOUT", 30),
+		},
+	}
 }
 
 // GetConfigPath returns the path to the config file specified by the environment variable
@@ -115,65 +1032,280 @@ func GetConfigPath() string {
 	configPath := os.Getenv("SERVER_CONFIG_PATH")
 	if configPath == "" {
 		// Try to find config file in the config directory
-		defaultConfigPath := filepath.Join("config", "default.yaml")
-		if _, err := os.Stat(defaultConfigPath); err == nil {
-			return defaultConfigPath
+		for _, name := range defaultConfigFiles {
+			defaultConfigPath := filepath.Join("config", name)
+			if _, err := os.Stat(defaultConfigPath); err == nil {
+				return defaultConfigPath
+			}
 		}
 	}
 	return configPath
 }
 
-// Environment variable helpers
-func getEnvString(key, defaultValue string) string {
-	value, exists := os.LookupEnv(key)
+// environment reads the settings from environment variables, recording the
+// values it could not parse, which fall back to their defaults, so that
+// Validate reports them
+type environment struct {
+	problems []string
+
+	// ignore reads every variable as unset, for the defaults alone
+	ignore bool
+
+	// mark reads every variable set as a value other than its default, so
+	// that comparing with the defaults finds the settings it overrides
+	mark bool
+}
+
+// lookup returns the value of a variable and whether it is set
+func (e *environment) lookup(key string) (string, bool) {
+	if e.ignore {
+		return "", false
+	}
+	return os.LookupEnv(key)
+}
+
+// invalid records a value that could not be parsed
+func (e *environment) invalid(key, value, expected string) {
+	e.problems = append(e.problems, fmt.Sprintf("%s: %q is not %s", key, value, expected))
+}
+
+func (e *environment) String(key, defaultValue string) string {
+	value, exists := e.lookup(key)
 	if !exists {
 		return defaultValue
 	}
+	if e.mark {
+		return defaultValue + "\x00"
+	}
 	return value
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	value, exists := os.LookupEnv(key)
+func (e *environment) Int(key string, defaultValue int) int {
+	value, exists := e.lookup(key)
 	if !exists {
 		return defaultValue
 	}
+	if e.mark {
+		return defaultValue + 1
+	}
 
-	intValue, err := strconv.Atoi(value)
+	intValue, err := strconv.Atoi(strings.TrimSpace(value))
 	if err != nil {
+		e.invalid(key, value, "an integer")
 		return defaultValue
 	}
 
 	return intValue
 }
 
-func getEnvInt64(key string, defaultValue int64) int64 {
-	value, exists := os.LookupEnv(key)
+func (e *environment) Int64(key string, defaultValue int64) int64 {
+	value, exists := e.lookup(key)
 	if !exists {
 		return defaultValue
 	}
+	if e.mark {
+		return defaultValue + 1
+	}
 
-	intValue, err := strconv.ParseInt(value, 10, 64)
+	intValue, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
 	if err != nil {
+		e.invalid(key, value, "an integer")
 		return defaultValue
 	}
 
 	return intValue
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	value, exists := os.LookupEnv(key)
+func (e *environment) Float64(key string, defaultValue float64) float64 {
+	value, exists := e.lookup(key)
 	if !exists {
 		return defaultValue
 	}
+	if e.mark {
+		return defaultValue + 1
+	}
 
-	value = strings.ToLower(value)
-	if value == "true" || value == "1" || value == "yes" || value == "y" {
-		return true
+	floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		e.invalid(key, value, "a number")
+		return defaultValue
 	}
 
-	if value == "false" || value == "0" || value == "no" || value == "n" {
+	return floatValue
+}
+
+// StringSlice parses a comma-separated list, ignoring empty entries
+func (e *environment) StringSlice(key string, defaultValue []string) []string {
+	value, exists := e.lookup(key)
+	if !exists {
+		return defaultValue
+	}
+	if e.mark {
+		return append(defaultValue[:len(defaultValue):len(defaultValue)], "")
+	}
+
+	var values []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			values = append(values, entry)
+		}
+	}
+	return values
+}
+
+// IntSlice parses a comma-separated list of integers, skipping invalid entries
+func (e *environment) IntSlice(key string, defaultValue []int) []int {
+	value, exists := e.lookup(key)
+	if !exists {
+		return defaultValue
+	}
+	if e.mark {
+		return append(defaultValue[:len(defaultValue):len(defaultValue)], 0)
+	}
+
+	var values []int
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		intValue, err := strconv.Atoi(entry)
+		if err != nil {
+			e.invalid(key, entry, "an integer")
+			continue
+		}
+		values = append(values, intValue)
+	}
+	return values
+}
+
+// IntMap parses comma-separated entries of the form key:integer, e.g.
+// "ice-candidate:-1,offer:0". Malformed entries are skipped.
+func (e *environment) IntMap(key string) map[string]int {
+	value, exists := e.lookup(key)
+	if exists && e.mark {
+		return map[string]int{}
+	}
+	if !exists || value == "" {
+		return nil
+	}
+
+	values := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		name, number, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			e.invalid(key, entry, "of the form name:integer")
+			continue
+		}
+		intValue, err := strconv.Atoi(strings.TrimSpace(number))
+		if err != nil {
+			e.invalid(key, entry, "of the form name:integer")
+			continue
+		}
+		values[name] = intValue
+	}
+	return values
+}
+
+func (e *environment) Bool(key string, defaultValue bool) bool {
+	value, exists := e.lookup(key)
+	if !exists {
+		return defaultValue
+	}
+	if e.mark {
+		return !defaultValue
+	}
+
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes", "y":
+		return true
+	case "false", "0", "no", "n":
 		return false
 	}
 
+	e.invalid(key, value, "a boolean")
 	return defaultValue
-}
\ No newline at end of file
+}
+
+// Deprecations parses comma-separated deprecations of the form
+// messageType[:field], e.g. "offer:payload.legacy,mute". Entries without a
+// message type deprecate the field in messages of any type, e.g. ":password".
+func (e *environment) Deprecations(key string) []DeprecationConfig {
+	value, exists := e.lookup(key)
+	if exists && e.mark {
+		return []DeprecationConfig{{}}
+	}
+	if !exists || value == "" {
+		return nil
+	}
+
+	var deprecations []DeprecationConfig
+	for _, entry := range strings.Split(value, ",") {
+		messageType, field, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if messageType == "" && field == "" {
+			continue
+		}
+		deprecations = append(deprecations, DeprecationConfig{MessageType: messageType, Field: field})
+	}
+
+	return deprecations
+}
+
+// NamespacePolicies parses comma-separated namespace policies of the form
+// namespace:maxPeers[:requirePassword[:maxDuration[:observerJoins]]], e.g.
+// "acme/web:4:true,globex:10,trial:2:false:2400,webinars:0:false:0:true".
+// Malformed entries are skipped.
+func (e *environment) NamespacePolicies(key string) []NamespacePolicyConfig {
+	value, exists := e.lookup(key)
+	if exists && e.mark {
+		return []NamespacePolicyConfig{{}}
+	}
+	if !exists || value == "" {
+		return nil
+	}
+
+	var policies []NamespacePolicyConfig
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		policy, ok := parseNamespacePolicy(entry)
+		if !ok {
+			e.invalid(key, entry, "of the form namespace:maxPeers[:requirePassword[:maxDuration[:observerJoins]]]")
+			continue
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies
+}
+
+// parseNamespacePolicy parses a namespace policy of NamespacePolicies
+func parseNamespacePolicy(entry string) (NamespacePolicyConfig, bool) {
+	fields := strings.Split(entry, ":")
+	if len(fields) < 2 || len(fields) > 5 {
+		return NamespacePolicyConfig{}, false
+	}
+
+	maxPeers, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return NamespacePolicyConfig{}, false
+	}
+
+	policy := NamespacePolicyConfig{Namespace: fields[0], MaxPeers: maxPeers}
+	if len(fields) >= 3 {
+		if policy.RequirePassword, err = strconv.ParseBool(fields[2]); err != nil {
+			return NamespacePolicyConfig{}, false
+		}
+	}
+	if len(fields) >= 4 {
+		if policy.MaxDuration, err = strconv.Atoi(fields[3]); err != nil {
+			return NamespacePolicyConfig{}, false
+		}
+	}
+	if len(fields) == 5 {
+		if policy.ObserverJoins, err = strconv.ParseBool(fields[4]); err != nil {
+			return NamespacePolicyConfig{}, false
+		}
+	}
+	return policy, true
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -75,4 +76,57 @@ func TestGetConfigPath(t *testing.T) {
 	} else {
 		os.Unsetenv("SERVER_CONFIG_PATH")
 	}
-}
\ No newline at end of file
+}
+func TestNamespacePoliciesFromEnv(t *testing.T) {
+	os.Setenv("SIGNALING_NAMESPACE_POLICIES", "acme/web:4:true, globex:10,trial:2:false:2400,webinars:0:false:0:true,broken,bad:x")
+	defer os.Unsetenv("SIGNALING_NAMESPACE_POLICIES")
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := []NamespacePolicyConfig{
+		{Namespace: "acme/web", MaxPeers: 4, RequirePassword: true},
+		{Namespace: "globex", MaxPeers: 10},
+		{Namespace: "trial", MaxPeers: 2, MaxDuration: 2400},
+		{Namespace: "webinars", ObserverJoins: true},
+	}
+	if !reflect.DeepEqual(cfg.Signaling.NamespacePolicies, expected) {
+		t.Errorf("Expected policies %+v, got %+v", expected, cfg.Signaling.NamespacePolicies)
+	}
+}
+
+func TestCompressionThresholdsFromEnv(t *testing.T) {
+	os.Setenv("WEBSOCKET_COMPRESSION_THRESHOLDS", "ice-candidate:-1, offer:0,answer:256,broken,bad:x")
+	defer os.Unsetenv("WEBSOCKET_COMPRESSION_THRESHOLDS")
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := map[string]int{"ice-candidate": -1, "offer": 0, "answer": 256}
+	if !reflect.DeepEqual(cfg.WebSocket.CompressionThresholds, expected) {
+		t.Errorf("Expected thresholds %v, got %v", expected, cfg.WebSocket.CompressionThresholds)
+	}
+}
+
+func TestDeprecationsFromEnv(t *testing.T) {
+	os.Setenv("SIGNALING_DEPRECATIONS", "join:payload.metadata, mute,:password,:")
+	defer os.Unsetenv("SIGNALING_DEPRECATIONS")
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := []DeprecationConfig{
+		{MessageType: "join", Field: "payload.metadata"},
+		{MessageType: "mute"},
+		{Field: "password"},
+	}
+	if !reflect.DeepEqual(cfg.Signaling.Deprecations, expected) {
+		t.Errorf("Expected deprecations %+v, got %+v", expected, cfg.Signaling.Deprecations)
+	}
+}
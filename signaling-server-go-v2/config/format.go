@@ -0,0 +1,309 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Config file formats, all decoded by the mapstructure tags of Config
+const (
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+	FormatJSON = "json"
+)
+
+// formatsByExtension maps the config file extensions to their formats
+var formatsByExtension = map[string]string{
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+	".toml": FormatTOML,
+	".json": FormatJSON,
+}
+
+// defaultConfigFiles are looked up in the config directory, in order, when
+// no config path is set
+var defaultConfigFiles = []string{"default.yaml", "default.yml", "default.toml", "default.json"}
+
+// FileFormat returns the format of a config file by its extension
+func FileFormat(path string) (string, error) {
+	format, ok := formatsByExtension[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return "", fmt.Errorf("config file %s: unsupported format, expected .yaml, .yml, .toml or .json", path)
+	}
+	return format, nil
+}
+
+// ProfileEnv is the environment variable selecting the config profile, such
+// as dev, staging or prod
+const ProfileEnv = "APP_ENV"
+
+// profileName matches the names of config profiles, which are part of file names
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ProfilePath returns the overlay of a config file for a profile, next to it
+// with the profile before its extension, e.g. config/default.staging.yaml
+func ProfilePath(basePath, profile string) string {
+	ext := filepath.Ext(basePath)
+	return strings.TrimSuffix(basePath, ext) + "." + profile + ext
+}
+
+// configProfile returns the profile selected by APP_ENV, "" without one,
+// checking that the base config file has an overlay for it, so that a
+// misspelled profile does not start a server with the base settings alone
+func configProfile(basePath string) (string, error) {
+	profile := strings.TrimSpace(os.Getenv(ProfileEnv))
+	if profile == "" {
+		return "", nil
+	}
+	if !profileName.MatchString(profile) {
+		return "", fmt.Errorf("%s: %q is not a profile name of letters, digits, - and _", ProfileEnv, profile)
+	}
+	if basePath == "" {
+		return "", fmt.Errorf("%s: profile %s requires a base config file", ProfileEnv, profile)
+	}
+
+	if _, err := os.Stat(ProfilePath(basePath, profile)); err != nil {
+		return "", fmt.Errorf("%s: overlay of profile %s: %w", ProfileEnv, profile, err)
+	}
+	return profile, nil
+}
+
+// readConfigFile reads the settings of a JSON or TOML config file, as nested
+// maps by key
+func readConfigFile(path, format string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	var values map[string]any
+	switch format {
+	case FormatJSON:
+		// Numbers are kept as written, so that large integers are not rounded
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+		if err == nil && decoder.More() {
+			err = fmt.Errorf("unexpected data after the top-level object")
+		}
+	case FormatTOML:
+		values, err = parseTOML(data)
+	default:
+		err = fmt.Errorf("unsupported format %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// mergeSettings merges the settings of a profile overlay into those of its
+// base config file, tables key by key, while other values, lists included,
+// replace the base ones. Keys match regardless of case, as when decoded.
+func mergeSettings(base, overlay map[string]any) {
+	for key, value := range overlay {
+		baseKey := key
+		for k := range base {
+			if strings.EqualFold(k, key) {
+				baseKey = k
+				break
+			}
+		}
+
+		baseTable, isTable := base[baseKey].(map[string]any)
+		table, overlaysTable := value.(map[string]any)
+		if isTable && overlaysTable {
+			mergeSettings(baseTable, table)
+			continue
+		}
+		delete(base, baseKey)
+		base[key] = value
+	}
+}
+
+// withConfigFile returns the configuration of the settings of a config file,
+// on top of the defaults, with the settings of the environment variables in
+// cfg overriding both
+func withConfigFile(cfg *Config, values map[string]any) (*Config, error) {
+	file := newConfig(&environment{ignore: true})
+	if err := decodeSetting(reflect.ValueOf(file).Elem(), values, ""); err != nil {
+		return nil, err
+	}
+
+	defaults := newConfig(&environment{ignore: true})
+	overridden := newConfig(&environment{mark: true})
+	overrideSettings(reflect.ValueOf(file).Elem(), reflect.ValueOf(cfg).Elem(),
+		reflect.ValueOf(overridden).Elem(), reflect.ValueOf(defaults).Elem())
+
+	file.problems = cfg.problems
+	file.profile = cfg.profile
+	return file, nil
+}
+
+// overrideSettings sets the settings of dst that the environment overrides,
+// found as those marked differently from their defaults, to those of env
+func overrideSettings(dst, env, marked, defaults reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		if !dst.Type().Field(i).IsExported() {
+			continue
+		}
+		if dst.Field(i).Kind() == reflect.Struct {
+			overrideSettings(dst.Field(i), env.Field(i), marked.Field(i), defaults.Field(i))
+			continue
+		}
+		if !reflect.DeepEqual(marked.Field(i).Interface(), defaults.Field(i).Interface()) {
+			dst.Field(i).Set(env.Field(i))
+		}
+	}
+}
+
+// settingKey returns the key of a setting in config files
+func settingKey(field reflect.StructField) string {
+	if key := field.Tag.Get("mapstructure"); key != "" {
+		return key
+	}
+	return strings.ToLower(field.Name[:1]) + field.Name[1:]
+}
+
+// decodeSetting decodes a value of a config file into the setting at path,
+// matching keys to the mapstructure tags regardless of case
+func decodeSetting(v reflect.Value, value any, path string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		values, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected a table of settings, got %v", path, value)
+		}
+		seen := make(map[string]string, len(values))
+		for key, fieldValue := range values {
+			field, ok := settingField(v, key)
+			if !ok {
+				return fmt.Errorf("%s: unknown setting", joinKey(path, key))
+			}
+			// Keys differing in case only would otherwise override each other at random
+			if other, ok := seen[strings.ToLower(key)]; ok {
+				return fmt.Errorf("%s: set twice, also as %s", joinKey(path, key), other)
+			}
+			seen[strings.ToLower(key)] = key
+			if err := decodeSetting(field, fieldValue, joinKey(path, key)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a string", path, value)
+		}
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a boolean", path, value)
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int64:
+		n, ok := integerValue(value)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an integer", path, value)
+		}
+		v.SetInt(n)
+
+	case reflect.Float64:
+		f, ok := floatValue(value)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a number", path, value)
+		}
+		v.SetFloat(f)
+
+	case reflect.Slice:
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a list", path, value)
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeSetting(slice.Index(i), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+
+	case reflect.Map:
+		values, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a table", path, value)
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(values))
+		for key, item := range values {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeSetting(elem, item, joinKey(path, key)); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+		v.Set(m)
+
+	default:
+		return fmt.Errorf("%s: settings of type %s cannot be read from config files", path, v.Type())
+	}
+	return nil
+}
+
+// settingField returns the field of a struct whose key is key, regardless of case
+func settingField(v reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.IsExported() && strings.EqualFold(settingKey(field), key) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// joinKey returns the dotted path of a key in the table at path
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// integerValue returns a JSON or TOML number as an integer, if it is one
+func integerValue(value any) (int64, bool) {
+	switch n := value.(type) {
+	case int64:
+		return n, true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// floatValue returns a JSON or TOML number as a float
+func floatValue(value any) (float64, bool) {
+	switch n := value.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
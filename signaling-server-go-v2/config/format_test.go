@@ -0,0 +1,189 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileFormat(t *testing.T) {
+	tests := map[string]string{
+		"config/default.yaml": FormatYAML,
+		"server.YML":          FormatYAML,
+		"/etc/signaling.toml": FormatTOML,
+		"config.json":         FormatJSON,
+	}
+	for path, expected := range tests {
+		if format, err := FileFormat(path); err != nil || format != expected {
+			t.Errorf("Expected %s to be %s, got %q (%v)", path, expected, format, err)
+		}
+	}
+
+	if _, err := FileFormat("config.ini"); err == nil {
+		t.Error("Expected an error for an unsupported extension")
+	}
+	if _, err := LoadConfig("config.ini"); err == nil {
+		t.Error("Expected LoadConfig to reject an unsupported config file")
+	}
+}
+
+func TestConfigProfile(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "default.yaml")
+	if err := os.WriteFile(base, []byte("server:\n  port: 8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ProfilePath(base, "staging"), []byte("server:\n  port: 9090\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if overlay := ProfilePath(base, "staging"); overlay != filepath.Join(dir, "default.staging.yaml") {
+		t.Errorf("Expected the overlay next to the base file, got %s", overlay)
+	}
+
+	os.Setenv(ProfileEnv, "staging")
+	defer os.Unsetenv(ProfileEnv)
+	cfg, err := LoadConfig(base)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Profile() != "staging" {
+		t.Errorf("Expected the staging profile, got %q", cfg.Profile())
+	}
+
+	// A profile without an overlay, an invalid name or no base file is rejected
+	for _, profile := range []string{"prod", "../staging"} {
+		os.Setenv(ProfileEnv, profile)
+		if _, err := LoadConfig(base); err == nil {
+			t.Errorf("Expected profile %q to be rejected", profile)
+		}
+	}
+	os.Setenv(ProfileEnv, "staging")
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("Expected a profile without a base file to be rejected")
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{
+  "server": {"port": 9000, "trustedProxies": ["10.0.0.0/8"]},
+  "logging": {"level": "warn"},
+  "quality": {"threshold": 4},
+  "signaling": {"namespacePolicies": [{"namespace": "acme", "maxPeers": 4, "requirePassword": true}]}
+}`,
+		"config.toml": `[server]
+port = 9000
+trustedProxies = ["10.0.0.0/8"]
+
+[logging]
+level = "warn"
+
+[quality]
+threshold = 4
+
+[[signaling.namespacePolicies]]
+namespace = "acme"
+maxPeers = 4
+requirePassword = true
+`,
+	}
+
+	// Environment variables override the file, even when set to the default
+	os.Setenv("LOGGING_LEVEL", "info")
+	defer os.Unsetenv("LOGGING_LEVEL")
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: failed to load config: %v", name, err)
+		}
+
+		if cfg.Server.Port != 9000 || !reflect.DeepEqual(cfg.Server.TrustedProxies, []string{"10.0.0.0/8"}) || cfg.Quality.Threshold != 4 {
+			t.Errorf("%s: expected the settings of the file, got %+v and %+v", name, cfg.Server, cfg.Quality)
+		}
+		expected := []NamespacePolicyConfig{{Namespace: "acme", MaxPeers: 4, RequirePassword: true}}
+		if !reflect.DeepEqual(cfg.Signaling.NamespacePolicies, expected) {
+			t.Errorf("%s: expected policies %+v, got %+v", name, expected, cfg.Signaling.NamespacePolicies)
+		}
+		if cfg.Logging.Level != "info" {
+			t.Errorf("%s: expected the environment to override the logging level, got %s", name, cfg.Logging.Level)
+		}
+		// Settings missing from the file keep their defaults
+		if cfg.Server.Host != "0.0.0.0" {
+			t.Errorf("%s: expected the default host, got %s", name, cfg.Server.Host)
+		}
+	}
+
+	// Unknown settings, values of the wrong type and keys set twice are rejected
+	for name, content := range map[string]string{
+		"unknown.json":   `{"server": {"prot": 9000}}`,
+		"type.json":      `{"server": {"port": "9000"}}`,
+		"fraction.json":  `{"server": {"port": 90.5}}`,
+		"twice.json":     `{"server": {"port": 9000, "Port": 9001}}`,
+		"malformed.toml": "[server]\nport = \n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestConfigProfileOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "default.json")
+	files := map[string]string{
+		base: `{
+  "server": {"port": 8080, "trustedProxies": ["10.0.0.0/8", "172.16.0.0/12"]},
+  "websocket": {"compressionThresholds": {"offer": 0, "answer": 0}},
+  "logging": {"level": "debug"}
+}`,
+		ProfilePath(base, "prod"): `{
+  "server": {"Port": 443, "trustedProxies": ["192.168.0.0/16"]},
+  "websocket": {"compressionThresholds": {"answer": -1}}
+}`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.Setenv(ProfileEnv, "prod")
+	defer os.Unsetenv(ProfileEnv)
+	cfg, err := LoadConfig(base)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// The overlay's values take effect, its lists replacing the base ones and
+	// its maps merged key by key
+	if cfg.Server.Port != 443 || !reflect.DeepEqual(cfg.Server.TrustedProxies, []string{"192.168.0.0/16"}) {
+		t.Errorf("Expected the server settings of the overlay, got %+v", cfg.Server)
+	}
+	if expected := map[string]int{"offer": 0, "answer": -1}; !reflect.DeepEqual(cfg.WebSocket.CompressionThresholds, expected) {
+		t.Errorf("Expected thresholds %v, got %v", expected, cfg.WebSocket.CompressionThresholds)
+	}
+	if cfg.Logging.Level != "debug" {
+		t.Errorf("Expected the base logging level, got %s", cfg.Logging.Level)
+	}
+
+	// Environment variables override the overlay
+	os.Setenv("SERVER_PORT", "8443")
+	defer os.Unsetenv("SERVER_PORT")
+	if cfg, err = LoadConfig(base); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Server.Port != 8443 {
+		t.Errorf("Expected the environment to override the overlay, got %d", cfg.Server.Port)
+	}
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Hash returns a digest of the redacted configuration, identical on every
+// instance running with the same effective configuration. Secrets are masked
+// before hashing so that the hash cannot be used to guess them; rotating a
+// secret does not change the hash.
+func (c Config) Hash() (string, error) {
+	// Struct fields are encoded in declaration order and map keys sorted, so the encoding is deterministic
+	data, err := json.Marshal(c.Redacted())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode configuration: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import "fmt"
+
+// FitProxyIdleTimeout lowers the keepalive intervals of long-lived
+// connections to half the proxy idle timeout where they would exceed it, so
+// that the fronting proxy never sees a call's connection idle long enough to
+// close it. It returns a description of each adjustment.
+func (c *Config) FitProxyIdleTimeout() []string {
+	idle := c.Server.ProxyIdleTimeout
+	if idle <= 0 {
+		return nil
+	}
+	limit := max(idle/2, 1)
+
+	var adjusted []string
+	fit := func(name string, interval *int) {
+		if *interval > 0 && *interval >= idle {
+			adjusted = append(adjusted, fmt.Sprintf("%s lowered from %ds to %ds to fit the proxy idle timeout of %ds", name, *interval, limit, idle))
+			*interval = limit
+		}
+	}
+	fit("websocket.pingInterval", &c.WebSocket.PingInterval)
+	fit("websocket.lowPowerPingInterval", &c.WebSocket.LowPowerPingInterval)
+	fit("sse.keepaliveInterval", &c.SSE.KeepaliveInterval)
+	fit("longPoll.pollTimeout", &c.LongPoll.PollTimeout)
+	return adjusted
+}
@@ -0,0 +1,29 @@
+package config
+
+import "testing"
+
+func TestFitProxyIdleTimeout(t *testing.T) {
+	cfg := Config{
+		Server:    ServerConfig{ProxyIdleTimeout: 60},
+		WebSocket: WebSocketConfig{PingInterval: 30, LowPowerPingInterval: 120},
+		SSE:       SSEConfig{KeepaliveInterval: 0},
+		LongPoll:  LongPollConfig{PollTimeout: 60},
+	}
+
+	adjusted := cfg.FitProxyIdleTimeout()
+	if len(adjusted) != 2 {
+		t.Errorf("Expected 2 adjustments, got %v", adjusted)
+	}
+	if cfg.WebSocket.PingInterval != 30 || cfg.WebSocket.LowPowerPingInterval != 30 || cfg.LongPoll.PollTimeout != 30 {
+		t.Errorf("Expected intervals over the idle timeout to be halved, got %+v and %+v", cfg.WebSocket, cfg.LongPoll)
+	}
+	if cfg.SSE.KeepaliveInterval != 0 {
+		t.Errorf("Expected disabled keepalives to stay disabled, got %d", cfg.SSE.KeepaliveInterval)
+	}
+
+	cfg.Server.ProxyIdleTimeout = 0
+	cfg.WebSocket.PingInterval = 300
+	if adjusted := cfg.FitProxyIdleTimeout(); len(adjusted) != 0 || cfg.WebSocket.PingInterval != 300 {
+		t.Errorf("Expected no adjustment without a proxy, got %v", adjusted)
+	}
+}
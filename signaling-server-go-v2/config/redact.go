@@ -0,0 +1,46 @@
+package config
+
+import "strings"
+
+// RedactedValue replaces secrets in redacted configuration
+const RedactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with secrets masked, safe for
+// logging, debug bundles and admin endpoints
+func (c Config) Redacted() Config {
+	redacted := c
+	redacted.Admin.Token = redact(c.Admin.Token)
+	redacted.Metrics.Token = redact(c.Metrics.Token)
+	redacted.Cluster.Redis.Password = redact(c.Cluster.Redis.Password)
+	redacted.Cluster.NATS.Token = redact(c.Cluster.NATS.Token)
+	redacted.ICE.TURNSecret = redact(c.ICE.TURNSecret)
+	redacted.Signaling.PeerIDSecret = redact(c.Signaling.PeerIDSecret)
+	redacted.Events.Webhooks.Secret = redact(c.Events.Webhooks.Secret)
+	redacted.Events.Warehouse.ClickHouse.Password = redact(c.Events.Warehouse.ClickHouse.Password)
+	redacted.WebSocket.IdentityTokenSecret = redact(c.WebSocket.IdentityTokenSecret)
+	redacted.WebSocket.IdentityAPIKeys = redactEntries(c.WebSocket.IdentityAPIKeys)
+	redacted.Admin.TenantTokens = redactEntries(c.Admin.TenantTokens)
+	return redacted
+}
+
+// redactEntries masks the secrets of name:secret entries in a copy, keeping
+// the names visible
+func redactEntries(entries []string) []string {
+	if entries == nil {
+		return nil
+	}
+	redacted := make([]string, len(entries))
+	for i, entry := range entries {
+		name, secret, _ := strings.Cut(entry, ":")
+		redacted[i] = name + ":" + redact(secret)
+	}
+	return redacted
+}
+
+// redact masks a secret, keeping empty values visible so unset secrets can be spotted
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedValue
+}
@@ -0,0 +1,55 @@
+package config
+
+import "testing"
+
+func TestRedacted(t *testing.T) {
+	cfg := Config{Admin: AdminConfig{Enabled: true, Token: "s3cret"}}
+
+	redacted := cfg.Redacted()
+	if redacted.Admin.Token != RedactedValue {
+		t.Errorf("Expected admin token to be redacted, got %s", redacted.Admin.Token)
+	}
+	if !redacted.Admin.Enabled {
+		t.Error("Expected non-secret values to be preserved")
+	}
+	if cfg.Admin.Token != "s3cret" {
+		t.Error("Expected the original configuration to be unchanged")
+	}
+
+	cfg.WebSocket.IdentityAPIKeys = []string{"alice:k1"}
+	if keys := cfg.Redacted().WebSocket.IdentityAPIKeys; keys[0] != "alice:"+RedactedValue || cfg.WebSocket.IdentityAPIKeys[0] != "alice:k1" {
+		t.Errorf("Expected API keys to be redacted in a copy, got %v", keys)
+	}
+	cfg.Admin.TenantTokens = []string{"acme:t1"}
+	if tokens := cfg.Redacted().Admin.TenantTokens; tokens[0] != "acme:"+RedactedValue {
+		t.Errorf("Expected tenant tokens to be redacted, got %v", tokens)
+	}
+
+	if (Config{}).Redacted().Admin.Token != "" {
+		t.Error("Expected empty secrets to stay empty")
+	}
+}
+
+func TestHash(t *testing.T) {
+	cfg := Config{Server: ServerConfig{Port: 8080}, Admin: AdminConfig{Token: "s3cret"}}
+
+	hash, err := cfg.Hash()
+	if err != nil {
+		t.Fatalf("Hash failed: %v", err)
+	}
+	if again, _ := cfg.Hash(); again != hash {
+		t.Errorf("Expected the hash to be deterministic, got %s and %s", hash, again)
+	}
+
+	rotated := cfg
+	rotated.Admin.Token = "n3w-s3cret"
+	if h, _ := rotated.Hash(); h != hash {
+		t.Error("Expected rotating a secret not to change the hash")
+	}
+
+	changed := cfg
+	changed.Server.Port = 9090
+	if h, _ := changed.Hash(); h == hash {
+		t.Error("Expected a configuration change to change the hash")
+	}
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Setting is a setting that can change without a restart. Value reads it
+// from a configuration, and Apply makes the value of a configuration
+// effective, e.g. by changing the log level.
+type Setting struct {
+	Name  string
+	Value func(cfg *Config) interface{}
+	Apply func(cfg *Config) error
+}
+
+// Change is a setting changed by a reload
+type Change struct {
+	Setting string      `json:"setting"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+}
+
+// Reloader reloads the configuration and applies the settings that changed.
+// Settings that are not registered keep the values they were loaded with at
+// startup.
+type Reloader struct {
+	load     func() (*Config, error)
+	settings []Setting
+
+	// values holds the value each setting was last applied with
+	values map[string]interface{}
+	mutex  sync.Mutex
+}
+
+// NewReloader creates a Reloader loading the configuration with load and
+// applying the settings, whose current values are read from cfg
+func NewReloader(load func() (*Config, error), cfg *Config, settings ...Setting) *Reloader {
+	r := &Reloader{
+		load:     load,
+		settings: settings,
+		values:   make(map[string]interface{}, len(settings)),
+	}
+	for _, setting := range settings {
+		r.values[setting.Name] = setting.Value(cfg)
+	}
+	return r
+}
+
+// Reload loads the configuration and applies the settings whose value
+// changed, returning the changes applied. A setting that fails to apply keeps
+// its value and is reported in the error, the other settings still being
+// applied.
+func (r *Reloader) Reload() ([]Change, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	var changes []Change
+	var errs []error
+	for _, setting := range r.settings {
+		old, value := r.values[setting.Name], setting.Value(cfg)
+		if reflect.DeepEqual(old, value) {
+			continue
+		}
+		if err := setting.Apply(cfg); err != nil {
+			errs = append(errs, fmt.Errorf("failed to apply %s: %w", setting.Name, err))
+			continue
+		}
+		r.values[setting.Name] = value
+		changes = append(changes, Change{Setting: setting.Name, Old: old, New: value})
+	}
+	return changes, errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReloader(t *testing.T) {
+	loaded := &Config{Logging: LoggingConfig{Level: "info"}, WebSocket: WebSocketConfig{MessageRateLimit: 10}}
+	var applied []string
+	failRate := false
+
+	settings := []Setting{
+		{
+			Name:  "logging.level",
+			Value: func(cfg *Config) interface{} { return cfg.Logging.Level },
+			Apply: func(cfg *Config) error {
+				applied = append(applied, "level="+cfg.Logging.Level)
+				return nil
+			},
+		},
+		{
+			Name:  "websocket.messageRateLimit",
+			Value: func(cfg *Config) interface{} { return cfg.WebSocket.MessageRateLimit },
+			Apply: func(cfg *Config) error {
+				if failRate {
+					return errors.New("rejected")
+				}
+				applied = append(applied, "rate")
+				return nil
+			},
+		},
+	}
+	current := *loaded
+	r := NewReloader(func() (*Config, error) {
+		cfg := *loaded
+		return &cfg, nil
+	}, &current, settings...)
+
+	// Unchanged settings are not applied
+	if changes, err := r.Reload(); err != nil || len(changes) != 0 || len(applied) != 0 {
+		t.Fatalf("Expected no changes, got %v (applied %v, error %v)", changes, applied, err)
+	}
+
+	loaded.Logging.Level = "debug"
+	changes, err := r.Reload()
+	if err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	expected := []Change{{Setting: "logging.level", Old: "info", New: "debug"}}
+	if !reflect.DeepEqual(changes, expected) || !reflect.DeepEqual(applied, []string{"level=debug"}) {
+		t.Errorf("Expected changes %v, got %v (applied %v)", expected, changes, applied)
+	}
+
+	// A setting that fails to apply keeps its value and is retried on the next reload
+	loaded.WebSocket.MessageRateLimit = 20
+	failRate = true
+	if changes, err := r.Reload(); err == nil || len(changes) != 0 {
+		t.Errorf("Expected the failed setting to be reported, got %v and %v", changes, err)
+	}
+	failRate = false
+	changes, _ = r.Reload()
+	expected = []Change{{Setting: "websocket.messageRateLimit", Old: 10, New: 20}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+}
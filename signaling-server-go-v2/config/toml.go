@@ -0,0 +1,439 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses the TOML of a config file as nested maps by key. Values
+// are strings, int64, float64, booleans, []any and map[string]any. The
+// subset of TOML config files need is supported: tables, arrays of tables,
+// dotted and quoted keys, inline tables and arrays spanning lines, but not
+// multi-line strings or dates.
+func parseTOML(data []byte) (map[string]any, error) {
+	p := &tomlParser{data: string(data), line: 1}
+	root := make(map[string]any)
+	current := root
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			array := p.consume('[')
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume(']') || (array && !p.consume(']')) {
+				return nil, p.errorf("expected ] after table name %s", strings.Join(keys, "."))
+			}
+			if current, err = p.table(root, keys, array); err != nil {
+				return nil, err
+			}
+		} else {
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume('=') {
+				return nil, p.errorf("expected = after key %s", strings.Join(keys, "."))
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.set(current, keys, value); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tomlParser reads TOML from its position, counting lines for errors
+type tomlParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+// consume skips spaces, then c if it is next, reporting whether it was
+func (p *tomlParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.peek() != c {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// skipSpace skips spaces and tabs
+func (p *tomlParser) skipSpace() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, line breaks and comments
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endLine skips the rest of a line, which may only hold a comment
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	if p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return p.errorf("expected the end of the line, got %q", p.rest())
+	}
+	p.pos++
+	p.line++
+	return nil
+}
+
+// rest returns the rest of the current line, for errors
+func (p *tomlParser) rest() string {
+	end := strings.IndexByte(p.data[p.pos:], '\n')
+	if end < 0 {
+		return p.data[p.pos:]
+	}
+	return strings.TrimRight(p.data[p.pos:p.pos+end], "\r")
+}
+
+// key reads a key of bare or quoted parts separated by dots
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		var part string
+		switch c := p.peek(); {
+		case c == '"':
+			s, err := p.basicString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		case c == '\'':
+			s, err := p.literalString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a key, got %q", p.rest())
+			}
+			part = p.data[start:p.pos]
+		}
+		keys = append(keys, part)
+
+		p.skipSpace()
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// table returns the table of a [table] or [[array]] header, creating it
+func (p *tomlParser) table(root map[string]any, keys []string, array bool) (map[string]any, error) {
+	parent, err := p.parent(root, keys)
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+
+	if array {
+		var tables []any
+		if existing, ok := parent[last]; ok {
+			if tables, ok = existing.([]any); !ok {
+				return nil, p.errorf("%s is not an array of tables", strings.Join(keys, "."))
+			}
+		}
+		table := make(map[string]any)
+		parent[last] = append(tables, table)
+		return table, nil
+	}
+
+	if existing, ok := parent[last]; ok {
+		table, ok := existing.(map[string]any)
+		if !ok {
+			return nil, p.errorf("%s is already set", strings.Join(keys, "."))
+		}
+		return table, nil
+	}
+	table := make(map[string]any)
+	parent[last] = table
+	return table, nil
+}
+
+// parent returns the table holding the last of the keys, creating the
+// tables before it. Arrays of tables stand for their last table.
+func (p *tomlParser) parent(table map[string]any, keys []string) (map[string]any, error) {
+	for i, key := range keys[:len(keys)-1] {
+		switch existing := table[key].(type) {
+		case nil:
+			next := make(map[string]any)
+			table[key] = next
+			table = next
+		case map[string]any:
+			table = existing
+		case []any:
+			last, ok := existing[len(existing)-1].(map[string]any)
+			if !ok {
+				return nil, p.errorf("%s is not a table", strings.Join(keys[:i+1], "."))
+			}
+			table = last
+		default:
+			return nil, p.errorf("%s is not a table", strings.Join(keys[:i+1], "."))
+		}
+	}
+	return table, nil
+}
+
+// set sets a dotted key of the table, which must not be set already
+func (p *tomlParser) set(table map[string]any, keys []string, value any) error {
+	parent, err := p.parent(table, keys)
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, ok := parent[last]; ok {
+		return p.errorf("%s is set twice", strings.Join(keys, "."))
+	}
+	parent[last] = value
+	return nil
+}
+
+// value reads a string, number, boolean, array or inline table
+func (p *tomlParser) value() (any, error) {
+	p.skipSpace()
+	switch c := p.peek(); c {
+	case '"':
+		if strings.HasPrefix(p.data[p.pos:], `"""`) {
+			return nil, p.errorf("multi-line strings are not supported")
+		}
+		return p.basicString()
+	case '\'':
+		if strings.HasPrefix(p.data[p.pos:], "'''") {
+			return nil, p.errorf("multi-line strings are not supported")
+		}
+		return p.literalString()
+	case '[':
+		return p.array()
+	case '{':
+		return p.inlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n,]}#", p.peek()) < 0 {
+		p.pos++
+	}
+	token := p.data[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, p.errorf("expected a value, got %q", p.rest())
+	}
+	if hasLeadingZero(token) {
+		return nil, p.errorf("%q has a leading zero", token)
+	}
+	if n, err := strconv.ParseInt(token, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(strings.ReplaceAll(token, "_", ""), 64); err == nil && !strings.ContainsAny(token, "xXpP") {
+		return f, nil
+	}
+	return nil, p.errorf("%q is not a supported value", token)
+}
+
+// hasLeadingZero reports whether a number has a leading zero, which TOML
+// forbids and strconv would read as octal
+func hasLeadingZero(token string) bool {
+	token = strings.TrimLeft(token, "+-")
+	return len(token) > 1 && token[0] == '0' && token[1] >= '0' && token[1] <= '9'
+}
+
+// array reads an array, whose values may span lines
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++
+	values := make([]any, 0)
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected , or ] in array, got %q", p.rest())
+		}
+	}
+}
+
+// inlineTable reads an inline table, on a single line
+func (p *tomlParser) inlineTable() (map[string]any, error) {
+	p.pos++
+	table := make(map[string]any)
+	if p.consume('}') {
+		return table, nil
+	}
+	for {
+		keys, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume('=') {
+			return nil, p.errorf("expected = after key %s", strings.Join(keys, "."))
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.set(table, keys, value); err != nil {
+			return nil, err
+		}
+
+		if p.consume('}') {
+			return table, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected , or } in inline table, got %q", p.rest())
+		}
+	}
+}
+
+// literalString reads a single-quoted string, which has no escapes
+func (p *tomlParser) literalString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.data[p.pos:], "'\n")
+	if end < 0 || p.data[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.data[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// basicString reads a double-quoted string, unescaping it
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			escape := p.data[p.pos]
+			p.pos++
+			switch escape {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(escape)
+			case 'u', 'U':
+				size := 4
+				if escape == 'U' {
+					size = 8
+				}
+				if p.pos+size > len(p.data) {
+					return "", p.errorf("invalid escape \\%c", escape)
+				}
+				code, err := strconv.ParseUint(p.data[p.pos:p.pos+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", p.errorf("invalid escape \\%c%s", escape, p.data[p.pos:p.pos+size])
+				}
+				b.WriteRune(rune(code))
+				p.pos += size
+			default:
+				return "", p.errorf("invalid escape \\%c", escape)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML([]byte(`# Server configuration
+title = "signaling \"v2\"\u00e9"
+path = 'C:\logs'
+
+[server]
+port = 8_080
+trustedProxies = [
+  "10.0.0.0/8", # the cluster network
+  "192.168.0.0/16",
+]
+tls.enabled = true
+
+[quality]
+threshold = 3.5
+
+[websocket.compressionThresholds]
+"ice-candidate" = -1
+
+[[signaling.namespacePolicies]]
+namespace = "acme"
+maxPeers = 4
+
+[[signaling.namespacePolicies]]
+namespace = "globex"
+limits = { rooms = 10, peers = [1, 2] }
+`))
+	if err != nil {
+		t.Fatalf("parseTOML failed: %v", err)
+	}
+
+	expected := map[string]any{
+		"title": "signaling \"v2\"é",
+		"path":  `C:\logs`,
+		"server": map[string]any{
+			"port":           int64(8080),
+			"trustedProxies": []any{"10.0.0.0/8", "192.168.0.0/16"},
+			"tls":            map[string]any{"enabled": true},
+		},
+		"quality":   map[string]any{"threshold": 3.5},
+		"websocket": map[string]any{"compressionThresholds": map[string]any{"ice-candidate": int64(-1)}},
+		"signaling": map[string]any{"namespacePolicies": []any{
+			map[string]any{"namespace": "acme", "maxPeers": int64(4)},
+			map[string]any{"namespace": "globex", "limits": map[string]any{"rooms": int64(10), "peers": []any{int64(1), int64(2)}}},
+		}},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing value":     "port =\n",
+		"duplicate key":     "port = 1\nport = 2\n",
+		"trailing data":     "port = 1 2\n",
+		"unterminated":      "host = \"localhost\n",
+		"leading zero":      "port = 08080\n",
+		"date":              "since = 1979-05-27\n",
+		"multi-line string": "motd = \"\"\"\nhello\n\"\"\"\n",
+		"table over value":  "server = 1\n[server]\n",
+		"unclosed array":    "urls = [\"a\"\n",
+		"unclosed header":   "[server\n",
+	}
+	for name, doc := range tests {
+		if _, err := parseTOML([]byte(doc)); err == nil {
+			t.Errorf("%s: expected %q to be rejected", name, doc)
+		}
+	}
+}
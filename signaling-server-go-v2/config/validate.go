@@ -0,0 +1,291 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError lists every problem found in a configuration, so that they
+// can all be fixed before the next start
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	noun := "problems"
+	if len(e.Problems) == 1 {
+		noun = "problem"
+	}
+	return fmt.Sprintf("%d configuration %s:\n  - %s", len(e.Problems), noun, strings.Join(e.Problems, "\n  - "))
+}
+
+// Validate checks the configuration, returning a *ValidationError listing
+// the environment variables that could not be parsed, which LoadConfig
+// replaced with their defaults, and the settings that are out of range,
+// unknown or missing a setting they require
+func (c *Config) Validate() error {
+	v := &validator{problems: append([]string(nil), c.problems...)}
+
+	v.port("SERVER_PORT", c.Server.Port)
+	v.nonNegative("SERVER_SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout)
+	v.nonNegative("SERVER_READ_TIMEOUT", c.Server.ReadTimeout)
+	v.nonNegative("SERVER_WRITE_TIMEOUT", c.Server.WriteTimeout)
+	v.nonNegative("SERVER_IDLE_TIMEOUT", c.Server.IdleTimeout)
+	v.nonNegative("SERVER_PROXY_IDLE_TIMEOUT", c.Server.ProxyIdleTimeout)
+	if c.Debug.Enabled {
+		v.port("DEBUG_PORT", c.Debug.Port)
+		v.distinctPorts("DEBUG_PORT", c.Debug.Port, "SERVER_PORT", c.Server.Port)
+	}
+	if c.GRPC.Enabled {
+		v.port("GRPC_PORT", c.GRPC.Port)
+		v.distinctPorts("GRPC_PORT", c.GRPC.Port, "SERVER_PORT", c.Server.Port)
+		if c.Debug.Enabled {
+			v.distinctPorts("GRPC_PORT", c.GRPC.Port, "DEBUG_PORT", c.Debug.Port)
+		}
+	}
+
+	if tls := c.Server.TLS; tls.Enabled {
+		v.requires("SERVER_TLS_ENABLED", "SERVER_TLS_CERT_FILE", tls.CertFile)
+		v.requires("SERVER_TLS_ENABLED", "SERVER_TLS_KEY_FILE", tls.KeyFile)
+		if tls.MinVersion != "" {
+			v.oneOf("SERVER_TLS_MIN_VERSION", tls.MinVersion, "1.2", "1.3")
+		}
+		v.oneOf("SERVER_TLS_CLIENT_AUTH", strings.ToLower(tls.ClientAuth), "", "none", "optional", "require")
+		if mode := strings.ToLower(tls.ClientAuth); mode == "optional" || mode == "require" {
+			v.requires("SERVER_TLS_CLIENT_AUTH="+mode, "SERVER_TLS_CLIENT_CA_FILE", tls.ClientCAFile)
+		}
+	}
+
+	v.oneOf("LOGGING_LEVEL", strings.ToLower(c.Logging.Level), "debug", "info", "warn", "error")
+	v.oneOf("LOGGING_FORMAT", c.Logging.Format, "json", "text")
+	v.oneOf("LOGGING_BACKEND", c.Logging.Backend, "kit", "zerolog")
+	for msg, every := range c.Logging.Sampling {
+		if every < 1 {
+			v.add("LOGGING_SAMPLING: %d for %q must be positive", every, msg)
+		}
+	}
+	for _, output := range c.Logging.Outputs {
+		v.oneOf("LOGGING_OUTPUTS", strings.ToLower(output), "stdout", "file", "syslog")
+	}
+	v.nonNegative("LOGGING_FILE_ROTATE_HOURS", c.Logging.File.RotateHours)
+	for _, gate := range c.Monitoring.ReadinessGates {
+		v.oneOf("MONITORING_READINESS_GATES", gate, "stores", "cluster")
+	}
+	if len(c.Monitoring.ReadinessGates) > 0 {
+		v.positive("MONITORING_WARM_UP_INTERVAL", c.Monitoring.WarmUpInterval)
+	}
+	if c.Tracing.Enabled {
+		v.oneOf("TRACING_EXPORTER", c.Tracing.Exporter, "otlp", "jaeger", "zipkin")
+	}
+
+	ws := c.WebSocket
+	v.nonNegative("WEBSOCKET_PING_INTERVAL", ws.PingInterval)
+	v.nonNegative("WEBSOCKET_WRITE_WAIT", ws.WriteWait)
+	if ws.PingInterval > 0 && ws.PongWait <= ws.PingInterval {
+		v.add("WEBSOCKET_PONG_WAIT: %d must exceed WEBSOCKET_PING_INTERVAL (%d), or connections are reaped between pings", ws.PongWait, ws.PingInterval)
+	}
+	v.nonNegative("WEBSOCKET_LOW_POWER_PING_INTERVAL", ws.LowPowerPingInterval)
+	if ws.LowPowerPingInterval > 0 && ws.LowPowerPongWait <= ws.LowPowerPingInterval {
+		v.add("WEBSOCKET_LOW_POWER_PONG_WAIT: %d must exceed WEBSOCKET_LOW_POWER_PING_INTERVAL (%d), or connections are reaped between pings", ws.LowPowerPongWait, ws.LowPowerPingInterval)
+	}
+	v.nonNegative("WEBSOCKET_SEND_LATENCY_SAMPLING", ws.SendLatencySampling)
+	v.positive("WEBSOCKET_SEND_BUFFER_SIZE", ws.SendBufferSize)
+	if ws.SendMemoryBudget < 0 {
+		v.add("WEBSOCKET_SEND_MEMORY_BUDGET: %d must not be negative", ws.SendMemoryBudget)
+	} else if ws.SendMemoryBudget > 0 && ws.SendMemoryBudget < ws.MaxMessageSize {
+		v.add("WEBSOCKET_SEND_MEMORY_BUDGET: %d is below WEBSOCKET_MAX_MESSAGE_SIZE (%d), or large messages are always shed", ws.SendMemoryBudget, ws.MaxMessageSize)
+	}
+	v.oneOf("WEBSOCKET_BACKPRESSURE_POLICY", ws.BackpressurePolicy, "disconnect", "drop")
+	if ws.MaxMessageSize <= 0 {
+		v.add("WEBSOCKET_MAX_MESSAGE_SIZE: %d must be positive", ws.MaxMessageSize)
+	}
+	v.oneOf("WEBSOCKET_RATE_LIMIT_BACKEND", ws.RateLimitBackend, "memory", "redis")
+	v.oneOf("WEBSOCKET_REPLAY_CACHE_BACKEND", ws.ReplayCacheBackend, "memory", "redis")
+	for _, encoding := range ws.Encodings {
+		v.oneOf("WEBSOCKET_ENCODINGS", encoding, "protobuf", "msgpack")
+	}
+	if ws.CompressionLevel < -2 || ws.CompressionLevel > 9 {
+		v.add("WEBSOCKET_COMPRESSION_LEVEL: %d is not between -2 and 9", ws.CompressionLevel)
+	}
+	for _, source := range ws.IdentitySources {
+		v.oneOf("WEBSOCKET_IDENTITY_SOURCES", source, "certificate", "token", "apiKey")
+		switch source {
+		case "token":
+			v.requires("WEBSOCKET_IDENTITY_SOURCES=token", "WEBSOCKET_IDENTITY_TOKEN_SECRET", ws.IdentityTokenSecret)
+		case "apiKey":
+			if len(ws.IdentityAPIKeys) == 0 {
+				v.add("WEBSOCKET_IDENTITY_SOURCES=apiKey requires WEBSOCKET_IDENTITY_API_KEYS")
+			}
+		}
+	}
+	for i, entry := range ws.IdentityAPIKeys {
+		// The entry is not quoted, it holds a key
+		if owner, key, ok := strings.Cut(entry, ":"); !ok || owner == "" || key == "" {
+			v.add("WEBSOCKET_IDENTITY_API_KEYS: entry %d is not of the form owner:key", i+1)
+		}
+	}
+	v.oneOf("WEBSOCKET_IDENTITY_CONFLICT", ws.IdentityConflict, "replace", "reject", "multiDevice")
+	for _, source := range ws.TenantSources {
+		v.oneOf("WEBSOCKET_TENANT_SOURCES", source, "claim", "path")
+		if source == "claim" {
+			v.requires("WEBSOCKET_TENANT_SOURCES=claim", "WEBSOCKET_IDENTITY_TOKEN_SECRET", ws.IdentityTokenSecret)
+		}
+	}
+	v.nonNegative("WEBSOCKET_TENANT_MESSAGE_RATE_LIMIT", ws.TenantMessageRateLimit)
+	if ws.ReauthorizeURL != "" {
+		v.nonNegative("WEBSOCKET_REAUTHORIZE_INTERVAL", ws.ReauthorizeInterval)
+		v.positive("WEBSOCKET_REAUTHORIZE_TIMEOUT", ws.ReauthorizeTimeout)
+	}
+
+	v.nonNegative("SIGNALING_CLOSED_ROOM_RETENTION", c.Signaling.ClosedRoomRetention)
+	v.oneOf("SIGNALING_DISPLAY_NAME_COLLISION", c.Signaling.DisplayNameCollision, "suffix", "reject")
+	v.oneOf("SIGNALING_ENVELOPE_TIMESTAMP_FORMAT", c.Signaling.EnvelopeTimestampFormat, "rfc3339", "unix_ms")
+	if c.Signaling.MaxResidentRooms > 0 {
+		v.requires("SIGNALING_MAX_RESIDENT_ROOMS", "SIGNALING_SPILL_PATH", c.Signaling.SpillPath)
+	}
+
+	if c.Admin.Enabled {
+		v.requires("ADMIN_ENABLED", "ADMIN_TOKEN", c.Admin.Token)
+	}
+	for i, entry := range c.Admin.TenantTokens {
+		// The entry is not quoted, it holds a token
+		if tenant, token, ok := strings.Cut(entry, ":"); !ok || tenant == "" || token == "" {
+			v.add("ADMIN_TENANT_TOKENS: entry %d is not of the form tenant:token", i+1)
+		}
+	}
+
+	v.positive("EXPORTERS_BUFFER_SIZE", c.Exporters.BufferSize)
+	v.positive("EXPORTERS_FAILURE_THRESHOLD", c.Exporters.FailureThreshold)
+	if kafka := c.Events.Kafka; kafka.Enabled {
+		v.requires("EVENTS_KAFKA_ENABLED", "EVENTS_KAFKA_BROKERS", strings.Join(kafka.Brokers, ","))
+		v.requires("EVENTS_KAFKA_ENABLED", "EVENTS_KAFKA_TOPIC", kafka.Topic)
+		v.positive("EVENTS_KAFKA_BATCH_SIZE", kafka.BatchSize)
+	}
+	if webhooks := c.Events.Webhooks; webhooks.Enabled {
+		v.requires("EVENTS_WEBHOOKS_ENABLED", "EVENTS_WEBHOOKS_URLS", strings.Join(webhooks.URLs, ","))
+		if webhooks.MaxRetryBackoff < webhooks.RetryBackoff {
+			v.add("EVENTS_WEBHOOKS_MAX_RETRY_BACKOFF: %d is below EVENTS_WEBHOOKS_RETRY_BACKOFF (%d)", webhooks.MaxRetryBackoff, webhooks.RetryBackoff)
+		}
+	}
+	if c.Events.RoomWebhooks.Enabled {
+		v.requires("EVENTS_ROOM_WEBHOOKS_ENABLED", "EVENTS_ROOM_WEBHOOKS_STORE_PATH", c.Events.RoomWebhooks.StorePath)
+	}
+	if warehouse := c.Events.Warehouse; warehouse.Enabled {
+		v.oneOf("EVENTS_WAREHOUSE_BACKEND", warehouse.Backend, "clickhouse", "bigquery", "s3")
+		switch warehouse.Backend {
+		case "clickhouse":
+			v.requires("EVENTS_WAREHOUSE_BACKEND=clickhouse", "EVENTS_WAREHOUSE_CLICKHOUSE_URL", warehouse.ClickHouse.URL)
+			v.requires("EVENTS_WAREHOUSE_BACKEND=clickhouse", "EVENTS_WAREHOUSE_CLICKHOUSE_TABLE", warehouse.ClickHouse.Table)
+		case "bigquery":
+			v.requires("EVENTS_WAREHOUSE_BACKEND=bigquery", "EVENTS_WAREHOUSE_BIGQUERY_PROJECT", warehouse.BigQuery.Project)
+		case "s3":
+			v.requires("EVENTS_WAREHOUSE_BACKEND=s3", "EVENTS_WAREHOUSE_S3_BUCKET", warehouse.S3.Bucket)
+		}
+		v.positive("EVENTS_WAREHOUSE_INTERVAL", warehouse.Interval)
+		v.positive("EVENTS_WAREHOUSE_BATCH_SIZE", warehouse.BatchSize)
+		v.requires("EVENTS_WAREHOUSE_ENABLED", "EVENTS_WAREHOUSE_OUTBOX_PATH", warehouse.OutboxPath)
+	}
+	v.positive("MIGRATIONS_LOCK_TIMEOUT", c.Migrations.LockTimeout)
+	if c.Audit.Enabled {
+		v.requires("AUDIT_ENABLED", "AUDIT_FILE_PATH", c.Audit.File.Path)
+		v.positive("AUDIT_BUFFER_SIZE", c.Audit.BufferSize)
+		v.nonNegative("AUDIT_FILE_ROTATE_HOURS", c.Audit.File.RotateHours)
+	}
+
+	v.oneOf("CLUSTER_BACKEND", c.Cluster.Backend, "", "redis", "nats")
+	switch c.Cluster.Backend {
+	case "redis":
+		v.requires("CLUSTER_BACKEND=redis", "CLUSTER_REDIS_ADDRESS", c.Cluster.Redis.Address)
+	case "nats":
+		v.requires("CLUSTER_BACKEND=nats", "CLUSTER_NATS_URL", c.Cluster.NATS.URL)
+	}
+	if c.Cluster.AtLeastOnce {
+		v.positive("CLUSTER_RELAY_RETRY_INTERVAL", c.Cluster.RelayRetryInterval)
+		v.positive("CLUSTER_RELAY_MAX_ATTEMPTS", c.Cluster.RelayMaxAttempts)
+	}
+	if c.Cluster.Sharding.Enabled {
+		v.requires("CLUSTER_SHARDING_ENABLED", "CLUSTER_BACKEND", c.Cluster.Backend)
+		v.oneOf("CLUSTER_SHARDING_MEMBERSHIP", c.Cluster.Sharding.Membership, "redis", "memberlist")
+		switch c.Cluster.Sharding.Membership {
+		case "redis":
+			v.requires("CLUSTER_SHARDING_MEMBERSHIP=redis", "CLUSTER_REDIS_ADDRESS", c.Cluster.Redis.Address)
+		case "memberlist":
+			v.requires("CLUSTER_SHARDING_MEMBERSHIP=memberlist", "CLUSTER_SHARDING_MEMBERLIST_BIND_ADDRESS", c.Cluster.Sharding.Memberlist.BindAddress)
+		}
+		v.positive("CLUSTER_SHARDING_REPLICAS", c.Cluster.Sharding.Replicas)
+		if c.Cluster.Sharding.MemberTTL <= c.Cluster.HealthCheckInterval {
+			v.add("CLUSTER_SHARDING_MEMBER_TTL: %d must exceed CLUSTER_HEALTH_CHECK_INTERVAL (%d), or instances drop off the ring between renewals", c.Cluster.Sharding.MemberTTL, c.Cluster.HealthCheckInterval)
+		}
+	}
+
+	if c.LongPoll.Enabled {
+		v.positive("LONGPOLL_POLL_TIMEOUT", c.LongPoll.PollTimeout)
+		if c.LongPoll.IdleTimeout <= c.LongPoll.PollTimeout {
+			v.add("LONGPOLL_IDLE_TIMEOUT: %d must exceed LONGPOLL_POLL_TIMEOUT (%d), or sessions close while polling", c.LongPoll.IdleTimeout, c.LongPoll.PollTimeout)
+		}
+	}
+	if c.Quality.Enabled && (c.Quality.Threshold < 1 || c.Quality.Threshold > 4.5) {
+		v.add("QUALITY_THRESHOLD: %g is not between 1 and 4.5", c.Quality.Threshold)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validator collects the problems found by Validate
+type validator struct {
+	problems []string
+}
+
+func (v *validator) add(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) port(key string, port int) {
+	if port < 1 || port > 65535 {
+		v.add("%s: %d is not a port between 1 and 65535", key, port)
+	}
+}
+
+func (v *validator) distinctPorts(key string, port int, otherKey string, otherPort int) {
+	if port == otherPort {
+		v.add("%s: %d is already used by %s", key, port, otherKey)
+	}
+}
+
+func (v *validator) nonNegative(key string, value int) {
+	if value < 0 {
+		v.add("%s: %d must not be negative", key, value)
+	}
+}
+
+func (v *validator) positive(key string, value int) {
+	if value <= 0 {
+		v.add("%s: %d must be positive", key, value)
+	}
+}
+
+// requires reports a setting that must be set when another one is
+func (v *validator) requires(setting, key, value string) {
+	if value == "" {
+		v.add("%s: required with %s", key, setting)
+	}
+}
+
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+
+	var names []string
+	for _, a := range allowed {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	v.add("%s: %q is not one of %s", key, value, strings.Join(names, ", "))
+}
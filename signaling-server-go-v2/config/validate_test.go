@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateDefaults(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the defaults to be valid, got %v", err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	env := map[string]string{
+		"SERVER_PORT":                  "80a",
+		"DEBUG_ENABLED":                "ture",
+		"WEBSOCKET_COMPRESSION_LEVEL":  "12",
+		"SERVER_TLS_ENABLED":           "true",
+		"SERVER_TLS_CERT_FILE":         "server.crt",
+		"SIGNALING_NAMESPACE_POLICIES": "acme:4,broken",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Server.Port != 8080 {
+		t.Errorf("Expected a malformed port to fall back to the default, got %d", cfg.Server.Port)
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(cfg.Validate(), &validationErr) {
+		t.Fatal("Expected a validation error")
+	}
+	expected := []string{
+		`SERVER_PORT: "80a" is not an integer`,
+		`SIGNALING_NAMESPACE_POLICIES: "broken" is not of the form namespace:maxPeers[:requirePassword[:maxDuration[:observerJoins]]]`,
+		`DEBUG_ENABLED: "ture" is not a boolean`,
+		"SERVER_TLS_KEY_FILE: required with SERVER_TLS_ENABLED",
+		"WEBSOCKET_COMPRESSION_LEVEL: 12 is not between -2 and 9",
+	}
+	if !reflect.DeepEqual(validationErr.Problems, expected) {
+		t.Errorf("Expected problems %q, got %q", expected, validationErr.Problems)
+	}
+	if !strings.Contains(validationErr.Error(), "5 configuration problems") {
+		t.Errorf("Expected the error to count the problems, got %s", validationErr.Error())
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		problem string
+	}{
+		{"port out of range", func(cfg *Config) { cfg.Server.Port = 70000 }, "SERVER_PORT: 70000 is not a port between 1 and 65535"},
+		{"ports in conflict", func(cfg *Config) { cfg.GRPC.Enabled, cfg.GRPC.Port = true, cfg.Server.Port }, "GRPC_PORT: 8080 is already used by SERVER_PORT"},
+		{"negative timeout", func(cfg *Config) { cfg.Server.ReadTimeout = -1 }, "SERVER_READ_TIMEOUT: -1 must not be negative"},
+		{"pong before ping", func(cfg *Config) { cfg.WebSocket.PongWait = 20 }, "WEBSOCKET_PONG_WAIT: 20 must exceed WEBSOCKET_PING_INTERVAL (30), or connections are reaped between pings"},
+		{"client auth without CA", func(cfg *Config) {
+			cfg.Server.TLS = TLSConfig{Enabled: true, CertFile: "c", KeyFile: "k", ClientAuth: "require"}
+		}, "SERVER_TLS_CLIENT_CA_FILE: required with SERVER_TLS_CLIENT_AUTH=require"},
+		{"unknown exporter", func(cfg *Config) { cfg.Tracing.Exporter = "datadog" }, `TRACING_EXPORTER: "datadog" is not one of otlp, jaeger, zipkin`},
+		{"unknown backend", func(cfg *Config) { cfg.Cluster.Backend = "etcd" }, `CLUSTER_BACKEND: "etcd" is not one of redis, nats`},
+		{"sharding without backend", func(cfg *Config) { cfg.Cluster.Sharding.Enabled = true }, "CLUSTER_BACKEND: required with CLUSTER_SHARDING_ENABLED"},
+		{"member TTL below health checks", func(cfg *Config) {
+			cfg.Cluster.Backend, cfg.Cluster.Sharding.Enabled, cfg.Cluster.Sharding.MemberTTL = "nats", true, 5
+		}, "CLUSTER_SHARDING_MEMBER_TTL: 5 must exceed CLUSTER_HEALTH_CHECK_INTERVAL (5), or instances drop off the ring between renewals"},
+		{"unknown readiness gate", func(cfg *Config) { cfg.Monitoring.ReadinessGates = []string{"jwks"} }, `MONITORING_READINESS_GATES: "jwks" is not one of stores, cluster`},
+		{"send budget below message size", func(cfg *Config) { cfg.WebSocket.SendMemoryBudget = 1024 }, "WEBSOCKET_SEND_MEMORY_BUDGET: 1024 is below WEBSOCKET_MAX_MESSAGE_SIZE (1048576), or large messages are always shed"},
+		{"unknown backpressure policy", func(cfg *Config) { cfg.WebSocket.BackpressurePolicy = "block" }, `WEBSOCKET_BACKPRESSURE_POLICY: "block" is not one of disconnect, drop`},
+		{"token identity without secret", func(cfg *Config) { cfg.WebSocket.IdentitySources = []string{"certificate", "token"} }, "WEBSOCKET_IDENTITY_TOKEN_SECRET: required with WEBSOCKET_IDENTITY_SOURCES=token"},
+		{"malformed API key", func(cfg *Config) {
+			cfg.WebSocket.IdentitySources, cfg.WebSocket.IdentityAPIKeys = []string{"apiKey"}, []string{"alice:k1", "k2"}
+		}, "WEBSOCKET_IDENTITY_API_KEYS: entry 2 is not of the form owner:key"},
+		{"unknown identity conflict", func(cfg *Config) { cfg.WebSocket.IdentityConflict = "queue" }, `WEBSOCKET_IDENTITY_CONFLICT: "queue" is not one of replace, reject, multiDevice`},
+		{"claim tenant without secret", func(cfg *Config) { cfg.WebSocket.TenantSources = []string{"claim", "path"} }, "WEBSOCKET_IDENTITY_TOKEN_SECRET: required with WEBSOCKET_TENANT_SOURCES=claim"},
+		{"unknown tenant source", func(cfg *Config) { cfg.WebSocket.TenantSources = []string{"header"} }, `WEBSOCKET_TENANT_SOURCES: "header" is not one of claim, path`},
+		{"malformed tenant token", func(cfg *Config) { cfg.Admin.TenantTokens = []string{"acme"} }, "ADMIN_TENANT_TOKENS: entry 1 is not of the form tenant:token"},
+		{"warehouse without bucket", func(cfg *Config) {
+			cfg.Events.Warehouse.Enabled, cfg.Events.Warehouse.Backend = true, "s3"
+		}, "EVENTS_WAREHOUSE_S3_BUCKET: required with EVENTS_WAREHOUSE_BACKEND=s3"},
+		{"admin without token", func(cfg *Config) { cfg.Admin.Enabled = true }, "ADMIN_TOKEN: required with ADMIN_ENABLED"},
+		{"kafka without topic", func(cfg *Config) { cfg.Events.Kafka.Enabled, cfg.Events.Kafka.Topic = true, "" }, "EVENTS_KAFKA_TOPIC: required with EVENTS_KAFKA_ENABLED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := LoadConfig("")
+			tt.modify(cfg)
+
+			var validationErr *ValidationError
+			if !errors.As(cfg.Validate(), &validationErr) {
+				t.Fatal("Expected a validation error")
+			}
+			if !reflect.DeepEqual(validationErr.Problems, []string{tt.problem}) {
+				t.Errorf("Expected problem %q, got %q", tt.problem, validationErr.Problems)
+			}
+		})
+	}
+}
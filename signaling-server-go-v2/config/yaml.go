@@ -0,0 +1,99 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// YAML encodes the configuration as YAML with the keys of config/default.yaml.
+// Strings are always double-quoted, so that values such as "1.2" or "no"
+// keep their type when read back.
+func (c Config) YAML() []byte {
+	var buf bytes.Buffer
+	writeYAMLFields(&buf, reflect.ValueOf(c), 0)
+	return buf.Bytes()
+}
+
+// writeYAMLFields writes the exported fields of a struct as a block mapping
+func writeYAMLFields(buf *bytes.Buffer, v reflect.Value, indent int) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		writeYAMLEntry(buf, settingKey(field), v.Field(i), indent)
+	}
+}
+
+// writeYAMLEntry writes a key and its value at the indent
+func writeYAMLEntry(buf *bytes.Buffer, key string, v reflect.Value, indent int) {
+	prefix := strings.Repeat(" ", indent)
+	switch v.Kind() {
+	case reflect.Struct:
+		fmt.Fprintf(buf, "%s%s:\n", prefix, key)
+		writeYAMLFields(buf, v, indent+2)
+
+	case reflect.Map:
+		if v.Len() == 0 {
+			fmt.Fprintf(buf, "%s%s: {}\n", prefix, key)
+			return
+		}
+		fmt.Fprintf(buf, "%s%s:\n", prefix, key)
+		keys := make([]string, 0, v.Len())
+		values := make(map[string]reflect.Value, v.Len())
+		for _, k := range v.MapKeys() {
+			name := fmt.Sprint(k.Interface())
+			keys = append(keys, name)
+			values[name] = v.MapIndex(k)
+		}
+		sort.Strings(keys)
+		for _, name := range keys {
+			writeYAMLEntry(buf, yamlScalar(reflect.ValueOf(name)), values[name], indent+2)
+		}
+
+	case reflect.Slice:
+		if v.Len() == 0 {
+			fmt.Fprintf(buf, "%s%s: []\n", prefix, key)
+			return
+		}
+		if v.Type().Elem().Kind() != reflect.Struct {
+			items := make([]string, v.Len())
+			for i := range items {
+				items[i] = yamlScalar(v.Index(i))
+			}
+			fmt.Fprintf(buf, "%s%s: [%s]\n", prefix, key, strings.Join(items, ", "))
+			return
+		}
+
+		// Structs are written as block sequences of mappings, the first
+		// field of each on the line of its dash
+		fmt.Fprintf(buf, "%s%s:\n", prefix, key)
+		for i := 0; i < v.Len(); i++ {
+			var item bytes.Buffer
+			writeYAMLFields(&item, v.Index(i), indent+4)
+			lines := item.Bytes()
+			buf.WriteString(prefix + "  - ")
+			buf.Write(lines[indent+4:])
+		}
+
+	default:
+		fmt.Fprintf(buf, "%s%s: %s\n", prefix, key, yamlScalar(v))
+	}
+}
+
+// yamlScalar formats a string, number or boolean
+func yamlScalar(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYAML(t *testing.T) {
+	cfg := Config{
+		Server: ServerConfig{Port: 8080, Host: "0.0.0.0", TrustedProxies: []string{"10.0.0.0/8"}},
+		WebSocket: WebSocketConfig{
+			CompressionThresholds: map[string]int{"offer": 0, "ice-candidate": -1},
+		},
+		Quality: QualityConfig{Threshold: 3.5},
+		Signaling: SignalingConfig{
+			NamespacePolicies: []NamespacePolicyConfig{{Namespace: "acme", MaxPeers: 4, RequirePassword: true}},
+			RoomCloseWarnings: []int{300, 60},
+		},
+	}
+
+	yaml := string(cfg.YAML())
+	expected := []string{
+		"server:\n  port: 8080\n  host: \"0.0.0.0\"\n",
+		"  trustedProxies: [\"10.0.0.0/8\"]\n",
+		"  compressionThresholds:\n    \"ice-candidate\": -1\n    \"offer\": 0\n",
+		"  namespacePolicies:\n    - namespace: \"acme\"\n      maxPeers: 4\n      requirePassword: true\n",
+		"  roomCloseWarnings: [300, 60]\n",
+		"  allowedCodecs: []\n",
+		"quality:\n  enabled: false\n  threshold: 3.5\n",
+	}
+	for _, fragment := range expected {
+		if !strings.Contains(yaml, fragment) {
+			t.Errorf("Expected the YAML to contain %q, got:\n%s", fragment, yaml)
+		}
+	}
+}
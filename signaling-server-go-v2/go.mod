@@ -1,3 +1,11 @@
 module github.com/babakgh/tuesdays/signaling-server-go-v2
 
 go 1.21
+
+require (
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/crypto v0.31.0
+	pgregory.net/rapid v1.1.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
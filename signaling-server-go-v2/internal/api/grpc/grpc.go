@@ -0,0 +1,191 @@
+// Package grpc serves the signaling protocol over the bidirectional Signal
+// stream of the Signaling service in signaling.proto, for native and backend
+// clients that prefer gRPC over WebSocket. Streams are attached to the
+// WebSocket handler's client registry, so gRPC and WebSocket clients share
+// rooms through the same SignalingManager.
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	ws "github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// SignalMethod is the full method name of the Signal call
+const SignalMethod = "/tuesdays.signaling.v1.Signaling/Signal"
+
+// Stream is the server side of a Signal call, as implemented by the
+// generated Signaling_SignalServer
+type Stream interface {
+	Context() context.Context
+	Send(msg *protocol.Message) error
+	Recv() (*protocol.Message, error)
+}
+
+// Peer describes the client of a call
+type Peer struct {
+	Addr string
+
+	// Metadata holds the call's metadata, which are its HTTP/2 headers, e.g.
+	// the authorization header
+	Metadata http.Header
+
+	// TLS is the state of the client's TLS connection, nil without TLS
+	TLS *tls.ConnectionState
+}
+
+// peerKey is the context key of the Peer of a call
+type peerKey struct{}
+
+// ContextWithPeer returns a context carrying the peer of a call
+func ContextWithPeer(ctx context.Context, p Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// PeerFromContext returns the peer of the call whose context is given
+func PeerFromContext(ctx context.Context) (Peer, bool) {
+	// In a real implementation, this would combine peer.FromContext and
+	// metadata.FromIncomingContext
+	p, ok := ctx.Value(peerKey{}).(Peer)
+	return p, ok
+}
+
+// Server serves the Signaling service
+type Server struct {
+	addr     string
+	attacher ws.Attacher
+	logger   logging.Logger
+}
+
+// NewServer creates a Server listening on addr, attaching its streams to the WebSocket handler
+func NewServer(addr string, attacher ws.Attacher, logger logging.Logger) *Server {
+	return &Server{
+		addr:     addr,
+		attacher: attacher,
+		logger:   logger.With("component", "grpc"),
+	}
+}
+
+// Start starts serving the Signaling service
+func (s *Server) Start() error {
+	// In a real implementation, this would listen on addr and serve a
+	// grpc.Server with the Signaling service registered, using the server's
+	// TLS configuration and keepalive enforcement
+	s.logger.Info("gRPC signaling service listening", "address", s.addr)
+	return nil
+}
+
+// Shutdown stops accepting calls and waits for open streams to end until the context is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	// In a real implementation, this would GracefulStop the grpc.Server, and
+	// Stop it when the context is done first. Open streams end when the
+	// WebSocket handler drains its clients.
+	s.logger.Info("gRPC signaling service stopped")
+	return nil
+}
+
+// Signal implements the Signal call. Messages read from the stream are
+// handled as WebSocket messages of the attached client, and the messages sent
+// to the client are written to the stream, until either side ends it.
+func (s *Server) Signal(stream Stream) error {
+	ctx := stream.Context()
+	conn, err := s.attacher.Attach(request(ctx))
+	if err != nil {
+		// In a real implementation, this would be a RESOURCE_EXHAUSTED status for ErrTooManyConnections
+		return fmt.Errorf("failed to attach signaling stream: %w", err)
+	}
+	logger := s.logger.With("client_id", conn.ID())
+	logger.Info("Signaling stream opened")
+
+	writeCtx, stopWriting := context.WithCancel(ctx)
+	defer stopWriting()
+	written := make(chan error, 1)
+	go func() {
+		written <- s.write(writeCtx, stream, conn)
+	}()
+	received := make(chan error, 1)
+	go func() {
+		received <- s.receive(stream, conn)
+	}()
+
+	// Returning ends the call, which also ends a pending Recv
+	select {
+	case err = <-received:
+		stopWriting()
+		<-written
+	case err = <-written:
+	}
+	conn.Disconnect()
+
+	logger.Info("Signaling stream closed")
+	return err
+}
+
+// receive handles the messages read from the stream until the client ends it
+func (s *Server) receive(stream Stream, conn ws.Conn) error {
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		message, err := json.Marshal(msg)
+		if err != nil {
+			s.logger.Warn("Failed to marshal stream message", "error", err, "client_id", conn.ID())
+			continue
+		}
+		if err := conn.Receive(message); err != nil {
+			s.logger.Warn("Failed to handle stream message", "error", err, "client_id", conn.ID())
+		}
+	}
+}
+
+// write writes the messages sent to the client until its connection is closed
+func (s *Server) write(ctx context.Context, stream Stream, conn ws.Conn) error {
+	for {
+		message, ok := conn.Next(ctx)
+		if !ok {
+			return nil
+		}
+
+		var msg protocol.Message
+		if err := json.Unmarshal(message, &msg); err != nil {
+			s.logger.Error("Failed to decode message for stream", "error", err, "client_id", conn.ID())
+			continue
+		}
+		if err := stream.Send(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// request builds the request identifying the client of a call to the
+// WebSocket handler, as the upgrade request of a WebSocket client does
+func request(ctx context.Context) *http.Request {
+	r := (&http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: SignalMethod},
+		Header: make(http.Header),
+	}).WithContext(ctx)
+
+	if p, ok := PeerFromContext(ctx); ok {
+		r.RemoteAddr = p.Addr
+		r.TLS = p.TLS
+		if p.Metadata != nil {
+			r.Header = p.Metadata.Clone()
+		}
+	}
+	return r
+}
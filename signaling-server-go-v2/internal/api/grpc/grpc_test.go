@@ -0,0 +1,165 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/gorilla"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// stream is the server side of a Signal call driven by the test
+type stream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	recv   chan *protocol.Message
+	sent   chan *protocol.Message
+}
+
+func newStream(addr string) *stream {
+	ctx, cancel := context.WithCancel(ContextWithPeer(context.Background(), Peer{Addr: addr}))
+	return &stream{
+		ctx:    ctx,
+		cancel: cancel,
+		recv:   make(chan *protocol.Message),
+		sent:   make(chan *protocol.Message, 16),
+	}
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stream) Send(msg *protocol.Message) error {
+	s.sent <- msg
+	return nil
+}
+
+func (s *stream) Recv() (*protocol.Message, error) {
+	select {
+	case msg, ok := <-s.recv:
+		if !ok {
+			return nil, io.EOF
+		}
+		return msg, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+// next returns the next message sent to the client
+func (s *stream) next(t *testing.T) *protocol.Message {
+	t.Helper()
+	select {
+	case msg := <-s.sent:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a message on the stream")
+		return nil
+	}
+}
+
+func TestSignal(t *testing.T) {
+	var sm *protocol.SignalingManager
+	var handler *gorilla.Handler
+	handler = gorilla.NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		gorilla.WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			return sm.ProcessMessage(message, clientID, handler.SendMessage)
+		}),
+		gorilla.WithDisconnectHandler(func(clientID string) {
+			sm.RemoveClient(clientID)
+		}),
+	).(*gorilla.Handler)
+	sm = protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithConnections(handler))
+	server := NewServer("localhost:9090", handler, testsupport.NewLogger())
+
+	s := newStream("192.0.2.1:50000")
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Signal(s)
+	}()
+
+	if welcome := s.next(t); welcome.Type != protocol.Welcome {
+		t.Fatalf("Expected a welcome message, got %+v", welcome)
+	}
+
+	s.recv <- &protocol.Message{Type: protocol.Join, Room: "call"}
+	joined := s.next(t)
+	if joined.Type != protocol.Joined || joined.Room != "call" {
+		t.Fatalf("Expected a joined message, got %+v", joined)
+	}
+	streamID := joined.Recipient
+
+	// A WebSocket client in the same room reaches the gRPC client
+	rec := httptest.NewRecorder()
+	handler.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	wsClient, _ := handler.Client(resp["client_id"].(string))
+
+	joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "call"})
+	wsClient.Receive(joinJSON)
+	offerJSON, _ := json.Marshal(protocol.Message{Type: protocol.Offer, Room: "call", Recipient: streamID, Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	if err := wsClient.Receive(offerJSON); err != nil {
+		t.Fatalf("Relay failed: %v", err)
+	}
+
+	for {
+		msg := s.next(t)
+		if msg.Type == protocol.Offer {
+			if msg.Sender != wsClient.ID() || string(msg.Payload) != `{"sdp":"v=0"}` {
+				t.Errorf("Unexpected offer on the stream: %+v", msg)
+			}
+			break
+		}
+	}
+
+	// Ending the stream removes the client from its rooms
+	close(s.recv)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the call to end cleanly, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the call to end")
+	}
+	if peers := sm.GetPeersInRoom("call"); len(peers) != 1 || peers[0] != wsClient.ID() {
+		t.Errorf("Expected only the WebSocket client to remain, got %v", peers)
+	}
+}
+
+func TestSignalClosedByServer(t *testing.T) {
+	handler := gorilla.NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}).(*gorilla.Handler)
+	server := NewServer("localhost:9090", handler, testsupport.NewLogger())
+
+	s := newStream("192.0.2.1:50000")
+	defer s.cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Signal(s)
+	}()
+
+	var ids []string
+	for deadline := time.Now().Add(time.Second); len(ids) == 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		ids = handler.ClientIDs()
+	}
+	if len(ids) != 1 {
+		t.Fatalf("Expected the stream to be attached, got %v", ids)
+	}
+
+	// Kicking the client ends the call
+	handler.CloseConnection(ids[0])
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the call to end")
+	}
+}
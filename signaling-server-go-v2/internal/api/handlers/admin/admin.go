@@ -0,0 +1,528 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/audit"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/events"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// CloseRoomsRequest is the request body of the close rooms endpoint
+type CloseRoomsRequest struct {
+	Pattern string `json:"pattern"`
+	Reason  string `json:"reason,omitempty"`
+	DryRun  bool   `json:"dryRun"`
+}
+
+// DisconnectTenantRequest is the request body of the disconnect tenant endpoint
+type DisconnectTenantRequest struct {
+	Tenant string `json:"tenant"`
+	DryRun bool   `json:"dryRun"`
+}
+
+// BroadcastRequest is the request body of the broadcast endpoint
+type BroadcastRequest struct {
+	Message string `json:"message"`
+	DryRun  bool   `json:"dryRun"`
+}
+
+// RoomRequest is the request body of the create room and update room metadata endpoints
+type RoomRequest struct {
+	Room     string          `json:"room"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// RoomPasswordRequest is the request body of the set room password endpoint
+type RoomPasswordRequest struct {
+	Room     string `json:"room"`
+	Password string `json:"password"`
+}
+
+// RoomTimeLimitRequest is the request body of the room time limit endpoint
+type RoomTimeLimitRequest struct {
+	Room        string `json:"room"`
+	MaxDuration int    `json:"maxDuration"` // in seconds after the room's creation, 0 lifts the limit
+}
+
+// RoomCreationRequest is the request body of the room creation endpoint
+type RoomCreationRequest struct {
+	Disabled bool   `json:"disabled"`
+	Message  string `json:"message,omitempty"` // sent to clients joining new rooms, a default message if empty
+}
+
+// BulkResponse is the response of a bulk operation
+type BulkResponse struct {
+	DryRun bool `json:"dryRun"`
+	protocol.BulkResult
+}
+
+// ConfigResponse is the response of the config endpoint
+type ConfigResponse struct {
+	Hash   string        `json:"hash"`
+	Config config.Config `json:"config"`
+}
+
+// ReloadResponse is the response of the config reload endpoint
+type ReloadResponse struct {
+	Changes []config.Change `json:"changes"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// ErrorResponse is the response returned when an admin request fails
+type ErrorResponse struct {
+	// Code classifies the errors of the signaling manager and stores, e.g.
+	// not_found or already_exists
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error"`
+}
+
+// Handler is the administrative API handler
+type Handler struct {
+	cfg       *config.Config
+	logger    logging.Logger
+	audit     logging.Logger
+	token     string
+	manager   *protocol.SignalingManager
+	wsHandler websocket.WebSocketHandler
+	recorder  *logging.Recorder
+	trail     *audit.Log
+	reloader  *config.Reloader
+	webhooks  *events.RoomWebhooks
+
+	// tenantTokens holds the tenant of each tenant token
+	tenantTokens map[string]string
+}
+
+// Option configures optional Handler dependencies
+type Option func(*Handler)
+
+// WithLogRecorder sets the recorder whose recent log entries are included in debug bundles
+func WithLogRecorder(recorder *logging.Recorder) Option {
+	return func(h *Handler) {
+		h.recorder = recorder
+	}
+}
+
+// WithAuditLog also records the admin operations in the audit log
+func WithAuditLog(trail *audit.Log) Option {
+	return func(h *Handler) {
+		h.trail = trail
+	}
+}
+
+// WithReloader lets the admin API reload the configuration
+func WithReloader(reloader *config.Reloader) Option {
+	return func(h *Handler) {
+		h.reloader = reloader
+	}
+}
+
+// NewHandler creates a new administrative API handler
+func NewHandler(cfg *config.Config, logger logging.Logger, manager *protocol.SignalingManager, wsHandler websocket.WebSocketHandler, opts ...Option) *Handler {
+	h := &Handler{
+		cfg:       cfg,
+		logger:    logger.With("component", "admin"),
+		audit:     logger.With("component", "admin", "audit", true),
+		token:     cfg.Admin.Token,
+		manager:   manager,
+		wsHandler: wsHandler,
+
+		tenantTokens: make(map[string]string),
+	}
+	for _, entry := range cfg.Admin.TenantTokens {
+		if tenant, token, ok := strings.Cut(entry, ":"); ok && tenant != "" && token != "" {
+			h.tenantTokens[token] = tenant
+		}
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// Authorize wraps a handler so it requires the configured bearer token.
+// Requests are always rejected if no token is configured.
+func (h *Handler) Authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			h.logger.Warn("Unauthorized admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// auditOperation logs an admin operation with its details, given as
+// alternating keys and values, and records it in the audit log, if any
+func (h *Handler) auditOperation(r *http.Request, operation string, keyvals ...interface{}) {
+	if tenant, ok := tenantOf(r); ok {
+		keyvals = append(keyvals, "scope", tenant)
+	}
+	fields := append([]interface{}{"operation", operation}, keyvals...)
+	h.audit.Info("Admin operation", append(fields, "remote_addr", r.RemoteAddr)...)
+
+	if h.trail != nil {
+		h.trail.Admin(operation, r.RemoteAddr, keyvals...)
+	}
+}
+
+// CloseRoomsHandler closes all rooms matching a namespace pattern
+func (h *Handler) CloseRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	var req CloseRoomsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Pattern == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "pattern is required"})
+		return
+	}
+	if !h.inTenant(w, r, req.Pattern) {
+		return
+	}
+
+	result := h.manager.CloseRooms(req.Pattern, req.Reason, req.DryRun)
+
+	h.auditOperation(r, "close_rooms",
+		"pattern", req.Pattern,
+		"reason", req.Reason,
+		"dry_run", req.DryRun,
+		"rooms", len(result.Rooms),
+		"clients", len(result.Clients),
+	)
+
+	writeJSON(w, http.StatusOK, BulkResponse{DryRun: req.DryRun, BulkResult: result})
+}
+
+// DisconnectTenantHandler disconnects all clients in the rooms of a tenant
+func (h *Handler) DisconnectTenantHandler(w http.ResponseWriter, r *http.Request) {
+	var req DisconnectTenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tenant == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "tenant is required"})
+		return
+	}
+	if tenant, ok := tenantOf(r); ok && req.Tenant != tenant {
+		h.writeError(w, errs.Newf(errs.PermissionDenied, "tenant %s is not %s", req.Tenant, tenant))
+		return
+	}
+
+	result, err := h.manager.TenantClients(req.Tenant)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if !req.DryRun {
+		for _, clientID := range result.Clients {
+			h.manager.RemoveClient(clientID)
+			if err := h.wsHandler.CloseConnection(clientID); err != nil {
+				h.logger.Warn("Failed to close client connection", "error", err, "client_id", clientID)
+			}
+		}
+	}
+
+	h.auditOperation(r, "disconnect_tenant",
+		"tenant", req.Tenant,
+		"dry_run", req.DryRun,
+		"rooms", len(result.Rooms),
+		"clients", len(result.Clients),
+	)
+
+	writeJSON(w, http.StatusOK, BulkResponse{DryRun: req.DryRun, BulkResult: result})
+}
+
+// BroadcastHandler sends a system notice to every connection
+func (h *Handler) BroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	var req BroadcastRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "message is required"})
+		return
+	}
+
+	notice, err := protocol.NewNotice(protocol.SystemNotice, "", req.Message)
+	if err != nil {
+		h.logger.Error("Failed to build system notice", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to build notice"})
+		return
+	}
+
+	if !req.DryRun {
+		if err := h.wsHandler.BroadcastMessage(notice); err != nil {
+			h.logger.Error("Failed to broadcast system notice", "error", err)
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to broadcast notice"})
+			return
+		}
+	}
+
+	h.auditOperation(r, "broadcast",
+		"message", req.Message,
+		"dry_run", req.DryRun,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dryRun": req.DryRun,
+		"notice": json.RawMessage(notice),
+	})
+}
+
+// ListRoomsHandler returns the rooms matching the pattern query parameter,
+// all rooms if omitted or, for a tenant token, all rooms of its tenant
+func (h *Handler) ListRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		pattern = "**"
+		if tenant, ok := tenantOf(r); ok {
+			pattern = protocol.QualifyRoom(tenant, "**")
+		}
+	}
+	if !h.inTenant(w, r, pattern) {
+		return
+	}
+
+	rooms := make([]protocol.RoomSnapshot, 0)
+	for _, room := range h.manager.Snapshot() {
+		if protocol.MatchRoomPattern(pattern, room.ID) {
+			rooms = append(rooms, room)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pattern": pattern,
+		"rooms":   rooms,
+	})
+}
+
+// RoomQualityHandler returns the call quality scores of the rooms with
+// quality reports, worst first; only degraded rooms with degraded=true
+func (h *Handler) RoomQualityHandler(w http.ResponseWriter, r *http.Request) {
+	degradedOnly := r.URL.Query().Get("degraded") == "true"
+
+	rooms := make([]protocol.CallQuality, 0)
+	for _, quality := range h.manager.CallQualities() {
+		if !degradedOnly || quality.Degraded {
+			rooms = append(rooms, quality)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rooms": rooms,
+	})
+}
+
+// CreateRoomHandler creates a room with metadata ahead of the first join
+func (h *Handler) CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var req RoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Room == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "room is required"})
+		return
+	}
+	if !h.inTenant(w, r, req.Room) {
+		return
+	}
+
+	if err := h.manager.CreateRoom(req.Room, req.Metadata); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.auditOperation(r, "create_room",
+		"room_id", req.Room,
+	)
+
+	writeJSON(w, http.StatusCreated, req)
+}
+
+// UpdateRoomMetadataHandler replaces the metadata of an existing room
+func (h *Handler) UpdateRoomMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	var req RoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Room == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "room is required"})
+		return
+	}
+	if !h.inTenant(w, r, req.Room) {
+		return
+	}
+
+	if err := h.manager.SetRoomMetadata(req.Room, req.Metadata); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.auditOperation(r, "update_room_metadata",
+		"room_id", req.Room,
+	)
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+// SetRoomPasswordHandler sets or, with an empty password, clears the password of an existing room
+func (h *Handler) SetRoomPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req RoomPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Room == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "room is required"})
+		return
+	}
+	if !h.inTenant(w, r, req.Room) {
+		return
+	}
+
+	if len(req.Password) > protocol.MaxPasswordLength {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "password is too long"})
+		return
+	}
+
+	if err := h.manager.SetRoomPassword(req.Room, req.Password); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.auditOperation(r, "set_room_password",
+		"room_id", req.Room,
+		"protected", req.Password != "",
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"room":      req.Room,
+		"protected": req.Password != "",
+	})
+}
+
+// SetRoomTimeLimitHandler limits the duration of a room, overriding its
+// namespace policy; its peers are warned before it closes
+func (h *Handler) SetRoomTimeLimitHandler(w http.ResponseWriter, r *http.Request) {
+	var req RoomTimeLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Room == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "room is required"})
+		return
+	}
+	if !h.inTenant(w, r, req.Room) {
+		return
+	}
+	if req.MaxDuration < 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "maxDuration must not be negative"})
+		return
+	}
+
+	closesAt, err := h.manager.SetRoomTimeLimit(req.Room, time.Duration(req.MaxDuration)*time.Second)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.auditOperation(r, "set_room_time_limit",
+		"room_id", req.Room,
+		"max_duration", req.MaxDuration,
+	)
+
+	response := map[string]interface{}{
+		"room":        req.Room,
+		"maxDuration": req.MaxDuration,
+	}
+	if !closesAt.IsZero() {
+		response["closesAt"] = closesAt
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// RoomCreationHandler returns whether joins may create rooms
+func (h *Handler) RoomCreationHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.manager.RoomCreation())
+}
+
+// SetRoomCreationHandler disables or re-enables the creation of rooms by
+// joins, leaving calls in existing rooms untouched
+func (h *Handler) SetRoomCreationHandler(w http.ResponseWriter, r *http.Request) {
+	var req RoomCreationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	creation := h.manager.SetRoomCreation(req.Disabled, req.Message)
+
+	h.auditOperation(r, "set_room_creation",
+		"disabled", creation.Disabled,
+		"message", creation.Message,
+	)
+
+	writeJSON(w, http.StatusOK, creation)
+}
+
+// ReloadConfigHandler reloads the configuration, applying the settings that
+// can change without dropping connections, and returns the changes
+func (h *Handler) ReloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if h.reloader == nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "configuration reload is not available"})
+		return
+	}
+
+	changes, err := h.reloader.Reload()
+
+	// The changes are recorded in the audit log as a reload rather than an admin operation
+	h.audit.Info("Admin operation", "operation", "reload_config", "changes", len(changes), "remote_addr", r.RemoteAddr)
+	if h.trail != nil {
+		h.trail.ConfigReload("admin", r.RemoteAddr, changes)
+	}
+
+	response := ReloadResponse{Changes: changes}
+	if changes == nil {
+		response.Changes = []config.Change{}
+	}
+	if err != nil {
+		h.logger.Error("Failed to reload configuration", "error", err)
+		response.Error = err.Error()
+		writeJSON(w, http.StatusInternalServerError, response)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// ConfigHandler returns the effective configuration with secrets masked and
+// its hash, which differs between instances whose configuration drifted
+func (h *Handler) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	var cfg config.Config
+	if h.cfg != nil {
+		cfg = *h.cfg
+	}
+
+	hash, err := cfg.Hash()
+	if err != nil {
+		h.logger.Error("Failed to hash configuration", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to hash configuration"})
+		return
+	}
+
+	h.auditOperation(r, "get_config",
+		"hash", hash,
+	)
+
+	w.Header().Set("ETag", strconv.Quote(hash))
+	writeJSON(w, http.StatusOK, ConfigResponse{Hash: hash, Config: cfg.Redacted()})
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error with the status of its code and the message
+// safe to show; errors without a code are logged, as the response only says
+// that the request failed
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	code := errs.CodeOf(err)
+	if code == errs.Internal {
+		h.logger.Error("Admin request failed", "error", err)
+	}
+	writeJSON(w, errs.HTTPStatus(err), ErrorResponse{Code: string(code), Error: errs.Message(err)})
+}
@@ -0,0 +1,688 @@
+package admin
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/events"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/roomstore"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func setupTestHandler(token string) (*Handler, *protocol.SignalingManager, *testsupport.WebSocketHandler) {
+	ws := testsupport.NewWebSocketHandler()
+	sm := protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithConnections(ws))
+	noop := func(string, []byte) error { return nil }
+
+	joins := map[string]string{
+		"client-1": "acme/web/standup",
+		"client-2": "acme/web/retro",
+		"client-3": "globex/standup",
+	}
+	for clientID, roomID := range joins {
+		joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: roomID})
+		sm.ProcessMessage(joinJSON, clientID, noop)
+	}
+
+	return NewHandler(&config.Config{Admin: config.AdminConfig{Token: token}}, testsupport.NewLogger(), sm, ws), sm, ws
+}
+
+func TestCloseRoomsDryRun(t *testing.T) {
+	h, sm, ws := setupTestHandler("")
+
+	req := httptest.NewRequest("POST", "/admin/rooms/close", strings.NewReader(`{"pattern":"acme/**","dryRun":true}`))
+	rec := httptest.NewRecorder()
+	h.CloseRoomsHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var resp BulkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.DryRun || len(resp.Rooms) != 2 || len(resp.Clients) != 2 {
+		t.Errorf("Unexpected dry run response: %+v", resp)
+	}
+
+	// Nothing is changed in dry-run mode
+	if sm.GetRoomCount() != 3 {
+		t.Errorf("Expected 3 rooms after dry run, got %d", sm.GetRoomCount())
+	}
+	if len(ws.Sent) != 0 {
+		t.Errorf("Expected no notices in dry run, got %d", len(ws.Sent))
+	}
+}
+
+func TestCloseRooms(t *testing.T) {
+	h, sm, ws := setupTestHandler("")
+
+	req := httptest.NewRequest("POST", "/admin/rooms/close", strings.NewReader(`{"pattern":"acme/**","reason":"incident"}`))
+	rec := httptest.NewRecorder()
+	h.CloseRoomsHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	if sm.GetRoomCount() != 1 {
+		t.Errorf("Expected 1 room left, got %d", sm.GetRoomCount())
+	}
+	if len(ws.Sent["client-1"]) != 1 || len(ws.Sent["client-2"]) != 1 {
+		t.Errorf("Expected room closed notices for acme clients, got %v", ws.Sent)
+	}
+}
+
+func TestDisconnectTenant(t *testing.T) {
+	h, sm, ws := setupTestHandler("")
+
+	req := httptest.NewRequest("POST", "/admin/tenants/disconnect", strings.NewReader(`{"tenant":"globex"}`))
+	rec := httptest.NewRecorder()
+	h.DisconnectTenantHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	if len(ws.Closed) != 1 || ws.Closed[0].ClientID != "client-3" {
+		t.Errorf("Expected client-3 to be disconnected, got %v", ws.ClosedIDs())
+	}
+	if sm.RoomExists("globex/standup") {
+		t.Error("Expected globex/standup to be removed")
+	}
+
+	// Wildcards and nested namespaces are not tenants
+	for _, tenant := range []string{"*", "**", "acme/web", "glob?x"} {
+		req = httptest.NewRequest("POST", "/admin/tenants/disconnect", strings.NewReader(`{"tenant":"`+tenant+`"}`))
+		rec = httptest.NewRecorder()
+		h.DisconnectTenantHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for tenant %q, got %d", http.StatusBadRequest, tenant, rec.Code)
+		}
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	h, _, ws := setupTestHandler("")
+
+	req := httptest.NewRequest("POST", "/admin/broadcast", strings.NewReader(`{"message":"maintenance in 5 minutes"}`))
+	rec := httptest.NewRecorder()
+	h.BroadcastHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	if len(ws.Broadcasts) != 1 {
+		t.Fatalf("Expected 1 broadcast, got %d", len(ws.Broadcasts))
+	}
+
+	var notice protocol.Message
+	json.Unmarshal(ws.Broadcasts[0], &notice)
+	if notice.Type != protocol.SystemNotice {
+		t.Errorf("Expected message type %s, got %s", protocol.SystemNotice, notice.Type)
+	}
+
+	// Invalid requests are rejected
+	req = httptest.NewRequest("POST", "/admin/broadcast", strings.NewReader(`{}`))
+	rec = httptest.NewRecorder()
+	h.BroadcastHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+// listingHandler is a WebSocket handler listing fixed connections
+type listingHandler struct {
+	*testsupport.WebSocketHandler
+	clients []string
+}
+
+func (h listingHandler) ClientIDs() []string {
+	return h.clients
+}
+
+func TestReconnectClients(t *testing.T) {
+	_, sm, ws := setupTestHandler("")
+	clients := []string{"client-1", "client-2", "client-3", "client-4"}
+	h := NewHandler(&config.Config{}, testsupport.NewLogger(), sm, listingHandler{WebSocketHandler: ws, clients: clients})
+
+	req := httptest.NewRequest("POST", "/admin/clients/reconnect", strings.NewReader(`{"percent":50,"window":60,"message":"upgrading"}`))
+	rec := httptest.NewRecorder()
+	h.ReconnectClientsHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var resp ReconnectResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Connections != 4 || len(resp.Clients) != 2 {
+		t.Fatalf("Expected 2 of 4 connections to be asked to reconnect, got %+v", resp)
+	}
+	for _, clientID := range resp.Clients {
+		if len(ws.Sent[clientID]) != 1 {
+			t.Fatalf("Expected a request sent to %s, got %d messages", clientID, len(ws.Sent[clientID]))
+		}
+		var msg protocol.Message
+		var payload protocol.ReconnectPayload
+		json.Unmarshal(ws.Sent[clientID][0], &msg)
+		json.Unmarshal(msg.Payload, &payload)
+		if msg.Type != protocol.PleaseReconnect || payload.Message != "upgrading" || payload.ReconnectIn < 0 || payload.ReconnectIn > 60000 {
+			t.Errorf("Expected a please-reconnect request within the window, got %s", ws.Sent[clientID][0])
+		}
+	}
+	if len(ws.Sent) != 2 || len(ws.Closed) != 0 {
+		t.Errorf("Expected only the picked clients to be sent a request and none closed, got %v and %v", ws.Sent, ws.Closed)
+	}
+
+	// Forced connections are closed at their deadline
+	req = httptest.NewRequest("POST", "/admin/clients/reconnect", strings.NewReader(`{"percent":100,"force":true}`))
+	h.ReconnectClientsHandler(httptest.NewRecorder(), req)
+	deadline := time.Now().Add(time.Second)
+	for len(ws.ClosedIDs()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if closed := ws.ClosedIDs(); len(closed) != 4 {
+		t.Errorf("Expected all connections closed at their deadline, got %v", closed)
+	}
+
+	// Invalid percentages are rejected
+	for _, body := range []string{`{}`, `{"percent":150}`} {
+		rec = httptest.NewRecorder()
+		h.ReconnectClientsHandler(rec, httptest.NewRequest("POST", "/admin/clients/reconnect", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, got %d", http.StatusBadRequest, body, rec.Code)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	h, _, _ := setupTestHandler("s3cret")
+	handler := h.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("POST", "/admin/broadcast", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d without token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/broadcast", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d with token, got %d", http.StatusOK, rec.Code)
+	}
+
+	// Without a configured token every request is rejected
+	h, _, _ = setupTestHandler("")
+	handler = h.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req = httptest.NewRequest("POST", "/admin/broadcast", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d without configured token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestAuthorizeTenant(t *testing.T) {
+	ws := testsupport.NewWebSocketHandler()
+	sm := protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithConnections(ws))
+	noop := func(string, []byte) error { return nil }
+	for clientID, roomID := range map[string]string{"client-1": "acme/standup", "client-2": "globex/standup"} {
+		joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: roomID})
+		sm.ProcessMessage(joinJSON, clientID, noop)
+	}
+	cfg := &config.Config{Admin: config.AdminConfig{Token: "s3cret", TenantTokens: []string{"acme:acme-token"}}}
+	h := NewHandler(cfg, testsupport.NewLogger(), sm, ws)
+
+	do := func(handler http.HandlerFunc, method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.AuthorizeTenant(handler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A tenant token lists only its tenant's rooms
+	var resp struct {
+		Rooms []protocol.RoomSnapshot `json:"rooms"`
+	}
+	json.Unmarshal(do(h.ListRoomsHandler, "GET", "/admin/rooms", "", "acme-token").Body.Bytes(), &resp)
+	if len(resp.Rooms) != 1 || resp.Rooms[0].ID != "acme/standup" {
+		t.Errorf("Expected only the acme room, got %+v", resp.Rooms)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+		token   string
+		status  int
+	}{
+		{"other tenant's rooms", h.ListRoomsHandler, "GET", "/admin/rooms?pattern=**", "", "acme-token", http.StatusForbidden},
+		{"create room in tenant", h.CreateRoomHandler, "POST", "/admin/rooms", `{"room":"acme/retro"}`, "acme-token", http.StatusCreated},
+		{"create room outside tenant", h.CreateRoomHandler, "POST", "/admin/rooms", `{"room":"acmeco/retro"}`, "acme-token", http.StatusForbidden},
+		{"close other tenant's rooms", h.CloseRoomsHandler, "POST", "/admin/rooms/close", `{"pattern":"globex/**"}`, "acme-token", http.StatusForbidden},
+		{"disconnect other tenant", h.DisconnectTenantHandler, "POST", "/admin/tenants/disconnect", `{"tenant":"globex"}`, "acme-token", http.StatusForbidden},
+		{"global token", h.CloseRoomsHandler, "POST", "/admin/rooms/close", `{"pattern":"globex/**"}`, "s3cret", http.StatusOK},
+		{"unknown token", h.ListRoomsHandler, "GET", "/admin/rooms", "", "guess", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(tt.handler, tt.method, tt.target, tt.body, tt.token); rec.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+	if !sm.RoomExists("acme/retro") || sm.RoomExists("globex/standup") {
+		t.Error("Expected the allowed operations to be applied")
+	}
+}
+
+func TestDebugBundle(t *testing.T) {
+	ws := testsupport.NewWebSocketHandler()
+	sm := protocol.NewSignalingManager(testsupport.NewLogger())
+	sm.CreateRoom("acme/standup", json.RawMessage(`{"topic":"s3cret plans"}`))
+
+	recorder := logging.NewRecorder(testsupport.NewLogger(), 10, logging.NewLevel("debug"))
+	recorder.Info("Client connected", "client_id", "client-1", "token", "s3cret")
+	recorder.Info("Request completed", "remote_addr", "203.0.113.7:5000")
+
+	cfg := &config.Config{Admin: config.AdminConfig{Token: "admin-s3cret"}}
+	h := NewHandler(cfg, testsupport.NewLogger(), sm, ws, WithLogRecorder(recorder))
+
+	req := httptest.NewRequest("GET", "/admin/debug/bundle", nil)
+	rec := httptest.NewRecorder()
+	h.DebugBundleHandler(rec, req)
+
+	if rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected Content-Type application/zip, got %s", rec.Header().Get("Content-Type"))
+	}
+
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(data)
+	}
+
+	for _, name := range []string{"rooms.json", "clients.json", "config.json", "logs.json", "goroutines.txt"} {
+		if _, ok := contents[name]; !ok {
+			t.Errorf("Expected %s in bundle", name)
+		}
+	}
+
+	if !strings.Contains(contents["rooms.json"], "acme/standup") || !strings.Contains(contents["rooms.json"], "topic") {
+		t.Error("Expected rooms.json to contain acme/standup and its metadata keys")
+	}
+	if strings.Contains(contents["logs.json"], "203.0.113.7") {
+		t.Error("Expected client addresses to be redacted from logs.json")
+	}
+
+	for name, content := range contents {
+		if strings.Contains(content, "s3cret") {
+			t.Errorf("Expected secrets to be redacted from %s", name)
+		}
+	}
+}
+
+func TestSetRoomPassword(t *testing.T) {
+	h, sm, _ := setupTestHandler("")
+
+	req := httptest.NewRequest("POST", "/admin/rooms/password", strings.NewReader(`{"room":"globex/standup","password":"s3cret"}`))
+	rec := httptest.NewRecorder()
+	h.SetRoomPasswordHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	for _, room := range sm.Snapshot() {
+		if room.ID == "globex/standup" && !room.PasswordProtected {
+			t.Error("Expected globex/standup to be password protected")
+		}
+	}
+
+	// Unknown rooms are rejected
+	req = httptest.NewRequest("POST", "/admin/rooms/password", strings.NewReader(`{"room":"missing","password":"x"}`))
+	rec = httptest.NewRecorder()
+	h.SetRoomPasswordHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestCreateRoomErrors(t *testing.T) {
+	h, _, _ := setupTestHandler("")
+
+	tests := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"room":"acme/standup"}`, http.StatusCreated, ""},
+		{`{"room":"acme/standup"}`, http.StatusConflict, "already_exists"},
+		{`{"room":"acme//standup"}`, http.StatusBadRequest, "invalid_argument"},
+		{`{"room":"acme/retro","metadata":[1]}`, http.StatusBadRequest, "invalid_argument"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.CreateRoomHandler(rec, httptest.NewRequest("POST", "/admin/rooms", strings.NewReader(tt.body)))
+		var resp ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != tt.status || resp.Code != tt.code {
+			t.Errorf("Expected %d %q for %s, got %d %+v", tt.status, tt.code, tt.body, rec.Code, resp)
+		}
+	}
+}
+
+func TestListRooms(t *testing.T) {
+	h, _, _ := setupTestHandler("")
+
+	req := httptest.NewRequest("GET", "/admin/rooms?pattern=acme/**", nil)
+	rec := httptest.NewRecorder()
+	h.ListRoomsHandler(rec, req)
+
+	var resp struct {
+		Rooms []protocol.RoomSnapshot `json:"rooms"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Rooms) != 2 || resp.Rooms[0].ID != "acme/web/retro" || resp.Rooms[1].ID != "acme/web/standup" {
+		t.Errorf("Expected the acme rooms, got %+v", resp.Rooms)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/rooms", nil)
+	rec = httptest.NewRecorder()
+	h.ListRoomsHandler(rec, req)
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Rooms) != 3 {
+		t.Errorf("Expected all 3 rooms without a pattern, got %d", len(resp.Rooms))
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	ws := testsupport.NewWebSocketHandler()
+	sm := protocol.NewSignalingManager(testsupport.NewLogger())
+	cfg := &config.Config{
+		Server:  config.ServerConfig{Port: 8080},
+		Admin:   config.AdminConfig{Token: "admin-s3cret"},
+		Metrics: config.MetricsConfig{Token: "metrics-s3cret"},
+	}
+	h := NewHandler(cfg, testsupport.NewLogger(), sm, ws)
+
+	rec := httptest.NewRecorder()
+	h.ConfigHandler(rec, httptest.NewRequest("GET", "/admin/config", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "s3cret") {
+		t.Error("Expected secrets to be masked")
+	}
+
+	var resp ConfigResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Config.Server.Port != 8080 {
+		t.Errorf("Expected the effective configuration, got port %d", resp.Config.Server.Port)
+	}
+
+	expected, _ := cfg.Hash()
+	if resp.Hash != expected || rec.Header().Get("ETag") != `"`+expected+`"` {
+		t.Errorf("Expected hash %s in body and ETag, got %s and %s", expected, resp.Hash, rec.Header().Get("ETag"))
+	}
+}
+
+func TestSetRoomCreation(t *testing.T) {
+	h, sm, _ := setupTestHandler("")
+
+	req := httptest.NewRequest("POST", "/admin/room-creation", strings.NewReader(`{"disabled":true}`))
+	rec := httptest.NewRecorder()
+	h.SetRoomCreationHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	var resp protocol.RoomCreation
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if !resp.Disabled || resp.Message != protocol.DefaultRoomCreationDisabledMessage {
+		t.Errorf("Expected room creation to be disabled with the default message, got %+v", resp)
+	}
+
+	// Joins to existing rooms continue, new rooms are rejected with the message
+	var reply protocol.Message
+	sender := func(clientID string, message []byte) error {
+		return json.Unmarshal(message, &reply)
+	}
+	joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "acme/web/standup"})
+	if err := sm.ProcessMessage(joinJSON, "client-4", sender); err != nil {
+		t.Errorf("Expected the join to an existing room to succeed, got %v", err)
+	}
+	joinJSON, _ = json.Marshal(protocol.Message{Type: protocol.Join, Room: "acme/web/planning"})
+	if err := sm.ProcessMessage(joinJSON, "client-4", sender); err == nil {
+		t.Error("Expected the join to a new room to be rejected")
+	}
+	var payload protocol.ErrorPayload
+	json.Unmarshal(reply.Payload, &payload)
+	if reply.Type != protocol.Error || payload.Message != protocol.DefaultRoomCreationDisabledMessage {
+		t.Errorf("Expected an error with the friendly message, got %+v", reply)
+	}
+
+	rec = httptest.NewRecorder()
+	h.SetRoomCreationHandler(rec, httptest.NewRequest("POST", "/admin/room-creation", strings.NewReader(`{"disabled":false}`)))
+	rec = httptest.NewRecorder()
+	h.RoomCreationHandler(rec, httptest.NewRequest("GET", "/admin/room-creation", nil))
+	resp = protocol.RoomCreation{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Disabled {
+		t.Errorf("Expected room creation to be enabled again, got %+v", resp)
+	}
+	if err := sm.ProcessMessage(joinJSON, "client-4", sender); err != nil {
+		t.Errorf("Expected the join to create the room once enabled, got %v", err)
+	}
+}
+
+// statsHandler is a WebSocket handler reporting fixed hub statistics
+type statsHandler struct {
+	*testsupport.WebSocketHandler
+	stats websocket.HubStats
+}
+
+func (h statsHandler) Stats() websocket.HubStats {
+	return h.stats
+}
+
+func TestStatsHandler(t *testing.T) {
+	_, sm, ws := setupTestHandler("")
+	hub := statsHandler{WebSocketHandler: ws, stats: websocket.HubStats{Connections: 3, QueuedMessages: 5, MaxQueueDepth: 4, DroppedMessages: 2}}
+	h := NewHandler(&config.Config{}, testsupport.NewLogger(), sm, hub)
+
+	rec := httptest.NewRecorder()
+	h.StatsHandler(rec, httptest.NewRequest("GET", "/admin/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var resp struct {
+		Cmdline   []string               `json:"cmdline"`
+		Memstats  map[string]interface{} `json:"memstats"`
+		Signaling SignalingStats         `json:"signaling"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Cmdline) == 0 || resp.Memstats["HeapAlloc"] == nil {
+		t.Errorf("Expected the expvar cmdline and memstats variables, got %s", rec.Body.String())
+	}
+
+	expected := SignalingStats{
+		HubStats:  hub.stats,
+		Rooms:     3,
+		RoomPeers: map[string]int{"acme/web/standup": 1, "acme/web/retro": 1, "globex/standup": 1},
+	}
+	if !reflect.DeepEqual(resp.Signaling, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, resp.Signaling)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	h, sm, _ := setupTestHandler("s3cret")
+
+	rec := httptest.NewRecorder()
+	h.ReloadConfigHandler(rec, httptest.NewRequest("POST", "/admin/config/reload", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a reloader, got %d", rec.Code)
+	}
+
+	policies := []config.NamespacePolicyConfig{{Namespace: "acme", MaxPeers: 1}}
+	h.reloader = config.NewReloader(func() (*config.Config, error) {
+		return &config.Config{Signaling: config.SignalingConfig{NamespacePolicies: policies}}, nil
+	}, &config.Config{}, config.Setting{
+		Name:  "signaling.namespacePolicies",
+		Value: func(cfg *config.Config) interface{} { return cfg.Signaling.NamespacePolicies },
+		Apply: func(cfg *config.Config) error {
+			sm.SetNamespacePolicies(map[string]protocol.NamespacePolicy{"acme": {MaxPeers: cfg.Signaling.NamespacePolicies[0].MaxPeers}})
+			return nil
+		},
+	})
+
+	rec = httptest.NewRecorder()
+	h.ReloadConfigHandler(rec, httptest.NewRequest("POST", "/admin/config/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp ReloadResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Changes) != 1 || resp.Changes[0].Setting != "signaling.namespacePolicies" {
+		t.Errorf("Expected the changed setting to be reported, got %+v", resp)
+	}
+
+	// The room cap applies to later joins without disconnecting the peers
+	joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "acme/web/standup"})
+	if err := sm.ProcessMessage(joinJSON, "client-4", func(string, []byte) error { return nil }); err == nil {
+		t.Error("Expected the reloaded room cap to reject the join")
+	}
+	if peers := sm.GetPeersInRoom("acme/web/standup"); len(peers) != 1 {
+		t.Errorf("Expected the peer of the room to stay, got %v", peers)
+	}
+}
+
+func TestRoomWebhooks(t *testing.T) {
+	store, err := roomstore.OpenWebhookStore(filepath.Join(t.TempDir(), "webhooks.db"))
+	if err != nil {
+		t.Fatalf("OpenWebhookStore failed: %v", err)
+	}
+	defer store.Close()
+	webhooks, err := events.NewRoomWebhooks(store, config.WebhooksConfig{},
+		config.ExportersConfig{BufferSize: 16, FailureThreshold: 5, Cooldown: 30}, nil, testsupport.NewLogger())
+	if err != nil {
+		t.Fatalf("NewRoomWebhooks failed: %v", err)
+	}
+	sm := protocol.NewSignalingManager(testsupport.NewLogger())
+	h := NewHandler(&config.Config{}, testsupport.NewLogger(), sm, testsupport.NewWebSocketHandler(), WithRoomWebhooks(webhooks))
+
+	rec := httptest.NewRecorder()
+	h.RegisterWebhookHandler(rec, httptest.NewRequest("POST", "/admin/webhooks", strings.NewReader(`{"rooms":"acme/**","url":"ftp://recorder"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an invalid URL, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.RegisterWebhookHandler(rec, httptest.NewRequest("POST", "/admin/webhooks",
+		strings.NewReader(`{"rooms":"acme/**","url":"https://recorder.example.com/events","secret":"s3cret"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var registered WebhookResponse
+	json.NewDecoder(rec.Body).Decode(&registered)
+	if registered.Webhook.ID == "" || registered.Webhook.Secret != config.RedactedValue {
+		t.Errorf("Expected an ID and a masked secret, got %+v", registered.Webhook)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ListWebhooksHandler(rec, httptest.NewRequest("GET", "/admin/webhooks", nil))
+	var list WebhooksResponse
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Webhooks) != 1 || list.Webhooks[0].Rooms != "acme/**" || list.Webhooks[0].Secret != config.RedactedValue {
+		t.Errorf("Expected the registered webhook with its secret masked, got %+v", list.Webhooks)
+	}
+
+	rec = httptest.NewRecorder()
+	h.UnregisterWebhookHandler(rec, httptest.NewRequest("POST", "/admin/webhooks/delete", strings.NewReader(`{"id":"`+registered.Webhook.ID+`"}`)))
+	if rec.Code != http.StatusOK || len(webhooks.List()) != 0 {
+		t.Errorf("Expected the webhook to be unregistered, got status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.UnregisterWebhookHandler(rec, httptest.NewRequest("POST", "/admin/webhooks/delete", strings.NewReader(`{"id":"`+registered.Webhook.ID+`"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for an unknown webhook, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestRestoreRoom(t *testing.T) {
+	ws := testsupport.NewWebSocketHandler()
+	sm := protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithConnections(ws), protocol.WithClosedRoomRetention(time.Hour))
+	h := NewHandler(&config.Config{}, testsupport.NewLogger(), sm, ws)
+	joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "acme/standup"})
+	sm.ProcessMessage(joinJSON, "client-1", func(string, []byte) error { return nil })
+	sm.CloseRooms("acme/**", "", false)
+
+	rec := httptest.NewRecorder()
+	h.ClosedRoomsHandler(rec, httptest.NewRequest("GET", "/admin/rooms/closed", nil))
+	var closed ClosedRoomsResponse
+	json.NewDecoder(rec.Body).Decode(&closed)
+	if len(closed.Rooms) != 1 || closed.Rooms[0].ID != "acme/standup" {
+		t.Fatalf("Expected the closed room, got %+v", closed.Rooms)
+	}
+
+	rec = httptest.NewRecorder()
+	h.RestoreRoomHandler(rec, httptest.NewRequest("POST", "/admin/rooms/restore", strings.NewReader(`{"room":"acme/standup","dryRun":true}`)))
+	if rec.Code != http.StatusOK || sm.RoomExists("acme/standup") {
+		t.Fatalf("Expected a dry run to leave the room closed, got status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.RestoreRoomHandler(rec, httptest.NewRequest("POST", "/admin/rooms/restore", strings.NewReader(`{"room":"acme/standup"}`)))
+	var resp RestoreRoomResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || !sm.RoomExists("acme/standup") || !reflect.DeepEqual(resp.Room.Peers, []string{"client-1"}) {
+		t.Errorf("Expected the room to be restored, got status %d and %+v", rec.Code, resp)
+	}
+
+	rec = httptest.NewRecorder()
+	h.RestoreRoomHandler(rec, httptest.NewRequest("POST", "/admin/rooms/restore", strings.NewReader(`{"room":"acme/standup"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for a room not closed, got %d", http.StatusNotFound, rec.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// sensitiveFieldMarkers identify log fields whose values are masked in debug
+// bundles, including client addresses which are personal data
+var sensitiveFieldMarkers = []string{"token", "password", "secret", "authorization", "cookie", "addr"}
+
+// clientLister is implemented by WebSocket handlers able to list their clients
+type clientLister interface {
+	ClientIDs() []string
+}
+
+// DebugBundleHandler writes a zip archive with redacted server state for support tickets
+func (h *Handler) DebugBundleHandler(w http.ResponseWriter, r *http.Request) {
+	filename := fmt.Sprintf("debug-bundle-%s.zip", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if err := h.writeBundle(w); err != nil {
+		h.logger.Error("Failed to write debug bundle", "error", err)
+		return
+	}
+
+	h.auditOperation(r, "debug_bundle")
+}
+
+// writeBundle writes the debug bundle zip archive
+func (h *Handler) writeBundle(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	clients := []string{}
+	if lister, ok := h.wsHandler.(clientLister); ok {
+		clients = lister.ClientIDs()
+	}
+
+	var redacted config.Config
+	if h.cfg != nil {
+		redacted = h.cfg.Redacted()
+	}
+
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"rooms.json", jsonWriter(redactRooms(h.manager.Snapshot()))},
+		{"clients.json", jsonWriter(clients)},
+		{"config.json", jsonWriter(redacted)},
+		{"logs.json", jsonWriter(h.recentLogs())},
+		{"goroutines.txt", func(w io.Writer) error {
+			return pprof.Lookup("goroutine").WriteTo(w, 2)
+		}},
+	}
+
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", file.name, err)
+		}
+		if err := file.write(fw); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+
+	return zw.Close()
+}
+
+// recentLogs returns the recorded log entries with sensitive fields masked
+func (h *Handler) recentLogs() []logging.Entry {
+	if h.recorder == nil {
+		return []logging.Entry{}
+	}
+
+	entries := h.recorder.Entries()
+	for i := range entries {
+		fields := make(map[string]string, len(entries[i].Fields))
+		for key, value := range entries[i].Fields {
+			if isSensitiveField(key) {
+				value = config.RedactedValue
+			}
+			fields[key] = value
+		}
+		entries[i].Fields = fields
+	}
+	return entries
+}
+
+// redactRooms masks the values of room metadata, which is supplied by clients
+// and may hold personal data, keeping its keys for debugging
+func redactRooms(rooms []protocol.RoomSnapshot) []protocol.RoomSnapshot {
+	for i := range rooms {
+		if len(rooms[i].Metadata) == 0 {
+			continue
+		}
+
+		var metadata map[string]json.RawMessage
+		if err := json.Unmarshal(rooms[i].Metadata, &metadata); err != nil {
+			rooms[i].Metadata = nil
+			continue
+		}
+
+		redacted := make(map[string]string, len(metadata))
+		for key := range metadata {
+			redacted[key] = config.RedactedValue
+		}
+		rooms[i].Metadata, _ = json.Marshal(redacted)
+	}
+	return rooms
+}
+
+// isSensitiveField reports whether a log field may contain secrets
+func isSensitiveField(key string) bool {
+	key = strings.ToLower(key)
+	for _, marker := range sensitiveFieldMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonWriter returns a function writing v as indented JSON
+func jsonWriter(v interface{}) func(io.Writer) error {
+	return func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+}
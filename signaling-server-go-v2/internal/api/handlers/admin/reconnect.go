@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+)
+
+// ReconnectReason is the close frame reason of the connections closed at the
+// deadline of a please-reconnect request
+const ReconnectReason = "reconnect requested"
+
+// ReconnectRequest is the request body of the reconnect clients endpoint
+type ReconnectRequest struct {
+	// Percent of the connections asked to reconnect, picked at random
+	Percent float64 `json:"percent"`
+
+	// Window is the time in seconds over which the deadlines of the clients
+	// are spread, so that they do not all reconnect at once
+	Window int `json:"window"`
+
+	Message string `json:"message,omitempty"`
+
+	// Force closes the connections still open at their deadline
+	Force  bool `json:"force"`
+	DryRun bool `json:"dryRun"`
+}
+
+// ReconnectResponse is the response of the reconnect clients endpoint
+type ReconnectResponse struct {
+	DryRun      bool     `json:"dryRun"`
+	Connections int      `json:"connections"`
+	Clients     []string `json:"clients"`
+}
+
+// ReconnectClientsHandler sends a please-reconnect request, with a deadline
+// jittered over the window, to a random percentage of the connections, so
+// that clients move onto new server versions gradually
+func (h *Handler) ReconnectClientsHandler(w http.ResponseWriter, r *http.Request) {
+	var req ReconnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Percent <= 0 || req.Percent > 100 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "percent between 0 and 100 is required"})
+		return
+	}
+	if req.Window < 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "window must not be negative"})
+		return
+	}
+
+	lister, ok := h.wsHandler.(clientLister)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "connections cannot be listed"})
+		return
+	}
+	connections := lister.ClientIDs()
+	clients := pickClients(connections, req.Percent)
+
+	if !req.DryRun {
+		window := time.Duration(req.Window) * time.Second
+		for _, clientID := range clients {
+			h.requestReconnect(clientID, jitter(window), req.Message, req.Force)
+		}
+	}
+
+	h.auditOperation(r, "reconnect_clients",
+		"percent", req.Percent,
+		"window", req.Window,
+		"force", req.Force,
+		"dry_run", req.DryRun,
+		"clients", len(clients),
+	)
+
+	writeJSON(w, http.StatusOK, ReconnectResponse{DryRun: req.DryRun, Connections: len(connections), Clients: clients})
+}
+
+// requestReconnect asks a client to reconnect within reconnectIn, closing its
+// connection then if force is set
+func (h *Handler) requestReconnect(clientID string, reconnectIn time.Duration, message string, force bool) {
+	request, err := protocol.NewReconnectRequest(time.Now(), reconnectIn, message)
+	if err != nil {
+		h.logger.Error("Failed to build reconnect request", "error", err)
+		return
+	}
+	if err := h.wsHandler.SendMessage(clientID, request); err != nil {
+		h.logger.Warn("Failed to send reconnect request", "error", err, "client_id", clientID)
+		return
+	}
+
+	if force {
+		time.AfterFunc(reconnectIn, func() {
+			// Clients that reconnected are gone, or have closed the connection already
+			h.wsHandler.CloseConnectionWithReason(clientID, websocket.CloseGoingAway, ReconnectReason)
+		})
+	}
+}
+
+// pickClients returns a random percentage of the clients, at least one if
+// there are any
+func pickClients(clients []string, percent float64) []string {
+	picked := append([]string{}, clients...)
+	rand.Shuffle(len(picked), func(i, j int) {
+		picked[i], picked[j] = picked[j], picked[i]
+	})
+
+	count := int(float64(len(picked))*percent/100 + 0.5)
+	if count == 0 && len(picked) > 0 {
+		count = 1
+	}
+	return picked[:count]
+}
+
+// jitter returns a random duration of up to window
+func jitter(window time.Duration) time.Duration {
+	if window <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(window) + 1))
+}
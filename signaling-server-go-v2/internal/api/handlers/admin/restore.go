@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+)
+
+// RestoreRoomRequest is the request body of the restore room endpoint
+type RestoreRoomRequest struct {
+	Room   string `json:"room"`
+	DryRun bool   `json:"dryRun"`
+}
+
+// RestoreRoomResponse is the response of the restore room endpoint
+type RestoreRoomResponse struct {
+	DryRun bool                `json:"dryRun"`
+	Room   protocol.ClosedRoom `json:"room"`
+}
+
+// ClosedRoomsResponse is the response of the closed rooms endpoint
+type ClosedRoomsResponse struct {
+	Rooms []protocol.ClosedRoom `json:"rooms"`
+}
+
+// ClosedRoomsHandler lists the rooms closed through the admin API that can
+// still be restored
+func (h *Handler) ClosedRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ClosedRoomsResponse{Rooms: h.manager.ClosedRooms()})
+}
+
+// RestoreRoomHandler re-creates a room closed by mistake, asking its
+// previous peers to rejoin
+func (h *Handler) RestoreRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var req RestoreRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Room == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "room is required"})
+		return
+	}
+
+	var room protocol.ClosedRoom
+	var err error
+	if req.DryRun {
+		err = protocol.ErrRoomNotRestorable
+		for _, closed := range h.manager.ClosedRooms() {
+			if closed.ID == req.Room {
+				room, err = closed, nil
+			}
+		}
+	} else {
+		room, err = h.manager.RestoreRoom(req.Room)
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.auditOperation(r, "restore_room",
+		"room_id", req.Room,
+		"dry_run", req.DryRun,
+		"clients", len(room.Peers),
+	)
+
+	writeJSON(w, http.StatusOK, RestoreRoomResponse{DryRun: req.DryRun, Room: room})
+}
@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+)
+
+// SignalingStats are the hub and room statistics of the stats endpoint
+type SignalingStats struct {
+	websocket.HubStats
+
+	Rooms int `json:"rooms"`
+
+	// RoomPeers is the number of peers of each room
+	RoomPeers map[string]int `json:"roomPeers"`
+}
+
+// StatsHandler returns the hub and room statistics in the format of expvar's
+// /debug/vars, alongside its cmdline and memstats variables, for scripts and
+// tools such as expvarmon where a Prometheus stack is overkill
+func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := SignalingStats{RoomPeers: make(map[string]int)}
+	if reporter, ok := h.wsHandler.(websocket.StatsReporter); ok {
+		stats.HubStats = reporter.Stats()
+	}
+	for _, room := range h.manager.Snapshot() {
+		stats.RoomPeers[room.ID] = len(room.Peers)
+	}
+	stats.Rooms = len(stats.RoomPeers)
+
+	var memstats runtime.MemStats
+	runtime.ReadMemStats(&memstats)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"cmdline":   os.Args,
+		"memstats":  memstats,
+		"signaling": stats,
+	})
+}
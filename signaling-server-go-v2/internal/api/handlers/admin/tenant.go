@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// tenantKey is the request context key of the tenant a request's token is scoped to
+type tenantKey struct{}
+
+// AuthorizeTenant wraps a handler so it requires the configured bearer
+// token or a tenant token. Requests authorized with a tenant token may only
+// address the rooms and clients of its tenant.
+func (h *Handler) AuthorizeTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if tenant, ok := h.tokenTenant(token); ok {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+			return
+		}
+		h.logger.Warn("Unauthorized admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized"})
+	})
+}
+
+// tokenTenant returns the tenant of a tenant token, comparing the token with
+// each configured token in constant time
+func (h *Handler) tokenTenant(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+
+	tenant, found := "", false
+	for candidate, candidateTenant := range h.tenantTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			tenant, found = candidateTenant, true
+		}
+	}
+	return tenant, found
+}
+
+// tenantOf returns the tenant a request is scoped to, reporting false if it
+// was authorized with the global token
+func tenantOf(r *http.Request) (string, bool) {
+	tenant, ok := r.Context().Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// inTenant reports whether a room ID or pattern lies within the tenant the
+// request is scoped to, writing a permission denied error if not. Requests
+// authorized with the global token may address any room.
+func (h *Handler) inTenant(w http.ResponseWriter, r *http.Request, room string) bool {
+	tenant, ok := tenantOf(r)
+	if !ok {
+		return true
+	}
+	// Tenants are literal namespace segments, so a pattern with the tenant's
+	// prefix only matches its rooms
+	if _, ok := protocol.UnqualifyRoom(tenant, room); ok {
+		return true
+	}
+	h.writeError(w, errs.Newf(errs.PermissionDenied, "%s is outside tenant %s", room, tenant))
+	return false
+}
@@ -0,0 +1,145 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/events"
+)
+
+// unregisterTimeout bounds the delivery of the events buffered for a room
+// webhook being unregistered
+const unregisterTimeout = 5 * time.Second
+
+// RegisterWebhookRequest is the request body of the register webhook endpoint
+type RegisterWebhookRequest struct {
+	events.Registration
+	DryRun bool `json:"dryRun"`
+}
+
+// UnregisterWebhookRequest is the request body of the unregister webhook endpoint
+type UnregisterWebhookRequest struct {
+	ID     string `json:"id"`
+	DryRun bool   `json:"dryRun"`
+}
+
+// WebhookResponse is the response of the register and unregister webhook endpoints
+type WebhookResponse struct {
+	DryRun  bool                `json:"dryRun"`
+	Webhook events.Registration `json:"webhook"`
+}
+
+// WebhooksResponse is the response of the list webhooks endpoint
+type WebhooksResponse struct {
+	Webhooks []events.Registration `json:"webhooks"`
+}
+
+// WithRoomWebhooks lets the admin API register webhooks for rooms
+func WithRoomWebhooks(webhooks *events.RoomWebhooks) Option {
+	return func(h *Handler) {
+		h.webhooks = webhooks
+	}
+}
+
+// ListWebhooksHandler returns the room webhooks with their secrets masked
+func (h *Handler) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if h.webhooks == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "room webhooks are not enabled"})
+		return
+	}
+
+	registrations := h.webhooks.List()
+	for i := range registrations {
+		registrations[i] = redactRegistration(registrations[i])
+	}
+	writeJSON(w, http.StatusOK, WebhooksResponse{Webhooks: registrations})
+}
+
+// RegisterWebhookHandler registers a webhook receiving the events of the
+// rooms matching a pattern
+func (h *Handler) RegisterWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if h.webhooks == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "room webhooks are not enabled"})
+		return
+	}
+
+	var req RegisterWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "rooms and url are required"})
+		return
+	}
+
+	registration := req.Registration
+	if err := events.ValidateRegistration(registration); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if !req.DryRun {
+		var err error
+		if registration, err = h.webhooks.Register(registration); err != nil {
+			h.writeError(w, err)
+			return
+		}
+	}
+
+	h.auditOperation(r, "register_webhook",
+		"id", registration.ID,
+		"rooms", registration.Rooms,
+		"url", registration.URL,
+		"events", registration.Events,
+		"dry_run", req.DryRun,
+	)
+
+	writeJSON(w, http.StatusOK, WebhookResponse{DryRun: req.DryRun, Webhook: redactRegistration(registration)})
+}
+
+// UnregisterWebhookHandler removes a room webhook
+func (h *Handler) UnregisterWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if h.webhooks == nil {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{Error: "room webhooks are not enabled"})
+		return
+	}
+
+	var req UnregisterWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "id is required"})
+		return
+	}
+
+	var registration events.Registration
+	var err error
+	if req.DryRun {
+		var ok bool
+		if registration, ok = h.webhooks.Get(req.ID); !ok {
+			err = events.ErrRegistrationNotFound
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), unregisterTimeout)
+		defer cancel()
+		registration, err = h.webhooks.Unregister(ctx, req.ID)
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.auditOperation(r, "unregister_webhook",
+		"id", registration.ID,
+		"rooms", registration.Rooms,
+		"url", registration.URL,
+		"dry_run", req.DryRun,
+	)
+
+	writeJSON(w, http.StatusOK, WebhookResponse{DryRun: req.DryRun, Webhook: redactRegistration(registration)})
+}
+
+// redactRegistration masks the secret of a registration
+func redactRegistration(registration events.Registration) events.Registration {
+	if registration.Secret != "" {
+		registration.Secret = config.RedactedValue
+	}
+	return registration
+}
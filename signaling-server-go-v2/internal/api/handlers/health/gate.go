@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Gate is a warm-up task that must complete before the instance is ready,
+// such as connecting to its stores or syncing the rooms of the cluster. The
+// readiness check of a gate is DOWN until the gate opens and stays UP
+// afterwards; failures of the dependency once warm are left to its own
+// checks, e.g. to degrade rather than take the instance out of rotation.
+type Gate struct {
+	name string
+
+	mutex   sync.Mutex
+	open    bool
+	lastErr error
+}
+
+// NewGate creates a closed gate
+func NewGate(name string) *Gate {
+	return &Gate{name: name}
+}
+
+// Name returns the name of the gate's readiness check
+func (g *Gate) Name() string {
+	return g.name
+}
+
+// Open marks the warm-up task complete
+func (g *Gate) Open() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.open = true
+	g.lastErr = nil
+}
+
+// Fail records why the warm-up task has not completed yet, reported by the
+// readiness check while the gate is closed
+func (g *Gate) Fail(err error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.open {
+		g.lastErr = err
+	}
+}
+
+// Ready returns whether the gate is open
+func (g *Gate) Ready() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.open
+}
+
+// Check is the readiness check of the gate
+func (g *Gate) Check() (Status, string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	switch {
+	case g.open:
+		return StatusUp, ""
+	case g.lastErr != nil:
+		return StatusDown, "warming up: " + g.lastErr.Error()
+	default:
+		return StatusDown, "warming up"
+	}
+}
+
+// WarmUp runs the task every interval until it succeeds or the context is
+// done, then opens the gate. It returns whether the gate opened.
+func (g *Gate) WarmUp(ctx context.Context, interval time.Duration, task func() error) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := task()
+		if err == nil {
+			g.Open()
+			return true
+		}
+		g.Fail(err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
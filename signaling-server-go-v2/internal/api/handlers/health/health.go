@@ -17,6 +17,10 @@ const (
 
 	// StatusDown indicates the service is down
 	StatusDown Status = "DOWN"
+
+	// StatusDegraded indicates the service works with reduced functionality,
+	// e.g. without exporting telemetry; it does not fail the check endpoints
+	StatusDegraded Status = "DEGRADED"
 )
 
 // HealthResponse represents the response from a health check endpoint
@@ -75,9 +79,7 @@ func (h *Handler) LiveHandler(w http.ResponseWriter, r *http.Request) {
 			Message: message,
 		}
 
-		if status == StatusDown {
-			resp.Status = StatusDown
-		}
+		resp.Status = worse(resp.Status, status)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -112,9 +114,7 @@ func (h *Handler) ReadyHandler(w http.ResponseWriter, r *http.Request) {
 			Message: message,
 		}
 
-		if status == StatusDown {
-			resp.Status = StatusDown
-		}
+		resp.Status = worse(resp.Status, status)
 	}
 
 	// Then run all readiness-specific checks
@@ -125,9 +125,7 @@ func (h *Handler) ReadyHandler(w http.ResponseWriter, r *http.Request) {
 			Message: message,
 		}
 
-		if status == StatusDown {
-			resp.Status = StatusDown
-		}
+		resp.Status = worse(resp.Status, status)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -143,3 +141,14 @@ func (h *Handler) ReadyHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+// worse returns the more severe of two statuses
+func worse(a, b Status) Status {
+	if a == StatusDown || b == StatusDown {
+		return StatusDown
+	}
+	if a == StatusDegraded || b == StatusDegraded {
+		return StatusDegraded
+	}
+	return StatusUp
+}
@@ -1,26 +1,20 @@
 package health
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
-	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
 )
 
-// MockLogger implements logging.Logger for testing
-type MockLogger struct{}
-
-func (l *MockLogger) Debug(msg string, keyvals ...interface{})   {}
-func (l *MockLogger) Info(msg string, keyvals ...interface{})    {}
-func (l *MockLogger) Warn(msg string, keyvals ...interface{})    {}
-func (l *MockLogger) Error(msg string, keyvals ...interface{})   {}
-func (l *MockLogger) With(keyvals ...interface{}) logging.Logger { return l }
-
 func TestLivenessHandler(t *testing.T) {
 	// Create a new health handler
-	handler := NewHandler(&MockLogger{})
+	handler := NewHandler(testsupport.NewLogger())
 
 	// Add a check that will pass
 	handler.AddLivenessCheck("service-status", func() (Status, string) {
@@ -59,7 +53,7 @@ func TestLivenessHandler(t *testing.T) {
 
 func TestLivenessHandlerWithFailedCheck(t *testing.T) {
 	// Create a new health handler
-	handler := NewHandler(&MockLogger{})
+	handler := NewHandler(testsupport.NewLogger())
 
 	// Add a check that will fail
 	handler.AddLivenessCheck("failing-check", func() (Status, string) {
@@ -92,7 +86,7 @@ func TestLivenessHandlerWithFailedCheck(t *testing.T) {
 
 func TestReadinessHandler(t *testing.T) {
 	// Create a new health handler
-	handler := NewHandler(&MockLogger{})
+	handler := NewHandler(testsupport.NewLogger())
 
 	// Add a check that will pass
 	handler.AddReadinessCheck("database-connection", func() (Status, string) {
@@ -131,7 +125,7 @@ func TestReadinessHandler(t *testing.T) {
 
 func TestReadinessHandlerWithFailedCheck(t *testing.T) {
 	// Create a new health handler
-	handler := NewHandler(&MockLogger{})
+	handler := NewHandler(testsupport.NewLogger())
 
 	// Add a check that will fail
 	handler.AddReadinessCheck("external-api", func() (Status, string) {
@@ -161,3 +155,77 @@ func TestReadinessHandlerWithFailedCheck(t *testing.T) {
 		t.Errorf("Expected status %s, got %s", StatusDown, response.Status)
 	}
 }
+
+func TestDegradedCheckDoesNotFailEndpoints(t *testing.T) {
+	handler := NewHandler(testsupport.NewLogger())
+	handler.AddLivenessCheck("exporters", func() (Status, string) {
+		return StatusDegraded, "otlp_traces open"
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ReadyHandler(rec, httptest.NewRequest("GET", "/health/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var response HealthResponse
+	json.NewDecoder(rec.Body).Decode(&response)
+	if response.Status != StatusDegraded || response.Checks["exporters"].Message != "otlp_traces open" {
+		t.Errorf("Expected a degraded status with the breaker state, got %+v", response)
+	}
+
+	// A failing check takes precedence
+	handler.AddLivenessCheck("failing-check", func() (Status, string) {
+		return StatusDown, "Service is down"
+	})
+	rec = httptest.NewRecorder()
+	handler.LiveHandler(rec, httptest.NewRequest("GET", "/health/live", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestReadinessGate(t *testing.T) {
+	handler := NewHandler(testsupport.NewLogger())
+	gate := NewGate("cluster")
+	handler.AddReadinessCheck(gate.Name(), gate.Check)
+
+	ready := func() (int, CheckStatus) {
+		rec := httptest.NewRecorder()
+		handler.ReadyHandler(rec, httptest.NewRequest("GET", "/health/ready", nil))
+
+		var response HealthResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+		return rec.Code, response.Checks["cluster"]
+	}
+
+	if code, check := ready(); code != http.StatusServiceUnavailable || check.Message != "warming up" {
+		t.Errorf("Expected a closed gate to fail readiness, got %d %+v", code, check)
+	}
+
+	// The task is retried until it succeeds, reporting its last failure
+	attempts := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	opened := gate.WarmUp(ctx, time.Millisecond, func() error {
+		attempts++
+		if attempts < 3 {
+			if code, check := ready(); attempts > 1 && (code != http.StatusServiceUnavailable || check.Message != "warming up: unreachable") {
+				t.Errorf("Expected the last failure to be reported, got %d %+v", code, check)
+			}
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	if !opened || attempts != 3 {
+		t.Fatalf("Expected the gate to open on the third attempt, got %v after %d", opened, attempts)
+	}
+
+	// An open gate stays open
+	gate.Fail(errors.New("unreachable"))
+	if code, check := ready(); code != http.StatusOK || check.Status != StatusUp {
+		t.Errorf("Expected an open gate to pass readiness, got %d %+v", code, check)
+	}
+}
@@ -0,0 +1,47 @@
+package ice
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/ice"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// ConfigResponse is the response of the ICE config endpoint. Its iceServers
+// can be passed to the RTCPeerConnection constructor as they are.
+type ConfigResponse struct {
+	ICEServers []ice.Server `json:"iceServers"`
+	TTL        int          `json:"ttl"` // in seconds the TURN credentials are valid
+}
+
+// Handler serves the ICE servers clients use to build their peer connections
+type Handler struct {
+	provider *ice.Provider
+	logger   logging.Logger
+}
+
+// NewHandler creates a new ICE config handler
+func NewHandler(provider *ice.Provider, logger logging.Logger) *Handler {
+	return &Handler{
+		provider: provider,
+		logger:   logger.With("component", "ice"),
+	}
+}
+
+// ConfigHandler returns the STUN and TURN servers with fresh TURN
+// credentials, labelled with the optional user query parameter
+func (h *Handler) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	h.logger.Debug("Issuing ICE config", "remote_addr", r.RemoteAddr)
+
+	resp := ConfigResponse{
+		ICEServers: h.provider.Servers(r.URL.Query().Get("user")),
+		TTL:        int(h.provider.TTL().Seconds()),
+	}
+
+	// Credentials must not be shared through caches
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,45 @@
+package ice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/ice"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestConfigHandler(t *testing.T) {
+	provider := ice.NewProvider(config.ICEConfig{
+		STUNURLs:      []string{"stun:stun.example.com"},
+		TURNURLs:      []string{"turn:turn.example.com"},
+		TURNSecret:    "north",
+		CredentialTTL: 600,
+	})
+	h := NewHandler(provider, testsupport.NewLogger())
+
+	rec := httptest.NewRecorder()
+	h.ConfigHandler(rec, httptest.NewRequest("GET", "/ice-config?user=alice", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Expected credentials not to be cached, got Cache-Control %q", cc)
+	}
+
+	var resp ConfigResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if resp.TTL != 600 || len(resp.ICEServers) != 2 {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+	turn := resp.ICEServers[1]
+	if !strings.HasSuffix(turn.Username, ":alice") || turn.Credential != ice.Credential([]byte("north"), turn.Username) {
+		t.Errorf("Expected credentials for alice, got %+v", turn)
+	}
+}
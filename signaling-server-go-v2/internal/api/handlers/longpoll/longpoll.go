@@ -0,0 +1,276 @@
+// Package longpoll serves the signaling protocol over HTTP long-polling, for
+// restrictive networks where neither WebSocket nor event streams get through.
+// A client opens a session with a first poll, then polls for its messages and
+// sends its messages in separate requests carrying the session token.
+// Sessions are attached to the WebSocket handler's client registry, so
+// long-polling and WebSocket clients share rooms.
+package longpoll
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// TokenHeader carries the session token on polls and sends. Load balancers
+// spreading sessions across nodes must route by it, as sessions live on the
+// node that opened them.
+const TokenHeader = "X-Signaling-Session-Token"
+
+// writeMargin is the time left to write a poll response after its timeout
+const writeMargin = 5 * time.Second
+
+// SessionResponse is the response to the poll opening a session
+type SessionResponse struct {
+	ClientID string `json:"client_id"`
+	Token    string `json:"token"`
+}
+
+// PollResponse is the response to a poll: the messages sent to the client,
+// empty if none arrived before the poll timed out
+type PollResponse struct {
+	Messages []json.RawMessage `json:"messages"`
+}
+
+// session is a long-polling client. Only its latest poll waits for messages.
+type session struct {
+	conn websocket.Conn
+
+	// cancel ends the waiting poll, nil if none
+	cancel context.CancelFunc
+
+	// polls counts the polls, so that a superseded poll leaves the idle timer to the newer one
+	polls uint64
+
+	// idle closes the session once it was not polled for the idle timeout
+	idle *time.Timer
+}
+
+// Handler serves long-polling sessions
+type Handler struct {
+	attacher       websocket.Attacher
+	checkOrigin    func(r *http.Request) bool
+	pollTimeout    time.Duration
+	idleTimeout    time.Duration
+	maxMessageSize int64
+	logger         logging.Logger
+
+	sessions map[string]*session
+	mutex    sync.Mutex
+}
+
+// NewHandler creates a Handler attaching its sessions to the WebSocket
+// handler. Polls are answered after the poll timeout if no message arrived,
+// and sessions not polled for the idle timeout are closed. The allowed
+// origins and message size limit are those of WebSocket connections.
+func NewHandler(attacher websocket.Attacher, cfg config.WebSocketConfig, pollTimeout, idleTimeout time.Duration, logger logging.Logger) *Handler {
+	return &Handler{
+		attacher:       attacher,
+		checkOrigin:    websocket.CheckOrigin(cfg.AllowedOrigins),
+		pollTimeout:    pollTimeout,
+		idleTimeout:    idleTimeout,
+		maxMessageSize: cfg.MaxMessageSize,
+		logger:         logger.With("component", "longpoll"),
+		sessions:       make(map[string]*session),
+	}
+}
+
+// PollHandler opens a session for polls without a token, and otherwise
+// waits for the messages of the session until the poll timeout. Polls of a
+// closed or expired session are answered with 410 Gone.
+func (h *Handler) PollHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.checkOrigin(r) {
+		h.logger.WarnCtx(r.Context(), "Rejected poll from disallowed origin", "origin", r.Header.Get("Origin"), "remote_addr", r.RemoteAddr)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	token := r.Header.Get(TokenHeader)
+	if token == "" {
+		h.open(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.pollTimeout)
+	defer cancel()
+
+	h.mutex.Lock()
+	s, ok := h.sessions[token]
+	var poll uint64
+	if ok {
+		// A new poll supersedes the waiting one, e.g. after the client retried
+		if s.cancel != nil {
+			s.cancel()
+		}
+		s.cancel = cancel
+		s.polls++
+		poll = s.polls
+		s.idle.Stop()
+	}
+	h.mutex.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusGone)
+		return
+	}
+	defer func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		if _, ok := h.sessions[token]; ok && s.polls == poll {
+			s.cancel = nil
+			s.idle.Reset(h.idleTimeout)
+		}
+	}()
+
+	// The poll may outlast the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(h.pollTimeout + writeMargin)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.WarnCtx(r.Context(), "Failed to extend write deadline of poll", "error", err)
+	}
+
+	message, ok := s.conn.Next(ctx)
+	if !ok {
+		select {
+		case <-ctx.Done():
+			// Timed out, or superseded by a newer poll
+			writeJSON(w, PollResponse{Messages: []json.RawMessage{}})
+		default:
+			h.close(token, s)
+			http.Error(w, "session closed", http.StatusGone)
+		}
+		return
+	}
+
+	// Messages that arrived together are returned together; any left are
+	// returned by the next poll
+	resp := PollResponse{Messages: []json.RawMessage{message}}
+	done, stop := context.WithCancel(ctx)
+	stop()
+	for {
+		message, ok := s.conn.Next(done)
+		if !ok {
+			break
+		}
+		resp.Messages = append(resp.Messages, message)
+	}
+	writeJSON(w, resp)
+}
+
+// SendHandler handles a message sent by the client of the session whose
+// token is in the TokenHeader. Replies and errors are returned by polls, so
+// the message is accepted as soon as it was handled.
+func (h *Handler) SendHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	h.mutex.Lock()
+	s, ok := h.sessions[r.Header.Get(TokenHeader)]
+	h.mutex.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusGone)
+		return
+	}
+
+	if h.maxMessageSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxMessageSize)
+	}
+	message, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := s.conn.Receive(message); err != nil {
+		h.logger.DebugCtx(r.Context(), "Failed to handle message", "error", err, "client_id", s.conn.ID())
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Close closes all sessions, e.g. on shutdown
+func (h *Handler) Close() {
+	h.mutex.Lock()
+	sessions := h.sessions
+	h.sessions = make(map[string]*session)
+	h.mutex.Unlock()
+
+	for _, s := range sessions {
+		s.idle.Stop()
+		s.conn.Disconnect()
+	}
+}
+
+// open attaches a client and returns the token of its new session
+func (h *Handler) open(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.attacher.Attach(r)
+	if errors.Is(err, websocket.ErrTooManyConnections) {
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		h.logger.ErrorCtx(r.Context(), "Failed to attach long-polling session", "error", err)
+		http.Error(w, "failed to open session", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		conn.Disconnect()
+		h.logger.ErrorCtx(r.Context(), "Failed to open long-polling session", "error", err)
+		http.Error(w, "failed to open session", http.StatusInternalServerError)
+		return
+	}
+
+	s := &session{conn: conn}
+	h.mutex.Lock()
+	s.idle = time.AfterFunc(h.idleTimeout, func() {
+		h.logger.Info("Long-polling session expired", "client_id", conn.ID())
+		h.close(token, s)
+	})
+	h.sessions[token] = s
+	h.mutex.Unlock()
+
+	h.logger.InfoCtx(r.Context(), "Long-polling session opened", "client_id", conn.ID())
+	writeJSON(w, SessionResponse{ClientID: conn.ID(), Token: token})
+}
+
+// close forgets a session and disconnects its client, unless it was already closed
+func (h *Handler) close(token string, s *session) {
+	h.mutex.Lock()
+	current, ok := h.sessions[token]
+	if ok && current == s {
+		delete(h.sessions, token)
+		s.idle.Stop()
+	}
+	h.mutex.Unlock()
+
+	if ok && current == s {
+		s.conn.Disconnect()
+	}
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+// newToken generates the token of a session
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
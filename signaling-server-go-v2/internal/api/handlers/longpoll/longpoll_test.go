@@ -0,0 +1,126 @@
+package longpoll
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/gorilla"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func newSignaling() (*gorilla.Handler, *protocol.SignalingManager) {
+	var sm *protocol.SignalingManager
+	var ws *gorilla.Handler
+	ws = gorilla.NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		gorilla.WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			return sm.ProcessMessage(message, clientID, ws.SendMessage)
+		}),
+		gorilla.WithDisconnectHandler(func(clientID string) {
+			sm.RemoveClient(clientID)
+		}),
+	).(*gorilla.Handler)
+	sm = protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithConnections(ws))
+	return ws, sm
+}
+
+// request calls a handler with the session token, returning the recorded response
+func request(handler http.HandlerFunc, path, token string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set(TokenHeader, token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestLongPolling(t *testing.T) {
+	ws, sm := newSignaling()
+	h := NewHandler(ws, config.WebSocketConfig{MaxMessageSize: 1024}, 50*time.Millisecond, time.Minute, testsupport.NewLogger())
+	defer h.Close()
+
+	rec := request(h.PollHandler, "/poll", "", nil)
+	var session SessionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); rec.Code != http.StatusOK || err != nil || session.Token == "" {
+		t.Fatalf("Expected a session, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// The first poll gets the welcome message, the next times out empty
+	rec = request(h.PollHandler, "/poll", session.Token, nil)
+	var poll PollResponse
+	var welcome protocol.Message
+	if json.Unmarshal(rec.Body.Bytes(), &poll); len(poll.Messages) != 1 || json.Unmarshal(poll.Messages[0], &welcome) != nil || welcome.Type != protocol.Welcome {
+		t.Fatalf("Expected a welcome message, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = request(h.PollHandler, "/poll", session.Token, nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &poll); rec.Code != http.StatusOK || err != nil || len(poll.Messages) != 0 {
+		t.Fatalf("Expected an empty poll, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	join, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "call"})
+	if rec := request(h.SendHandler, "/send", "unknown", join); rec.Code != http.StatusGone {
+		t.Errorf("Expected an unknown session to be rejected, got %d", rec.Code)
+	}
+	if rec := request(h.SendHandler, "/send", session.Token, join); rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected the join to be accepted, got %d", rec.Code)
+	}
+
+	rec = request(h.PollHandler, "/poll", session.Token, nil)
+	json.Unmarshal(rec.Body.Bytes(), &poll)
+	if len(poll.Messages) == 0 {
+		t.Fatalf("Expected the joined message, got %s", rec.Body.String())
+	}
+	var joined protocol.Message
+	json.Unmarshal(poll.Messages[0], &joined)
+	if joined.Type != protocol.Joined || joined.Recipient != session.ClientID {
+		t.Errorf("Expected a joined message, got %+v", joined)
+	}
+	if peers := sm.GetPeersInRoom("call"); len(peers) != 1 || peers[0] != session.ClientID {
+		t.Errorf("Expected the session's client in the room, got %v", peers)
+	}
+
+	// Polls of a closed session are answered with 410 Gone
+	ws.CloseConnection(session.ClientID)
+	if rec := request(h.PollHandler, "/poll", session.Token, nil); rec.Code != http.StatusGone {
+		t.Errorf("Expected status %d for a closed session, got %d", http.StatusGone, rec.Code)
+	}
+	if rec := request(h.SendHandler, "/send", session.Token, join); rec.Code != http.StatusGone {
+		t.Errorf("Expected the closed session to be forgotten, got %d", rec.Code)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ws, sm := newSignaling()
+	h := NewHandler(ws, config.WebSocketConfig{}, 10*time.Millisecond, 50*time.Millisecond, testsupport.NewLogger())
+	defer h.Close()
+
+	var session SessionResponse
+	json.Unmarshal(request(h.PollHandler, "/poll", "", nil).Body.Bytes(), &session)
+	join, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "call"})
+	request(h.SendHandler, "/send", session.Token, join)
+
+	// Polling keeps the session open past the idle timeout
+	for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+		if rec := request(h.PollHandler, "/poll", session.Token, nil); rec.Code != http.StatusOK {
+			t.Fatalf("Expected the polled session to stay open, got %d", rec.Code)
+		}
+	}
+
+	// Without polls the session expires and its client leaves the room
+	for deadline := time.Now().Add(time.Second); len(sm.GetPeersInRoom("call")) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the idle session to be closed")
+		}
+	}
+	if rec := request(h.PollHandler, "/poll", session.Token, nil); rec.Code != http.StatusGone {
+		t.Errorf("Expected status %d for an expired session, got %d", http.StatusGone, rec.Code)
+	}
+}
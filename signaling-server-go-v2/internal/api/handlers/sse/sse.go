@@ -0,0 +1,227 @@
+// Package sse serves the signaling protocol over Server-Sent Events, as a
+// fallback for clients behind proxies that strip WebSocket upgrades. A client
+// receives its messages as events of a GET request and sends its messages as
+// POST requests. Connections are attached to the WebSocket handler's client
+// registry, so SSE and WebSocket clients share rooms.
+package sse
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// TokenHeader carries the token of the event stream on the POST requests
+// sending its client's messages. The token is not accepted in the query
+// string, where it would end up in access logs.
+const TokenHeader = "X-Signaling-Stream-Token"
+
+// ConnectedEvent is the data of the first event of a stream
+type ConnectedEvent struct {
+	ClientID string `json:"client_id"`
+
+	// Token authenticates the client's messages, sent in the TokenHeader
+	Token string `json:"token"`
+}
+
+// Handler serves event streams and the messages their clients send
+type Handler struct {
+	attacher       websocket.Attacher
+	checkOrigin    func(r *http.Request) bool
+	keepalive      time.Duration
+	maxMessageSize int64
+	logger         logging.Logger
+
+	// streams are the connections of the open event streams, by token
+	streams map[string]websocket.Conn
+	mutex   sync.Mutex
+}
+
+// NewHandler creates a Handler attaching its streams to the WebSocket
+// handler. Streams are sent a comment every keepalive interval so that
+// proxies do not close them while idle. The allowed origins and message size
+// limit are those of WebSocket connections.
+func NewHandler(attacher websocket.Attacher, cfg config.WebSocketConfig, keepalive time.Duration, logger logging.Logger) *Handler {
+	return &Handler{
+		attacher:       attacher,
+		checkOrigin:    websocket.CheckOrigin(cfg.AllowedOrigins),
+		keepalive:      keepalive,
+		maxMessageSize: cfg.MaxMessageSize,
+		logger:         logger.With("component", "sse"),
+		streams:        make(map[string]websocket.Conn),
+	}
+}
+
+// StreamHandler attaches a client and streams its messages as message
+// events until the client disconnects or its connection is closed
+func (h *Handler) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.checkOrigin(r) {
+		h.logger.WarnCtx(r.Context(), "Rejected event stream from disallowed origin", "origin", r.Header.Get("Origin"), "remote_addr", r.RemoteAddr)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	conn, err := h.attacher.Attach(r)
+	if errors.Is(err, websocket.ErrTooManyConnections) {
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		h.logger.ErrorCtx(r.Context(), "Failed to attach event stream", "error", err)
+		http.Error(w, "failed to open event stream", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Disconnect()
+
+	token, err := newToken()
+	if err != nil {
+		h.logger.ErrorCtx(r.Context(), "Failed to open event stream", "error", err)
+		http.Error(w, "failed to open event stream", http.StatusInternalServerError)
+		return
+	}
+	h.mutex.Lock()
+	h.streams[token] = conn
+	h.mutex.Unlock()
+	defer func() {
+		h.mutex.Lock()
+		delete(h.streams, token)
+		h.mutex.Unlock()
+	}()
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.WarnCtx(r.Context(), "Failed to clear write deadline of event stream", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// Ask buffering proxies such as nginx to pass events through as they are written
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	connected, _ := json.Marshal(ConnectedEvent{ClientID: conn.ID(), Token: token})
+	if err := writeEvent(w, "connected", connected); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		h.logger.ErrorCtx(r.Context(), "Event stream cannot be flushed", "error", err)
+		return
+	}
+	h.logger.InfoCtx(r.Context(), "Event stream opened", "client_id", conn.ID())
+
+	// Messages are read in the background, so that keepalives are written while waiting
+	messages := make(chan []byte)
+	go func() {
+		defer close(messages)
+		for {
+			message, ok := conn.Next(r.Context())
+			if !ok {
+				return
+			}
+			select {
+			case messages <- message:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if h.keepalive > 0 {
+		ticker := time.NewTicker(h.keepalive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				h.logger.InfoCtx(r.Context(), "Event stream closed", "client_id", conn.ID())
+				return
+			}
+			if err := writeEvent(w, "message", message); err != nil {
+				return
+			}
+		case <-tick:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Close disconnects the clients of all open streams, which ends them, e.g.
+// on shutdown
+func (h *Handler) Close() {
+	h.mutex.Lock()
+	conns := make([]websocket.Conn, 0, len(h.streams))
+	for _, conn := range h.streams {
+		conns = append(conns, conn)
+	}
+	h.mutex.Unlock()
+
+	for _, conn := range conns {
+		conn.Disconnect()
+	}
+}
+
+// MessageHandler handles a message sent by the client of the event stream
+// whose token is in the TokenHeader. Replies and errors are sent as events of
+// the stream, so the message is accepted as soon as it was handled.
+func (h *Handler) MessageHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	h.mutex.Lock()
+	conn, ok := h.streams[r.Header.Get(TokenHeader)]
+	h.mutex.Unlock()
+	if !ok {
+		http.Error(w, "unknown event stream", http.StatusUnauthorized)
+		return
+	}
+
+	if h.maxMessageSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxMessageSize)
+	}
+	message, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := conn.Receive(message); err != nil {
+		h.logger.DebugCtx(r.Context(), "Failed to handle message", "error", err, "client_id", conn.ID())
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// writeEvent writes an event; the data, JSON without newlines, fits a single data line
+func writeEvent(w io.Writer, event string, data []byte) error {
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// newToken generates the token of an event stream
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate stream token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,169 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/gorilla"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// event is an event read from a stream
+type event struct {
+	name string
+	data string
+}
+
+// readEvents sends the events of a stream until it ends
+func readEvents(resp *http.Response) <-chan event {
+	events := make(chan event, 16)
+	go func() {
+		defer close(events)
+		var e event
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			case line == "" && e.name != "":
+				events <- e
+				e = event{}
+			}
+		}
+	}()
+	return events
+}
+
+func next(t *testing.T, events <-chan event) event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("Stream ended")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an event")
+		return event{}
+	}
+}
+
+func TestStream(t *testing.T) {
+	var sm *protocol.SignalingManager
+	var ws *gorilla.Handler
+	ws = gorilla.NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		gorilla.WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			return sm.ProcessMessage(message, clientID, ws.SendMessage)
+		}),
+		gorilla.WithDisconnectHandler(func(clientID string) {
+			sm.RemoveClient(clientID)
+		}),
+	).(*gorilla.Handler)
+	sm = protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithConnections(ws))
+
+	h := NewHandler(ws, config.WebSocketConfig{MaxMessageSize: 1024}, time.Minute, testsupport.NewLogger())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.MessageHandler(w, r)
+			return
+		}
+		h.StreamHandler(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+	events := readEvents(resp)
+
+	first := next(t, events)
+	var connected ConnectedEvent
+	if err := json.Unmarshal([]byte(first.data), &connected); first.name != "connected" || err != nil || connected.Token == "" {
+		t.Fatalf("Expected a connected event, got %+v", first)
+	}
+	welcome := next(t, events)
+	var greeting protocol.Message
+	if json.Unmarshal([]byte(welcome.data), &greeting); welcome.name != "message" || greeting.Type != protocol.Welcome {
+		t.Fatalf("Expected a welcome message, got %+v", welcome)
+	}
+
+	post := func(token string, msg protocol.Message) int {
+		body, _ := json.Marshal(msg)
+		req, _ := http.NewRequest("POST", server.URL+"/events", bytes.NewReader(body))
+		req.Header.Set(TokenHeader, token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("unknown", protocol.Message{Type: protocol.Join, Room: "call"}); status != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown token to be rejected, got %d", status)
+	}
+
+	if status := post(connected.Token, protocol.Message{Type: protocol.Join, Room: "call"}); status != http.StatusAccepted {
+		t.Fatalf("Expected the join to be accepted, got %d", status)
+	}
+	joined := next(t, events)
+	var msg protocol.Message
+	json.Unmarshal([]byte(joined.data), &msg)
+	if joined.name != "message" || msg.Type != protocol.Joined || msg.Recipient != connected.ClientID {
+		t.Fatalf("Expected a joined message, got %+v", joined)
+	}
+
+	// The stream shares the room with WebSocket clients
+	peers := sm.GetPeersInRoom("call")
+	if len(peers) != 1 || peers[0] != connected.ClientID {
+		t.Errorf("Expected the stream's client in the room, got %v", peers)
+	}
+
+	// Closing the connection ends the stream and forgets its token
+	ws.CloseConnection(connected.ClientID)
+	for range events {
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if post(connected.Token, protocol.Message{Type: protocol.Join, Room: "call"}) == http.StatusUnauthorized {
+			return
+		}
+	}
+	t.Error("Expected the token of the closed stream to be rejected")
+}
+
+func TestStreamDisallowedOrigin(t *testing.T) {
+	ws := gorilla.NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}).(*gorilla.Handler)
+	h := NewHandler(ws, config.WebSocketConfig{AllowedOrigins: []string{"https://app.example.com"}}, 0, testsupport.NewLogger())
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec := httptest.NewRecorder()
+	h.StreamHandler(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+	if ids := ws.ClientIDs(); len(ids) != 0 {
+		t.Errorf("Expected no client to be attached, got %v", ids)
+	}
+}
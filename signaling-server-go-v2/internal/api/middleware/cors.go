@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy is the cross-origin resource sharing policy of the CORS middleware
+type CORSPolicy struct {
+	// AllowedOrigins are the origins allowed to call the server, e.g.
+	// "https://app.example.com"; "https://*.example.com" allows the
+	// subdomains of a domain and "*" allows any origin
+	AllowedOrigins []string
+
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers browsers may send; "*" allows any
+	AllowedHeaders []string
+
+	// MaxAge is how long browsers may cache the result of a preflight request
+	MaxAge time.Duration
+
+	// AllowCredentials lets browsers send cookies and TLS client certificates
+	AllowCredentials bool
+}
+
+// allowsOrigin reports whether the policy allows the origin
+func (p CORSPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(strings.TrimSuffix(allowed, "/"))
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// A wildcard matches one or more subdomain labels under the same scheme
+		if prefix, suffix, ok := strings.Cut(allowed, "*."); ok && strings.HasPrefix(origin, prefix) {
+			host := strings.TrimPrefix(origin, prefix)
+			if strings.HasSuffix(host, "."+suffix) && len(host) > len(suffix)+1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CORS middleware lets browser apps served from the allowed origins call the
+// server, e.g. to fetch /ice-config or use the admin API. It answers the
+// preflight requests of allowed origins itself, and rejects those of other
+// origins with 403 Forbidden; other requests are served without CORS headers,
+// so browsers withhold the responses from disallowed origins.
+func CORS(policy CORSPolicy) func(next http.Handler) http.Handler {
+	methods := strings.Join(policy.AllowedMethods, ", ")
+	headers := strings.Join(policy.AllowedHeaders, ", ")
+	anyHeader := false
+	for _, header := range policy.AllowedHeaders {
+		anyHeader = anyHeader || header == "*"
+	}
+	maxAge := strconv.Itoa(int(policy.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Responses differ by origin, caches must not share them across origins
+			w.Header().Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if !policy.allowsOrigin(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if policy.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if anyHeader {
+				w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			} else if headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
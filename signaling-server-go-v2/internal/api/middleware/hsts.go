@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HSTS middleware sets the Strict-Transport-Security header on responses to
+// requests received over TLS, so browsers only connect over HTTPS and wss://
+// for the given duration
+func HSTS(maxAge time.Duration) func(next http.Handler) http.Handler {
+	header := fmt.Sprintf("max-age=%d; includeSubDomains", int(maxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Browsers ignore the header over plain HTTP, where it could be injected
+			if r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", header)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -16,17 +16,18 @@ func Logging(logger logging.Logger) func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
-			// Get request ID from context (added by the RequestID middleware)
-			requestID := r.Header.Get(RequestIDHeader)
+			// Carry the request ID in the context so handlers' logs can be
+			// correlated, along with the span started by the Tracing middleware
+			ctx := logging.ContextWithRequestID(r.Context(), r.Header.Get(RequestIDHeader))
+			r = r.WithContext(ctx)
 			ctxLogger := logger.With(
-				"request_id", requestID,
 				"method", r.Method,
 				"path", r.URL.Path,
 				"remote_addr", r.RemoteAddr,
 				"user_agent", r.UserAgent(),
 			)
 
-			ctxLogger.Info("Request started")
+			ctxLogger.InfoCtx(ctx, "Request started")
 
 			// Create a response wrapper to capture the status code
 			rw := &responseWriter{w, http.StatusOK, 0}
@@ -35,7 +36,7 @@ func Logging(logger logging.Logger) func(next http.Handler) http.Handler {
 			next.ServeHTTP(rw, r)
 
 			// Log the response details
-			ctxLogger.Info("Request completed",
+			ctxLogger.InfoCtx(ctx, "Request completed",
 				"status", rw.status,
 				"size", rw.size,
 				"duration_ms", time.Since(start).Milliseconds(),
@@ -63,3 +64,9 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	rw.size += size
 	return size, err
 }
+
+// Unwrap returns the wrapped http.ResponseWriter, so that http.ResponseController
+// reaches its Flush and deadline methods, e.g. for event streams
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
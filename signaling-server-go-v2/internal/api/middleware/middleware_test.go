@@ -5,30 +5,18 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
-	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing/otel"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
 )
 
-// MockLogger implements logging.Logger for testing
-type MockLogger struct {
-	DebugCalled bool
-	InfoCalled  bool
-	WarnCalled  bool
-	ErrorCalled bool
-}
-
-func (l *MockLogger) Debug(msg string, keyvals ...interface{})   { l.DebugCalled = true }
-func (l *MockLogger) Info(msg string, keyvals ...interface{})    { l.InfoCalled = true }
-func (l *MockLogger) Warn(msg string, keyvals ...interface{})    { l.WarnCalled = true }
-func (l *MockLogger) Error(msg string, keyvals ...interface{})   { l.ErrorCalled = true }
-func (l *MockLogger) With(keyvals ...interface{}) logging.Logger { return l }
-
 // Test Logging middleware
 func TestLoggingMiddleware(t *testing.T) {
-	mockLogger := &MockLogger{}
+	mockLogger := testsupport.NewLogger()
 
 	// Create a test handler
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -48,7 +36,7 @@ func TestLoggingMiddleware(t *testing.T) {
 	handler.ServeHTTP(rec, req)
 
 	// Verify the logger was called
-	if !mockLogger.InfoCalled {
+	if !mockLogger.Called("INFO") {
 		t.Error("Expected logger.Info to be called")
 	}
 
@@ -58,9 +46,39 @@ func TestLoggingMiddleware(t *testing.T) {
 	}
 }
 
+// Test that request logs carry the request ID and the IDs of the request's span
+func TestLoggingMiddlewareCorrelatesTrace(t *testing.T) {
+	mockLogger := testsupport.NewLogger()
+
+	var handlerCtx context.Context
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCtx = r.Context()
+	})
+	tracer, _ := otel.NewOTelTracer(config.TracingConfig{Enabled: true})
+	handler := Tracing(tracer)(Logging(mockLogger)(nextHandler))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set(RequestIDHeader, "test-request-id")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	traceID, spanID := tracing.SpanIDsFromContext(handlerCtx)
+	if traceID == "" || spanID == "" {
+		t.Fatal("Expected the handler context to carry span IDs")
+	}
+
+	fields := make(map[interface{}]interface{})
+	entry := mockLogger.Entries()[0]
+	for i := 0; i+1 < len(entry.Keyvals); i += 2 {
+		fields[entry.Keyvals[i]] = entry.Keyvals[i+1]
+	}
+	if fields["request_id"] != "test-request-id" || fields["trace_id"] != traceID || fields["span_id"] != spanID {
+		t.Errorf("Expected request and trace IDs in log fields, got %v", fields)
+	}
+}
+
 // Test Recovery middleware
 func TestRecoveryMiddleware(t *testing.T) {
-	mockLogger := &MockLogger{}
+	mockLogger := testsupport.NewLogger()
 
 	// Create a handler that panics
 	panicHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -79,7 +97,7 @@ func TestRecoveryMiddleware(t *testing.T) {
 	handler.ServeHTTP(rec, req)
 
 	// Verify the logger was called with error
-	if !mockLogger.ErrorCalled {
+	if !mockLogger.Called("ERROR") {
 		t.Error("Expected logger.Error to be called for panic")
 	}
 
@@ -126,45 +144,9 @@ func TestMetricsMiddleware(t *testing.T) {
 	}
 }
 
-// MockTracer implements tracing.Tracer for testing
-type MockTracer struct{}
-
-func (t *MockTracer) StartSpan(name string, opts ...tracing.SpanOption) tracing.Span {
-	return &MockSpan{}
-}
-
-func (t *MockTracer) Inject(ctx context.Context, carrier interface{}) error {
-	return nil
-}
-
-func (t *MockTracer) Extract(carrier interface{}) (context.Context, error) {
-	return context.Background(), nil
-}
-
-// MockSpan implements tracing.Span for testing
-type MockSpan struct {
-	EndCalled  bool
-	Attributes map[string]interface{}
-}
-
-func (s *MockSpan) End() {
-	s.EndCalled = true
-}
-
-func (s *MockSpan) SetAttribute(key string, value interface{}) {
-	if s.Attributes == nil {
-		s.Attributes = make(map[string]interface{})
-	}
-	s.Attributes[key] = value
-}
-
-func (s *MockSpan) AddEvent(name string, attributes map[string]interface{}) {}
-func (s *MockSpan) RecordError(err error)                                   {}
-func (s *MockSpan) Context() context.Context                                { return context.Background() }
-
 // Test Tracing middleware
 func TestTracingMiddleware(t *testing.T) {
-	mockTracer := &MockTracer{}
+	mockTracer := testsupport.NewTracer()
 
 	// Create a test handler
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -186,4 +168,132 @@ func TestTracingMiddleware(t *testing.T) {
 	if rec.Code != http.StatusOK {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, rec.Code)
 	}
+
+	// Verify a span was started and ended for the request
+	if spans := mockTracer.Spans(); len(spans) != 1 || !spans[0].Ended {
+		t.Errorf("Expected 1 ended span, got %+v", spans)
+	}
+}
+
+func TestHSTSMiddleware(t *testing.T) {
+	handler := HSTS(24 * time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The header is only sent over TLS
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/", nil))
+	if header := rec.Header().Get("Strict-Transport-Security"); header != "" {
+		t.Errorf("Expected no HSTS header over plain HTTP, got %q", header)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "https://example.com/", nil))
+	if header := rec.Header().Get("Strict-Transport-Security"); header != "max-age=86400; includeSubDomains" {
+		t.Errorf("Unexpected HSTS header: %q", header)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	served := false
+	handler := CORS(CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		MaxAge:         10 * time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/ice-config", nil)
+		req.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		rec := httptest.NewRecorder()
+		served = false
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Allowed origins are answered with CORS headers
+	for _, origin := range []string{"https://app.example.com", "https://eu.media.example.org"} {
+		rec := request("GET", origin)
+		if !served || rec.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("Expected %s to be allowed, got headers %v", origin, rec.Header())
+		}
+	}
+
+	// Preflights are answered by the middleware
+	rec := request("OPTIONS", "https://app.example.com")
+	if served || rec.Code != http.StatusNoContent {
+		t.Errorf("Expected the preflight to be answered with 204, got %d", rec.Code)
+	}
+	if methods := rec.Header().Get("Access-Control-Allow-Methods"); methods != "GET, POST" {
+		t.Errorf("Unexpected allowed methods: %q", methods)
+	}
+	if headers := rec.Header().Get("Access-Control-Allow-Headers"); headers != "Authorization, Content-Type" {
+		t.Errorf("Unexpected allowed headers: %q", headers)
+	}
+	if maxAge := rec.Header().Get("Access-Control-Max-Age"); maxAge != "600" {
+		t.Errorf("Unexpected max age: %q", maxAge)
+	}
+	if credentials := rec.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+		t.Errorf("Expected no credentials to be allowed, got %q", credentials)
+	}
+
+	// Other origins get no CORS headers, and their preflights are rejected
+	for _, origin := range []string{"https://evil.example.com", "http://app.example.com", "https://example.org"} {
+		rec := request("GET", origin)
+		if !served || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Expected %s to get no CORS headers, got %v", origin, rec.Header())
+		}
+	}
+	if rec := request("OPTIONS", "https://evil.example.com"); served || rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the preflight of a disallowed origin to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestProxyHeaders(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+	if _, err := ParseTrustedProxies([]string{"proxy.internal"}); err == nil {
+		t.Error("Expected an error for a proxy that is not an IP or CIDR")
+	}
+
+	var remoteAddr string
+	handler := ProxyHeaders(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"x-forwarded-for", "10.0.0.5:4711", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+		{"forged hop before the client", "10.0.0.5:4711", map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"chain of trusted proxies", "10.0.0.5:4711", map[string]string{"X-Forwarded-For": "203.0.113.7, 192.0.2.1, 10.1.2.3"}, "203.0.113.7"},
+		{"forwarded preferred", "192.0.2.1:4711", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https`, "X-Forwarded-For": "203.0.113.7"}, "2001:db8::1"},
+		{"forwarded chain", "10.0.0.5:4711", map[string]string{"Forwarded": "for=203.0.113.7, for=10.1.2.3"}, "203.0.113.7"},
+		{"obfuscated forwarded hop", "10.0.0.5:4711", map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.5:4711"},
+		{"x-real-ip", "10.0.0.5:4711", map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+		{"no headers", "10.0.0.5:4711", nil, "10.0.0.5:4711"},
+		{"untrusted peer", "198.51.100.9:4711", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "198.51.100.9:4711"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/ws", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if remoteAddr != tc.want {
+				t.Errorf("Expected remote address %s, got %s", tc.want, remoteAddr)
+			}
+		})
+	}
 }
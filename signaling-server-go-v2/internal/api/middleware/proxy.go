@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses the addresses of trusted reverse proxies, CIDRs
+// such as "10.0.0.0/8" or single IPs
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ProxyHeaders middleware replaces the remote address of requests received
+// from a trusted reverse proxy with the client IP the proxy forwarded, so
+// that connection caps, allowlists and logs see the client rather than the
+// proxy. The Forwarded header is preferred, then X-Forwarded-For, then
+// X-Real-IP. Forwarded addresses are read from the nearest hop outwards,
+// skipping trusted proxies, as clients may send forged headers that proxies
+// append to. Requests from other addresses are left untouched, their
+// forwarding headers being as good as forged.
+func ProxyHeaders(trusted []*net.IPNet) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTrusted(trusted, remoteHost(r.RemoteAddr)) {
+				if ip := forwardedFor(r, trusted); ip != "" {
+					r.RemoteAddr = ip
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor returns the client IP of a request forwarded by trusted
+// proxies, empty if the headers carry none
+func forwardedFor(r *http.Request, trusted []*net.IPNet) string {
+	if hops := forwardedHops(r.Header.Values("Forwarded")); len(hops) > 0 {
+		return clientHop(hops, trusted)
+	}
+	if hops := splitList(r.Header.Values("X-Forwarded-For")); len(hops) > 0 {
+		return clientHop(hops, trusted)
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// clientHop returns the nearest hop that is not a trusted proxy, or the
+// farthest hop if all are trusted. Malformed hops, such as the obfuscated
+// identifiers of the Forwarded header, end the search.
+func clientHop(hops []string, trusted []*net.IPNet) string {
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !isTrusted(trusted, client) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedHops returns the for= addresses of Forwarded headers (RFC 7239),
+// without ports and IPv6 brackets
+func forwardedHops(headers []string) []string {
+	var hops []string
+	for _, element := range splitList(headers) {
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !strings.EqualFold(name, "for") {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+			if host, _, err := net.SplitHostPort(value); err == nil {
+				value = host
+			}
+			hops = append(hops, strings.Trim(value, "[]"))
+		}
+	}
+	return hops
+}
+
+// splitList splits comma-separated header values into trimmed, non-empty elements
+func splitList(headers []string) []string {
+	var elements []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				elements = append(elements, element)
+			}
+		}
+	}
+	return elements
+}
+
+// isTrusted reports whether an IP is in one of the trusted networks
+func isTrusted(trusted []*net.IPNet, host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns the host of a remote address
+func remoteHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
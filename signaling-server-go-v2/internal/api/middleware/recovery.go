@@ -14,12 +14,9 @@ func Recovery(logger logging.Logger) func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
-					// Get request ID from header
-					requestID := r.Header.Get(RequestIDHeader)
-
-					// Log the panic with stacktrace
-					logger.Error("Panic recovered",
-						"request_id", requestID,
+					// Log the panic with stacktrace and the request's correlation IDs
+					ctx := logging.ContextWithRequestID(r.Context(), r.Header.Get(RequestIDHeader))
+					logger.ErrorCtx(ctx, "Panic recovered",
 						"error", fmt.Sprintf("%v", err),
 						"stack", string(debug.Stack()),
 					)
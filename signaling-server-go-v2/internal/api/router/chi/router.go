@@ -2,6 +2,7 @@ package chi
 
 import (
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/router"
@@ -11,6 +12,10 @@ import (
 type ChiRouter struct {
 	router     *http.ServeMux
 	middleware []func(http.Handler) http.Handler
+
+	// routes holds the handlers of each registered path by method, as the
+	// mux allows a single registration per path
+	routes map[string]map[string]http.Handler
 }
 
 // NewChiRouter creates a new router
@@ -18,19 +23,34 @@ func NewChiRouter() router.Router {
 	return &ChiRouter{
 		router:     http.NewServeMux(),
 		middleware: []func(http.Handler) http.Handler{},
+		routes:     make(map[string]map[string]http.Handler),
 	}
 }
 
 // Handle registers a handler for a specific method and path
 func (r *ChiRouter) Handle(method, path string, handler http.Handler) {
-	// Create a method checking wrapper
+	// Further methods of a registered path are dispatched by its wrapper
+	if handlers, ok := r.routes[path]; ok {
+		handlers[strings.ToUpper(method)] = handler
+		return
+	}
+	handlers := map[string]http.Handler{strings.ToUpper(method): handler}
+	r.routes[path] = handlers
+
+	// Create a method dispatching wrapper
 	wrapped := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		// Only serve if method matches
-		if req.Method == strings.ToUpper(method) {
+		// Only serve if a handler is registered for the method
+		if handler, ok := handlers[req.Method]; ok {
 			handler.ServeHTTP(w, req)
 			return
 		}
 		// Method not allowed
+		allowed := make([]string, 0, len(handlers))
+		for method := range handlers {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	})
 
@@ -135,4 +135,28 @@ func TestChiRouterMultipleMiddleware(t *testing.T) {
 	if !middleware2Called {
 		t.Error("Expected middleware2 to be called")
 	}
-}
\ No newline at end of file
+}
+func TestMultipleMethods(t *testing.T) {
+	router := NewChiRouter()
+
+	router.HandleFunc("GET", "/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.HandleFunc("POST", "/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	for method, status := range map[string]int{"GET": http.StatusOK, "POST": http.StatusAccepted, "PUT": http.StatusMethodNotAllowed} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, "/events", nil))
+		if rec.Code != status {
+			t.Errorf("Expected status %d for %s, got %d", status, method, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("PUT", "/events", nil))
+	if allow := rec.Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Expected the allowed methods in the Allow header, got %q", allow)
+	}
+}
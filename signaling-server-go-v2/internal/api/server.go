@@ -5,13 +5,22 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/handlers/admin"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/handlers/health"
+	icehandler "github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/handlers/ice"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/handlers/longpoll"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/handlers/sse"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/middleware"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/router"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/audit"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/events"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/ice"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
@@ -27,6 +36,78 @@ type Server struct {
 	tracer        tracing.Tracer
 	wsHandler     websocket.WebSocketHandler
 	healthHandler *health.Handler
+	adminHandler  *admin.Handler
+	iceHandler    *icehandler.Handler
+	sseHandler    *sse.Handler
+	pollHandler   *longpoll.Handler
+	signaling     *protocol.SignalingManager
+	logRecorder   *logging.Recorder
+	auditLog      *audit.Log
+	reloader      *config.Reloader
+	roomWebhooks  *events.RoomWebhooks
+
+	// healthChecks are added to the liveness checks of the health handler
+	healthChecks map[string]func() (health.Status, string)
+
+	// readinessGates are added to the readiness checks of the health handler
+	readinessGates []*health.Gate
+}
+
+// Option configures optional Server dependencies
+type Option func(*Server)
+
+// WithSignalingManager sets the signaling manager used by the administrative API
+func WithSignalingManager(sm *protocol.SignalingManager) Option {
+	return func(s *Server) {
+		s.signaling = sm
+	}
+}
+
+// WithLogRecorder sets the recorder whose recent log entries are exposed by the administrative API
+func WithLogRecorder(recorder *logging.Recorder) Option {
+	return func(s *Server) {
+		s.logRecorder = recorder
+	}
+}
+
+// WithAuditLog sets the audit log recording the operations of the administrative API
+func WithAuditLog(log *audit.Log) Option {
+	return func(s *Server) {
+		s.auditLog = log
+	}
+}
+
+// WithConfigReloader sets the reloader of the configuration used by the administrative API
+func WithConfigReloader(reloader *config.Reloader) Option {
+	return func(s *Server) {
+		s.reloader = reloader
+	}
+}
+
+// WithRoomWebhooks sets the room webhooks registered through the administrative API
+func WithRoomWebhooks(webhooks *events.RoomWebhooks) Option {
+	return func(s *Server) {
+		s.roomWebhooks = webhooks
+	}
+}
+
+// WithHealthCheck adds a check reported by the health endpoints, e.g. the
+// state of the exporters' circuit breakers
+func WithHealthCheck(name string, check func() (health.Status, string)) Option {
+	return func(s *Server) {
+		if s.healthChecks == nil {
+			s.healthChecks = make(map[string]func() (health.Status, string))
+		}
+		s.healthChecks[name] = check
+	}
+}
+
+// WithReadinessGate keeps the readiness endpoint DOWN until the gate opens,
+// so that the instance receives no traffic before it warmed up
+func WithReadinessGate(gate *health.Gate) Option {
+	return func(s *Server) {
+		s.readinessGates = append(s.readinessGates, gate)
+	}
 }
 
 // NewServer creates a new server with the given configuration
@@ -37,6 +118,7 @@ func NewServer(
 	metrics *metrics.Metrics,
 	tracer tracing.Tracer,
 	wsHandler websocket.WebSocketHandler,
+	opts ...Option,
 ) *Server {
 	s := &Server{
 		cfg:       cfg,
@@ -47,6 +129,10 @@ func NewServer(
 		wsHandler: wsHandler,
 	}
 
+	for _, opt := range opts {
+		opt(s)
+	}
+
 	// Create and configure the HTTP server
 	s.httpServer = &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
@@ -58,6 +144,62 @@ func NewServer(
 
 	// Create health handler
 	s.healthHandler = health.NewHandler(logger)
+	for name, check := range s.healthChecks {
+		s.healthHandler.AddLivenessCheck(name, check)
+	}
+	for _, gate := range s.readinessGates {
+		s.healthHandler.AddReadinessCheck(gate.Name(), gate.Check)
+	}
+
+	// Create ICE config handler if any STUN or TURN server is configured
+	if len(cfg.ICE.TURNURLs) > 0 && cfg.ICE.TURNSecret == "" {
+		logger.Error("TURN servers configured without ICE_TURN_SECRET, not serving them")
+	}
+	if provider := ice.NewProvider(cfg.ICE); provider.Enabled() {
+		s.iceHandler = icehandler.NewHandler(provider, logger)
+	}
+
+	// Create the fallback transport handlers if enabled, attaching their clients to the WebSocket clients
+	if attacher, ok := wsHandler.(websocket.Attacher); ok {
+		if cfg.SSE.Enabled {
+			s.sseHandler = sse.NewHandler(attacher, cfg.WebSocket, time.Duration(cfg.SSE.KeepaliveInterval)*time.Second, logger)
+		}
+		if cfg.LongPoll.Enabled {
+			s.pollHandler = longpoll.NewHandler(attacher, cfg.WebSocket,
+				time.Duration(cfg.LongPoll.PollTimeout)*time.Second, time.Duration(cfg.LongPoll.IdleTimeout)*time.Second, logger)
+		}
+	} else if cfg.SSE.Enabled || cfg.LongPoll.Enabled {
+		logger.Error("WebSocket handler cannot attach other transports, not serving fallback transports")
+	}
+
+	// Open event streams and long polls are active requests, which the HTTP
+	// server would otherwise wait on until the shutdown timeout
+	if s.sseHandler != nil {
+		s.httpServer.RegisterOnShutdown(s.sseHandler.Close)
+	}
+	if s.pollHandler != nil {
+		s.httpServer.RegisterOnShutdown(s.pollHandler.Close)
+	}
+
+	// Create admin handler if enabled; the admin API is never served without a token
+	if cfg.Admin.Enabled && cfg.Admin.Token == "" {
+		logger.Error("Admin API enabled without ADMIN_TOKEN, not registering admin routes")
+	} else if cfg.Admin.Enabled && s.signaling != nil {
+		var adminOpts []admin.Option
+		if s.logRecorder != nil {
+			adminOpts = append(adminOpts, admin.WithLogRecorder(s.logRecorder))
+		}
+		if s.auditLog != nil {
+			adminOpts = append(adminOpts, admin.WithAuditLog(s.auditLog))
+		}
+		if s.reloader != nil {
+			adminOpts = append(adminOpts, admin.WithReloader(s.reloader))
+		}
+		if s.roomWebhooks != nil {
+			adminOpts = append(adminOpts, admin.WithRoomWebhooks(s.roomWebhooks))
+		}
+		s.adminHandler = admin.NewHandler(cfg, logger, s.signaling, wsHandler, adminOpts...)
+	}
 
 	// Register routes and middleware
 	s.registerMiddleware()
@@ -68,9 +210,21 @@ func NewServer(
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
-	s.logger.Info("Starting server", "address", s.httpServer.Addr)
+	tlsCfg := s.cfg.Server.TLS
+	s.logger.Info("Starting server", "address", s.httpServer.Addr, "tls", tlsCfg.Enabled)
 
-	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	var err error
+	if tlsCfg.Enabled {
+		if s.httpServer.TLSConfig, err = NewTLSConfig(tlsCfg); err != nil {
+			s.logger.Error("Invalid TLS configuration", "error", err)
+			return err
+		}
+		err = s.httpServer.ListenAndServeTLS(tlsCfg.CertFile, tlsCfg.KeyFile)
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		s.logger.Error("Failed to start server", "error", err)
 		return err
 	}
@@ -86,29 +240,76 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	shutdownCtx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.Server.ShutdownTimeout)*time.Second)
 	defer cancel()
 
-	// Shutdown the HTTP server
-	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+	// Shutdown the HTTP server, which stops new upgrades and ends the event
+	// streams and long-polling sessions. Established connections are closed
+	// below even if it timed out.
+	err := s.httpServer.Shutdown(shutdownCtx)
+	if err != nil {
 		s.logger.Error("Failed to shutdown server gracefully", "error", err)
-		return err
 	}
 
-	return nil
+	// Established WebSocket connections are not tracked by the HTTP server; close them with the remaining time
+	if drainer, ok := s.wsHandler.(websocket.Drainer); ok {
+		result := drainer.Drain(shutdownCtx, websocket.ShutdownReason)
+		if result.Forced > 0 {
+			s.logger.Warn("Force-closed WebSocket connections after drain timeout", "closed", result.Closed, "forced", result.Forced)
+		} else {
+			s.logger.Info("Drained WebSocket connections", "closed", result.Closed)
+		}
+	}
+
+	return err
 }
 
 // registerMiddleware registers middleware for the server
 func (s *Server) registerMiddleware() {
 	// Add core middleware
 	s.router.Use(middleware.Recovery(s.logger))
+
+	// Resolve client IPs from the headers of trusted reverse proxies, ahead of
+	// everything logging or limiting by remote address
+	if len(s.cfg.Server.TrustedProxies) > 0 {
+		if trusted, err := middleware.ParseTrustedProxies(s.cfg.Server.TrustedProxies); err != nil {
+			s.logger.Error("Invalid trusted proxies, ignoring forwarding headers", "error", err)
+		} else {
+			s.router.Use(middleware.ProxyHeaders(trusted))
+		}
+	}
+
+	// Add tracing middleware if enabled, ahead of logging so request logs carry the span's IDs
+	if s.cfg.Tracing.Enabled {
+		s.router.Use(middleware.Tracing(s.tracer))
+	}
+
 	s.router.Use(middleware.Logging(s.logger))
 
+	// Tell browsers to use HTTPS only once the server is served over TLS
+	if s.cfg.Server.TLS.Enabled && s.cfg.Server.TLS.HSTSMaxAge > 0 {
+		s.router.Use(middleware.HSTS(time.Duration(s.cfg.Server.TLS.HSTSMaxAge) * time.Second))
+	}
+
 	// Add metrics middleware if enabled
 	if s.cfg.Metrics.Enabled {
 		s.router.Use(middleware.Metrics(s.metrics))
 	}
 
-	// Add tracing middleware if enabled
-	if s.cfg.Tracing.Enabled {
-		s.router.Use(middleware.Tracing(s.tracer))
+	// Let browser apps from other origins call the HTTP endpoints
+	if cors := s.cfg.CORS; cors.Enabled {
+		if len(cors.AllowedOrigins) == 0 {
+			s.logger.Warn("CORS enabled without CORS_ALLOWED_ORIGINS, no origin is allowed")
+		}
+		for _, origin := range cors.AllowedOrigins {
+			if origin == "*" && cors.AllowCredentials {
+				s.logger.Warn("CORS allows credentials from any origin")
+			}
+		}
+		s.router.Use(middleware.CORS(middleware.CORSPolicy{
+			AllowedOrigins:   cors.AllowedOrigins,
+			AllowedMethods:   cors.AllowedMethods,
+			AllowedHeaders:   cors.AllowedHeaders,
+			MaxAge:           time.Duration(cors.MaxAge) * time.Second,
+			AllowCredentials: cors.AllowCredentials,
+		}))
 	}
 }
 
@@ -120,9 +321,71 @@ func (s *Server) registerRoutes() {
 
 	// Register WebSocket endpoint
 	s.router.HandleFunc("GET", s.cfg.WebSocket.Path, s.wsHandler.HandleConnection)
+	for _, source := range s.cfg.WebSocket.TenantSources {
+		if source == websocket.TenantFromPath {
+			// Serve /ws/{tenant}
+			s.router.HandleFunc("GET", strings.TrimSuffix(s.cfg.WebSocket.Path, "/")+"/", s.wsHandler.HandleConnection)
+		}
+	}
+
+	// Register Server-Sent Events endpoints if enabled
+	if s.sseHandler != nil {
+		s.router.HandleFunc("GET", s.cfg.SSE.Path, s.sseHandler.StreamHandler)
+		s.router.HandleFunc("POST", s.cfg.SSE.Path, s.sseHandler.MessageHandler)
+	}
+
+	// Register long-polling endpoints if enabled
+	if s.pollHandler != nil {
+		s.router.HandleFunc("POST", s.cfg.LongPoll.PollPath, s.pollHandler.PollHandler)
+		s.router.HandleFunc("POST", s.cfg.LongPoll.SendPath, s.pollHandler.SendHandler)
+	}
+
+	// Register ICE config endpoint if enabled
+	if s.iceHandler != nil {
+		s.router.HandleFunc("GET", "/ice-config", s.iceHandler.ConfigHandler)
+	}
 
 	// Register metrics endpoint if enabled
 	if s.cfg.Metrics.Enabled {
-		s.router.Handle("GET", s.cfg.Metrics.Path, metrics.MetricsHandler())
+		handler, err := metrics.Protect(s.cfg.Metrics, metrics.MetricsHandler())
+		if err != nil {
+			s.logger.Error("Invalid metrics access configuration, not registering metrics endpoint", "error", err)
+		} else {
+			s.router.Handle("GET", s.cfg.Metrics.Path, handler)
+		}
+	}
+
+	// Register admin endpoints if enabled
+	if s.adminHandler != nil {
+		s.registerAdminRoutes()
+	}
+}
+
+// registerAdminRoutes registers the administrative API routes. The room and
+// tenant endpoints also accept tenant tokens, scoped to one tenant's rooms.
+func (s *Server) registerAdminRoutes() {
+	prefix := s.cfg.Admin.PathPrefix
+	s.router.Handle("GET", prefix+"/rooms", s.adminHandler.AuthorizeTenant(http.HandlerFunc(s.adminHandler.ListRoomsHandler)))
+	s.router.Handle("POST", prefix+"/rooms", s.adminHandler.AuthorizeTenant(http.HandlerFunc(s.adminHandler.CreateRoomHandler)))
+	s.router.Handle("POST", prefix+"/rooms/metadata", s.adminHandler.AuthorizeTenant(http.HandlerFunc(s.adminHandler.UpdateRoomMetadataHandler)))
+	s.router.Handle("POST", prefix+"/rooms/password", s.adminHandler.AuthorizeTenant(http.HandlerFunc(s.adminHandler.SetRoomPasswordHandler)))
+	s.router.Handle("GET", prefix+"/rooms/quality", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.RoomQualityHandler)))
+	s.router.Handle("POST", prefix+"/rooms/time-limit", s.adminHandler.AuthorizeTenant(http.HandlerFunc(s.adminHandler.SetRoomTimeLimitHandler)))
+	s.router.Handle("POST", prefix+"/rooms/close", s.adminHandler.AuthorizeTenant(http.HandlerFunc(s.adminHandler.CloseRoomsHandler)))
+	s.router.Handle("GET", prefix+"/rooms/closed", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.ClosedRoomsHandler)))
+	s.router.Handle("POST", prefix+"/rooms/restore", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.RestoreRoomHandler)))
+	s.router.Handle("GET", prefix+"/room-creation", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.RoomCreationHandler)))
+	s.router.Handle("POST", prefix+"/room-creation", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.SetRoomCreationHandler)))
+	s.router.Handle("POST", prefix+"/tenants/disconnect", s.adminHandler.AuthorizeTenant(http.HandlerFunc(s.adminHandler.DisconnectTenantHandler)))
+	s.router.Handle("POST", prefix+"/broadcast", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.BroadcastHandler)))
+	s.router.Handle("POST", prefix+"/clients/reconnect", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.ReconnectClientsHandler)))
+	if s.roomWebhooks != nil {
+		s.router.Handle("GET", prefix+"/webhooks", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.ListWebhooksHandler)))
+		s.router.Handle("POST", prefix+"/webhooks", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.RegisterWebhookHandler)))
+		s.router.Handle("POST", prefix+"/webhooks/delete", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.UnregisterWebhookHandler)))
 	}
+	s.router.Handle("GET", prefix+"/debug/bundle", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.DebugBundleHandler)))
+	s.router.Handle("GET", prefix+"/config", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.ConfigHandler)))
+	s.router.Handle("POST", prefix+"/config/reload", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.ReloadConfigHandler)))
+	s.router.Handle("GET", prefix+"/stats", s.adminHandler.Authorize(http.HandlerFunc(s.adminHandler.StatsHandler)))
 }
@@ -1,81 +1,23 @@
 package api
 
 import (
+	"bufio"
 	"context"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
-	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/gorilla"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
 )
 
-// MockRouter implements the Router interface for testing
-type MockRouter struct {
-	handlers map[string]http.Handler
-	mws      []func(http.Handler) http.Handler
-}
-
-func NewMockRouter() *MockRouter {
-	return &MockRouter{
-		handlers: make(map[string]http.Handler),
-		mws:      []func(http.Handler) http.Handler{},
-	}
-}
-
-func (r *MockRouter) Handle(method, path string, handler http.Handler) {
-	r.handlers[method+":"+path] = handler
-}
-
-func (r *MockRouter) HandleFunc(method, path string, handlerFunc func(http.ResponseWriter, *http.Request)) {
-	r.handlers[method+":"+path] = http.HandlerFunc(handlerFunc)
-}
-
-func (r *MockRouter) Use(middleware ...func(http.Handler) http.Handler) {
-	r.mws = append(r.mws, middleware...)
-}
-
-func (r *MockRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	key := req.Method + ":" + req.URL.Path
-	if handler, ok := r.handlers[key]; ok {
-		handler.ServeHTTP(w, req)
-	} else {
-		w.WriteHeader(http.StatusNotFound)
-	}
-}
-
-// MockLogger implements logging.Logger for testing
-type MockLogger struct{}
-
-func (l *MockLogger) Debug(msg string, keyvals ...interface{})   {}
-func (l *MockLogger) Info(msg string, keyvals ...interface{})    {}
-func (l *MockLogger) Warn(msg string, keyvals ...interface{})    {}
-func (l *MockLogger) Error(msg string, keyvals ...interface{})   {}
-func (l *MockLogger) With(keyvals ...interface{}) logging.Logger { return l }
-
-// MockWebSocketHandler implements the WebSocketHandler interface for testing
-type MockWebSocketHandler struct{}
-
-func (h *MockWebSocketHandler) HandleConnection(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("WebSocket connection"))
-}
-
-func (h *MockWebSocketHandler) BroadcastMessage(message []byte) error {
-	return nil
-}
-
-func (h *MockWebSocketHandler) SendMessage(clientID string, message []byte) error {
-	return nil
-}
-
-func (h *MockWebSocketHandler) CloseConnection(clientID string) error {
-	return nil
-}
-
-func setupTestServer() (*Server, *MockRouter) {
+func setupTestServer() (*Server, *testsupport.Router) {
 	cfg := &config.Config{
 		Server: config.ServerConfig{
 			Port:            8080,
@@ -98,11 +40,11 @@ func setupTestServer() (*Server, *MockRouter) {
 		},
 	}
 
-	router := NewMockRouter()
-	logger := &MockLogger{}
+	router := testsupport.NewRouter()
+	logger := testsupport.NewLogger()
 	m := metrics.NewMetrics(cfg.Metrics)
 	tracer := &tracing.NoopTracer{}
-	wsHandler := &MockWebSocketHandler{}
+	wsHandler := testsupport.NewWebSocketHandler()
 
 	server := NewServer(cfg, router, logger, m, tracer, wsHandler)
 	return server, router
@@ -112,16 +54,16 @@ func TestServerRouteRegistration(t *testing.T) {
 	server, mockRouter := setupTestServer()
 
 	// Check that health endpoints are registered
-	if _, ok := mockRouter.handlers["GET:/health/live"]; !ok {
+	if _, ok := mockRouter.Handlers["GET:/health/live"]; !ok {
 		t.Error("Liveness endpoint not registered")
 	}
 
-	if _, ok := mockRouter.handlers["GET:/health/ready"]; !ok {
+	if _, ok := mockRouter.Handlers["GET:/health/ready"]; !ok {
 		t.Error("Readiness endpoint not registered")
 	}
 
 	// Check that WebSocket endpoint is registered
-	if _, ok := mockRouter.handlers["GET:/ws"]; !ok {
+	if _, ok := mockRouter.Handlers["GET:/ws"]; !ok {
 		t.Error("WebSocket endpoint not registered")
 	}
 
@@ -173,3 +115,66 @@ func TestServerShutdown(t *testing.T) {
 		t.Errorf("Server shutdown failed: %v", err)
 	}
 }
+
+func TestServerShutdownOpenStream(t *testing.T) {
+	server, _ := setupTestServer()
+	server.cfg.Server.ShutdownTimeout = 5
+	server.cfg.SSE = config.SSEConfig{Enabled: true, Path: "/events"}
+	ws := gorilla.NewHandler(server.cfg.WebSocket, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{})
+	server = NewServer(server.cfg, testsupport.NewRouter(), testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}, ws)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.httpServer.Serve(listener)
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/events")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || line != "event: connected\n" {
+		t.Fatalf("Expected a connected event, got %q: %v", line, err)
+	}
+
+	// The open stream does not hold the shutdown until its timeout
+	start := time.Now()
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("Server shutdown failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the stream ended at shutdown, took %v", elapsed)
+	}
+	ended := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Error("Expected the stream ended at shutdown")
+	}
+}
+
+func TestICEConfigRoute(t *testing.T) {
+	_, mockRouter := setupTestServer()
+	if _, ok := mockRouter.Handlers["GET:/ice-config"]; ok {
+		t.Error("Expected no ICE config endpoint without ICE servers")
+	}
+
+	cfg := &config.Config{
+		WebSocket: config.WebSocketConfig{Path: "/ws"},
+		ICE:       config.ICEConfig{STUNURLs: []string{"stun:stun.example.com"}},
+	}
+	router := testsupport.NewRouter()
+	NewServer(cfg, router, testsupport.NewLogger(), metrics.NewMetrics(cfg.Metrics), &tracing.NoopTracer{}, testsupport.NewWebSocketHandler())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/ice-config", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// tlsVersions maps configured minimum versions to their TLS constants
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// clientAuthModes maps configured client authentication modes to their TLS
+// policies; client certificates that are presented are always verified
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"":         tls.NoClientCert,
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// NewTLSConfig builds the TLS configuration of the listener. The certificate
+// and key are loaded by ListenAndServeTLS; it fails if they are not set, if
+// the minimum version is not supported, if a cipher suite is unknown or
+// insecure, or if client authentication is enabled without a valid CA bundle.
+func NewTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("TLS certificate and key files are required")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS minimum version: %s", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if err := configureClientAuth(tlsConfig, cfg); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.CipherSuites) == 0 {
+		return tlsConfig, nil
+	}
+
+	// Only secure suites may be configured; TLS 1.3 suites are not configurable
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, name := range cfg.CipherSuites {
+		id, ok := suites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite: %s", name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+
+	return tlsConfig, nil
+}
+
+// configureClientAuth sets up verification of client certificates for mutual TLS
+func configureClientAuth(tlsConfig *tls.Config, cfg config.TLSConfig) error {
+	mode, ok := clientAuthModes[strings.ToLower(cfg.ClientAuth)]
+	if !ok {
+		return fmt.Errorf("unknown TLS client auth mode: %s", cfg.ClientAuth)
+	}
+	if mode == tls.NoClientCert {
+		return nil
+	}
+
+	if cfg.ClientCAFile == "" {
+		return fmt.Errorf("a client CA file is required for TLS client auth")
+	}
+	bundle, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return fmt.Errorf("no certificates found in client CA file: %s", cfg.ClientCAFile)
+	}
+
+	tlsConfig.ClientAuth = mode
+	tlsConfig.ClientCAs = pool
+	return nil
+}
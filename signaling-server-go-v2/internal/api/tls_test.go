@@ -0,0 +1,108 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	cfg := config.TLSConfig{
+		Enabled:      true,
+		CertFile:     "server.crt",
+		KeyFile:      "server.key",
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	}
+
+	tlsConfig, err := NewTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 minimum, got %x", tlsConfig.MinVersion)
+	}
+	if len(tlsConfig.CipherSuites) != 1 || tlsConfig.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Unexpected cipher suites: %v", tlsConfig.CipherSuites)
+	}
+
+	invalid := map[string]config.TLSConfig{
+		"missing key":    {CertFile: "server.crt"},
+		"old version":    {CertFile: "server.crt", KeyFile: "server.key", MinVersion: "1.0"},
+		"insecure suite": {CertFile: "server.crt", KeyFile: "server.key", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+	}
+	for name, cfg := range invalid {
+		if _, err := NewTLSConfig(cfg); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+// writeTestCA writes a self-signed CA certificate in PEM format and returns its path
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	return path
+}
+
+func TestNewTLSConfigClientAuth(t *testing.T) {
+	caFile := writeTestCA(t)
+	base := config.TLSConfig{CertFile: "server.crt", KeyFile: "server.key"}
+
+	cfg := base
+	cfg.ClientAuth, cfg.ClientCAFile = "require", caFile
+	tlsConfig, err := NewTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Errorf("Expected client certificates to be required and verified, got %v", tlsConfig.ClientAuth)
+	}
+
+	garbage := filepath.Join(t.TempDir(), "garbage.pem")
+	os.WriteFile(garbage, []byte("not a certificate"), 0o600)
+
+	invalid := map[string]config.TLSConfig{
+		"unknown mode":     {CertFile: "server.crt", KeyFile: "server.key", ClientAuth: "maybe", ClientCAFile: caFile},
+		"missing CA file":  {CertFile: "server.crt", KeyFile: "server.key", ClientAuth: "optional"},
+		"CA without certs": {CertFile: "server.crt", KeyFile: "server.key", ClientAuth: "optional", ClientCAFile: garbage},
+	}
+	for name, cfg := range invalid {
+		if _, err := NewTLSConfig(cfg); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
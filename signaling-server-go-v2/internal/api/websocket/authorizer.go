@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims identify the principal behind a connection, as presented on the upgrade request
+type Claims struct {
+	// Subject is the subject of the verified client certificate, if any
+	Subject string
+
+	// Token is the bearer token of the Authorization header, if any
+	Token string
+}
+
+// ClaimsFromRequest returns the claims presented on an upgrade request
+func ClaimsFromRequest(r *http.Request) Claims {
+	var claims Claims
+	if subject, ok := CertificateClientID(r); ok {
+		claims.Subject = subject
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		claims.Token = strings.TrimSpace(token)
+	}
+	return claims
+}
+
+// Decision is an Authorizer's verdict on a connected client
+type Decision struct {
+	// Revoke disconnects the client altogether, e.g. because its user was banned
+	Revoke bool `json:"revoke"`
+
+	// Reason is sent in the close frame of a revoked client
+	Reason string `json:"reason,omitempty"`
+
+	// LeaveRooms lists the rooms the client is no longer allowed in
+	LeaveRooms []string `json:"leaveRooms,omitempty"`
+
+	// ObserveRooms lists the rooms the client may only watch, as an observer
+	// that cannot send offers or broadcasts
+	ObserveRooms []string `json:"observeRooms,omitempty"`
+}
+
+// Authorizer re-checks the permissions of connected clients, so that
+// permissions revoked after connect, e.g. a user banned in the identity
+// provider, take effect on open connections
+type Authorizer interface {
+	Reauthorize(ctx context.Context, clientID string, claims Claims, rooms []string) (Decision, error)
+}
+
+// HTTPAuthorizer asks an authorization service over HTTP. It POSTs the
+// client ID, subject and rooms as JSON, forwarding the client's bearer token
+// in the Authorization header, and expects a Decision in the response.
+type HTTPAuthorizer struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPAuthorizer creates an HTTPAuthorizer for the service at url, with
+// requests timing out after timeout
+func NewHTTPAuthorizer(url string, timeout time.Duration) *HTTPAuthorizer {
+	return &HTTPAuthorizer{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// reauthorizeRequest is the body POSTed by HTTPAuthorizer
+type reauthorizeRequest struct {
+	ClientID string   `json:"clientId"`
+	Subject  string   `json:"subject,omitempty"`
+	Rooms    []string `json:"rooms"`
+}
+
+// Reauthorize implements Authorizer
+func (a *HTTPAuthorizer) Reauthorize(ctx context.Context, clientID string, claims Claims, rooms []string) (Decision, error) {
+	if rooms == nil {
+		rooms = []string{}
+	}
+	body, err := json.Marshal(reauthorizeRequest{ClientID: clientID, Subject: claims.Subject, Rooms: rooms})
+	if err != nil {
+		return Decision{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if claims.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+claims.Token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return Decision{}, fmt.Errorf("authorization request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The service may answer a revoked token with a plain 401 or 403
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return Decision{Revoke: true, Reason: "authorization revoked"}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf("authorization service returned %s", resp.Status)
+	}
+
+	var decision Decision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return Decision{}, fmt.Errorf("invalid authorization response: %w", err)
+	}
+	return decision, nil
+}
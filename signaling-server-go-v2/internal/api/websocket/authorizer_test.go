@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClaimsFromRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Authorization", "Bearer abc123")
+	if claims := ClaimsFromRequest(req); claims != (Claims{Token: "abc123"}) {
+		t.Errorf("Expected the bearer token in the claims, got %+v", claims)
+	}
+
+	req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	if claims := ClaimsFromRequest(req); claims != (Claims{}) {
+		t.Errorf("Expected no claims without a bearer token, got %+v", claims)
+	}
+}
+
+func TestHTTPAuthorizer(t *testing.T) {
+	var received reauthorizeRequest
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		switch received.ClientID {
+		case "banned":
+			w.WriteHeader(http.StatusForbidden)
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(Decision{LeaveRooms: []string{"room-b"}, Reason: "plan downgraded"})
+		}
+	}))
+	defer server.Close()
+
+	authorizer := NewHTTPAuthorizer(server.URL, time.Second)
+	ctx := context.Background()
+
+	decision, err := authorizer.Reauthorize(ctx, "client-1", Claims{Subject: "alice", Token: "abc123"}, []string{"room-a", "room-b"})
+	if err != nil {
+		t.Fatalf("Reauthorize failed: %v", err)
+	}
+	if !reflect.DeepEqual(decision, Decision{LeaveRooms: []string{"room-b"}, Reason: "plan downgraded"}) {
+		t.Errorf("Unexpected decision %+v", decision)
+	}
+	if !reflect.DeepEqual(received, reauthorizeRequest{ClientID: "client-1", Subject: "alice", Rooms: []string{"room-a", "room-b"}}) {
+		t.Errorf("Unexpected request %+v", received)
+	}
+	if authorization != "Bearer abc123" {
+		t.Errorf("Expected the client's token to be forwarded, got %q", authorization)
+	}
+
+	// A forbidden answer revokes the client
+	decision, err = authorizer.Reauthorize(ctx, "banned", Claims{}, nil)
+	if err != nil || !decision.Revoke {
+		t.Errorf("Expected a 403 to revoke the client, got %+v, %v", decision, err)
+	}
+	if authorization != "" {
+		t.Errorf("Expected no Authorization header without a token, got %q", authorization)
+	}
+
+	if _, err := authorizer.Reauthorize(ctx, "broken", Claims{}, nil); err == nil {
+		t.Error("Expected an error for a failing authorization service")
+	}
+}
@@ -0,0 +1,22 @@
+package websocket
+
+import "net/http"
+
+// CertificateClientID returns a client ID derived from the subject of the
+// verified client certificate of a mutual TLS connection: the common name,
+// or the full subject if it has none. It reports false if the request did
+// not present a verified certificate.
+func CertificateClientID(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName != "" {
+		return subject.CommonName, true
+	}
+	if name := subject.String(); name != "" {
+		return name, true
+	}
+	return "", false
+}
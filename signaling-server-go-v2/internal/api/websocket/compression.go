@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"compress/flate"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// DeflateExtension is the WebSocket extension compressing each message with DEFLATE (RFC 7692)
+const DeflateExtension = "permessage-deflate"
+
+// Compression configures permessage-deflate for connections whose client offers it
+type Compression struct {
+	Enabled bool
+
+	// Level is the DEFLATE compression level, from flate.HuffmanOnly to flate.BestCompression
+	Level int
+
+	// Threshold is the size in bytes from which messages are compressed;
+	// smaller messages, such as ICE candidates, are not worth the CPU. A
+	// negative threshold never compresses.
+	Threshold int
+
+	// Thresholds override Threshold for messages of the given types, e.g. -1
+	// to never compress ICE candidates or 0 to always compress offers
+	Thresholds map[string]int
+
+	// ServerNoContextTakeover and ClientNoContextTakeover reset the
+	// compression context after each message sent by the server and by the
+	// client, trading compression ratio for the memory each connection holds
+	ServerNoContextTakeover bool
+	ClientNoContextTakeover bool
+}
+
+// ValidLevel reports whether the compression level is supported by DEFLATE
+func (c Compression) ValidLevel() bool {
+	return c.Level >= flate.HuffmanOnly && c.Level <= flate.BestCompression
+}
+
+// Negotiate selects the first permessage-deflate offer of an upgrade
+// request's Sec-WebSocket-Extensions header that the server can accept. It
+// returns the extension response header value and whether compression was
+// negotiated. Offers limiting the server's window size are declined, as the
+// server always uses the full window.
+func (c Compression) Negotiate(r *http.Request) (string, bool) {
+	if !c.Enabled {
+		return "", false
+	}
+
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(header, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != DeflateExtension {
+				continue
+			}
+			if response, ok := c.accept(params[1:]); ok {
+				return response, true
+			}
+		}
+	}
+	return "", false
+}
+
+// accept builds the response to a permessage-deflate offer with the given
+// parameters, reporting false if the offer cannot be accepted
+func (c Compression) accept(params []string) (string, bool) {
+	serverNoContextTakeover := c.ServerNoContextTakeover
+	for _, param := range params {
+		name, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch strings.TrimSpace(name) {
+		case "server_no_context_takeover":
+			// A client asking the server not to take over the context must be honored
+			serverNoContextTakeover = true
+		case "client_no_context_takeover", "client_max_window_bits":
+			// The server may always reset the client's context, and does not
+			// limit the client's window
+		default:
+			return "", false
+		}
+	}
+
+	response := DeflateExtension
+	if serverNoContextTakeover {
+		response += "; server_no_context_takeover"
+	}
+	if c.ClientNoContextTakeover {
+		response += "; client_no_context_takeover"
+	}
+	return response, true
+}
+
+// Compress reports whether a message of the given type and size is sent
+// compressed on a connection that negotiated permessage-deflate
+func (c Compression) Compress(messageType string, size int) bool {
+	threshold, ok := c.Thresholds[messageType]
+	if !ok {
+		threshold = c.Threshold
+	}
+	return threshold >= 0 && size >= threshold
+}
+
+// DeflatedSize returns the size of a message once compressed at the level,
+// as sent in a permessage-deflate frame without the trailing empty block
+func (c Compression) DeflatedSize(message []byte) int {
+	var size byteCounter
+	w, err := flate.NewWriter(&size, c.Level)
+	if err != nil {
+		return len(message)
+	}
+	w.Write(message)
+	w.Flush()
+	return int(size) - 4
+}
+
+// byteCounter is an io.Writer counting the bytes written to it
+type byteCounter int
+
+// Write implements io.Writer
+func (b *byteCounter) Write(p []byte) (int, error) {
+	*b += byteCounter(len(p))
+	return len(p), nil
+}
+
+// MessageType returns the type of a JSON signaling message, empty if it has
+// none, to look up its compression threshold
+func MessageType(message []byte) string {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(message, &envelope)
+	return envelope.Type
+}
@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressionNegotiate(t *testing.T) {
+	takeover := Compression{Enabled: true}
+	noTakeover := Compression{Enabled: true, ServerNoContextTakeover: true, ClientNoContextTakeover: true}
+
+	cases := []struct {
+		name        string
+		compression Compression
+		offer       string
+		want        string
+	}{
+		{"browser offer", noTakeover, "permessage-deflate; client_max_window_bits", "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"context takeover", takeover, "permessage-deflate", "permessage-deflate"},
+		{"client asks for no takeover", takeover, "permessage-deflate; server_no_context_takeover", "permessage-deflate; server_no_context_takeover"},
+		{"limited server window declined", takeover, "permessage-deflate; server_max_window_bits=10", ""},
+		{"fallback offer", takeover, "permessage-deflate; server_max_window_bits=10, permessage-deflate", "permessage-deflate"},
+		{"unknown parameter", takeover, "permessage-deflate; x-custom", ""},
+		{"other extension", takeover, "x-webkit-deflate-frame", ""},
+		{"no offer", takeover, "", ""},
+		{"disabled", Compression{}, "permessage-deflate", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/ws", nil)
+			if tc.offer != "" {
+				req.Header.Set("Sec-WebSocket-Extensions", tc.offer)
+			}
+			got, ok := tc.compression.Negotiate(req)
+			if got != tc.want || ok != (tc.want != "") {
+				t.Errorf("Expected %q for offer %q, got %q (%v)", tc.want, tc.offer, got, ok)
+			}
+		})
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	for level, valid := range map[int]bool{-3: false, -2: true, 0: true, 1: true, 9: true, 10: false} {
+		if got := (Compression{Level: level}).ValidLevel(); got != valid {
+			t.Errorf("Expected level %d to be valid: %v, got %v", level, valid, got)
+		}
+	}
+}
+
+func TestCompressionThresholds(t *testing.T) {
+	c := Compression{Level: 1, Threshold: 512, Thresholds: map[string]int{"ice-candidate": -1, "offer": 0}}
+	cases := []struct {
+		messageType string
+		size        int
+		compress    bool
+	}{
+		{"ice-candidate", 4096, false},
+		{"offer", 10, true},
+		{"answer", 511, false},
+		{"answer", 512, true},
+		{"", 1024, true},
+	}
+	for _, tc := range cases {
+		if got := c.Compress(tc.messageType, tc.size); got != tc.compress {
+			t.Errorf("Expected a %d byte %q message to be compressed: %v, got %v", tc.size, tc.messageType, tc.compress, got)
+		}
+	}
+
+	if got := MessageType([]byte(`{"type":"offer","payload":{"sdp":"v=0"}}`)); got != "offer" {
+		t.Errorf("Expected the message type to be read, got %q", got)
+	}
+	if got := MessageType([]byte("not json")); got != "" {
+		t.Errorf("Expected no message type for malformed messages, got %q", got)
+	}
+
+	sdp := []byte(strings.Repeat("a=candidate:1 1 udp 2122260223 192.0.2.1 54400 typ host\r\n", 20))
+	if size := c.DeflatedSize(sdp); size <= 0 || size >= len(sdp)/4 {
+		t.Errorf("Expected repetitive SDP to deflate well, got %d of %d bytes", size, len(sdp))
+	}
+}
@@ -1,190 +1,1669 @@
 package gorilla
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
 	ws "github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/geoip"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
 )
 
+// drainPollInterval is how often Drain checks whether all clients disconnected
+const drainPollInterval = 50 * time.Millisecond
+
+// rateLimitWarningWindow is how long after a rate limit warning a client
+// exceeding the limit again is disconnected rather than warned again
+const rateLimitWarningWindow = 10 * time.Second
+
+// ErrRateLimited is returned by Client.Receive for messages dropped because the client exceeded its rate limit
+var ErrRateLimited = errs.New(errs.ResourceExhausted, "message rate limit exceeded")
+
 // Handler implements WebSocketHandler with a basic implementation
 type Handler struct {
 	wsConfig   ws.WebSocketConfig
 	clients    map[string]*Client
-	register   chan *Client
 	unregister chan *Client
 	broadcast  chan []byte
 	logger     logging.Logger
 	metrics    *metrics.Metrics
 	tracer     tracing.Tracer
 	mux        sync.Mutex
-	nextID     int
+
+	// clientLimiter and ipLimiter limit the inbound message rate of each
+	// client and of each remote IP, nil if unlimited
+	clientLimiter ws.Limiter
+	ipLimiter     ws.Limiter
+
+	// tenantLimiter limits the inbound message rate of each tenant, nil if unlimited
+	tenantLimiter ws.Limiter
+
+	// tenantOf returns the tenant of a connection's upgrade request, nil if
+	// rooms are not partitioned by tenant
+	tenantOf func(r *http.Request) (string, bool)
+
+	// connsPerIP counts the registered clients of each remote IP
+	connsPerIP map[string]int
+
+	// connsPerASN counts the registered clients of each autonomous system
+	connsPerASN map[uint]int
+
+	// locate looks up the location of remote IPs, nil if GeoIP is disabled
+	locate func(ip string) geoip.Location
+
+	// newID generates the IDs of new connections, random UUIDs by default
+	newID func() string
+
+	// identify derives client IDs from the principal authenticated on the
+	// upgrade request, nil to generate them, and identityConflict applies
+	// when a client connects with the identity of a connected client
+	identify         func(r *http.Request) (string, bool)
+	identityConflict string
+
+	// checkOrigin reports whether an upgrade request's origin is allowed, as the upgrader's CheckOrigin
+	checkOrigin func(r *http.Request) bool
+
+	// limits guards the limiters and checkOrigin, which may change at runtime
+	limits sync.RWMutex
+
+	// sessions lets reconnecting clients resume their client ID, nil if disabled
+	sessions *ws.Sessions
+
+	// now is the time source of keepalives and send latencies
+	now func() time.Time
+
+	// onConnect is called when a client connects, nil if unused
+	onConnect func(clientID string, r *http.Request)
+
+	// onMessage handles messages read from clients, nil to drop them
+	onMessage func(ctx context.Context, clientID string, message []byte) error
+
+	// onDisconnect is called when a client is gone for good, nil if unused
+	onDisconnect func(clientID string)
+
+	// authorizer re-checks the permissions of connected clients every
+	// reauthorize interval, nil if disabled
+	authorizer ws.Authorizer
+
+	// rooms returns the rooms a client has joined, and revokeRooms removes a
+	// client from rooms it is no longer authorized for
+	rooms       func(clientID string) []string
+	revokeRooms func(clientID string, rooms []string, reason string)
+
+	// observeRooms makes a client an observer of rooms it may only watch
+	observeRooms func(clientID string, rooms []string)
+
+	// codecs are the binary message encodings clients may negotiate, by subprotocol
+	codecs map[string]protocol.Codec
+
+	// stamper stamps the messages written to the clients envelopes reports
+	// as receiving them, nil if disabled
+	stamper   *protocol.Stamper
+	envelopes func(clientID string) bool
+
+	// dropped counts the outbound messages dropped on full send buffers, and
+	// rateLimited the inbound messages dropped by the rate limits
+	dropped     int64
+	rateLimited int64
+
+	// queued counts the outbound messages, one in the send latency sampling
+	// of which are timed
+	queued int64
+
+	// queuedBytes is the size of the messages queued for all clients, kept
+	// within the send memory budget. It is decremented by the write pumps
+	// without the mutex.
+	queuedBytes atomic.Int64
+}
+
+// Option configures optional Handler dependencies
+type Option func(*Handler)
+
+// WithSessions enables session resume using the given Sessions
+func WithSessions(sessions *ws.Sessions) Option {
+	return func(h *Handler) {
+		h.sessions = sessions
+	}
+}
+
+// WithClock sets the time source of keepalives and send latencies
+func WithClock(now func() time.Time) Option {
+	return func(h *Handler) {
+		h.now = now
+	}
+}
+
+// WithClientIDs sets the generator of the IDs of new connections instead of
+// random UUIDs, e.g. to get the same IDs in every run of a simulation. The
+// IDs must be unique.
+func WithClientIDs(generate func() string) Option {
+	return func(h *Handler) {
+		h.newID = generate
+	}
+}
+
+// WithRateLimiters sets the limiters of the inbound message rate of each
+// client and of each remote IP, e.g. limiters shared by all instances. A nil
+// limiter keeps the in-memory limiter of the configured rate.
+func WithRateLimiters(client, ip ws.Limiter) Option {
+	return func(h *Handler) {
+		h.clientLimiter = client
+		h.ipLimiter = ip
+	}
+}
+
+// WithGeoIP enriches connections with the country and autonomous system of
+// their remote IP, for logs, metrics and the per-ASN connection cap
+func WithGeoIP(locate func(ip string) geoip.Location) Option {
+	return func(h *Handler) {
+		h.locate = locate
+	}
+}
+
+// WithConnectHandler sets a function called with the upgrade request when a
+// client connects or resumes its session, e.g. to record the client's identity
+func WithConnectHandler(handle func(clientID string, r *http.Request)) Option {
+	return func(h *Handler) {
+		h.onConnect = handle
+	}
+}
+
+// WithMessageHandler sets the handler of messages read from clients, e.g.
+// the signaling manager's ProcessMessageContext. The context carries the
+// span of the client's connection.
+func WithMessageHandler(handle func(ctx context.Context, clientID string, message []byte) error) Option {
+	return func(h *Handler) {
+		h.onMessage = handle
+	}
+}
+
+// WithCertificateClientIDs derives the client ID of connections presenting
+// a verified client certificate from the certificate subject, so that a
+// client keeps its ID across reconnects. A new connection with the same ID
+// replaces the previous one.
+func WithCertificateClientIDs() Option {
+	return WithIdentity(ws.CertificateClientID, ws.IdentityReplace)
+}
+
+// WithIdentity derives the client ID of connections from the principal
+// authenticated on their upgrade request, e.g. with an Identifier's
+// Identify, so that a client keeps its ID across reconnects and devices.
+// Connections identify does not identify get a generated ID. The conflict
+// policy applies when a client connects with the identity of a connected client.
+func WithIdentity(identify func(r *http.Request) (string, bool), conflict string) Option {
+	return func(h *Handler) {
+		h.identify = identify
+		h.identityConflict = conflict
+	}
+}
+
+// WithTenants partitions rooms by tenant, the first namespace segment of
+// room IDs. The tenant of each connection is taken from its upgrade request
+// with tenantOf, e.g. from a token claim or the /ws/{tenant} path, and
+// connections without a valid tenant are rejected with ErrNoTenant. Clients
+// name rooms relative to their tenant, which the handler qualifies on receipt
+// and unqualifies on write.
+func WithTenants(tenantOf func(r *http.Request) (string, bool)) Option {
+	return func(h *Handler) {
+		h.tenantOf = tenantOf
+	}
+}
+
+// WithDisconnectHandler sets a function called when a client's connection
+// drops and cannot be resumed, so it can be removed from its rooms. With
+// sessions enabled this is left to session expiry.
+func WithDisconnectHandler(handle func(clientID string)) Option {
+	return func(h *Handler) {
+		h.onDisconnect = handle
+	}
+}
+
+// WithReauthorization re-checks the permissions of each connected client
+// every reauthorize interval. The authorizer is given the claims presented on
+// connect and the client's rooms, as returned by rooms. Clients it revokes are
+// disconnected; clients it denies some rooms are removed from them by
+// revokeRooms, e.g. the signaling manager's RevokeRooms.
+func WithReauthorization(authorizer ws.Authorizer, rooms func(clientID string) []string, revokeRooms func(clientID string, rooms []string, reason string)) Option {
+	return func(h *Handler) {
+		h.authorizer = authorizer
+		h.rooms = rooms
+		h.revokeRooms = revokeRooms
+	}
+}
+
+// WithObserverRestriction makes the clients the authorizer only allows to
+// watch some rooms observers of them by observeRooms, e.g. the signaling
+// manager's RestrictToObserver. It applies with WithReauthorization.
+func WithObserverRestriction(observeRooms func(clientID string, rooms []string)) Option {
+	return func(h *Handler) {
+		h.observeRooms = observeRooms
+	}
+}
+
+// WithCodecs lets clients negotiate binary frames encoded with one of the
+// codecs by listing its subprotocol in the Sec-WebSocket-Protocol header.
+// Messages are transcoded at the connection, so the message handler always
+// handles JSON.
+func WithCodecs(codecs ...protocol.Codec) Option {
+	return func(h *Handler) {
+		h.codecs = make(map[string]protocol.Codec, len(codecs))
+		for _, codec := range codecs {
+			h.codecs[codec.Subprotocol()] = codec
+		}
+	}
+}
+
+// WithEnvelopes stamps the messages written to the clients for which
+// envelopes reports true, e.g. the signaling manager's ReceivesEnvelopes,
+// with their envelope. Messages are numbered per connection as they are
+// written, so the sequence restarts when a client reconnects.
+func WithEnvelopes(stamper *protocol.Stamper, envelopes func(clientID string) bool) Option {
+	return func(h *Handler) {
+		h.stamper = stamper
+		h.envelopes = envelopes
+	}
+}
+
+// outbound is a message queued for a client, with the function to call once
+// it is written to the connection, nil if no one is waiting for the write
+type outbound struct {
+	message []byte
+	written func()
+
+	// queued is when the message was queued if its send latency is sampled,
+	// the zero time otherwise
+	queued time.Time
 }
 
 // Client represents a connected WebSocket client
 type Client struct {
 	id      string
 	handler *Handler
-	send    chan []byte
+	send    chan outbound
 	logger  logging.Logger
 	metrics *metrics.Metrics
 	tracer  tracing.Tracer
+
+	// ping signals the write pump to write a ping frame
+	ping chan struct{}
+
+	// closeCode and closeReason are sent in the close frame when the server closes the connection
+	closeCode   int
+	closeReason string
+
+	// draining is set once Drain closed the send channel; the client stays
+	// registered until its connection closes or the drain deadline passes
+	draining bool
+
+	// lastSeen is when the client last answered a ping or sent a message
+	lastSeen time.Time
+
+	// pings counts the pings sent to the client, and pingedAt is when it was last pinged
+	pings    int
+	pingedAt time.Time
+
+	// lowPower is set if the client negotiated the low power mode, relaxing its keepalive
+	lowPower bool
+
+	// warnedAt is when the client was last warned about its message rate
+	warnedAt time.Time
+
+	// ip is the remote IP the client's connection is counted against
+	ip string
+
+	// location is the location of the remote IP, the zero Location if GeoIP is disabled
+	location geoip.Location
+
+	// tenant is the tenant the client's rooms belong to, "" if rooms are not
+	// partitioned by tenant
+	tenant string
+
+	// claims are the credentials presented on connect, for reauthorization
+	claims ws.Claims
+
+	// span spans the connection, parenting the spans of its messages; nil
+	// until the client is registered
+	span tracing.Span
+
+	// codec encodes the client's binary frames, nil for JSON text frames
+	codec protocol.Codec
+
+	// deflate is set if the client negotiated permessage-deflate, and
+	// compressed counts the frames written compressed
+	deflate    bool
+	compressed int
+
+	// attached is set for connections of other transports, which keep
+	// themselves alive rather than answering the handler's pings
+	attached bool
+
+	// seq is the sequence number of the last message stamped with its
+	// envelope, only used by the write pump
+	seq uint64
+
+	// queuedBytes is the size of the messages queued for the client,
+	// counted in the handler's queuedBytes until written or released
+	queuedBytes atomic.Int64
 }
 
 // NewHandler creates a new websocket handler
-func NewHandler(cfg config.WebSocketConfig, logger logging.Logger, m *metrics.Metrics, tracer tracing.Tracer) ws.WebSocketHandler {
+func NewHandler(cfg config.WebSocketConfig, logger logging.Logger, m *metrics.Metrics, tracer tracing.Tracer, opts ...Option) ws.WebSocketHandler {
 	wsConfig := ws.NewWebSocketConfig(cfg)
 	h := &Handler{
-		wsConfig:   wsConfig,
-		clients:    make(map[string]*Client),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan []byte),
-		logger:     logger.With("component", "websocket"),
-		metrics:    m,
-		tracer:     tracer,
-		nextID:     1,
+		wsConfig:    wsConfig,
+		clients:     make(map[string]*Client),
+		connsPerIP:  make(map[string]int),
+		connsPerASN: make(map[uint]int),
+		checkOrigin: ws.CheckOrigin(wsConfig.AllowedOrigins),
+		unregister:  make(chan *Client),
+		broadcast:   make(chan []byte),
+		logger:      logger.With("component", "websocket"),
+		metrics:     m,
+		tracer:      tracer,
+		newID:       newClientID,
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.clientLimiter == nil && wsConfig.MessageRate > 0 {
+		h.clientLimiter = ws.NewMemoryLimiter(wsConfig.MessageRate, wsConfig.MessageBurst, h.now)
+	}
+	if h.ipLimiter == nil && wsConfig.IPMessageRate > 0 {
+		h.ipLimiter = ws.NewMemoryLimiter(wsConfig.IPMessageRate, wsConfig.IPMessageBurst, h.now)
+	}
+	if h.tenantLimiter == nil && h.tenantOf != nil && wsConfig.TenantMessageRate > 0 {
+		h.tenantLimiter = ws.NewMemoryLimiter(wsConfig.TenantMessageRate, wsConfig.TenantMessageBurst, h.now)
 	}
 
 	// Start the client manager
 	go h.run()
 
+	// Ping clients and reap connections that stop answering
+	if wsConfig.PingInterval > 0 && wsConfig.PongWait > 0 {
+		go h.runKeepalive()
+	}
+
+	// Re-check the permissions of connected clients
+	if h.authorizer != nil && wsConfig.ReauthorizeInterval > 0 {
+		go h.runReauthorization()
+	}
+
 	return h
 }
 
-// run processes client registration and broadcasts
+// run processes client unregistration and broadcasts
 func (h *Handler) run() {
 	for {
 		select {
-		case client := <-h.register:
-			h.mux.Lock()
-			h.clients[client.id] = client
-			h.mux.Unlock()
-			h.logger.Info("Client registered", "client_id", client.id)
-			if h.metrics != nil {
-				h.metrics.WebSocketConnect()
-			}
-
 		case client := <-h.unregister:
-			h.mux.Lock()
-			if _, ok := h.clients[client.id]; ok {
-				delete(h.clients, client.id)
-				close(client.send)
-				h.logger.Info("Client unregistered", "client_id", client.id)
-				if h.metrics != nil {
-					h.metrics.WebSocketDisconnect()
-				}
-			}
-			h.mux.Unlock()
+			h.unregisterClient(client)
 
 		case message := <-h.broadcast:
 			h.mux.Lock()
-			for id, client := range h.clients {
-				select {
-				case client.send <- message:
-					// Message sent to client
-				default:
-					// Failed to send - client buffer full
-					close(client.send)
-					delete(h.clients, id)
-					if h.metrics != nil {
-						h.metrics.WebSocketDisconnect()
-						h.metrics.WebSocketError("send_buffer_full")
-					}
-				}
+			var dropped []string
+			for _, client := range h.clients {
+				dropped = append(dropped, h.enqueue(client, outbound{message: message, queued: h.queuedAt()})...)
 			}
 			h.mux.Unlock()
+
+			for _, id := range dropped {
+				h.disconnected(id)
+			}
 		}
 	}
 }
 
-// HandleConnection handles a new WebSocket connection
-func (h *Handler) HandleConnection(w http.ResponseWriter, r *http.Request) {
-	// In a real implementation, this would upgrade the connection to WebSocket
-	// For now, just create a simulated client and acknowledge the connection
+// registerClient adds a connected client, replacing the previous connection
+// of a resumed session
+func (h *Handler) registerClient(ctx context.Context, client *Client) {
+	if h.tracer != nil {
+		transport := "websocket"
+		if client.attached {
+			transport = "attached"
+		}
+		client.span = h.tracer.StartSpan("websocket.connection",
+			tracing.WithParent(ctx),
+			tracing.WithAttributes(map[string]interface{}{
+				"client_id": client.id,
+				"transport": transport,
+			}),
+		)
+	}
+
 	h.mux.Lock()
-	clientID := h.generateClientID()
+	client.lastSeen = h.now()
+	client.pingedAt = client.lastSeen
+	if old, ok := h.clients[client.id]; ok {
+		close(old.send)
+		h.release(old)
+		if h.metrics != nil {
+			h.metrics.WebSocketDisconnect()
+		}
+	}
+	h.clients[client.id] = client
 	h.mux.Unlock()
 
-	client := &Client{
-		id:      clientID,
-		handler: h,
-		send:    make(chan []byte, 256),
-		logger:  h.logger.With("client_id", clientID),
-		metrics: h.metrics,
-		tracer:  h.tracer,
+	if h.locate != nil {
+		h.logger.InfoCtx(ctx, "Client registered", "country", client.location.Country, "asn", client.location.ASN)
+	} else {
+		h.logger.InfoCtx(ctx, "Client registered")
+	}
+	if h.metrics != nil {
+		h.metrics.WebSocketConnect()
+		if h.locate != nil {
+			h.metrics.WebSocketConnectCountry(client.location.Country)
+		}
+		if client.tenant != "" {
+			h.metrics.WebSocketConnectTenant(client.tenant)
+		}
 	}
+}
 
-	// Register the client
-	h.register <- client
+// unregisterClient removes a dropped client and detaches its session. The
+// session is detached under the same lock that removes the client, so a
+// message sent concurrently is either delivered to the client or queued.
+func (h *Handler) unregisterClient(client *Client) {
+	h.mux.Lock()
+	if current, ok := h.clients[client.id]; !ok || current != client {
+		h.mux.Unlock()
+		return
+	}
 
-	// Since we can't actually establish a WebSocket connection in this context,
-	// we'll send a success response and log it
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"connected","message":"WebSocket connection simulated","client_id":"` + clientID + `"}`))
+	delete(h.clients, client.id)
+	h.closeSend(client)
+	h.release(client)
+	resumable := h.sessions != nil && !client.attached
+	if resumable {
+		h.sessions.Detach(client.id)
+	}
+	h.logger.Info("Client unregistered", "client_id", client.id)
+	if h.metrics != nil {
+		h.metrics.WebSocketDisconnect()
+	}
+	h.mux.Unlock()
+
+	// A detached session may still be resumed; session expiry removes it.
+	// Attached clients have no session.
+	if !resumable {
+		h.disconnected(client.id)
+	}
 }
 
-// BroadcastMessage sends a message to all connected clients
-func (h *Handler) BroadcastMessage(message []byte) error {
-	h.broadcast <- message
-	return nil
+// runKeepalive pings clients every ping interval and reaps dead connections
+func (h *Handler) runKeepalive() {
+	ticker := time.NewTicker(h.wsConfig.PingInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.keepalive()
+	}
 }
 
-// SendMessage sends a message to a specific client
-func (h *Handler) SendMessage(clientID string, message []byte) error {
+// keepalive unregisters clients that have not answered a ping or sent a
+// message within the pong wait, as a real connection's read deadline would,
+// and pings the others. Low power clients are reaped after the low power
+// pong wait, and pinged on the first tick after each low power ping interval.
+// It returns the IDs of the reaped clients.
+func (h *Handler) keepalive() []string {
+	now := h.now()
+
 	h.mux.Lock()
-	defer h.mux.Unlock()
+	var dead []*Client
+	for _, client := range h.clients {
+		if client.attached {
+			continue
+		}
+		if now.Sub(client.lastSeen) > h.pongWait(client) {
+			dead = append(dead, client)
+			continue
+		}
+		if client.lowPower && now.Sub(client.pingedAt) < h.wsConfig.LowPower.PingInterval {
+			continue
+		}
 
-	client, ok := h.clients[clientID]
-	if !ok {
-		h.logger.Error("Client not found", "client_id", clientID)
-		return nil
+		// A ping still pending is not queued again
+		select {
+		case client.ping <- struct{}{}:
+		default:
+		}
+		client.pings++
+		client.pingedAt = now
 	}
+	h.mux.Unlock()
 
-	select {
-	case client.send <- message:
-		return nil
-	default:
-		// Client send channel is full - disconnect client
-		close(client.send)
-		delete(h.clients, clientID)
+	reaped := make([]string, 0, len(dead))
+	for _, client := range dead {
+		h.logger.Warn("Connection timed out", "client_id", client.id, "pong_wait", h.pongWait(client).String(), "low_power", client.lowPower)
 		if h.metrics != nil {
-			h.metrics.WebSocketDisconnect()
-			h.metrics.WebSocketError("send_buffer_full")
+			h.metrics.WebSocketError("connection_timeout")
 		}
-		return nil
+		h.unregisterClient(client)
+		reaped = append(reaped, client.id)
 	}
+	return reaped
 }
 
-// CloseConnection closes a client's connection
-func (h *Handler) CloseConnection(clientID string) error {
+// pongWait is how long a client may stay silent before it is reaped
+func (h *Handler) pongWait(client *Client) time.Duration {
+	if client.lowPower {
+		return h.wsConfig.LowPower.PongWait
+	}
+	return h.wsConfig.PongWait
+}
+
+// runReauthorization re-checks the permissions of connected clients every reauthorize interval
+func (h *Handler) runReauthorization() {
+	ticker := time.NewTicker(h.wsConfig.ReauthorizeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.reauthorize()
+	}
+}
+
+// reauthorize asks the authorizer about each connected client, disconnecting
+// the clients it revokes and removing the others from the rooms it denies.
+// Clients whose check fails keep their permissions until the next check. It
+// returns the IDs of the disconnected clients.
+func (h *Handler) reauthorize() []string {
+	type connected struct {
+		id     string
+		claims ws.Claims
+	}
+
 	h.mux.Lock()
-	defer h.mux.Unlock()
+	clients := make([]connected, 0, len(h.clients))
+	for id, client := range h.clients {
+		clients = append(clients, connected{id: id, claims: client.claims})
+	}
+	h.mux.Unlock()
 
-	client, ok := h.clients[clientID]
-	if !ok {
-		return nil
+	revoked := make([]string, 0)
+	for _, client := range clients {
+		var rooms []string
+		if h.rooms != nil {
+			rooms = h.rooms(client.id)
+		}
+
+		// The authorizer may query a remote service, so it is called without the lock
+		ctx, cancel := context.WithTimeout(context.Background(), h.wsConfig.ReauthorizeTimeout)
+		decision, err := h.authorizer.Reauthorize(ctx, client.id, client.claims, rooms)
+		cancel()
+		if err != nil {
+			h.logger.Warn("Reauthorization failed, keeping client permissions", "client_id", client.id, "error", err)
+			if h.metrics != nil {
+				h.metrics.WebSocketError("reauthorization_failed")
+			}
+			continue
+		}
+
+		reason := decision.Reason
+		if reason == "" {
+			reason = "authorization revoked"
+		}
+		if decision.Revoke {
+			h.logger.Warn("Disconnecting client with revoked authorization", "client_id", client.id, "reason", reason)
+			if h.metrics != nil {
+				h.metrics.WebSocketError("authorization_revoked")
+			}
+			h.CloseConnectionWithReason(client.id, ws.ClosePolicyViolation, reason)
+			h.disconnected(client.id)
+			revoked = append(revoked, client.id)
+			continue
+		}
+		if len(decision.LeaveRooms) > 0 && h.revokeRooms != nil {
+			h.revokeRooms(client.id, decision.LeaveRooms, reason)
+		}
+		if len(decision.ObserveRooms) > 0 && h.observeRooms != nil {
+			h.observeRooms(client.id, decision.ObserveRooms)
+		}
 	}
+	return revoked
+}
+
+// allowMessage applies the client's, its IP's and its tenant's rate limits
+// to an inbound message. A client over a limit is sent an error message the first time,
+// and disconnected if it exceeds a limit again within the warning window.
+func (h *Handler) allowMessage(client *Client) bool {
+	h.limits.RLock()
+	clientLimiter, ipLimiter, tenantLimiter := h.clientLimiter, h.ipLimiter, h.tenantLimiter
+	h.limits.RUnlock()
+
+	// The limiters may query a remote store, so they are called without the lock
+	if h.allow(clientLimiter, "client:"+client.id) && h.allow(ipLimiter, "ip:"+client.ip) &&
+		(client.tenant == "" || h.allow(tenantLimiter, "tenant:"+client.tenant)) {
+		return true
+	}
+
+	now := h.now()
+	h.mux.Lock()
+	h.rateLimited++
+	warn := client.warnedAt.IsZero() || now.Sub(client.warnedAt) > rateLimitWarningWindow
+	if warn {
+		client.warnedAt = now
+	}
+	h.mux.Unlock()
 
-	// Close the send channel to signal disconnect
-	close(client.send)
-	delete(h.clients, clientID)
 	if h.metrics != nil {
-		h.metrics.WebSocketDisconnect()
+		h.metrics.WebSocketError("rate_limited")
 	}
 
-	return nil
+	if warn {
+		h.logger.Warn("Client exceeded message rate limit", "client_id", client.id)
+		if message, err := rateLimitError(client.id); err == nil {
+			h.SendMessage(client.id, message)
+		}
+		return false
+	}
+
+	h.logger.Warn("Disconnecting client over message rate limit", "client_id", client.id)
+	h.CloseConnectionWithReason(client.id, ws.ClosePolicyViolation, "message rate limit exceeded")
+	h.disconnected(client.id)
+	return false
+}
+
+// allow checks a rate limit for the key, allowing the message if the limiter
+// is nil or fails
+func (h *Handler) allow(limiter ws.Limiter, key string) bool {
+	if limiter == nil {
+		return true
+	}
+	allowed, err := limiter.Allow(context.Background(), key)
+	if err != nil {
+		h.logger.Warn("Rate limit check failed", "error", err, "key", key)
+		return true
+	}
+	return allowed
+}
+
+// SetRateLimits implements websocket.Reconfigurer. Limiters set with
+// WithRateLimiters other than in-memory limiters, such as limiters shared by
+// all instances, are kept as they are.
+func (h *Handler) SetRateLimits(clientRate float64, clientBurst int, ipRate float64, ipBurst int) {
+	h.limits.Lock()
+	defer h.limits.Unlock()
+
+	h.clientLimiter = h.memoryLimiter(h.clientLimiter, clientRate, clientBurst)
+	h.ipLimiter = h.memoryLimiter(h.ipLimiter, ipRate, ipBurst)
+	h.logger.Info("Rate limits changed", "client_rate", clientRate, "client_burst", clientBurst, "ip_rate", ipRate, "ip_burst", ipBurst)
+}
+
+// memoryLimiter returns limiter changed to the rate, a new in-memory limiter
+// if it was unlimited, or nil to lift the limit. Limiters other than
+// in-memory limiters are returned unchanged.
+func (h *Handler) memoryLimiter(limiter ws.Limiter, rate float64, burst int) ws.Limiter {
+	memory, ok := limiter.(*ws.MemoryLimiter)
+	switch {
+	case limiter != nil && !ok:
+		return limiter
+	case rate <= 0:
+		return nil
+	case ok:
+		memory.SetRate(rate, burst)
+		return memory
+	default:
+		return ws.NewMemoryLimiter(rate, burst, h.now)
+	}
+}
+
+// SetAllowedOrigins implements websocket.Reconfigurer
+func (h *Handler) SetAllowedOrigins(origins []string) {
+	checkOrigin := ws.CheckOrigin(origins)
+
+	h.limits.Lock()
+	h.checkOrigin = checkOrigin
+	h.limits.Unlock()
+	h.logger.Info("Allowed origins changed", "origins", origins)
+}
+
+// rateLimitError builds the error message warning a client about its message rate
+func rateLimitError(clientID string) ([]byte, error) {
+	payload, err := json.Marshal(protocol.ErrorPayload{Message: "message rate limit exceeded, slow down or be disconnected"})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(protocol.Message{Type: protocol.Error, Recipient: clientID, Payload: payload})
+}
+
+// disconnected calls the disconnect handler for a client that is gone for
+// good. It must be called without the mutex held, as the handler typically
+// takes the signaling manager's locks, which are held while sending messages.
+func (h *Handler) disconnected(clientID string) {
+	if h.onDisconnect != nil {
+		h.onDisconnect(clientID)
+	}
+}
+
+// HandleConnection handles a new WebSocket connection
+func (h *Handler) HandleConnection(w http.ResponseWriter, r *http.Request) {
+	h.limits.RLock()
+	checkOrigin := h.checkOrigin
+	h.limits.RUnlock()
+
+	// In a real implementation, the upgrader would reject the origin with 403
+	if !checkOrigin(r) {
+		h.logger.WarnCtx(r.Context(), "Rejected WebSocket upgrade from disallowed origin", "origin", r.Header.Get("Origin"), "remote_addr", r.RemoteAddr)
+		if h.metrics != nil {
+			h.metrics.WebSocketError("origin_rejected")
+		}
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	tenant, err := h.tenant(r)
+	if err != nil {
+		h.logger.WarnCtx(r.Context(), "Rejected connection without a valid tenant", "remote_addr", r.RemoteAddr, "error", err)
+		if h.metrics != nil {
+			h.metrics.WebSocketError("no_tenant")
+		}
+		http.Error(w, errs.Message(err), errs.HTTPStatus(err))
+		return
+	}
+
+	// Reject the upgrade before touching sessions if the remote IP is at its cap
+	ip := remoteIP(r)
+	var location geoip.Location
+	if h.locate != nil {
+		location = h.locate(ip)
+	}
+	if limit, ok := h.acquireIP(ip, location.ASN); !ok {
+		if limit == "asn_connection_limit" {
+			h.logger.WarnCtx(r.Context(), "Too many connections from autonomous system", "remote_addr", r.RemoteAddr, "asn", location.ASN, "limit", h.wsConfig.MaxConnectionsPerASN)
+		} else {
+			h.logger.WarnCtx(r.Context(), "Too many connections from remote IP", "remote_addr", r.RemoteAddr, "limit", h.wsConfig.MaxConnectionsPerIP)
+		}
+		if h.metrics != nil {
+			h.metrics.WebSocketError(limit)
+		}
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+
+	// In a real implementation, this would upgrade the connection to WebSocket
+	// For now, just create a simulated client and acknowledge the connection
+	clientID, token, queued, resumed := h.resumeSession(r)
+	if !resumed {
+		if clientID, err = h.assignClientID(r); err != nil {
+			h.logger.WarnCtx(r.Context(), "Rejected connection of a connected identity", "remote_addr", r.RemoteAddr, "error", err)
+			if h.metrics != nil {
+				h.metrics.WebSocketError("identity_conflict")
+			}
+			h.mux.Lock()
+			h.releaseIP(ip, location.ASN)
+			h.mux.Unlock()
+			http.Error(w, errs.Message(err), errs.HTTPStatus(err))
+			return
+		}
+	}
+
+	// Correlate the connection's logs with the upgrade request and its trace
+	ctx := logging.ContextWithClientID(r.Context(), clientID)
+	if !resumed && h.sessions != nil {
+		if token, err = h.sessions.Create(clientID); err != nil {
+			h.logger.ErrorCtx(ctx, "Failed to create session", "error", err)
+		}
+	}
+
+	logger := h.logger.With("client_id", clientID)
+	if h.locate != nil {
+		logger = logger.With("country", location.Country, "asn", location.ASN)
+	}
+	if tenant != "" {
+		logger = logger.With("tenant", tenant)
+	}
+	client := &Client{
+		id:       clientID,
+		handler:  h,
+		send:     make(chan outbound, h.wsConfig.SendBufferSize),
+		ping:     make(chan struct{}, 1),
+		logger:   logger,
+		metrics:  h.metrics,
+		tracer:   h.tracer,
+		ip:       ip,
+		location: location,
+		tenant:   tenant,
+		claims:   ws.ClaimsFromRequest(r),
+		codec:    h.negotiateCodec(r),
+	}
+	extensions, deflate := h.wsConfig.Compression.Negotiate(r)
+	client.deflate = deflate
+	client.lowPower = h.wsConfig.LowPower.Negotiate(r)
+
+	// Greet the client with its ID, then flush the messages queued while a
+	// resumed client was disconnected
+	h.welcome(ctx, client, token, resumed)
+	for _, message := range queued {
+		select {
+		case client.send <- outbound{message: message}:
+			client.queuedBytes.Add(int64(len(message)))
+			h.queuedBytes.Add(int64(len(message)))
+		default:
+			h.logger.WarnCtx(ctx, "Dropped queued message on resume")
+			h.mux.Lock()
+			h.dropped++
+			h.mux.Unlock()
+		}
+	}
+
+	if resumed {
+		h.logger.InfoCtx(ctx, "Session resumed", "queued", len(queued))
+	}
+	h.registerClient(ctx, client)
+	if h.onConnect != nil {
+		h.onConnect(clientID, r)
+	}
+	if client.lowPower {
+		h.logger.DebugCtx(ctx, "Client negotiated low power mode", "ping_interval", h.wsConfig.LowPower.PingInterval.String())
+	}
+
+	// Since we can't actually establish a WebSocket connection in this context,
+	// we'll send a success response and log it. In a real implementation, the
+	// upgraded connection would be written only by go client.writePump(conn),
+	// while this goroutine runs the read pump calling Receive and Pong.
+	if client.codec != nil {
+		w.Header().Set("Sec-WebSocket-Protocol", client.codec.Subprotocol())
+	}
+	if deflate {
+		// In a real implementation, the upgrader's EnableCompression would
+		// negotiate the extension and SetCompressionLevel apply the level
+		w.Header().Set("Sec-WebSocket-Extensions", extensions)
+	}
+	if client.lowPower {
+		w.Header().Set(ws.PowerModeHeader, ws.PowerModeLow)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"connected","message":"WebSocket connection simulated","client_id":"` + clientID + `","session_token":"` + token + `","resumed":` + fmt.Sprint(resumed) + `}`))
+}
+
+// Attach implements websocket.Attacher. Attached clients are subject to the
+// connection caps and rate limits of WebSocket clients, and are pinged by
+// their own transport. They exchange JSON messages.
+func (h *Handler) Attach(r *http.Request) (ws.Conn, error) {
+	tenant, err := h.tenant(r)
+	if err != nil {
+		h.logger.WarnCtx(r.Context(), "Rejected attached connection without a valid tenant", "remote_addr", r.RemoteAddr, "error", err)
+		if h.metrics != nil {
+			h.metrics.WebSocketError("no_tenant")
+		}
+		return nil, err
+	}
+
+	ip := remoteIP(r)
+	var location geoip.Location
+	if h.locate != nil {
+		location = h.locate(ip)
+	}
+	if limit, ok := h.acquireIP(ip, location.ASN); !ok {
+		h.logger.WarnCtx(r.Context(), "Too many connections for attached client", "remote_addr", r.RemoteAddr, "limit", limit)
+		if h.metrics != nil {
+			h.metrics.WebSocketError(limit)
+		}
+		return nil, ws.ErrTooManyConnections
+	}
+
+	clientID, err := h.assignClientID(r)
+	if err != nil {
+		h.logger.WarnCtx(r.Context(), "Rejected attached connection of a connected identity", "remote_addr", r.RemoteAddr, "error", err)
+		if h.metrics != nil {
+			h.metrics.WebSocketError("identity_conflict")
+		}
+		h.mux.Lock()
+		h.releaseIP(ip, location.ASN)
+		h.mux.Unlock()
+		return nil, err
+	}
+
+	logger := h.logger.With("client_id", clientID)
+	if h.locate != nil {
+		logger = logger.With("country", location.Country, "asn", location.ASN)
+	}
+	if tenant != "" {
+		logger = logger.With("tenant", tenant)
+	}
+	client := &Client{
+		id:       clientID,
+		handler:  h,
+		send:     make(chan outbound, h.wsConfig.SendBufferSize),
+		ping:     make(chan struct{}, 1),
+		logger:   logger,
+		metrics:  h.metrics,
+		tracer:   h.tracer,
+		ip:       ip,
+		location: location,
+		tenant:   tenant,
+		claims:   ws.ClaimsFromRequest(r),
+		attached: true,
+	}
+
+	ctx := logging.ContextWithClientID(r.Context(), clientID)
+	h.welcome(ctx, client, "", false)
+	h.registerClient(ctx, client)
+	if h.onConnect != nil {
+		h.onConnect(clientID, r)
+	}
+	return client, nil
+}
+
+// negotiateCodec selects the first subprotocol of the upgrade request with a
+// codec, nil to exchange JSON text frames
+func (h *Handler) negotiateCodec(r *http.Request) protocol.Codec {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, subprotocol := range strings.Split(header, ",") {
+			if codec, ok := h.codecs[strings.TrimSpace(subprotocol)]; ok {
+				return codec
+			}
+		}
+	}
+	return nil
+}
+
+// acquireIP counts a new connection against its remote IP and autonomous
+// system, 0 if unknown. If either is at its connection cap, it reports false
+// with the error type of the cap. The counts are released by release when
+// the client is removed.
+func (h *Handler) acquireIP(ip string, asn uint) (string, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if limit := h.wsConfig.MaxConnectionsPerIP; limit > 0 && h.connsPerIP[ip] >= limit {
+		return "connection_limit", false
+	}
+	if limit := h.wsConfig.MaxConnectionsPerASN; limit > 0 && asn != 0 && h.connsPerASN[asn] >= limit {
+		return "asn_connection_limit", false
+	}
+	h.connsPerIP[ip]++
+	if asn != 0 {
+		h.connsPerASN[asn]++
+	}
+	return "", true
+}
+
+// release releases a removed client's counts against its remote IP and
+// autonomous system, and ends its connection span. Must be called with the
+// mutex held.
+func (h *Handler) release(client *Client) {
+	if client.span != nil {
+		client.span.End()
+	}
+
+	// The messages left in the send buffer no longer count against the
+	// budget, whether or not the write pump still writes them
+	h.queuedBytes.Add(-client.queuedBytes.Swap(0))
+	h.releaseIP(client.ip, client.location.ASN)
+}
+
+// releaseIP releases a connection counted by acquireIP. h.mux must be held.
+func (h *Handler) releaseIP(ip string, asn uint) {
+	if asn != 0 {
+		if h.connsPerASN[asn] <= 1 {
+			delete(h.connsPerASN, asn)
+		} else {
+			h.connsPerASN[asn]--
+		}
+	}
+
+	if h.connsPerIP[ip] <= 1 {
+		delete(h.connsPerIP, ip)
+		return
+	}
+	h.connsPerIP[ip]--
+}
+
+// remoteIP returns the IP of a request's remote address
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// resumeSession resumes the session whose token is presented in the
+// session token header, if sessions are enabled, returning the token that
+// replaces the consumed one. The token is not accepted in the query string,
+// where it would end up in access logs and traces.
+func (h *Handler) resumeSession(r *http.Request) (clientID, token string, queued [][]byte, ok bool) {
+	token = r.Header.Get(ws.SessionTokenHeader)
+	if h.sessions == nil || token == "" {
+		return "", "", nil, false
+	}
+
+	clientID, token, queued, err := h.sessions.Resume(r.Context(), token)
+	switch {
+	case errors.Is(err, ws.ErrTokenReplayed):
+		h.logger.WarnCtx(r.Context(), "Session token replayed, starting new session", "remote_addr", r.RemoteAddr)
+		if h.metrics != nil {
+			h.metrics.TokenReplayed("session")
+		}
+		return "", "", nil, false
+	case err != nil:
+		h.logger.InfoCtx(r.Context(), "Session token rejected, starting new session", "remote_addr", r.RemoteAddr, "error", err)
+		return "", "", nil, false
+	}
+	return clientID, token, queued, true
+}
+
+// BroadcastMessage sends a message to all connected clients
+func (h *Handler) BroadcastMessage(message []byte) error {
+	h.broadcast <- message
+	return nil
+}
+
+// SendMessage sends a message to a specific client
+func (h *Handler) SendMessage(clientID string, message []byte) error {
+	return h.SendMessageNotify(clientID, message, nil)
+}
+
+// SendMessageNotify implements protocol.WriteNotifier. Messages queued for a
+// disconnected session are written after the session is resumed, without
+// calling written.
+func (h *Handler) SendMessageNotify(clientID string, message []byte, written func()) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	client, ok := h.clients[clientID]
+	if !ok {
+		if h.sessions != nil && h.sessions.Enqueue(clientID, message) {
+			h.logger.Debug("Queued message for disconnected session", "client_id", clientID)
+			return nil
+		}
+		h.logger.Error("Client not found", "client_id", clientID)
+		return nil
+	}
+
+	// The caller may hold signaling locks, so the disconnect handler of the
+	// clients shed runs separately
+	if dropped := h.enqueue(client, outbound{message: message, written: written, queued: h.queuedAt()}); len(dropped) > 0 {
+		go func() {
+			for _, id := range dropped {
+				h.disconnected(id)
+			}
+		}()
+	}
+	return nil
+}
+
+// enqueue queues a message for a client, shedding load by the backpressure
+// policy when the client's send buffer or the send memory budget is full. It
+// returns the IDs of the clients disconnected to shed it, for the caller to
+// run the disconnect handler once the mutex is released. Must be called with
+// h.mux held.
+func (h *Handler) enqueue(client *Client, out outbound) []string {
+	// A draining client was sent its close frame and takes no more messages
+	if client.draining {
+		return nil
+	}
+
+	var dropped []string
+	size := int64(len(out.message))
+	disconnect := h.wsConfig.BackpressurePolicy != ws.BackpressureDrop
+
+	if budget := h.wsConfig.SendMemoryBudget; budget > 0 && h.queuedBytes.Load()+size > budget {
+		// Closing the connection with the most bytes queued frees the most
+		// memory, and is the client furthest behind
+		if disconnect {
+			if slowest := h.slowestClient(); slowest != nil {
+				h.logger.Warn("Send memory budget exceeded, disconnecting slowest client", "client_id", slowest.id, "queued_bytes", slowest.queuedBytes.Load(), "budget", budget)
+				h.shed(slowest, "send_budget_exceeded")
+				dropped = append(dropped, slowest.id)
+			}
+		}
+		if current, ok := h.clients[client.id]; !ok || current != client || h.queuedBytes.Load()+size > budget {
+			h.dropped++
+			if h.metrics != nil && !disconnect {
+				h.metrics.WebSocketError("send_budget_exceeded")
+			}
+			return dropped
+		}
+	}
+
+	select {
+	case client.send <- out:
+		client.queuedBytes.Add(size)
+		h.queuedBytes.Add(size)
+	default:
+		h.dropped++
+		if disconnect {
+			h.shed(client, "send_buffer_full")
+			dropped = append(dropped, client.id)
+		} else if h.metrics != nil {
+			h.metrics.WebSocketError("send_buffer_full")
+		}
+	}
+	return dropped
+}
+
+// slowestClient returns the client with the most bytes queued, nil if none
+// has any. Must be called with h.mux held.
+func (h *Handler) slowestClient() *Client {
+	var slowest *Client
+	var most int64
+	for _, client := range h.clients {
+		if queued := client.queuedBytes.Load(); queued > most {
+			slowest, most = client, queued
+		}
+	}
+	return slowest
+}
+
+// shed closes the connection of a client to shed load. Must be called with
+// h.mux held; the caller runs the disconnect handler once it is released.
+func (h *Handler) shed(client *Client, reason string) {
+	h.closeSend(client)
+	delete(h.clients, client.id)
+	h.release(client)
+	if h.metrics != nil {
+		h.metrics.WebSocketDisconnect()
+		h.metrics.WebSocketError(reason)
+	}
+}
+
+// queuedAt returns the queue time of an outbound message, or the zero time
+// unless the message is sampled for its send latency. Must be called with
+// h.mux held.
+func (h *Handler) queuedAt() time.Time {
+	every := h.wsConfig.SendLatencySampling
+	if every <= 0 || h.metrics == nil {
+		return time.Time{}
+	}
+	h.queued++
+	if h.queued%int64(every) != 0 {
+		return time.Time{}
+	}
+	return h.now()
+}
+
+// CloseConnection closes a client's connection
+func (h *Handler) CloseConnection(clientID string) error {
+	return h.CloseConnectionWithReason(clientID, ws.CloseNormalClosure, "")
+}
+
+// CloseConnectionWithReason closes a client's connection with a close frame carrying the code and reason
+func (h *Handler) CloseConnectionWithReason(clientID string, code int, reason string) error {
+	// A connection closed by the server cannot be resumed. The session is
+	// ended before taking the lock, as invalidating its token may reach a
+	// shared store.
+	if h.sessions != nil {
+		h.sessions.End(clientID)
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	client, ok := h.clients[clientID]
+	if !ok {
+		return nil
+	}
+
+	// Record the close frame and close the send channel to signal disconnect
+	client.closeCode = code
+	client.closeReason = reason
+	h.logger.Info("Closing client connection", "client_id", clientID, "code", code, "reason", reason)
+	h.closeSend(client)
+	delete(h.clients, clientID)
+	h.release(client)
+	if h.metrics != nil {
+		h.metrics.WebSocketDisconnect()
+	}
+
+	return nil
+}
+
+// Drain implements websocket.Drainer. Closing the send channel of each
+// client, its write pump sends the close frame after its queued messages;
+// clients are expected to answer it by disconnecting.
+func (h *Handler) Drain(ctx context.Context, reason string) ws.DrainResult {
+	h.mux.Lock()
+	for id, client := range h.clients {
+		// The write pump writes the queued messages, then the close frame
+		client.closeCode = ws.CloseGoingAway
+		client.closeReason = reason
+		h.closeSend(client)
+		client.draining = true
+		if h.sessions != nil {
+			h.sessions.End(id)
+		}
+	}
+	total := len(h.clients)
+	h.mux.Unlock()
+
+	h.logger.Info("Draining connections", "clients", total, "reason", reason)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		h.mux.Lock()
+		remaining := len(h.clients)
+		h.mux.Unlock()
+		if remaining == 0 {
+			return ws.DrainResult{Closed: total}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			forced := h.forceClose()
+			return ws.DrainResult{Closed: total - forced, Forced: forced}
+		}
+	}
+}
+
+// closeSend closes the send channel of a client, signaling its write pump to
+// write the close frame, unless Drain closed it already. Must be called with
+// h.mux held.
+func (h *Handler) closeSend(client *Client) {
+	if !client.draining {
+		close(client.send)
+	}
+}
+
+// forceClose closes the connections of all remaining clients and returns how many there were
+func (h *Handler) forceClose() int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	forced := len(h.clients)
+	for id, client := range h.clients {
+		h.closeSend(client)
+		delete(h.clients, id)
+		h.release(client)
+		if h.metrics != nil {
+			h.metrics.WebSocketDisconnect()
+			h.metrics.WebSocketError("drain_timeout")
+		}
+	}
+	return forced
+}
+
+// Stats implements websocket.StatsReporter
+func (h *Handler) Stats() ws.HubStats {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	depths := h.queueDepthsLocked()
+	stats := ws.HubStats{
+		Connections:         len(h.clients),
+		DroppedMessages:     h.dropped,
+		RateLimitedMessages: h.rateLimited,
+		QueuedBytes:         h.queuedBytes.Load(),
+		QueueDepthP50:       percentile(depths, 50),
+		QueueDepthP90:       percentile(depths, 90),
+		QueueDepthP99:       percentile(depths, 99),
+	}
+	for _, depth := range depths {
+		stats.QueuedMessages += depth
+	}
+	if len(depths) > 0 {
+		stats.MaxQueueDepth = depths[len(depths)-1]
+	}
+	return stats
+}
+
+// QueueDepths implements websocket.QueueReporter, returning the depths of
+// the clients' send buffers in ascending order
+func (h *Handler) QueueDepths() []int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.queueDepthsLocked()
+}
+
+// queueDepthsLocked returns the sorted depths of the clients' send buffers.
+// Must be called with h.mux held.
+func (h *Handler) queueDepthsLocked() []int {
+	depths := make([]int, 0, len(h.clients))
+	for _, client := range h.clients {
+		depths = append(depths, len(client.send))
+	}
+	sort.Ints(depths)
+	return depths
+}
+
+// percentile returns the nearest-rank percentile p of sorted values, 0 if there are none
+func percentile(sorted []int, p int) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (len(sorted)*p + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// ClientIDs returns the IDs of all registered clients, sorted
+func (h *Handler) ClientIDs() []string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	ids := make([]string, 0, len(h.clients))
+	for id := range h.clients {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// newClientID generates a random (version 4) UUID as client ID, which
+// neither collides across restarts nor lets clients guess each other's IDs
+func newClientID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// tenant returns the tenant of a new connection, "" if rooms are not
+// partitioned by tenant. It returns ErrNoTenant if they are and the upgrade
+// request names no tenant, and an InvalidArgument error if it names an
+// invalid one.
+func (h *Handler) tenant(r *http.Request) (string, error) {
+	if h.tenantOf == nil {
+		return "", nil
+	}
+	tenant, ok := h.tenantOf(r)
+	if !ok {
+		return "", ws.ErrNoTenant
+	}
+	if err := protocol.ValidateTenant(tenant); err != nil {
+		return "", err
+	}
+	return tenant, nil
+}
+
+// assignClientID returns the client ID of a new connection: the identity of
+// its authenticated principal, a device of it or, if it is not identified, a
+// generated ID. It returns ErrIdentityConnected if the identity is connected
+// and the conflict policy rejects the connection.
+func (h *Handler) assignClientID(r *http.Request) (string, error) {
+	if h.identify == nil {
+		return h.newID(), nil
+	}
+	identity, ok := h.identify(r)
+	if !ok {
+		return h.newID(), nil
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if _, connected := h.clients[identity]; !connected {
+		return identity, nil
+	}
+	switch h.identityConflict {
+	case ws.IdentityReject:
+		return "", fmt.Errorf("%w: %s", ws.ErrIdentityConnected, identity)
+	case ws.IdentityMultiDevice:
+		for device := 2; ; device++ {
+			id := fmt.Sprintf("%s#%d", identity, device)
+			if _, connected := h.clients[id]; !connected {
+				return id, nil
+			}
+		}
+	}
+	return identity, nil
+}
+
+// welcome queues the welcome message for a new client, the first message it
+// receives, so that it learns the ID it was assigned
+func (h *Handler) welcome(ctx context.Context, client *Client, token string, resumed bool) {
+	message, err := protocol.NewWelcome(client.id, token, resumed)
+	if err != nil {
+		h.logger.ErrorCtx(ctx, "Failed to build welcome message", "error", err)
+		return
+	}
+
+	select {
+	case client.send <- outbound{message: message}:
+		client.queuedBytes.Add(int64(len(message)))
+		h.queuedBytes.Add(int64(len(message)))
+	default:
+	}
+}
+
+// Client returns a connected client by ID
+func (h *Handler) Client(clientID string) (*Client, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	client, ok := h.clients[clientID]
+	return client, ok
+}
+
+// ID returns the client's ID
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Receive handles a message read from the client's connection, as the read
+// pump does for each message of a real connection
+func (c *Client) Receive(message []byte) (err error) {
+	// A panic reading or handling the message closes only this connection
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := ws.NewPanicError(r)
+			c.logger.Error("Panic receiving message", "error", panicErr, "stack", string(panicErr.Stack))
+			err = panicErr
+		}
+		var panicErr *ws.PanicError
+		if errors.As(err, &panicErr) {
+			c.panicked()
+		}
+	}()
+
+	c.seen()
+	if !c.handler.allowMessage(c) {
+		return ErrRateLimited
+	}
+	if c.handler.onMessage == nil {
+		return nil
+	}
+	if c.codec != nil {
+		decoded, err := protocol.DecodeJSON(c.codec, message)
+		if err != nil {
+			if c.metrics != nil {
+				c.metrics.WebSocketError("invalid_frame")
+			}
+			return fmt.Errorf("invalid %s frame: %w", c.codec.Subprotocol(), err)
+		}
+		message = decoded
+	}
+	if c.tenant != "" {
+		qualified, err := protocol.QualifyMessage(c.tenant, message)
+		if err != nil {
+			if c.metrics != nil {
+				c.metrics.WebSocketError("invalid_message")
+			}
+			return err
+		}
+		message = qualified
+		if c.metrics != nil {
+			c.metrics.WebSocketMessageTenant(c.tenant)
+		}
+	}
+	ctx := context.Background()
+	if c.span != nil {
+		ctx = c.span.Context()
+	}
+	return c.handler.onMessage(ctx, c.id, message)
+}
+
+// panicked closes the connection of a client whose message handling
+// panicked, and removes the client from its rooms. The session is ended, as
+// resuming it would replay the state that panicked.
+func (c *Client) panicked() {
+	if c.metrics != nil {
+		c.metrics.WebSocketError("panic")
+	}
+	c.handler.CloseConnectionWithReason(c.id, ws.CloseInternalError, "internal error")
+	c.handler.disconnected(c.id)
+}
+
+// Pong records a pong from the client, as the pong handler of a real
+// connection does by extending the read deadline
+func (c *Client) Pong() {
+	c.seen()
+}
+
+// seen records that the client's connection is alive
+func (c *Client) seen() {
+	c.handler.mux.Lock()
+	defer c.handler.mux.Unlock()
+	c.lastSeen = c.handler.now()
+}
+
+// Disconnect unregisters the client as if its connection dropped, as the
+// read pump does when reading fails
+func (c *Client) Disconnect() {
+	c.handler.unregisterClient(c)
+}
+
+// Drain returns the messages waiting to be written to the client, as the
+// write pump writes them, and whether the connection is still open. Once
+// closed, CloseFrame returns the code and reason sent to the client.
+func (c *Client) Drain() ([][]byte, bool) {
+	var messages [][]byte
+	for {
+		select {
+		case out, ok := <-c.send:
+			if !ok {
+				return messages, false
+			}
+			if frame, ok := c.frame(out); ok {
+				messages = append(messages, frame)
+				c.sent(out)
+			}
+		default:
+			return messages, true
+		}
+	}
+}
+
+// frame stamps a message with its envelope and encodes it with the client's
+// codec, as the write pump does before writing a frame. Messages that cannot
+// be encoded are dropped; those that cannot be stamped are written without
+// envelope.
+func (c *Client) frame(out outbound) ([]byte, bool) {
+	size := len(out.message)
+
+	// Rooms are named to a tenant's clients relative to the tenant
+	if c.tenant != "" {
+		if unqualified, err := protocol.UnqualifyMessage(c.tenant, out.message); err == nil {
+			out.message = unqualified
+		}
+	}
+
+	// The envelope is checked without the handler's mutex, as the signaling
+	// manager sends messages while holding its locks
+	if h := c.handler; h.stamper != nil && h.envelopes(c.id) {
+		if stamped, err := h.stamper.Stamp(out.message, c.seq+1); err != nil {
+			c.logger.Warn("Writing message without envelope", "error", err)
+		} else {
+			c.seq++
+			out.message = stamped
+		}
+	}
+
+	frame := out.message
+	if c.codec != nil {
+		var err error
+		if frame, err = protocol.EncodeJSON(c.codec, out.message); err != nil {
+			c.logger.Error("Failed to encode message", "error", err, "subprotocol", c.codec.Subprotocol())
+			c.dequeued(size)
+			return nil, false
+		}
+	}
+
+	if c.deflate {
+		compression := c.handler.wsConfig.Compression
+		messageType := ws.MessageType(out.message)
+		compressed := compression.Compress(messageType, len(frame))
+		wireSize := len(frame)
+
+		// In a real implementation, the write pump would call
+		// EnableWriteCompression with this decision before writing the frame,
+		// and count the bytes the connection wrote
+		if compressed {
+			wireSize = compression.DeflatedSize(frame)
+			c.handler.mux.Lock()
+			c.compressed++
+			c.handler.mux.Unlock()
+		}
+		if c.metrics != nil {
+			c.metrics.CompressionBytes(messageType, compressed, len(frame), wireSize)
+		}
+	}
+
+	return frame, true
+}
+
+// sent reports a message written to the connection, to the caller waiting
+// for the write and to the send latency metrics
+func (c *Client) sent(out outbound) {
+	c.dequeued(len(out.message))
+	if out.written != nil {
+		out.written()
+	}
+	if !out.queued.IsZero() && c.metrics != nil {
+		c.metrics.SendLatency(c.handler.now().Sub(out.queued))
+	}
+}
+
+// dequeued releases the bytes of a message taken off the send buffer from
+// the send memory budget. Once the client is released its bytes were
+// already, and the count goes negative instead.
+func (c *Client) dequeued(size int) {
+	if c.queuedBytes.Add(-int64(size)) >= 0 {
+		c.handler.queuedBytes.Add(-int64(size))
+	}
+}
+
+// Compressed returns the number of frames written to the client compressed
+// with permessage-deflate
+func (c *Client) Compressed() int {
+	c.handler.mux.Lock()
+	defer c.handler.mux.Unlock()
+	return c.compressed
+}
+
+// Next implements websocket.Conn, waiting for the next message as the write
+// pump does, for transports writing the frames themselves
+func (c *Client) Next(ctx context.Context) ([]byte, bool) {
+	for {
+		select {
+		case out, ok := <-c.send:
+			if !ok {
+				return nil, false
+			}
+			// Attached transports write the frame right away
+			if frame, ok := c.frame(out); ok {
+				c.sent(out)
+				return frame, true
+			}
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
 }
 
-// generateClientID generates a simple client ID
-func (h *Handler) generateClientID() string {
-	id := h.nextID
-	h.nextID++
-	return "client-" + time.Now().Format("20060102150405") + "-" + fmt.Sprint(id)
+// CloseFrame returns the close code and reason the server closed the connection with, zero if none
+func (c *Client) CloseFrame() (int, string) {
+	c.handler.mux.Lock()
+	defer c.handler.mux.Unlock()
+	return c.closeCode, c.closeReason
 }
@@ -1,25 +1,31 @@
 package gorilla
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
-	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	ws "github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/geoip"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
 )
 
-// MockLogger implements logging.Logger for testing
-type MockLogger struct{}
-
-func (l *MockLogger) Debug(msg string, keyvals ...interface{})   {}
-func (l *MockLogger) Info(msg string, keyvals ...interface{})    {}
-func (l *MockLogger) Warn(msg string, keyvals ...interface{})    {}
-func (l *MockLogger) Error(msg string, keyvals ...interface{})   {}
-func (l *MockLogger) With(keyvals ...interface{}) logging.Logger { return l }
-
 func TestNewHandler(t *testing.T) {
 	// Create config
 	cfg := config.WebSocketConfig{
@@ -31,7 +37,7 @@ func TestNewHandler(t *testing.T) {
 	}
 
 	// Create dependencies
-	logger := &MockLogger{}
+	logger := testsupport.NewLogger()
 	metrics := metrics.NewMetrics(config.MetricsConfig{Enabled: true})
 	tracer := &tracing.NoopTracer{}
 
@@ -55,7 +61,7 @@ func TestHandleConnection(t *testing.T) {
 	}
 
 	// Create dependencies
-	logger := &MockLogger{}
+	logger := testsupport.NewLogger()
 	metrics := metrics.NewMetrics(config.MetricsConfig{Enabled: true})
 	tracer := &tracing.NoopTracer{}
 
@@ -90,7 +96,7 @@ func TestBroadcastAndSendMessages(t *testing.T) {
 		WriteWait:      10,
 		MaxMessageSize: 1024 * 1024,
 	}
-	logger := &MockLogger{}
+	logger := testsupport.NewLogger()
 	metrics := metrics.NewMetrics(config.MetricsConfig{Enabled: true})
 	tracer := &tracing.NoopTracer{}
 	h := NewHandler(cfg, logger, metrics, tracer).(*Handler)
@@ -101,7 +107,7 @@ func TestBroadcastAndSendMessages(t *testing.T) {
 	client := &Client{
 		id:      clientID,
 		handler: h,
-		send:    make(chan []byte, 10),
+		send:    make(chan outbound, 10),
 		logger:  logger,
 		metrics: metrics,
 		tracer:  tracer,
@@ -117,7 +123,7 @@ func TestBroadcastAndSendMessages(t *testing.T) {
 	}
 
 	// Verify message was sent
-	receivedMsg := <-client.send
+	receivedMsg := (<-client.send).message
 	if string(receivedMsg) != string(message) {
 		t.Errorf("Expected message %s, got %s", string(message), string(receivedMsg))
 	}
@@ -130,7 +136,7 @@ func TestBroadcastAndSendMessages(t *testing.T) {
 	}
 
 	// Verify broadcast message was received
-	broadcastReceived := <-client.send
+	broadcastReceived := (<-client.send).message
 	if string(broadcastReceived) != string(broadcastMsg) {
 		t.Errorf("Expected broadcast message %s, got %s", string(broadcastMsg), string(broadcastReceived))
 	}
@@ -150,3 +156,1233 @@ func TestBroadcastAndSendMessages(t *testing.T) {
 		t.Error("Expected client to be removed after closing connection")
 	}
 }
+
+func TestSessionResume(t *testing.T) {
+	cfg := config.WebSocketConfig{Path: "/ws"}
+	logger := testsupport.NewLogger()
+	sessions := ws.NewSessions(time.Minute, 10)
+	h := NewHandler(cfg, logger, testsupport.NewMetrics(), &tracing.NoopTracer{}, WithSessions(sessions)).(*Handler)
+
+	connect := func(token string) map[string]interface{} {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		if token != "" {
+			req.Header.Set(ws.SessionTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return resp
+	}
+
+	first := connect("")
+	clientID := first["client_id"].(string)
+	token := first["session_token"].(string)
+	if token == "" || first["resumed"] != false {
+		t.Fatalf("Expected a new session, got %v", first)
+	}
+
+	// Simulate the connection dropping and a relay arriving meanwhile
+	client, _ := h.Client(clientID)
+	client.Disconnect()
+	h.SendMessage(clientID, []byte("queued offer"))
+
+	second := connect(token)
+	if second["client_id"] != clientID || second["resumed"] != true {
+		t.Fatalf("Expected to resume %s, got %v", clientID, second)
+	}
+
+	resumed, ok := h.Client(clientID)
+	if !ok || resumed == client {
+		t.Fatalf("Expected %s to be registered with a new connection", clientID)
+	}
+	skipWelcome(t, resumed)
+	if messages, _ := resumed.Drain(); len(messages) != 1 || string(messages[0]) != "queued offer" {
+		t.Errorf("Expected queued message to be flushed on resume, got %q", messages)
+	}
+
+	// The used token is replaced, and replaying it starts a new session
+	if next := second["session_token"]; next == "" || next == token {
+		t.Errorf("Expected a new session token on resume, got %v", next)
+	}
+	if replayed := connect(token); replayed["client_id"] == clientID || replayed["resumed"] != false {
+		t.Errorf("Expected a replayed token not to resume %s, got %v", clientID, replayed)
+	}
+}
+
+func TestClientHooks(t *testing.T) {
+	cfg := config.WebSocketConfig{Path: "/ws"}
+	var received, disconnected []string
+	h := NewHandler(cfg, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }),
+		WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			received = append(received, clientID+":"+string(message))
+			return nil
+		}),
+		WithDisconnectHandler(func(clientID string) {
+			disconnected = append(disconnected, clientID)
+		}),
+	).(*Handler)
+
+	rec := httptest.NewRecorder()
+	h.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	client, ok := h.Client(resp["client_id"].(string))
+	if !ok {
+		t.Fatalf("Expected the client to be registered, got %s", rec.Body.String())
+	}
+	skipWelcome(t, client)
+
+	client.Receive([]byte("hello"))
+	if !reflect.DeepEqual(received, []string{client.ID() + ":hello"}) {
+		t.Errorf("Expected message to reach the message handler, got %v", received)
+	}
+
+	h.SendMessage(client.ID(), []byte("hello"))
+	if messages, open := client.Drain(); len(messages) != 1 || !open {
+		t.Errorf("Expected one message on an open connection, got %q (open %v)", messages, open)
+	}
+
+	// Without sessions a dropped connection is gone for good
+	client.Disconnect()
+	if !reflect.DeepEqual(disconnected, []string{client.ID()}) {
+		t.Errorf("Expected disconnect handler to be called, got %v", disconnected)
+	}
+	if _, open := client.Drain(); open {
+		t.Error("Expected connection to be closed after disconnect")
+	}
+}
+
+func TestKeepaliveReapsDeadConnections(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var disconnected []string
+	h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return now }),
+		WithDisconnectHandler(func(clientID string) {
+			disconnected = append(disconnected, clientID)
+		}),
+	).(*Handler)
+	h.wsConfig.PongWait = time.Minute
+
+	connect := func() *Client {
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		return client
+	}
+	alive, dead := connect(), connect()
+
+	now = now.Add(30 * time.Second)
+	if reaped := h.keepalive(); len(reaped) != 0 {
+		t.Errorf("Expected no connections reaped within the pong wait, got %v", reaped)
+	}
+	if alive.pings != 1 || dead.pings != 1 {
+		t.Errorf("Expected each client to be pinged once, got %d and %d", alive.pings, dead.pings)
+	}
+
+	// Only the client answering pings survives past the pong wait
+	alive.Pong()
+	now = now.Add(45 * time.Second)
+	if reaped := h.keepalive(); !reflect.DeepEqual(reaped, []string{dead.ID()}) {
+		t.Errorf("Expected %s to be reaped, got %v", dead.ID(), reaped)
+	}
+	if !reflect.DeepEqual(disconnected, []string{dead.ID()}) {
+		t.Errorf("Expected the reaped client to be removed from its rooms, got %v", disconnected)
+	}
+	if _, ok := h.Client(alive.ID()); !ok {
+		t.Error("Expected the responsive client to stay connected")
+	}
+}
+
+func TestLowPowerKeepalive(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", LowPowerPingInterval: 120, LowPowerPongWait: 300}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return now }),
+	).(*Handler)
+	h.wsConfig.PingInterval = 30 * time.Second
+	h.wsConfig.PongWait = time.Minute
+
+	connect := func(req *http.Request) (*Client, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		return client, rec
+	}
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set(ws.PowerModeHeader, "low")
+	header, rec := connect(req)
+	if got := rec.Header().Get(ws.PowerModeHeader); got != ws.PowerModeLow {
+		t.Errorf("Expected the low power mode to be accepted, got %q", got)
+	}
+	query, _ := connect(httptest.NewRequest("GET", "/ws?powerMode=low", nil))
+	normal, rec := connect(httptest.NewRequest("GET", "/ws", nil))
+	if got := rec.Header().Get(ws.PowerModeHeader); got != "" {
+		t.Errorf("Expected no power mode for a client not declaring it, got %q", got)
+	}
+	if !header.lowPower || !query.lowPower || normal.lowPower {
+		t.Fatalf("Expected the header and query clients in low power mode, got %v, %v and %v", header.lowPower, query.lowPower, normal.lowPower)
+	}
+
+	// Low power clients are pinged once per low power interval and outlive the normal pong wait
+	for i := 0; i < 4; i++ {
+		now = now.Add(30 * time.Second)
+		normal.Pong()
+		if reaped := h.keepalive(); len(reaped) != 0 {
+			t.Fatalf("Expected no connections reaped within the pong waits, got %v", reaped)
+		}
+	}
+	if header.pings != 1 || normal.pings != 4 {
+		t.Errorf("Expected 1 ping in low power mode and 4 otherwise, got %d and %d", header.pings, normal.pings)
+	}
+
+	// Liveness is still enforced after the low power pong wait
+	header.Pong()
+	now = now.Add(200 * time.Second)
+	normal.Pong()
+	if reaped := h.keepalive(); !reflect.DeepEqual(reaped, []string{query.ID()}) {
+		t.Errorf("Expected the silent low power client to be reaped, got %v", reaped)
+	}
+}
+
+func TestSendMessageNotify(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}).(*Handler)
+
+	rec := httptest.NewRecorder()
+	h.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	client, _ := h.Client(resp["client_id"].(string))
+	skipWelcome(t, client)
+
+	written := 0
+	if err := h.SendMessageNotify(client.ID(), []byte(`{"type":"offer"}`), func() { written++ }); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	h.SendMessage(client.ID(), []byte(`{"type":"answer"}`))
+	if written != 0 {
+		t.Error("Expected the write not to be reported while the message is queued")
+	}
+
+	if messages, _ := client.Drain(); len(messages) != 2 {
+		t.Fatalf("Expected both messages to be written, got %d", len(messages))
+	}
+	if written != 1 {
+		t.Errorf("Expected the write to be reported once, got %d", written)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var disconnected []string
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", MessageRateLimit: 1, MessageRateBurst: 2}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return now }),
+		WithDisconnectHandler(func(clientID string) {
+			disconnected = append(disconnected, clientID)
+		}),
+	).(*Handler)
+
+	rec := httptest.NewRecorder()
+	h.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	client, _ := h.Client(resp["client_id"].(string))
+	skipWelcome(t, client)
+
+	for i := 0; i < 2; i++ {
+		if err := client.Receive([]byte(`{}`)); err != nil {
+			t.Fatalf("Expected message %d within the burst to be accepted, got %v", i, err)
+		}
+	}
+
+	// The first message over the limit is dropped with a warning
+	if err := client.Receive([]byte(`{}`)); err != ErrRateLimited {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+	messages, open := client.Drain()
+	if !open || len(messages) != 1 || !strings.Contains(string(messages[0]), "rate limit") {
+		t.Fatalf("Expected a rate limit warning on an open connection, got %q (open %v)", messages, open)
+	}
+
+	// Tokens refill over time
+	now = now.Add(time.Second)
+	if err := client.Receive([]byte(`{}`)); err != nil {
+		t.Fatalf("Expected a refilled token to be accepted, got %v", err)
+	}
+
+	// Exceeding the limit again within the warning window disconnects the client
+	client.Receive([]byte(`{}`))
+	if _, ok := h.Client(client.ID()); ok {
+		t.Error("Expected the client to be disconnected")
+	}
+	if !reflect.DeepEqual(disconnected, []string{client.ID()}) {
+		t.Errorf("Expected the client to be removed from its rooms, got %v", disconnected)
+	}
+}
+
+func TestConnectionLimitPerASN(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", MaxConnectionsPerASN: 1}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithGeoIP(func(ip string) geoip.Location {
+			if strings.HasPrefix(ip, "198.51.100.") {
+				return geoip.Location{Country: "NL", ASN: 64500}
+			}
+			return geoip.Location{Country: geoip.UnknownCountry}
+		}),
+	).(*Handler)
+
+	connect := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		return rec
+	}
+
+	first := connect("198.51.100.1:1000")
+	if rec := connect("198.51.100.2:1000"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 over the ASN's cap, got %d", rec.Code)
+	}
+	if len(h.connsPerIP) != 1 {
+		t.Errorf("Expected the rejected connection not to count against its IP, got %v", h.connsPerIP)
+	}
+
+	// IPs of unknown autonomous systems are not capped together
+	connect("192.0.2.1:1000")
+	if rec := connect("192.0.2.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected an IP of an unknown ASN to connect, got %d", rec.Code)
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(first.Body.Bytes(), &resp)
+	client, _ := h.Client(resp["client_id"].(string))
+	client.Disconnect()
+	if rec := connect("198.51.100.2:1001"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a freed ASN slot to accept a connection, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", IPMessageRateLimit: 1, IPMessageRateBurst: 2}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return now }),
+	).(*Handler)
+
+	connect := func(remoteAddr string) *Client {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		return client
+	}
+
+	// Clients of the same IP share its limit
+	first, second := connect("198.51.100.1:1000"), connect("198.51.100.1:1001")
+	if err := first.Receive([]byte(`{}`)); err != nil {
+		t.Fatalf("Expected a message within the burst to be accepted, got %v", err)
+	}
+	if err := second.Receive([]byte(`{}`)); err != nil {
+		t.Fatalf("Expected a message within the burst to be accepted, got %v", err)
+	}
+	if err := second.Receive([]byte(`{}`)); err != ErrRateLimited {
+		t.Errorf("Expected ErrRateLimited over the IP's burst, got %v", err)
+	}
+
+	if err := connect("198.51.100.2:1000").Receive([]byte(`{}`)); err != nil {
+		t.Errorf("Expected a client of another IP to be accepted, got %v", err)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var received []string
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", TenantMessageRateLimit: 1, TenantMessageRateBurst: 2}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return now }),
+		WithTenants(ws.TenantResolver([]string{ws.TenantFromPath}, nil, "/ws")),
+		WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			received = append(received, string(message))
+			return nil
+		}),
+	).(*Handler)
+
+	connect := func(path string) (*Client, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, httptest.NewRequest("GET", path, nil))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		id, _ := resp["client_id"].(string)
+		client, _ := h.Client(id)
+		return client, rec
+	}
+
+	for _, path := range []string{"/ws", "/ws/acme/web", "/ws/*"} {
+		if _, rec := connect(path); rec.Code != http.StatusForbidden && rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected a connection to %s to be rejected, got %d", path, rec.Code)
+		}
+	}
+
+	// Rooms are named relative to the tenant
+	acme, _ := connect("/ws/acme")
+	skipWelcome(t, acme)
+	if err := acme.Receive([]byte(`{"type":"join","room":"standup"}`)); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if len(received) != 1 || !strings.Contains(received[0], `"room":"acme/standup"`) {
+		t.Errorf("Expected the room to be qualified with the tenant, got %v", received)
+	}
+	h.SendMessage(acme.ID(), []byte(`{"type":"joined","room":"acme/standup"}`))
+	if messages, _ := acme.Drain(); len(messages) != 1 || !strings.Contains(string(messages[0]), `"room":"standup"`) {
+		t.Errorf("Expected the room to be unqualified for the client, got %q", messages)
+	}
+
+	// Clients of a tenant share its rate limit
+	other, _ := connect("/ws?tenant=acme")
+	if err := other.Receive([]byte(`{}`)); err != nil {
+		t.Fatalf("Expected a message within the burst to be accepted, got %v", err)
+	}
+	if err := other.Receive([]byte(`{}`)); err != ErrRateLimited {
+		t.Errorf("Expected ErrRateLimited over the tenant's burst, got %v", err)
+	}
+	globex, _ := connect("/ws/globex")
+	if err := globex.Receive([]byte(`{}`)); err != nil {
+		t.Errorf("Expected a client of another tenant to be accepted, got %v", err)
+	}
+}
+
+func TestConnectionLimitPerIP(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", MaxConnectionsPerIP: 2}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}).(*Handler)
+
+	connect := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		return rec
+	}
+
+	first := connect("198.51.100.1:1000")
+	connect("198.51.100.1:1001")
+	if rec := connect("198.51.100.1:1002"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 over the cap, got %d", rec.Code)
+	}
+	if rec := connect("198.51.100.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another IP to connect, got %d", rec.Code)
+	}
+
+	// A disconnect frees a slot for the IP
+	var resp map[string]interface{}
+	json.Unmarshal(first.Body.Bytes(), &resp)
+	client, _ := h.Client(resp["client_id"].(string))
+	client.Disconnect()
+	if rec := connect("198.51.100.1:1003"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a freed slot to accept a connection, got %d", rec.Code)
+	}
+}
+
+func TestHandleConnectionChecksOrigin(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", AllowedOrigins: []string{"https://*.example.com"}}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}).(*Handler)
+
+	for origin, status := range map[string]int{
+		"https://app.example.com":  http.StatusOK,
+		"https://evil.example.net": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		if rec.Code != status {
+			t.Errorf("Expected status %d for origin %s, got %d", status, origin, rec.Code)
+		}
+	}
+	if len(h.ClientIDs()) != 1 {
+		t.Errorf("Expected only the allowed origin to connect, got %v", h.ClientIDs())
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", AllowedOrigins: []string{"https://app.example.com"}}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return now }),
+	).(*Handler)
+
+	connect := func(origin string) (*Client, int) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		id, _ := resp["client_id"].(string)
+		client, _ := h.Client(id)
+		return client, rec.Code
+	}
+
+	client, _ := connect("https://app.example.com")
+	h.SetAllowedOrigins([]string{"https://new.example.com"})
+	if _, status := connect("https://app.example.com"); status != http.StatusForbidden {
+		t.Errorf("Expected the removed origin to be rejected, got %d", status)
+	}
+	if _, status := connect("https://new.example.com"); status != http.StatusOK {
+		t.Errorf("Expected the added origin to be allowed, got %d", status)
+	}
+	if _, ok := h.Client(client.ID()); !ok {
+		t.Error("Expected connected clients to stay connected")
+	}
+
+	// Unlimited clients become limited, and the limit can be lifted again
+	h.SetRateLimits(1, 1, 0, 0)
+	client.Receive([]byte(`{}`))
+	if err := client.Receive([]byte(`{}`)); err != ErrRateLimited {
+		t.Errorf("Expected ErrRateLimited under the new limit, got %v", err)
+	}
+	h.SetRateLimits(0, 0, 0, 0)
+	if err := client.Receive([]byte(`{}`)); err != nil {
+		t.Errorf("Expected the lifted limit to accept messages, got %v", err)
+	}
+}
+
+// uuidPattern matches the random (version 4) UUIDs generated as client IDs
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestCertificateClientIDs(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithCertificateClientIDs(),
+	).(*Handler)
+
+	connect := func(state *tls.ConnectionState) string {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.TLS = state
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp["client_id"].(string)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "media-server-1"}}
+	if id := connect(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}); id != "media-server-1" {
+		t.Errorf("Expected the certificate common name as client ID, got %s", id)
+	}
+
+	// Connections without a verified certificate get a random UUID
+	if id := connect(&tls.ConnectionState{}); !uuidPattern.MatchString(id) {
+		t.Errorf("Expected a generated client ID, got %s", id)
+	}
+}
+
+func TestIdentityConflicts(t *testing.T) {
+	identify := func(r *http.Request) (string, bool) {
+		user := r.Header.Get("X-User")
+		return user, user != ""
+	}
+	connect := func(h *Handler, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		return rec
+	}
+	clientID := func(rec *httptest.ResponseRecorder) string {
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		id, _ := resp["client_id"].(string)
+		return id
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), nil, nil, WithIdentity(identify, ws.IdentityReplace)).(*Handler)
+		connect(h, "alice")
+		first, _ := h.Client("alice")
+		if id := clientID(connect(h, "alice")); id != "alice" {
+			t.Fatalf("Expected the identity as client ID, got %q", id)
+		}
+		if _, open := first.Drain(); open {
+			t.Error("Expected the previous connection to be closed")
+		}
+		if id := clientID(connect(h, "")); !uuidPattern.MatchString(id) {
+			t.Errorf("Expected unidentified clients to get a random ID, got %q", id)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		h := NewHandler(config.WebSocketConfig{Path: "/ws", MaxConnectionsPerIP: 2}, testsupport.NewLogger(), nil, nil, WithIdentity(identify, ws.IdentityReject)).(*Handler)
+		connect(h, "alice")
+		if rec := connect(h, "alice"); rec.Code != http.StatusConflict {
+			t.Errorf("Expected the second connection to be rejected, got %d %s", rec.Code, rec.Body.String())
+		}
+
+		// The rejected connection does not count against the connection cap
+		if id := clientID(connect(h, "bob")); id != "bob" {
+			t.Errorf("Expected bob to connect within the cap, got %q", id)
+		}
+	})
+
+	t.Run("multiDevice", func(t *testing.T) {
+		h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), nil, nil, WithIdentity(identify, ws.IdentityMultiDevice)).(*Handler)
+		var ids []string
+		for i := 0; i < 3; i++ {
+			ids = append(ids, clientID(connect(h, "alice")))
+		}
+		if !reflect.DeepEqual(ids, []string{"alice", "alice#2", "alice#3"}) {
+			t.Fatalf("Expected a client ID per device, got %v", ids)
+		}
+
+		client, _ := h.Client("alice#2")
+		client.Disconnect()
+		if id := clientID(connect(h, "alice")); id != "alice#2" {
+			t.Errorf("Expected the lowest free device number, got %q", id)
+		}
+	})
+}
+
+func TestDrain(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}).(*Handler)
+
+	connect := func() *Client {
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		return client
+	}
+	polite, stuck := connect(), connect()
+
+	// The write pump of the polite client writes the close frame, and the
+	// client disconnects once the connection closes; the stuck client's
+	// connection is never written
+	conn := newRecordingConn()
+	go func() {
+		polite.writePump(conn)
+		polite.Disconnect()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result := h.Drain(ctx, ws.ShutdownReason)
+
+	if result != (ws.DrainResult{Closed: 1, Forced: 1}) {
+		t.Errorf("Expected 1 closed and 1 forced connection, got %+v", result)
+	}
+	conn.mutex.Lock()
+	last := conn.frames[len(conn.frames)-1]
+	conn.mutex.Unlock()
+	if last.messageType != CloseMessage || last.data != string(closePayload(ws.CloseGoingAway, ws.ShutdownReason)) {
+		t.Errorf("Expected a going away close frame written to the connection, got %+v", last)
+	}
+	if _, open := stuck.Drain(); open {
+		t.Error("Expected the stuck connection to be closed")
+	}
+	if len(h.ClientIDs()) != 0 {
+		t.Errorf("Expected no clients left, got %v", h.ClientIDs())
+	}
+}
+
+// fakeAuthorizer returns a fixed decision per client ID
+type fakeAuthorizer struct {
+	decisions map[string]ws.Decision
+	claims    map[string]ws.Claims
+}
+
+func (a *fakeAuthorizer) Reauthorize(ctx context.Context, clientID string, claims ws.Claims, rooms []string) (ws.Decision, error) {
+	a.claims[clientID] = claims
+	decision, ok := a.decisions[clientID]
+	if !ok {
+		return ws.Decision{}, errors.New("authorization service unavailable")
+	}
+	return decision, nil
+}
+
+func TestReauthorization(t *testing.T) {
+	authorizer := &fakeAuthorizer{decisions: make(map[string]ws.Decision), claims: make(map[string]ws.Claims)}
+	revokedRooms := make(map[string][]string)
+	observedRooms := make(map[string][]string)
+	var disconnected []string
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", ReauthorizeTimeout: 1}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithReauthorization(authorizer,
+			func(clientID string) []string { return []string{"room-a", "room-b"} },
+			func(clientID string, rooms []string, reason string) { revokedRooms[clientID] = rooms },
+		),
+		WithObserverRestriction(func(clientID string, rooms []string) { observedRooms[clientID] = rooms }),
+		WithDisconnectHandler(func(clientID string) {
+			disconnected = append(disconnected, clientID)
+		}),
+	).(*Handler)
+
+	connect := func(token string) string {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp["client_id"].(string)
+	}
+	banned, restricted, unchecked := connect("banned"), connect("restricted"), connect("unchecked")
+	authorizer.decisions[banned] = ws.Decision{Revoke: true, Reason: "user banned"}
+	authorizer.decisions[restricted] = ws.Decision{LeaveRooms: []string{"room-b"}, ObserveRooms: []string{"room-a"}}
+
+	bannedClient, _ := h.Client(banned)
+	if revoked := h.reauthorize(); !reflect.DeepEqual(revoked, []string{banned}) {
+		t.Fatalf("Expected only %s to be revoked, got %v", banned, revoked)
+	}
+	if code, reason := bannedClient.CloseFrame(); code != ws.ClosePolicyViolation || reason != "user banned" {
+		t.Errorf("Expected a policy violation close with the reason, got %d %q", code, reason)
+	}
+	if !reflect.DeepEqual(disconnected, []string{banned}) {
+		t.Errorf("Expected the revoked client to be removed from its rooms, got %v", disconnected)
+	}
+	if !reflect.DeepEqual(revokedRooms, map[string][]string{restricted: {"room-b"}}) {
+		t.Errorf("Expected %s to be removed from room-b only, got %v", restricted, revokedRooms)
+	}
+	if !reflect.DeepEqual(observedRooms, map[string][]string{restricted: {"room-a"}}) {
+		t.Errorf("Expected %s to observe room-a only, got %v", restricted, observedRooms)
+	}
+	if authorizer.claims[restricted].Token != "restricted" {
+		t.Errorf("Expected the authorizer to be given the token presented on connect, got %+v", authorizer.claims[restricted])
+	}
+
+	// A failed check keeps the client connected
+	if _, ok := h.Client(unchecked); !ok {
+		t.Error("Expected the client whose check failed to stay connected")
+	}
+	if ids := h.ClientIDs(); len(ids) != 2 {
+		t.Errorf("Expected 2 clients left, got %v", ids)
+	}
+}
+
+func TestBinaryFraming(t *testing.T) {
+	var received []byte
+	h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithCodecs(protocol.ProtobufCodec{}),
+		WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			received = message
+			return nil
+		}),
+	).(*Handler)
+
+	connect := func(subprotocols string) (*Client, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		if subprotocols != "" {
+			req.Header.Set("Sec-WebSocket-Protocol", subprotocols)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		skipWelcome(t, client)
+		return client, rec
+	}
+
+	binary, rec := connect("signaling.v2+cbor, signaling.v1+protobuf")
+	if got := rec.Header().Get("Sec-WebSocket-Protocol"); got != protocol.ProtobufSubprotocol {
+		t.Errorf("Expected the protobuf subprotocol to be selected, got %q", got)
+	}
+	text, rec := connect("")
+	if got := rec.Header().Get("Sec-WebSocket-Protocol"); got != "" {
+		t.Errorf("Expected no subprotocol for a JSON client, got %q", got)
+	}
+
+	// Binary frames reach the message handler as JSON
+	frame, _ := protocol.ProtobufCodec{}.Encode(protocol.Message{Type: protocol.Join, Room: "call"})
+	if err := binary.Receive(frame); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(received) != `{"type":"join","room":"call","sender":""}` {
+		t.Errorf("Expected the frame to be transcoded to JSON, got %s", received)
+	}
+	if err := binary.Receive([]byte(`{"type":"join"}`)); err == nil {
+		t.Error("Expected a JSON frame from a protobuf client to be rejected")
+	}
+
+	// Messages are written to each client in its encoding
+	message := []byte(`{"type":"offer","room":"call","sender":"alice","payload":{"sdp":"v=0"}}`)
+	h.SendMessage(binary.ID(), message)
+	h.SendMessage(text.ID(), message)
+
+	frames, _ := binary.Drain()
+	if len(frames) != 1 {
+		t.Fatalf("Expected 1 frame, got %d", len(frames))
+	}
+	msg, err := protocol.ProtobufCodec{}.Decode(frames[0])
+	if err != nil || msg.Type != protocol.Offer || msg.Sender != "alice" || string(msg.Payload) != `{"sdp":"v=0"}` {
+		t.Errorf("Expected a protobuf offer, got %+v, %v", msg, err)
+	}
+	if frames, _ := text.Drain(); len(frames) != 1 || string(frames[0]) != string(message) {
+		t.Errorf("Expected the JSON message unchanged, got %q", frames)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{
+		Path:                    "/ws",
+		EnableCompression:       true,
+		CompressionLevel:        1,
+		CompressionThreshold:    64,
+		ServerNoContextTakeover: true,
+		ClientNoContextTakeover: true,
+	}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}).(*Handler)
+
+	connect := func(extensions string) (*Client, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		if extensions != "" {
+			req.Header.Set("Sec-WebSocket-Extensions", extensions)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		skipWelcome(t, client)
+		return client, rec
+	}
+
+	deflate, rec := connect("permessage-deflate; client_max_window_bits")
+	if got := rec.Header().Get("Sec-WebSocket-Extensions"); got != "permessage-deflate; server_no_context_takeover; client_no_context_takeover" {
+		t.Errorf("Expected permessage-deflate to be negotiated, got %q", got)
+	}
+	plain, rec := connect("")
+	if got := rec.Header().Get("Sec-WebSocket-Extensions"); got != "" {
+		t.Errorf("Expected no extension for a client not offering it, got %q", got)
+	}
+
+	// Only messages over the threshold are compressed
+	candidate := []byte(`{"type":"ice-candidate","payload":{}}`)
+	offer := []byte(`{"type":"offer","payload":{"type":"offer","sdp":"` + strings.Repeat("a=candidate ", 10) + `"}}`)
+	for _, client := range []*Client{deflate, plain} {
+		h.SendMessage(client.ID(), candidate)
+		h.SendMessage(client.ID(), offer)
+		if messages, _ := client.Drain(); len(messages) != 2 {
+			t.Fatalf("Expected both messages to be written, got %d", len(messages))
+		}
+	}
+	if got := deflate.Compressed(); got != 1 {
+		t.Errorf("Expected only the offer to be compressed, got %d frames", got)
+	}
+	if got := plain.Compressed(); got != 0 {
+		t.Errorf("Expected no compression without the extension, got %d frames", got)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var disconnected []string
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", MaxConnectionsPerIP: 1}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return now }),
+		WithSessions(ws.NewSessions(time.Minute, 16)),
+		WithDisconnectHandler(func(clientID string) {
+			disconnected = append(disconnected, clientID)
+		}),
+	).(*Handler)
+	h.wsConfig.PongWait = time.Minute
+
+	req := httptest.NewRequest("POST", "/tuesdays.signaling.v1.Signaling/Signal", nil)
+	conn, err := h.Attach(req)
+	if err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	if _, err := h.Attach(req); !errors.Is(err, ws.ErrTooManyConnections) {
+		t.Errorf("Expected the connection cap to apply to attached clients, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if message, ok := conn.Next(ctx); !ok || !strings.Contains(string(message), `"clientId":"`+conn.ID()+`"`) {
+		t.Errorf("Expected the attached client to be welcomed with its ID, got %s", message)
+	}
+
+	h.SendMessage(conn.ID(), []byte(`{"type":"system-notice"}`))
+	if message, ok := conn.Next(ctx); !ok || string(message) != `{"type":"system-notice"}` {
+		t.Errorf("Expected the message to be written to the attached client, got %s", message)
+	}
+
+	// Attached clients are kept alive by their own transport
+	now = now.Add(2 * time.Minute)
+	if reaped := h.keepalive(); len(reaped) != 0 {
+		t.Errorf("Expected attached clients not to be reaped, got %v", reaped)
+	}
+
+	// Attached clients have no session to resume
+	conn.Disconnect()
+	if !reflect.DeepEqual(disconnected, []string{conn.ID()}) {
+		t.Errorf("Expected the attached client to be removed from its rooms, got %v", disconnected)
+	}
+	if _, ok := conn.Next(ctx); ok {
+		t.Error("Expected no more messages once disconnected")
+	}
+}
+
+func TestStats(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{}).(*Handler)
+
+	connect := func() *Client {
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		skipWelcome(t, client)
+		return client
+	}
+	slow := connect()
+	connect()
+
+	for i := 0; i < 3; i++ {
+		h.SendMessage(slow.ID(), []byte(`{"type":"system-notice"}`))
+	}
+	if stats := h.Stats(); stats != (ws.HubStats{Connections: 2, QueuedMessages: 3, MaxQueueDepth: 3, QueueDepthP90: 3, QueueDepthP99: 3, QueuedBytes: 72}) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// A message to a full send buffer is dropped with the client
+	for i := 0; i < cap(slow.send); i++ {
+		h.SendMessage(slow.ID(), []byte(`{"type":"system-notice"}`))
+	}
+	if stats := h.Stats(); stats.Connections != 1 || stats.DroppedMessages != 1 || stats.QueuedMessages != 0 || stats.QueuedBytes != 0 {
+		t.Errorf("Expected the slow client's message to be dropped, got %+v", stats)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	notice := []byte(`{"type":"system-notice"}`) // 24 bytes
+	connect := func(t *testing.T, h *Handler) *Client {
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		skipWelcome(t, client)
+		return client
+	}
+
+	t.Run("disconnect", func(t *testing.T) {
+		h := NewHandler(config.WebSocketConfig{Path: "/ws", SendBufferSize: 4, SendMemoryBudget: 96}, testsupport.NewLogger(), nil, nil).(*Handler)
+		slow, fast := connect(t, h), connect(t, h)
+		if cap(slow.send) != 4 {
+			t.Fatalf("Expected a send buffer of 4 messages, got %d", cap(slow.send))
+		}
+
+		// Beyond the budget, the client with the most bytes queued is closed
+		// and the message queued in the memory it freed
+		for i := 0; i < 3; i++ {
+			h.SendMessage(slow.ID(), notice)
+		}
+		h.SendMessage(fast.ID(), notice)
+		h.SendMessage(fast.ID(), notice)
+		if _, ok := h.Client(slow.ID()); ok {
+			t.Error("Expected the slowest client to be disconnected")
+		}
+		if stats := h.Stats(); stats.Connections != 1 || stats.QueuedBytes != 48 || stats.DroppedMessages != 0 {
+			t.Errorf("Expected the fast client's messages to be queued, got %+v", stats)
+		}
+
+		// Written messages no longer count against the budget
+		if messages, _ := fast.Drain(); len(messages) != 2 || h.Stats().QueuedBytes != 0 {
+			t.Errorf("Expected the drained messages to be released, got %d and %+v", len(messages), h.Stats())
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		h := NewHandler(config.WebSocketConfig{Path: "/ws", SendBufferSize: 4, SendMemoryBudget: 48, BackpressurePolicy: ws.BackpressureDrop}, testsupport.NewLogger(), nil, nil).(*Handler)
+		client := connect(t, h)
+
+		// Messages beyond the budget or the buffer are dropped, the client is kept
+		for i := 0; i < 3; i++ {
+			h.SendMessage(client.ID(), notice)
+		}
+		if stats := h.Stats(); stats.Connections != 1 || stats.QueuedMessages != 2 || stats.DroppedMessages != 1 {
+			t.Errorf("Expected the message beyond the budget to be dropped, got %+v", stats)
+		}
+
+		h.wsConfig.SendMemoryBudget = 0
+		for i := 0; i < 3; i++ {
+			h.SendMessage(client.ID(), notice)
+		}
+		if stats := h.Stats(); stats.Connections != 1 || stats.QueuedMessages != 4 || stats.DroppedMessages != 2 {
+			t.Errorf("Expected the message beyond the buffer to be dropped, got %+v", stats)
+		}
+	})
+}
+
+func TestSendLatencySampling(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", SendLatencySampling: 2}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithClock(func() time.Time { return now }),
+	).(*Handler)
+
+	rec := httptest.NewRecorder()
+	h.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	client, _ := h.Client(resp["client_id"].(string))
+	skipWelcome(t, client)
+
+	for i := 0; i < 4; i++ {
+		h.SendMessage(client.ID(), []byte(`{"type":"system-notice"}`))
+	}
+	var sampled []bool
+	for len(client.send) > 0 {
+		out := <-client.send
+		sampled = append(sampled, !out.queued.IsZero())
+	}
+	if !reflect.DeepEqual(sampled, []bool{false, true, false, true}) {
+		t.Errorf("Expected one in two messages to be timed, got %v", sampled)
+	}
+	if depths := h.QueueDepths(); !reflect.DeepEqual(depths, []int{0}) {
+		t.Errorf("Expected the drained send buffer to be empty, got %v", depths)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	depths := []int{0, 0, 1, 1, 2, 3, 5, 8, 13, 40}
+	for p, expected := range map[int]int{50: 2, 90: 13, 99: 40, 100: 40} {
+		if got := percentile(depths, p); got != expected {
+			t.Errorf("Expected percentile %d to be %d, got %d", p, expected, got)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("Expected 0 without clients, got %d", got)
+	}
+}
+
+func TestEnvelopes(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	stamper := protocol.NewStamper("node-1", protocol.UnixMillisTimestamps, func() time.Time { return now })
+	var legacy string
+	h := NewHandler(config.WebSocketConfig{Path: "/ws"}, testsupport.NewLogger(), testsupport.NewMetrics(), &tracing.NoopTracer{},
+		WithCodecs(protocol.MessagePackCodec{}),
+		WithEnvelopes(stamper, func(clientID string) bool { return clientID != legacy }),
+	).(*Handler)
+
+	connect := func(subprotocol string) *Client {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		if subprotocol != "" {
+			req.Header.Set("Sec-WebSocket-Protocol", subprotocol)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleConnection(rec, req)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		client, _ := h.Client(resp["client_id"].(string))
+		skipWelcome(t, client)
+		return client
+	}
+	stamped, unstamped, binary := connect(""), connect(""), connect(protocol.MessagePackSubprotocol)
+	legacy = unstamped.ID()
+
+	message := []byte(`{"type":"peer-joined","room":"call","sender":""}`)
+	for _, client := range []*Client{stamped, unstamped, binary} {
+		h.SendMessage(client.ID(), message)
+		h.SendMessage(client.ID(), message)
+	}
+
+	frames, _ := stamped.Drain()
+	for i, frame := range frames {
+		expected := fmt.Sprintf(`{"type":"peer-joined","room":"call","sender":"","meta":{"ts":1714564800000,"seq":%d,"server":"node-1"}}`, i+1)
+		if string(frame) != expected {
+			t.Errorf("Expected message %d to be stamped as %s, got %s", i+1, expected, frame)
+		}
+	}
+	if frames, _ := unstamped.Drain(); len(frames) != 2 || string(frames[1]) != string(message) {
+		t.Errorf("Expected messages to clients without envelopes unchanged, got %q", frames)
+	}
+
+	frames, _ = binary.Drain()
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames, got %d", len(frames))
+	}
+	msg, err := protocol.MessagePackCodec{}.Decode(frames[1])
+	if err != nil || string(msg.Meta) != `{"seq":2,"server":"node-1","ts":1714564800000}` {
+		t.Errorf("Expected the envelope in the binary frame, got %+v, %v", msg, err)
+	}
+}
+
+// recordingConn is a FrameWriter recording the frames written and their deadlines
+type recordingConn struct {
+	mutex     sync.Mutex
+	frames    []recordedFrame
+	deadlines int
+	failAfter int // fail the writes after this many frames, 0 never
+	panics    bool
+	closed    chan struct{}
+}
+
+type recordedFrame struct {
+	messageType int
+	data        string
+}
+
+func newRecordingConn() *recordingConn {
+	return &recordingConn{closed: make(chan struct{})}
+}
+
+func (c *recordingConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deadlines++
+	return nil
+}
+
+func (c *recordingConn) WriteMessage(messageType int, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.failAfter > 0 && len(c.frames) >= c.failAfter {
+		return errors.New("write timed out")
+	}
+	if c.panics {
+		panic("index out of range")
+	}
+	c.frames = append(c.frames, recordedFrame{messageType: messageType, data: string(data)})
+	return nil
+}
+
+func (c *recordingConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestWritePump(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{WriteWait: 10, PongWait: 60, MaxMessageSize: 1024}, testsupport.NewLogger(), nil, nil).(*Handler)
+	h.HandleConnection(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws", nil))
+	client, _ := h.Client(h.ClientIDs()[0])
+	skipWelcome(t, client)
+
+	// Messages queued before the pump runs are written in one pass, under one deadline
+	written := 0
+	h.SendMessageNotify(client.ID(), []byte("offer"), func() { written++ })
+	h.SendMessage(client.ID(), []byte("candidate"))
+	h.keepalive()
+	h.CloseConnectionWithReason(client.ID(), ws.CloseGoingAway, "bye")
+
+	conn := newRecordingConn()
+	client.writePump(conn)
+	select {
+	case <-conn.closed:
+	default:
+		t.Fatal("Expected the pump to close the connection")
+	}
+
+	// The ping may be written before or after the batch, or not at all once the
+	// connection is closing; the close frame is last
+	var messages []string
+	pings := 0
+	for _, frame := range conn.frames {
+		switch frame.messageType {
+		case TextMessage:
+			messages = append(messages, frame.data)
+		case PingMessage:
+			pings++
+		}
+	}
+	if len(messages) != 2 || messages[0] != "offer" || messages[1] != "candidate" || written != 1 {
+		t.Errorf("Expected both messages in order with the write reported, got %q (%d)", messages, written)
+	}
+	last := conn.frames[len(conn.frames)-1]
+	if last.messageType != CloseMessage || last.data != string(closePayload(ws.CloseGoingAway, "bye")) {
+		t.Errorf("Expected the close frame last, got %+v", last)
+	}
+	if pings > 1 || conn.deadlines != 2+pings {
+		t.Errorf("Expected a ping and a deadline per pass, got %d deadlines for %+v", conn.deadlines, conn.frames)
+	}
+}
+
+func TestPanicIsolation(t *testing.T) {
+	var disconnected []string
+	h := NewHandler(config.WebSocketConfig{Path: "/ws", WriteWait: 10}, testsupport.NewLogger(), testsupport.NewMetrics(), nil,
+		WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			if string(message) == "boom" {
+				panic("nil map")
+			}
+			return nil
+		}),
+		WithDisconnectHandler(func(clientID string) {
+			disconnected = append(disconnected, clientID)
+		}),
+	).(*Handler)
+	h.HandleConnection(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws", nil))
+	h.HandleConnection(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws", nil))
+	ids := h.ClientIDs()
+	faulty, _ := h.Client(ids[0])
+	healthy, _ := h.Client(ids[1])
+
+	// A panic handling a message closes only the connection that sent it
+	var panicErr *ws.PanicError
+	if err := faulty.Receive([]byte("boom")); !errors.As(err, &panicErr) || len(panicErr.Stack) == 0 {
+		t.Fatalf("Expected the panic to be returned with its stack, got %v", err)
+	}
+	if code, _ := faulty.CloseFrame(); code != ws.CloseInternalError {
+		t.Errorf("Expected the connection to be closed with %d, got %d", ws.CloseInternalError, code)
+	}
+	if !reflect.DeepEqual(disconnected, []string{faulty.ID()}) {
+		t.Errorf("Expected the client to be removed from its rooms, got %v", disconnected)
+	}
+	if err := healthy.Receive([]byte("hello")); err != nil {
+		t.Errorf("Expected the other connection to keep working, got %v", err)
+	}
+
+	// A panic in the write pump unregisters its client and closes the connection
+	h.SendMessage(healthy.ID(), []byte("offer"))
+	conn := newRecordingConn()
+	conn.panics = true
+	healthy.writePump(conn)
+	select {
+	case <-conn.closed:
+	default:
+		t.Error("Expected the pump to close the connection")
+	}
+	if len(h.ClientIDs()) != 0 {
+		t.Errorf("Expected the client to be unregistered, got %v", h.ClientIDs())
+	}
+}
+
+func TestWritePumpFailure(t *testing.T) {
+	h := NewHandler(config.WebSocketConfig{WriteWait: 10, MaxMessageSize: 1024}, testsupport.NewLogger(), nil, nil).(*Handler)
+	h.HandleConnection(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws", nil))
+	client, _ := h.Client(h.ClientIDs()[0])
+
+	// A client whose connection cannot be written to is unregistered
+	h.SendMessage(client.ID(), []byte("offer"))
+	h.SendMessage(client.ID(), []byte("answer"))
+	conn := newRecordingConn()
+	conn.failAfter = 1
+	client.writePump(conn)
+
+	if len(conn.frames) != 1 {
+		t.Errorf("Expected one frame before the failure, got %+v", conn.frames)
+	}
+	if _, ok := h.Client(client.ID()); ok {
+		t.Error("Expected the client to be unregistered after the write failure")
+	}
+}
+
+// skipWelcome takes the welcome message off the send buffer of a new client,
+// so that tests see only the messages sent after it
+func skipWelcome(t *testing.T, client *Client) {
+	t.Helper()
+
+	select {
+	case out := <-client.send:
+		var msg protocol.Message
+		var welcome protocol.WelcomePayload
+		if json.Unmarshal(out.message, &msg) != nil || msg.Type != protocol.Welcome ||
+			json.Unmarshal(msg.Payload, &welcome) != nil || welcome.ClientID != client.ID() {
+			t.Fatalf("Expected a welcome message with the client ID first, got %s", out.message)
+		}
+		client.dequeued(len(out.message))
+	default:
+		t.Fatal("Expected a welcome message queued for the new client")
+	}
+}
@@ -0,0 +1,128 @@
+package gorilla
+
+import (
+	"encoding/binary"
+	"time"
+
+	ws "github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+)
+
+// Frame types of WebSocket messages, as numbered by gorilla/websocket
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+)
+
+// FrameWriter is the connection a write pump writes to, a *websocket.Conn
+// once upgraded. Connections support a single concurrent writer, so only the
+// write pump of its client calls it; everything else queues messages on the
+// client's send channel or signals the pump.
+type FrameWriter interface {
+	SetWriteDeadline(t time.Time) error
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// writePump writes the messages queued for the client, the keepalive pings
+// and the close frame to the connection until the send channel is closed or
+// a write fails, then closes the connection, which stops the read pump. Each
+// write must complete within the write wait. The messages queued behind the
+// one received are written in the same pass, under one deadline, rather than
+// waking the pump for each.
+func (c *Client) writePump(conn FrameWriter) {
+	defer conn.Close()
+
+	// A panic writing closes only this connection, as a failed write does
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := ws.NewPanicError(r)
+			c.logger.Error("Panic in write pump", "error", panicErr, "stack", string(panicErr.Stack))
+			if c.metrics != nil {
+				c.metrics.WebSocketError("panic")
+			}
+			c.handler.unregisterClient(c)
+		}
+	}()
+
+	for {
+		select {
+		case out, ok := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(c.handler.wsConfig.WriteWait))
+			if !ok {
+				c.writeClose(conn)
+				return
+			}
+
+			batch := []outbound{out}
+			closed := false
+			for queued := len(c.send); queued > 0 && !closed; queued-- {
+				next, ok := <-c.send
+				if !ok {
+					closed = true
+					break
+				}
+				batch = append(batch, next)
+			}
+
+			for _, out := range batch {
+				frame, ok := c.frame(out)
+				if !ok {
+					continue
+				}
+				if err := conn.WriteMessage(c.messageType(), frame); err != nil {
+					c.writeFailed(err)
+					return
+				}
+				c.sent(out)
+			}
+			if closed {
+				c.writeClose(conn)
+				return
+			}
+
+		case <-c.ping:
+			conn.SetWriteDeadline(time.Now().Add(c.handler.wsConfig.WriteWait))
+			if err := conn.WriteMessage(PingMessage, nil); err != nil {
+				c.writeFailed(err)
+				return
+			}
+		}
+	}
+}
+
+// messageType is the frame type of the client's messages
+func (c *Client) messageType() int {
+	if c.codec != nil {
+		return BinaryMessage
+	}
+	return TextMessage
+}
+
+// writeClose writes the close frame the server closed the connection with.
+// The connection is closed next either way, so a failure is not reported.
+func (c *Client) writeClose(conn FrameWriter) {
+	code, reason := c.CloseFrame()
+	conn.WriteMessage(CloseMessage, closePayload(code, reason))
+}
+
+// writeFailed unregisters a client whose connection could not be written
+// to, as a timed out or broken connection cannot take further frames
+func (c *Client) writeFailed(err error) {
+	c.logger.Warn("Failed to write to connection", "error", err)
+	if c.metrics != nil {
+		c.metrics.WebSocketError("write_failed")
+	}
+	c.handler.unregisterClient(c)
+}
+
+// closePayload encodes the payload of a close frame, empty without a code
+func closePayload(code int, reason string) []byte {
+	if code == 0 {
+		return nil
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return append(payload, reason...)
+}
@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// Identity sources, the credentials of an upgrade request an Identifier
+// derives the client ID from
+const (
+	// IdentityCertificate is the subject of the verified client certificate
+	IdentityCertificate = "certificate"
+
+	// IdentityToken is the sub claim of a bearer token signed with HS256
+	IdentityToken = "token"
+
+	// IdentityAPIKey is the owner of the API key in the X-API-Key header
+	IdentityAPIKey = "apiKey"
+)
+
+// APIKeyHeader is the request header carrying an API key
+const APIKeyHeader = "X-API-Key"
+
+// Identity conflict policies, applied when a client connects with the
+// identity of a connected client
+const (
+	// IdentityReplace closes the connection of the connected client, whose
+	// rooms the new connection takes over
+	IdentityReplace = "replace"
+
+	// IdentityReject rejects the new connection with ErrIdentityConnected
+	IdentityReject = "reject"
+
+	// IdentityMultiDevice keeps both connections, suffixing the client ID of
+	// the new one with the lowest free device number, e.g. "alice#2"
+	IdentityMultiDevice = "multiDevice"
+)
+
+// ErrIdentityConnected is returned when a client connects with the identity
+// of a connected client under the IdentityReject policy
+var ErrIdentityConnected = errs.New(errs.AlreadyExists, "identity already connected")
+
+// Identifier derives the client ID of a connection from the principal
+// authenticated on its upgrade request, trying its sources in order
+type Identifier struct {
+	sources     []string
+	tokenSecret []byte
+	apiKeys     map[string]string // owner by API key
+	now         func() time.Time
+}
+
+// NewIdentifier creates an Identifier trying the sources in order. Bearer
+// tokens must be signed with tokenSecret, and apiKeys are entries of the form
+// owner:key; malformed entries are skipped.
+func NewIdentifier(sources []string, tokenSecret string, apiKeys []string, now func() time.Time) *Identifier {
+	i := &Identifier{
+		sources:     sources,
+		tokenSecret: []byte(tokenSecret),
+		apiKeys:     make(map[string]string),
+		now:         now,
+	}
+	for _, entry := range apiKeys {
+		if owner, key, ok := strings.Cut(entry, ":"); ok && owner != "" && key != "" {
+			i.apiKeys[key] = owner
+		}
+	}
+	return i
+}
+
+// Identify returns the identity of the principal authenticated on an
+// upgrade request, reporting false if none of the sources authenticates it
+func (i *Identifier) Identify(r *http.Request) (string, bool) {
+	for _, source := range i.sources {
+		var identity string
+		var ok bool
+		switch source {
+		case IdentityCertificate:
+			identity, ok = CertificateClientID(r)
+		case IdentityToken:
+			claims, valid := i.tokenClaims(ClaimsFromRequest(r).Token)
+			identity, ok = claims.Subject, valid && claims.Subject != ""
+		case IdentityAPIKey:
+			identity, ok = i.apiKeyOwner(r.Header.Get(APIKeyHeader))
+		}
+		if ok {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// tokenClaims are the claims of a bearer token read by an Identifier
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	Tenant    string `json:"tenant"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+// tokenClaims returns the claims of a JWT signed with HS256 using the token
+// secret, reporting false if the token is malformed, forged, expired or not
+// yet valid
+func (i *Identifier) tokenClaims(token string) (tokenClaims, bool) {
+	if token == "" || len(i.tokenSecret) == 0 {
+		return tokenClaims{}, false
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return tokenClaims{}, false
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if !decodeSegment(parts[0], &header) || header.Algorithm != "HS256" {
+		return tokenClaims{}, false
+	}
+	mac := hmac.New(sha256.New, i.tokenSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return tokenClaims{}, false
+	}
+
+	var claims tokenClaims
+	if !decodeSegment(parts[1], &claims) {
+		return tokenClaims{}, false
+	}
+	now := i.now().Unix()
+	if (claims.ExpiresAt != 0 && now >= claims.ExpiresAt) || now < claims.NotBefore {
+		return tokenClaims{}, false
+	}
+	return claims, true
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) bool {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// apiKeyOwner returns the owner of an API key, comparing the key with each
+// configured key in constant time
+func (i *Identifier) apiKeyOwner(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	owner, found := "", false
+	for candidate, candidateOwner := range i.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate)) == 1 {
+			owner, found = candidateOwner, true
+		}
+	}
+	return owner, found
+}
+
+// Tenant returns the tenant claim of the bearer token of an upgrade request,
+// reporting false if the token is invalid or has no tenant claim
+func (i *Identifier) Tenant(r *http.Request) (string, bool) {
+	claims, ok := i.tokenClaims(ClaimsFromRequest(r).Token)
+	if !ok || claims.Tenant == "" {
+		return "", false
+	}
+	return claims.Tenant, true
+}
@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signToken builds a JWT with the header and claims, signed with HS256
+func signToken(secret, header, claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	unsigned := encode([]byte(header)) + "." + encode([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + encode(mac.Sum(nil))
+}
+
+func TestIdentifier(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	identifier := NewIdentifier([]string{IdentityCertificate, IdentityToken, IdentityAPIKey}, "s3cret", []string{"media-bot:k-123", "malformed"}, func() time.Time { return now })
+
+	identify := func(token, apiKey string, cert bool) (string, bool) {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if apiKey != "" {
+			req.Header.Set(APIKeyHeader, apiKey)
+		}
+		if cert {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "sfu-1"}}}}}
+		}
+		return identifier.Identify(req)
+	}
+
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	tests := []struct {
+		name     string
+		token    string
+		apiKey   string
+		cert     bool
+		identity string
+	}{
+		{"token subject", signToken("s3cret", hs256, `{"sub":"alice","exp":1700000060}`), "", false, "alice"},
+		{"certificate first", signToken("s3cret", hs256, `{"sub":"alice"}`), "", true, "sfu-1"},
+		{"api key owner", "", "k-123", false, "media-bot"},
+		{"forged token", signToken("guess", hs256, `{"sub":"alice"}`), "", false, ""},
+		{"unsigned token", signToken("s3cret", `{"alg":"none"}`, `{"sub":"alice"}`), "", false, ""},
+		{"expired token", signToken("s3cret", hs256, `{"sub":"alice","exp":1700000000}`), "", false, ""},
+		{"token not yet valid", signToken("s3cret", hs256, `{"sub":"alice","nbf":1700000060}`), "", false, ""},
+		{"token without subject", signToken("s3cret", hs256, `{"exp":1700000060}`), "", false, ""},
+		{"unknown api key", "", "malformed", false, ""},
+		{"forged token falls back to api key", signToken("guess", hs256, `{"sub":"alice"}`), "k-123", false, "media-bot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, ok := identify(tt.token, tt.apiKey, tt.cert)
+			if identity != tt.identity || ok != (tt.identity != "") {
+				t.Errorf("Expected identity %q, got %q (%v)", tt.identity, identity, ok)
+			}
+		})
+	}
+}
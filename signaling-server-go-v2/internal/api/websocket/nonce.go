@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// ErrTokenReplayed is returned when a one-time token is presented again
+// after its first use or its invalidation
+var ErrTokenReplayed = errs.New(errs.Unauthenticated, "token already used")
+
+// NonceCache remembers the nonces of one-time tokens, such as the jti of a
+// signed token or a consumed session token, until they expire, so that a
+// captured token cannot be replayed. Implementations backed by a shared store
+// reject replays across all server instances.
+type NonceCache interface {
+	// Use records the first use of a nonce valid until expiry, reporting
+	// false if it was used or invalidated before
+	Use(ctx context.Context, nonce string, expiry time.Time) (bool, error)
+
+	// Invalidate rejects any use of a nonce valid until expiry
+	Invalidate(ctx context.Context, nonce string, expiry time.Time) error
+}
+
+// NonceKey derives the cache key of a bearer token, so that the cache never
+// holds usable tokens. The kind keeps the nonces of different token types apart.
+func NonceKey(kind, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return kind + ":" + hex.EncodeToString(sum[:])
+}
+
+// MemoryNonceCache is a NonceCache in memory, so each server instance
+// rejects the replays of the tokens it has seen
+type MemoryNonceCache struct {
+	now    func() time.Time
+	nonces map[string]time.Time
+	checks int
+	mutex  sync.Mutex
+}
+
+// NewMemoryNonceCache creates an empty MemoryNonceCache
+func NewMemoryNonceCache(now func() time.Time) *MemoryNonceCache {
+	return &MemoryNonceCache{
+		now:    now,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Use implements NonceCache.Use
+func (c *MemoryNonceCache) Use(ctx context.Context, nonce string, expiry time.Time) (bool, error) {
+	now := c.now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.checks++
+	if c.checks%pruneInterval == 0 {
+		c.prune(now)
+	}
+
+	if until, ok := c.nonces[nonce]; ok && now.Before(until) {
+		return false, nil
+	}
+	c.nonces[nonce] = expiry
+	return true, nil
+}
+
+// Invalidate implements NonceCache.Invalidate
+func (c *MemoryNonceCache) Invalidate(ctx context.Context, nonce string, expiry time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if until, ok := c.nonces[nonce]; !ok || until.Before(expiry) {
+		c.nonces[nonce] = expiry
+	}
+	return nil
+}
+
+// prune removes the expired nonces, whose tokens are rejected as expired
+// anyway. Must be called with the mutex held.
+func (c *MemoryNonceCache) prune(now time.Time) {
+	for nonce, until := range c.nonces {
+		if !now.Before(until) {
+			delete(c.nonces, nonce)
+		}
+	}
+}
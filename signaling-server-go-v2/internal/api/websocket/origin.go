@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CheckOrigin returns an origin check for WebSocket upgrades, with the
+// signature of the gorilla upgrader's CheckOrigin. Each allowed origin is
+// either "*", an exact origin such as "https://app.example.com", or a
+// wildcard subdomain such as "https://*.example.com"; a pattern without a
+// scheme matches any scheme. Requests without an Origin header come from
+// non-browser clients and are allowed. With no allowed origins, only
+// same-origin requests are allowed, as with the upgrader's default.
+func CheckOrigin(allowed []string) func(r *http.Request) bool {
+	patterns := make([]string, 0, len(allowed))
+	for _, origin := range allowed {
+		if origin = strings.ToLower(strings.TrimSpace(origin)); origin != "" {
+			patterns = append(patterns, strings.TrimSuffix(origin, "/"))
+		}
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+
+		if len(patterns) == 0 {
+			return strings.EqualFold(host, r.Host)
+		}
+		for _, pattern := range patterns {
+			if matchOrigin(pattern, scheme, host) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matchOrigin reports whether an origin's scheme and host match an allowed origin pattern
+func matchOrigin(pattern, scheme, host string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		if pattern[:i] != scheme {
+			return false
+		}
+		pattern = pattern[i+3:]
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
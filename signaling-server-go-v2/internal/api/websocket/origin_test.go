@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	cases := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin header", []string{"https://app.example.com"}, "", true},
+		{"exact match", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"exact match is case-insensitive", []string{"https://App.Example.com/"}, "https://app.example.com", true},
+		{"scheme mismatch", []string{"https://app.example.com"}, "http://app.example.com", false},
+		{"other host", []string{"https://app.example.com"}, "https://evil.example.net", false},
+		{"wildcard subdomain", []string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{"wildcard excludes apex", []string{"https://*.example.com"}, "https://example.com", false},
+		{"wildcard suffix lookalike", []string{"*.example.com"}, "https://evilexample.com", false},
+		{"wildcard without scheme", []string{"*.example.com"}, "http://app.example.com", true},
+		{"any origin", []string{"*"}, "https://anything.test", true},
+		{"same origin by default", nil, "http://signal.example.com", true},
+		{"cross origin by default", nil, "https://app.example.com", false},
+		{"malformed origin", []string{"*.example.com"}, "null", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://signal.example.com/ws", nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			if got := CheckOrigin(tc.allowed)(req); got != tc.want {
+				t.Errorf("Expected %v for origin %q with %v, got %v", tc.want, tc.origin, tc.allowed, got)
+			}
+		})
+	}
+}
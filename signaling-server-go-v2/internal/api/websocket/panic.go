@@ -0,0 +1,32 @@
+package websocket
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// CloseInternalError is the close code of connections closed because the
+// server failed handling them (RFC 6455 section 7.4.1)
+const CloseInternalError = 1011
+
+// PanicError is a panic recovered in the goroutine of a connection or in
+// the execution of one of its messages. The connection is closed, while the
+// other connections and the hub keep running.
+type PanicError struct {
+	// Value is the value passed to panic
+	Value interface{}
+
+	// Stack is the stack of the goroutine at the panic
+	Stack []byte
+}
+
+// NewPanicError returns the error of a recovered panic. It must be called
+// in the deferred function that recovered it, so that the stack is the
+// panicking goroutine's.
+func NewPanicError(value interface{}) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
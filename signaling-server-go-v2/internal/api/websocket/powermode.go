@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PowerModeHeader is the upgrade request header in which clients declare
+// their power mode. Browsers cannot set headers on WebSocket upgrades, so the
+// power mode query parameter is accepted as well.
+const PowerModeHeader = "X-Signaling-Power-Mode"
+
+// PowerModeParam is the query parameter in which clients declare their power mode
+const PowerModeParam = "powerMode"
+
+// PowerModeLow is the power mode of battery-sensitive clients, such as
+// mobile apps idling in a lobby
+const PowerModeLow = "low"
+
+// LowPower configures the relaxed keepalive of clients declaring the low
+// power mode: they are pinged every PingInterval rather than the handler's
+// ping interval, and reaped after PongWait without a pong or a message, so
+// that dead connections are still detected, only later.
+type LowPower struct {
+	PingInterval time.Duration
+	PongWait     time.Duration
+}
+
+// Enabled reports whether the low power mode may be negotiated
+func (l LowPower) Enabled() bool {
+	return l.PingInterval > 0 && l.PongWait > 0
+}
+
+// Negotiate reports whether the client of an upgrade request declared the
+// low power mode, in the power mode header or query parameter, and the
+// server accepts it. Accepted clients are told so by echoing the header.
+func (l LowPower) Negotiate(r *http.Request) bool {
+	if !l.Enabled() {
+		return false
+	}
+
+	mode := r.Header.Get(PowerModeHeader)
+	if mode == "" {
+		mode = r.URL.Query().Get(PowerModeParam)
+	}
+	return strings.EqualFold(strings.TrimSpace(mode), PowerModeLow)
+}
@@ -0,0 +1,79 @@
+package protocol
+
+import "time"
+
+// ActivityType is the type of an activity event
+type ActivityType string
+
+const (
+	// ActivityJoin is emitted when a client joins a room
+	ActivityJoin ActivityType = "join"
+
+	// ActivityLeave is emitted when a client leaves a room, for the reason in the event
+	ActivityLeave ActivityType = "leave"
+
+	// ActivityRelay is emitted when a message is relayed between peers
+	ActivityRelay ActivityType = "relay"
+
+	// ActivityKick and ActivityBan are emitted when a moderator, the Actor of
+	// the event, kicks or bans a peer, for the reason the moderator gave. A
+	// peer removed from the room also leaves it with the reason "kick" or "ban".
+	ActivityKick ActivityType = "kick"
+	ActivityBan  ActivityType = "ban"
+
+	// ActivityRoomCreated is emitted when a room is created, by the join of the
+	// Client of the event or ahead of it through the admin API, and
+	// ActivityRoomClosed when a room closes, after its peers left, for the
+	// reason in the event, e.g. "empty" or "expired"
+	ActivityRoomCreated ActivityType = "room_created"
+	ActivityRoomClosed  ActivityType = "room_closed"
+)
+
+// Leave reasons reported in activity events
+const (
+	LeaveReasonLeft         = "left"
+	LeaveReasonDisconnected = "disconnected"
+	LeaveReasonClosed       = "closed"
+	LeaveReasonExpired      = "expired"
+	LeaveReasonRevoked      = "revoked"
+	LeaveReasonTimeLimit    = "time_limit"
+)
+
+// ActivityEvent describes signaling activity for analytics. It never carries
+// message payloads such as SDP, only their size.
+type ActivityEvent struct {
+	Type        ActivityType `json:"type"`
+	Time        time.Time    `json:"time"`
+	Room        string       `json:"room"`
+	Client      string       `json:"client"`
+	Recipient   string       `json:"recipient,omitempty"`
+	MessageType MessageType  `json:"messageType,omitempty"`
+	PayloadSize int          `json:"payloadSize,omitempty"`
+	Reason      string       `json:"reason,omitempty"`
+	Actor       string       `json:"actor,omitempty"`
+}
+
+// ActivitySink receives the activity events of a SignalingManager. Emit is
+// called while the manager holds its locks, so it must not block.
+type ActivitySink interface {
+	Emit(event ActivityEvent)
+}
+
+// WithActivitySink adds a sink receiving the activity events, e.g. both an
+// analytics exporter and an audit log
+func WithActivitySink(sink ActivitySink) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.activity = append(sm.activity, sink)
+	}
+}
+
+// emit sends an activity event to the sinks, if any
+func (sm *SignalingManager) emit(event ActivityEvent) {
+	if len(sm.activity) == 0 {
+		return
+	}
+	event.Time = sm.now()
+	for _, sink := range sm.activity {
+		sink.Emit(event)
+	}
+}
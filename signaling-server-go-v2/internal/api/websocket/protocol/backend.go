@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RoomBackend shares room membership and relays with other server instances,
+// so that peers of a room may be connected to different instances behind a
+// load balancer. The manager calls it while holding its locks, so
+// implementations must not call back into the SignalingManager.
+type RoomBackend interface {
+	// Join announces that a client connected to this instance joined a room
+	Join(roomID, clientID string) error
+
+	// Leave announces that a client connected to this instance left a room
+	Leave(roomID, clientID string) error
+
+	// Relay forwards a message to a recipient connected to another instance
+	Relay(roomID, recipient string, message []byte) error
+}
+
+// PeerLister is implemented by RoomBackends that know the peers of a room
+// connected to other instances, so that broadcasts reach them
+type PeerLister interface {
+	// Peers returns the peers of a room, local and remote
+	Peers(roomID string) []string
+}
+
+// WithRoomBackend sets the backend used to reach peers connected to other instances
+func WithRoomBackend(b RoomBackend) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.backend = b
+	}
+}
+
+// WithRelayIDs sets an idempotency key on each relayed message, for backends
+// relaying at least once: a message retried after a lost acknowledgement
+// reaches its recipient twice with the same ID
+func WithRelayIDs() ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.relayIDs = true
+	}
+}
+
+// newRelayID returns a random idempotency key
+func newRelayID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// announceJoin announces a join to the room backend and the activity sink, if any
+func (sm *SignalingManager) announceJoin(roomID, clientID string) {
+	sm.emit(ActivityEvent{Type: ActivityJoin, Room: roomID, Client: clientID})
+
+	if sm.backend == nil {
+		return
+	}
+	if err := sm.backend.Join(roomID, clientID); err != nil {
+		sm.logger.Warn("Failed to announce join", "error", err, "client_id", clientID, "room_id", roomID)
+	}
+}
+
+// announceLeave announces that the peers left a room for the reason to the
+// room backend and the activity sink, if any, and forgets their pseudonyms
+// and quality scores
+func (sm *SignalingManager) announceLeave(roomID, reason string, peers ...string) {
+	for _, peer := range peers {
+		sm.forgetPeerID(roomID, peer)
+		sm.forgetQuality(roomID, peer)
+		sm.emit(ActivityEvent{Type: ActivityLeave, Room: roomID, Client: peer, Reason: reason})
+
+		if sm.backend == nil {
+			continue
+		}
+		if err := sm.backend.Leave(roomID, peer); err != nil {
+			sm.logger.Warn("Failed to announce leave", "error", err, "client_id", peer, "room_id", roomID)
+		}
+	}
+}
+
+// isLocalPeer reports whether a client connected to this instance is a peer of the room
+func (sm *SignalingManager) isLocalPeer(roomID, clientID string) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		spilled, ok := sm.spilled[roomID]
+		return ok && spilled.hasPeer(clientID)
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	_, ok = room.Peers[clientID]
+	return ok
+}
@@ -0,0 +1,298 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// BulkResult describes the rooms and clients affected by a bulk operation
+type BulkResult struct {
+	Rooms   []string `json:"rooms"`
+	Clients []string `json:"clients"`
+}
+
+// CloseRooms removes all rooms matching a wildcard pattern and notifies their
+// peers with a room-closed message. With dryRun set, only the affected rooms
+// and clients are reported. The rooms can be restored with RestoreRoom
+// within the closed room retention.
+func (sm *SignalingManager) CloseRooms(pattern, reason string, dryRun bool) BulkResult {
+	return sm.closeRooms(func(roomID string) bool {
+		return MatchRoomPattern(pattern, roomID)
+	}, "closed", LeaveReasonClosed, reason, dryRun)
+}
+
+// closeRooms removes the matching rooms and notifies their peers with a
+// room-closed message with the reason. The rooms are recorded as closed for
+// closedReason in the metrics and their peers as left for leaveReason. The
+// state of rooms closed by an operator is retained for RestoreRoom.
+func (sm *SignalingManager) closeRooms(match func(roomID string) bool, closedReason, leaveReason, reason string, dryRun bool) BulkResult {
+	closed := make(map[string][]string)
+	retain := leaveReason == LeaveReasonClosed
+
+	sm.mutex.Lock()
+	for roomID, room := range sm.rooms {
+		if !match(roomID) {
+			continue
+		}
+
+		room.mutex.RLock()
+		closed[roomID] = peerList(room)
+		stats := room.stats(sm.now())
+		rec := room.record()
+		room.mutex.RUnlock()
+
+		if !dryRun {
+			if retain {
+				sm.retainClosedLocked(rec, reason)
+			}
+			delete(sm.rooms, roomID)
+			sm.announceLeave(roomID, leaveReason, closed[roomID]...)
+			sm.roomClosed(roomID, stats, closedReason)
+		}
+	}
+	for roomID, spilled := range sm.spilled {
+		if !match(roomID) {
+			continue
+		}
+
+		closed[roomID] = append([]string{}, spilled.peers...)
+		if !dryRun {
+			if rec, err := sm.loadRecord(roomID); retain && err == nil {
+				sm.retainClosedLocked(rec, reason)
+			}
+			sm.announceLeave(roomID, leaveReason, closed[roomID]...)
+			sm.roomClosed(roomID, sm.dropSpilledLocked(roomID), closedReason)
+		}
+	}
+	sm.mutex.Unlock()
+
+	result := newBulkResult(closed)
+	if dryRun {
+		return result
+	}
+
+	for roomID, peers := range closed {
+		notice, err := NewNotice(RoomClosed, roomID, reason)
+		if err != nil {
+			sm.logger.Error("Failed to marshal room closed notice", "error", err)
+			continue
+		}
+		sm.notifyPeers(peers, notice)
+		sm.logger.Info("Room closed", "room_id", roomID, "peers", len(peers), "reason", reason)
+	}
+
+	return result
+}
+
+// ValidateTenant checks that a tenant is a single literal namespace segment
+func ValidateTenant(tenant string) error {
+	if tenant == "" {
+		return errs.New(errs.InvalidArgument, "tenant is required")
+	}
+	if strings.ContainsAny(tenant, "*?[\\"+NamespaceSeparator) {
+		return errs.Newf(errs.InvalidArgument, "tenant must be a single namespace segment without wildcards: %s", tenant)
+	}
+	return nil
+}
+
+// TenantClients returns the rooms and clients belonging to a tenant, the
+// top-level namespace of room IDs. Top-level rooms without a namespace belong
+// to no tenant.
+func (sm *SignalingManager) TenantClients(tenant string) (BulkResult, error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return BulkResult{}, err
+	}
+
+	prefix := tenant + NamespaceSeparator
+	members := make(map[string][]string)
+
+	sm.mutex.RLock()
+	for roomID, room := range sm.rooms {
+		if !strings.HasPrefix(roomID, prefix) {
+			continue
+		}
+		room.mutex.RLock()
+		members[roomID] = peerList(room)
+		room.mutex.RUnlock()
+	}
+	for roomID, spilled := range sm.spilled {
+		if strings.HasPrefix(roomID, prefix) {
+			members[roomID] = append([]string{}, spilled.peers...)
+		}
+	}
+	sm.mutex.RUnlock()
+
+	return newBulkResult(members), nil
+}
+
+// RemoveClient removes a client from every room it has joined, deleting rooms
+// left empty. It returns the IDs of the rooms the client was removed from.
+func (sm *SignalingManager) RemoveClient(clientID string) []string {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	delete(sm.identities, clientID)
+	delete(sm.versions, clientID)
+	delete(sm.capabilities, clientID)
+	if sm.deprecations != nil {
+		sm.deprecations.forget(clientID)
+	}
+
+	for roomID, spilled := range sm.spilled {
+		if spilled.hasPeer(clientID) {
+			sm.rehydrateLocked(roomID)
+		}
+	}
+
+	rooms := make([]string, 0)
+	for roomID, room := range sm.rooms {
+		room.mutex.Lock()
+		if _, ok := room.Peers[clientID]; ok {
+			room.removePeer(clientID)
+			sm.announceLeave(roomID, LeaveReasonDisconnected, clientID)
+			rooms = append(rooms, roomID)
+		}
+		empty := len(room.Peers) == 0
+		stats := room.stats(sm.now())
+		room.mutex.Unlock()
+
+		if empty {
+			delete(sm.rooms, roomID)
+			sm.roomClosed(roomID, stats, "empty")
+		}
+	}
+
+	sort.Strings(rooms)
+	if len(rooms) > 0 {
+		sm.logger.Info("Client removed from rooms", "client_id", clientID, "rooms", len(rooms))
+	}
+	return rooms
+}
+
+// ClientRooms returns the IDs of the rooms a client has joined, sorted
+func (sm *SignalingManager) ClientRooms(clientID string) []string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	rooms := make([]string, 0)
+	for roomID, room := range sm.rooms {
+		room.mutex.RLock()
+		if _, ok := room.Peers[clientID]; ok {
+			rooms = append(rooms, roomID)
+		}
+		room.mutex.RUnlock()
+	}
+	for roomID, spilled := range sm.spilled {
+		if spilled.hasPeer(clientID) {
+			rooms = append(rooms, roomID)
+		}
+	}
+
+	sort.Strings(rooms)
+	return rooms
+}
+
+// RevokeRooms removes a client from rooms it is no longer authorized to be
+// in, deleting rooms left empty, and sends it a kicked message for each. It
+// returns the IDs of the rooms the client was removed from.
+func (sm *SignalingManager) RevokeRooms(clientID string, roomIDs []string, reason string) []string {
+	sm.mutex.Lock()
+	rooms := make([]string, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		if spilled, ok := sm.spilled[roomID]; ok && spilled.hasPeer(clientID) {
+			sm.rehydrateLocked(roomID)
+		}
+		room, ok := sm.rooms[roomID]
+		if !ok {
+			continue
+		}
+
+		room.mutex.Lock()
+		if _, ok := room.Peers[clientID]; ok {
+			room.removePeer(clientID)
+			sm.announceLeave(roomID, LeaveReasonRevoked, clientID)
+			rooms = append(rooms, roomID)
+		}
+		empty := len(room.Peers) == 0
+		stats := room.stats(sm.now())
+		room.mutex.Unlock()
+
+		if empty {
+			delete(sm.rooms, roomID)
+			sm.roomClosed(roomID, stats, "empty")
+		}
+	}
+	sm.mutex.Unlock()
+
+	sort.Strings(rooms)
+	for _, roomID := range rooms {
+		if message, err := NewNotice(Kicked, roomID, reason); err == nil {
+			sm.notifyPeers([]string{clientID}, message)
+		}
+		sm.logger.Info("Client authorization revoked for room", "client_id", clientID, "room_id", roomID, "reason", reason)
+	}
+	return rooms
+}
+
+// NewNotice builds a server-originated notice message
+func NewNotice(messageType MessageType, roomID, text string) ([]byte, error) {
+	payload, err := json.Marshal(NoticePayload{Message: text})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal notice payload: %w", err)
+	}
+
+	return json.Marshal(Message{
+		Type:    messageType,
+		Room:    roomID,
+		Payload: payload,
+	})
+}
+
+// notifyPeers sends a message to each peer using the configured connections
+func (sm *SignalingManager) notifyPeers(peers []string, message []byte) {
+	if sm.connections == nil {
+		return
+	}
+
+	for _, peer := range peers {
+		if err := sm.connections.SendMessage(peer, message); err != nil {
+			sm.logger.Warn("Failed to notify peer", "error", err, "client_id", peer)
+		}
+	}
+}
+
+// peerList returns the peers of a room. Must be called with the room mutex held.
+func peerList(room *Room) []string {
+	peers := make([]string, 0, len(room.Peers))
+	for peer := range room.Peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// newBulkResult flattens a room to peers mapping into a sorted BulkResult
+func newBulkResult(members map[string][]string) BulkResult {
+	result := BulkResult{
+		Rooms:   make([]string, 0, len(members)),
+		Clients: make([]string, 0),
+	}
+
+	seen := make(map[string]struct{})
+	for roomID, peers := range members {
+		result.Rooms = append(result.Rooms, roomID)
+		for _, peer := range peers {
+			if _, ok := seen[peer]; !ok {
+				seen[peer] = struct{}{}
+				result.Clients = append(result.Clients, peer)
+			}
+		}
+	}
+
+	sort.Strings(result.Rooms)
+	sort.Strings(result.Clients)
+	return result
+}
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes signaling messages in binary WebSocket frames, for clients
+// negotiating its subprotocol in the Sec-WebSocket-Protocol header. Clients
+// not negotiating a codec exchange JSON text frames.
+type Codec interface {
+	// Subprotocol is the Sec-WebSocket-Protocol value selecting the codec
+	Subprotocol() string
+
+	Encode(msg Message) ([]byte, error)
+	Decode(frame []byte) (Message, error)
+}
+
+// NewCodec returns the codec of an encoding name: "protobuf" or "msgpack"
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "protobuf":
+		return ProtobufCodec{}, nil
+	case "msgpack":
+		return MessagePackCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown message encoding: %s", name)
+	}
+}
+
+// EncodeJSON converts a JSON message, as sent by the SignalingManager, to a
+// frame of the codec
+func EncodeJSON(codec Codec, message []byte) ([]byte, error) {
+	var msg Message
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message format: %w", err)
+	}
+	return codec.Encode(msg)
+}
+
+// DecodeJSON converts a frame of the codec to a JSON message, as processed by
+// the SignalingManager
+func DecodeJSON(codec Codec, frame []byte) ([]byte, error) {
+	msg, err := codec.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
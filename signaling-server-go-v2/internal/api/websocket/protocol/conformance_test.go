@@ -0,0 +1,155 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// conformanceDir holds the conformance vectors published to client teams
+const conformanceDir = "testdata/conformance"
+
+// conformanceVector is a scenario of frames sent by clients and the frames
+// and connection closes the server must answer each of them with
+type conformanceVector struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Steps       []conformanceStep `json:"steps"`
+}
+
+// conformanceStep is a frame sent by a client. Frame is sent as is; Raw is
+// sent instead for frames that are not valid JSON.
+type conformanceStep struct {
+	Client   string            `json:"client"`
+	Frame    json.RawMessage   `json:"frame,omitempty"`
+	Raw      string            `json:"raw,omitempty"`
+	Rejected bool              `json:"rejected"`
+	Expect   []conformanceSent `json:"expect"`
+}
+
+// conformanceSent is a frame sent or a connection closed by the server, in order
+type conformanceSent struct {
+	To    string            `json:"to"`
+	Frame json.RawMessage   `json:"frame,omitempty"`
+	Close *conformanceClose `json:"close,omitempty"`
+}
+
+// conformanceClose is the close frame of a connection closed by the server
+type conformanceClose struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// conformanceConnections records what the server sends outside of replies
+type conformanceConnections struct {
+	sent *[]conformanceSent
+}
+
+func (c conformanceConnections) SendMessage(clientID string, message []byte) error {
+	*c.sent = append(*c.sent, conformanceSent{To: clientID, Frame: message})
+	return nil
+}
+
+func (c conformanceConnections) CloseConnection(clientID string) error {
+	return c.CloseConnectionWithReason(clientID, 1000, "")
+}
+
+func (c conformanceConnections) CloseConnectionWithReason(clientID string, code int, reason string) error {
+	*c.sent = append(*c.sent, conformanceSent{To: clientID, Close: &conformanceClose{Code: code, Reason: reason}})
+	return nil
+}
+
+func TestConformance(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(conformanceDir, "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No conformance vectors found in %s: %v", conformanceDir, err)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		var vector conformanceVector
+		if err := json.Unmarshal(data, &vector); err != nil {
+			t.Fatalf("Invalid conformance vector %s: %v", path, err)
+		}
+
+		t.Run(vector.Name, func(t *testing.T) {
+			runConformanceVector(t, vector)
+		})
+	}
+}
+
+// runConformanceVector replays a vector's frames against a fresh manager
+func runConformanceVector(t *testing.T, vector conformanceVector) {
+	var sent []conformanceSent
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }),
+		WithConnections(conformanceConnections{sent: &sent}),
+	)
+	reply := func(clientID string, message []byte) error {
+		sent = append(sent, conformanceSent{To: clientID, Frame: message})
+		return nil
+	}
+
+	for i, step := range vector.Steps {
+		sent = nil
+		frame := []byte(step.Frame)
+		if step.Raw != "" {
+			frame = []byte(step.Raw)
+		}
+
+		err := sm.ProcessMessage(frame, step.Client, reply)
+		if rejected := err != nil; rejected != step.Rejected {
+			t.Errorf("Step %d: expected rejected %v, got error %v", i, step.Rejected, err)
+		}
+
+		if len(sent) != len(step.Expect) {
+			t.Errorf("Step %d: expected %d frames, got %d: %s", i, len(step.Expect), len(sent), describeSent(sent))
+			continue
+		}
+		for j, expected := range step.Expect {
+			if !sameSent(expected, sent[j]) {
+				t.Errorf("Step %d: expected %s, got %s", i, describeSent([]conformanceSent{expected}), describeSent(sent[j:j+1]))
+			}
+		}
+	}
+}
+
+// sameSent reports whether two sent frames are equal as JSON values
+func sameSent(expected, got conformanceSent) bool {
+	if expected.To != got.To || !reflect.DeepEqual(expected.Close, got.Close) {
+		return false
+	}
+	if expected.Frame == nil || got.Frame == nil {
+		return expected.Frame == nil && got.Frame == nil
+	}
+
+	var want, have interface{}
+	if json.Unmarshal(expected.Frame, &want) != nil || json.Unmarshal(got.Frame, &have) != nil {
+		return false
+	}
+	return reflect.DeepEqual(want, have)
+}
+
+// describeSent formats sent frames for test failures
+func describeSent(sent []conformanceSent) string {
+	s := ""
+	for _, frame := range sent {
+		if frame.Close != nil {
+			s += fmt.Sprintf("[close %s %d %q]", frame.To, frame.Close.Code, frame.Close.Reason)
+		} else {
+			s += fmt.Sprintf("[to %s %s]", frame.To, frame.Frame)
+		}
+	}
+	return s
+}
@@ -0,0 +1,54 @@
+package protocol
+
+// DefaultRoomCreationDisabledMessage is sent to clients joining a new room
+// while room creation is disabled, unless another message is configured
+const DefaultRoomCreationDisabledMessage = "new calls are temporarily unavailable, please try again later"
+
+// RoomCreation is the state of the room creation switch
+type RoomCreation struct {
+	Disabled bool   `json:"disabled"`
+	Message  string `json:"message,omitempty"`
+}
+
+// WithRoomCreationDisabled starts the manager with room creation disabled,
+// rejecting joins to new rooms with the message, or a default one if empty
+func WithRoomCreationDisabled(message string) ManagerOption {
+	return func(sm *SignalingManager) {
+		if message == "" {
+			message = DefaultRoomCreationDisabledMessage
+		}
+		sm.creation = RoomCreation{Disabled: true, Message: message}
+	}
+}
+
+// SetRoomCreation enables or disables the creation of rooms by joins, e.g.
+// during an incident or a capacity crunch. While disabled, joins to rooms that
+// do not exist are rejected with the message, or a default one if empty,
+// and calls in existing rooms continue. Rooms created through the admin API
+// are not affected.
+func (sm *SignalingManager) SetRoomCreation(disabled bool, message string) RoomCreation {
+	if !disabled {
+		message = ""
+	} else if message == "" {
+		message = DefaultRoomCreationDisabledMessage
+	}
+
+	sm.mutex.Lock()
+	sm.creation = RoomCreation{Disabled: disabled, Message: message}
+	sm.mutex.Unlock()
+
+	if disabled {
+		sm.logger.Warn("Room creation disabled", "message", message)
+	} else {
+		sm.logger.Info("Room creation enabled")
+	}
+	return RoomCreation{Disabled: disabled, Message: message}
+}
+
+// RoomCreation returns the state of the room creation switch
+func (sm *SignalingManager) RoomCreation() RoomCreation {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	return sm.creation
+}
@@ -0,0 +1,158 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDeprecationNoticeInterval is how often a client is reminded of a
+// deprecated feature it keeps using, unless configured otherwise
+const DefaultDeprecationNoticeInterval = time.Hour
+
+// Deprecation marks a message type, or a field of messages, as deprecated.
+// Clients using it are sent a deprecation notice; the message is still processed.
+type Deprecation struct {
+	// MessageType is the deprecated message type or, with Field set, the type
+	// of the messages whose field is deprecated, empty for messages of any type
+	MessageType MessageType
+
+	// Field is the deprecated field: a message field such as "password", or
+	// a payload field such as "payload.metadata". Empty deprecates the type.
+	Field string
+
+	// Message tells clients what to use instead
+	Message string
+}
+
+// Feature names the deprecated feature in notices and metrics, e.g. "join.password"
+func (d Deprecation) Feature() string {
+	switch {
+	case d.Field == "":
+		return string(d.MessageType)
+	case d.MessageType == "":
+		return d.Field
+	default:
+		return string(d.MessageType) + "." + d.Field
+	}
+}
+
+// DeprecationPayload is the payload of deprecation notices
+type DeprecationPayload struct {
+	Feature string `json:"feature"`
+	Message string `json:"message,omitempty"`
+}
+
+// deprecations are the deprecated features and when each client was last
+// notified of each, so that clients are reminded at most once per interval
+type deprecations struct {
+	list     []Deprecation
+	interval time.Duration
+	notified map[string]map[string]time.Time
+	mutex    sync.Mutex
+}
+
+// WithDeprecations sends deprecation notices to clients using the deprecated
+// features, at most once per interval per connection and feature
+func WithDeprecations(list []Deprecation, interval time.Duration) ManagerOption {
+	return func(sm *SignalingManager) {
+		if interval <= 0 {
+			interval = DefaultDeprecationNoticeInterval
+		}
+		sm.deprecations = &deprecations{
+			list:     list,
+			interval: interval,
+			notified: make(map[string]map[string]time.Time),
+		}
+	}
+}
+
+// warnDeprecated records the deprecated features used by a message and
+// notifies the client of those it was not reminded of within the interval
+func (sm *SignalingManager) warnDeprecated(raw []byte, msg Message, clientID string, sender func(string, []byte) error) {
+	if sm.deprecations == nil {
+		return
+	}
+
+	var fields, payload map[string]json.RawMessage
+	for _, deprecation := range sm.deprecations.list {
+		if deprecation.MessageType != "" && deprecation.MessageType != msg.Type {
+			continue
+		}
+		if deprecation.Field != "" {
+			name, inPayload := strings.CutPrefix(deprecation.Field, "payload.")
+			// The fields are only parsed for messages that may use a deprecated field
+			if inPayload {
+				if payload == nil {
+					payload = make(map[string]json.RawMessage)
+					json.Unmarshal(msg.Payload, &payload)
+				}
+				if _, ok := payload[name]; !ok {
+					continue
+				}
+			} else {
+				if fields == nil {
+					fields = make(map[string]json.RawMessage)
+					json.Unmarshal(raw, &fields)
+				}
+				if _, ok := fields[deprecation.Field]; !ok {
+					continue
+				}
+			}
+		}
+
+		feature := deprecation.Feature()
+		if sm.metrics != nil {
+			sm.metrics.DeprecatedUsage(feature)
+		}
+		if !sm.deprecations.remind(clientID, feature, sm.now()) {
+			continue
+		}
+
+		sm.logger.Info("Client used deprecated feature", "client_id", clientID, "feature", feature)
+		notice, err := newDeprecationNotice(clientID, deprecation)
+		if err != nil {
+			sm.logger.Error("Failed to marshal deprecation notice", "error", err)
+			continue
+		}
+		if err := sender(clientID, notice); err != nil {
+			sm.logger.Warn("Failed to send deprecation notice", "error", err, "client_id", clientID)
+		}
+	}
+}
+
+// remind reports whether the client should be notified of the feature now,
+// recording the notice if so
+func (d *deprecations) remind(clientID, feature string, now time.Time) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	notified := d.notified[clientID]
+	if last, ok := notified[feature]; ok && now.Sub(last) < d.interval {
+		return false
+	}
+	if notified == nil {
+		notified = make(map[string]time.Time)
+		d.notified[clientID] = notified
+	}
+	notified[feature] = now
+	return true
+}
+
+// forget drops the notices recorded for a client that disconnected
+func (d *deprecations) forget(clientID string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.notified, clientID)
+}
+
+// newDeprecationNotice builds the deprecation notice of a feature sent to a client
+func newDeprecationNotice(clientID string, deprecation Deprecation) ([]byte, error) {
+	payload, err := json.Marshal(DeprecationPayload{Feature: deprecation.Feature(), Message: deprecation.Message})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Message{Type: DeprecationNotice, Recipient: clientID, Payload: payload})
+}
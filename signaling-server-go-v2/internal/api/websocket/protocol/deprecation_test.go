@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestDeprecationNotices(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithDeprecations([]Deprecation{
+			{MessageType: Join, Field: "payload.metadata", Message: "set metadata through the admin API"},
+			{Field: "password"},
+			{MessageType: Mute},
+		}, time.Minute),
+	)
+
+	var notices []DeprecationPayload
+	sender := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		if msg.Type == DeprecationNotice {
+			var payload DeprecationPayload
+			json.Unmarshal(msg.Payload, &payload)
+			notices = append(notices, payload)
+		}
+		return nil
+	}
+
+	join := []byte(`{"type":"join","room":"call","password":"secret","payload":{"metadata":{"topic":"standup"}}}`)
+	if err := sm.ProcessMessage(join, "alice", sender); err != nil {
+		t.Fatalf("Expected the deprecated join to be processed, got %v", err)
+	}
+	expected := []DeprecationPayload{
+		{Feature: "join.payload.metadata", Message: "set metadata through the admin API"},
+		{Feature: "password"},
+	}
+	if !reflect.DeepEqual(notices, expected) {
+		t.Errorf("Expected notices %+v, got %+v", expected, notices)
+	}
+
+	// Each connection is reminded of a feature once per interval
+	notices = nil
+	sm.ProcessMessage([]byte(`{"type":"join","room":"other","password":"secret"}`), "alice", sender)
+	sm.ProcessMessage([]byte(`{"type":"join","room":"other","password":"secret"}`), "bob", sender)
+	if !reflect.DeepEqual(notices, []DeprecationPayload{{Feature: "password"}}) {
+		t.Errorf("Expected only bob to be notified within the interval, got %+v", notices)
+	}
+
+	notices = nil
+	now = now.Add(time.Minute)
+	sm.ProcessMessage([]byte(`{"type":"join","room":"third","password":"secret"}`), "alice", sender)
+	if len(notices) != 1 {
+		t.Errorf("Expected alice to be reminded after the interval, got %+v", notices)
+	}
+
+	// Messages without deprecated fields are not flagged
+	notices = nil
+	sm.ProcessMessage([]byte(`{"type":"leave","room":"third"}`), "alice", sender)
+	if len(notices) != 0 {
+		t.Errorf("Expected no notice for a leave, got %+v", notices)
+	}
+}
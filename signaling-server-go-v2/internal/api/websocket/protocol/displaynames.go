@@ -0,0 +1,193 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// MaxDisplayNameLength is the maximum length of a display name in characters
+const MaxDisplayNameLength = 64
+
+// DisplayNameTakenReason is the error reported to clients whose display
+// name is taken in the room under DisplayNameReject
+const DisplayNameTakenReason = "display name taken"
+
+// ErrDisplayNameTaken is returned when a display name is taken in the room
+// under DisplayNameReject
+var ErrDisplayNameTaken = errs.New(errs.AlreadyExists, DisplayNameTakenReason)
+
+// DisplayNameCollision is how the server resolves a display name already
+// taken by another peer of the room. Names differing only in case collide.
+type DisplayNameCollision string
+
+const (
+	// DisplayNameSuffix gives the peer the name with the lowest free
+	// suffix, e.g. "Alice (2)"
+	DisplayNameSuffix DisplayNameCollision = "suffix"
+
+	// DisplayNameReject rejects the join or rename with DisplayNameTakenReason
+	DisplayNameReject DisplayNameCollision = "reject"
+)
+
+// ParseDisplayNameCollision parses a display name collision policy
+func ParseDisplayNameCollision(policy string) (DisplayNameCollision, error) {
+	switch DisplayNameCollision(policy) {
+	case DisplayNameSuffix, DisplayNameReject:
+		return DisplayNameCollision(policy), nil
+	}
+	return "", fmt.Errorf("unknown display name collision policy: %q", policy)
+}
+
+// WithDisplayNameCollision sets how display names taken in a room are
+// resolved, DisplayNameSuffix by default
+func WithDisplayNameCollision(policy DisplayNameCollision) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.nameCollision = policy
+	}
+}
+
+// RenamePayload is the payload of rename messages. Clients send it with the
+// name they want; the server sends it to all peers of the room, the renamed
+// peer included, with the name the peer was given.
+type RenamePayload struct {
+	DisplayName string `json:"displayName"`
+}
+
+// NormalizeDisplayName trims a display name and checks that it is neither
+// too long nor contains control characters
+func NormalizeDisplayName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if !utf8.ValidString(name) {
+		return "", errs.New(errs.InvalidArgument, "display name is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(name) > MaxDisplayNameLength {
+		return "", errs.Newf(errs.InvalidArgument, "display name exceeds %d characters", MaxDisplayNameLength)
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", errs.New(errs.InvalidArgument, "display name contains control characters")
+	}
+	return name, nil
+}
+
+// claimName gives a peer a display name unique in the room, resolved by the
+// policy if another peer holds it, and releases its previous name. An empty
+// name releases the peer's name. Must be called with the room mutex held.
+func (r *Room) claimName(clientID, name string, policy DisplayNameCollision) (string, error) {
+	if name == "" {
+		r.releaseName(clientID)
+		return "", nil
+	}
+
+	given := name
+	for n := 2; ; n++ {
+		holder, taken := r.nameHolders[strings.ToLower(given)]
+		if !taken || holder == clientID {
+			break
+		}
+		if policy == DisplayNameReject {
+			return "", fmt.Errorf("%w: %s", ErrDisplayNameTaken, name)
+		}
+		given = fmt.Sprintf("%s (%d)", name, n)
+	}
+
+	r.releaseName(clientID)
+	if r.names == nil {
+		r.names = make(map[string]string)
+		r.nameHolders = make(map[string]string)
+	}
+	r.names[clientID] = given
+	r.nameHolders[strings.ToLower(given)] = clientID
+	r.peerChanged(clientID)
+	return given, nil
+}
+
+// releaseName frees the display name of a peer. Must be called with the room mutex held.
+func (r *Room) releaseName(clientID string) {
+	if name, ok := r.names[clientID]; ok {
+		delete(r.nameHolders, strings.ToLower(name))
+		delete(r.names, clientID)
+	}
+}
+
+// handleRename changes the display name of a peer and sends the name it was
+// given to all local peers of the room
+func (sm *SignalingManager) handleRename(msg Message, clientID string, sender func(string, []byte) error) error {
+	if msg.Room == "" {
+		return errs.New(errs.InvalidArgument, "room ID is required for rename messages")
+	}
+
+	var rename RenamePayload
+	if err := json.Unmarshal(msg.Payload, &rename); err != nil {
+		err = errs.Wrap(errs.InvalidArgument, "invalid rename payload", err)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	name, err := NormalizeDisplayName(rename.DisplayName)
+	if err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	sm.mutex.RLock()
+	room, ok := sm.rooms[msg.Room]
+	if !ok {
+		sm.mutex.RUnlock()
+		err := fmt.Errorf("%w: %s", ErrRoomNotFound, msg.Room)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	room.mutex.Lock()
+	if _, ok := room.Peers[clientID]; !ok {
+		room.mutex.Unlock()
+		sm.mutex.RUnlock()
+		err := fmt.Errorf("%w: client %s in room %s", ErrNotInRoom, clientID, msg.Room)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	given, err := room.claimName(clientID, name, sm.nameCollision)
+	peers := peerList(room)
+	room.mutex.Unlock()
+	sm.mutex.RUnlock()
+
+	if err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	payload, err := json.Marshal(RenamePayload{DisplayName: given})
+	if err != nil {
+		return fmt.Errorf("failed to marshal rename payload: %w", err)
+	}
+	notice, err := json.Marshal(Message{
+		Type:    Rename,
+		Room:    msg.Room,
+		Sender:  sm.peerID(msg.Room, clientID),
+		Payload: payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal rename message: %w", err)
+	}
+
+	sm.logger.Info("Peer renamed", "client_id", clientID, "room_id", msg.Room)
+	replyErr := sender(clientID, notice)
+
+	others := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if peer != clientID {
+			others = append(others, peer)
+		}
+	}
+	sort.Strings(others)
+	sm.notifyPeers(others, notice)
+
+	if replyErr != nil {
+		return fmt.Errorf("failed to send rename message: %w", replyErr)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestDisplayNames(t *testing.T) {
+	conns := testsupport.NewWebSocketHandler()
+	sm := NewSignalingManager(testsupport.NewLogger(), WithConnections(conns))
+	var replies []Message
+	reply := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		replies = append(replies, msg)
+		return nil
+	}
+	join := func(clientID, name string) (JoinedPayload, error) {
+		replies = nil
+		payload, _ := json.Marshal(JoinPayload{DisplayName: name})
+		joinJSON, _ := json.Marshal(Message{Type: Join, Room: "standup", Payload: payload})
+		err := sm.ProcessMessage(joinJSON, clientID, reply)
+		var joined JoinedPayload
+		if len(replies) > 0 {
+			json.Unmarshal(replies[0].Payload, &joined)
+		}
+		return joined, err
+	}
+
+	// Taken names are suffixed, ignoring case
+	for _, tc := range []struct{ clientID, name, want string }{
+		{"client-1", "Alice", "Alice"},
+		{"client-2", "Alice", "Alice (2)"},
+		{"client-3", "  alice ", "alice (3)"},
+	} {
+		joined, err := join(tc.clientID, tc.name)
+		if err != nil {
+			t.Fatalf("Failed to join %s: %v", tc.clientID, err)
+		}
+		if joined.DisplayName != tc.want {
+			t.Errorf("Expected %s to be named %q, got %q", tc.clientID, tc.want, joined.DisplayName)
+		}
+	}
+	if _, err := join("client-4", strings.Repeat("x", MaxDisplayNameLength+1)); err == nil {
+		t.Error("Expected a join with too long a display name to fail")
+	}
+
+	// Renames free the old name and are sent to all peers of the room
+	rename := func(clientID, name string) error {
+		replies = nil
+		payload, _ := json.Marshal(RenamePayload{DisplayName: name})
+		renameJSON, _ := json.Marshal(Message{Type: Rename, Room: "standup", Payload: payload})
+		return sm.ProcessMessage(renameJSON, clientID, reply)
+	}
+	if err := rename("client-1", "Bob"); err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	var renamed RenamePayload
+	json.Unmarshal(replies[0].Payload, &renamed)
+	if replies[0].Type != Rename || renamed.DisplayName != "Bob" {
+		t.Errorf("Expected the renamed peer to be told its name, got %+v", replies[0])
+	}
+	if sent := conns.Sent["client-2"]; len(sent) == 0 || !strings.Contains(string(sent[len(sent)-1]), `"displayName":"Bob"`) {
+		t.Errorf("Expected client-2 to be notified of the rename, got %s", sent)
+	}
+	if err := rename("client-2", "alice"); err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+
+	expected := []PeerInfo{
+		{ID: "client-1", Role: RoleOwner, DisplayName: "Bob"},
+		{ID: "client-2", Role: RoleParticipant, DisplayName: "alice"},
+		{ID: "client-3", Role: RoleParticipant, DisplayName: "alice (3)"},
+	}
+	if peers := sm.GetPeerInfos("standup"); !reflect.DeepEqual(peers, expected) {
+		t.Errorf("Expected peers %+v, got %+v", expected, peers)
+	}
+
+	// Names are freed when their peer leaves
+	sm.RemoveClient("client-1")
+	if joined, _ := join("client-5", "bob"); joined.DisplayName != "bob" {
+		t.Errorf("Expected the name of the peer that left to be free, got %q", joined.DisplayName)
+	}
+}
+
+func TestDisplayNameRejection(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger(), WithDisplayNameCollision(DisplayNameReject))
+	var reasons []string
+	reply := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		if msg.Type == Error {
+			var payload ErrorPayload
+			json.Unmarshal(msg.Payload, &payload)
+			reasons = append(reasons, payload.Message)
+		}
+		return nil
+	}
+
+	payload, _ := json.Marshal(JoinPayload{DisplayName: "Alice"})
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "standup", Payload: payload})
+	if err := sm.ProcessMessage(joinJSON, "client-1", reply); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	if err := sm.ProcessMessage(joinJSON, "client-2", reply); !errors.Is(err, ErrDisplayNameTaken) {
+		t.Errorf("Expected the join with a taken name to be rejected, got %v", err)
+	}
+	if peers := sm.GetPeerInfos("standup"); len(peers) != 1 {
+		t.Errorf("Expected the rejected client not to join, got %v", peers)
+	}
+
+	// A peer may keep its own name
+	payload, _ = json.Marshal(RenamePayload{DisplayName: "ALICE"})
+	renameJSON, _ := json.Marshal(Message{Type: Rename, Room: "standup", Payload: payload})
+	if err := sm.ProcessMessage(renameJSON, "client-1", reply); err != nil {
+		t.Errorf("Expected a peer to change the case of its name: %v", err)
+	}
+
+	if !reflect.DeepEqual(reasons, []string{DisplayNameTakenReason}) {
+		t.Errorf("Expected the rejected client to be told the name is taken, got %v", reasons)
+	}
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EnvelopeVersion is the protocol version from which clients, declaring it
+// in the payload of a join, receive the envelope metadata of the messages
+// sent to them in their meta field
+const EnvelopeVersion = 3
+
+// Envelope is the metadata the server stamps on the messages it writes to
+// clients of EnvelopeVersion, so that they can order events, estimate the
+// skew of their clock and tell which node served them
+type Envelope struct {
+	// Time is when the server wrote the message, serialized by the
+	// TimestampFormat of the server
+	Time json.RawMessage `json:"ts"`
+
+	// Seq numbers the messages written on the connection from 1; a gap
+	// means messages were dropped
+	Seq uint64 `json:"seq"`
+
+	// Server identifies the node the client is connected to
+	Server string `json:"server,omitempty"`
+}
+
+// TimestampFormat serializes the time of envelopes as a JSON value
+type TimestampFormat func(t time.Time) json.RawMessage
+
+// RFC3339Timestamps serializes times as RFC 3339 strings in UTC with
+// nanoseconds, e.g. "2024-05-01T12:00:00.123456789Z"
+func RFC3339Timestamps(t time.Time) json.RawMessage {
+	return json.RawMessage(strconv.Quote(t.UTC().Format(time.RFC3339Nano)))
+}
+
+// UnixMillisTimestamps serializes times as milliseconds since the Unix
+// epoch, as JavaScript's Date.now returns them
+func UnixMillisTimestamps(t time.Time) json.RawMessage {
+	return json.RawMessage(strconv.FormatInt(t.UnixMilli(), 10))
+}
+
+// NewTimestampFormat returns the timestamp format of a name: "rfc3339" or "unix_ms"
+func NewTimestampFormat(name string) (TimestampFormat, error) {
+	switch name {
+	case "rfc3339":
+		return RFC3339Timestamps, nil
+	case "unix_ms":
+		return UnixMillisTimestamps, nil
+	default:
+		return nil, fmt.Errorf("unknown timestamp format: %q", name)
+	}
+}
+
+// Stamper stamps the messages written to clients with their envelope
+type Stamper struct {
+	server string
+	format TimestampFormat
+	now    func() time.Time
+}
+
+// NewStamper creates a Stamper identifying the node as server and
+// serializing the time of now with format
+func NewStamper(server string, format TimestampFormat, now func() time.Time) *Stamper {
+	return &Stamper{server: server, format: format, now: now}
+}
+
+// Stamp returns a JSON message, as sent by the SignalingManager, with the
+// envelope of the seq-th message written on a connection. The meta field is
+// appended to the message rather than re-encoding it.
+func (s *Stamper) Stamp(message []byte, seq uint64) ([]byte, error) {
+	message = bytes.TrimSpace(message)
+	if len(message) < 2 || message[0] != '{' || message[len(message)-1] != '}' {
+		return nil, fmt.Errorf("message is not a JSON object")
+	}
+	meta, err := json.Marshal(Envelope{Time: s.format(s.now()), Seq: seq, Server: s.server})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope: %w", err)
+	}
+
+	stamped := make([]byte, 0, len(message)+len(meta)+len(`,"meta":`))
+	stamped = append(stamped, message[:len(message)-1]...)
+	if len(bytes.TrimSpace(message[1:len(message)-1])) > 0 {
+		stamped = append(stamped, ',')
+	}
+	stamped = append(stamped, `"meta":`...)
+	stamped = append(stamped, meta...)
+	return append(stamped, '}'), nil
+}
+
+// ReceivesEnvelopes reports whether a client declared EnvelopeVersion or
+// later in one of its joins
+func (sm *SignalingManager) ReceivesEnvelopes(clientID string) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return sm.versions[clientID] >= EnvelopeVersion
+}
+
+// recordVersion records the protocol version a client declared in a join,
+// keeping the highest it declared
+func (sm *SignalingManager) recordVersion(clientID string, version int) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if version > sm.versions[clientID] {
+		sm.versions[clientID] = version
+	}
+}
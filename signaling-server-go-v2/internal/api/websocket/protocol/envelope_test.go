@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestStamper(t *testing.T) {
+	now := time.Date(2024, 5, 1, 14, 0, 0, 123000000, time.FixedZone("CEST", 2*60*60))
+
+	for name, expected := range map[string]string{
+		"rfc3339": `{"type":"joined","room":"call","sender":"","meta":{"ts":"2024-05-01T12:00:00.123Z","seq":7,"server":"node-1"}}`,
+		"unix_ms": `{"type":"joined","room":"call","sender":"","meta":{"ts":1714564800123,"seq":7,"server":"node-1"}}`,
+	} {
+		format, err := NewTimestampFormat(name)
+		if err != nil {
+			t.Fatalf("Failed to create timestamp format %s: %v", name, err)
+		}
+		stamper := NewStamper("node-1", format, func() time.Time { return now })
+
+		stamped, err := stamper.Stamp([]byte(`{"type":"joined","room":"call","sender":""}`), 7)
+		if err != nil {
+			t.Fatalf("Stamp failed: %v", err)
+		}
+		if string(stamped) != expected {
+			t.Errorf("Expected %s with %s timestamps, got %s", expected, name, stamped)
+		}
+	}
+
+	if _, err := NewTimestampFormat("iso"); err == nil {
+		t.Error("Expected an unknown timestamp format to be rejected")
+	}
+
+	stamper := NewStamper("", UnixMillisTimestamps, func() time.Time { return now })
+	if stamped, err := stamper.Stamp([]byte("{}\n"), 1); err != nil || string(stamped) != `{"meta":{"ts":1714564800123,"seq":1}}` {
+		t.Errorf("Expected an empty message to be stamped, got %s, %v", stamped, err)
+	}
+	if _, err := stamper.Stamp([]byte(`["joined"]`), 1); err == nil {
+		t.Error("Expected a message other than an object to be rejected")
+	}
+}
+
+func TestReceivesEnvelopes(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	noop := func(string, []byte) error { return nil }
+
+	join := func(clientID, room string, version int) {
+		payload, _ := json.Marshal(JoinPayload{ProtocolVersion: version})
+		message, _ := json.Marshal(Message{Type: Join, Room: room, Payload: payload})
+		if err := sm.ProcessMessage(message, clientID, noop); err != nil {
+			t.Fatalf("Join failed: %v", err)
+		}
+	}
+	join("alice", "call", EnvelopeVersion)
+	join("bob", "call", BatchedJoinsVersion)
+	join("alice", "other", 0)
+
+	if !sm.ReceivesEnvelopes("alice") {
+		t.Error("Expected a client of the envelope version to receive envelopes")
+	}
+	if sm.ReceivesEnvelopes("bob") {
+		t.Error("Expected a client of an older version not to receive envelopes")
+	}
+
+	sm.RemoveClient("alice")
+	if sm.ReceivesEnvelopes("alice") {
+		t.Error("Expected the version of a removed client to be forgotten")
+	}
+
+	// Envelopes set by clients are not relayed
+	join("carol", "call", EnvelopeVersion)
+	var relayed Message
+	offer, _ := json.Marshal(Message{Type: Offer, Room: "call", Recipient: "bob", Payload: json.RawMessage(`{"sdp":"v=0"}`), Meta: json.RawMessage(`{"seq":1}`)})
+	sm.ProcessMessage(offer, "carol", func(clientID string, message []byte) error {
+		return json.Unmarshal(message, &relayed)
+	})
+	if relayed.Type != Offer || relayed.Meta != nil {
+		t.Errorf("Expected the offer to be relayed without its meta, got %+v", relayed)
+	}
+}
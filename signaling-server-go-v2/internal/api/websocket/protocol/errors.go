@@ -0,0 +1,43 @@
+package protocol
+
+import "github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+
+// The errors reported to clients in error messages, with their code and
+// message. The errors returned by the manager wrap them with the details for
+// the logs, so that callers can tell them apart with errors.Is.
+var (
+	// ErrRoomNotFound is returned for messages to a room that does not exist
+	ErrRoomNotFound = errs.New(errs.NotFound, "room not found")
+
+	// ErrNotInRoom is returned for messages to a room the client is not a peer of
+	ErrNotInRoom = errs.New(errs.PermissionDenied, "not a peer of this room")
+
+	// ErrPeerNotInRoom is returned for messages addressing a peer that is not in the room
+	ErrPeerNotInRoom = errs.New(errs.NotFound, "peer not in room")
+
+	// ErrRoleNotRequestable is returned for joins requesting a role other than observer
+	ErrRoleNotRequestable = errs.New(errs.PermissionDenied, "only the observer role may be requested")
+
+	// ErrBanned is returned for joins of a client banned from the room
+	ErrBanned = errs.New(errs.PermissionDenied, "you are banned from this room")
+
+	// ErrRoomFull is returned for joins beyond the room's peer limit
+	ErrRoomFull = errs.New(errs.ResourceExhausted, "room is full")
+
+	// ErrRoomLocked is returned for joins of a locked room
+	ErrRoomLocked = errs.New(errs.FailedPrecondition, "room is locked")
+
+	// ErrRoomCreationDisabled is returned for joins creating a room while
+	// creation is disabled; clients are shown the configured message
+	ErrRoomCreationDisabled = errs.New(errs.FailedPrecondition, "room creation is disabled")
+
+	// ErrPasswordRequired is returned for joins creating a room without the
+	// password its namespace requires
+	ErrPasswordRequired = errs.New(errs.Unauthenticated, "room password required")
+
+	// ErrInvalidPassword is returned for joins with a wrong room password
+	ErrInvalidPassword = errs.New(errs.Unauthenticated, "invalid room password")
+
+	// ErrRoomExists is returned when creating a room that exists
+	ErrRoomExists = errs.New(errs.AlreadyExists, "room already exists")
+)
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// RunJanitor periodically expires rooms idle for longer than ttl, spills cold
+// rooms to the room store and prunes expired bans until the context is
+// cancelled. A ttl of zero keeps idle rooms. Room time limits are enforced
+// every few seconds, or every interval if shorter.
+func (sm *SignalingManager) RunJanitor(ctx context.Context, ttl, interval time.Duration) {
+	if interval <= 0 {
+		sm.logger.Info("Room janitor disabled")
+		return
+	}
+
+	sm.logger.Info("Starting room janitor", "ttl", ttl.String(), "interval", interval.String())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	limits := time.NewTicker(min(interval, timeLimitInterval))
+	defer limits.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			sm.logger.Info("Stopping room janitor")
+			return
+		case <-limits.C:
+			sm.EnforceRoomTimeLimits()
+		case <-ticker.C:
+			if ttl > 0 {
+				sm.ExpireIdleRooms(ttl)
+			}
+			sm.SpillColdRooms()
+			if pruned := sm.PruneBans(); pruned > 0 {
+				sm.logger.Debug("Pruned expired bans", "count", pruned)
+			}
+		}
+	}
+}
+
+// ExpireIdleRooms removes rooms without activity for longer than ttl, notifies
+// their peers and closes their connections. It returns the IDs of expired rooms.
+func (sm *SignalingManager) ExpireIdleRooms(ttl time.Duration) []string {
+	cutoff := sm.now().Add(-ttl)
+	expired := make(map[string][]string)
+
+	sm.mutex.Lock()
+	for roomID, room := range sm.rooms {
+		room.mutex.RLock()
+		idle := room.lastActivity.Before(cutoff)
+		if idle {
+			expired[roomID] = peerList(room)
+		}
+		stats := room.stats(sm.now())
+		room.mutex.RUnlock()
+
+		if idle {
+			delete(sm.rooms, roomID)
+			sm.announceLeave(roomID, LeaveReasonExpired, expired[roomID]...)
+			sm.roomClosed(roomID, stats, "expired")
+		}
+	}
+	for roomID, spilled := range sm.spilled {
+		if spilled.lastActivity.Before(cutoff) {
+			expired[roomID] = append([]string{}, spilled.peers...)
+			sm.announceLeave(roomID, LeaveReasonExpired, expired[roomID]...)
+			sm.roomClosed(roomID, sm.dropSpilledLocked(roomID), "expired")
+		}
+	}
+	sm.mutex.Unlock()
+
+	// Notify and disconnect peers outside of the manager lock
+	roomIDs := make([]string, 0, len(expired))
+	for roomID, peers := range expired {
+		roomIDs = append(roomIDs, roomID)
+		sm.closePeers(roomID, peers)
+
+		sm.logger.Info("Room expired", "room_id", roomID, "peers", len(peers), "ttl", ttl.String())
+		if sm.metrics != nil {
+			sm.metrics.RoomExpired()
+		}
+	}
+
+	return roomIDs
+}
+
+// closePeers notifies the peers of an expired room and closes their
+// connections. Closing a connection ends its membership in every room, so the
+// peers are removed from their other rooms as well.
+func (sm *SignalingManager) closePeers(roomID string, peers []string) {
+	for _, peer := range peers {
+		sm.RemoveClient(peer)
+	}
+
+	if sm.connections == nil {
+		return
+	}
+
+	notice, err := json.Marshal(Message{Type: RoomExpired, Room: roomID})
+	if err != nil {
+		sm.logger.Error("Failed to marshal room expiry notice", "error", err)
+		return
+	}
+
+	sm.notifyPeers(peers, notice)
+	for _, peer := range peers {
+		if err := sm.connections.CloseConnection(peer); err != nil {
+			sm.logger.Warn("Failed to close peer connection", "error", err, "client_id", peer, "room_id", roomID)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// BatchedJoinsVersion is the protocol version from which clients, declaring
+// it in the payload of their join, are notified of joins with joined-batch
+// messages rather than a peer-joined message per join
+const BatchedJoinsVersion = 2
+
+// JoinPacedReason is the error reported to clients whose join exceeds the
+// join rate of the room; they should retry after a randomized backoff
+const JoinPacedReason = "room is busy, retry shortly"
+
+// ErrJoinPaced is returned when a join exceeds the join rate of the room
+var ErrJoinPaced = errs.New(errs.ResourceExhausted, JoinPacedReason)
+
+// PeerJoinedPayload is the payload of peer-joined messages
+type PeerJoinedPayload struct {
+	Peer PeerInfo `json:"peer"`
+}
+
+// JoinedBatchPayload is the payload of joined-batch messages, listing the
+// peers in the order they joined
+type JoinedBatchPayload struct {
+	Peers []PeerInfo `json:"peers"`
+}
+
+// joinBatches collects the joins to each room until its peers are notified
+// of them, so that the peers of a room many clients join at once are sent a
+// notification per batch window rather than per join
+type joinBatches struct {
+	window time.Duration
+
+	// pending lists the peers that joined each room since its last
+	// notification, in join order
+	pending map[string][]string
+	mutex   sync.Mutex
+}
+
+// WithJoinPacing paces the joins of new peers to each room at rate joins per
+// second, with bursts of up to burst joins. Joins beyond it are rejected
+// with JoinPacedReason, so that a room many clients join at once fills at a
+// rate its peers can keep up with. Peers rejoining a room are not paced.
+func WithJoinPacing(rate float64, burst int) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.joinPacer = websocket.NewMemoryLimiter(rate, burst, func() time.Time { return sm.now() })
+	}
+}
+
+// WithJoinBatchWindow sets how long the joins to a room are collected before
+// its peers are notified of them, 0 to notify them of each join at once
+func WithJoinBatchWindow(window time.Duration) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.joins.window = window
+	}
+}
+
+// admitJoinLocked reports whether a client may join a room under the join
+// pacing, taking a join from the room's bucket unless the client is already
+// a peer of the room. Must be called with sm.mutex held.
+func (sm *SignalingManager) admitJoinLocked(room *Room, roomID, clientID string) bool {
+	if sm.joinPacer == nil {
+		return true
+	}
+
+	if room != nil {
+		room.mutex.RLock()
+		_, member := room.Peers[clientID]
+		room.mutex.RUnlock()
+		if member {
+			return true
+		}
+	}
+
+	// The memory limiter does not fail
+	allowed, _ := sm.joinPacer.Allow(context.Background(), roomID)
+	return allowed
+}
+
+// queueJoin records the join of a new peer to a room, to be notified to its
+// peers once the batch window has passed. Must be called with the room mutex held.
+func (sm *SignalingManager) queueJoin(room *Room, clientID string, join JoinPayload) {
+	if join.ProtocolVersion >= BatchedJoinsVersion {
+		if room.batchedJoins == nil {
+			room.batchedJoins = make(map[string]struct{})
+		}
+		room.batchedJoins[clientID] = struct{}{}
+	}
+
+	b := &sm.joins
+	b.mutex.Lock()
+	first := len(b.pending[room.ID]) == 0
+	b.pending[room.ID] = append(b.pending[room.ID], clientID)
+	b.mutex.Unlock()
+
+	if first && b.window > 0 {
+		roomID := room.ID
+		time.AfterFunc(b.window, func() {
+			sm.flushJoins(roomID)
+		})
+	}
+}
+
+// flushJoins notifies the local peers of a room of the peers that joined it
+// since its last notification. Peers that joined in the batch are only
+// notified of the peers that joined after them, the others being in the
+// peer list of their joined message. Clients of BatchedJoinsVersion get a
+// single joined-batch message, older clients a peer-joined message per join.
+func (sm *SignalingManager) flushJoins(roomID string) {
+	b := &sm.joins
+	b.mutex.Lock()
+	joiners := b.pending[roomID]
+	delete(b.pending, roomID)
+	b.mutex.Unlock()
+
+	if len(joiners) == 0 || sm.connections == nil {
+		return
+	}
+
+	sm.mutex.RLock()
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		sm.mutex.RUnlock()
+		return
+	}
+	room.mutex.RLock()
+	// Peers that left before the notification are not announced
+	joined := make([]PeerInfo, 0, len(joiners))
+	for _, peer := range joiners {
+		if _, ok := room.Peers[peer]; ok {
+			joined = append(joined, room.peerInfo(peer))
+		}
+	}
+	recipients := peerList(room)
+	batched := make(map[string]bool, len(room.batchedJoins))
+	for peer := range room.batchedJoins {
+		batched[peer] = true
+	}
+	room.mutex.RUnlock()
+	sm.mutex.RUnlock()
+
+	if len(joined) == 0 {
+		return
+	}
+
+	position := make(map[string]int, len(joined))
+	for i, peer := range joined {
+		position[peer.ID] = i
+		if sm.peerIDs != nil {
+			joined[i].ID = sm.peerID(roomID, peer.ID)
+		}
+	}
+
+	// Recipients are grouped by the joins they are notified of, peers that
+	// joined in the batch being notified of fewer, so that each notification
+	// is marshalled once however many peers receive it. Peers are notified in
+	// peer ID order.
+	type group struct {
+		from    int
+		batched bool
+	}
+	var order []group
+	groups := make(map[group][]string)
+	sort.Strings(recipients)
+	for _, recipient := range recipients {
+		g := group{batched: batched[recipient]}
+		if i, ok := position[recipient]; ok {
+			g.from = i + 1
+		}
+		if g.from == len(joined) {
+			continue
+		}
+		if _, ok := groups[g]; !ok {
+			order = append(order, g)
+		}
+		groups[g] = append(groups[g], recipient)
+	}
+
+	peerJoined := make([][]byte, len(joined))
+	for _, g := range order {
+		peers := groups[g]
+		if g.batched {
+			notice, err := newJoinNotice(roomID, JoinedBatch, JoinedBatchPayload{Peers: joined[g.from:]})
+			if err != nil {
+				sm.logger.Error("Failed to marshal joined batch notice", "error", err)
+				return
+			}
+			sm.notifyPeers(peers, notice)
+			continue
+		}
+		for i := g.from; i < len(joined); i++ {
+			if peerJoined[i] == nil {
+				notice, err := newJoinNotice(roomID, PeerJoined, PeerJoinedPayload{Peer: joined[i]})
+				if err != nil {
+					sm.logger.Error("Failed to marshal peer joined notice", "error", err)
+					return
+				}
+				peerJoined[i] = notice
+			}
+			sm.notifyPeers(peers, peerJoined[i])
+		}
+	}
+
+	sm.logger.Debug("Notified peers of joins", "room_id", roomID, "joined", len(joined), "recipients", len(recipients))
+}
+
+// newJoinNotice builds a peer-joined or joined-batch notice
+func newJoinNotice(roomID string, messageType MessageType, payload interface{}) ([]byte, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Message{Type: messageType, Room: roomID, Payload: payloadJSON})
+}
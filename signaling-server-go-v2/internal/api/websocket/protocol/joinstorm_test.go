@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestJoinPacing(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithJoinPacing(1, 2),
+	)
+	var reasons []string
+	reply := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		if msg.Type == Error {
+			var payload ErrorPayload
+			json.Unmarshal(msg.Payload, &payload)
+			reasons = append(reasons, payload.Message)
+		}
+		return nil
+	}
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "webinar"})
+	for _, client := range []string{"alice", "bob"} {
+		if err := sm.ProcessMessage(joinJSON, client, reply); err != nil {
+			t.Fatalf("Expected %s to join within the burst: %v", client, err)
+		}
+	}
+	if err := sm.ProcessMessage(joinJSON, "carol", reply); !errors.Is(err, ErrJoinPaced) {
+		t.Errorf("Expected the join beyond the burst to be paced, got %v", err)
+	}
+	if !reflect.DeepEqual(reasons, []string{JoinPacedReason}) {
+		t.Errorf("Expected the paced client to be told to retry, got %v", reasons)
+	}
+
+	// Peers rejoining are not paced, and the room admits joins as the bucket refills
+	if err := sm.ProcessMessage(joinJSON, "alice", reply); err != nil {
+		t.Errorf("Expected a peer of the room to rejoin: %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := sm.ProcessMessage(joinJSON, "carol", reply); err != nil {
+		t.Errorf("Expected the retried join to be admitted: %v", err)
+	}
+
+	otherJSON, _ := json.Marshal(Message{Type: Join, Room: "standup"})
+	if err := sm.ProcessMessage(otherJSON, "dave", reply); err != nil {
+		t.Errorf("Expected joins to other rooms not to be paced: %v", err)
+	}
+}
+
+func TestJoinBatching(t *testing.T) {
+	conns := testsupport.NewWebSocketHandler()
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithConnections(conns),
+		WithJoinBatchWindow(time.Hour),
+	)
+	noop := func(string, []byte) error { return nil }
+
+	join := func(clientID string, version int) {
+		payload, _ := json.Marshal(JoinPayload{ProtocolVersion: version})
+		joinJSON, _ := json.Marshal(Message{Type: Join, Room: "webinar", Payload: payload})
+		if err := sm.ProcessMessage(joinJSON, clientID, noop); err != nil {
+			t.Fatalf("Failed to join %s: %v", clientID, err)
+		}
+	}
+	join("host", BatchedJoinsVersion)
+	join("legacy", 0)
+	for _, client := range []string{"guest-1", "guest-2", "guest-3"} {
+		join(client, BatchedJoinsVersion)
+	}
+	leaveJSON, _ := json.Marshal(Message{Type: Leave, Room: "webinar"})
+	sm.ProcessMessage(leaveJSON, "guest-3", noop)
+
+	if len(conns.Sent) != 0 {
+		t.Fatalf("Expected no notifications within the batch window, got %v", conns.Sent)
+	}
+	sm.flushJoins("webinar")
+
+	received := func(clientID string) []string {
+		var notified []string
+		for _, message := range conns.Sent[clientID] {
+			var msg Message
+			json.Unmarshal(message, &msg)
+			switch msg.Type {
+			case JoinedBatch:
+				var payload JoinedBatchPayload
+				json.Unmarshal(msg.Payload, &payload)
+				var peers []string
+				for _, peer := range payload.Peers {
+					peers = append(peers, peer.ID)
+				}
+				notified = append(notified, "batch:"+strings.Join(peers, ","))
+			case PeerJoined:
+				var payload PeerJoinedPayload
+				json.Unmarshal(msg.Payload, &payload)
+				notified = append(notified, "peer:"+payload.Peer.ID)
+			}
+		}
+		return notified
+	}
+
+	// The peer that left is not announced, and peers that joined in the batch
+	// are only notified of the later joins
+	expected := map[string][]string{
+		"host":    {"batch:legacy,guest-1,guest-2"},
+		"legacy":  {"peer:guest-1", "peer:guest-2"},
+		"guest-1": {"batch:guest-2"},
+		"guest-2": nil,
+	}
+	for clientID, want := range expected {
+		if got := received(clientID); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s to be notified of %v, got %v", clientID, want, got)
+		}
+	}
+
+	// The batch is sent once
+	sm.flushJoins("webinar")
+	if got := received("host"); len(got) != 1 {
+		t.Errorf("Expected a single batch, got %v", got)
+	}
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// MaxMetadataSize is the maximum size in bytes of room metadata
+const MaxMetadataSize = 8 * 1024
+
+// ValidateMetadata checks that room metadata is a JSON object within the size limit.
+// Empty metadata is valid.
+func ValidateMetadata(metadata json.RawMessage) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	if len(metadata) > MaxMetadataSize {
+		return errs.Newf(errs.InvalidArgument, "room metadata exceeds %d bytes", MaxMetadataSize)
+	}
+
+	trimmed := bytes.TrimSpace(metadata)
+	if len(trimmed) == 0 || trimmed[0] != '{' || !json.Valid(trimmed) {
+		return errs.New(errs.InvalidArgument, "room metadata must be a JSON object")
+	}
+
+	return nil
+}
+
+// CreateRoom creates an empty room with metadata ahead of the first join
+func (sm *SignalingManager) CreateRoom(roomID string, metadata json.RawMessage) error {
+	if err := ValidateRoomID(roomID); err != nil {
+		return err
+	}
+	if err := ValidateMetadata(metadata); err != nil {
+		return err
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if _, ok := sm.rooms[roomID]; ok {
+		return fmt.Errorf("%w: %s", ErrRoomExists, roomID)
+	}
+	if _, ok := sm.spilled[roomID]; ok {
+		return fmt.Errorf("%w: %s", ErrRoomExists, roomID)
+	}
+
+	sm.rooms[roomID] = &Room{
+		ID:           roomID,
+		Peers:        make(map[string]struct{}),
+		Metadata:     metadata,
+		lastActivity: sm.now(),
+		createdAt:    sm.now(),
+	}
+	sm.limitRoomLocked(roomID, sm.policyFor(roomID))
+	sm.emit(ActivityEvent{Type: ActivityRoomCreated, Room: roomID})
+
+	sm.logger.Info("Room created", "room_id", roomID)
+	return nil
+}
+
+// SetRoomMetadata replaces the metadata of an existing room
+func (sm *SignalingManager) SetRoomMetadata(roomID string, metadata json.RawMessage) error {
+	if err := ValidateMetadata(metadata); err != nil {
+		return err
+	}
+
+	sm.rehydrate(roomID)
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+
+	room.mutex.Lock()
+	room.Metadata = metadata
+	room.mutex.Unlock()
+
+	sm.logger.Info("Room metadata updated", "room_id", roomID)
+	return nil
+}
+
+// GetRoomMetadata returns the metadata of a room
+func (sm *SignalingManager) GetRoomMetadata(roomID string) (json.RawMessage, bool) {
+	sm.rehydrate(roomID)
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		return nil, false
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	return room.Metadata, true
+}
@@ -0,0 +1,184 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// DefaultBanDuration is how long banned peers are rejected from a room unless configured otherwise
+const DefaultBanDuration = time.Hour
+
+// ModerationPayload is the optional payload of kick and ban messages
+type ModerationPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// handleModeration removes the recipient of a kick or ban message from the
+// room, notifies it and closes its connection with the reason. Closing the
+// connection ends its membership in every room, so it is removed from its
+// other rooms as well.
+func (sm *SignalingManager) handleModeration(msg Message, clientID string, sender func(string, []byte) error) error {
+	if msg.Room == "" || msg.Recipient == "" {
+		return errs.Newf(errs.InvalidArgument, "room and recipient are required for %s messages", msg.Type)
+	}
+
+	var moderation ModerationPayload
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &moderation); err != nil {
+			err = errs.Wrap(errs.InvalidArgument, "invalid moderation payload", err)
+			sm.sendError(clientID, err, sender)
+			return err
+		}
+	}
+
+	if err := sm.removeModerated(msg, clientID); err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	notice := Kicked
+	if msg.Type == Ban {
+		notice = Banned
+	}
+	sm.logger.Info("Peer removed by moderator", "action", msg.Type, "client_id", msg.Recipient, "moderator", clientID, "room_id", msg.Room, "reason", moderation.Reason)
+	activity := ActivityKick
+	if msg.Type == Ban {
+		activity = ActivityBan
+	}
+	sm.emit(ActivityEvent{Type: activity, Room: msg.Room, Client: msg.Recipient, Actor: clientID, Reason: moderation.Reason})
+
+	if sm.connections == nil {
+		return nil
+	}
+
+	sm.RemoveClient(msg.Recipient)
+	if message, err := NewNotice(notice, msg.Room, moderation.Reason); err == nil {
+		sm.notifyPeers([]string{msg.Recipient}, message)
+	}
+
+	closeReason := fmt.Sprintf("%s from room %s", notice, msg.Room)
+	if moderation.Reason != "" {
+		closeReason += ": " + moderation.Reason
+	}
+	if err := sm.connections.CloseConnectionWithReason(msg.Recipient, websocket.ClosePolicyViolation, closeReason); err != nil {
+		sm.logger.Warn("Failed to close moderated peer connection", "error", err, "client_id", msg.Recipient)
+	}
+
+	return nil
+}
+
+// removeModerated checks the moderator's permission and removes the target
+// from the room, recording a ban if requested
+func (sm *SignalingManager) removeModerated(msg Message, moderatorID string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	room, ok := sm.rooms[msg.Room]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, msg.Room)
+	}
+
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if msg.Recipient == moderatorID {
+		return errs.Wrap(errs.InvalidArgument, "cannot moderate yourself", fmt.Errorf("client %s attempted to %s itself", moderatorID, msg.Type))
+	}
+
+	if !room.canModerate(moderatorID, msg.Recipient) {
+		sm.logger.Warn("Rejected moderation by non-moderator", "client_id", moderatorID, "room_id", msg.Room, "action", msg.Type)
+		return errs.Wrap(errs.PermissionDenied, "not allowed to moderate this room", fmt.Errorf("client %s cannot moderate room: %s", moderatorID, msg.Room))
+	}
+
+	if _, ok := room.Peers[msg.Recipient]; !ok && msg.Type == Kick {
+		return fmt.Errorf("%w: peer %s in room %s", ErrPeerNotInRoom, msg.Recipient, msg.Room)
+	}
+
+	if _, ok := room.Peers[msg.Recipient]; ok {
+		room.removePeer(msg.Recipient)
+		sm.announceLeave(msg.Room, string(msg.Type), msg.Recipient)
+	}
+
+	if msg.Type == Ban {
+		if sm.bans[msg.Room] == nil {
+			sm.bans[msg.Room] = make(map[string]time.Time)
+		}
+		sm.bans[msg.Room][sm.identityOf(msg.Recipient)] = sm.now().Add(sm.banDuration)
+	}
+
+	return nil
+}
+
+// SetClientIdentity records the identity of a connected client, such as its
+// address or authenticated principal. Bans apply to the identity, so a banned
+// peer cannot rejoin by reconnecting with a new client ID. Clients without an
+// identity are banned by client ID.
+func (sm *SignalingManager) SetClientIdentity(clientID, identity string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if identity == "" {
+		delete(sm.identities, clientID)
+		return
+	}
+	sm.identities[clientID] = identity
+}
+
+// identityOf returns the identity bans of a client are keyed on. Must be called with sm.mutex held.
+func (sm *SignalingManager) identityOf(clientID string) string {
+	if identity, ok := sm.identities[clientID]; ok {
+		return identity
+	}
+	return clientID
+}
+
+// isBanned reports whether a client is currently banned from a room,
+// forgetting an expired ban. Must be called with sm.mutex write-locked.
+func (sm *SignalingManager) isBanned(roomID, clientID string) bool {
+	bans, ok := sm.bans[roomID]
+	if !ok {
+		return false
+	}
+
+	identity := sm.identityOf(clientID)
+	until, ok := bans[identity]
+	if !ok {
+		return false
+	}
+
+	if !sm.now().Before(until) {
+		delete(bans, identity)
+		if len(bans) == 0 {
+			delete(sm.bans, roomID)
+		}
+		return false
+	}
+
+	return true
+}
+
+// PruneBans forgets expired bans and returns how many were removed. Bans are
+// otherwise only forgotten when the banned peer tries to rejoin.
+func (sm *SignalingManager) PruneBans() int {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := sm.now()
+	pruned := 0
+	for roomID, bans := range sm.bans {
+		for identity, until := range bans {
+			if !now.Before(until) {
+				delete(bans, identity)
+				pruned++
+			}
+		}
+		if len(bans) == 0 {
+			delete(sm.bans, roomID)
+		}
+	}
+	return pruned
+}
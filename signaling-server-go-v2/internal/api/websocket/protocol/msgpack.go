@@ -0,0 +1,433 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// MessagePackSubprotocol is the subprotocol of binary frames encoded with MessagePack
+const MessagePackSubprotocol = "signaling.v1+msgpack"
+
+// msgpackMaxDepth bounds the nesting of decoded payloads
+const msgpackMaxDepth = 64
+
+// errMsgpackTruncated is returned for frames ending in the middle of a value
+var errMsgpackTruncated = errors.New("truncated MessagePack frame")
+
+// MessagePackCodec encodes a message as a MessagePack map with the keys of
+// the JSON message. The payload and meta are native MessagePack values
+// rather than embedded JSON, so that clients need no JSON parser.
+type MessagePackCodec struct{}
+
+// Subprotocol implements Codec
+func (MessagePackCodec) Subprotocol() string {
+	return MessagePackSubprotocol
+}
+
+// Encode implements Codec. Empty fields are omitted.
+func (MessagePackCodec) Encode(msg Message) ([]byte, error) {
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"type", string(msg.Type)},
+		{"room", msg.Room},
+		{"sender", msg.Sender},
+		{"recipient", msg.Recipient},
+		{"password", msg.Password},
+		{"trace", msg.Trace},
+		{"id", msg.ID},
+	}
+
+	count := 0
+	for _, field := range fields {
+		if field.value != "" {
+			count++
+		}
+	}
+	// The JSON fields are decoded into values encoded natively
+	type value struct {
+		key   string
+		value interface{}
+	}
+	var values []value
+	for _, field := range []struct {
+		key  string
+		json json.RawMessage
+	}{
+		{"payload", msg.Payload},
+		{"meta", msg.Meta},
+	} {
+		if len(field.json) == 0 {
+			continue
+		}
+		decoded := value{key: field.key}
+		decoder := json.NewDecoder(bytes.NewReader(field.json))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decoded.value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", field.key, err)
+		}
+		values = append(values, decoded)
+		count++
+	}
+
+	frame := appendMsgpackMapHeader(nil, count)
+	for _, field := range fields {
+		if field.value != "" {
+			frame = appendMsgpackString(frame, field.key)
+			frame = appendMsgpackString(frame, field.value)
+		}
+	}
+	for _, field := range values {
+		frame = appendMsgpackString(frame, field.key)
+		var err error
+		if frame, err = appendMsgpackValue(frame, field.value); err != nil {
+			return nil, err
+		}
+	}
+	return frame, nil
+}
+
+// Decode implements Codec. Unknown keys are ignored.
+func (MessagePackCodec) Decode(frame []byte) (Message, error) {
+	d := msgpackDecoder{data: frame}
+	value, err := d.value(0)
+	if err != nil {
+		return Message{}, err
+	}
+	if len(d.data) > 0 {
+		return Message{}, fmt.Errorf("trailing bytes after MessagePack message")
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return Message{}, fmt.Errorf("MessagePack message is not a map")
+	}
+
+	var msg Message
+	for key, target := range map[string]*string{
+		"room":      &msg.Room,
+		"sender":    &msg.Sender,
+		"recipient": &msg.Recipient,
+		"password":  &msg.Password,
+		"trace":     &msg.Trace,
+		"id":        &msg.ID,
+	} {
+		if *target, err = msgpackStringField(fields, key); err != nil {
+			return Message{}, err
+		}
+	}
+	messageType, err := msgpackStringField(fields, "type")
+	if err != nil {
+		return Message{}, err
+	}
+	msg.Type = MessageType(messageType)
+
+	if payload, ok := fields["payload"]; ok && payload != nil {
+		if msg.Payload, err = json.Marshal(payload); err != nil {
+			return Message{}, fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+	if meta, ok := fields["meta"]; ok && meta != nil {
+		if msg.Meta, err = json.Marshal(meta); err != nil {
+			return Message{}, fmt.Errorf("invalid meta: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+// msgpackStringField returns a string field of a decoded message, empty if absent
+func msgpackStringField(fields map[string]interface{}, key string) (string, error) {
+	value, ok := fields[key]
+	if !ok || value == nil {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("MessagePack field %s is not a string", key)
+	}
+	return s, nil
+}
+
+// appendMsgpackValue appends a value decoded from JSON with UseNumber
+func appendMsgpackValue(frame []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(frame, 0xc0), nil
+	case bool:
+		if v {
+			return append(frame, 0xc3), nil
+		}
+		return append(frame, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(frame, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", v)
+		}
+		frame = append(frame, 0xcb)
+		return binary.BigEndian.AppendUint64(frame, math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackString(frame, v), nil
+	case []interface{}:
+		frame = appendMsgpackArrayHeader(frame, len(v))
+		for _, element := range v {
+			var err error
+			if frame, err = appendMsgpackValue(frame, element); err != nil {
+				return nil, err
+			}
+		}
+		return frame, nil
+	case map[string]interface{}:
+		// Keys are sorted so that encoding is deterministic
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		frame = appendMsgpackMapHeader(frame, len(v))
+		for _, key := range keys {
+			frame = appendMsgpackString(frame, key)
+			var err error
+			if frame, err = appendMsgpackValue(frame, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return frame, nil
+	default:
+		return nil, fmt.Errorf("unsupported payload value of type %T", value)
+	}
+}
+
+// appendMsgpackInt appends an integer in its smallest encoding
+func appendMsgpackInt(frame []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(frame, byte(i))
+	case i < 0 && i >= -32:
+		return append(frame, byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(frame, 0xd0, byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(frame, 0xd1), uint16(int16(i)))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(frame, 0xd2), uint32(int32(i)))
+	default:
+		return binary.BigEndian.AppendUint64(append(frame, 0xd3), uint64(i))
+	}
+}
+
+// appendMsgpackString appends a str value
+func appendMsgpackString(frame []byte, s string) []byte {
+	switch n := len(s); {
+	case n <= 31:
+		frame = append(frame, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		frame = append(frame, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0xda), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint32(append(frame, 0xdb), uint32(n))
+	}
+	return append(frame, s...)
+}
+
+// appendMsgpackArrayHeader appends the header of an array of n elements
+func appendMsgpackArrayHeader(frame []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(frame, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(frame, 0xdc), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(frame, 0xdd), uint32(n))
+	}
+}
+
+// appendMsgpackMapHeader appends the header of a map of n entries
+func appendMsgpackMapHeader(frame []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(frame, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(frame, 0xde), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(frame, 0xdf), uint32(n))
+	}
+}
+
+// msgpackDecoder decodes MessagePack values into the values json.Marshal
+// encodes: nil, bool, json.Number, string, []interface{} and
+// map[string]interface{}. Binary values are decoded as strings.
+type msgpackDecoder struct {
+	data []byte
+}
+
+// next consumes n bytes
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+// value decodes the next value, nested depth levels deep
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, fmt.Errorf("MessagePack value nested too deeply")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return json.Number(strconv.Itoa(int(c))), nil
+	case c >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(c)))), nil
+	case c&0xe0 == 0xa0:
+		return d.string(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return d.array(int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return d.mapping(int(c&0x0f), depth)
+	}
+
+	switch c := b[0]; c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2, 0xc3:
+		return c == 0xc3, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(u, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from the encoded size
+		shift := 64 - 8*size
+		return json.Number(strconv.FormatInt(int64(u<<shift)>>shift, 10)), nil
+	case 0xca:
+		u, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(float64(math.Float32frombits(uint32(u))))
+	case 0xcb:
+		u, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(math.Float64frombits(u))
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		size := 1 << (c - 0xd9)
+		if c <= 0xc6 {
+			size = 1 << (c - 0xc4)
+		}
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		return d.string(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapping(int(n), depth)
+	default:
+		return nil, fmt.Errorf("unsupported MessagePack type 0x%02x", c)
+	}
+}
+
+// string decodes a string of n bytes
+func (d *msgpackDecoder) string(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// array decodes an array of n elements
+func (d *msgpackDecoder) array(n int, depth int) (interface{}, error) {
+	// Each element takes at least a byte, which bounds the allocation
+	if n > len(d.data) {
+		return nil, errMsgpackTruncated
+	}
+	array := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		element, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, element)
+	}
+	return array, nil
+}
+
+// mapping decodes a map of n entries with string keys
+func (d *msgpackDecoder) mapping(n int, depth int) (interface{}, error) {
+	if n > len(d.data)/2 {
+		return nil, errMsgpackTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("MessagePack map key is not a string")
+		}
+		if m[s], err = d.value(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// msgpackFloat converts a float to a JSON number, rejecting the values JSON cannot represent
+func msgpackFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("MessagePack float %v cannot be represented in JSON", f)
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
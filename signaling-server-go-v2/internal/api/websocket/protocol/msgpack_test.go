@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestMessagePackCodec(t *testing.T) {
+	codec := MessagePackCodec{}
+
+	frame, err := codec.Encode(Message{Type: Join, Room: "call"})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	expected := []byte("\x82\xa4type\xa4join\xa4room\xa4call")
+	if !bytes.Equal(frame, expected) {
+		t.Errorf("Expected %q, got %q", expected, frame)
+	}
+
+	// Payloads round trip through native MessagePack values
+	payload := `{"big":70000,"candidate":null,"flags":[true,false],"neg":-200,"ratio":0.5,"sdp":"v=0","small":-3}`
+	meta := `{"seq":7,"server":"node-1","ts":"2024-05-01T12:00:00Z"}`
+	msg := Message{Type: Offer, Room: "call", Sender: "alice", Recipient: "bob", Payload: json.RawMessage(payload), Meta: json.RawMessage(meta)}
+	frame, err = codec.Encode(msg)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := codec.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Type != Offer || decoded.Room != "call" || decoded.Sender != "alice" || decoded.Recipient != "bob" {
+		t.Errorf("Unexpected fields after a round trip: %+v", decoded)
+	}
+	if string(decoded.Payload) != payload {
+		t.Errorf("Expected payload %s after a round trip, got %s", payload, decoded.Payload)
+	}
+	if string(decoded.Meta) != meta {
+		t.Errorf("Expected meta %s after a round trip, got %s", meta, decoded.Meta)
+	}
+
+	// Encodings other clients may choose: uint16, str8, bin8 and unknown keys
+	frame = []byte("\x83\xa4type\xd9\x06answer\xa7payload\x82\xa3seq\xcd\x01\x00\xa3raw\xc4\x02hi\xa5extra\xc0")
+	decoded, err = codec.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Type != Answer || string(decoded.Payload) != `{"raw":"hi","seq":256}` {
+		t.Errorf("Unexpected decoded message %+v with payload %s", decoded, decoded.Payload)
+	}
+
+	deep := bytes.Repeat([]byte{0x91}, msgpackMaxDepth+2)
+	for name, frame := range map[string][]byte{
+		"truncated frame": expected[:len(expected)-1],
+		"non-map message": {0x93, 0x01, 0x02, 0x03},
+		"non-string key":  {0x81, 0x01, 0x02},
+		"non-string type": {0x81, 0xa4, 't', 'y', 'p', 'e', 0x05},
+		"trailing bytes":  append(append([]byte{}, expected...), 0xc0),
+		"NaN float":       {0x81, 0xa7, 'p', 'a', 'y', 'l', 'o', 'a', 'd', 0xcb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 1},
+		"ext type":        {0xd4, 0x01, 0x00},
+		"deep nesting":    append([]byte{0x81, 0xa7, 'p', 'a', 'y', 'l', 'o', 'a', 'd'}, deep...),
+	} {
+		if _, err := codec.Decode(frame); err == nil {
+			t.Errorf("Expected an error decoding a frame with a %s", name)
+		}
+	}
+}
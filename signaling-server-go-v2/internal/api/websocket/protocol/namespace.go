@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// NamespaceSeparator separates the segments of a hierarchical room ID (org/project/room)
+const NamespaceSeparator = "/"
+
+// NamespacePolicy holds the policies applied to all rooms within a namespace
+type NamespacePolicy struct {
+	// MaxPeers limits the number of peers in each room, 0 means unlimited
+	MaxPeers int
+
+	// RequirePassword rejects the creation of rooms without a password
+	RequirePassword bool
+
+	// MaxDuration closes each room that long after its creation, warning its
+	// peers beforehand; 0 means unlimited
+	MaxDuration time.Duration
+
+	// ObserverJoins makes the peers joining a room after its creator
+	// observers, for webinars where the owner grants the participant role to
+	// the few peers that publish
+	ObserverJoins bool
+}
+
+// ValidateRoomID checks that a room ID is a well-formed namespace path
+func ValidateRoomID(roomID string) error {
+	if roomID == "" {
+		return errs.New(errs.InvalidArgument, "room ID is required")
+	}
+
+	for _, segment := range strings.Split(roomID, NamespaceSeparator) {
+		if segment == "" {
+			return errs.Newf(errs.InvalidArgument, "room ID contains an empty namespace segment: %s", roomID)
+		}
+	}
+
+	return nil
+}
+
+// NamespaceOf returns the namespace of a room ID, or an empty string for top-level rooms
+func NamespaceOf(roomID string) string {
+	i := strings.LastIndex(roomID, NamespaceSeparator)
+	if i < 0 {
+		return ""
+	}
+	return roomID[:i]
+}
+
+// MatchRoomPattern reports whether a room ID matches a wildcard pattern.
+// A "*" segment (or glob within a segment) matches a single segment and a
+// trailing "**" segment matches any number of remaining segments.
+func MatchRoomPattern(pattern, roomID string) bool {
+	patternSegments := strings.Split(pattern, NamespaceSeparator)
+	roomSegments := strings.Split(roomID, NamespaceSeparator)
+
+	for i, p := range patternSegments {
+		if p == "**" && i == len(patternSegments)-1 {
+			return true
+		}
+		if i >= len(roomSegments) {
+			return false
+		}
+		if ok, err := path.Match(p, roomSegments[i]); err != nil || !ok {
+			return false
+		}
+	}
+
+	return len(patternSegments) == len(roomSegments)
+}
+
+// SetNamespacePolicy sets the policy for a namespace and all of its descendants.
+// The empty namespace sets the global default policy.
+func (sm *SignalingManager) SetNamespacePolicy(namespace string, policy NamespacePolicy) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.policies[strings.Trim(namespace, NamespaceSeparator)] = policy
+	sm.logger.Info("Namespace policy updated", "namespace", namespace, "max_peers", policy.MaxPeers, "require_password", policy.RequirePassword, "max_duration", policy.MaxDuration.String(), "observer_joins", policy.ObserverJoins)
+}
+
+// SetNamespacePolicies replaces the policies of all namespaces, e.g. on
+// configuration reload. Peer caps apply to later joins and time limits to
+// rooms created later; rooms already over a new cap keep their peers.
+func (sm *SignalingManager) SetNamespacePolicies(policies map[string]NamespacePolicy) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.policies = make(map[string]NamespacePolicy, len(policies))
+	for namespace, policy := range policies {
+		sm.policies[strings.Trim(namespace, NamespaceSeparator)] = policy
+	}
+	sm.logger.Info("Namespace policies replaced", "namespaces", len(policies))
+}
+
+// RemoveNamespacePolicy removes the policy set for a namespace
+func (sm *SignalingManager) RemoveNamespacePolicy(namespace string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	delete(sm.policies, strings.Trim(namespace, NamespaceSeparator))
+}
+
+// policyFor returns the most specific policy applying to a room. Must be called with sm.mutex held.
+func (sm *SignalingManager) policyFor(roomID string) NamespacePolicy {
+	for namespace := NamespaceOf(roomID); ; namespace = NamespaceOf(namespace) {
+		if policy, ok := sm.policies[namespace]; ok {
+			return policy
+		}
+		if namespace == "" {
+			return NamespacePolicy{}
+		}
+	}
+}
+
+// FindRooms returns the sorted IDs of all rooms matching a wildcard pattern
+func (sm *SignalingManager) FindRooms(pattern string) []string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	rooms := make([]string, 0)
+	for roomID := range sm.rooms {
+		if MatchRoomPattern(pattern, roomID) {
+			rooms = append(rooms, roomID)
+		}
+	}
+	for roomID := range sm.spilled {
+		if MatchRoomPattern(pattern, roomID) {
+			rooms = append(rooms, roomID)
+		}
+	}
+
+	sort.Strings(rooms)
+	return rooms
+}
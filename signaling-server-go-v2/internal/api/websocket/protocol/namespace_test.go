@@ -0,0 +1,178 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestMatchRoomPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		roomID  string
+		want    bool
+	}{
+		{"acme/web/standup", "acme/web/standup", true},
+		{"acme/*/standup", "acme/web/standup", true},
+		{"acme/*", "acme/web/standup", false},
+		{"acme/**", "acme/web/standup", true},
+		{"acme/**", "other/web/standup", false},
+		{"acme/web/stand*", "acme/web/standup", true},
+		{"**", "lobby", true},
+		{"acme/web/standup/extra", "acme/web/standup", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchRoomPattern(tt.pattern, tt.roomID); got != tt.want {
+			t.Errorf("MatchRoomPattern(%q, %q) = %v, want %v", tt.pattern, tt.roomID, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRoomID(t *testing.T) {
+	valid := []string{"lobby", "acme/web/standup"}
+	for _, roomID := range valid {
+		if err := ValidateRoomID(roomID); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", roomID, err)
+		}
+	}
+
+	invalid := []string{"", "/lobby", "acme//standup", "acme/"}
+	for _, roomID := range invalid {
+		if err := ValidateRoomID(roomID); err == nil {
+			t.Errorf("Expected %q to be invalid", roomID)
+		}
+	}
+}
+
+func TestFindRooms(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	noop := func(string, []byte) error { return nil }
+
+	for i, roomID := range []string{"acme/web/standup", "acme/web/retro", "acme/mobile/standup", "globex/web/standup"} {
+		joinJSON, _ := json.Marshal(Message{Type: Join, Room: roomID})
+		sm.ProcessMessage(joinJSON, "client-"+string(rune('a'+i)), noop)
+	}
+
+	got := sm.FindRooms("acme/*/standup")
+	want := []string{"acme/mobile/standup", "acme/web/standup"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected rooms %v, got %v", want, got)
+	}
+
+	if got := sm.FindRooms("acme/**"); len(got) != 3 {
+		t.Errorf("Expected 3 rooms in acme namespace, got %v", got)
+	}
+}
+
+func TestNamespacePolicies(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	noop := func(string, []byte) error { return nil }
+
+	sm.SetNamespacePolicy("acme", NamespacePolicy{MaxPeers: 1})
+	sm.SetNamespacePolicy("acme/secure", NamespacePolicy{RequirePassword: true})
+
+	// Size limits are inherited by nested namespaces
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "acme/web/standup"})
+	if err := sm.ProcessMessage(joinJSON, "client-1", noop); err != nil {
+		t.Fatalf("First join failed: %v", err)
+	}
+	if err := sm.ProcessMessage(joinJSON, "client-2", noop); err == nil {
+		t.Error("Expected join beyond namespace size limit to fail")
+	}
+
+	// The most specific policy wins
+	openJSON, _ := json.Marshal(Message{Type: Join, Room: "acme/secure/board"})
+	if err := sm.ProcessMessage(openJSON, "client-3", noop); err == nil {
+		t.Error("Expected room creation without password to fail")
+	}
+	if sm.RoomExists("acme/secure/board") {
+		t.Error("Expected rejected room not to be created")
+	}
+
+	protectedJSON, _ := json.Marshal(Message{Type: Join, Room: "acme/secure/board", Password: "s3cret"})
+	if err := sm.ProcessMessage(protectedJSON, "client-3", noop); err != nil {
+		t.Errorf("Expected room creation with password to succeed: %v", err)
+	}
+
+	// Rooms outside the namespace are unaffected
+	otherJSON, _ := json.Marshal(Message{Type: Join, Room: "globex/standup"})
+	sm.ProcessMessage(otherJSON, "client-4", noop)
+	if err := sm.ProcessMessage(otherJSON, "client-5", noop); err != nil {
+		t.Errorf("Expected join outside namespace to succeed: %v", err)
+	}
+}
+
+func TestTenantClients(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	noop := func(string, []byte) error { return nil }
+
+	for clientID, roomID := range map[string]string{"client-1": "r", "client-2": "r/standup", "client-3": "rx/standup"} {
+		joinJSON, _ := json.Marshal(Message{Type: Join, Room: roomID})
+		sm.ProcessMessage(joinJSON, clientID, noop)
+	}
+
+	// Only rooms namespaced under the tenant belong to it
+	result, err := sm.TenantClients("r")
+	if err != nil {
+		t.Fatalf("TenantClients failed: %v", err)
+	}
+	if !reflect.DeepEqual(result.Rooms, []string{"r/standup"}) || !reflect.DeepEqual(result.Clients, []string{"client-2"}) {
+		t.Errorf("Expected only r/standup and client-2, got %+v", result)
+	}
+
+	for _, tenant := range []string{"", "*", "**", "r/standup", "[r]", `r\`} {
+		if _, err := sm.TenantClients(tenant); err == nil {
+			t.Errorf("Expected tenant %q to be rejected", tenant)
+		}
+	}
+}
+
+func TestQualifyMessage(t *testing.T) {
+	qualified, err := QualifyMessage("acme", []byte(`{"type":"join","room":"web/standup","payload":{"room":"lobby"}}`))
+	if err != nil {
+		t.Fatalf("QualifyMessage failed: %v", err)
+	}
+	var msg Message
+	json.Unmarshal(qualified, &msg)
+	if msg.Room != "acme/web/standup" || string(msg.Payload) != `{"room":"lobby"}` {
+		t.Errorf("Expected only the room to be qualified, got %s", qualified)
+	}
+
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{`{"type":"joined","room":"acme/web/standup"}`, "web/standup"},
+		{`{"type":"joined","room":"globex/standup"}`, "globex/standup"},
+		{`{"type":"joined","room":"acmeco/standup"}`, "acmeco/standup"},
+	}
+	for _, tt := range tests {
+		unqualified, err := UnqualifyMessage("acme", []byte(tt.message))
+		json.Unmarshal(unqualified, &msg)
+		if err != nil || msg.Room != tt.want {
+			t.Errorf("UnqualifyMessage(%s) = %s, %v, want room %q", tt.message, unqualified, err, tt.want)
+		}
+	}
+
+	notice := []byte(`{"type":"notice"}`)
+	if unqualified, err := UnqualifyMessage("acme", notice); err != nil || string(unqualified) != string(notice) {
+		t.Errorf("Expected a message without a room to be unchanged, got %s, %v", unqualified, err)
+	}
+	if _, err := QualifyMessage("acme", []byte(`not json`)); err == nil {
+		t.Error("Expected an invalid message to be rejected")
+	}
+
+	// Messages decode the room regardless of case, so it is qualified the same way
+	qualified, err = QualifyMessage("acme", []byte(`{"type":"join","Room":"globex/standup"}`))
+	msg = Message{}
+	json.Unmarshal(qualified, &msg)
+	if err != nil || msg.Room != "acme/globex/standup" {
+		t.Errorf("Expected a case-variant room key to be qualified, got %s, %v", qualified, err)
+	}
+	if _, err := QualifyMessage("acme", []byte(`{"type":"join","room":"standup","ROOM":"globex/standup"}`)); err == nil {
+		t.Error("Expected a room set under several keys to be rejected")
+	}
+}
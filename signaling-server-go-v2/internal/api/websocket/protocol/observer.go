@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"sort"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// ErrObserver is returned when an observer sends a message only publishers may send
+var ErrObserver = errs.New(errs.PermissionDenied, "observers cannot publish")
+
+// mayPublish reports whether the sender of a relayed message may send it.
+// Observers may only answer and send ICE candidates to a single peer, so
+// that they receive the media of publishers without sending any. Relays
+// without a room are checked against each room the sender is a peer of.
+func (sm *SignalingManager) mayPublish(msg Message) bool {
+	if msg.Recipient != "" && (msg.Type == Answer || msg.Type == ICECandidate) {
+		return true
+	}
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if msg.Room != "" {
+		room, ok := sm.rooms[msg.Room]
+		if !ok {
+			return true
+		}
+		room.mutex.RLock()
+		defer room.mutex.RUnlock()
+		return room.roleOf(msg.Sender) != RoleObserver
+	}
+
+	for _, room := range sm.rooms {
+		room.mutex.RLock()
+		observer := room.roleOf(msg.Sender) == RoleObserver
+		room.mutex.RUnlock()
+		if observer {
+			return false
+		}
+	}
+	return true
+}
+
+// RestrictToObserver makes a client an observer of the rooms it is a peer of
+// among roomIDs, e.g. when its Authorizer only allows it to watch them, and
+// returns the sorted IDs of the rooms whose role it changed. An owner
+// restricted to observing hands the room over as if it left. The role can be
+// granted back by the room owner until the client is restricted again.
+func (sm *SignalingManager) RestrictToObserver(clientID string, roomIDs []string) []string {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	rooms := make([]string, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		if spilled, ok := sm.spilled[roomID]; ok && spilled.hasPeer(clientID) {
+			sm.rehydrateLocked(roomID)
+		}
+		room, ok := sm.rooms[roomID]
+		if !ok {
+			continue
+		}
+
+		room.mutex.Lock()
+		if _, ok := room.Peers[clientID]; ok && room.roleOf(clientID) != RoleObserver {
+			room.setRole(clientID, RoleObserver)
+			if room.Owner == clientID {
+				room.electOwner()
+			}
+			rooms = append(rooms, roomID)
+		}
+		room.mutex.Unlock()
+	}
+
+	sort.Strings(rooms)
+	if len(rooms) > 0 {
+		sm.logger.Info("Client restricted to observing rooms", "client_id", clientID, "rooms", len(rooms))
+	}
+	return rooms
+}
@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestObserverRole(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	sent := make(map[string][]Message)
+	sender := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		sent[clientID] = append(sent[clientID], msg)
+		return nil
+	}
+	send := func(clientID string, msg Message) error {
+		if msg.Room == "" {
+			msg.Room = "webinar"
+		}
+		data, _ := json.Marshal(msg)
+		return sm.ProcessMessage(data, clientID, sender)
+	}
+	join := func(clientID string, role Role) error {
+		payload, _ := json.Marshal(JoinPayload{Role: role})
+		return send(clientID, Message{Type: Join, Payload: payload})
+	}
+
+	// An observer joining first does not own the room
+	if err := join("viewer", RoleObserver); err != nil {
+		t.Fatalf("Failed to join as observer: %v", err)
+	}
+	if err := join("host", ""); err != nil {
+		t.Fatalf("Failed to join host: %v", err)
+	}
+	if err := join("speaker", RoleModerator); err == nil {
+		t.Error("Expected a join requesting the moderator role to fail")
+	}
+	if err := join("speaker", ""); err != nil {
+		t.Fatalf("Failed to join speaker: %v", err)
+	}
+
+	expected := []PeerInfo{
+		{ID: "host", Role: RoleOwner},
+		{ID: "speaker", Role: RoleParticipant},
+		{ID: "viewer", Role: RoleObserver},
+	}
+	if peers := sm.GetPeerInfos("webinar"); !reflect.DeepEqual(peers, expected) {
+		t.Errorf("Expected peers %+v, got %+v", expected, peers)
+	}
+
+	// Observers receive offers and broadcasts, and may only answer them
+	if err := send("host", Message{Type: Offer}); err != nil {
+		t.Fatalf("Failed to broadcast offer: %v", err)
+	}
+	if last := sent["viewer"][len(sent["viewer"])-1]; last.Type != Offer {
+		t.Errorf("Expected the observer to receive the broadcast, got %+v", last)
+	}
+	for _, msg := range []Message{
+		{Type: Answer, Recipient: "host"},
+		{Type: ICECandidate, Recipient: "host"},
+	} {
+		if err := send("viewer", msg); err != nil {
+			t.Errorf("Expected the observer to send %s: %v", msg.Type, err)
+		}
+	}
+	for _, msg := range []Message{
+		{Type: Offer, Recipient: "host"},
+		{Type: Renegotiate, Recipient: "host"},
+		{Type: ICERestart, Recipient: "host"},
+		{Type: ICECandidate},
+		{Type: Offer, Room: "-", Recipient: "host"},
+	} {
+		if msg.Room == "-" {
+			msg.Room = ""
+		}
+		if err := send("viewer", msg); !errors.Is(err, ErrObserver) {
+			t.Errorf("Expected the observer not to send %+v, got %v", msg, err)
+		}
+	}
+
+	// Roles granted by the owner take effect on the next message
+	grant := func(to string, role Role) error {
+		payload, _ := json.Marshal(RolePayload{Role: role})
+		return send("host", Message{Type: GrantRole, Recipient: to, Payload: payload})
+	}
+	if err := grant("speaker", RoleObserver); err != nil {
+		t.Fatalf("Failed to grant observer: %v", err)
+	}
+	if err := send("speaker", Message{Type: Offer, Recipient: "host"}); !errors.Is(err, ErrObserver) {
+		t.Errorf("Expected the demoted speaker not to publish, got %v", err)
+	}
+	if err := grant("viewer", RoleParticipant); err != nil {
+		t.Fatalf("Failed to grant participant: %v", err)
+	}
+	if err := send("viewer", Message{Type: Offer, Recipient: "host"}); err != nil {
+		t.Errorf("Expected the promoted viewer to publish: %v", err)
+	}
+
+	// Observers are passed over when the owner leaves
+	send("host", Message{Type: Leave})
+	if roles := sm.Snapshot()[0].Roles; roles["viewer"] != RoleOwner || roles["speaker"] != RoleObserver {
+		t.Errorf("Expected ownership to pass to viewer, got %v", roles)
+	}
+}
+
+func TestObserverJoins(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	sm.SetNamespacePolicy("webinars", NamespacePolicy{ObserverJoins: true})
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "webinars/launch"})
+	for _, client := range []string{"host", "viewer-1", "viewer-2"} {
+		if err := sm.ProcessMessage(joinJSON, client, noop); err != nil {
+			t.Fatalf("Failed to join %s: %v", client, err)
+		}
+	}
+
+	expected := map[string]Role{"host": RoleOwner, "viewer-1": RoleObserver, "viewer-2": RoleObserver}
+	if roles := sm.Snapshot()[0].Roles; !reflect.DeepEqual(roles, expected) {
+		t.Errorf("Expected roles %v, got %v", expected, roles)
+	}
+
+	// Rejoining does not demote a peer the owner granted participant
+	payload, _ := json.Marshal(RolePayload{Role: RoleParticipant})
+	grantJSON, _ := json.Marshal(Message{Type: GrantRole, Room: "webinars/launch", Recipient: "viewer-1", Payload: payload})
+	if err := sm.ProcessMessage(grantJSON, "host", noop); err != nil {
+		t.Fatalf("Failed to grant participant: %v", err)
+	}
+	sm.ProcessMessage(joinJSON, "viewer-1", noop)
+	if roles := sm.Snapshot()[0].Roles; roles["viewer-1"] != "" {
+		t.Errorf("Expected viewer-1 to stay a participant, got %s", roles["viewer-1"])
+	}
+}
+
+func TestRestrictToObserver(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	noop := func(string, []byte) error { return nil }
+
+	for _, roomID := range []string{"room-a", "room-b"} {
+		joinJSON, _ := json.Marshal(Message{Type: Join, Room: roomID})
+		for _, client := range []string{"client-1", "client-2"} {
+			if err := sm.ProcessMessage(joinJSON, client, noop); err != nil {
+				t.Fatalf("Failed to join %s to %s: %v", client, roomID, err)
+			}
+		}
+	}
+
+	rooms := sm.RestrictToObserver("client-1", []string{"room-b", "room-a", "room-c"})
+	if !reflect.DeepEqual(rooms, []string{"room-a", "room-b"}) {
+		t.Errorf("Expected client-1 to be restricted in both rooms, got %v", rooms)
+	}
+	if rooms := sm.RestrictToObserver("client-1", []string{"room-a"}); len(rooms) != 0 {
+		t.Errorf("Expected no change for an observer, got %v", rooms)
+	}
+
+	// The restricted owner hands both rooms over
+	for _, snapshot := range sm.Snapshot() {
+		expected := map[string]Role{"client-1": RoleObserver, "client-2": RoleOwner}
+		if !reflect.DeepEqual(snapshot.Roles, expected) {
+			t.Errorf("Expected roles %v in %s, got %v", expected, snapshot.ID, snapshot.Roles)
+		}
+	}
+}
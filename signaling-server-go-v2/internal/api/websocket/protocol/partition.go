@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// ErrRoomDegraded is returned for relays to peers on other instances of a
+// room that is read-only while the cluster is partitioned
+var ErrRoomDegraded = errs.New(errs.Unavailable, "room is degraded, try again later")
+
+// DegradedPayload is the payload of degraded notices
+type DegradedPayload struct {
+	// Degraded is set when the room becomes read-only and cleared once it recovered
+	Degraded bool   `json:"degraded"`
+	Message  string `json:"message,omitempty"`
+}
+
+// SetDegraded marks rooms read-only while this instance cannot reach the
+// other instances sharing them, or writable again once membership was
+// reconciled, and sends a degraded notice to their local peers. A degraded
+// room rejects joins from new peers and relays to peers on other instances;
+// relays between local peers and leaves are still processed.
+func (sm *SignalingManager) SetDegraded(roomIDs []string, degraded bool) {
+	notified := make(map[string][]string)
+
+	sm.mutex.Lock()
+	for _, roomID := range roomIDs {
+		if degraded {
+			sm.degraded[roomID] = struct{}{}
+		} else {
+			delete(sm.degraded, roomID)
+		}
+
+		if room, ok := sm.rooms[roomID]; ok {
+			room.mutex.RLock()
+			notified[roomID] = peerList(room)
+			room.mutex.RUnlock()
+		} else if spilled, ok := sm.spilled[roomID]; ok {
+			notified[roomID] = append([]string{}, spilled.peers...)
+		}
+	}
+	sm.mutex.Unlock()
+
+	message := "room is read-only until the connection to the other servers is restored"
+	if !degraded {
+		message = "connection to the other servers restored"
+	}
+	payload, err := json.Marshal(DegradedPayload{Degraded: degraded, Message: message})
+	if err != nil {
+		sm.logger.Error("Failed to marshal degraded payload", "error", err)
+		return
+	}
+
+	rooms := make([]string, 0, len(notified))
+	for roomID := range notified {
+		rooms = append(rooms, roomID)
+	}
+	sort.Strings(rooms)
+	for _, roomID := range rooms {
+		notice, err := json.Marshal(Message{Type: Degraded, Room: roomID, Payload: payload})
+		if err != nil {
+			sm.logger.Error("Failed to marshal degraded notice", "error", err)
+			continue
+		}
+		sm.notifyPeers(notified[roomID], notice)
+	}
+
+	if degraded {
+		sm.logger.Warn("Rooms degraded by a cluster partition", "rooms", len(roomIDs))
+	} else {
+		sm.logger.Info("Rooms recovered from a cluster partition", "rooms", len(roomIDs))
+	}
+}
+
+// isDegraded reports whether a room is read-only
+func (sm *SignalingManager) isDegraded(roomID string) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	_, ok := sm.degraded[roomID]
+	return ok
+}
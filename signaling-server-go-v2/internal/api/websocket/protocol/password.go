@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// MaxPasswordLength is the maximum length in bytes of a room password, the input limit of bcrypt
+const MaxPasswordLength = 72
+
+// passwordCheck holds the result of the bcrypt work done for a join before the
+// manager lock is taken
+type passwordCheck struct {
+	// hash is the hash of the presented password, set when the room did not exist yet
+	hash []byte
+
+	// matched is the room password hash the presented password was verified against
+	matched []byte
+}
+
+// allows reports whether the checked password admits a client to the room.
+// The room must still carry the hash that was verified, so a password changed
+// in the meantime rejects the join. Must be called with the room mutex held.
+func (c passwordCheck) allows(room *Room) bool {
+	return room.passwordHash == nil || (c.matched != nil && bytes.Equal(room.passwordHash, c.matched))
+}
+
+// hashPassword returns the bcrypt hash of a password, or nil for an empty password
+func hashPassword(password string) ([]byte, error) {
+	if password == "" {
+		return nil, nil
+	}
+	if len(password) > MaxPasswordLength {
+		return nil, errs.Newf(errs.InvalidArgument, "room password must be at most %d bytes", MaxPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash room password: %w", err)
+	}
+	return hash, nil
+}
+
+// checkRoomPassword verifies a password against the current password of a
+// room, or hashes it if the room does not exist and the join would create it
+func (sm *SignalingManager) checkRoomPassword(roomID, password string) (passwordCheck, error) {
+	sm.mutex.RLock()
+	room, ok := sm.rooms[roomID]
+	var current []byte
+	if ok {
+		room.mutex.RLock()
+		current = room.passwordHash
+		room.mutex.RUnlock()
+	}
+	sm.mutex.RUnlock()
+
+	if !ok {
+		hash, err := hashPassword(password)
+		return passwordCheck{hash: hash}, err
+	}
+
+	if current != nil && password != "" && bcrypt.CompareHashAndPassword(current, []byte(password)) == nil {
+		return passwordCheck{matched: current}, nil
+	}
+	return passwordCheck{}, nil
+}
+
+// SetRoomPassword sets or clears (with an empty password) the password of an existing room
+func (sm *SignalingManager) SetRoomPassword(roomID, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	sm.rehydrate(roomID)
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+
+	room.mutex.Lock()
+	room.passwordHash = hash
+	room.mutex.Unlock()
+
+	sm.logger.Info("Room password updated", "room_id", roomID, "protected", password != "")
+	return nil
+}
@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"sort"
+)
+
+// CapabilityPeerListDelta is the capability of clients that resynchronize
+// the peer list of a room with the changes since the last list they saw,
+// rather than the full list. Declaring it, a client gets the sequence number
+// of the peer list in its joined and peers replies, and is sent a
+// peer-list-delta reply to a peers request since a sequence number.
+const CapabilityPeerListDelta = "peer-list-delta"
+
+// capabilities are the optional protocol features the server supports
+var capabilities = []string{CapabilityPeerListDelta}
+
+// maxPeerListChanges bounds the peer list changes retained per room. Clients
+// asking for the changes since an older sequence number get the full list.
+const maxPeerListChanges = 1024
+
+// PeersRequestPayload is the optional payload of a peers request
+type PeersRequestPayload struct {
+	// Since is the sequence number of the last peer list the client saw.
+	// Clients of CapabilityPeerListDelta setting it are sent the changes
+	// since, if still retained, and the full list otherwise.
+	Since uint64 `json:"since,omitempty"`
+}
+
+// PeerListDeltaPayload is the payload of peer-list-delta messages, listing
+// the peers that joined or changed and the peers that left a room between
+// two sequence numbers of its peer list. Removes may name peers that joined
+// and left in between, which clients ignore.
+type PeerListDeltaPayload struct {
+	Since   uint64     `json:"since"`
+	Seq     uint64     `json:"seq"`
+	Adds    []PeerInfo `json:"adds"`
+	Removes []string   `json:"removes"`
+}
+
+// peerChange records that a peer joined, left or changed its role, mute
+// state or display name at a sequence number of the room's peer list
+type peerChange struct {
+	seq  uint64
+	peer string
+}
+
+// negotiateCapabilities returns the capabilities the server supports among
+// those a client declared, in the server's order
+func negotiateCapabilities(declared []string) []string {
+	var negotiated []string
+	for _, capability := range capabilities {
+		if hasCapability(declared, capability) {
+			negotiated = append(negotiated, capability)
+		}
+	}
+	return negotiated
+}
+
+// hasCapability reports whether the capability is in the list
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// recordCapabilities records the capabilities negotiated in a client's join,
+// keeping those negotiated in its earlier joins
+func (sm *SignalingManager) recordCapabilities(clientID string, negotiated []string) {
+	if len(negotiated) == 0 {
+		return
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	for _, capability := range negotiated {
+		if !hasCapability(sm.capabilities[clientID], capability) {
+			sm.capabilities[clientID] = append(sm.capabilities[clientID], capability)
+		}
+	}
+}
+
+// negotiated reports whether a client negotiated the capability in one of its joins
+func (sm *SignalingManager) negotiated(clientID, capability string) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	return hasCapability(sm.capabilities[clientID], capability)
+}
+
+// peerChanged records a change of the peer list, advancing its sequence
+// number. Must be called with the room mutex held.
+func (r *Room) peerChanged(peer string) {
+	r.peerSeq++
+	r.peerChanges = append(r.peerChanges, peerChange{seq: r.peerSeq, peer: peer})
+	if len(r.peerChanges) > maxPeerListChanges {
+		r.peerChangesFrom = r.peerChanges[0].seq
+		r.peerChanges = r.peerChanges[1:]
+	}
+}
+
+// peerListDelta returns the peers of the room that joined or changed and the
+// peers that left since the sequence number, sorted by ID, reporting false
+// if the changes since are no longer retained. Must be called with the room
+// mutex held.
+func (r *Room) peerListDelta(since uint64) ([]PeerInfo, []string, bool) {
+	if since < r.peerChangesFrom || since > r.peerSeq {
+		return nil, nil, false
+	}
+
+	first := sort.Search(len(r.peerChanges), func(i int) bool {
+		return r.peerChanges[i].seq > since
+	})
+	changed := make(map[string]struct{})
+	for _, change := range r.peerChanges[first:] {
+		changed[change.peer] = struct{}{}
+	}
+
+	adds := make([]PeerInfo, 0, len(changed))
+	removes := make([]string, 0)
+	for peer := range changed {
+		if _, ok := r.Peers[peer]; ok {
+			adds = append(adds, r.peerInfo(peer))
+		} else {
+			removes = append(removes, peer)
+		}
+	}
+	sortPeerInfos(adds)
+	sort.Strings(removes)
+	return adds, removes, true
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestPeerListDelta(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	var sent []Message
+	senderFunc := func(clientID string, message []byte) error {
+		var msg Message
+		if err := json.Unmarshal(message, &msg); err != nil {
+			t.Fatalf("Failed to unmarshal sent message: %v", err)
+		}
+		sent = append(sent, msg)
+		return nil
+	}
+	process := func(clientID string, msg Message) Message {
+		t.Helper()
+		sent = nil
+		messageJSON, _ := json.Marshal(msg)
+		if err := sm.ProcessMessage(messageJSON, clientID, senderFunc); err != nil {
+			t.Fatalf("Process %s message failed: %v", msg.Type, err)
+		}
+		if len(sent) == 0 {
+			t.Fatalf("Expected a reply to the %s message", msg.Type)
+		}
+		return sent[0]
+	}
+	peersSince := func(clientID string, since uint64) Message {
+		payload, _ := json.Marshal(PeersRequestPayload{Since: since})
+		return process(clientID, Message{Type: Peers, Room: "webinar", Payload: payload})
+	}
+
+	// The server acknowledges the capabilities it supports
+	capable, _ := json.Marshal(JoinPayload{Capabilities: []string{"video-layers", CapabilityPeerListDelta}})
+	var joined JoinedPayload
+	json.Unmarshal(process("alice", Message{Type: Join, Room: "webinar", Payload: capable}).Payload, &joined)
+	if !reflect.DeepEqual(joined.Capabilities, []string{CapabilityPeerListDelta}) || joined.PeerListSeq != 1 {
+		t.Fatalf("Expected the delta capability and the peer list sequence, got %+v", joined)
+	}
+	joined = JoinedPayload{}
+	json.Unmarshal(process("bob", Message{Type: Join, Room: "webinar"}).Payload, &joined)
+	if joined.Capabilities != nil || joined.PeerListSeq != 0 {
+		t.Errorf("Expected no capabilities without declaring them, got %+v", joined)
+	}
+
+	process("carol", Message{Type: Join, Room: "webinar"})
+	leaveJSON, _ := json.Marshal(Message{Type: Leave, Room: "webinar"})
+	sm.ProcessMessage(leaveJSON, "bob", senderFunc)
+	muteJSON, _ := json.Marshal(Message{Type: Mute, Room: "webinar", Recipient: "carol"})
+	sm.ProcessMessage(muteJSON, "alice", senderFunc)
+
+	reply := peersSince("alice", 1)
+	var delta PeerListDeltaPayload
+	json.Unmarshal(reply.Payload, &delta)
+	want := PeerListDeltaPayload{
+		Since:   1,
+		Seq:     5,
+		Adds:    []PeerInfo{{ID: "carol", Role: RoleParticipant, Muted: true}},
+		Removes: []string{"bob"},
+	}
+	if reply.Type != PeerListDelta || !reflect.DeepEqual(delta, want) {
+		t.Errorf("Expected the changes since 1, got %s %+v", reply.Type, delta)
+	}
+
+	// Clients without the capability, and clients asking for changes that
+	// are not retained, get the full list
+	var peers PeersPayload
+	if reply := peersSince("carol", 1); reply.Type != Peers {
+		t.Errorf("Expected a full peer list without the capability, got %s", reply.Type)
+	}
+	for i := 0; i < maxPeerListChanges; i++ {
+		rename, _ := json.Marshal(RenamePayload{DisplayName: fmt.Sprintf("Carol %d", i)})
+		renameJSON, _ := json.Marshal(Message{Type: Rename, Room: "webinar", Payload: rename})
+		sm.ProcessMessage(renameJSON, "carol", senderFunc)
+	}
+	for _, since := range []uint64{1, 10000} {
+		reply := peersSince("alice", since)
+		json.Unmarshal(reply.Payload, &peers)
+		if reply.Type != Peers || len(peers.Peers) != 2 || peers.Seq != 5+maxPeerListChanges {
+			t.Errorf("Expected the full peer list since %d, got %s %+v", since, reply.Type, peers)
+		}
+	}
+}
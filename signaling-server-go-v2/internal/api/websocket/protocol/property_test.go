@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+	"pgregory.net/rapid"
+)
+
+// Small pools of clients and rooms, so that generated sequences frequently revisit the same rooms
+var (
+	propertyClients = rapid.SampledFrom([]string{"client-0", "client-1", "client-2", "client-3", "client-4"})
+	propertyRooms   = rapid.SampledFrom([]string{"room-0", "room-1", "room-2"})
+)
+
+// roomMachine applies random joins, leaves and disconnects to a
+// SignalingManager and to a model of the expected room membership: a plain map
+// of room ID to members
+type roomMachine struct {
+	sm    *SignalingManager
+	model map[string]map[string]struct{}
+}
+
+func newRoomMachine() *roomMachine {
+	return &roomMachine{
+		sm:    NewSignalingManager(testsupport.NewLogger()),
+		model: make(map[string]map[string]struct{}),
+	}
+}
+
+// actions returns the state machine actions for rapid.T.Repeat, with check run after every action
+func (m *roomMachine) actions(check func(*rapid.T)) map[string]func(*rapid.T) {
+	noop := func(string, []byte) error { return nil }
+
+	return map[string]func(*rapid.T){
+		"join": func(t *rapid.T) {
+			client, room := propertyClients.Draw(t, "client"), propertyRooms.Draw(t, "room")
+			data, _ := json.Marshal(Message{Type: Join, Room: room})
+			m.sm.ProcessMessage(data, client, noop)
+
+			if m.model[room] == nil {
+				m.model[room] = make(map[string]struct{})
+			}
+			m.model[room][client] = struct{}{}
+		},
+		"leave": func(t *rapid.T) {
+			client, room := propertyClients.Draw(t, "client"), propertyRooms.Draw(t, "room")
+			data, _ := json.Marshal(Message{Type: Leave, Room: room})
+			m.sm.ProcessMessage(data, client, noop)
+
+			if peers, ok := m.model[room]; ok {
+				delete(peers, client)
+				if len(peers) == 0 {
+					delete(m.model, room)
+				}
+			}
+		},
+		"disconnect": func(t *rapid.T) {
+			client := propertyClients.Draw(t, "client")
+			m.sm.RemoveClient(client)
+
+			for roomID, peers := range m.model {
+				delete(peers, client)
+				if len(peers) == 0 {
+					delete(m.model, roomID)
+				}
+			}
+		},
+		"": check,
+	}
+}
+
+// checkRoomInvariants returns the first violated invariant of the room state, if any
+func checkRoomInvariants(sm *SignalingManager) error {
+	rooms := sm.Snapshot()
+
+	if sm.GetRoomCount() != len(rooms) {
+		return fmt.Errorf("room count %d does not match %d rooms", sm.GetRoomCount(), len(rooms))
+	}
+
+	occupancy := sm.Occupancy()
+	peerCounts := make([]int, 0, len(rooms))
+	for _, room := range rooms {
+		peerCounts = append(peerCounts, len(room.Peers))
+	}
+	sort.Ints(peerCounts)
+	sort.Ints(occupancy.PeersPerRoom)
+	if occupancy.Rooms != len(rooms) || !reflect.DeepEqual(occupancy.PeersPerRoom, peerCounts) {
+		return fmt.Errorf("occupancy %+v does not match peer counts %v", occupancy, peerCounts)
+	}
+
+	for _, room := range rooms {
+		if len(room.Peers) == 0 {
+			return fmt.Errorf("empty room retained: %s", room.ID)
+		}
+		if !sm.RoomExists(room.ID) {
+			return fmt.Errorf("room %s listed but does not exist", room.ID)
+		}
+
+		peers := sm.GetPeersInRoom(room.ID)
+		sort.Strings(peers)
+		if !reflect.DeepEqual(peers, room.Peers) {
+			return fmt.Errorf("peers of room %s inconsistent: %v != %v", room.ID, peers, room.Peers)
+		}
+	}
+
+	return nil
+}
+
+func TestPropertyRoomInvariants(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		m := newRoomMachine()
+		t.Repeat(m.actions(func(t *rapid.T) {
+			if err := checkRoomInvariants(m.sm); err != nil {
+				t.Fatal(err)
+			}
+		}))
+	})
+}
+
+func TestPropertyMatchesModel(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		m := newRoomMachine()
+		t.Repeat(m.actions(func(t *rapid.T) {
+			if m.sm.GetRoomCount() != len(m.model) {
+				t.Fatalf("Expected %d rooms, got %d", len(m.model), m.sm.GetRoomCount())
+			}
+			for roomID, peers := range m.model {
+				actual := m.sm.GetPeersInRoom(roomID)
+				if len(actual) != len(peers) {
+					t.Fatalf("Expected %d peers in %s, got %v", len(peers), roomID, actual)
+				}
+				for _, peer := range actual {
+					if _, ok := peers[peer]; !ok {
+						t.Fatalf("Unexpected peer %s in %s", peer, roomID)
+					}
+				}
+			}
+		}))
+	})
+}
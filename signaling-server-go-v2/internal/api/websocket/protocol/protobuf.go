@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ProtobufSubprotocol is the subprotocol of binary frames encoded with the
+// Message schema in signaling.proto
+const ProtobufSubprotocol = "signaling.v1+protobuf"
+
+// Field numbers of the Message schema in signaling.proto
+const (
+	protoFieldType      = 1
+	protoFieldRoom      = 2
+	protoFieldSender    = 3
+	protoFieldRecipient = 4
+	protoFieldPayload   = 5
+	protoFieldPassword  = 6
+	protoFieldTrace     = 7
+	protoFieldMeta      = 8
+	protoFieldID        = 9
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// errTruncated is returned for frames ending in the middle of a field
+var errTruncated = errors.New("truncated protobuf frame")
+
+// ProtobufCodec encodes messages with the Message schema in signaling.proto.
+// The schema only has string and bytes fields, so the codec writes the wire
+// format directly rather than depending on generated code.
+type ProtobufCodec struct{}
+
+// Subprotocol implements Codec
+func (ProtobufCodec) Subprotocol() string {
+	return ProtobufSubprotocol
+}
+
+// Encode implements Codec. Empty fields are omitted, as proto3 does.
+func (ProtobufCodec) Encode(msg Message) ([]byte, error) {
+	var frame []byte
+	frame = appendProtoBytes(frame, protoFieldType, []byte(msg.Type))
+	frame = appendProtoBytes(frame, protoFieldRoom, []byte(msg.Room))
+	frame = appendProtoBytes(frame, protoFieldSender, []byte(msg.Sender))
+	frame = appendProtoBytes(frame, protoFieldRecipient, []byte(msg.Recipient))
+	frame = appendProtoBytes(frame, protoFieldPayload, msg.Payload)
+	frame = appendProtoBytes(frame, protoFieldPassword, []byte(msg.Password))
+	frame = appendProtoBytes(frame, protoFieldTrace, []byte(msg.Trace))
+	frame = appendProtoBytes(frame, protoFieldMeta, msg.Meta)
+	frame = appendProtoBytes(frame, protoFieldID, []byte(msg.ID))
+	return frame, nil
+}
+
+// Decode implements Codec. Unknown fields are skipped, so that clients may
+// use newer versions of the schema.
+func (ProtobufCodec) Decode(frame []byte) (Message, error) {
+	var msg Message
+	for len(frame) > 0 {
+		key, n := binary.Uvarint(frame)
+		if n <= 0 {
+			return Message{}, errTruncated
+		}
+		frame = frame[n:]
+
+		field, wireType := key>>3, key&7
+		if wireType != wireBytes {
+			var err error
+			if frame, err = skipProtoField(frame, wireType); err != nil {
+				return Message{}, err
+			}
+			continue
+		}
+
+		length, n := binary.Uvarint(frame)
+		if n <= 0 || length > uint64(len(frame)-n) {
+			return Message{}, errTruncated
+		}
+		value := frame[n : n+int(length)]
+		frame = frame[n+int(length):]
+
+		switch field {
+		case protoFieldType:
+			msg.Type = MessageType(value)
+		case protoFieldRoom:
+			msg.Room = string(value)
+		case protoFieldSender:
+			msg.Sender = string(value)
+		case protoFieldRecipient:
+			msg.Recipient = string(value)
+		case protoFieldPayload:
+			if !json.Valid(value) {
+				return Message{}, fmt.Errorf("payload is not valid JSON")
+			}
+			msg.Payload = append(json.RawMessage(nil), value...)
+		case protoFieldPassword:
+			msg.Password = string(value)
+		case protoFieldTrace:
+			msg.Trace = string(value)
+		case protoFieldMeta:
+			if !json.Valid(value) {
+				return Message{}, fmt.Errorf("meta is not valid JSON")
+			}
+			msg.Meta = append(json.RawMessage(nil), value...)
+		case protoFieldID:
+			msg.ID = string(value)
+		}
+	}
+	return msg, nil
+}
+
+// appendProtoBytes appends a length-delimited field, unless the value is empty
+func appendProtoBytes(frame []byte, field uint64, value []byte) []byte {
+	if len(value) == 0 {
+		return frame
+	}
+	frame = binary.AppendUvarint(frame, field<<3|wireBytes)
+	frame = binary.AppendUvarint(frame, uint64(len(value)))
+	return append(frame, value...)
+}
+
+// skipProtoField skips the value of a field of a wire type other than length-delimited
+func skipProtoField(frame []byte, wireType uint64) ([]byte, error) {
+	switch wireType {
+	case wireVarint:
+		if _, n := binary.Uvarint(frame); n > 0 {
+			return frame[n:], nil
+		}
+		return nil, errTruncated
+	case wireFixed64, wireFixed32:
+		size := 8
+		if wireType == wireFixed32 {
+			size = 4
+		}
+		if len(frame) < size {
+			return nil, errTruncated
+		}
+		return frame[size:], nil
+	default:
+		return nil, fmt.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+}
@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestProtobufCodec(t *testing.T) {
+	codec := ProtobufCodec{}
+	msg := Message{
+		Type:      Offer,
+		Room:      "call",
+		Sender:    "alice",
+		Recipient: "bob",
+		Payload:   json.RawMessage(`{"type":"offer","sdp":"v=0"}`),
+		Trace:     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		Meta:      json.RawMessage(`{"ts":1714564800123,"seq":7,"server":"node-1"}`),
+	}
+
+	frame, err := codec.Encode(msg)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := codec.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("Expected %+v after a round trip, got %+v", msg, decoded)
+	}
+
+	// The type field is field 1, length-delimited: key 0x0a, length 5, "offer"
+	if string(frame[:7]) != "\x0a\x05offer" {
+		t.Errorf("Unexpected encoding of the type field: %q", frame[:7])
+	}
+
+	// Fields of newer schema versions are skipped
+	extended := append(append([]byte{}, frame...), 0x40, 0x96, 0x01, 0x45, 1, 2, 3, 4)
+	if decoded, err := codec.Decode(extended); err != nil || !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("Expected unknown fields to be skipped, got %+v, %v", decoded, err)
+	}
+
+	for name, frame := range map[string][]byte{
+		"truncated value":  frame[:len(frame)-1],
+		"truncated length": {0x0a},
+		"invalid payload":  {0x2a, 0x03, '{', 'x', '}'},
+		"group wire type":  {0x0b},
+	} {
+		if _, err := codec.Decode(frame); err == nil {
+			t.Errorf("Expected an error decoding a frame with a %s", name)
+		}
+	}
+}
+
+func TestCodecTranscoding(t *testing.T) {
+	if _, err := NewCodec("xml"); err == nil {
+		t.Error("Expected an unknown encoding to be rejected")
+	}
+
+	message := []byte(`{"type":"join","room":"call","sender":"","payload":{"metadata":{"topic":"standup"}}}`)
+	for _, name := range []string{"protobuf", "msgpack"} {
+		codec, err := NewCodec(name)
+		if err != nil {
+			t.Fatalf("NewCodec(%q) failed: %v", name, err)
+		}
+
+		frame, err := EncodeJSON(codec, message)
+		if err != nil {
+			t.Fatalf("%s: EncodeJSON failed: %v", name, err)
+		}
+		back, err := DecodeJSON(codec, frame)
+		if err != nil {
+			t.Fatalf("%s: DecodeJSON failed: %v", name, err)
+		}
+		if string(back) != string(message) {
+			t.Errorf("%s: expected %s after a round trip, got %s", name, message, back)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+)
+
+// PeerIDObfuscator derives the pseudonymous peer IDs that the peers of a room
+// see instead of each other's client IDs, for privacy-sensitive deployments
+type PeerIDObfuscator interface {
+	// PeerID returns the pseudonym of a client in a room. It must be stable
+	// for the client within the room and unlinkable across rooms.
+	PeerID(roomID, clientID string) string
+}
+
+// HMACPeerIDs derives pseudonyms as a keyed hash of the room and client IDs.
+// Instances sharing the key derive the same pseudonyms, so that clients
+// connected to other instances of a cluster can be addressed.
+type HMACPeerIDs struct {
+	key []byte
+}
+
+// NewHMACPeerIDs creates an HMACPeerIDs deriving pseudonyms with the key
+func NewHMACPeerIDs(key []byte) *HMACPeerIDs {
+	return &HMACPeerIDs{key: key}
+}
+
+// PeerID implements PeerIDObfuscator
+func (h *HMACPeerIDs) PeerID(roomID, clientID string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(roomID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(clientID))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// peerIDs maps the pseudonyms of the local peers of each room back to their
+// client IDs, so that messages addressed to pseudonyms can be routed
+type peerIDs struct {
+	obfuscator PeerIDObfuscator
+	rooms      map[string]map[string]string
+	mutex      sync.Mutex
+}
+
+// WithPeerIDObfuscator exposes pseudonymous peer IDs to clients: peer lists
+// and relayed messages carry the pseudonyms of the peers in the room, and
+// clients address peers by their pseudonyms. Client IDs remain server-side.
+func WithPeerIDObfuscator(obfuscator PeerIDObfuscator) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.peerIDs = &peerIDs{
+			obfuscator: obfuscator,
+			rooms:      make(map[string]map[string]string),
+		}
+	}
+}
+
+// peerID returns the ID of a client exposed to the other peers of a room:
+// its pseudonym, or its client ID without obfuscation
+func (sm *SignalingManager) peerID(roomID, clientID string) string {
+	if sm.peerIDs == nil || clientID == "" {
+		return clientID
+	}
+	return sm.peerIDs.obfuscator.PeerID(roomID, clientID)
+}
+
+// exposePeers replaces the client IDs of peer infos with the IDs exposed to
+// the other peers of the room, sorted by them
+func (sm *SignalingManager) exposePeers(roomID string, peers []PeerInfo) []PeerInfo {
+	if sm.peerIDs == nil {
+		return peers
+	}
+	for i := range peers {
+		peers[i].ID = sm.peerID(roomID, peers[i].ID)
+	}
+	sortPeerInfos(peers)
+	return peers
+}
+
+// exposePeerIDs replaces client IDs with the IDs exposed to the peers of the
+// room, sorted
+func (sm *SignalingManager) exposePeerIDs(roomID string, clientIDs []string) []string {
+	if sm.peerIDs == nil {
+		return clientIDs
+	}
+	for i := range clientIDs {
+		clientIDs[i] = sm.peerID(roomID, clientIDs[i])
+	}
+	sort.Strings(clientIDs)
+	return clientIDs
+}
+
+// rememberPeerID records the pseudonym of a local peer that joined a room
+func (sm *SignalingManager) rememberPeerID(roomID, clientID string) {
+	if sm.peerIDs == nil {
+		return
+	}
+	p := sm.peerIDs
+	peerID := p.obfuscator.PeerID(roomID, clientID)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.rooms[roomID] == nil {
+		p.rooms[roomID] = make(map[string]string)
+	}
+	p.rooms[roomID][peerID] = clientID
+}
+
+// forgetPeerID drops the pseudonym of a local peer that left a room
+func (sm *SignalingManager) forgetPeerID(roomID, clientID string) {
+	if sm.peerIDs == nil {
+		return
+	}
+	p := sm.peerIDs
+	peerID := p.obfuscator.PeerID(roomID, clientID)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.rooms[roomID], peerID)
+	if len(p.rooms[roomID]) == 0 {
+		delete(p.rooms, roomID)
+	}
+}
+
+// resolvePeerID returns the client ID of the peer of a room a client
+// addressed by its exposed ID. Without obfuscation the IDs are client IDs.
+// Pseudonyms of peers connected to other instances are resolved against the
+// peers known to the backend.
+func (sm *SignalingManager) resolvePeerID(roomID, peerID string) (string, bool) {
+	if sm.peerIDs == nil {
+		return peerID, true
+	}
+	p := sm.peerIDs
+
+	p.mutex.Lock()
+	clientID, ok := p.rooms[roomID][peerID]
+	p.mutex.Unlock()
+	if ok {
+		return clientID, true
+	}
+
+	if lister, ok := sm.backend.(PeerLister); ok {
+		for _, peer := range lister.Peers(roomID) {
+			if p.obfuscator.PeerID(roomID, peer) == peerID {
+				return peer, true
+			}
+		}
+	}
+	return "", false
+}
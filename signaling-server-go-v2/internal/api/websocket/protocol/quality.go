@@ -0,0 +1,261 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// DefaultQualityThreshold is the room score below which calls are degraded
+// unless configured otherwise; a MOS of 3.5 is about where users start to
+// notice impairments
+const DefaultQualityThreshold = 3.5
+
+// qualitySmoothing is the weight of a new report in a peer's smoothed score
+const qualitySmoothing = 0.3
+
+// qualityHysteresis is how far above the threshold a degraded room's score
+// must climb to recover, so that a score hovering at the threshold does not
+// flap between degraded and recovered
+const qualityHysteresis = 0.25
+
+// QualityReportPayload is the payload of quality-report messages: the stats
+// of the sender's peer connections in the room, as reported by getStats()
+type QualityReportPayload struct {
+	RTT        float64 `json:"rtt"`        // round-trip time in milliseconds
+	Jitter     float64 `json:"jitter"`     // in milliseconds
+	PacketLoss float64 `json:"packetLoss"` // fraction of packets lost, from 0 to 1
+}
+
+// validate checks that the stats are in range
+func (p QualityReportPayload) validate() error {
+	if p.RTT < 0 || p.Jitter < 0 || math.IsNaN(p.RTT) || math.IsNaN(p.Jitter) {
+		return fmt.Errorf("rtt and jitter must not be negative")
+	}
+	if p.PacketLoss < 0 || p.PacketLoss > 1 || math.IsNaN(p.PacketLoss) {
+		return fmt.Errorf("packetLoss must be between 0 and 1")
+	}
+	return nil
+}
+
+// MOS estimates the mean opinion score of the reported stats, from 1 (bad)
+// to 4.5 (excellent), with a simplified E-model (ITU-T G.107)
+func (p QualityReportPayload) MOS() float64 {
+	// Jitter is weighted double, as jitter buffers add delay to absorb it
+	latency := p.RTT/2 + 2*p.Jitter + 10
+
+	r := 93.2
+	if latency < 160 {
+		r -= latency / 40
+	} else {
+		r -= (latency - 120) / 10
+	}
+	r -= 2.5 * p.PacketLoss * 100
+
+	if r < 0 {
+		return 1
+	}
+	return math.Min(4.5, math.Max(1, 1+0.035*r+0.000007*r*(r-60)*(100-r)))
+}
+
+// CallQuality is the quality of the call in a room, aggregated from the
+// quality reports of its peers
+type CallQuality struct {
+	Room string `json:"room"`
+
+	// Score is the mean of the peers' smoothed scores, and Min the lowest
+	Score float64 `json:"score"`
+	Min   float64 `json:"min"`
+
+	// Peers is the number of peers that reported, Reports the number of reports
+	Peers   int `json:"peers"`
+	Reports int `json:"reports"`
+
+	Degraded  bool      `json:"degraded"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// QualityHandler is called when the call in a room becomes degraded, its
+// score falling below the threshold, and when it recovers
+type QualityHandler func(quality CallQuality)
+
+// callQuality holds the smoothed scores of the peers of each room
+type callQuality struct {
+	threshold float64
+	handler   QualityHandler
+	rooms     map[string]*roomQuality
+	mutex     sync.Mutex
+}
+
+// roomQuality holds the smoothed scores of the peers of a room
+type roomQuality struct {
+	peers     map[string]float64
+	reports   int
+	degraded  bool
+	updatedAt time.Time
+}
+
+// WithCallQuality aggregates the quality reports of the peers of each room
+// into a score, calling the handler, if any, when a room's score falls below
+// the threshold and when it recovers
+func WithCallQuality(threshold float64, handler QualityHandler) ManagerOption {
+	return func(sm *SignalingManager) {
+		if threshold <= 0 {
+			threshold = DefaultQualityThreshold
+		}
+		sm.quality = &callQuality{
+			threshold: threshold,
+			handler:   handler,
+			rooms:     make(map[string]*roomQuality),
+		}
+	}
+}
+
+// handleQualityReport folds the stats reported by a peer into its room's score
+func (sm *SignalingManager) handleQualityReport(msg Message, clientID string, sender func(string, []byte) error) error {
+	if msg.Room == "" {
+		return errs.New(errs.InvalidArgument, "room ID is required for quality-report messages")
+	}
+	if sm.quality == nil {
+		// Clients may report regardless of whether scoring is enabled
+		return nil
+	}
+
+	var report QualityReportPayload
+	if err := json.Unmarshal(msg.Payload, &report); err != nil {
+		err = errs.Wrap(errs.InvalidArgument, "invalid quality report", err)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	if err := report.validate(); err != nil {
+		err = errs.Newf(errs.InvalidArgument, "invalid quality report: %v", err)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	if !sm.isLocalPeer(msg.Room, clientID) {
+		err := fmt.Errorf("%w: client %s in room %s", ErrNotInRoom, clientID, msg.Room)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	mos := report.MOS()
+	if sm.metrics != nil {
+		sm.metrics.CallQuality(mos)
+	}
+
+	quality, changed := sm.quality.record(msg.Room, clientID, mos, sm.now())
+	if !changed {
+		return nil
+	}
+
+	if quality.Degraded {
+		sm.logger.Warn("Call quality degraded", "room_id", msg.Room, "score", quality.Score, "min", quality.Min)
+		if sm.metrics != nil {
+			sm.metrics.CallQualityDegraded()
+		}
+	} else {
+		sm.logger.Info("Call quality recovered", "room_id", msg.Room, "score", quality.Score)
+	}
+	if sm.quality.handler != nil {
+		sm.quality.handler(quality)
+	}
+	return nil
+}
+
+// record folds a peer's score into its room's, reporting whether the room
+// became degraded or recovered
+func (q *callQuality) record(roomID, clientID string, mos float64, now time.Time) (CallQuality, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	room, ok := q.rooms[roomID]
+	if !ok {
+		room = &roomQuality{peers: make(map[string]float64)}
+		q.rooms[roomID] = room
+	}
+	if score, ok := room.peers[clientID]; ok {
+		mos = score + qualitySmoothing*(mos-score)
+	}
+	room.peers[clientID] = mos
+	room.reports++
+	room.updatedAt = now
+
+	quality := room.summary(roomID)
+	changed := false
+	switch {
+	case !room.degraded && quality.Score < q.threshold:
+		room.degraded, changed = true, true
+	case room.degraded && quality.Score >= q.threshold+qualityHysteresis:
+		room.degraded, changed = false, true
+	}
+	quality.Degraded = room.degraded
+	return quality, changed
+}
+
+// forget drops the score of a peer that left a room, and the room's once empty
+func (q *callQuality) forget(roomID, clientID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	room, ok := q.rooms[roomID]
+	if !ok {
+		return
+	}
+	delete(room.peers, clientID)
+	if len(room.peers) == 0 {
+		delete(q.rooms, roomID)
+	}
+}
+
+// summary aggregates the peers' scores. Must be called with the mutex held.
+func (r *roomQuality) summary(roomID string) CallQuality {
+	quality := CallQuality{
+		Room:      roomID,
+		Min:       math.Inf(1),
+		Peers:     len(r.peers),
+		Reports:   r.reports,
+		Degraded:  r.degraded,
+		UpdatedAt: r.updatedAt,
+	}
+	for _, score := range r.peers {
+		quality.Score += score
+		quality.Min = math.Min(quality.Min, score)
+	}
+	quality.Score /= float64(len(r.peers))
+	return quality
+}
+
+// CallQualities returns the call quality of the rooms with quality reports,
+// worst first
+func (sm *SignalingManager) CallQualities() []CallQuality {
+	if sm.quality == nil {
+		return []CallQuality{}
+	}
+
+	sm.quality.mutex.Lock()
+	qualities := make([]CallQuality, 0, len(sm.quality.rooms))
+	for roomID, room := range sm.quality.rooms {
+		qualities = append(qualities, room.summary(roomID))
+	}
+	sm.quality.mutex.Unlock()
+
+	sort.Slice(qualities, func(i, j int) bool {
+		if qualities[i].Score != qualities[j].Score {
+			return qualities[i].Score < qualities[j].Score
+		}
+		return qualities[i].Room < qualities[j].Room
+	})
+	return qualities
+}
+
+// forgetQuality drops the score of a peer that left a room
+func (sm *SignalingManager) forgetQuality(roomID, clientID string) {
+	if sm.quality != nil {
+		sm.quality.forget(roomID, clientID)
+	}
+}
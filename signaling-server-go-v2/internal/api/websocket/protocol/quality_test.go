@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestMOS(t *testing.T) {
+	good := QualityReportPayload{RTT: 40, Jitter: 5}.MOS()
+	if good < 4 || good > 4.5 {
+		t.Errorf("Expected a clean call to score above 4, got %.2f", good)
+	}
+	lossy := QualityReportPayload{RTT: 40, Jitter: 5, PacketLoss: 0.1}.MOS()
+	if lossy >= good || lossy > 3.6 {
+		t.Errorf("Expected 10%% packet loss to score lower, got %.2f", lossy)
+	}
+	if bad := (QualityReportPayload{RTT: 800, Jitter: 100, PacketLoss: 0.5}).MOS(); bad != 1 {
+		t.Errorf("Expected an unusable call to score 1, got %.2f", bad)
+	}
+}
+
+func TestCallQuality(t *testing.T) {
+	var notified []CallQuality
+	sm := NewSignalingManager(testsupport.NewLogger(), WithCallQuality(3.5, func(quality CallQuality) {
+		notified = append(notified, quality)
+	}))
+	sender := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "call"})
+	sm.ProcessMessage(joinJSON, "alice", sender)
+	sm.ProcessMessage(joinJSON, "bob", sender)
+
+	report := func(clientID string, stats QualityReportPayload) error {
+		payload, _ := json.Marshal(stats)
+		reportJSON, _ := json.Marshal(Message{Type: QualityReport, Room: "call", Payload: payload})
+		return sm.ProcessMessage(reportJSON, clientID, sender)
+	}
+
+	clean := QualityReportPayload{RTT: 40, Jitter: 5}
+	lossy := QualityReportPayload{RTT: 300, Jitter: 40, PacketLoss: 0.2}
+	report("alice", clean)
+	report("bob", clean)
+	if len(notified) != 0 {
+		t.Fatalf("Expected no notification for a good call, got %+v", notified)
+	}
+
+	// A single bad report is smoothed, repeated ones degrade the room
+	for i := 0; i < 10 && len(notified) == 0; i++ {
+		report("bob", lossy)
+	}
+	if len(notified) != 1 || !notified[0].Degraded || notified[0].Peers != 2 {
+		t.Fatalf("Expected the room to be degraded once, got %+v", notified)
+	}
+	qualities := sm.CallQualities()
+	if len(qualities) != 1 || !qualities[0].Degraded || qualities[0].Min >= qualities[0].Score {
+		t.Errorf("Expected the degraded room in the qualities, got %+v", qualities)
+	}
+
+	for i := 0; i < 20 && len(notified) == 1; i++ {
+		report("bob", clean)
+	}
+	if len(notified) != 2 || notified[1].Degraded {
+		t.Fatalf("Expected the room to recover, got %+v", notified)
+	}
+
+	// Reports are validated and only accepted from peers of the room
+	if err := report("bob", QualityReportPayload{PacketLoss: 2}); err == nil {
+		t.Error("Expected an out of range packet loss to be rejected")
+	}
+	if err := report("mallory", clean); err == nil {
+		t.Error("Expected a report from outside the room to be rejected")
+	}
+
+	// Scores are dropped with the peers that leave
+	leaveJSON, _ := json.Marshal(Message{Type: Leave, Room: "call"})
+	sm.ProcessMessage(leaveJSON, "alice", sender)
+	sm.ProcessMessage(leaveJSON, "bob", sender)
+	if qualities := sm.CallQualities(); len(qualities) != 0 {
+		t.Errorf("Expected no qualities once the room is empty, got %+v", qualities)
+	}
+}
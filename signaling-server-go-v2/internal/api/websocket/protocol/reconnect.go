@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ReconnectPayload is the payload of please-reconnect requests. Clients
+// reconnect at a time of their choosing before the deadline, e.g. once a call
+// ends; ReconnectIn spares them comparing the deadline with a skewed clock.
+type ReconnectPayload struct {
+	Deadline    time.Time `json:"deadline"`
+	ReconnectIn int64     `json:"reconnectIn"` // in milliseconds
+	Message     string    `json:"message,omitempty"`
+}
+
+// NewReconnectRequest builds a please-reconnect request asking a client to
+// reconnect within reconnectIn of now
+func NewReconnectRequest(now time.Time, reconnectIn time.Duration, message string) ([]byte, error) {
+	payload, err := json.Marshal(ReconnectPayload{
+		Deadline:    now.Add(reconnectIn).UTC(),
+		ReconnectIn: reconnectIn.Milliseconds(),
+		Message:     message,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Message{Type: PleaseReconnect, Payload: payload})
+}
@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// ErrRoomNotRestorable is returned when restoring a room that was not closed
+// by an operator or whose retention window has passed
+var ErrRoomNotRestorable = errs.New(errs.NotFound, "room cannot be restored")
+
+// RoomRestoredMessage is the text of the room-restored notices
+const RoomRestoredMessage = "The room was restored, rejoin to continue"
+
+// WithClosedRoomRetention keeps the state of rooms closed by an operator for
+// the retention, so that rooms closed by mistake can be restored
+func WithClosedRoomRetention(retention time.Duration) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.closedRetention = retention
+	}
+}
+
+// closedRoom is the state of a room closed by an operator
+type closedRoom struct {
+	record   roomRecord
+	reason   string
+	closedAt time.Time
+}
+
+// ClosedRoom describes a room closed by an operator that can be restored
+type ClosedRoom struct {
+	ID              string    `json:"id"`
+	Peers           []string  `json:"peers"` // in join order
+	Reason          string    `json:"reason,omitempty"`
+	ClosedAt        time.Time `json:"closedAt"`
+	RestorableUntil time.Time `json:"restorableUntil"`
+}
+
+// describe returns the description of a closed room
+func (c *closedRoom) describe(retention time.Duration) ClosedRoom {
+	return ClosedRoom{
+		ID:              c.record.ID,
+		Peers:           append([]string{}, c.record.Peers...),
+		Reason:          c.reason,
+		ClosedAt:        c.closedAt,
+		RestorableUntil: c.closedAt.Add(retention),
+	}
+}
+
+// retainClosedLocked keeps the state of a room closed by an operator, if
+// closed rooms are retained. Must be called with the manager mutex held.
+func (sm *SignalingManager) retainClosedLocked(rec roomRecord, reason string) {
+	if sm.closedRetention <= 0 {
+		return
+	}
+	sm.pruneClosedLocked()
+	sm.closed[rec.ID] = &closedRoom{record: rec, reason: reason, closedAt: sm.now()}
+}
+
+// pruneClosedLocked forgets the closed rooms whose retention has passed.
+// Must be called with the manager mutex held.
+func (sm *SignalingManager) pruneClosedLocked() {
+	cutoff := sm.now().Add(-sm.closedRetention)
+	for roomID, closed := range sm.closed {
+		if closed.closedAt.Before(cutoff) {
+			delete(sm.closed, roomID)
+		}
+	}
+}
+
+// ClosedRooms returns the rooms closed by an operator that can still be
+// restored, most recently closed first
+func (sm *SignalingManager) ClosedRooms() []ClosedRoom {
+	sm.mutex.Lock()
+	sm.pruneClosedLocked()
+	rooms := make([]ClosedRoom, 0, len(sm.closed))
+	for _, closed := range sm.closed {
+		rooms = append(rooms, closed.describe(sm.closedRetention))
+	}
+	sm.mutex.Unlock()
+
+	sort.Slice(rooms, func(i, j int) bool {
+		if !rooms[i].ClosedAt.Equal(rooms[j].ClosedAt) {
+			return rooms[i].ClosedAt.After(rooms[j].ClosedAt)
+		}
+		return rooms[i].ID < rooms[j].ID
+	})
+	return rooms
+}
+
+// RestoreRoom re-creates a room closed by an operator within the retention,
+// with its metadata and password, and sends its previous peers a
+// room-restored notice so that they rejoin. Clients disconnected since get
+// the notice when they resume their session. Roles and the lock are not
+// restored; the room is owned by its first peer to rejoin.
+func (sm *SignalingManager) RestoreRoom(roomID string) (ClosedRoom, error) {
+	sm.mutex.Lock()
+	sm.pruneClosedLocked()
+	closed, ok := sm.closed[roomID]
+	if !ok {
+		sm.mutex.Unlock()
+		return ClosedRoom{}, fmt.Errorf("%w: %s was not closed by an operator in the last %s", ErrRoomNotRestorable, roomID, sm.closedRetention)
+	}
+	if _, ok := sm.rooms[roomID]; ok {
+		sm.mutex.Unlock()
+		return ClosedRoom{}, fmt.Errorf("%w: %s", ErrRoomExists, roomID)
+	}
+	if _, ok := sm.spilled[roomID]; ok {
+		sm.mutex.Unlock()
+		return ClosedRoom{}, fmt.Errorf("%w: %s", ErrRoomExists, roomID)
+	}
+
+	delete(sm.closed, roomID)
+	sm.rooms[roomID] = &Room{
+		ID:           roomID,
+		Peers:        make(map[string]struct{}),
+		Metadata:     closed.record.Metadata,
+		passwordHash: closed.record.PasswordHash,
+		lastActivity: sm.now(),
+		createdAt:    sm.now(),
+	}
+	sm.limitRoomLocked(roomID, sm.policyFor(roomID))
+	sm.emit(ActivityEvent{Type: ActivityRoomCreated, Room: roomID, Reason: "restored"})
+	sm.mutex.Unlock()
+
+	restored := closed.describe(sm.closedRetention)
+	notice, err := NewNotice(RoomRestored, roomID, RoomRestoredMessage)
+	if err != nil {
+		sm.logger.Error("Failed to marshal room restored notice", "error", err)
+	} else {
+		sm.notifyPeers(restored.Peers, notice)
+	}
+
+	sm.logger.Info("Room restored", "room_id", roomID, "peers", len(restored.Peers))
+	return restored, nil
+}
@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestRestoreRoom(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	conns := testsupport.NewWebSocketHandler()
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithConnections(conns),
+		WithClosedRoomRetention(15*time.Minute),
+	)
+	noop := func(string, []byte) error { return nil }
+
+	sm.CreateRoom("acme/standup", json.RawMessage(`{"topic":"daily"}`))
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "acme/standup"})
+	sm.ProcessMessage(joinJSON, "alice", noop)
+	sm.ProcessMessage(joinJSON, "bob", noop)
+	sm.CloseRooms("acme/**", "closed by operator", false)
+
+	closed := sm.ClosedRooms()
+	if len(closed) != 1 || closed[0].ID != "acme/standup" || !reflect.DeepEqual(closed[0].Peers, []string{"alice", "bob"}) {
+		t.Fatalf("Expected the closed room with its peers, got %+v", closed)
+	}
+	if !closed[0].RestorableUntil.Equal(now.Add(15 * time.Minute)) {
+		t.Errorf("Expected the room to be restorable for the retention, got %v", closed[0].RestorableUntil)
+	}
+
+	now = now.Add(10 * time.Minute)
+	restored, err := sm.RestoreRoom("acme/standup")
+	if err != nil {
+		t.Fatalf("RestoreRoom failed: %v", err)
+	}
+	if !reflect.DeepEqual(restored.Peers, []string{"alice", "bob"}) {
+		t.Errorf("Expected the previous peers, got %v", restored.Peers)
+	}
+	if metadata, ok := sm.GetRoomMetadata("acme/standup"); !ok || string(metadata) != `{"topic":"daily"}` {
+		t.Errorf("Expected the room to be re-created with its metadata, got %s", metadata)
+	}
+	if peers := sm.GetPeersInRoom("acme/standup"); len(peers) != 0 {
+		t.Errorf("Expected the peers to rejoin themselves, got %v", peers)
+	}
+	for _, peer := range []string{"alice", "bob"} {
+		sent := conns.Sent[peer]
+		var msg Message
+		json.Unmarshal(sent[len(sent)-1], &msg)
+		if msg.Type != RoomRestored || msg.Room != "acme/standup" {
+			t.Errorf("Expected %s to be notified of the restore, got %+v", peer, msg)
+		}
+	}
+
+	// A room is restored once
+	if _, err := sm.RestoreRoom("acme/standup"); !errors.Is(err, ErrRoomNotRestorable) {
+		t.Errorf("Expected ErrRoomNotRestorable, got %v", err)
+	}
+
+	// Rooms are forgotten after the retention
+	sm.CloseRooms("acme/standup", "", false)
+	now = now.Add(16 * time.Minute)
+	if _, err := sm.RestoreRoom("acme/standup"); !errors.Is(err, ErrRoomNotRestorable) {
+		t.Errorf("Expected the room to be forgotten after the retention, got %v", err)
+	}
+	if closed := sm.ClosedRooms(); len(closed) != 0 {
+		t.Errorf("Expected no closed rooms, got %+v", closed)
+	}
+}
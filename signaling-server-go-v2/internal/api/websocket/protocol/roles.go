@@ -0,0 +1,321 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// Role is the role of a peer within a room
+type Role string
+
+const (
+	// RoleOwner is held by the creator of a room and may moderate and grant roles
+	RoleOwner Role = "owner"
+
+	// RoleModerator may kick, ban and mute participants and lock the room
+	RoleModerator Role = "moderator"
+
+	// RoleParticipant is the default role of a peer
+	RoleParticipant Role = "participant"
+
+	// RoleObserver receives the room's events and broadcasts, and may answer
+	// the offers of publishers to receive their media, but may not send
+	// offers or broadcasts itself, e.g. the viewers of a webinar. Observers
+	// never own a room.
+	RoleObserver Role = "observer"
+)
+
+// rank orders roles by privilege; a peer may only moderate peers of lower rank
+func (r Role) rank() int {
+	switch r {
+	case RoleOwner:
+		return 2
+	case RoleModerator:
+		return 1
+	case RoleObserver:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// RolePayload is the payload of a grant-role message
+type RolePayload struct {
+	Role Role `json:"role"`
+}
+
+// PeerInfo describes a peer in a room's peer list
+type PeerInfo struct {
+	ID          string `json:"id"`
+	Role        Role   `json:"role"`
+	Muted       bool   `json:"muted,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// roleOf returns the role of a client in the room. Must be called with the room mutex held.
+func (r *Room) roleOf(clientID string) Role {
+	if clientID != "" && clientID == r.Owner {
+		return RoleOwner
+	}
+	if role, ok := r.roles[clientID]; ok {
+		return role
+	}
+	return RoleParticipant
+}
+
+// setRole sets the role of a peer other than the owner. Must be called with
+// the room mutex held.
+func (r *Room) setRole(clientID string, role Role) {
+	r.peerChanged(clientID)
+	if role == RoleParticipant {
+		delete(r.roles, clientID)
+		return
+	}
+	if r.roles == nil {
+		r.roles = make(map[string]Role)
+	}
+	r.roles[clientID] = role
+}
+
+// canModerate reports whether a client may moderate the target peer, which
+// requires the moderator role or higher and a higher rank than the target.
+// Must be called with the room mutex held.
+func (r *Room) canModerate(clientID, targetID string) bool {
+	role := r.roleOf(clientID)
+	return role.rank() >= RoleModerator.rank() && role.rank() > r.roleOf(targetID).rank()
+}
+
+// removePeer removes a peer with its role, mute state and display name. If the peer owned
+// the room, ownership passes to the earliest joined moderator, or else the
+// earliest joined peer that is not an observer. Must be called with the room mutex held.
+func (r *Room) removePeer(clientID string) {
+	r.peerChanged(clientID)
+	delete(r.Peers, clientID)
+	delete(r.roles, clientID)
+	delete(r.muted, clientID)
+	delete(r.batchedJoins, clientID)
+	r.releaseName(clientID)
+
+	for i, peer := range r.joinOrder {
+		if peer == clientID {
+			r.joinOrder = append(r.joinOrder[:i], r.joinOrder[i+1:]...)
+			break
+		}
+	}
+
+	if r.Owner == clientID {
+		r.electOwner()
+	}
+}
+
+// electOwner passes ownership of the room to the earliest joined moderator,
+// or else the earliest joined peer that is not an observer, leaving the room
+// without owner if there is none. Must be called with the room mutex held.
+func (r *Room) electOwner() {
+	r.Owner = ""
+	for _, peer := range r.joinOrder {
+		if r.roles[peer] == RoleModerator {
+			r.Owner = peer
+			break
+		}
+	}
+	for _, peer := range r.joinOrder {
+		if r.Owner != "" {
+			break
+		}
+		if r.roles[peer] != RoleObserver {
+			r.Owner = peer
+		}
+	}
+	delete(r.roles, r.Owner)
+	if r.Owner != "" {
+		r.peerChanged(r.Owner)
+	}
+}
+
+// peerInfos returns the room's peers with their roles, sorted by ID. Must be called with the room mutex held.
+func (r *Room) peerInfos() []PeerInfo {
+	peers := make([]PeerInfo, 0, len(r.Peers))
+	for peer := range r.Peers {
+		peers = append(peers, r.peerInfo(peer))
+	}
+
+	sortPeerInfos(peers)
+	return peers
+}
+
+// peerInfo returns the info of a peer of the room. Must be called with the room mutex held.
+func (r *Room) peerInfo(peer string) PeerInfo {
+	_, muted := r.muted[peer]
+	return PeerInfo{ID: peer, Role: r.roleOf(peer), Muted: muted, DisplayName: r.names[peer]}
+}
+
+// sortPeerInfos sorts peer infos by ID
+func sortPeerInfos(peers []PeerInfo) {
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID < peers[j].ID
+	})
+}
+
+// handleGrantRole assigns a role to a peer of the room. Only the owner may
+// grant roles; granting the owner role transfers ownership and demotes the
+// previous owner to moderator.
+func (sm *SignalingManager) handleGrantRole(msg Message, clientID string, sender func(string, []byte) error) error {
+	if msg.Room == "" || msg.Recipient == "" {
+		return errs.New(errs.InvalidArgument, "room and recipient are required for grant-role messages")
+	}
+
+	var grant RolePayload
+	if err := json.Unmarshal(msg.Payload, &grant); err != nil {
+		err = errs.Wrap(errs.InvalidArgument, "invalid grant-role payload", err)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	if grant.Role != RoleOwner && grant.Role != RoleModerator && grant.Role != RoleParticipant && grant.Role != RoleObserver {
+		err := errs.Wrap(errs.InvalidArgument, "unknown role", fmt.Errorf("unknown role: %s", grant.Role))
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[msg.Room]
+	if !ok {
+		err := fmt.Errorf("%w: %s", ErrRoomNotFound, msg.Room)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if room.roleOf(clientID) != RoleOwner {
+		err := errs.Wrap(errs.PermissionDenied, "only the room owner may grant roles", fmt.Errorf("client %s is not the owner of room: %s", clientID, msg.Room))
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	if _, ok := room.Peers[msg.Recipient]; !ok {
+		err := fmt.Errorf("%w: peer %s in room %s", ErrPeerNotInRoom, msg.Recipient, msg.Room)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	if msg.Recipient == clientID {
+		err := errs.Wrap(errs.InvalidArgument, "cannot change your own role", fmt.Errorf("client %s attempted to change its own role", clientID))
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	if grant.Role == RoleOwner {
+		room.Owner = msg.Recipient
+		room.setRole(msg.Recipient, RoleParticipant)
+		room.setRole(clientID, RoleModerator)
+	} else {
+		room.setRole(msg.Recipient, grant.Role)
+	}
+
+	sm.logger.Info("Role granted", "client_id", msg.Recipient, "role", grant.Role, "granted_by", clientID, "room_id", msg.Room)
+	return nil
+}
+
+// handleLockRoom locks or unlocks a room; a locked room rejects joins from new peers
+func (sm *SignalingManager) handleLockRoom(msg Message, clientID string, sender func(string, []byte) error) error {
+	if msg.Room == "" {
+		return errs.Newf(errs.InvalidArgument, "room ID is required for %s messages", msg.Type)
+	}
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[msg.Room]
+	if !ok {
+		err := fmt.Errorf("%w: %s", ErrRoomNotFound, msg.Room)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if room.roleOf(clientID).rank() < RoleModerator.rank() {
+		err := errs.Wrap(errs.PermissionDenied, "not allowed to moderate this room", fmt.Errorf("client %s cannot moderate room: %s", clientID, msg.Room))
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	room.locked = msg.Type == LockRoom
+	sm.logger.Info("Room lock changed", "room_id", msg.Room, "locked", room.locked, "client_id", clientID)
+	return nil
+}
+
+// handleMute records a peer as muted or unmuted and forwards the message to
+// it, so the client can stop or resume sending media
+func (sm *SignalingManager) handleMute(msg Message, clientID string, receivedAt time.Time, sender func(string, []byte) error) error {
+	if msg.Room == "" || msg.Recipient == "" {
+		return errs.Newf(errs.InvalidArgument, "room and recipient are required for %s messages", msg.Type)
+	}
+
+	if err := sm.setMuted(msg, clientID); err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	return sm.relayMessage(msg, receivedAt, sender)
+}
+
+// setMuted updates the muted state of the recipient after checking the
+// sender's permission
+func (sm *SignalingManager) setMuted(msg Message, clientID string) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[msg.Room]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, msg.Room)
+	}
+
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if _, ok := room.Peers[msg.Recipient]; !ok {
+		return fmt.Errorf("%w: peer %s in room %s", ErrPeerNotInRoom, msg.Recipient, msg.Room)
+	}
+	if !room.canModerate(clientID, msg.Recipient) {
+		return errs.Wrap(errs.PermissionDenied, "not allowed to moderate this peer", fmt.Errorf("client %s cannot moderate %s in room: %s", clientID, msg.Recipient, msg.Room))
+	}
+
+	if msg.Type == Mute {
+		if room.muted == nil {
+			room.muted = make(map[string]struct{})
+		}
+		room.muted[msg.Recipient] = struct{}{}
+	} else {
+		delete(room.muted, msg.Recipient)
+	}
+	room.peerChanged(msg.Recipient)
+
+	sm.logger.Info("Peer mute changed", "client_id", msg.Recipient, "muted", msg.Type == Mute, "moderator", clientID, "room_id", msg.Room)
+	return nil
+}
+
+// GetPeerInfos returns the peers of a room with their roles, sorted by ID
+func (sm *SignalingManager) GetPeerInfos(roomID string) []PeerInfo {
+	sm.rehydrate(roomID)
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		return []PeerInfo{}
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	return room.peerInfos()
+}
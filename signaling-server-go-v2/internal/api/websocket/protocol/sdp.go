@@ -0,0 +1,253 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SDPPolicy restricts the session descriptions relayed in offers and answers
+type SDPPolicy struct {
+	// MaxSize is the largest SDP accepted in bytes, 0 for no limit
+	MaxSize int
+
+	// AllowedCodecs lists the codecs that may be negotiated, e.g. "opus" or
+	// "VP8", empty to allow all codecs not denied. Codecs are matched by the
+	// encoding name of their rtpmap attribute, ignoring case.
+	AllowedCodecs []string
+
+	// DeniedCodecs lists the codecs removed from the media descriptions
+	DeniedCodecs []string
+
+	// StripAttributes lists the attributes removed from the SDP, e.g. "extmap-allow-mixed"
+	StripAttributes []string
+}
+
+// WithSDPPolicy validates and sanitizes the SDP of relayed offers and
+// answers, including renegotiations and ICE restarts. Messages with invalid
+// SDP are answered with an error instead of being relayed.
+func WithSDPPolicy(policy SDPPolicy) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.sdpPolicy = &policy
+	}
+}
+
+// sanitizeDescription validates the SDP of an offer or answer payload, a
+// session description such as {"type":"offer","sdp":"v=0..."}, and returns the
+// payload with the SDP sanitized according to the policy
+func (p *SDPPolicy) sanitizeDescription(payload json.RawMessage) (json.RawMessage, error) {
+	var description map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &description); err != nil {
+		return nil, fmt.Errorf("payload is not a session description")
+	}
+	var sdp string
+	if err := json.Unmarshal(description["sdp"], &sdp); err != nil || sdp == "" {
+		return nil, fmt.Errorf("session description has no sdp")
+	}
+
+	sanitized, err := p.sanitize(sdp)
+	if err != nil {
+		return nil, err
+	}
+
+	description["sdp"], err = json.Marshal(sanitized)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(description)
+}
+
+// mediaSection is a media description of an SDP: its m= line and the lines up to the next one
+type mediaSection struct {
+	media []string // the fields of the m= line
+	lines []string
+}
+
+// sanitize validates an SDP and removes the denied codecs and stripped attributes
+func (p *SDPPolicy) sanitize(sdp string) (string, error) {
+	if p.MaxSize > 0 && len(sdp) > p.MaxSize {
+		return "", fmt.Errorf("SDP exceeds %d bytes", p.MaxSize)
+	}
+
+	lines := strings.Split(strings.TrimRight(sdp, "\r\n"), "\n")
+	var session []string
+	var sections []*mediaSection
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) < 2 || line[1] != '=' || line[0] < 'a' || line[0] > 'z' {
+			return "", fmt.Errorf("malformed SDP line %d", i+1)
+		}
+		if i == 0 && line != "v=0" {
+			return "", fmt.Errorf("SDP must start with v=0")
+		}
+		if p.stripped(line) {
+			continue
+		}
+
+		if line[0] == 'm' {
+			media := strings.Fields(line[2:])
+			if len(media) < 4 {
+				return "", fmt.Errorf("malformed media description on line %d", i+1)
+			}
+			sections = append(sections, &mediaSection{media: media})
+		}
+		if len(sections) == 0 {
+			session = append(session, line)
+		} else {
+			section := sections[len(sections)-1]
+			section.lines = append(section.lines, line)
+		}
+	}
+	if !hasLine(session, 'o') || !hasLine(session, 's') {
+		return "", fmt.Errorf("SDP lacks an origin or session name")
+	}
+	if len(sections) == 0 {
+		return "", fmt.Errorf("SDP has no media descriptions")
+	}
+
+	out := session
+	for _, section := range sections {
+		if err := p.filterCodecs(section); err != nil {
+			return "", err
+		}
+		out = append(out, section.lines...)
+	}
+	return strings.Join(out, "\r\n") + "\r\n", nil
+}
+
+// filterCodecs removes the payload types of codecs the policy does not allow
+// from an RTP media section, along with their attributes and the
+// retransmission formats associated with them
+func (p *SDPPolicy) filterCodecs(section *mediaSection) error {
+	if !strings.Contains(section.media[2], "RTP") {
+		return nil
+	}
+
+	removed := make(map[string]struct{})
+	for _, line := range section.lines {
+		if pt, codec, ok := rtpmap(line); ok && !p.codecAllowed(codec) {
+			removed[pt] = struct{}{}
+		}
+	}
+	for _, line := range section.lines {
+		// RTX formats reference the format they retransmit with apt=
+		if pt, apt, ok := fmtpAssociated(line); ok {
+			if _, ok := removed[apt]; ok {
+				removed[pt] = struct{}{}
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	formats := make([]string, 0, len(section.media)-3)
+	for _, pt := range section.media[3:] {
+		if _, ok := removed[pt]; !ok {
+			formats = append(formats, pt)
+		}
+	}
+	if len(formats) == 0 {
+		return fmt.Errorf("no allowed codecs in %s media description", section.media[0])
+	}
+
+	lines := make([]string, 0, len(section.lines))
+	lines = append(lines, "m="+strings.Join(append(section.media[:3:3], formats...), " "))
+	for _, line := range section.lines[1:] {
+		if pt, ok := formatOf(line); ok {
+			if _, ok := removed[pt]; ok {
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	section.lines = lines
+	return nil
+}
+
+// codecAllowed reports whether the policy allows a codec
+func (p *SDPPolicy) codecAllowed(codec string) bool {
+	for _, denied := range p.DeniedCodecs {
+		if strings.EqualFold(codec, denied) {
+			return false
+		}
+	}
+	if len(p.AllowedCodecs) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedCodecs {
+		if strings.EqualFold(codec, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripped reports whether a line is an attribute the policy strips
+func (p *SDPPolicy) stripped(line string) bool {
+	if !strings.HasPrefix(line, "a=") {
+		return false
+	}
+	name := line[2:]
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name = name[:i]
+	}
+	for _, attribute := range p.StripAttributes {
+		if name == attribute {
+			return true
+		}
+	}
+	return false
+}
+
+// rtpmap parses an a=rtpmap:<payload type> <encoding name>/<clock rate> line
+func rtpmap(line string) (pt, codec string, ok bool) {
+	if !strings.HasPrefix(line, "a=rtpmap:") {
+		return "", "", false
+	}
+	fields := strings.Fields(line[len("a=rtpmap:"):])
+	if len(fields) != 2 {
+		return "", "", false
+	}
+	codec, _, _ = strings.Cut(fields[1], "/")
+	return fields[0], codec, true
+}
+
+// fmtpAssociated parses the associated payload type of an a=fmtp:<payload type> apt=<payload type> line
+func fmtpAssociated(line string) (pt, apt string, ok bool) {
+	if !strings.HasPrefix(line, "a=fmtp:") {
+		return "", "", false
+	}
+	pt, params, found := strings.Cut(line[len("a=fmtp:"):], " ")
+	if !found {
+		return "", "", false
+	}
+	for _, param := range strings.Split(params, ";") {
+		if value, found := strings.CutPrefix(strings.TrimSpace(param), "apt="); found {
+			return pt, value, true
+		}
+	}
+	return "", "", false
+}
+
+// formatOf returns the payload type of a format specific attribute line:
+// a=rtpmap, a=fmtp or a=rtcp-fb
+func formatOf(line string) (string, bool) {
+	for _, prefix := range []string{"a=rtpmap:", "a=fmtp:", "a=rtcp-fb:"} {
+		if strings.HasPrefix(line, prefix) {
+			pt, _, _ := strings.Cut(line[len(prefix):], " ")
+			return pt, true
+		}
+	}
+	return "", false
+}
+
+// hasLine reports whether any line is of the given type
+func hasLine(lines []string, typ byte) bool {
+	for _, line := range lines {
+		if line[0] == typ {
+			return true
+		}
+	}
+	return false
+}
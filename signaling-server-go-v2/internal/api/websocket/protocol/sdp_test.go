@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// testOffer is an offer with an audio and a video section, VP8 and H264 with their RTX formats
+const testOffer = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=extmap-allow-mixed\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 102 103\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtcp-fb:96 nack\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtpmap:102 H264/90000\r\n" +
+	"a=fmtp:102 profile-level-id=42001f\r\n" +
+	"a=rtpmap:103 rtx/90000\r\n" +
+	"a=fmtp:103 apt=102\r\n"
+
+func TestSDPSanitize(t *testing.T) {
+	policy := SDPPolicy{DeniedCodecs: []string{"h264"}, StripAttributes: []string{"extmap-allow-mixed"}}
+
+	sdp, err := policy.sanitize(testOffer)
+	if err != nil {
+		t.Fatalf("Expected a valid offer, got %v", err)
+	}
+	if !strings.Contains(sdp, "m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n") {
+		t.Errorf("Expected H264 and its RTX format to be removed from the media line, got %q", sdp)
+	}
+	for _, removed := range []string{"H264", "a=fmtp:102", "a=fmtp:103", "extmap-allow-mixed"} {
+		if strings.Contains(sdp, removed) {
+			t.Errorf("Expected %q to be stripped, got %q", removed, sdp)
+		}
+	}
+	if !strings.Contains(sdp, "a=rtcp-fb:96 nack\r\n") || !strings.Contains(sdp, "a=rtpmap:111 opus/48000/2\r\n") {
+		t.Errorf("Expected the allowed codecs to be kept, got %q", sdp)
+	}
+
+	// An allow list removing every codec of a section rejects the SDP
+	if _, err := (&SDPPolicy{AllowedCodecs: []string{"VP8", "rtx"}}).sanitize(testOffer); err == nil {
+		t.Error("Expected an audio section without allowed codecs to be rejected")
+	}
+
+	invalid := map[string]string{
+		"too large":     strings.Repeat("a", 70000),
+		"no version":    "o=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nm=audio 9 RTP/AVP 0\r\n",
+		"malformed":     "v=0\r\nnot a line\r\n",
+		"no media":      "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n",
+		"short m= line": "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nm=audio 9\r\n",
+	}
+	for name, sdp := range invalid {
+		if _, err := (&SDPPolicy{MaxSize: 65536}).sanitize(sdp); err == nil {
+			t.Errorf("Expected the %s SDP to be rejected", name)
+		}
+	}
+}
+
+func TestRelayRejectsInvalidSDP(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger(), WithSDPPolicy(SDPPolicy{DeniedCodecs: []string{"H264"}}))
+	sent := make(map[string][]Message)
+	sender := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		sent[clientID] = append(sent[clientID], msg)
+		return nil
+	}
+	for _, client := range []string{"alice", "bob"} {
+		joinJSON, _ := json.Marshal(Message{Type: Join, Room: "call"})
+		sm.ProcessMessage(joinJSON, client, sender)
+	}
+
+	offer, _ := json.Marshal(map[string]string{"type": "offer", "sdp": testOffer})
+	offerJSON, _ := json.Marshal(Message{Type: Offer, Room: "call", Recipient: "bob", Payload: offer})
+	if err := sm.ProcessMessage(offerJSON, "alice", sender); err != nil {
+		t.Fatalf("Expected the offer to be relayed, got %v", err)
+	}
+	relayed := sent["bob"][len(sent["bob"])-1]
+	var description map[string]string
+	json.Unmarshal(relayed.Payload, &description)
+	if description["type"] != "offer" || strings.Contains(description["sdp"], "H264") {
+		t.Errorf("Expected the sanitized offer to be relayed, got %v", description)
+	}
+
+	garbled, _ := json.Marshal(map[string]string{"type": "answer", "sdp": "hello"})
+	answerJSON, _ := json.Marshal(Message{Type: Answer, Room: "call", Recipient: "alice", Payload: garbled})
+	relayedToAlice := len(sent["alice"])
+	if err := sm.ProcessMessage(answerJSON, "bob", sender); err == nil {
+		t.Fatal("Expected the invalid answer to be rejected")
+	}
+	if len(sent["alice"]) != relayedToAlice {
+		t.Error("Expected the invalid answer not to be relayed")
+	}
+	if reply := sent["bob"][len(sent["bob"])-1]; reply.Type != Error {
+		t.Errorf("Expected an error back to the sender, got %v", reply.Type)
+	}
+
+	// Renegotiations and ICE restarts are offers and are checked alike
+	for _, messageType := range []MessageType{Renegotiate, ICERestart} {
+		garbledJSON, _ := json.Marshal(Message{Type: messageType, Room: "call", Recipient: "bob", Payload: garbled})
+		if err := sm.ProcessMessage(garbledJSON, "alice", sender); err == nil {
+			t.Errorf("Expected the invalid %s to be rejected", messageType)
+		}
+	}
+
+	// ICE candidates are not SDP and pass through
+	candidateJSON, _ := json.Marshal(Message{Type: ICECandidate, Room: "call", Recipient: "alice", Payload: json.RawMessage(`{"candidate":""}`)})
+	if err := sm.ProcessMessage(candidateJSON, "bob", sender); err != nil {
+		t.Errorf("Expected the ICE candidate to be relayed, got %v", err)
+	}
+}
@@ -1,11 +1,20 @@
 package protocol
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/ice"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
 )
 
 // MessageType defines the type of WebRTC signaling message
@@ -21,13 +30,123 @@ const (
 	// ICECandidate message - sent when a new ICE candidate is discovered
 	ICECandidate MessageType = "ice-candidate"
 
+	// Renegotiate message - an offer sent mid-call to change the session,
+	// e.g. to add or remove a track, relayed like an offer
+	Renegotiate MessageType = "renegotiate"
+
+	// ICERestart message - an offer sent mid-call to gather new ICE
+	// candidates after a network change, relayed like an offer
+	ICERestart MessageType = "ice-restart"
+
 	// Join message - sent when a peer wants to join a room
 	Join MessageType = "join"
 
 	// Leave message - sent when a peer wants to leave a room
 	Leave MessageType = "leave"
+
+	// Error message - sent by the server when a request is rejected
+	Error MessageType = "error"
+
+	// RoomExpired message - sent by the server before closing the connections of an idle room
+	RoomExpired MessageType = "room-expired"
+
+	// RoomClosed message - sent by the server when an operator closes a room,
+	// or when it reaches its time limit
+	RoomClosed MessageType = "room-closed"
+
+	// RoomClosing message - sent by the server to warn the peers of a room
+	// that it closes soon at its time limit
+	RoomClosing MessageType = "room-closing"
+
+	// SystemNotice message - sent by the server to announce operator notices
+	SystemNotice MessageType = "system-notice"
+
+	// PleaseReconnect message - sent by the server to ask a client to
+	// reconnect by a deadline, e.g. to move it onto a new server version
+	PleaseReconnect MessageType = "please-reconnect"
+
+	// RoomRestored message - sent by the server to the previous peers of a
+	// room closed by mistake and restored by an operator, so that they rejoin
+	RoomRestored MessageType = "room-restored"
+
+	// Welcome message - sent by the server first on each connection, with
+	// the client ID it assigned
+	Welcome MessageType = "welcome"
+
+	// Joined message - sent by the server to confirm a join
+	Joined MessageType = "joined"
+
+	// Kick message - sent by a moderator to remove a peer from a room
+	Kick MessageType = "kick"
+
+	// Ban message - sent by a moderator to remove a peer and reject its rejoins
+	Ban MessageType = "ban"
+
+	// Kicked message - sent by the server to a peer removed by a moderator
+	Kicked MessageType = "kicked"
+
+	// Banned message - sent by the server to a peer banned by a moderator
+	Banned MessageType = "banned"
+
+	// GrantRole message - sent by the room owner to assign a role to a peer
+	GrantRole MessageType = "grant-role"
+
+	// LockRoom message - sent by a moderator to reject joins from new peers
+	LockRoom MessageType = "lock-room"
+
+	// UnlockRoom message - sent by a moderator to accept joins again
+	UnlockRoom MessageType = "unlock-room"
+
+	// Mute message - sent by a moderator and forwarded to the peer that should stop sending media
+	Mute MessageType = "mute"
+
+	// Unmute message - sent by a moderator and forwarded to the peer that may resume sending media
+	Unmute MessageType = "unmute"
+
+	// Peers message - sent by a peer to request the peer list of a room it
+	// has joined, and by the server in reply with the list
+	Peers MessageType = "peers"
+
+	// DeprecationNotice message - sent by the server to a client using a deprecated message type or field
+	DeprecationNotice MessageType = "deprecation"
+
+	// Degraded message - sent by the server to the peers of a room that became
+	// read-only during a cluster partition, and again once it recovered
+	Degraded MessageType = "degraded"
+
+	// QualityReport message - sent periodically by a peer with the stats of
+	// its peer connections, aggregated into the room's call quality score
+	QualityReport MessageType = "quality-report"
+
+	// PeerJoined message - sent by the server to the peers of a room when a
+	// peer joins it
+	PeerJoined MessageType = "peer-joined"
+
+	// JoinedBatch message - sent by the server instead of peer-joined
+	// messages to clients of BatchedJoinsVersion, listing the peers that
+	// joined the room within the join batch window
+	JoinedBatch MessageType = "joined-batch"
+
+	// Rename message - sent by a peer to change its display name in a room,
+	// and by the server to the peers of the room with the name it was given
+	Rename MessageType = "rename"
+
+	// PeerListDelta message - sent by the server instead of a full peer list
+	// in reply to a peers request of a client of CapabilityPeerListDelta,
+	// listing the changes since the sequence number of its request
+	PeerListDelta MessageType = "peer-list-delta"
 )
 
+// carriesSDP reports whether messages of the type carry a session
+// description: offers, answers, and the offers of renegotiations and ICE restarts
+func (t MessageType) carriesSDP() bool {
+	switch t {
+	case Offer, Answer, Renegotiate, ICERestart:
+		return true
+	}
+	return false
+}
+
 // Message represents a signaling message
 type Message struct {
 	Type      MessageType     `json:"type"`
@@ -35,32 +154,318 @@ type Message struct {
 	Sender    string          `json:"sender"`
 	Recipient string          `json:"recipient,omitempty"`
 	Payload   json.RawMessage `json:"payload,omitempty"`
+	Password  string          `json:"password,omitempty"`
+
+	// Trace is reserved for the W3C traceparent of the span the message is
+	// part of. Clients may set it to link the server's span of the message
+	// to their own trace; the server sets it on relayed messages to the
+	// span of the relay, so that flows between peers can be stitched together.
+	Trace string `json:"trace,omitempty"`
+
+	// Meta is the Envelope of messages written to clients of
+	// EnvelopeVersion, set by the server; clients leave it empty
+	Meta json.RawMessage `json:"meta,omitempty"`
+
+	// ID is the idempotency key the server sets on relayed messages with
+	// WithRelayIDs. A message delivered more than once carries the same ID,
+	// so clients should ignore the IDs they have seen.
+	ID string `json:"id,omitempty"`
+}
+
+// ErrorPayload is the payload of an error message sent to a client
+type ErrorPayload struct {
+	// Code classifies the error, e.g. not_found or resource_exhausted, so
+	// that clients can handle it without matching the message
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// NoticePayload is the payload of server-originated notices such as system-notice and room-closed
+type NoticePayload struct {
+	Message string `json:"message"`
+}
+
+// JoinPayload is the optional payload of a join message
+type JoinPayload struct {
+	// Metadata is attached to the room when the join creates it
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+
+	// ProtocolVersion is the version of the signaling protocol the client
+	// implements, 1 if omitted. Clients of BatchedJoinsVersion or later are
+	// notified of joins with joined-batch messages, and clients of
+	// EnvelopeVersion or later receive envelope metadata.
+	ProtocolVersion int `json:"protocolVersion,omitempty"`
+
+	// DisplayName is the name the client wants to be shown under in the
+	// room. If another peer holds it, it is suffixed or the join rejected,
+	// depending on the display name collision policy.
+	DisplayName string `json:"displayName,omitempty"`
+
+	// Role requests to join as an observer, the only role a client may ask
+	// for; other roles are granted by the room owner
+	Role Role `json:"role,omitempty"`
+
+	// Capabilities lists the optional protocol features the client
+	// supports, e.g. CapabilityPeerListDelta. The joined reply lists those
+	// the server supports too, which apply to the client from then on.
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// JoinedPayload is the payload of a joined message
+type JoinedPayload struct {
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Role     Role            `json:"role"`
+	Peers    []PeerInfo      `json:"peers"`
+
+	// DisplayName is the display name the client was given in the room
+	DisplayName string `json:"displayName,omitempty"`
+
+	// PeerID is the joining client's own pseudonym in the room, set if peer
+	// IDs are obfuscated so that it can find itself in the peer list
+	PeerID string `json:"peerId,omitempty"`
+
+	// ICEServers lets the client build its peer connection without fetching
+	// /ice-config first, omitted if no ICE servers are configured
+	ICEServers []ice.Server `json:"iceServers,omitempty"`
+
+	// AlreadyJoined is set in the reply to a join of a room the client is a
+	// peer of, e.g. replayed after a reconnect. The join changes nothing and
+	// its peers are not notified; the reply carries the room's current state.
+	AlreadyJoined bool `json:"alreadyJoined,omitempty"`
+
+	// Capabilities lists the capabilities of the join the server
+	// supports, and PeerListSeq is the sequence number of the peer list for
+	// clients of CapabilityPeerListDelta
+	Capabilities []string `json:"capabilities,omitempty"`
+	PeerListSeq  uint64   `json:"peerListSeq,omitempty"`
+}
+
+// PeersPayload is the payload of the server's reply to a peers message
+type PeersPayload struct {
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Peers    []PeerInfo      `json:"peers"`
+
+	// Seq is the sequence number of the peer list for clients of CapabilityPeerListDelta
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 // Room represents a signaling room with connected peers
 type Room struct {
-	ID    string
-	Peers map[string]struct{}
-	mutex sync.RWMutex
+	ID       string
+	Peers    map[string]struct{}
+	Metadata json.RawMessage
+	Owner    string
+	mutex    sync.RWMutex
+
+	// lastActivity is the time of the last join or relay in the room
+	lastActivity time.Time
+
+	// roles holds granted roles other than owner; peers without an entry are participants
+	roles map[string]Role
+
+	// joinOrder lists the peers in the order they joined, to pick the next owner
+	joinOrder []string
+
+	// locked rooms reject joins from peers not already in the room
+	locked bool
+
+	// muted holds the peers a moderator asked to stop sending media
+	muted map[string]struct{}
+
+	// batchedJoins holds the peers notified of joins with joined-batch messages
+	batchedJoins map[string]struct{}
+
+	// peerSeq is the sequence number of the peer list, advanced by each
+	// change of it. peerChanges are the latest changes, the changes up to
+	// peerChangesFrom having been dropped.
+	peerSeq         uint64
+	peerChanges     []peerChange
+	peerChangesFrom uint64
+
+	// names holds the display names of the peers that have one, and
+	// nameHolders the peer holding each name, lower-cased
+	names       map[string]string
+	nameHolders map[string]string
+
+	// passwordHash is the bcrypt hash of the room password, nil if the room is open
+	passwordHash []byte
+
+	// createdAt, firstJoinAt, firstRelayAt and maxPeers are reported in the
+	// room metrics when the room closes
+	createdAt    time.Time
+	firstJoinAt  time.Time
+	firstRelayAt time.Time
+	maxPeers     int
+}
+
+// Connections delivers messages to and closes client connections on behalf of the SignalingManager
+type Connections interface {
+	SendMessage(clientID string, message []byte) error
+	CloseConnection(clientID string) error
+	CloseConnectionWithReason(clientID string, code int, reason string) error
+}
+
+// WriteNotifier is implemented by Connections that report when a message has
+// been written to a client's connection rather than queued for it. Relays to
+// local peers are sent through it, so that the relay latency includes the
+// time spent in the recipient's send buffer.
+type WriteNotifier interface {
+	// SendMessageNotify sends a message as SendMessage does and calls
+	// written once it is written; dropped messages never call it
+	SendMessageNotify(clientID string, message []byte, written func()) error
 }
 
 // SignalingManager handles signaling message routing and room management
 type SignalingManager struct {
-	rooms  map[string]*Room
-	mutex  sync.RWMutex
-	logger logging.Logger
+	rooms       map[string]*Room
+	policies    map[string]NamespacePolicy
+	bans        map[string]map[string]time.Time
+	banDuration time.Duration
+	identities  map[string]string
+	versions    map[string]int
+	mutex       sync.RWMutex
+	logger      logging.Logger
+	metrics     *metrics.Metrics
+	tracer      tracing.Tracer
+	connections Connections
+	backend     RoomBackend
+	activity    []ActivitySink
+	store       RoomStore
+	maxResident int
+	spilled     map[string]spilledRoom
+	iceServers  func(clientID string) []ice.Server
+	sdpPolicy   *SDPPolicy
+
+	// capabilities holds the capabilities each client negotiated
+	capabilities map[string][]string
+
+	// deprecations are the deprecated features clients are notified of, nil if none
+	deprecations *deprecations
+
+	// degraded holds the rooms that are read-only during a cluster partition
+	degraded map[string]struct{}
+
+	// creation rejects joins to new rooms while disabled
+	creation RoomCreation
+
+	// peerIDs maps the pseudonyms exposed to peers back to client IDs, nil
+	// if client IDs are exposed
+	peerIDs *peerIDs
+
+	// quality aggregates the quality reports of each room, nil if disabled
+	quality *callQuality
+
+	// timeLimits holds the time limits of the rooms that have one, and
+	// closeWarnings how long before them their peers are warned
+	timeLimits    map[string]*timeLimit
+	closeWarnings []time.Duration
+
+	// joinPacer paces the joins to each room, nil if unpaced, and joins
+	// collects the joins its peers are yet to be notified of
+	joinPacer websocket.Limiter
+	joins     joinBatches
+
+	// nameCollision resolves the display names taken in a room
+	nameCollision DisplayNameCollision
+
+	// relayIDs sets an idempotency key on relayed messages
+	relayIDs bool
+
+	// closed holds the state of the rooms closed by an operator for
+	// closedRetention, so that they can be restored
+	closed          map[string]*closedRoom
+	closedRetention time.Duration
+
+	now func() time.Time
+}
+
+// ManagerOption configures a SignalingManager
+type ManagerOption func(*SignalingManager)
+
+// WithMetrics sets the metrics recorded by the SignalingManager
+func WithMetrics(m *metrics.Metrics) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.metrics = m
+	}
+}
+
+// WithTracer sets the tracer of the spans of processed messages
+func WithTracer(t tracing.Tracer) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.tracer = t
+	}
+}
+
+// WithConnections sets the connections used to notify and disconnect clients outside of a request
+func WithConnections(c Connections) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.connections = c
+	}
+}
+
+// WithClock sets the time source of the SignalingManager
+func WithClock(now func() time.Time) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.now = now
+	}
+}
+
+// WithBanDuration sets how long banned peers are rejected from a room
+func WithBanDuration(d time.Duration) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.banDuration = d
+	}
+}
+
+// WithICEServers sets the source of the ICE servers included in joined
+// messages, called with the joining client's ID to label TURN credentials
+func WithICEServers(servers func(clientID string) []ice.Server) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.iceServers = servers
+	}
 }
 
 // NewSignalingManager creates a new SignalingManager
-func NewSignalingManager(logger logging.Logger) *SignalingManager {
-	return &SignalingManager{
-		rooms:  make(map[string]*Room),
-		logger: logger.With("component", "signaling"),
+func NewSignalingManager(logger logging.Logger, opts ...ManagerOption) *SignalingManager {
+	sm := &SignalingManager{
+		rooms:       make(map[string]*Room),
+		policies:    make(map[string]NamespacePolicy),
+		bans:        make(map[string]map[string]time.Time),
+		banDuration: DefaultBanDuration,
+		identities:  make(map[string]string),
+		versions:    make(map[string]int),
+		spilled:     make(map[string]spilledRoom),
+		degraded:    make(map[string]struct{}),
+		timeLimits:  make(map[string]*timeLimit),
+		joins:       joinBatches{pending: make(map[string][]string)},
+		closed:      make(map[string]*closedRoom),
+		logger:      logger.With("component", "signaling"),
+		now:         time.Now,
+
+		capabilities: make(map[string][]string),
+
+		closeWarnings: DefaultRoomCloseWarnings,
+		nameCollision: DisplayNameSuffix,
 	}
+
+	for _, opt := range opts {
+		opt(sm)
+	}
+
+	return sm
 }
 
 // ProcessMessage processes an incoming signaling message
 func (sm *SignalingManager) ProcessMessage(message []byte, clientID string, sender func(string, []byte) error) error {
+	return sm.ProcessMessageContext(context.Background(), message, clientID, sender)
+}
+
+// ProcessMessageContext processes an incoming signaling message as
+// ProcessMessage does, in a span that is a child of the span in the context,
+// e.g. the span of the client's connection
+func (sm *SignalingManager) ProcessMessageContext(ctx context.Context, message []byte, clientID string, sender func(string, []byte) error) (err error) {
+	receivedAt := time.Now()
+
 	// Parse the message
 	var msg Message
 	if err := json.Unmarshal(message, &msg); err != nil {
@@ -71,53 +476,296 @@ func (sm *SignalingManager) ProcessMessage(message []byte, clientID string, send
 	// Set the sender ID
 	msg.Sender = clientID
 
+	span := sm.startMessageSpan(ctx, &msg)
+	defer span.End()
+
+	// A panic handling the message is returned as a *websocket.PanicError,
+	// for the transport to close the client's connection
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := websocket.NewPanicError(r)
+			sm.logger.Error("Panic handling message", "client_id", clientID, "type", msg.Type, "room_id", msg.Room, "error", panicErr, "stack", string(panicErr.Stack))
+			span.RecordError(panicErr)
+			err = panicErr
+		}
+	}()
+
+	err = sm.handleMessage(message, msg, clientID, receivedAt, sender)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// handleMessage handles a parsed message according to its type
+func (sm *SignalingManager) handleMessage(message []byte, msg Message, clientID string, receivedAt time.Time, sender func(string, []byte) error) error {
+
+	// Deprecated features keep working, but their users are told to migrate
+	sm.warnDeprecated(message, msg, clientID, sender)
+
+	// Load the room back into memory if it was spilled to the room store
+	if msg.Room != "" {
+		sm.rehydrate(msg.Room)
+	}
+
+	// Clients address the peers of a room by their pseudonyms if peer IDs are obfuscated
+	if msg.Recipient != "" && sm.peerIDs != nil {
+		recipient, ok := sm.resolvePeerID(msg.Room, msg.Recipient)
+		if !ok {
+			err := fmt.Errorf("%w: unknown peer %s in room %s", ErrPeerNotInRoom, msg.Recipient, msg.Room)
+			sm.sendError(clientID, err, sender)
+			return err
+		}
+		msg.Recipient = recipient
+	}
+
 	// Handle the message based on its type
 	switch msg.Type {
 	case Join:
-		return sm.handleJoin(msg, clientID)
+		return sm.handleJoin(msg, clientID, sender)
 	case Leave:
 		return sm.handleLeave(msg, clientID)
-	case Offer, Answer, ICECandidate:
-		return sm.relayMessage(msg, sender)
+	case Offer, Answer, ICECandidate, Renegotiate, ICERestart:
+		if !sm.mayPublish(msg) {
+			err := fmt.Errorf("%w: %s from %s", ErrObserver, msg.Type, clientID)
+			sm.sendError(clientID, err, sender)
+			return err
+		}
+		return sm.relayMessage(msg, receivedAt, sender)
+	case Kick, Ban:
+		return sm.handleModeration(msg, clientID, sender)
+	case GrantRole:
+		return sm.handleGrantRole(msg, clientID, sender)
+	case LockRoom, UnlockRoom:
+		return sm.handleLockRoom(msg, clientID, sender)
+	case Mute, Unmute:
+		return sm.handleMute(msg, clientID, receivedAt, sender)
+	case Peers:
+		return sm.handlePeers(msg, clientID, sender)
+	case QualityReport:
+		return sm.handleQualityReport(msg, clientID, sender)
+	case Rename:
+		return sm.handleRename(msg, clientID, sender)
 	default:
 		sm.logger.Warn("Unknown message type", "type", msg.Type)
 		return fmt.Errorf("unknown message type: %s", msg.Type)
 	}
 }
 
-// handleJoin adds a client to a room
-func (sm *SignalingManager) handleJoin(msg Message, clientID string) error {
+// handleJoin adds a client to a room and replies with a joined message
+func (sm *SignalingManager) handleJoin(msg Message, clientID string, sender func(string, []byte) error) error {
 	if msg.Room == "" {
-		return fmt.Errorf("room ID is required for join messages")
+		return errs.New(errs.InvalidArgument, "room ID is required for join messages")
+	}
+	if err := ValidateRoomID(msg.Room); err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
 	}
 
+	var join JoinPayload
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &join); err != nil {
+			err = errs.Wrap(errs.InvalidArgument, "invalid join payload", err)
+			sm.sendError(clientID, err, sender)
+			return err
+		}
+	}
+	if err := ValidateMetadata(join.Metadata); err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	displayName, err := NormalizeDisplayName(join.DisplayName)
+	if err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	join.DisplayName = displayName
+	if join.Role != "" && join.Role != RoleObserver {
+		err := fmt.Errorf("%w: client %s requested role %s", ErrRoleNotRequestable, clientID, join.Role)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	// Hash or verify the password before taking the manager lock, bcrypt is slow by design
+	password, err := sm.checkRoomPassword(msg.Room, msg.Password)
+	if err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	joined, err := sm.addPeer(msg, join, password, clientID)
+	if err != nil {
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+	if sm.iceServers != nil {
+		joined.ICEServers = sm.iceServers(clientID)
+	}
+	sm.recordVersion(clientID, join.ProtocolVersion)
+	sm.recordCapabilities(clientID, joined.Capabilities)
+
+	payload, err := json.Marshal(joined)
+	if err != nil {
+		sm.logger.Error("Failed to marshal joined payload", "error", err)
+		return fmt.Errorf("failed to marshal joined payload: %w", err)
+	}
+
+	messageJSON, err := json.Marshal(Message{
+		Type:      Joined,
+		Room:      msg.Room,
+		Recipient: clientID,
+		Payload:   payload,
+	})
+	if err != nil {
+		sm.logger.Error("Failed to marshal joined message", "error", err)
+		return fmt.Errorf("failed to marshal joined message: %w", err)
+	}
+
+	if err := sender(clientID, messageJSON); err != nil {
+		sm.logger.Error("Failed to send joined message", "error", err, "client_id", clientID)
+		return fmt.Errorf("failed to send joined message: %w", err)
+	}
+
+	// Without a batch window the peers are notified after the joiner got its peer list
+	if sm.joins.window <= 0 {
+		sm.flushJoins(msg.Room)
+	}
+
+	return nil
+}
+
+// addPeer adds a client to a room, creating the room if needed. On rejection
+// the error's message is the reason to report to the client.
+func (sm *SignalingManager) addPeer(msg Message, join JoinPayload, password passwordCheck, clientID string) (JoinedPayload, error) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	// Get or create the room
+	policy := sm.policyFor(msg.Room)
+
+	if sm.isBanned(msg.Room, clientID) {
+		sm.logger.Warn("Rejected join from banned peer", "client_id", clientID, "room_id", msg.Room)
+		return JoinedPayload{}, fmt.Errorf("%w: client %s in room %s", ErrBanned, clientID, msg.Room)
+	}
+
+	// Get or create the room; the first joiner may set the room password and metadata
 	room, ok := sm.rooms[msg.Room]
+	if !sm.admitJoinLocked(room, msg.Room, clientID) {
+		sm.logger.Warn("Rejected join beyond room join rate", "client_id", clientID, "room_id", msg.Room)
+		if sm.metrics != nil {
+			sm.metrics.WebSocketError("join_paced")
+		}
+		return JoinedPayload{}, fmt.Errorf("%w: %s", ErrJoinPaced, msg.Room)
+	}
 	if !ok {
+		if sm.creation.Disabled {
+			sm.logger.Warn("Rejected room creation while disabled", "client_id", clientID, "room_id", msg.Room)
+			if sm.metrics != nil {
+				sm.metrics.WebSocketError("room_creation_disabled")
+			}
+			return JoinedPayload{}, errs.Wrap(errs.FailedPrecondition, sm.creation.Message, fmt.Errorf("%w: %s", ErrRoomCreationDisabled, msg.Room))
+		}
+		if policy.RequirePassword && msg.Password == "" {
+			sm.logger.Warn("Rejected room creation without password", "client_id", clientID, "room_id", msg.Room)
+			return JoinedPayload{}, fmt.Errorf("%w: to create room %s", ErrPasswordRequired, msg.Room)
+		}
+
 		room = &Room{
-			ID:    msg.Room,
-			Peers: make(map[string]struct{}),
+			ID:        msg.Room,
+			Peers:     make(map[string]struct{}),
+			Metadata:  join.Metadata,
+			createdAt: sm.now(),
+		}
+		if msg.Password != "" {
+			// The password was hashed unless the room was deleted since it was checked
+			if password.hash == nil {
+				hash, err := hashPassword(msg.Password)
+				if err != nil {
+					return JoinedPayload{}, err
+				}
+				password.hash = hash
+			}
+			room.passwordHash = password.hash
+			password.matched = password.hash
 		}
 		sm.rooms[msg.Room] = room
+		sm.limitRoomLocked(msg.Room, policy)
+		sm.emit(ActivityEvent{Type: ActivityRoomCreated, Room: msg.Room, Client: clientID})
 	}
 
 	// Add the client to the room
 	room.mutex.Lock()
 	defer room.mutex.Unlock()
 
-	room.Peers[clientID] = struct{}{}
+	// A peer joining again is acknowledged with the room's state, whatever
+	// the password, display name and role of its join
+	_, joined := room.Peers[clientID]
+	if !joined && !password.allows(room) {
+		sm.logger.Warn("Rejected join with invalid room password", "client_id", clientID, "room_id", msg.Room)
+		return JoinedPayload{}, fmt.Errorf("%w: %s", ErrInvalidPassword, msg.Room)
+	}
 
-	sm.logger.Info("Client joined room", "client_id", clientID, "room_id", msg.Room)
-	return nil
+	if !joined && room.locked {
+		sm.logger.Warn("Rejected join to locked room", "client_id", clientID, "room_id", msg.Room)
+		return JoinedPayload{}, fmt.Errorf("%w: %s", ErrRoomLocked, msg.Room)
+	}
+
+	if _, degraded := sm.degraded[msg.Room]; !joined && degraded {
+		sm.logger.Warn("Rejected join to degraded room", "client_id", clientID, "room_id", msg.Room)
+		return JoinedPayload{}, fmt.Errorf("%w: %s", ErrRoomDegraded, msg.Room)
+	}
+
+	if !joined && policy.MaxPeers > 0 && len(room.Peers) >= policy.MaxPeers {
+		sm.logger.Warn("Rejected join to full room", "client_id", clientID, "room_id", msg.Room, "max_peers", policy.MaxPeers)
+		return JoinedPayload{}, fmt.Errorf("%w: %s", ErrRoomFull, msg.Room)
+	}
+
+	if !joined {
+		if _, err := room.claimName(clientID, join.DisplayName, sm.nameCollision); err != nil {
+			sm.logger.Warn("Rejected join with taken display name", "client_id", clientID, "room_id", msg.Room)
+			return JoinedPayload{}, err
+		}
+		room.Peers[clientID] = struct{}{}
+		room.peerChanged(clientID)
+		room.joinOrder = append(room.joinOrder, clientID)
+		room.recordJoin(sm.now())
+		if join.Role == RoleObserver || (policy.ObserverJoins && room.Owner != "") {
+			room.setRole(clientID, RoleObserver)
+		}
+		sm.rememberPeerID(msg.Room, clientID)
+		sm.announceJoin(msg.Room, clientID)
+		sm.queueJoin(room, clientID, join)
+		if room.Owner == "" && room.roleOf(clientID) != RoleObserver {
+			room.Owner = clientID
+		}
+		sm.logger.Info("Client joined room", "client_id", clientID, "room_id", msg.Room)
+	} else {
+		sm.logger.Debug("Acknowledged join of a peer already in the room", "client_id", clientID, "room_id", msg.Room)
+	}
+	room.lastActivity = sm.now()
+
+	joinedPayload := JoinedPayload{
+		AlreadyJoined: joined,
+
+		Metadata: room.Metadata,
+		Role:     room.roleOf(clientID),
+		Peers:    sm.exposePeers(msg.Room, room.peerInfos()),
+
+		DisplayName: room.names[clientID],
+	}
+	if sm.peerIDs != nil {
+		joinedPayload.PeerID = sm.peerID(msg.Room, clientID)
+	}
+	joinedPayload.Capabilities = negotiateCapabilities(join.Capabilities)
+	if hasCapability(joinedPayload.Capabilities, CapabilityPeerListDelta) {
+		joinedPayload.PeerListSeq = room.peerSeq
+	}
+	return joinedPayload, nil
 }
 
 // handleLeave removes a client from a room
 func (sm *SignalingManager) handleLeave(msg Message, clientID string) error {
 	if msg.Room == "" {
-		return fmt.Errorf("room ID is required for leave messages")
+		return errs.New(errs.InvalidArgument, "room ID is required for leave messages")
 	}
 
 	sm.mutex.RLock()
@@ -126,12 +774,19 @@ func (sm *SignalingManager) handleLeave(msg Message, clientID string) error {
 	// Get the room
 	room, ok := sm.rooms[msg.Room]
 	if !ok {
-		return fmt.Errorf("room not found: %s", msg.Room)
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, msg.Room)
 	}
 
-	// Remove the client from the room
+	// Only a peer of the room may leave it
 	room.mutex.Lock()
-	delete(room.Peers, clientID)
+	if _, ok := room.Peers[clientID]; !ok {
+		room.mutex.Unlock()
+		return fmt.Errorf("%w: client %s in room %s", ErrNotInRoom, clientID, msg.Room)
+	}
+
+	// Remove the client from the room
+	room.removePeer(clientID)
+	sm.announceLeave(msg.Room, LeaveReasonLeft, clientID)
 
 	// If the room is empty, remove it
 	if len(room.Peers) == 0 {
@@ -140,6 +795,7 @@ func (sm *SignalingManager) handleLeave(msg Message, clientID string) error {
 		delete(sm.rooms, msg.Room)
 		sm.mutex.Unlock()
 		sm.mutex.RLock()
+		sm.roomClosed(msg.Room, room.stats(sm.now()), "empty")
 	}
 	room.mutex.Unlock()
 
@@ -147,29 +803,334 @@ func (sm *SignalingManager) handleLeave(msg Message, clientID string) error {
 	return nil
 }
 
-// relayMessage relays a message to its intended recipient
-func (sm *SignalingManager) relayMessage(msg Message, sender func(string, []byte) error) error {
+// handlePeers replies with the current peers and metadata of a room the
+// client has joined, e.g. so that a resumed client can resynchronize.
+// Clients of CapabilityPeerListDelta asking for the changes since a sequence
+// number are sent a peer-list-delta instead, if the changes are retained.
+func (sm *SignalingManager) handlePeers(msg Message, clientID string, sender func(string, []byte) error) error {
+	if msg.Room == "" {
+		return errs.New(errs.InvalidArgument, "room ID is required for peers messages")
+	}
+
+	var request PeersRequestPayload
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &request); err != nil {
+			err = errs.Wrap(errs.InvalidArgument, "invalid peers payload", err)
+			sm.sendError(clientID, err, sender)
+			return err
+		}
+	}
+	deltas := sm.negotiated(clientID, CapabilityPeerListDelta)
+
+	sm.mutex.RLock()
+	room, ok := sm.rooms[msg.Room]
+	var peers PeersPayload
+	var delta *PeerListDeltaPayload
+	member := false
+	if ok {
+		room.mutex.RLock()
+		_, member = room.Peers[clientID]
+		var adds []PeerInfo
+		var removes []string
+		retained := false
+		if deltas && request.Since > 0 {
+			adds, removes, retained = room.peerListDelta(request.Since)
+		}
+		if retained {
+			delta = &PeerListDeltaPayload{Since: request.Since, Seq: room.peerSeq, Adds: sm.exposePeers(msg.Room, adds), Removes: sm.exposePeerIDs(msg.Room, removes)}
+		} else {
+			peers = PeersPayload{Metadata: room.Metadata, Peers: sm.exposePeers(msg.Room, room.peerInfos())}
+			if deltas {
+				peers.Seq = room.peerSeq
+			}
+		}
+		room.mutex.RUnlock()
+	}
+	sm.mutex.RUnlock()
+
+	// The peers of a room are only disclosed to its members
+	if !member {
+		err := fmt.Errorf("%w: client %s in room %s", ErrNotInRoom, clientID, msg.Room)
+		sm.sendError(clientID, err, sender)
+		return err
+	}
+
+	messageType, reply := Peers, interface{}(peers)
+	if delta != nil {
+		messageType, reply = PeerListDelta, delta
+	}
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		sm.logger.Error("Failed to marshal peers payload", "error", err)
+		return fmt.Errorf("failed to marshal peers payload: %w", err)
+	}
+
+	messageJSON, err := json.Marshal(Message{
+		Type:      messageType,
+		Room:      msg.Room,
+		Recipient: clientID,
+		Payload:   payload,
+	})
+	if err != nil {
+		sm.logger.Error("Failed to marshal peers message", "error", err)
+		return fmt.Errorf("failed to marshal peers message: %w", err)
+	}
+
+	if err := sender(clientID, messageJSON); err != nil {
+		sm.logger.Error("Failed to send peers message", "error", err, "client_id", clientID)
+		return fmt.Errorf("failed to send peers message: %w", err)
+	}
+	return nil
+}
+
+// relayMessage relays a message to its intended recipient or, without a
+// recipient, to every other peer of the sender's room. The relay latency
+// from receivedAt is observed for each recipient once the message is written
+// to its connection, or handed to the backend for a remote recipient.
+func (sm *SignalingManager) relayMessage(msg Message, receivedAt time.Time, sender func(string, []byte) error) error {
+	if msg.Recipient == "" && msg.Room == "" {
+		return errs.New(errs.InvalidArgument, "recipient or room is required for relay messages")
+	}
+
+	// Resolve the recipients of a broadcast before doing any work for it
+	recipients := []string{msg.Recipient}
 	if msg.Recipient == "" {
-		return fmt.Errorf("recipient is required for relay messages")
+		var err error
+		if recipients, err = sm.broadcastRecipients(msg.Room, msg.Sender); err != nil {
+			sm.sendError(msg.Sender, err, sender)
+			return err
+		}
+	}
+
+	// Check the SDP of offers and answers before it reaches the recipient
+	if sm.sdpPolicy != nil && msg.Type.carriesSDP() {
+		payload, err := sm.sdpPolicy.sanitizeDescription(msg.Payload)
+		if err != nil {
+			sm.logger.Warn("Rejected invalid SDP", "error", err, "client_id", msg.Sender, "type", msg.Type)
+			if sm.metrics != nil {
+				sm.metrics.WebSocketError("invalid_sdp")
+			}
+			err = errs.Newf(errs.InvalidArgument, "invalid SDP: %v", err)
+			sm.sendError(msg.Sender, err, sender)
+			return err
+		}
+		msg.Payload = payload
 	}
 
-	// Marshal the message
-	messageJSON, err := json.Marshal(msg)
+	sm.touchRoom(msg.Room, msg.Sender)
+
+	// Marshal the message with the IDs the peers see of each other
+	relayed := msg
+	relayed.Sender = sm.peerID(msg.Room, msg.Sender)
+	relayed.Recipient = sm.peerID(msg.Room, msg.Recipient)
+	relayed.Meta = nil
+	relayed.ID = ""
+	if sm.relayIDs {
+		relayed.ID = newRelayID()
+	}
+	messageJSON, err := json.Marshal(relayed)
 	if err != nil {
 		sm.logger.Error("Failed to marshal message", "error", err)
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	// Send the message to the recipient
-	if err := sender(msg.Recipient, messageJSON); err != nil {
-		sm.logger.Error("Failed to send message", "error", err, "recipient", msg.Recipient)
-		return fmt.Errorf("failed to send message: %w", err)
+	var written func()
+	if sm.metrics != nil {
+		written = func() {
+			// Messages do not carry a trace context yet, so no exemplar is attached
+			sm.metrics.RelayLatency(context.TODO(), string(msg.Type), len(msg.Payload), time.Since(receivedAt))
+		}
+	}
+
+	// A broadcast reaches the peers that can be reached, a direct relay fails with its recipient
+	var relayErr error
+	for _, recipient := range recipients {
+		if err := sm.deliver(msg.Room, recipient, messageJSON, sender, written); err != nil {
+			relayErr = err
+			continue
+		}
+
+		sm.emit(ActivityEvent{
+			Type:        ActivityRelay,
+			Room:        msg.Room,
+			Client:      msg.Sender,
+			Recipient:   recipient,
+			MessageType: msg.Type,
+			PayloadSize: len(msg.Payload),
+		})
+	}
+	if relayErr != nil && msg.Recipient != "" {
+		if errors.Is(relayErr, ErrRoomDegraded) {
+			sm.sendError(msg.Sender, relayErr, sender)
+		}
+		return relayErr
+	}
+
+	if sm.metrics != nil {
+		sm.metrics.MessageRelayed(string(msg.Type))
+	}
+
+	if msg.Recipient == "" {
+		sm.logger.Debug("Message broadcast", "from", msg.Sender, "room_id", msg.Room, "recipients", len(recipients), "type", msg.Type)
+	} else {
+		sm.logger.Debug("Message relayed", "from", msg.Sender, "to", msg.Recipient, "type", msg.Type)
+	}
+	return nil
+}
+
+// deliver sends a relayed message to a recipient, through the backend if it
+// is connected to another instance. written, if not nil, is called once the
+// message is written to a local recipient's connection, or once it is sent
+// when the write cannot be observed.
+func (sm *SignalingManager) deliver(roomID, recipient string, messageJSON []byte, sender func(string, []byte) error, written func()) error {
+	if sm.backend != nil && !sm.isLocalPeer(roomID, recipient) {
+		if sm.isDegraded(roomID) {
+			return fmt.Errorf("%w: cannot reach %s", ErrRoomDegraded, recipient)
+		}
+		if err := sm.backend.Relay(roomID, recipient, messageJSON); err != nil {
+			sm.logger.Error("Failed to relay message to another instance", "error", err, "recipient", recipient)
+			return fmt.Errorf("failed to relay message: %w", err)
+		}
+		if written != nil {
+			written()
+		}
+		return nil
 	}
 
-	sm.logger.Debug("Message relayed", "from", msg.Sender, "to", msg.Recipient, "type", msg.Type)
+	if notifier, ok := sm.connections.(WriteNotifier); ok && written != nil {
+		if err := notifier.SendMessageNotify(recipient, messageJSON, written); err != nil {
+			sm.logger.Error("Failed to send message", "error", err, "recipient", recipient)
+			return fmt.Errorf("failed to send message: %w", err)
+		}
+		return nil
+	}
+
+	if err := sender(recipient, messageJSON); err != nil {
+		sm.logger.Error("Failed to send message", "error", err, "recipient", recipient)
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if written != nil {
+		written()
+	}
 	return nil
 }
 
+// broadcastRecipients returns the peers of a room other than the sender,
+// including the peers connected to other instances if the backend knows
+// them. The sender must be a peer of the room.
+func (sm *SignalingManager) broadcastRecipients(roomID, senderID string) ([]string, error) {
+	sm.mutex.RLock()
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		sm.mutex.RUnlock()
+		return nil, fmt.Errorf("%w: room %s not found", ErrNotInRoom, roomID)
+	}
+	room.mutex.RLock()
+	peers := peerList(room)
+	_, member := room.Peers[senderID]
+	room.mutex.RUnlock()
+	sm.mutex.RUnlock()
+
+	if !member {
+		return nil, fmt.Errorf("%w: client %s in room %s", ErrNotInRoom, senderID, roomID)
+	}
+
+	if lister, ok := sm.backend.(PeerLister); ok {
+		peers = append(peers, lister.Peers(roomID)...)
+	}
+
+	sort.Strings(peers)
+	recipients := make([]string, 0, len(peers))
+	for i, peer := range peers {
+		if peer == senderID || (i > 0 && peer == peers[i-1]) {
+			continue
+		}
+		recipients = append(recipients, peer)
+	}
+	return recipients, nil
+}
+
+// touchRoom records activity in a room, and the time of its first relay, if
+// it exists and the client is one of its peers
+func (sm *SignalingManager) touchRoom(roomID, clientID string) {
+	if roomID == "" {
+		return
+	}
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		return
+	}
+
+	room.mutex.Lock()
+	if _, ok := room.Peers[clientID]; ok {
+		room.lastActivity = sm.now()
+		if room.firstRelayAt.IsZero() {
+			room.firstRelayAt = room.lastActivity
+		}
+	}
+	room.mutex.Unlock()
+}
+
+// recordJoin updates the first join time and peak peer count after a peer
+// joined. Must be called with the room mutex held.
+func (r *Room) recordJoin(now time.Time) {
+	if r.firstJoinAt.IsZero() {
+		r.firstJoinAt = now
+	}
+	if len(r.Peers) > r.maxPeers {
+		r.maxPeers = len(r.Peers)
+	}
+}
+
+// stats summarizes the room for the metrics recorded when it closes. Must be
+// called with the room mutex held.
+func (r *Room) stats(now time.Time) metrics.RoomStats {
+	stats := metrics.RoomStats{
+		Lifetime: now.Sub(r.createdAt),
+		MaxPeers: r.maxPeers,
+		Relayed:  !r.firstRelayAt.IsZero(),
+	}
+	if stats.Relayed {
+		stats.JoinToFirstRelay = r.firstRelayAt.Sub(r.firstJoinAt)
+	}
+	return stats
+}
+
+// roomClosed records the metrics of a closed room and emits its closing
+func (sm *SignalingManager) roomClosed(roomID string, stats metrics.RoomStats, reason string) {
+	if sm.metrics != nil {
+		sm.metrics.RoomClosed(reason, stats)
+	}
+	sm.emit(ActivityEvent{Type: ActivityRoomClosed, Room: roomID, Reason: reason})
+}
+
+// sendError sends an error message to a client
+func (sm *SignalingManager) sendError(clientID string, reason error, sender func(string, []byte) error) {
+	payload, err := json.Marshal(ErrorPayload{Code: string(errs.CodeOf(reason)), Message: errs.Message(reason)})
+	if err != nil {
+		sm.logger.Error("Failed to marshal error payload", "error", err)
+		return
+	}
+
+	messageJSON, err := json.Marshal(Message{
+		Type:      Error,
+		Recipient: clientID,
+		Payload:   payload,
+	})
+	if err != nil {
+		sm.logger.Error("Failed to marshal error message", "error", err)
+		return
+	}
+
+	if err := sender(clientID, messageJSON); err != nil {
+		sm.logger.Error("Failed to send error message", "error", err, "client_id", clientID)
+	}
+}
+
 // GetPeersInRoom returns all peers in a room
 func (sm *SignalingManager) GetPeersInRoom(roomID string) []string {
 	sm.mutex.RLock()
@@ -177,6 +1138,9 @@ func (sm *SignalingManager) GetPeersInRoom(roomID string) []string {
 
 	room, ok := sm.rooms[roomID]
 	if !ok {
+		if spilled, ok := sm.spilled[roomID]; ok {
+			return append([]string{}, spilled.peers...)
+		}
 		return []string{}
 	}
 
@@ -196,14 +1160,38 @@ func (sm *SignalingManager) RoomExists(roomID string) bool {
 	sm.mutex.RLock()
 	defer sm.mutex.RUnlock()
 
+	if _, ok := sm.spilled[roomID]; ok {
+		return true
+	}
 	_, ok := sm.rooms[roomID]
 	return ok
 }
 
-// GetRoomCount returns the number of active rooms
+// GetRoomCount returns the number of active rooms, including spilled rooms
 func (sm *SignalingManager) GetRoomCount() int {
 	sm.mutex.RLock()
 	defer sm.mutex.RUnlock()
 
-	return len(sm.rooms)
+	return len(sm.rooms) + len(sm.spilled)
+}
+
+// Occupancy returns the number of active rooms and of peers in each,
+// including spilled rooms, for the occupancy metrics
+func (sm *SignalingManager) Occupancy() metrics.Occupancy {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	occupancy := metrics.Occupancy{
+		Rooms:        len(sm.rooms) + len(sm.spilled),
+		PeersPerRoom: make([]int, 0, len(sm.rooms)+len(sm.spilled)),
+	}
+	for _, room := range sm.rooms {
+		room.mutex.RLock()
+		occupancy.PeersPerRoom = append(occupancy.PeersPerRoom, len(room.Peers))
+		room.mutex.RUnlock()
+	}
+	for _, spilled := range sm.spilled {
+		occupancy.PeersPerRoom = append(occupancy.PeersPerRoom, len(spilled.peers))
+	}
+	return occupancy
 }
@@ -2,22 +2,20 @@ package protocol
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
-	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/cluster"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/ice"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
 )
 
-// MockLogger implements logging.Logger for testing
-type MockLogger struct{}
-
-func (l *MockLogger) Debug(msg string, keyvals ...interface{})   {}
-func (l *MockLogger) Info(msg string, keyvals ...interface{})    {}
-func (l *MockLogger) Warn(msg string, keyvals ...interface{})    {}
-func (l *MockLogger) Error(msg string, keyvals ...interface{})   {}
-func (l *MockLogger) With(keyvals ...interface{}) logging.Logger { return l }
-
 func TestJoinRoom(t *testing.T) {
-	sm := NewSignalingManager(&MockLogger{})
+	sm := NewSignalingManager(testsupport.NewLogger())
 
 	// Create a join message
 	msg := Message{
@@ -54,8 +52,28 @@ func TestJoinRoom(t *testing.T) {
 	}
 }
 
+func TestProcessMessagePanic(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+
+	// A panic handling a message is returned as an error, for the transport
+	// to close the connection, and the manager keeps serving other clients
+	join := []byte(`{"type":"join","room":"test-room"}`)
+	err := sm.ProcessMessage(join, "client-1", func(string, []byte) error { panic("closed channel") })
+	var panicErr *websocket.PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "closed channel" {
+		t.Fatalf("Expected the panic as an error, got %v", err)
+	}
+
+	if err := sm.ProcessMessage(join, "client-2", func(string, []byte) error { return nil }); err != nil {
+		t.Fatalf("Expected the next message to be handled, got %v", err)
+	}
+	if peers := sm.GetPeersInRoom("test-room"); len(peers) != 2 {
+		t.Errorf("Expected both clients in the room, got %v", peers)
+	}
+}
+
 func TestLeaveRoom(t *testing.T) {
-	sm := NewSignalingManager(&MockLogger{})
+	sm := NewSignalingManager(testsupport.NewLogger())
 
 	// First join a room
 	joinMsg := Message{
@@ -92,8 +110,40 @@ func TestLeaveRoom(t *testing.T) {
 	}
 }
 
+// activityRecorder is an ActivitySink recording the events emitted
+type activityRecorder struct {
+	events []ActivityEvent
+}
+
+func (r *activityRecorder) Emit(event ActivityEvent) {
+	r.events = append(r.events, event)
+}
+
+func TestLeaveRoomNotJoined(t *testing.T) {
+	activity := &activityRecorder{}
+	sm := NewSignalingManager(testsupport.NewLogger(), WithActivitySink(activity))
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "test-room"})
+	sm.ProcessMessage(joinJSON, "client-1", noop)
+	emitted := len(activity.events)
+
+	// A client that never joined cannot leave, nor touch the room
+	leaveJSON, _ := json.Marshal(Message{Type: Leave, Room: "test-room"})
+	if err := sm.ProcessMessage(leaveJSON, "stranger", noop); !errors.Is(err, ErrNotInRoom) {
+		t.Fatalf("Expected ErrNotInRoom, got %v", err)
+	}
+	if len(activity.events) != emitted {
+		t.Errorf("Expected no activity for the stranger's leave, got %+v", activity.events[emitted:])
+	}
+	room := sm.rooms["test-room"]
+	if room.peerSeq != 1 || len(sm.GetPeersInRoom("test-room")) != 1 {
+		t.Errorf("Expected the room unchanged, got sequence %d and peers %v", room.peerSeq, sm.GetPeersInRoom("test-room"))
+	}
+}
+
 func TestRelayMessage(t *testing.T) {
-	sm := NewSignalingManager(&MockLogger{})
+	sm := NewSignalingManager(testsupport.NewLogger())
 
 	// First join two clients
 	joinMsg1 := Message{
@@ -185,7 +235,7 @@ func TestRelayMessage(t *testing.T) {
 }
 
 func TestRoomManagement(t *testing.T) {
-	sm := NewSignalingManager(&MockLogger{})
+	sm := NewSignalingManager(testsupport.NewLogger())
 
 	// Initially no rooms
 	if sm.GetRoomCount() != 0 {
@@ -243,3 +293,1039 @@ func TestRoomManagement(t *testing.T) {
 		t.Errorf("Expected 1 room, got %d", sm.GetRoomCount())
 	}
 }
+
+func TestPasswordProtectedRoom(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	noop := func(string, []byte) error { return nil }
+
+	// The first joiner sets the password
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "secret-room", Password: "hunter2"})
+	if err := sm.ProcessMessage(joinJSON, "client-1", noop); err != nil {
+		t.Fatalf("Process join message failed: %v", err)
+	}
+
+	// A join with the wrong password is rejected with an error message
+	var sentTo string
+	var sent []byte
+	senderFunc := func(clientID string, message []byte) error {
+		sentTo = clientID
+		sent = message
+		return nil
+	}
+
+	badJSON, _ := json.Marshal(Message{Type: Join, Room: "secret-room", Password: "wrong"})
+	if err := sm.ProcessMessage(badJSON, "client-2", senderFunc); err == nil {
+		t.Error("Expected join with wrong password to fail")
+	}
+
+	if sentTo != "client-2" {
+		t.Errorf("Expected error message sent to client-2, got %q", sentTo)
+	}
+
+	var errMsg Message
+	if err := json.Unmarshal(sent, &errMsg); err != nil {
+		t.Fatalf("Failed to unmarshal error message: %v", err)
+	}
+	if errMsg.Type != Error {
+		t.Errorf("Expected message type %s, got %s", Error, errMsg.Type)
+	}
+
+	if len(sm.GetPeersInRoom("secret-room")) != 1 {
+		t.Errorf("Expected 1 peer in room, got %d", len(sm.GetPeersInRoom("secret-room")))
+	}
+
+	// A join with the correct password succeeds
+	goodJSON, _ := json.Marshal(Message{Type: Join, Room: "secret-room", Password: "hunter2"})
+	if err := sm.ProcessMessage(goodJSON, "client-3", noop); err != nil {
+		t.Fatalf("Expected join with correct password to succeed: %v", err)
+	}
+
+	// Clearing the password opens the room
+	if err := sm.SetRoomPassword("secret-room", ""); err != nil {
+		t.Fatalf("SetRoomPassword failed: %v", err)
+	}
+	openJSON, _ := json.Marshal(Message{Type: Join, Room: "secret-room"})
+	if err := sm.ProcessMessage(openJSON, "client-4", noop); err != nil {
+		t.Errorf("Expected join to open room to succeed: %v", err)
+	}
+
+	if err := sm.SetRoomPassword("missing-room", "x"); err == nil {
+		t.Error("Expected SetRoomPassword on missing room to fail")
+	}
+}
+
+func TestDuplicateJoin(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	var sent []Message
+	senderFunc := func(clientID string, message []byte) error {
+		var msg Message
+		if err := json.Unmarshal(message, &msg); err != nil {
+			t.Fatalf("Failed to unmarshal sent message: %v", err)
+		}
+		sent = append(sent, msg)
+		return nil
+	}
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "secret-room", Password: "hunter2"})
+	if err := sm.ProcessMessage(joinJSON, "client-1", senderFunc); err != nil {
+		t.Fatalf("Process join message failed: %v", err)
+	}
+
+	// A peer joining again needs no password and is acknowledged with the room's state
+	sent = nil
+	againJSON, _ := json.Marshal(Message{Type: Join, Room: "secret-room"})
+	if err := sm.ProcessMessage(againJSON, "client-1", senderFunc); err != nil {
+		t.Fatalf("Expected duplicate join to succeed: %v", err)
+	}
+	if len(sent) != 1 || sent[0].Type != Joined {
+		t.Fatalf("Expected a single joined reply, got %+v", sent)
+	}
+	var joined JoinedPayload
+	if err := json.Unmarshal(sent[0].Payload, &joined); err != nil {
+		t.Fatalf("Failed to unmarshal joined payload: %v", err)
+	}
+	if !joined.AlreadyJoined || joined.Role != RoleOwner || len(joined.Peers) != 1 {
+		t.Errorf("Expected the owner to be acknowledged as already joined, got %+v", joined)
+	}
+
+	if peers := sm.GetPeersInRoom("secret-room"); len(peers) != 1 {
+		t.Errorf("Expected 1 peer in room, got %v", peers)
+	}
+}
+
+func TestExpireIdleRooms(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	conns := testsupport.NewWebSocketHandler()
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithConnections(conns),
+	)
+	noop := func(string, []byte) error { return nil }
+
+	idleJSON, _ := json.Marshal(Message{Type: Join, Room: "idle-room"})
+	sm.ProcessMessage(idleJSON, "client-1", noop)
+
+	now = now.Add(20 * time.Minute)
+	activeJSON, _ := json.Marshal(Message{Type: Join, Room: "active-room"})
+	sm.ProcessMessage(activeJSON, "client-2", noop)
+
+	now = now.Add(15 * time.Minute)
+	expired := sm.ExpireIdleRooms(30 * time.Minute)
+
+	if len(expired) != 1 || expired[0] != "idle-room" {
+		t.Fatalf("Expected only idle-room to expire, got %v", expired)
+	}
+	if sm.RoomExists("idle-room") {
+		t.Error("Expected idle-room to be removed")
+	}
+	if !sm.RoomExists("active-room") {
+		t.Error("Expected active-room to be retained")
+	}
+
+	// The peer of the expired room is notified and disconnected
+	if len(conns.Sent["client-1"]) != 1 {
+		t.Fatalf("Expected 1 notice sent to client-1, got %d", len(conns.Sent["client-1"]))
+	}
+	var notice Message
+	json.Unmarshal(conns.Sent["client-1"][0], &notice)
+	if notice.Type != RoomExpired || notice.Room != "idle-room" {
+		t.Errorf("Unexpected expiry notice: %+v", notice)
+	}
+	if len(conns.Closed) != 1 || conns.Closed[0].ClientID != "client-1" {
+		t.Errorf("Expected client-1 to be disconnected, got %v", conns.ClosedIDs())
+	}
+}
+
+func TestRoomMetadata(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	noop := func(string, []byte) error { return nil }
+
+	// The first joiner attaches metadata through the join payload
+	joinJSON, _ := json.Marshal(Message{
+		Type:    Join,
+		Room:    "meta-room",
+		Payload: json.RawMessage(`{"metadata":{"title":"Standup","owner":"alice"}}`),
+	})
+	if err := sm.ProcessMessage(joinJSON, "client-1", noop); err != nil {
+		t.Fatalf("Process join message failed: %v", err)
+	}
+
+	// Later joiners receive the metadata in the joined message
+	var joinedMsg Message
+	senderFunc := func(clientID string, message []byte) error {
+		return json.Unmarshal(message, &joinedMsg)
+	}
+	laterJSON, _ := json.Marshal(Message{
+		Type:    Join,
+		Room:    "meta-room",
+		Payload: json.RawMessage(`{"metadata":{"title":"Ignored"}}`),
+	})
+	if err := sm.ProcessMessage(laterJSON, "client-2", senderFunc); err != nil {
+		t.Fatalf("Process join message failed: %v", err)
+	}
+
+	if joinedMsg.Type != Joined {
+		t.Fatalf("Expected message type %s, got %s", Joined, joinedMsg.Type)
+	}
+	var joined JoinedPayload
+	json.Unmarshal(joinedMsg.Payload, &joined)
+	var metadata map[string]string
+	json.Unmarshal(joined.Metadata, &metadata)
+	if metadata["title"] != "Standup" {
+		t.Errorf("Expected title Standup, got %v", metadata)
+	}
+
+	// Metadata can be replaced through the admin API
+	if err := sm.SetRoomMetadata("meta-room", json.RawMessage(`{"title":"Retro"}`)); err != nil {
+		t.Fatalf("SetRoomMetadata failed: %v", err)
+	}
+	got, _ := sm.GetRoomMetadata("meta-room")
+	if string(got) != `{"title":"Retro"}` {
+		t.Errorf("Unexpected metadata: %s", got)
+	}
+
+	// Non-object metadata is rejected
+	badJSON, _ := json.Marshal(Message{Type: Join, Room: "bad-room", Payload: json.RawMessage(`{"metadata":[1,2]}`)})
+	if err := sm.ProcessMessage(badJSON, "client-3", noop); err == nil {
+		t.Error("Expected join with non-object metadata to fail")
+	}
+
+	// Rooms can be created with metadata ahead of the first join
+	if err := sm.CreateRoom("scheduled-room", json.RawMessage(`{"title":"Planning"}`)); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	if err := sm.CreateRoom("scheduled-room", nil); err == nil {
+		t.Error("Expected creating an existing room to fail")
+	}
+}
+
+func TestJoinedIncludesICEServers(t *testing.T) {
+	servers := []ice.Server{{URLs: []string{"stun:stun.example.com"}}}
+	var labels []string
+	sm := NewSignalingManager(testsupport.NewLogger(), WithICEServers(func(clientID string) []ice.Server {
+		labels = append(labels, clientID)
+		return servers
+	}))
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "ice-room"})
+	sm.ProcessMessage(joinJSON, "client-1", noop)
+
+	var joinedMsg Message
+	senderFunc := func(clientID string, message []byte) error {
+		return json.Unmarshal(message, &joinedMsg)
+	}
+	if err := sm.ProcessMessage(joinJSON, "client-2", senderFunc); err != nil {
+		t.Fatalf("Process join message failed: %v", err)
+	}
+
+	var joined JoinedPayload
+	json.Unmarshal(joinedMsg.Payload, &joined)
+	if !reflect.DeepEqual(joined.ICEServers, servers) {
+		t.Errorf("Expected the ICE servers in the joined message, got %+v", joined.ICEServers)
+	}
+	if len(joined.Peers) != 2 {
+		t.Errorf("Expected the peer list in the joined message, got %+v", joined.Peers)
+	}
+	if !reflect.DeepEqual(labels, []string{"client-1", "client-2"}) {
+		t.Errorf("Expected credentials labelled with the joining clients, got %v", labels)
+	}
+}
+
+func TestKickAndBan(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	conns := testsupport.NewWebSocketHandler()
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithConnections(conns),
+		WithBanDuration(10*time.Minute),
+	)
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "test-room"})
+	for _, client := range []string{"owner", "client-2", "client-3"} {
+		if err := sm.ProcessMessage(joinJSON, client, noop); err != nil {
+			t.Fatalf("Failed to join %s: %v", client, err)
+		}
+	}
+
+	// Only the room owner may moderate
+	kickJSON, _ := json.Marshal(Message{Type: Kick, Room: "test-room", Recipient: "client-3"})
+	if err := sm.ProcessMessage(kickJSON, "client-2", noop); err == nil {
+		t.Error("Expected kick by non-owner to fail")
+	}
+
+	kickJSON, _ = json.Marshal(Message{Type: Kick, Room: "test-room", Recipient: "client-2", Payload: json.RawMessage(`{"reason":"spam"}`)})
+	if err := sm.ProcessMessage(kickJSON, "owner", noop); err != nil {
+		t.Fatalf("Failed to kick: %v", err)
+	}
+	if len(sm.GetPeersInRoom("test-room")) != 2 {
+		t.Errorf("Expected 2 peers after kick, got %d", len(sm.GetPeersInRoom("test-room")))
+	}
+	// The kick notice follows the peer-joined notice of client-3
+	var notice Message
+	sent := conns.Sent["client-2"]
+	json.Unmarshal(sent[len(sent)-1], &notice)
+	if notice.Type != Kicked || notice.Room != "test-room" {
+		t.Errorf("Unexpected kick notice: %+v", notice)
+	}
+	if len(conns.Closed) != 1 || conns.Closed[0].ClientID != "client-2" || conns.Closed[0].Reason != "kicked from room test-room: spam" {
+		t.Errorf("Expected client-2 to be closed with reason, got %+v", conns.Closed)
+	}
+
+	// A kicked peer may rejoin, a banned peer may not until the ban expires
+	if err := sm.ProcessMessage(joinJSON, "client-2", noop); err != nil {
+		t.Errorf("Expected kicked peer to rejoin: %v", err)
+	}
+
+	banJSON, _ := json.Marshal(Message{Type: Ban, Room: "test-room", Recipient: "client-3"})
+	if err := sm.ProcessMessage(banJSON, "owner", noop); err != nil {
+		t.Fatalf("Failed to ban: %v", err)
+	}
+	if err := sm.ProcessMessage(joinJSON, "client-3", noop); err == nil {
+		t.Error("Expected banned peer to be rejected")
+	}
+
+	now = now.Add(10 * time.Minute)
+	if err := sm.ProcessMessage(joinJSON, "client-3", noop); err != nil {
+		t.Errorf("Expected peer to rejoin after ban expired: %v", err)
+	}
+}
+
+func TestBanFollowsIdentity(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	conns := testsupport.NewWebSocketHandler()
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithConnections(conns),
+		WithBanDuration(10*time.Minute),
+	)
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "test-room"})
+	otherJSON, _ := json.Marshal(Message{Type: Join, Room: "other-room"})
+	sm.ProcessMessage(joinJSON, "owner", noop)
+	sm.SetClientIdentity("client-2", "203.0.113.7")
+	sm.ProcessMessage(joinJSON, "client-2", noop)
+	sm.ProcessMessage(otherJSON, "client-2", noop)
+
+	banJSON, _ := json.Marshal(Message{Type: Ban, Room: "test-room", Recipient: "client-2"})
+	if err := sm.ProcessMessage(banJSON, "owner", noop); err != nil {
+		t.Fatalf("Failed to ban: %v", err)
+	}
+
+	// Closing the connection ends every membership of the banned peer
+	if sm.RoomExists("other-room") {
+		t.Error("Expected banned peer to be removed from its other rooms")
+	}
+
+	// Reconnecting with a new client ID does not lift the ban
+	sm.SetClientIdentity("client-9", "203.0.113.7")
+	if err := sm.ProcessMessage(joinJSON, "client-9", noop); err == nil {
+		t.Error("Expected banned identity to be rejected under a new client ID")
+	}
+
+	// Expired bans are pruned without a rejoin attempt
+	now = now.Add(10 * time.Minute)
+	if pruned := sm.PruneBans(); pruned != 1 {
+		t.Errorf("Expected 1 expired ban to be pruned, got %d", pruned)
+	}
+	if err := sm.ProcessMessage(joinJSON, "client-9", noop); err != nil {
+		t.Errorf("Expected peer to rejoin after ban expired: %v", err)
+	}
+}
+
+func TestRoomRoles(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	sent := make(map[string][]Message)
+	sender := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		sent[clientID] = append(sent[clientID], msg)
+		return nil
+	}
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "test-room"})
+	for _, client := range []string{"owner", "mod", "client-3"} {
+		if err := sm.ProcessMessage(joinJSON, client, sender); err != nil {
+			t.Fatalf("Failed to join %s: %v", client, err)
+		}
+	}
+
+	// Only the owner may grant roles
+	grant := func(from, to string, role Role) error {
+		payload, _ := json.Marshal(RolePayload{Role: role})
+		data, _ := json.Marshal(Message{Type: GrantRole, Room: "test-room", Recipient: to, Payload: payload})
+		return sm.ProcessMessage(data, from, sender)
+	}
+	if err := grant("client-3", "client-3", RoleModerator); err == nil {
+		t.Error("Expected grant by non-owner to fail")
+	}
+	if err := grant("owner", "mod", RoleModerator); err != nil {
+		t.Fatalf("Failed to grant moderator: %v", err)
+	}
+
+	expected := []PeerInfo{
+		{ID: "client-3", Role: RoleParticipant},
+		{ID: "mod", Role: RoleModerator},
+		{ID: "owner", Role: RoleOwner},
+	}
+	if peers := sm.GetPeerInfos("test-room"); !reflect.DeepEqual(peers, expected) {
+		t.Errorf("Expected peers %+v, got %+v", expected, peers)
+	}
+
+	// Moderators may mute participants but not the owner
+	muteJSON, _ := json.Marshal(Message{Type: Mute, Room: "test-room", Recipient: "client-3"})
+	if err := sm.ProcessMessage(muteJSON, "mod", sender); err != nil {
+		t.Fatalf("Failed to mute: %v", err)
+	}
+	if last := sent["client-3"][len(sent["client-3"])-1]; last.Type != Mute || last.Sender != "mod" {
+		t.Errorf("Expected mute to be forwarded to client-3, got %+v", last)
+	}
+	if peers := sm.GetPeerInfos("test-room"); !peers[0].Muted {
+		t.Error("Expected client-3 to be muted")
+	}
+	muteJSON, _ = json.Marshal(Message{Type: Mute, Room: "test-room", Recipient: "owner"})
+	if err := sm.ProcessMessage(muteJSON, "mod", sender); err == nil {
+		t.Error("Expected moderator muting the owner to fail")
+	}
+
+	// A locked room rejects new peers
+	lockJSON, _ := json.Marshal(Message{Type: LockRoom, Room: "test-room"})
+	if err := sm.ProcessMessage(lockJSON, "client-3", sender); err == nil {
+		t.Error("Expected lock by participant to fail")
+	}
+	if err := sm.ProcessMessage(lockJSON, "mod", sender); err != nil {
+		t.Fatalf("Failed to lock room: %v", err)
+	}
+	if err := sm.ProcessMessage(joinJSON, "client-4", sender); err == nil {
+		t.Error("Expected join to locked room to fail")
+	}
+
+	// Granting the owner role transfers ownership
+	if err := grant("owner", "client-3", RoleOwner); err != nil {
+		t.Fatalf("Failed to transfer ownership: %v", err)
+	}
+	roles := sm.Snapshot()[0].Roles
+	if roles["client-3"] != RoleOwner || roles["owner"] != RoleModerator {
+		t.Errorf("Expected ownership transferred to client-3, got %v", roles)
+	}
+}
+
+func TestRolesClearedOnLeave(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger())
+	noop := func(string, []byte) error { return nil }
+	send := func(clientID string, msg Message) error {
+		if msg.Room == "" {
+			msg.Room = "test-room"
+		}
+		data, _ := json.Marshal(msg)
+		return sm.ProcessMessage(data, clientID, noop)
+	}
+	grant := func(from, to string, role Role) error {
+		payload, _ := json.Marshal(RolePayload{Role: role})
+		return send(from, Message{Type: GrantRole, Recipient: to, Payload: payload})
+	}
+	roleOf := func(clientID string) Role {
+		for _, peer := range sm.GetPeerInfos("test-room") {
+			if peer.ID == clientID {
+				return peer.Role
+			}
+		}
+		return ""
+	}
+
+	for _, client := range []string{"owner", "client-2", "mod", "client-4"} {
+		if err := send(client, Message{Type: Join}); err != nil {
+			t.Fatalf("Failed to join %s: %v", client, err)
+		}
+	}
+	grant("owner", "mod", RoleModerator)
+	send("mod", Message{Type: Mute, Recipient: "client-4"})
+	send("owner", Message{Type: LockRoom})
+
+	// The owner leaving hands ownership to the moderator
+	if err := send("owner", Message{Type: Leave}); err != nil {
+		t.Fatalf("Failed to leave: %v", err)
+	}
+	if role := roleOf("mod"); role != RoleOwner {
+		t.Errorf("Expected mod to become owner, got %s", role)
+	}
+
+	// Without moderators ownership passes to the earliest joined peer
+	sm.RemoveClient("mod")
+	if role := roleOf("client-2"); role != RoleOwner {
+		t.Errorf("Expected client-2 to become owner, got %s", role)
+	}
+
+	// The locked room can still be unlocked by its new owner
+	if err := send("client-2", Message{Type: UnlockRoom}); err != nil {
+		t.Errorf("Expected new owner to unlock the room: %v", err)
+	}
+
+	// Roles and mutes do not survive leaving and rejoining
+	grant("client-2", "client-4", RoleModerator)
+	send("client-4", Message{Type: Leave})
+	send("client-4", Message{Type: Join})
+	for _, peer := range sm.GetPeerInfos("test-room") {
+		if peer.ID == "client-4" && (peer.Role != RoleParticipant || peer.Muted) {
+			t.Errorf("Expected client-4 to rejoin as unmuted participant, got %+v", peer)
+		}
+	}
+
+	// A former owner rejoins as a participant
+	send("owner", Message{Type: Join})
+	if role := roleOf("owner"); role != RoleParticipant {
+		t.Errorf("Expected former owner to rejoin as participant, got %s", role)
+	}
+	if roles := sm.Snapshot()[0].Roles; !reflect.DeepEqual(roles, map[string]Role{"client-2": RoleOwner}) {
+		t.Errorf("Expected only client-2 to hold a role, got %v", roles)
+	}
+}
+
+func TestExpiredPeersLeaveAllRooms(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithConnections(testsupport.NewWebSocketHandler()),
+	)
+	noop := func(string, []byte) error { return nil }
+
+	join := func(clientID, roomID string) {
+		data, _ := json.Marshal(Message{Type: Join, Room: roomID})
+		sm.ProcessMessage(data, clientID, noop)
+	}
+	join("client-1", "idle-room")
+	now = now.Add(20 * time.Minute)
+	join("client-1", "busy-room")
+	join("client-2", "busy-room")
+
+	// A relay naming the idle room from a non-member does not keep it alive
+	relayJSON, _ := json.Marshal(Message{Type: Offer, Room: "idle-room", Recipient: "client-1"})
+	sm.ProcessMessage(relayJSON, "outsider", noop)
+
+	now = now.Add(15 * time.Minute)
+	if expired := sm.ExpireIdleRooms(30 * time.Minute); len(expired) != 1 || expired[0] != "idle-room" {
+		t.Fatalf("Expected idle-room to expire, got %v", expired)
+	}
+
+	// The disconnected peer no longer lingers in its other rooms
+	if peers := sm.GetPeersInRoom("busy-room"); len(peers) != 1 || peers[0] != "client-2" {
+		t.Errorf("Expected only client-2 in busy-room, got %v", peers)
+	}
+}
+
+func TestRoomStats(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sm := NewSignalingManager(testsupport.NewLogger(), WithClock(func() time.Time { return now }))
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "stats-room"})
+	for _, client := range []string{"client-1", "client-2", "client-3"} {
+		sm.ProcessMessage(joinJSON, client, noop)
+	}
+	sm.RemoveClient("client-3")
+
+	now = now.Add(5 * time.Second)
+	offerJSON, _ := json.Marshal(Message{Type: Offer, Room: "stats-room", Recipient: "client-2", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	sm.ProcessMessage(offerJSON, "client-1", noop)
+
+	now = now.Add(time.Minute)
+	sm.mutex.RLock()
+	room := sm.rooms["stats-room"]
+	room.mutex.RLock()
+	stats := room.stats(now)
+	room.mutex.RUnlock()
+	sm.mutex.RUnlock()
+
+	if stats.Lifetime != time.Minute+5*time.Second {
+		t.Errorf("Expected a lifetime of 1m5s, got %s", stats.Lifetime)
+	}
+	if stats.MaxPeers != 3 {
+		t.Errorf("Expected a peak of 3 peers, got %d", stats.MaxPeers)
+	}
+	if !stats.Relayed || stats.JoinToFirstRelay != 5*time.Second {
+		t.Errorf("Expected the first relay 5s after the first join, got %+v", stats)
+	}
+}
+
+func TestRelayThroughRoomBackend(t *testing.T) {
+	bus := cluster.NewMemoryBus()
+	delivered := make(map[string][]byte)
+	deliver := func(clientID string, message []byte) error {
+		delivered[clientID] = message
+		return nil
+	}
+
+	// Two instances sharing rooms through the bus, with one peer connected to each
+	nodeA := NewSignalingManager(testsupport.NewLogger(),
+		WithRoomBackend(cluster.NewBackend(bus, deliver, testsupport.NewLogger(), cluster.WithNodeID("node-a"))))
+	nodeB := NewSignalingManager(testsupport.NewLogger(),
+		WithRoomBackend(cluster.NewBackend(bus, deliver, testsupport.NewLogger(), cluster.WithNodeID("node-b"))))
+
+	local := make(map[string][]byte)
+	sender := func(clientID string, message []byte) error {
+		local[clientID] = message
+		return nil
+	}
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "shared-room"})
+	nodeA.ProcessMessage(joinJSON, "client-a", sender)
+	nodeB.ProcessMessage(joinJSON, "client-b", sender)
+	delete(local, "client-b")
+
+	offerJSON, _ := json.Marshal(Message{Type: Offer, Room: "shared-room", Recipient: "client-b", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	if err := nodeA.ProcessMessage(offerJSON, "client-a", sender); err != nil {
+		t.Fatalf("Relay failed: %v", err)
+	}
+
+	var msg Message
+	if err := json.Unmarshal(delivered["client-b"], &msg); err != nil {
+		t.Fatalf("Expected the offer to reach client-b through the backend: %v", err)
+	}
+	if msg.Type != Offer || msg.Sender != "client-a" {
+		t.Errorf("Unexpected relayed message: %+v", msg)
+	}
+	if _, ok := local["client-b"]; ok {
+		t.Error("Expected the offer not to be sent to a local connection")
+	}
+
+	// Peers on the same instance are still reached directly
+	nodeA.ProcessMessage(joinJSON, "client-c", sender)
+	delete(local, "client-c")
+	offerJSON, _ = json.Marshal(Message{Type: Offer, Room: "shared-room", Recipient: "client-c"})
+	nodeA.ProcessMessage(offerJSON, "client-a", sender)
+	if _, ok := local["client-c"]; !ok {
+		t.Error("Expected the offer to a local peer to be sent directly")
+	}
+	if _, ok := delivered["client-c"]; ok {
+		t.Error("Expected the offer to a local peer not to go through the backend")
+	}
+}
+
+func TestAtLeastOnceRelay(t *testing.T) {
+	bus := cluster.NewMemoryBus()
+	delivered := make(map[string][]byte)
+	deliver := func(clientID string, message []byte) error {
+		delivered[clientID] = message
+		return nil
+	}
+	backendA := cluster.NewBackend(bus, deliver, testsupport.NewLogger(), cluster.WithNodeID("node-a"), cluster.WithAtLeastOnce(time.Minute, 3))
+	nodeA := NewSignalingManager(testsupport.NewLogger(), WithRoomBackend(backendA), WithRelayIDs())
+	nodeB := NewSignalingManager(testsupport.NewLogger(),
+		WithRoomBackend(cluster.NewBackend(bus, deliver, testsupport.NewLogger(), cluster.WithNodeID("node-b"), cluster.WithAtLeastOnce(time.Minute, 3))), WithRelayIDs())
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "shared-room"})
+	nodeA.ProcessMessage(joinJSON, "client-a", noop)
+	nodeB.ProcessMessage(joinJSON, "client-b", noop)
+
+	// Clients cannot choose the idempotency key
+	answerJSON, _ := json.Marshal(Message{Type: Answer, Room: "shared-room", Recipient: "client-b", ID: "mine", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	var ids []string
+	for i := 0; i < 2; i++ {
+		if err := nodeA.ProcessMessage(answerJSON, "client-a", noop); err != nil {
+			t.Fatalf("Relay failed: %v", err)
+		}
+		var msg Message
+		json.Unmarshal(delivered["client-b"], &msg)
+		if msg.Type != Answer || msg.ID == "" || msg.ID == "mine" {
+			t.Errorf("Expected the answer to carry an idempotency key of the server, got %+v", msg)
+		}
+		ids = append(ids, msg.ID)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("Expected each message to have its own key, got %v", ids)
+	}
+	if backendA.PendingRelays() != 0 {
+		t.Errorf("Expected the relays to be acknowledged, got %d pending", backendA.PendingRelays())
+	}
+}
+
+func TestBroadcastRelay(t *testing.T) {
+	bus := cluster.NewMemoryBus()
+	delivered := make(map[string][]byte)
+	deliver := func(clientID string, message []byte) error {
+		delivered[clientID] = message
+		return nil
+	}
+	nodeA := NewSignalingManager(testsupport.NewLogger(),
+		WithRoomBackend(cluster.NewBackend(bus, deliver, testsupport.NewLogger(), cluster.WithNodeID("node-a"))))
+	nodeB := NewSignalingManager(testsupport.NewLogger(),
+		WithRoomBackend(cluster.NewBackend(bus, deliver, testsupport.NewLogger(), cluster.WithNodeID("node-b"))))
+
+	local := make(map[string][]byte)
+	sender := func(clientID string, message []byte) error {
+		local[clientID] = message
+		return nil
+	}
+
+	// A mesh call with two peers on node A and one on node B
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "mesh"})
+	nodeA.ProcessMessage(joinJSON, "alice", sender)
+	nodeA.ProcessMessage(joinJSON, "bob", sender)
+	nodeB.ProcessMessage(joinJSON, "carol", sender)
+	local = make(map[string][]byte)
+
+	candidateJSON, _ := json.Marshal(Message{Type: ICECandidate, Room: "mesh", Payload: json.RawMessage(`{"candidate":""}`)})
+	if err := nodeA.ProcessMessage(candidateJSON, "alice", sender); err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+
+	if _, ok := local["alice"]; ok {
+		t.Error("Expected the broadcast not to be sent back to the sender")
+	}
+	for clientID, message := range map[string][]byte{"bob": local["bob"], "carol": delivered["carol"]} {
+		var msg Message
+		if err := json.Unmarshal(message, &msg); err != nil {
+			t.Fatalf("Expected the broadcast to reach %s: %v", clientID, err)
+		}
+		if msg.Type != ICECandidate || msg.Sender != "alice" || msg.Recipient != "" {
+			t.Errorf("Unexpected broadcast to %s: %+v", clientID, msg)
+		}
+	}
+
+	// Only peers of the room may broadcast to it
+	var reply Message
+	outsider := func(clientID string, message []byte) error {
+		json.Unmarshal(message, &reply)
+		return nil
+	}
+	if err := nodeA.ProcessMessage(candidateJSON, "mallory", outsider); err == nil {
+		t.Error("Expected a broadcast from a non-peer to be rejected")
+	}
+	if reply.Type != Error {
+		t.Errorf("Expected an error reply to the non-peer, got %+v", reply)
+	}
+
+	noRoomJSON, _ := json.Marshal(Message{Type: Offer})
+	if err := nodeA.ProcessMessage(noRoomJSON, "alice", sender); err == nil {
+		t.Error("Expected a relay without recipient or room to be rejected")
+	}
+}
+
+// partitionedBus is a cluster transport that can be cut off the bus it
+// wraps, neither publishing nor receiving while partitioned
+type partitionedBus struct {
+	*cluster.MemoryBus
+	partitioned bool
+}
+
+func (p *partitionedBus) Publish(channel string, data []byte) error {
+	if p.partitioned {
+		return errors.New("partitioned")
+	}
+	return p.MemoryBus.Publish(channel, data)
+}
+
+func (p *partitionedBus) Subscribe(channel string, handle func([]byte)) (func() error, error) {
+	return p.MemoryBus.Subscribe(channel, func(data []byte) {
+		if !p.partitioned {
+			handle(data)
+		}
+	})
+}
+
+func (p *partitionedBus) Ping() error {
+	if p.partitioned {
+		return errors.New("partitioned")
+	}
+	return nil
+}
+
+func TestDegradedRooms(t *testing.T) {
+	bus := cluster.NewMemoryBus()
+	linkA := &partitionedBus{MemoryBus: bus}
+	delivered := make(map[string][]byte)
+	deliver := func(clientID string, message []byte) error {
+		delivered[clientID] = message
+		return nil
+	}
+
+	conns := testsupport.NewWebSocketHandler()
+	var nodeA *SignalingManager
+	backendA := cluster.NewBackend(linkA, deliver, testsupport.NewLogger(), cluster.WithNodeID("node-a"),
+		cluster.WithPartitionHandler(func(roomIDs []string, degraded bool) {
+			nodeA.SetDegraded(roomIDs, degraded)
+		}),
+	)
+	nodeA = NewSignalingManager(testsupport.NewLogger(), WithConnections(conns), WithRoomBackend(backendA))
+	nodeB := NewSignalingManager(testsupport.NewLogger(),
+		WithRoomBackend(cluster.NewBackend(bus, deliver, testsupport.NewLogger(), cluster.WithNodeID("node-b"))))
+
+	var replies []Message
+	sender := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		replies = append(replies, msg)
+		return nil
+	}
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "shared-room"})
+	nodeA.ProcessMessage(joinJSON, "alice", sender)
+	nodeA.ProcessMessage(joinJSON, "bob", sender)
+	nodeB.ProcessMessage(joinJSON, "carol", sender)
+
+	degradedNotice := func(clientID string) (DegradedPayload, bool) {
+		var payload DegradedPayload
+		sent := conns.Sent[clientID]
+		if len(sent) == 0 {
+			return payload, false
+		}
+		var msg Message
+		json.Unmarshal(sent[len(sent)-1], &msg)
+		if msg.Type != Degraded || msg.Room != "shared-room" {
+			return payload, false
+		}
+		json.Unmarshal(msg.Payload, &payload)
+		return payload, true
+	}
+
+	linkA.partitioned = true
+	backendA.Check()
+	for _, peer := range []string{"alice", "bob"} {
+		if payload, ok := degradedNotice(peer); !ok || !payload.Degraded {
+			t.Errorf("Expected %s to be notified of the degraded room, got %v", peer, conns.Sent[peer])
+		}
+	}
+
+	// New peers are rejected, existing ones keep signaling on this instance
+	replies = nil
+	if err := nodeA.ProcessMessage(joinJSON, "dave", sender); !errors.Is(err, ErrRoomDegraded) {
+		t.Errorf("Expected the join to be rejected as degraded, got %v", err)
+	}
+	offerJSON, _ := json.Marshal(Message{Type: Offer, Room: "shared-room", Recipient: "carol", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	if err := nodeA.ProcessMessage(offerJSON, "alice", sender); !errors.Is(err, ErrRoomDegraded) {
+		t.Errorf("Expected the relay to another instance to be rejected, got %v", err)
+	}
+	if len(replies) != 2 || replies[0].Type != Error || replies[1].Type != Error {
+		t.Errorf("Expected error replies to the join and the relay, got %+v", replies)
+	}
+	offerJSON, _ = json.Marshal(Message{Type: Offer, Room: "shared-room", Recipient: "bob", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	if err := nodeA.ProcessMessage(offerJSON, "alice", sender); err != nil {
+		t.Errorf("Expected the relay to a local peer to succeed, got %v", err)
+	}
+
+	linkA.partitioned = false
+	backendA.Check()
+	if payload, ok := degradedNotice("alice"); !ok || payload.Degraded {
+		t.Errorf("Expected alice to be notified of the recovery, got %v", conns.Sent["alice"])
+	}
+	if err := nodeA.ProcessMessage(joinJSON, "dave", sender); err != nil {
+		t.Errorf("Expected the join to succeed after the recovery, got %v", err)
+	}
+	offerJSON, _ = json.Marshal(Message{Type: Offer, Room: "shared-room", Recipient: "carol", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	if err := nodeA.ProcessMessage(offerJSON, "alice", sender); err != nil {
+		t.Fatalf("Expected the relay to succeed after the recovery, got %v", err)
+	}
+	if _, ok := delivered["carol"]; !ok {
+		t.Error("Expected the offer to reach carol on the other instance")
+	}
+}
+
+// memoryStore is a RoomStore keeping records in a map
+type memoryStore map[string][]byte
+
+func (s memoryStore) Put(roomID string, record []byte) error {
+	s[roomID] = record
+	return nil
+}
+
+func (s memoryStore) Get(roomID string) ([]byte, error) {
+	record, ok := s[roomID]
+	if !ok {
+		return nil, fmt.Errorf("no record: %s", roomID)
+	}
+	return record, nil
+}
+
+func (s memoryStore) Delete(roomID string) error {
+	delete(s, roomID)
+	return nil
+}
+
+func TestSpillColdRooms(t *testing.T) {
+	now := time.Now()
+	store := memoryStore{}
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithRoomStore(store, 1),
+	)
+	noop := func(string, []byte) error { return nil }
+
+	coldJSON, _ := json.Marshal(Message{Type: Join, Room: "cold-room", Payload: json.RawMessage(`{"metadata":{"topic":"presence"}}`)})
+	sm.ProcessMessage(coldJSON, "client-1", noop)
+	sm.ProcessMessage(coldJSON, "client-2", noop)
+	lockJSON, _ := json.Marshal(Message{Type: LockRoom, Room: "cold-room"})
+	sm.ProcessMessage(lockJSON, "client-1", noop)
+
+	now = now.Add(time.Minute)
+	hotJSON, _ := json.Marshal(Message{Type: Join, Room: "hot-room"})
+	sm.ProcessMessage(hotJSON, "client-3", noop)
+
+	if spilled := sm.SpillColdRooms(); spilled != 1 {
+		t.Fatalf("Expected 1 room to be spilled, got %d", spilled)
+	}
+	if _, ok := store["cold-room"]; !ok {
+		t.Fatal("Expected the least recently active room to be spilled")
+	}
+	if len(sm.rooms) != 1 {
+		t.Errorf("Expected 1 resident room, got %d", len(sm.rooms))
+	}
+
+	// Membership queries are answered without re-hydrating
+	if sm.GetRoomCount() != 2 || !sm.RoomExists("cold-room") || len(sm.GetPeersInRoom("cold-room")) != 2 {
+		t.Error("Expected the spilled room to remain visible")
+	}
+	if err := checkRoomInvariants(sm); err != nil {
+		t.Error(err)
+	}
+
+	// Accessing the room re-hydrates it with its state
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "cold-room"})
+	if err := sm.ProcessMessage(joinJSON, "client-4", noop); err == nil {
+		t.Error("Expected the re-hydrated room to still be locked")
+	}
+	if _, ok := store["cold-room"]; ok {
+		t.Error("Expected the record to be deleted once re-hydrated")
+	}
+	infos := sm.GetPeerInfos("cold-room")
+	if len(infos) != 2 || infos[0].Role != RoleOwner {
+		t.Errorf("Expected the peers and roles to be restored, got %+v", infos)
+	}
+	if metadata, _ := sm.GetRoomMetadata("cold-room"); string(metadata) != `{"topic":"presence"}` {
+		t.Errorf("Expected the metadata to be restored, got %s", metadata)
+	}
+
+	// Disconnecting the last peers of a spilled room removes it
+	sm.SpillColdRooms()
+	sm.RemoveClient("client-1")
+	sm.RemoveClient("client-2")
+	if sm.RoomExists("cold-room") || len(store) != 0 {
+		t.Error("Expected the emptied room to be removed")
+	}
+
+	// Spilled rooms expire like resident ones
+	sm.ProcessMessage(coldJSON, "client-1", noop)
+	now = now.Add(time.Minute)
+	sm.ProcessMessage(hotJSON, "client-3", noop)
+	sm.SpillColdRooms()
+	if expired := sm.ExpireIdleRooms(30 * time.Second); len(expired) != 1 || expired[0] != "cold-room" {
+		t.Errorf("Expected the spilled room to expire, got %v", expired)
+	}
+	if len(store) != 0 || sm.GetRoomCount() != 1 {
+		t.Error("Expected the expired room to be removed from the store")
+	}
+}
+
+func TestRevokeRooms(t *testing.T) {
+	conns := testsupport.NewWebSocketHandler()
+	sm := NewSignalingManager(testsupport.NewLogger(), WithConnections(conns))
+	noop := func(string, []byte) error { return nil }
+
+	for _, room := range []string{"room-a", "room-b", "room-c"} {
+		joinJSON, _ := json.Marshal(Message{Type: Join, Room: room})
+		sm.ProcessMessage(joinJSON, "client-1", noop)
+	}
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "room-a"})
+	sm.ProcessMessage(joinJSON, "client-2", noop)
+
+	if rooms := sm.ClientRooms("client-1"); !reflect.DeepEqual(rooms, []string{"room-a", "room-b", "room-c"}) {
+		t.Fatalf("Expected client-1 in three rooms, got %v", rooms)
+	}
+
+	// Rooms the client is not in are ignored
+	removed := sm.RevokeRooms("client-1", []string{"room-b", "room-a", "room-x"}, "subscription expired")
+	if !reflect.DeepEqual(removed, []string{"room-a", "room-b"}) {
+		t.Errorf("Expected removal from room-a and room-b, got %v", removed)
+	}
+	if rooms := sm.ClientRooms("client-1"); !reflect.DeepEqual(rooms, []string{"room-c"}) {
+		t.Errorf("Expected client-1 to remain in room-c only, got %v", rooms)
+	}
+	if peers := sm.GetPeersInRoom("room-a"); !reflect.DeepEqual(peers, []string{"client-2"}) {
+		t.Errorf("Expected client-2 to remain in room-a, got %v", peers)
+	}
+	if sm.RoomExists("room-b") {
+		t.Error("Expected the emptied room-b to be deleted")
+	}
+
+	var notices []Message
+	for _, sent := range conns.Sent["client-1"] {
+		var notice Message
+		json.Unmarshal(sent, &notice)
+		if notice.Type == Kicked {
+			notices = append(notices, notice)
+		}
+	}
+	if len(notices) != 2 || notices[0].Room != "room-a" || notices[1].Room != "room-b" {
+		t.Errorf("Expected kicked notices for room-a and room-b, got %+v", notices)
+	}
+	if len(conns.Closed) != 0 {
+		t.Errorf("Expected the connection to stay open, got %+v", conns.Closed)
+	}
+}
+
+func TestObfuscatedPeerIDs(t *testing.T) {
+	sm := NewSignalingManager(testsupport.NewLogger(), WithPeerIDObfuscator(NewHMACPeerIDs([]byte("key"))))
+
+	sent := make(map[string][]Message)
+	sender := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		sent[clientID] = append(sent[clientID], msg)
+		return nil
+	}
+	join := func(clientID, roomID string) JoinedPayload {
+		joinJSON, _ := json.Marshal(Message{Type: Join, Room: roomID})
+		if err := sm.ProcessMessage(joinJSON, clientID, sender); err != nil {
+			t.Fatalf("Join failed: %v", err)
+		}
+		var joined JoinedPayload
+		json.Unmarshal(sent[clientID][len(sent[clientID])-1].Payload, &joined)
+		return joined
+	}
+
+	join("alice", "room-1")
+	joined := join("bob", "room-1")
+	if joined.PeerID == "" || joined.PeerID == "bob" {
+		t.Fatalf("Expected bob to get a pseudonym, got %q", joined.PeerID)
+	}
+	var alicePeerID string
+	for _, peer := range joined.Peers {
+		if peer.ID == "alice" || peer.ID == "bob" {
+			t.Fatalf("Expected no client IDs in the peer list, got %v", joined.Peers)
+		}
+		if peer.ID != joined.PeerID {
+			alicePeerID = peer.ID
+		}
+	}
+
+	// Pseudonyms are unlinkable across rooms
+	join("alice", "room-2")
+	if other := join("bob", "room-2"); other.PeerID == joined.PeerID {
+		t.Errorf("Expected a different pseudonym in another room, got %q twice", other.PeerID)
+	}
+
+	// Peers are addressed by their pseudonyms, and relays carry the sender's pseudonym
+	offerJSON, _ := json.Marshal(Message{Type: Offer, Room: "room-1", Recipient: alicePeerID, Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	if err := sm.ProcessMessage(offerJSON, "bob", sender); err != nil {
+		t.Fatalf("Relay failed: %v", err)
+	}
+	offer := sent["alice"][len(sent["alice"])-1]
+	if offer.Type != Offer || offer.Sender != joined.PeerID || offer.Recipient != alicePeerID {
+		t.Errorf("Expected an offer between pseudonyms, got %+v", offer)
+	}
+
+	// Client IDs cannot be used to address peers
+	offerJSON, _ = json.Marshal(Message{Type: Offer, Room: "room-1", Recipient: "alice", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	if err := sm.ProcessMessage(offerJSON, "bob", sender); err == nil {
+		t.Error("Expected a relay to a client ID to be rejected")
+	}
+
+	// The pseudonym of a peer that left no longer resolves
+	leaveJSON, _ := json.Marshal(Message{Type: Leave, Room: "room-1"})
+	sm.ProcessMessage(leaveJSON, "alice", sender)
+	if _, ok := sm.resolvePeerID("room-1", alicePeerID); ok {
+		t.Error("Expected the pseudonym of a peer that left to be forgotten")
+	}
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// RoomSnapshot is a point-in-time copy of a room's state
+type RoomSnapshot struct {
+	ID                string          `json:"id"`
+	Peers             []string        `json:"peers"`
+	Metadata          json.RawMessage `json:"metadata,omitempty"`
+	Roles             map[string]Role `json:"roles,omitempty"`
+	PasswordProtected bool            `json:"passwordProtected"`
+	Locked            bool            `json:"locked,omitempty"`
+	LastActivity      time.Time       `json:"lastActivity"`
+}
+
+// Snapshot returns a copy of the state of all rooms, sorted by room ID.
+// Spilled rooms are read from the room store without re-hydrating them.
+func (sm *SignalingManager) Snapshot() []RoomSnapshot {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	snapshots := make([]RoomSnapshot, 0, len(sm.rooms)+len(sm.spilled))
+	for _, room := range sm.rooms {
+		room.mutex.RLock()
+		snapshots = append(snapshots, room.snapshot())
+		room.mutex.RUnlock()
+	}
+	for roomID := range sm.spilled {
+		rec, err := sm.loadRecord(roomID)
+		if err != nil {
+			sm.logger.Warn("Failed to load spilled room record", "error", err, "room_id", roomID)
+			continue
+		}
+		snapshots = append(snapshots, rec.room().snapshot())
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].ID < snapshots[j].ID
+	})
+	return snapshots
+}
+
+// snapshot returns a copy of the room's state. Must be called with the room mutex held.
+func (r *Room) snapshot() RoomSnapshot {
+	peers := peerList(r)
+	sort.Strings(peers)
+	return RoomSnapshot{
+		ID:                r.ID,
+		Peers:             peers,
+		Metadata:          r.Metadata,
+		Roles:             roleMap(r),
+		PasswordProtected: r.passwordHash != nil,
+		Locked:            r.locked,
+		LastActivity:      r.lastActivity,
+	}
+}
+
+// roleMap returns the roles of the room's peers other than participants. Must be called with the room mutex held.
+func roleMap(room *Room) map[string]Role {
+	roles := make(map[string]Role)
+	for peer := range room.Peers {
+		if role := room.roleOf(peer); role != RoleParticipant {
+			roles[peer] = role
+		}
+	}
+	return roles
+}
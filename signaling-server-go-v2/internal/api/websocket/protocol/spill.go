@@ -0,0 +1,260 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
+)
+
+// RoomStore holds the records of cold rooms spilled out of memory
+type RoomStore interface {
+	// Put stores the record of a room, replacing any previous record
+	Put(roomID string, record []byte) error
+
+	// Get returns the record of a room, or an error if there is none
+	Get(roomID string) ([]byte, error)
+
+	// Delete removes the record of a room
+	Delete(roomID string) error
+}
+
+// WithRoomStore bounds the number of rooms held in memory. Whenever more than
+// maxResident rooms are resident, the janitor spills the least recently
+// active rooms to the store; they are re-hydrated when next accessed.
+func WithRoomStore(store RoomStore, maxResident int) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.store = store
+		sm.maxResident = maxResident
+	}
+}
+
+// spilledRoom is what stays in memory of a room spilled to the store, enough
+// to answer membership queries and expire the room without loading it
+type spilledRoom struct {
+	peers        []string
+	lastActivity time.Time
+}
+
+// roomRecord is the stored form of a spilled room
+type roomRecord struct {
+	ID           string            `json:"id"`
+	Peers        []string          `json:"peers"` // in join order
+	Metadata     json.RawMessage   `json:"metadata,omitempty"`
+	Owner        string            `json:"owner,omitempty"`
+	Roles        map[string]Role   `json:"roles,omitempty"`
+	Muted        []string          `json:"muted,omitempty"`
+	BatchedJoins []string          `json:"batchedJoins,omitempty"`
+	DisplayNames map[string]string `json:"displayNames,omitempty"`
+	Locked       bool              `json:"locked,omitempty"`
+	PasswordHash []byte            `json:"passwordHash,omitempty"`
+	LastActivity time.Time         `json:"lastActivity"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	FirstJoinAt  time.Time         `json:"firstJoinAt"`
+	FirstRelayAt time.Time         `json:"firstRelayAt"`
+	MaxPeers     int               `json:"maxPeers"`
+	PeerListSeq  uint64            `json:"peerListSeq,omitempty"`
+}
+
+// record returns the stored form of the room. Must be called with the room mutex held.
+func (r *Room) record() roomRecord {
+	muted := make([]string, 0, len(r.muted))
+	for peer := range r.muted {
+		muted = append(muted, peer)
+	}
+	var batchedJoins []string
+	for peer := range r.batchedJoins {
+		batchedJoins = append(batchedJoins, peer)
+	}
+
+	return roomRecord{
+		ID:           r.ID,
+		Peers:        append([]string(nil), r.joinOrder...),
+		Metadata:     r.Metadata,
+		Owner:        r.Owner,
+		Roles:        r.roles,
+		Muted:        muted,
+		BatchedJoins: batchedJoins,
+		DisplayNames: r.names,
+		Locked:       r.locked,
+		PasswordHash: r.passwordHash,
+		LastActivity: r.lastActivity,
+		CreatedAt:    r.createdAt,
+		FirstJoinAt:  r.firstJoinAt,
+		FirstRelayAt: r.firstRelayAt,
+		MaxPeers:     r.maxPeers,
+		PeerListSeq:  r.peerSeq,
+	}
+}
+
+// room restores a room from its stored form
+func (rec roomRecord) room() *Room {
+	room := &Room{
+		ID:           rec.ID,
+		Peers:        make(map[string]struct{}, len(rec.Peers)),
+		Metadata:     rec.Metadata,
+		Owner:        rec.Owner,
+		roles:        rec.Roles,
+		joinOrder:    rec.Peers,
+		locked:       rec.Locked,
+		passwordHash: rec.PasswordHash,
+		lastActivity: rec.LastActivity,
+		createdAt:    rec.CreatedAt,
+		firstJoinAt:  rec.FirstJoinAt,
+		firstRelayAt: rec.FirstRelayAt,
+		maxPeers:     rec.MaxPeers,
+	}
+	for _, peer := range rec.Peers {
+		room.Peers[peer] = struct{}{}
+	}
+	if len(rec.Muted) > 0 {
+		room.muted = make(map[string]struct{}, len(rec.Muted))
+		for _, peer := range rec.Muted {
+			room.muted[peer] = struct{}{}
+		}
+	}
+	if len(rec.BatchedJoins) > 0 {
+		room.batchedJoins = make(map[string]struct{}, len(rec.BatchedJoins))
+		for _, peer := range rec.BatchedJoins {
+			room.batchedJoins[peer] = struct{}{}
+		}
+	}
+	for peer, name := range rec.DisplayNames {
+		room.claimName(peer, name, DisplayNameSuffix)
+	}
+
+	// The peer list changes are not stored, so deltas start from the restored list
+	room.peerSeq, room.peerChanges, room.peerChangesFrom = rec.PeerListSeq, nil, rec.PeerListSeq
+	return room
+}
+
+// SpillColdRooms spills the least recently active rooms to the room store
+// until no more than the configured number of rooms are resident. It returns
+// the number of rooms spilled.
+func (sm *SignalingManager) SpillColdRooms() int {
+	if sm.store == nil || sm.maxResident <= 0 {
+		return 0
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	excess := len(sm.rooms) - sm.maxResident
+	if excess <= 0 {
+		return 0
+	}
+
+	records := make([]roomRecord, 0, len(sm.rooms))
+	for _, room := range sm.rooms {
+		room.mutex.RLock()
+		records = append(records, room.record())
+		room.mutex.RUnlock()
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].LastActivity.Before(records[j].LastActivity)
+	})
+
+	spilled := 0
+	for _, rec := range records[:excess] {
+		data, err := json.Marshal(rec)
+		if err == nil {
+			err = sm.store.Put(rec.ID, data)
+		}
+		if err != nil {
+			sm.logger.Error("Failed to spill room", "error", err, "room_id", rec.ID)
+			continue
+		}
+
+		delete(sm.rooms, rec.ID)
+		sm.spilled[rec.ID] = spilledRoom{peers: rec.Peers, lastActivity: rec.LastActivity}
+		spilled++
+	}
+
+	if spilled > 0 {
+		sm.logger.Info("Spilled cold rooms", "count", spilled, "resident", len(sm.rooms), "spilled", len(sm.spilled))
+	}
+	return spilled
+}
+
+// rehydrate makes a room resident again if it was spilled
+func (sm *SignalingManager) rehydrate(roomID string) {
+	if sm.store == nil {
+		return
+	}
+
+	sm.mutex.RLock()
+	_, spilled := sm.spilled[roomID]
+	sm.mutex.RUnlock()
+	if !spilled {
+		return
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.rehydrateLocked(roomID)
+}
+
+// rehydrateLocked makes a room resident again if it was spilled. A room that
+// fails to load stays spilled. Must be called with the manager mutex held.
+func (sm *SignalingManager) rehydrateLocked(roomID string) {
+	if _, ok := sm.spilled[roomID]; !ok {
+		return
+	}
+
+	rec, err := sm.loadRecord(roomID)
+	if err != nil {
+		sm.logger.Error("Failed to re-hydrate room", "error", err, "room_id", roomID)
+		return
+	}
+
+	// A leftover record is harmless, it is replaced when the room is spilled again
+	if err := sm.store.Delete(roomID); err != nil {
+		sm.logger.Warn("Failed to delete spilled room record", "error", err, "room_id", roomID)
+	}
+	delete(sm.spilled, roomID)
+	sm.rooms[roomID] = rec.room()
+	sm.logger.Debug("Re-hydrated room", "room_id", roomID)
+}
+
+// dropSpilledLocked removes a spilled room for good, returning its stats for
+// the room metrics. Must be called with the manager mutex held.
+func (sm *SignalingManager) dropSpilledLocked(roomID string) metrics.RoomStats {
+	var stats metrics.RoomStats
+	if rec, err := sm.loadRecord(roomID); err == nil {
+		stats = rec.room().stats(sm.now())
+	} else {
+		sm.logger.Warn("Failed to load spilled room record", "error", err, "room_id", roomID)
+	}
+
+	if err := sm.store.Delete(roomID); err != nil {
+		sm.logger.Warn("Failed to delete spilled room record", "error", err, "room_id", roomID)
+	}
+	delete(sm.spilled, roomID)
+	return stats
+}
+
+// loadRecord reads the record of a spilled room from the store
+func (sm *SignalingManager) loadRecord(roomID string) (roomRecord, error) {
+	data, err := sm.store.Get(roomID)
+	if err != nil {
+		return roomRecord{}, err
+	}
+
+	var rec roomRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return roomRecord{}, fmt.Errorf("invalid record of room %s: %w", roomID, err)
+	}
+	return rec, nil
+}
+
+// hasPeer reports whether the client is a peer of the spilled room
+func (s spilledRoom) hasPeer(clientID string) bool {
+	for _, peer := range s.peers {
+		if peer == clientID {
+			return true
+		}
+	}
+	return false
+}
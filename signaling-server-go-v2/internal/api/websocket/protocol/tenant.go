@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A tenant is the top-level namespace of room IDs. The clients of a tenant
+// name their rooms relative to it, so that tenants sharing a cluster cannot
+// collide or reach each other's rooms: the room "standup" of a client of the
+// tenant "acme" is the room "acme/standup" on the server.
+
+// QualifyRoom returns the server room ID of a room named by a client of the tenant
+func QualifyRoom(tenant, roomID string) string {
+	return tenant + NamespaceSeparator + roomID
+}
+
+// UnqualifyRoom returns the room ID a client of the tenant names a server
+// room by, reporting false if the room lies outside the tenant
+func UnqualifyRoom(tenant, roomID string) (string, bool) {
+	return strings.CutPrefix(roomID, tenant+NamespaceSeparator)
+}
+
+// QualifyMessage rewrites the room of a JSON message sent by a client of the
+// tenant into the tenant's namespace, keeping its other fields as sent
+func QualifyMessage(tenant string, message []byte) ([]byte, error) {
+	return rewriteRoom(message, func(roomID string) string {
+		return QualifyRoom(tenant, roomID)
+	})
+}
+
+// UnqualifyMessage rewrites the room of a JSON message sent to a client of
+// the tenant relative to the tenant's namespace. Messages without a room,
+// such as operator notices, are returned unchanged.
+func UnqualifyMessage(tenant string, message []byte) ([]byte, error) {
+	return rewriteRoom(message, func(roomID string) string {
+		if relative, ok := UnqualifyRoom(tenant, roomID); ok {
+			return relative
+		}
+		return roomID
+	})
+}
+
+// rewriteRoom rewrites the room field of a JSON message, if set. Messages
+// decode their fields regardless of case, so the room is found the same way,
+// and a message setting it under several keys is rejected rather than letting
+// one of them escape the rewrite.
+func rewriteRoom(message []byte, rewrite func(roomID string) string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	var key string
+	for name := range fields {
+		if !strings.EqualFold(name, "room") {
+			continue
+		}
+		if key != "" {
+			return nil, fmt.Errorf("invalid message: room set as both %q and %q", key, name)
+		}
+		key = name
+	}
+
+	var roomID string
+	if raw, ok := fields[key]; !ok || json.Unmarshal(raw, &roomID) != nil || roomID == "" {
+		return message, nil
+	}
+	room, err := json.Marshal(rewrite(roomID))
+	if err != nil {
+		return nil, err
+	}
+	fields[key] = room
+	return json.Marshal(fields)
+}
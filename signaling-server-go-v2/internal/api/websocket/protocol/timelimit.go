@@ -0,0 +1,174 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DefaultRoomCloseWarnings are how long before its time limit the peers of a
+// room are warned that it closes, unless configured otherwise
+var DefaultRoomCloseWarnings = []time.Duration{5 * time.Minute, time.Minute}
+
+// TimeLimitReason is the reason of the room-closed notice of rooms closed at their time limit
+const TimeLimitReason = "room time limit reached"
+
+// timeLimitInterval bounds how late time limits are enforced and warnings sent
+const timeLimitInterval = 5 * time.Second
+
+// RoomClosingPayload is the payload of room-closing warnings
+type RoomClosingPayload struct {
+	ClosesAt time.Time `json:"closesAt"`
+	ClosesIn int       `json:"closesIn"` // in seconds
+	Message  string    `json:"message"`  // e.g. "room closes in 5m"
+}
+
+// timeLimit is the time at which a room closes
+type timeLimit struct {
+	deadline time.Time
+
+	// warned is the last warning sent, 0 if none
+	warned time.Duration
+}
+
+// WithRoomCloseWarnings sets how long before their time limit the peers of
+// rooms are warned that they close
+func WithRoomCloseWarnings(warnings []time.Duration) ManagerOption {
+	return func(sm *SignalingManager) {
+		sm.closeWarnings = append([]time.Duration{}, warnings...)
+	}
+}
+
+// limitRoomLocked sets the time limit of a room created now from its
+// namespace policy, replacing any left by a closed room of the same ID. Must
+// be called with sm.mutex held.
+func (sm *SignalingManager) limitRoomLocked(roomID string, policy NamespacePolicy) {
+	delete(sm.timeLimits, roomID)
+	if policy.MaxDuration > 0 {
+		sm.timeLimits[roomID] = &timeLimit{deadline: sm.now().Add(policy.MaxDuration)}
+	}
+}
+
+// SetRoomTimeLimit limits the duration of an existing room, counted from its
+// creation, overriding the limit of its namespace policy. A maxDuration of
+// zero lifts the limit. It returns the time at which the room closes.
+func (sm *SignalingManager) SetRoomTimeLimit(roomID string, maxDuration time.Duration) (time.Time, error) {
+	sm.rehydrate(roomID)
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	room, ok := sm.rooms[roomID]
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+
+	if maxDuration <= 0 {
+		delete(sm.timeLimits, roomID)
+		sm.logger.Info("Room time limit lifted", "room_id", roomID)
+		return time.Time{}, nil
+	}
+
+	room.mutex.RLock()
+	deadline := room.createdAt.Add(maxDuration)
+	room.mutex.RUnlock()
+
+	// Warnings already sent are sent again as the new deadline approaches
+	sm.timeLimits[roomID] = &timeLimit{deadline: deadline}
+	sm.logger.Info("Room time limit set", "room_id", roomID, "max_duration", maxDuration.String(), "closes_at", deadline)
+	return deadline, nil
+}
+
+// EnforceRoomTimeLimits warns the peers of rooms approaching their time limit
+// and closes the rooms that reached it. It returns the IDs of closed rooms.
+func (sm *SignalingManager) EnforceRoomTimeLimits() []string {
+	now := sm.now()
+	expired := make(map[string]struct{})
+	warnings := make(map[string]RoomClosingPayload)
+	warned := make(map[string][]string)
+
+	sm.mutex.Lock()
+	for roomID, limit := range sm.timeLimits {
+		var peers []string
+		if room, ok := sm.rooms[roomID]; ok {
+			room.mutex.RLock()
+			peers = peerList(room)
+			room.mutex.RUnlock()
+		} else if spilled, ok := sm.spilled[roomID]; ok {
+			peers = append(peers, spilled.peers...)
+		} else {
+			// The room closed before its time limit
+			delete(sm.timeLimits, roomID)
+			continue
+		}
+
+		remaining := limit.deadline.Sub(now)
+		if remaining <= 0 {
+			expired[roomID] = struct{}{}
+			delete(sm.timeLimits, roomID)
+			continue
+		}
+
+		if warning := sm.dueWarning(limit, remaining); warning > 0 {
+			limit.warned = warning
+			warned[roomID] = peers
+			warnings[roomID] = RoomClosingPayload{
+				ClosesAt: limit.deadline,
+				ClosesIn: int((remaining + time.Second - 1) / time.Second),
+				Message:  "room closes in " + formatRemaining(remaining),
+			}
+		}
+	}
+	sm.mutex.Unlock()
+
+	for roomID, payload := range warnings {
+		notice, err := newRoomClosingNotice(roomID, payload)
+		if err != nil {
+			sm.logger.Error("Failed to marshal room closing notice", "error", err)
+			continue
+		}
+		sm.notifyPeers(warned[roomID], notice)
+		sm.logger.Info("Room closing", "room_id", roomID, "closes_in", payload.ClosesIn)
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+	result := sm.closeRooms(func(roomID string) bool {
+		_, ok := expired[roomID]
+		return ok
+	}, "time_limit", LeaveReasonTimeLimit, TimeLimitReason, false)
+	return result.Rooms
+}
+
+// dueWarning returns the warning due for a room with the remaining time, 0
+// if none: the shortest warning not yet sent that the remaining time is
+// within, so that a room limited to less than the longest warning is only
+// warned once. Must be called with sm.mutex held.
+func (sm *SignalingManager) dueWarning(limit *timeLimit, remaining time.Duration) time.Duration {
+	var due time.Duration
+	for _, warning := range sm.closeWarnings {
+		if remaining <= warning && (limit.warned == 0 || warning < limit.warned) {
+			due = warning
+		}
+	}
+	return due
+}
+
+// formatRemaining formats the time left before a room closes in whole
+// minutes, or seconds under a minute, rounded up
+func formatRemaining(remaining time.Duration) string {
+	if remaining < time.Minute {
+		return fmt.Sprintf("%ds", (remaining+time.Second-1)/time.Second)
+	}
+	return fmt.Sprintf("%dm", (remaining+time.Minute-1)/time.Minute)
+}
+
+// newRoomClosingNotice builds the room-closing warning sent to the peers of a room
+func newRoomClosingNotice(roomID string, closing RoomClosingPayload) ([]byte, error) {
+	payload, err := json.Marshal(closing)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Message{Type: RoomClosing, Room: roomID, Payload: payload})
+}
@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestRoomTimeLimits(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	conns := testsupport.NewWebSocketHandler()
+	sm := NewSignalingManager(testsupport.NewLogger(),
+		WithClock(func() time.Time { return now }),
+		WithConnections(conns),
+	)
+	sm.SetNamespacePolicy("trial", NamespacePolicy{MaxDuration: 40 * time.Minute})
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "trial/standup"})
+	sm.ProcessMessage(joinJSON, "alice", noop)
+	unlimitedJSON, _ := json.Marshal(Message{Type: Join, Room: "paid/standup"})
+	sm.ProcessMessage(unlimitedJSON, "bob", noop)
+
+	last := func(clientID string) (Message, RoomClosingPayload) {
+		sent := conns.Sent[clientID]
+		if len(sent) == 0 {
+			return Message{}, RoomClosingPayload{}
+		}
+		var msg Message
+		var payload RoomClosingPayload
+		json.Unmarshal(sent[len(sent)-1], &msg)
+		json.Unmarshal(msg.Payload, &payload)
+		return msg, payload
+	}
+
+	now = now.Add(30 * time.Minute)
+	sm.EnforceRoomTimeLimits()
+	if len(conns.Sent["alice"]) != 0 {
+		t.Fatalf("Expected no warning 10 minutes before the limit, got %d messages", len(conns.Sent["alice"]))
+	}
+
+	// Peers are warned once per warning
+	now = now.Add(5*time.Minute + 30*time.Second)
+	sm.EnforceRoomTimeLimits()
+	sm.EnforceRoomTimeLimits()
+	msg, payload := last("alice")
+	if len(conns.Sent["alice"]) != 1 || msg.Type != RoomClosing || payload.Message != "room closes in 5m" || payload.ClosesIn != 270 {
+		t.Fatalf("Expected a single 5 minute warning, got %+v %+v", msg, payload)
+	}
+
+	now = now.Add(4 * time.Minute)
+	sm.EnforceRoomTimeLimits()
+	if _, payload := last("alice"); len(conns.Sent["alice"]) != 2 || payload.Message != "room closes in 30s" {
+		t.Fatalf("Expected a final warning, got %+v", payload)
+	}
+
+	// At the limit the room is closed with its reason
+	now = now.Add(30 * time.Second)
+	if closed := sm.EnforceRoomTimeLimits(); len(closed) != 1 || closed[0] != "trial/standup" {
+		t.Fatalf("Expected the trial room to close, got %v", closed)
+	}
+	if sm.RoomExists("trial/standup") || !sm.RoomExists("paid/standup") {
+		t.Error("Expected only the time-limited room to be removed")
+	}
+	msg, _ = last("alice")
+	var notice NoticePayload
+	json.Unmarshal(msg.Payload, &notice)
+	if msg.Type != RoomClosed || notice.Message != TimeLimitReason {
+		t.Errorf("Expected a room-closed notice with the time limit reason, got %+v", msg)
+	}
+	if len(conns.Sent["bob"]) != 0 {
+		t.Errorf("Expected the unlimited room to be left alone, got %d messages", len(conns.Sent["bob"]))
+	}
+
+	// A room recreated with the same ID starts a new limit
+	sm.ProcessMessage(joinJSON, "alice", noop)
+	if closed := sm.EnforceRoomTimeLimits(); len(closed) != 0 {
+		t.Errorf("Expected the recreated room to stay open, got %v", closed)
+	}
+
+	// Operators limit rooms outside of the policies, counted from their creation
+	if _, err := sm.SetRoomTimeLimit("missing", time.Minute); err == nil {
+		t.Error("Expected a limit on a missing room to fail")
+	}
+	if closesAt, err := sm.SetRoomTimeLimit("paid/standup", 30*time.Minute); err != nil || !closesAt.Equal(now.Add(-10*time.Minute)) {
+		t.Fatalf("Expected the paid room to close 30 minutes after its creation, got %v, %v", closesAt, err)
+	}
+	if closed := sm.EnforceRoomTimeLimits(); len(closed) != 1 || closed[0] != "paid/standup" {
+		t.Errorf("Expected the paid room to close past its new limit, got %v", closed)
+	}
+}
@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"context"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+)
+
+// startMessageSpan starts the span of a processed message as a child of the
+// span in the context, linked to the span in the message's trace field if
+// any. The trace field is set to the new span, so that the messages relayed
+// to peers carry it; a malformed trace is dropped rather than relayed.
+func (sm *SignalingManager) startMessageSpan(ctx context.Context, msg *Message) tracing.Span {
+	var link context.Context
+	if msg.Trace != "" {
+		var err error
+		if link, err = tracing.ContextWithTraceparent(context.Background(), msg.Trace); err != nil {
+			sm.logger.Debug("Dropped malformed message trace", "error", err, "client_id", msg.Sender)
+			msg.Trace = ""
+			link = nil
+		}
+	}
+
+	if sm.tracer == nil {
+		return &tracing.NoopSpan{}
+	}
+
+	opts := []tracing.SpanOption{
+		tracing.WithParent(ctx),
+		tracing.WithAttributes(map[string]interface{}{
+			"signaling.message_type": string(msg.Type),
+			"signaling.room":         msg.Room,
+			"signaling.sender":       msg.Sender,
+			"signaling.recipient":    msg.Recipient,
+		}),
+	}
+	if link != nil {
+		opts = append(opts, tracing.WithLink(link))
+	}
+	span := sm.tracer.StartSpan("signaling.message", opts...)
+
+	if traceparent := tracing.Traceparent(span.Context()); traceparent != "" {
+		msg.Trace = traceparent
+	}
+	return span
+}
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// recordingTracer records the spans it starts
+type recordingTracer struct {
+	tracing.NoopTracer
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	tracing.NoopSpan
+	name    string
+	ctx     context.Context
+	options tracing.SpanOptions
+	errors  []error
+}
+
+func (t *recordingTracer) StartSpan(name string, opts ...tracing.SpanOption) tracing.Span {
+	span := &recordingSpan{name: name}
+	for _, opt := range opts {
+		opt(&span.options)
+	}
+	span.ctx = tracing.NewSpanContext(span.options.Parent)
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func (s *recordingSpan) RecordError(err error)    { s.errors = append(s.errors, err) }
+func (s *recordingSpan) Context() context.Context { return s.ctx }
+
+func TestMessageSpans(t *testing.T) {
+	tracer := &recordingTracer{}
+	sm := NewSignalingManager(testsupport.NewLogger(), WithTracer(tracer))
+	received := make(map[string]Message)
+	sender := func(clientID string, message []byte) error {
+		var msg Message
+		json.Unmarshal(message, &msg)
+		received[clientID] = msg
+		return nil
+	}
+
+	connection := tracing.ContextWithSpanIDs(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7")
+	joinJSON, _ := json.Marshal(Message{Type: Join, Room: "call"})
+	sm.ProcessMessageContext(connection, joinJSON, "alice", sender)
+	sm.ProcessMessageContext(connection, joinJSON, "bob", sender)
+
+	clientTrace := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	offerJSON, _ := json.Marshal(Message{Type: Offer, Room: "call", Recipient: "bob", Payload: json.RawMessage(`{"sdp":"v=0"}`), Trace: clientTrace})
+	if err := sm.ProcessMessageContext(connection, offerJSON, "alice", sender); err != nil {
+		t.Fatalf("Failed to relay offer: %v", err)
+	}
+
+	if len(tracer.spans) != 3 {
+		t.Fatalf("Expected a span per message, got %d", len(tracer.spans))
+	}
+	span := tracer.spans[2]
+	if span.name != "signaling.message" || span.options.Parent != connection {
+		t.Errorf("Expected the message span to be a child of the connection span, got %q", span.name)
+	}
+	for key, value := range map[string]string{
+		"signaling.message_type": "offer",
+		"signaling.room":         "call",
+		"signaling.sender":       "alice",
+		"signaling.recipient":    "bob",
+	} {
+		if span.options.Attributes[key] != value {
+			t.Errorf("Expected attribute %s=%s, got %v", key, value, span.options.Attributes[key])
+		}
+	}
+	if len(span.options.Links) != 1 || tracing.Traceparent(span.options.Links[0]) != clientTrace {
+		t.Errorf("Expected the span to be linked to the client's trace, got %v", span.options.Links)
+	}
+
+	// The relayed offer carries the span of the relay, in the connection's trace
+	if trace := received["bob"].Trace; trace != tracing.Traceparent(span.Context()) || trace[3:35] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the relayed offer to carry the relay span, got %q", trace)
+	}
+
+	// Malformed traces are not relayed, and failures are recorded on the span
+	unknownJSON, _ := json.Marshal(Message{Type: "bogus", Room: "call", Trace: "forged"})
+	if err := sm.ProcessMessageContext(connection, unknownJSON, "bob", sender); err == nil {
+		t.Fatal("Expected a message of unknown type to fail")
+	}
+	span = tracer.spans[3]
+	if len(span.options.Links) != 0 || len(span.errors) != 1 {
+		t.Errorf("Expected no link and the error recorded, got %v and %v", span.options.Links, span.errors)
+	}
+}
@@ -0,0 +1,30 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// WelcomePayload is the payload of the welcome message, the first message a
+// client receives on a new connection
+type WelcomePayload struct {
+	// ClientID is the ID the server assigned to the connection, which other
+	// peers address the client by unless peer IDs are obfuscated
+	ClientID string `json:"clientId"`
+
+	// SessionToken resumes the session on reconnect, omitted if sessions are
+	// disabled. Resumed is set if the connection resumed a session, whose
+	// queued messages follow the welcome.
+	SessionToken string `json:"sessionToken,omitempty"`
+	Resumed      bool   `json:"resumed,omitempty"`
+}
+
+// NewWelcome builds the welcome message of a new connection
+func NewWelcome(clientID, sessionToken string, resumed bool) ([]byte, error) {
+	payload, err := json.Marshal(WelcomePayload{ClientID: clientID, SessionToken: sessionToken, Resumed: resumed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal welcome payload: %w", err)
+	}
+
+	return json.Marshal(Message{Type: Welcome, Payload: payload})
+}
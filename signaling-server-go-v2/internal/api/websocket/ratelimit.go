@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pruneInterval is the number of checks between sweeps of idle buckets
+const pruneInterval = 1024
+
+// TokenBucket is a token bucket rate limiter. It holds up to burst tokens,
+// refilled at rate tokens per second, and each allowed event takes one token.
+// It is not safe for concurrent use.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full TokenBucket
+func NewTokenBucket(rate float64, burst int, now time.Time) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// Allow takes a token if one is available and reports whether it did
+func (b *TokenBucket) Allow(now time.Time) bool {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Limiter decides whether an event, such as an inbound message, may proceed
+// for a key such as a client ID or remote IP. Implementations backed by a
+// shared store enforce the limit across all server instances.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+// MemoryLimiter is a Limiter keeping a TokenBucket per key in memory, so each
+// server instance enforces the limit separately
+type MemoryLimiter struct {
+	rate    float64
+	burst   int
+	now     func() time.Time
+	buckets map[string]*TokenBucket
+	checks  int
+	mutex   sync.Mutex
+}
+
+// NewMemoryLimiter creates a MemoryLimiter allowing rate events per second
+// per key, with bursts of up to burst events
+func NewMemoryLimiter(rate float64, burst int, now func() time.Time) *MemoryLimiter {
+	return &MemoryLimiter{
+		rate:    rate,
+		burst:   burst,
+		now:     now,
+		buckets: make(map[string]*TokenBucket),
+	}
+}
+
+// Allow implements Limiter.Allow
+func (l *MemoryLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.checks++
+	if l.checks%pruneInterval == 0 {
+		l.prune(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = NewTokenBucket(l.rate, l.burst, now)
+		l.buckets[key] = bucket
+	}
+	return bucket.Allow(now), nil
+}
+
+// SetRate changes the rate and burst of the limiter, including the buckets
+// of the keys already seen, which keep their tokens up to the new burst
+func (l *MemoryLimiter) SetRate(rate float64, burst int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if burst < 1 {
+		burst = 1
+	}
+	l.rate = rate
+	l.burst = burst
+	for _, bucket := range l.buckets {
+		bucket.rate = rate
+		bucket.burst = float64(burst)
+		if bucket.tokens > bucket.burst {
+			bucket.tokens = bucket.burst
+		}
+	}
+}
+
+// prune removes the buckets that have refilled completely, which behave like
+// new buckets. Must be called with the mutex held.
+func (l *MemoryLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate >= bucket.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// failOpenLimiter is a Limiter allowing events when its backend fails
+type failOpenLimiter struct {
+	next    Limiter
+	budget  time.Duration
+	onError func(key string, err error)
+}
+
+// FailOpen wraps a Limiter backed by a remote store so that it never holds up
+// an event for longer than the latency budget: a check that fails or
+// exceeds the budget allows the event and is reported to onError. The
+// backend must honour the context deadline.
+func FailOpen(next Limiter, budget time.Duration, onError func(key string, err error)) Limiter {
+	return &failOpenLimiter{next: next, budget: budget, onError: onError}
+}
+
+// Allow implements Limiter.Allow
+func (l *failOpenLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	if l.budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.budget)
+		defer cancel()
+	}
+
+	allowed, err := l.next.Allow(ctx, key)
+	if err != nil {
+		if l.onError != nil {
+			l.onError(key, err)
+		}
+		return true, nil
+	}
+	return allowed, nil
+}
@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bucket := NewTokenBucket(2, 3, now)
+
+	for i := 0; i < 3; i++ {
+		if !bucket.Allow(now) {
+			t.Fatalf("Expected request %d within the burst to be allowed", i)
+		}
+	}
+	if bucket.Allow(now) {
+		t.Error("Expected a request over the burst to be denied")
+	}
+
+	// Half a second refills one token at two per second
+	now = now.Add(500 * time.Millisecond)
+	if !bucket.Allow(now) {
+		t.Error("Expected a refilled token to be allowed")
+	}
+	if bucket.Allow(now) {
+		t.Error("Expected the bucket to be empty again")
+	}
+
+	// Refill is capped at the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		bucket.Allow(now)
+	}
+	if bucket.Allow(now) {
+		t.Error("Expected refill to be capped at the burst")
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewMemoryLimiter(1, 2, func() time.Time { return now })
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.Allow(ctx, "a"); !allowed {
+			t.Fatalf("Expected request %d within the burst to be allowed", i)
+		}
+	}
+	if allowed, _ := limiter.Allow(ctx, "a"); allowed {
+		t.Error("Expected a request over the burst to be denied")
+	}
+
+	// Each key has its own bucket
+	if allowed, _ := limiter.Allow(ctx, "b"); !allowed {
+		t.Error("Expected another key to be allowed")
+	}
+
+	// Full buckets are pruned
+	now = now.Add(time.Minute)
+	limiter.mutex.Lock()
+	limiter.prune(now)
+	remaining := len(limiter.buckets)
+	limiter.mutex.Unlock()
+	if remaining != 0 {
+		t.Errorf("Expected refilled buckets to be pruned, %d remain", remaining)
+	}
+}
+
+// stubLimiter is a Limiter answering with a fixed result, or blocking until
+// the context is done when slow
+type stubLimiter struct {
+	allowed bool
+	err     error
+	slow    bool
+}
+
+func (l stubLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	if l.slow {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	return l.allowed, l.err
+}
+
+func TestFailOpen(t *testing.T) {
+	var failures []string
+	onError := func(key string, err error) {
+		failures = append(failures, key)
+	}
+
+	if allowed, err := FailOpen(stubLimiter{allowed: false}, time.Second, onError).Allow(context.Background(), "denied"); allowed || err != nil {
+		t.Errorf("Expected the backend's denial to stand, got %v, %v", allowed, err)
+	}
+	if allowed, err := FailOpen(stubLimiter{err: errors.New("connection refused")}, time.Second, onError).Allow(context.Background(), "failed"); !allowed || err != nil {
+		t.Errorf("Expected a failing backend to allow, got %v, %v", allowed, err)
+	}
+	if allowed, err := FailOpen(stubLimiter{slow: true}, 10*time.Millisecond, onError).Allow(context.Background(), "slow"); !allowed || err != nil {
+		t.Errorf("Expected a backend over the budget to allow, got %v, %v", allowed, err)
+	}
+
+	if !reflect.DeepEqual(failures, []string{"failed", "slow"}) {
+		t.Errorf("Expected the failures to be reported, got %v", failures)
+	}
+}
@@ -0,0 +1,247 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// SessionTokenHeader is the request header a reconnecting client presents its session token in
+const SessionTokenHeader = "X-Session-Token"
+
+// sessionNonceKind is the kind of the nonces of session tokens, see NonceKey
+const sessionNonceKind = "session"
+
+// ErrSessionNotFound is returned when resuming a session that is unknown or
+// whose grace period has passed
+var ErrSessionNotFound = errs.New(errs.NotFound, "session not found")
+
+// session tracks the client ID and undelivered messages of a session token
+type session struct {
+	token    string
+	clientID string
+
+	// detachedAt is when the connection dropped, zero while connected
+	detachedAt time.Time
+
+	// queue holds messages sent to the client while it was disconnected
+	queue [][]byte
+}
+
+// Sessions issues session tokens to connecting clients and lets a
+// reconnecting client re-adopt its client ID within a grace period. Messages
+// sent to a disconnected client during the grace period are queued and
+// returned when the session is resumed. Session tokens are single-use: each
+// resume consumes the token and issues the next, so a captured token cannot
+// take over the session once its client has resumed it.
+type Sessions struct {
+	grace     time.Duration
+	queueSize int
+	now       func() time.Time
+	nonces    NonceCache
+	byToken   map[string]*session
+	byClient  map[string]*session
+	mutex     sync.Mutex
+}
+
+// SessionOption configures Sessions
+type SessionOption func(*Sessions)
+
+// WithSessionClock sets the time source of Sessions
+func WithSessionClock(now func() time.Time) SessionOption {
+	return func(s *Sessions) {
+		s.now = now
+	}
+}
+
+// WithNonceCache sets the cache recording consumed and invalidated session
+// tokens, in memory unless set, e.g. to detect replays across instances
+func WithNonceCache(cache NonceCache) SessionOption {
+	return func(s *Sessions) {
+		s.nonces = cache
+	}
+}
+
+// NewSessions creates Sessions that keep disconnected sessions for the grace
+// period, queueing up to queueSize messages for each
+func NewSessions(grace time.Duration, queueSize int, opts ...SessionOption) *Sessions {
+	s := &Sessions{
+		grace:     grace,
+		queueSize: queueSize,
+		now:       time.Now,
+		byToken:   make(map[string]*session),
+		byClient:  make(map[string]*session),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.nonces == nil {
+		s.nonces = NewMemoryNonceCache(s.now)
+	}
+
+	return s
+}
+
+// newSessionToken generates a random session token
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Create issues a session token for a newly connected client
+func (s *Sessions) Create(clientID string) (string, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if old, ok := s.byClient[clientID]; ok {
+		delete(s.byToken, old.token)
+	}
+
+	sess := &session{token: token, clientID: clientID}
+	s.byToken[token] = sess
+	s.byClient[clientID] = sess
+	return token, nil
+}
+
+// Resume re-attaches a session by token, returning its client ID, the token
+// replacing the consumed one and the messages queued while it was
+// disconnected. It fails with ErrTokenReplayed if the token was consumed or
+// invalidated before, and with ErrSessionNotFound if it is unknown or its
+// grace period has passed.
+func (s *Sessions) Resume(ctx context.Context, token string) (string, string, [][]byte, error) {
+	// The token is consumed first, so that concurrent resumes with the same
+	// token, possibly on other instances, cannot both succeed
+	used, err := s.nonces.Use(ctx, NonceKey(sessionNonceKind, token), s.now().Add(s.grace))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to check session token: %w", err)
+	}
+	if !used {
+		return "", "", nil, ErrTokenReplayed
+	}
+
+	next, err := newSessionToken()
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.byToken[token]
+	if !ok || s.expired(sess) {
+		return "", "", nil, ErrSessionNotFound
+	}
+
+	delete(s.byToken, token)
+	sess.token = next
+	s.byToken[next] = sess
+
+	queued := sess.queue
+	sess.queue = nil
+	sess.detachedAt = time.Time{}
+	return sess.clientID, next, queued, nil
+}
+
+// Detach marks a client's session as disconnected, starting its grace period
+func (s *Sessions) Detach(clientID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if sess, ok := s.byClient[clientID]; ok && sess.detachedAt.IsZero() {
+		sess.detachedAt = s.now()
+	}
+}
+
+// End removes a client's session so it cannot be resumed, e.g. when the
+// server closes the connection, and invalidates its token so that later
+// attempts to resume it are reported as replays
+func (s *Sessions) End(clientID string) {
+	s.mutex.Lock()
+	sess, ok := s.byClient[clientID]
+	if ok {
+		delete(s.byToken, sess.token)
+		delete(s.byClient, clientID)
+	}
+	s.mutex.Unlock()
+
+	if ok {
+		// The session is gone either way, so a failure only loses the replay detection
+		s.nonces.Invalidate(context.Background(), NonceKey(sessionNonceKind, sess.token), s.now().Add(s.grace))
+	}
+}
+
+// Enqueue queues a message for a disconnected client within its grace period,
+// dropping the oldest message when the queue is full. It reports whether the
+// message was queued.
+func (s *Sessions) Enqueue(clientID string, message []byte) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.byClient[clientID]
+	if !ok || sess.detachedAt.IsZero() || s.expired(sess) || s.queueSize <= 0 {
+		return false
+	}
+
+	if len(sess.queue) >= s.queueSize {
+		sess.queue = sess.queue[1:]
+	}
+	sess.queue = append(sess.queue, message)
+	return true
+}
+
+// Expire removes the sessions whose grace period has passed and returns their client IDs
+func (s *Sessions) Expire() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expired := make([]string, 0)
+	for clientID, sess := range s.byClient {
+		if s.expired(sess) {
+			delete(s.byToken, sess.token)
+			delete(s.byClient, clientID)
+			expired = append(expired, clientID)
+		}
+	}
+	return expired
+}
+
+// Run expires sessions every interval until the context is cancelled, calling
+// onExpire for each expired client so it can be removed from its rooms
+func (s *Sessions) Run(ctx context.Context, interval time.Duration, onExpire func(clientID string)) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, clientID := range s.Expire() {
+				onExpire(clientID)
+			}
+		}
+	}
+}
+
+// expired reports whether a detached session's grace period has passed. Must be called with the mutex held.
+func (s *Sessions) expired(sess *session) bool {
+	return !sess.detachedAt.IsZero() && !s.now().Before(sess.detachedAt.Add(s.grace))
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionResume(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sessions := NewSessions(30*time.Second, 2, WithSessionClock(func() time.Time { return now }))
+
+	token, err := sessions.Create("client-1")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// Messages are only queued while the client is disconnected
+	if sessions.Enqueue("client-1", []byte("early")) {
+		t.Error("Expected no queueing while connected")
+	}
+
+	sessions.Detach("client-1")
+	for _, msg := range []string{"one", "two", "three"} {
+		if !sessions.Enqueue("client-1", []byte(msg)) {
+			t.Errorf("Expected %s to be queued", msg)
+		}
+	}
+
+	now = now.Add(29 * time.Second)
+	clientID, next, queued, err := sessions.Resume(context.Background(), token)
+	if err != nil || clientID != "client-1" {
+		t.Fatalf("Expected to resume client-1, got %q %v", clientID, err)
+	}
+	if next == "" || next == token {
+		t.Errorf("Expected a new token on resume, got %q", next)
+	}
+	if len(queued) != 2 || string(queued[0]) != "two" || string(queued[1]) != "three" {
+		t.Errorf("Expected the 2 most recent messages, got %q", queued)
+	}
+
+	// After the grace period the session expires
+	sessions.Detach("client-1")
+	now = now.Add(30 * time.Second)
+	if _, _, _, err := sessions.Resume(context.Background(), next); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected resume after grace period to fail, got %v", err)
+	}
+	if expired := sessions.Expire(); len(expired) != 1 || expired[0] != "client-1" {
+		t.Errorf("Expected client-1 to expire, got %v", expired)
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	sessions := NewSessions(time.Minute, 10)
+
+	token, _ := sessions.Create("client-1")
+	sessions.End("client-1")
+
+	if _, _, _, err := sessions.Resume(context.Background(), token); !errors.Is(err, ErrTokenReplayed) {
+		t.Errorf("Expected the token of an ended session to be rejected as replayed, got %v", err)
+	}
+}
+
+func TestSessionTokenReplay(t *testing.T) {
+	sessions := NewSessions(time.Minute, 10)
+
+	token, _ := sessions.Create("client-1")
+	sessions.Detach("client-1")
+	_, next, _, err := sessions.Resume(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Failed to resume session: %v", err)
+	}
+
+	// A captured token cannot take over the session once it has been used
+	if _, _, _, err := sessions.Resume(context.Background(), token); !errors.Is(err, ErrTokenReplayed) {
+		t.Errorf("Expected the used token to be rejected as replayed, got %v", err)
+	}
+
+	// The client itself keeps resuming with the token it was given
+	sessions.Detach("client-1")
+	if clientID, _, _, err := sessions.Resume(context.Background(), next); err != nil || clientID != "client-1" {
+		t.Errorf("Expected the new token to resume client-1, got %q %v", clientID, err)
+	}
+}
@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// Tenant sources, the parts of an upgrade request a connection's tenant is
+// taken from
+const (
+	// TenantFromClaim is the tenant claim of a bearer token signed with HS256
+	TenantFromClaim = "claim"
+
+	// TenantFromPath is the path segment following the endpoint, as in
+	// /ws/{tenant}, or the tenant query parameter
+	TenantFromPath = "path"
+)
+
+// ErrNoTenant is returned when a client connects without a tenant to a
+// server partitioning rooms by tenant
+var ErrNoTenant = errs.New(errs.PermissionDenied, "connection has no tenant")
+
+// TenantFromRequest returns the tenant of a request to endpoint/{tenant}, or
+// of its tenant query parameter, reporting false if it names none
+func TenantFromRequest(r *http.Request, endpoint string) (string, bool) {
+	if tenant, ok := strings.CutPrefix(r.URL.Path, strings.TrimSuffix(endpoint, "/")+"/"); ok && tenant != "" {
+		return tenant, true
+	}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		return tenant, true
+	}
+	return "", false
+}
+
+// TenantResolver returns a function taking the tenant of an upgrade request
+// to endpoint from the first of the sources naming one. The claim source
+// reads the bearer token with identifier.
+func TenantResolver(sources []string, identifier *Identifier, endpoint string) func(r *http.Request) (string, bool) {
+	return func(r *http.Request) (string, bool) {
+		for _, source := range sources {
+			var tenant string
+			var ok bool
+			switch source {
+			case TenantFromClaim:
+				tenant, ok = identifier.Tenant(r)
+			case TenantFromPath:
+				tenant, ok = TenantFromRequest(r, endpoint)
+			}
+			if ok {
+				return tenant, true
+			}
+		}
+		return "", false
+	}
+}
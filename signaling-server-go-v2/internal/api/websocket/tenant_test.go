@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTenantResolver(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	identifier := NewIdentifier(nil, "s3cret", nil, func() time.Time { return now })
+	resolve := TenantResolver([]string{TenantFromClaim, TenantFromPath}, identifier, "/ws")
+
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	tests := []struct {
+		name   string
+		target string
+		token  string
+		tenant string
+	}{
+		{"token claim", "/ws/globex", signToken("s3cret", hs256, `{"sub":"alice","tenant":"acme"}`), "acme"},
+		{"path", "/ws/globex", "", "globex"},
+		{"query parameter", "/ws?tenant=globex", "", "globex"},
+		{"forged claim falls back to path", "/ws/globex", signToken("guess", hs256, `{"tenant":"acme"}`), "globex"},
+		{"token without tenant", "/ws", signToken("s3cret", hs256, `{"sub":"alice"}`), ""},
+		{"endpoint only", "/ws/", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			tenant, ok := resolve(req)
+			if tenant != tt.tenant || ok != (tt.tenant != "") {
+				t.Errorf("Expected tenant %q, got %q (%v)", tt.tenant, tenant, ok)
+			}
+		})
+	}
+}
@@ -1,10 +1,20 @@
 package websocket
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// Close codes sent in WebSocket close frames (RFC 6455 section 7.4.1)
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	ClosePolicyViolation = 1008
+	CloseTryAgainLater   = 1013
 )
 
 // WebSocketHandler interface for abstracting WebSocket implementations
@@ -13,6 +23,107 @@ type WebSocketHandler interface {
 	BroadcastMessage(message []byte) error
 	SendMessage(clientID string, message []byte) error
 	CloseConnection(clientID string) error
+	CloseConnectionWithReason(clientID string, code int, reason string) error
+}
+
+// ShutdownReason is the close frame reason sent to clients when the server shuts down
+const ShutdownReason = "server shutting down"
+
+// DrainResult reports the outcome of draining connections on shutdown
+type DrainResult struct {
+	// Closed counts the clients that disconnected after the close frame
+	Closed int `json:"closed"`
+
+	// Forced counts the connections still open at the deadline, which were closed forcibly
+	Forced int `json:"forced"`
+}
+
+// Drainer is implemented by WebSocketHandlers that can close their
+// connections gracefully: send close frames with the reason, wait for
+// clients to disconnect until the context is done, then force-close the rest
+type Drainer interface {
+	Drain(ctx context.Context, reason string) DrainResult
+}
+
+// HubStats are counters and gauges of a WebSocketHandler's client registry
+type HubStats struct {
+	Connections int `json:"connections"`
+
+	// QueuedMessages is the number of messages waiting in the clients' send
+	// buffers, and MaxQueueDepth the fullest buffer of a single client
+	QueuedMessages int `json:"queuedMessages"`
+	MaxQueueDepth  int `json:"maxQueueDepth"`
+
+	// QueueDepthP50, QueueDepthP90 and QueueDepthP99 are percentiles of the
+	// depths of the clients' send buffers. A high P99 over a low P50 points
+	// at a few clients on slow networks, a high P50 at the server falling
+	// behind all of them.
+	QueueDepthP50 int `json:"queueDepthP50"`
+	QueueDepthP90 int `json:"queueDepthP90"`
+	QueueDepthP99 int `json:"queueDepthP99"`
+
+	// DroppedMessages counts the outbound messages dropped because a
+	// client's send buffer or the send memory budget was full, and RateLimitedMessages the inbound
+	// messages dropped by the rate limits, since the server started
+	DroppedMessages     int64 `json:"droppedMessages"`
+	RateLimitedMessages int64 `json:"rateLimitedMessages"`
+
+	// QueuedBytes is the size of the messages waiting in the clients' send
+	// buffers, bounded by the send memory budget
+	QueuedBytes int64 `json:"queuedBytes"`
+}
+
+// StatsReporter is implemented by WebSocketHandlers reporting HubStats
+type StatsReporter interface {
+	Stats() HubStats
+}
+
+// QueueReporter is implemented by WebSocketHandlers reporting the depth of
+// the send buffer of each of their clients
+type QueueReporter interface {
+	QueueDepths() []int
+}
+
+// Reconfigurer is implemented by WebSocketHandlers whose limits can change
+// without dropping their connections, e.g. on configuration reload
+type Reconfigurer interface {
+	// SetRateLimits changes the inbound message rate of each client and of
+	// each remote IP, in messages per second, 0 to lift the limit
+	SetRateLimits(clientRate float64, clientBurst int, ipRate float64, ipBurst int)
+
+	// SetAllowedOrigins changes the origins allowed to upgrade, applying to
+	// new connections
+	SetAllowedOrigins(origins []string)
+}
+
+// ErrTooManyConnections is returned by Attach when the remote IP or its
+// autonomous system is at its connection cap
+var ErrTooManyConnections = errs.New(errs.ResourceExhausted, "too many connections")
+
+// Attacher is implemented by WebSocketHandlers that register the connections
+// of other transports, such as gRPC streams, in their client registry, so that
+// messages reach a client whatever its transport
+type Attacher interface {
+	// Attach registers a connection made with the request, whose remote
+	// address and headers identify the client as for a WebSocket upgrade
+	Attach(r *http.Request) (Conn, error)
+}
+
+// Conn is a connection of another transport attached to a WebSocketHandler.
+// The transport reads messages from the client into Receive and writes the
+// messages returned by Next.
+type Conn interface {
+	ID() string
+
+	// Receive handles a message read from the client
+	Receive(message []byte) error
+
+	// Next waits for the next message to write to the client. It returns
+	// false once the connection was closed or the context is done.
+	Next(ctx context.Context) ([]byte, bool)
+
+	// Disconnect unregisters the connection once the client is gone
+	Disconnect()
 }
 
 // WebSocketConnection interface for abstracting WebSocket connection implementations
@@ -31,15 +142,130 @@ type WebSocketConfig struct {
 	PongWait       time.Duration
 	WriteWait      time.Duration
 	MaxMessageSize int64
+
+	// MessageRate is the sustained number of messages per second a client
+	// may send, 0 for no limit, with bursts of up to MessageBurst messages
+	MessageRate  float64
+	MessageBurst int
+
+	// IPMessageRate is the sustained number of messages per second the
+	// clients of one remote IP may send together, 0 for no limit, with bursts
+	// of up to IPMessageBurst messages
+	IPMessageRate  float64
+	IPMessageBurst int
+
+	// TenantMessageRate is the sustained number of messages per second the
+	// clients of one tenant may send together, 0 for no limit, with bursts of
+	// up to TenantMessageBurst messages
+	TenantMessageRate  float64
+	TenantMessageBurst int
+
+	// RateLimitBudget bounds the time a rate limit check against a remote
+	// store may take before the message is allowed
+	RateLimitBudget time.Duration
+
+	// MaxConnectionsPerIP caps the concurrent connections from one remote IP, 0 for no cap
+	MaxConnectionsPerIP int
+
+	// MaxConnectionsPerASN caps the concurrent connections from one
+	// autonomous system, 0 for no cap; it requires the GeoIP ASN database
+	MaxConnectionsPerASN int
+
+	// AllowedOrigins lists the browser origins allowed to connect, see CheckOrigin
+	AllowedOrigins []string
+
+	// ReauthorizeInterval is how often the permissions of connected clients
+	// are re-checked, 0 to check them only on connect, with each check timing
+	// out after ReauthorizeTimeout
+	ReauthorizeInterval time.Duration
+	ReauthorizeTimeout  time.Duration
+
+	// Compression configures permessage-deflate for clients offering it
+	Compression Compression
+
+	// LowPower configures the keepalive of clients declaring the low power mode
+	LowPower LowPower
+
+	// SendLatencySampling times one in SendLatencySampling outbound messages
+	// from their enqueue to their flush to the connection, 0 for none
+	SendLatencySampling int
+
+	// SendBufferSize is the number of messages queued for each client, and
+	// SendMemoryBudget bounds the bytes queued for all clients, 0 for no
+	// budget. BackpressurePolicy sheds the messages beyond either.
+	SendBufferSize     int
+	SendMemoryBudget   int64
+	BackpressurePolicy string
 }
 
+// DefaultSendBufferSize is the number of messages queued for each client
+// unless configured otherwise
+const DefaultSendBufferSize = 256
+
+// Backpressure policies, applied when a client's send buffer or the send
+// memory budget is full
+const (
+	// BackpressureDisconnect closes the connection of the client whose
+	// buffer is full, or of the client with the most bytes queued when the
+	// budget is, so that it reconnects and catches up
+	BackpressureDisconnect = "disconnect"
+
+	// BackpressureDrop drops the message and keeps the connection
+	BackpressureDrop = "drop"
+)
+
 // NewWebSocketConfig creates a WebSocketConfig from config.WebSocketConfig
 func NewWebSocketConfig(cfg config.WebSocketConfig) WebSocketConfig {
+	sendBufferSize := cfg.SendBufferSize
+	if sendBufferSize <= 0 {
+		sendBufferSize = DefaultSendBufferSize
+	}
+	policy := cfg.BackpressurePolicy
+	if policy == "" {
+		policy = BackpressureDisconnect
+	}
+
 	return WebSocketConfig{
 		Path:           cfg.Path,
 		PingInterval:   time.Duration(cfg.PingInterval) * time.Second,
 		PongWait:       time.Duration(cfg.PongWait) * time.Second,
 		WriteWait:      time.Duration(cfg.WriteWait) * time.Second,
 		MaxMessageSize: cfg.MaxMessageSize,
+		MessageRate:    float64(cfg.MessageRateLimit),
+		MessageBurst:   cfg.MessageRateBurst,
+
+		IPMessageRate:   float64(cfg.IPMessageRateLimit),
+		IPMessageBurst:  cfg.IPMessageRateBurst,
+		RateLimitBudget: time.Duration(cfg.RateLimitBudget) * time.Millisecond,
+
+		TenantMessageRate:  float64(cfg.TenantMessageRateLimit),
+		TenantMessageBurst: cfg.TenantMessageRateBurst,
+
+		MaxConnectionsPerIP:  cfg.MaxConnectionsPerIP,
+		MaxConnectionsPerASN: cfg.MaxConnectionsPerASN,
+		AllowedOrigins:       cfg.AllowedOrigins,
+
+		ReauthorizeInterval: time.Duration(cfg.ReauthorizeInterval) * time.Second,
+		ReauthorizeTimeout:  time.Duration(cfg.ReauthorizeTimeout) * time.Second,
+
+		Compression: Compression{
+			Enabled:                 cfg.EnableCompression,
+			Level:                   cfg.CompressionLevel,
+			Threshold:               cfg.CompressionThreshold,
+			Thresholds:              cfg.CompressionThresholds,
+			ServerNoContextTakeover: cfg.ServerNoContextTakeover,
+			ClientNoContextTakeover: cfg.ClientNoContextTakeover,
+		},
+
+		LowPower: LowPower{
+			PingInterval: time.Duration(cfg.LowPowerPingInterval) * time.Second,
+			PongWait:     time.Duration(cfg.LowPowerPongWait) * time.Second,
+		},
+
+		SendLatencySampling: cfg.SendLatencySampling,
+
+		SendBufferSize:     sendBufferSize,
+		SendMemoryBudget:   cfg.SendMemoryBudget,
+		BackpressurePolicy: policy,
 	}
 }
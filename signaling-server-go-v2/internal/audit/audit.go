@@ -0,0 +1,262 @@
+// Package audit writes a trail of room lifecycle events and administrative
+// actions for compliance review, apart from the application logs: a JSON
+// line per record in a file rotated by its own settings.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// Action is the kind of an audit record
+type Action string
+
+const (
+	// ActionJoin records a client joining a room
+	ActionJoin Action = "join"
+
+	// ActionLeave records a client leaving a room, for the reason of the record
+	ActionLeave Action = "leave"
+
+	// ActionKick and ActionBan record a moderator, the actor of the record,
+	// kicking or banning a client from a room
+	ActionKick Action = "kick"
+	ActionBan  Action = "ban"
+
+	// ActionAdmin records an operation of the admin API
+	ActionAdmin Action = "admin"
+
+	// ActionConfigReload records the settings changed by a configuration
+	// reload, requested by the actor of the record
+	ActionConfigReload Action = "config_reload"
+)
+
+// Record is an entry of the audit trail
+type Record struct {
+	Time     time.Time `json:"time"`
+	Action   Action    `json:"action"`
+	Room     string    `json:"room,omitempty"`
+	Client   string    `json:"client,omitempty"`
+	ClientIP string    `json:"clientIp,omitempty"`
+	Actor    string    `json:"actor,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+
+	// Operation and Details describe admin operations
+	Operation string                 `json:"operation,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Log writes audit records asynchronously, so that the signaling manager,
+// which emits its events while holding its locks, never waits for the disk.
+// Records that do not fit in the buffer are dropped and counted.
+type Log struct {
+	out     io.WriteCloser
+	now     func() time.Time
+	records chan Record
+	done    chan struct{}
+	dropped atomic.Int64
+	onDrop  func(count int)
+
+	// closed stops the queueing of records once the log is closing
+	closed      bool
+	closedMutex sync.RWMutex
+
+	// ips holds the IPs of connected clients, as the clients leaving rooms
+	// on disconnect are gone from the connection handler
+	ips   map[string]string
+	mutex sync.Mutex
+}
+
+// Option configures a Log
+type Option func(*Log)
+
+// WithDropHandler calls fn with the number of records dropped each time
+// records are dropped, e.g. to record a metric. It must not block.
+func WithDropHandler(fn func(count int)) Option {
+	return func(l *Log) {
+		l.onDrop = fn
+	}
+}
+
+// WithClock sets the clock timestamping admin records
+func WithClock(now func() time.Time) Option {
+	return func(l *Log) {
+		l.now = now
+	}
+}
+
+// Open creates a Log writing to the rotating file of the configuration
+func Open(cfg config.AuditConfig, opts ...Option) (*Log, error) {
+	file, err := logging.NewRotatingFile(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return New(file, cfg.BufferSize, opts...), nil
+}
+
+// New creates a Log writing to out, buffering up to size records
+func New(out io.WriteCloser, size int, opts ...Option) *Log {
+	l := &Log{
+		out:     out,
+		now:     time.Now,
+		records: make(chan Record, size),
+		done:    make(chan struct{}),
+		ips:     make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	go l.run()
+	return l
+}
+
+// Connected remembers the IP of a connected client, recorded with its events
+func (l *Log) Connected(clientID, ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.ips[clientID] = ip
+}
+
+// Disconnected forgets the IP of a client once it left its rooms
+func (l *Log) Disconnected(clientID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.ips, clientID)
+}
+
+// Emit implements protocol.ActivitySink without blocking, recording joins,
+// leaves, kicks and bans
+func (l *Log) Emit(event protocol.ActivityEvent) {
+	var action Action
+	switch event.Type {
+	case protocol.ActivityJoin:
+		action = ActionJoin
+	case protocol.ActivityLeave:
+		action = ActionLeave
+	case protocol.ActivityKick:
+		action = ActionKick
+	case protocol.ActivityBan:
+		action = ActionBan
+	default:
+		return
+	}
+
+	l.mutex.Lock()
+	ip := l.ips[event.Client]
+	l.mutex.Unlock()
+
+	l.push(Record{
+		Time:     event.Time,
+		Action:   action,
+		Room:     event.Room,
+		Client:   event.Client,
+		ClientIP: ip,
+		Actor:    event.Actor,
+		Reason:   event.Reason,
+	})
+}
+
+// Admin records an operation of the admin API requested from remoteAddr,
+// with details given as alternating keys and values
+func (l *Log) Admin(operation, remoteAddr string, keyvals ...interface{}) {
+	details := make(map[string]interface{}, len(keyvals)/2)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		details[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
+	if len(details) == 0 {
+		details = nil
+	}
+
+	l.push(Record{
+		Time:      l.now(),
+		Action:    ActionAdmin,
+		ClientIP:  remoteAddr,
+		Actor:     "admin",
+		Operation: operation,
+		Details:   details,
+	})
+}
+
+// ConfigReload records a configuration reload requested by actor, "signal"
+// or "admin", from remoteAddr if requested through the admin API, with the
+// settings it changed
+func (l *Log) ConfigReload(actor, remoteAddr string, changes []config.Change) {
+	l.push(Record{
+		Time:     l.now(),
+		Action:   ActionConfigReload,
+		ClientIP: remoteAddr,
+		Actor:    actor,
+		Details:  map[string]interface{}{"changes": changes},
+	})
+}
+
+// Dropped returns the number of records dropped so far
+func (l *Log) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// push queues a record without blocking, dropping it if the buffer is full
+// or the log closed
+func (l *Log) push(record Record) {
+	l.closedMutex.RLock()
+	defer l.closedMutex.RUnlock()
+
+	if l.closed {
+		l.drop()
+		return
+	}
+	select {
+	case l.records <- record:
+	default:
+		l.drop()
+	}
+}
+
+// drop counts a dropped record and reports it to the drop handler
+func (l *Log) drop() {
+	l.dropped.Add(1)
+	if l.onDrop != nil {
+		l.onDrop(1)
+	}
+}
+
+// run writes the queued records until the log is closed
+func (l *Log) run() {
+	defer close(l.done)
+	for record := range l.records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			continue
+		}
+		l.out.Write(append(line, '\n'))
+	}
+}
+
+// Close writes the queued records and closes the file, giving up on the
+// records still queued when the context is done. Records emitted after
+// Close are dropped.
+func (l *Log) Close(ctx context.Context) error {
+	l.closedMutex.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.records)
+	}
+	l.closedMutex.Unlock()
+
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return fmt.Errorf("audit log closed with records unwritten: %w", ctx.Err())
+	}
+	return l.out.Close()
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// buffer is an io.WriteCloser collecting the written records
+type buffer struct {
+	bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *buffer) Close() error { return nil }
+
+// records parses the records written, one per line
+func (b *buffer) records(t *testing.T) []Record {
+	var records []Record
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid audit record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	out := &buffer{}
+	log := New(out, 100, WithClock(func() time.Time { return now }))
+	sm := protocol.NewSignalingManager(testsupport.NewLogger(),
+		protocol.WithClock(func() time.Time { return now }),
+		protocol.WithConnections(testsupport.NewWebSocketHandler()),
+		protocol.WithActivitySink(log),
+	)
+	noop := func(string, []byte) error { return nil }
+
+	log.Connected("host", "192.0.2.1")
+	log.Connected("guest", "192.0.2.2")
+	joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "standup"})
+	sm.ProcessMessage(joinJSON, "host", noop)
+	sm.ProcessMessage(joinJSON, "guest", noop)
+	offerJSON, _ := json.Marshal(protocol.Message{Type: protocol.Offer, Room: "standup", Recipient: "guest"})
+	sm.ProcessMessage(offerJSON, "host", noop)
+	kickJSON, _ := json.Marshal(protocol.Message{Type: protocol.Kick, Room: "standup", Recipient: "guest", Payload: json.RawMessage(`{"reason":"spam"}`)})
+	if err := sm.ProcessMessage(kickJSON, "host", noop); err != nil {
+		t.Fatalf("Failed to kick: %v", err)
+	}
+	log.Admin("close_rooms", "203.0.113.7:4711", "pattern", "standup", "rooms", 1)
+
+	if err := log.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close audit log: %v", err)
+	}
+
+	// Relays are not audited
+	expected := []Record{
+		{Time: now, Action: ActionJoin, Room: "standup", Client: "host", ClientIP: "192.0.2.1"},
+		{Time: now, Action: ActionJoin, Room: "standup", Client: "guest", ClientIP: "192.0.2.2"},
+		{Time: now, Action: ActionLeave, Room: "standup", Client: "guest", ClientIP: "192.0.2.2", Reason: "kick"},
+		{Time: now, Action: ActionKick, Room: "standup", Client: "guest", ClientIP: "192.0.2.2", Actor: "host", Reason: "spam"},
+		{Time: now, Action: ActionAdmin, ClientIP: "203.0.113.7:4711", Actor: "admin", Operation: "close_rooms", Details: map[string]interface{}{"pattern": "standup", "rooms": float64(1)}},
+	}
+	if records := out.records(t); !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected records %+v, got %+v", expected, records)
+	}
+
+	// Records emitted after Close are dropped
+	log.Admin("get_config", "203.0.113.7:4711")
+	if dropped := log.Dropped(); dropped != 1 {
+		t.Errorf("Expected 1 dropped record, got %d", dropped)
+	}
+}
@@ -0,0 +1,737 @@
+// Package cluster shares rooms between signaling server instances, so that
+// peers of a room may be connected to different instances behind a load
+// balancer. Membership changes and relays are published on a channel per
+// room, which every instance with local peers in the room subscribes to.
+// Large clusters may shard rooms instead, see WithSharding.
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
+)
+
+// Transport is a publish/subscribe bus between server instances
+type Transport interface {
+	// Publish sends data to the subscribers of a channel on every instance
+	Publish(channel string, data []byte) error
+
+	// Subscribe calls handle for the data published on a channel until the
+	// returned function is called to unsubscribe
+	Subscribe(channel string, handle func(data []byte)) (func() error, error)
+
+	// Ping checks that the bus is reachable
+	Ping() error
+
+	// Close releases the transport's connections
+	Close() error
+}
+
+// NewTransport creates the transport of the configured cluster backend. It
+// returns nil for a single instance.
+func NewTransport(cfg config.ClusterConfig) (Transport, error) {
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case "redis":
+		return NewRedisTransport(cfg.Redis), nil
+	case "nats":
+		return NewNATSTransport(cfg.NATS), nil
+	default:
+		return nil, fmt.Errorf("unknown cluster backend: %s", cfg.Backend)
+	}
+}
+
+// ChannelPrefix returns the channel prefix configured for the cluster backend
+func ChannelPrefix(cfg config.ClusterConfig) string {
+	if cfg.Backend == "nats" {
+		return cfg.NATS.SubjectPrefix
+	}
+	return cfg.Redis.ChannelPrefix
+}
+
+// ChannelNamer is implemented by transports that restrict channel names
+type ChannelNamer interface {
+	// Channel returns the channel of a room
+	Channel(prefix, roomID string) string
+
+	// NodeChannel returns the channel of a node, when sharding rooms
+	NodeChannel(prefix, node string) string
+}
+
+// DeliverFunc delivers a relayed message to a client connected to this instance
+type DeliverFunc func(clientID string, message []byte) error
+
+// eventType is the type of an event published on a room channel
+type eventType string
+
+const (
+	// eventJoin announces a client joining the room on the publishing instance
+	eventJoin eventType = "join"
+
+	// eventPresent re-announces a member of the room to an instance that just joined it
+	eventPresent eventType = "present"
+
+	// eventLeave announces a client leaving the room on the publishing instance
+	eventLeave eventType = "leave"
+
+	// eventRelay carries a signaling message for a recipient on another instance
+	eventRelay eventType = "relay"
+
+	// eventAck acknowledges the delivery of an at-least-once relay to the
+	// instance that published it
+	eventAck eventType = "ack"
+
+	// eventResync announces an instance reconnecting to the bus; the others
+	// forget its members, which it re-announces, and re-announce their own
+	eventResync eventType = "resync"
+)
+
+// PartitionHandler is called when this instance loses the connection to the
+// bus, with degraded set and the rooms shared with other instances, and
+// again with the same rooms once it reconnected and reconciled membership
+type PartitionHandler func(roomIDs []string, degraded bool)
+
+// event is published on a room channel
+type event struct {
+	Type    eventType       `json:"type"`
+	Node    string          `json:"node"`
+	Room    string          `json:"room"`
+	Client  string          `json:"client"` // the joining or leaving client, or the relay recipient
+	Message json.RawMessage `json:"message,omitempty"`
+
+	// ID identifies an at-least-once relay and its acknowledgement
+	ID string `json:"id,omitempty"`
+
+	// Via is the owner of the room that forwarded the event, when sharding
+	Via string `json:"via,omitempty"`
+}
+
+// pendingRelay is an at-least-once relay waiting for its acknowledgement
+type pendingRelay struct {
+	event    event
+	attempts int
+	timer    *time.Timer
+}
+
+// room is the membership of a room this instance has local peers in
+type room struct {
+	local       map[string]struct{}
+	remote      map[string]string // client ID to node ID
+	unsubscribe func() error
+}
+
+// Backend implements the signaling manager's RoomBackend over a Transport
+type Backend struct {
+	node      string
+	prefix    string
+	transport Transport
+	deliver   DeliverFunc
+	logger    logging.Logger
+	rooms     map[string]*room
+	mutex     sync.Mutex
+
+	// onPartition is notified of partitions and recoveries, if set
+	onPartition PartitionHandler
+
+	// degraded holds the rooms shared with other instances when the bus
+	// became unreachable, nil while it is reachable
+	degraded []string
+
+	// pendingLeaves are the leaves that could not be published, replayed
+	// once the bus is reachable again
+	pendingLeaves []event
+
+	// pendingRelays holds the at-least-once relays waiting for their
+	// acknowledgement by ID, nil if relays are published at most once.
+	// They are published again every retryInterval, up to maxAttempts times.
+	pendingRelays map[string]*pendingRelay
+	retryInterval time.Duration
+	maxAttempts   int
+
+	metrics *metrics.Metrics
+
+	// membership lists the nodes of the ring assigning each room its owner
+	// when sharding, nil otherwise. The owner keeps the authoritative
+	// membership of the room in owned, and events are sent on the channel
+	// of the node they concern rather than on the room channel.
+	membership      Membership
+	ring            *Ring
+	replicas        int
+	owned           map[string]map[string]string // room ID to client ID to node ID
+	unsubscribeNode func() error
+}
+
+// Option configures a Backend
+type Option func(*Backend)
+
+// WithNodeID sets the ID identifying this instance, the host name by default
+func WithNodeID(node string) Option {
+	return func(b *Backend) {
+		if node != "" {
+			b.node = node
+		}
+	}
+}
+
+// WithChannelPrefix sets the prefix of the room channels
+func WithChannelPrefix(prefix string) Option {
+	return func(b *Backend) {
+		b.prefix = prefix
+	}
+}
+
+// WithPartitionHandler sets the handler notified when the bus becomes
+// unreachable and reachable again
+func WithPartitionHandler(handle PartitionHandler) Option {
+	return func(b *Backend) {
+		b.onPartition = handle
+	}
+}
+
+// WithAtLeastOnce publishes relays again every retryInterval until the
+// instance of the recipient acknowledges their delivery, up to maxAttempts
+// times. A relay whose acknowledgement is lost is delivered again, so the
+// relayed messages should carry an idempotency key for the recipient to
+// ignore duplicates. Retries stop when the recipient or the last local peer
+// leaves the room.
+func WithAtLeastOnce(retryInterval time.Duration, maxAttempts int) Option {
+	return func(b *Backend) {
+		b.pendingRelays = make(map[string]*pendingRelay)
+		b.retryInterval = retryInterval
+		b.maxAttempts = maxAttempts
+	}
+}
+
+// WithMetrics sets the metrics recorded by the Backend
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(b *Backend) {
+		b.metrics = m
+	}
+}
+
+// NewBackend creates a Backend publishing on the transport and delivering
+// messages relayed by other instances to local clients
+func NewBackend(transport Transport, deliver DeliverFunc, logger logging.Logger, opts ...Option) *Backend {
+	node, err := os.Hostname()
+	if err != nil {
+		node = "signaling-server"
+	}
+
+	b := &Backend{
+		node:      node,
+		prefix:    "signaling",
+		transport: transport,
+		deliver:   deliver,
+		logger:    logger.With("component", "cluster"),
+		rooms:     make(map[string]*room),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.membership != nil {
+		b.startSharding()
+	}
+
+	return b
+}
+
+// NodeID returns the ID identifying this instance
+func (b *Backend) NodeID() string {
+	return b.node
+}
+
+// Join implements RoomBackend.Join, subscribing to the room channel when the
+// first local client joins the room, unless sharding
+func (b *Backend) Join(roomID, clientID string) error {
+	b.mutex.Lock()
+	r, ok := b.rooms[roomID]
+	if !ok {
+		r = &room{local: make(map[string]struct{}), remote: make(map[string]string)}
+		b.rooms[roomID] = r
+	}
+	r.local[clientID] = struct{}{}
+
+	if r.unsubscribe == nil && b.membership == nil {
+		unsubscribe, err := b.transport.Subscribe(b.channel(roomID), b.handle)
+		if err != nil {
+			delete(r.local, clientID)
+			if len(r.local) == 0 {
+				delete(b.rooms, roomID)
+			}
+			b.mutex.Unlock()
+			return fmt.Errorf("failed to subscribe to room %s: %w", roomID, err)
+		}
+		r.unsubscribe = unsubscribe
+	}
+	b.dropPendingLeave(roomID, clientID)
+	b.mutex.Unlock()
+
+	// Publish outside the lock, a transport may deliver to this instance synchronously
+	return b.publish(event{Type: eventJoin, Room: roomID, Client: clientID})
+}
+
+// Leave implements RoomBackend.Leave, unsubscribing from the room channel
+// when the last local client leaves the room
+func (b *Backend) Leave(roomID, clientID string) error {
+	b.mutex.Lock()
+	var unsubscribe func() error
+	if r, ok := b.rooms[roomID]; ok {
+		delete(r.local, clientID)
+		if len(r.local) == 0 {
+			unsubscribe = r.unsubscribe
+			delete(b.rooms, roomID)
+		}
+	}
+	b.mutex.Unlock()
+
+	leave := event{Type: eventLeave, Room: roomID, Client: clientID}
+	err := b.publish(leave)
+	if err != nil {
+		// Other instances would keep the client as a member of the room
+		b.mutex.Lock()
+		b.pendingLeaves = append(b.pendingLeaves, leave)
+		b.mutex.Unlock()
+	}
+	if unsubscribe != nil {
+		if uerr := unsubscribe(); uerr != nil {
+			b.logger.Warn("Failed to unsubscribe from room", "error", uerr, "room_id", roomID)
+		}
+	}
+	return err
+}
+
+// Relay implements RoomBackend.Relay. The instance the recipient is connected
+// to delivers the message; it is dropped if no instance has the recipient.
+// At least once, a relay that cannot be published is left to its retries
+// rather than failing.
+func (b *Backend) Relay(roomID, recipient string, message []byte) error {
+	relay := event{Type: eventRelay, Room: roomID, Client: recipient, Message: message}
+	if b.pendingRelays == nil {
+		return b.publish(relay)
+	}
+
+	// The relay is pending before it is published, as a transport may
+	// deliver the acknowledgement synchronously
+	relay.ID = newRelayID()
+	b.mutex.Lock()
+	pending := &pendingRelay{event: relay, attempts: 1}
+	b.pendingRelays[relay.ID] = pending
+	b.scheduleRetry(pending)
+	b.mutex.Unlock()
+
+	if err := b.publish(relay); err != nil {
+		b.logger.Warn("Failed to publish relay, retrying", "error", err, "client_id", recipient, "room_id", roomID)
+	}
+	return nil
+}
+
+// PendingRelays returns the number of at-least-once relays waiting for
+// their acknowledgement
+func (b *Backend) PendingRelays() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.pendingRelays)
+}
+
+// scheduleRetry publishes a pending relay again after the retry interval,
+// unless it is acknowledged first. Must be called with the mutex held.
+func (b *Backend) scheduleRetry(pending *pendingRelay) {
+	id := pending.event.ID
+	pending.timer = time.AfterFunc(b.retryInterval, func() {
+		b.retryRelay(id)
+	})
+}
+
+// retryRelay publishes a relay that was not acknowledged again, or gives up
+// on it after the last attempt or once it cannot be delivered anymore
+func (b *Backend) retryRelay(id string) {
+	b.mutex.Lock()
+	pending, ok := b.pendingRelays[id]
+	if !ok {
+		b.mutex.Unlock()
+		return
+	}
+	relay := pending.event
+
+	// Without local peers the room is unsubscribed and acknowledgements
+	// would not be received
+	r, subscribed := b.rooms[relay.Room]
+	outcome := "retried"
+	switch {
+	case !subscribed || r.remote[relay.Client] == "":
+		outcome = "abandoned"
+		delete(b.pendingRelays, id)
+	case pending.attempts >= b.maxAttempts:
+		outcome = "unacked"
+		delete(b.pendingRelays, id)
+	default:
+		pending.attempts++
+		b.scheduleRetry(pending)
+	}
+	attempts := pending.attempts
+	b.mutex.Unlock()
+
+	if b.metrics != nil {
+		b.metrics.ClusterRelay(outcome)
+	}
+	switch outcome {
+	case "abandoned":
+		b.logger.Debug("Abandoned relay to peer that left", "client_id", relay.Client, "room_id", relay.Room)
+	case "unacked":
+		b.logger.Warn("Relay not acknowledged, giving up", "client_id", relay.Client, "room_id", relay.Room, "attempts", attempts)
+	default:
+		if err := b.publish(relay); err != nil {
+			b.logger.Warn("Failed to publish relay, retrying", "error", err, "client_id", relay.Client, "room_id", relay.Room, "attempts", attempts)
+		}
+	}
+}
+
+// acknowledged stops retrying an acknowledged relay
+func (b *Backend) acknowledged(id string) {
+	b.mutex.Lock()
+	pending, ok := b.pendingRelays[id]
+	if ok {
+		pending.timer.Stop()
+		delete(b.pendingRelays, id)
+	}
+	b.mutex.Unlock()
+
+	if ok && b.metrics != nil {
+		b.metrics.ClusterRelay("acked")
+	}
+}
+
+// Peers returns the peers of a room known to this instance, local and remote,
+// sorted by ID. Only rooms with local peers are tracked.
+func (b *Backend) Peers(roomID string) []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	peers := make([]string, 0)
+	if r, ok := b.rooms[roomID]; ok {
+		for peer := range r.local {
+			peers = append(peers, peer)
+		}
+		for peer := range r.remote {
+			if _, ok := r.local[peer]; !ok {
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	sort.Strings(peers)
+	return peers
+}
+
+// Degraded reports whether the bus is unreachable, and the rooms shared with
+// other instances are read-only
+func (b *Backend) Degraded() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.degraded != nil
+}
+
+// Check pings the bus, marking the backend degraded when it is unreachable
+// and reconciling membership with the other instances once it is reachable
+// again. When sharding, it also renews this node's membership and rebuilds
+// the ring if the members changed. It returns whether the bus is reachable.
+func (b *Backend) Check() bool {
+	if err := b.transport.Ping(); err != nil {
+		b.partitioned(err)
+		return false
+	}
+	if b.membership != nil {
+		b.refreshMembers()
+	}
+	b.reconcile()
+	return true
+}
+
+// Run checks the bus every interval until the context is done
+func (b *Backend) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.Check()
+		}
+	}
+}
+
+// partitioned marks the rooms shared with other instances degraded, unless
+// the bus was already unreachable. Remote members are kept until the bus is
+// reachable again, as they are likely still connected.
+func (b *Backend) partitioned(err error) {
+	b.mutex.Lock()
+	if b.degraded != nil {
+		b.mutex.Unlock()
+		return
+	}
+	rooms := make([]string, 0)
+	for roomID, r := range b.rooms {
+		if len(r.remote) > 0 {
+			rooms = append(rooms, roomID)
+		}
+	}
+	sort.Strings(rooms)
+	b.degraded = rooms
+	b.mutex.Unlock()
+
+	b.logger.Warn("Lost connection to the cluster, shared rooms are read-only", "error", err, "rooms", len(rooms))
+	if b.onPartition != nil {
+		b.onPartition(rooms, true)
+	}
+}
+
+// reconcile replays the pending leaves and, after a partition, rebuilds the
+// membership of every room: remote members are forgotten and each instance
+// re-announces its own, which also covers the joins and leaves published
+// while this instance could not receive them
+func (b *Backend) reconcile() {
+	b.mutex.Lock()
+	leaves := b.pendingLeaves
+	b.pendingLeaves = nil
+
+	rooms := b.degraded
+	b.degraded = nil
+	members := make(map[string][]string)
+	if rooms != nil {
+		for roomID, r := range b.rooms {
+			r.remote = make(map[string]string)
+			for peer := range r.local {
+				members[roomID] = append(members[roomID], peer)
+			}
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, leave := range leaves {
+		if err := b.publish(leave); err != nil {
+			b.logger.Warn("Failed to announce leave", "error", err, "client_id", leave.Client, "room_id", leave.Room)
+		}
+	}
+	if rooms == nil {
+		return
+	}
+
+	for roomID, peers := range members {
+		if err := b.publish(event{Type: eventResync, Room: roomID}); err != nil {
+			b.logger.Warn("Failed to resync room", "error", err, "room_id", roomID)
+			continue
+		}
+		for _, peer := range peers {
+			if err := b.publish(event{Type: eventPresent, Room: roomID, Client: peer}); err != nil {
+				b.logger.Warn("Failed to announce room member", "error", err, "client_id", peer, "room_id", roomID)
+			}
+		}
+	}
+
+	b.logger.Info("Reconnected to the cluster, reconciled room membership", "rooms", len(members))
+	if b.onPartition != nil {
+		b.onPartition(rooms, false)
+	}
+}
+
+// dropPendingLeave forgets a pending leave of a client that joined the room
+// again. Must be called with the mutex held.
+func (b *Backend) dropPendingLeave(roomID, clientID string) {
+	pending := b.pendingLeaves[:0]
+	for _, leave := range b.pendingLeaves {
+		if leave.Room != roomID || leave.Client != clientID {
+			pending = append(pending, leave)
+		}
+	}
+	b.pendingLeaves = pending
+}
+
+// Close unsubscribes from every room channel and closes the transport,
+// giving up on the pending relays. When sharding, this node leaves the
+// cluster, and the other nodes take over its rooms.
+func (b *Backend) Close() error {
+	b.mutex.Lock()
+	rooms := b.rooms
+	b.rooms = make(map[string]*room)
+	for id, pending := range b.pendingRelays {
+		pending.timer.Stop()
+		delete(b.pendingRelays, id)
+	}
+	unsubscribeNode := b.unsubscribeNode
+	b.unsubscribeNode = nil
+	b.mutex.Unlock()
+
+	for roomID, r := range rooms {
+		if r.unsubscribe == nil {
+			continue
+		}
+		if err := r.unsubscribe(); err != nil {
+			b.logger.Warn("Failed to unsubscribe from room", "error", err, "room_id", roomID)
+		}
+	}
+	if b.membership != nil {
+		if err := b.membership.Leave(b.node); err != nil {
+			b.logger.Warn("Failed to leave the cluster", "error", err)
+		}
+	}
+	if unsubscribeNode != nil {
+		if err := unsubscribeNode(); err != nil {
+			b.logger.Warn("Failed to unsubscribe from node channel", "error", err)
+		}
+	}
+	return b.transport.Close()
+}
+
+// handle applies an event published by another instance
+func (b *Backend) handle(data []byte) {
+	var e event
+	if err := json.Unmarshal(data, &e); err != nil {
+		b.logger.Warn("Discarding malformed cluster event", "error", err)
+		return
+	}
+	if e.Node == b.node {
+		return
+	}
+	b.apply(e)
+}
+
+// apply applies an event of another instance to the rooms with local peers
+func (b *Backend) apply(e event) {
+	if e.Type == eventRelay {
+		b.mutex.Lock()
+		r, ok := b.rooms[e.Room]
+		local := ok && hasKey(r.local, e.Client)
+		b.mutex.Unlock()
+
+		if !local {
+			return
+		}
+		if err := b.deliver(e.Client, e.Message); err != nil {
+			b.logger.Warn("Failed to deliver relayed message", "error", err, "client_id", e.Client, "from_node", e.Node)
+			return
+		}
+		if e.ID != "" {
+			ack := event{Type: eventAck, Room: e.Room, Client: e.Client, ID: e.ID}
+			var err error
+			if b.membership != nil {
+				err = b.send(e.Node, ack)
+			} else {
+				err = b.publish(ack)
+			}
+			if err != nil {
+				b.logger.Warn("Failed to acknowledge relay", "error", err, "client_id", e.Client, "from_node", e.Node)
+			}
+		}
+		return
+	}
+	if e.Type == eventAck {
+		b.acknowledged(e.ID)
+		return
+	}
+
+	b.mutex.Lock()
+	r, ok := b.rooms[e.Room]
+	if !ok {
+		b.mutex.Unlock()
+		return
+	}
+
+	// An instance announcing its first member of the room, or resyncing it,
+	// has not seen ours yet. When sharding, the owner of the room tells it.
+	var present []string
+	if b.membership == nil && (e.Type == eventResync || e.Type == eventJoin && !hasValue(r.remote, e.Node)) {
+		for peer := range r.local {
+			present = append(present, peer)
+		}
+	}
+
+	switch e.Type {
+	case eventJoin, eventPresent:
+		r.remote[e.Client] = e.Node
+	case eventLeave:
+		if r.remote[e.Client] == e.Node {
+			delete(r.remote, e.Client)
+		}
+	case eventResync:
+		for peer, node := range r.remote {
+			if node == e.Node {
+				delete(r.remote, peer)
+			}
+		}
+	}
+	b.mutex.Unlock()
+
+	for _, peer := range present {
+		if err := b.publish(event{Type: eventPresent, Room: e.Room, Client: peer}); err != nil {
+			b.logger.Warn("Failed to announce room member", "error", err, "client_id", peer, "room_id", e.Room)
+		}
+	}
+}
+
+// publish publishes an event from this instance on its room channel, or
+// routes it to the node it concerns when sharding
+func (b *Backend) publish(e event) error {
+	if b.membership != nil {
+		return b.route(e)
+	}
+
+	e.Node = b.node
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cluster event: %w", err)
+	}
+	if err := b.transport.Publish(b.channel(e.Room), data); err != nil {
+		return fmt.Errorf("failed to publish %s event for room %s: %w", e.Type, e.Room, err)
+	}
+	return nil
+}
+
+// channel returns the channel of a room
+func (b *Backend) channel(roomID string) string {
+	if namer, ok := b.transport.(ChannelNamer); ok {
+		return namer.Channel(b.prefix, roomID)
+	}
+	return b.prefix + ":room:" + roomID
+}
+
+// newRelayID returns a random ID of an at-least-once relay
+func newRelayID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// hasKey reports whether the set contains the key
+func hasKey(set map[string]struct{}, key string) bool {
+	_, ok := set[key]
+	return ok
+}
+
+// hasValue reports whether any key maps to the value
+func hasValue(m map[string]string, value string) bool {
+	for _, v := range m {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
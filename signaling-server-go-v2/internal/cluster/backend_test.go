@@ -0,0 +1,264 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// recorder records the messages delivered to local clients
+type recorder struct {
+	messages map[string][]string
+	mutex    sync.Mutex
+}
+
+func newRecorder() *recorder {
+	return &recorder{messages: make(map[string][]string)}
+}
+
+func (r *recorder) deliver(clientID string, message []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.messages[clientID] = append(r.messages[clientID], string(message))
+	return nil
+}
+
+func (r *recorder) received(clientID string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.messages[clientID]
+}
+
+// errPartitioned is returned by a cut link
+var errPartitioned = errors.New("partitioned")
+
+// link connects a node to a MemoryBus; cutting it injects a partition, in
+// which the node neither publishes nor receives
+type link struct {
+	*MemoryBus
+	cut atomic.Bool
+}
+
+func (l *link) Publish(channel string, data []byte) error {
+	if l.cut.Load() {
+		return errPartitioned
+	}
+	return l.MemoryBus.Publish(channel, data)
+}
+
+func (l *link) Subscribe(channel string, handle func([]byte)) (func() error, error) {
+	return l.MemoryBus.Subscribe(channel, func(data []byte) {
+		if !l.cut.Load() {
+			handle(data)
+		}
+	})
+}
+
+func (l *link) Ping() error {
+	if l.cut.Load() {
+		return errPartitioned
+	}
+	return nil
+}
+
+func TestBackendRelaysBetweenNodes(t *testing.T) {
+	bus := NewMemoryBus()
+	deliveredA, deliveredB := newRecorder(), newRecorder()
+	a := NewBackend(bus, deliveredA.deliver, testsupport.NewLogger(), WithNodeID("node-a"))
+	b := NewBackend(bus, deliveredB.deliver, testsupport.NewLogger(), WithNodeID("node-b"))
+
+	if err := a.Join("room", "alice"); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if err := b.Join("room", "bob"); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+
+	// Each node learns about the members of the other, whichever joined first
+	expected := []string{"alice", "bob"}
+	if peers := a.Peers("room"); !reflect.DeepEqual(peers, expected) {
+		t.Errorf("Expected node a to know %v, got %v", expected, peers)
+	}
+	if peers := b.Peers("room"); !reflect.DeepEqual(peers, expected) {
+		t.Errorf("Expected node b to know %v, got %v", expected, peers)
+	}
+
+	if err := a.Relay("room", "bob", []byte(`{"type":"offer"}`)); err != nil {
+		t.Fatalf("Relay failed: %v", err)
+	}
+	if got := deliveredB.received("bob"); len(got) != 1 || got[0] != `{"type":"offer"}` {
+		t.Errorf("Expected the offer to be delivered to bob, got %v", got)
+	}
+	if got := deliveredA.received("bob"); len(got) != 0 {
+		t.Errorf("Expected the sending node not to deliver, got %v", got)
+	}
+
+	// Relays to clients unknown to every node are dropped
+	if err := a.Relay("room", "carol", []byte(`{}`)); err != nil {
+		t.Fatalf("Relay failed: %v", err)
+	}
+	if got := deliveredB.received("carol"); len(got) != 0 {
+		t.Errorf("Expected no delivery to an unknown client, got %v", got)
+	}
+
+	if err := b.Leave("room", "bob"); err != nil {
+		t.Fatalf("Leave failed: %v", err)
+	}
+	if peers := a.Peers("room"); !reflect.DeepEqual(peers, []string{"alice"}) {
+		t.Errorf("Expected bob to be forgotten after leaving, got %v", peers)
+	}
+	if peers := b.Peers("room"); len(peers) != 0 {
+		t.Errorf("Expected node b to stop tracking the room, got %v", peers)
+	}
+
+	// Node b unsubscribed with its last local peer
+	if err := a.Relay("room", "bob", []byte(`{}`)); err != nil {
+		t.Fatalf("Relay failed: %v", err)
+	}
+	if got := deliveredB.received("bob"); len(got) != 1 {
+		t.Errorf("Expected no delivery after leaving, got %v", got)
+	}
+}
+
+func TestBackendClose(t *testing.T) {
+	bus := NewMemoryBus()
+	b := NewBackend(bus, newRecorder().deliver, testsupport.NewLogger())
+	b.Join("room-1", "alice")
+	b.Join("room-2", "alice")
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(bus.subscribers) != 0 {
+		t.Errorf("Expected every room channel to be unsubscribed, got %d", len(bus.subscribers))
+	}
+}
+
+func TestBackendPartition(t *testing.T) {
+	bus := NewMemoryBus()
+	linkA := &link{MemoryBus: bus}
+	var notices []string
+	a := NewBackend(linkA, newRecorder().deliver, testsupport.NewLogger(), WithNodeID("node-a"),
+		WithPartitionHandler(func(roomIDs []string, degraded bool) {
+			notices = append(notices, fmt.Sprintf("%v %v", roomIDs, degraded))
+		}),
+	)
+	b := NewBackend(&link{MemoryBus: bus}, newRecorder().deliver, testsupport.NewLogger(), WithNodeID("node-b"))
+
+	a.Join("shared", "alice")
+	a.Join("shared", "dave")
+	a.Join("local", "erin")
+	b.Join("shared", "bob")
+
+	linkA.cut.Store(true)
+	if a.Check() {
+		t.Fatal("Expected the check to fail while partitioned")
+	}
+	if !a.Degraded() {
+		t.Error("Expected node a to be degraded")
+	}
+
+	// Membership changes on either side of the partition
+	b.Leave("shared", "bob")
+	b.Join("shared", "carol")
+	if err := a.Leave("shared", "dave"); err == nil {
+		t.Error("Expected the leave not to be published while partitioned")
+	}
+
+	// Only the first failed check reports the partition
+	a.Check()
+	expected := []string{"[shared] true"}
+	if !reflect.DeepEqual(notices, expected) {
+		t.Errorf("Expected notices %v, got %v", expected, notices)
+	}
+
+	linkA.cut.Store(false)
+	if !a.Check() {
+		t.Fatal("Expected the check to pass once reconnected")
+	}
+	if a.Degraded() {
+		t.Error("Expected node a to recover")
+	}
+	expected = append(expected, "[shared] false")
+	if !reflect.DeepEqual(notices, expected) {
+		t.Errorf("Expected notices %v, got %v", expected, notices)
+	}
+
+	// Both nodes converge on the membership after the partition
+	members := []string{"alice", "carol"}
+	if peers := a.Peers("shared"); !reflect.DeepEqual(peers, members) {
+		t.Errorf("Expected node a to know %v, got %v", members, peers)
+	}
+	if peers := b.Peers("shared"); !reflect.DeepEqual(peers, members) {
+		t.Errorf("Expected node b to know %v, got %v", members, peers)
+	}
+	if peers := a.Peers("local"); !reflect.DeepEqual(peers, []string{"erin"}) {
+		t.Errorf("Expected the local room to be unaffected, got %v", peers)
+	}
+}
+
+// lossyLink drops the first acknowledgements published by a node
+type lossyLink struct {
+	*MemoryBus
+	drop atomic.Int32
+}
+
+func (l *lossyLink) Publish(channel string, data []byte) error {
+	if strings.Contains(string(data), `"type":"ack"`) && l.drop.Add(-1) >= 0 {
+		return nil
+	}
+	return l.MemoryBus.Publish(channel, data)
+}
+
+func TestBackendAtLeastOnce(t *testing.T) {
+	bus := NewMemoryBus()
+	deliveredB := newRecorder()
+	lossy := &lossyLink{MemoryBus: bus}
+	lossy.drop.Store(1)
+	a := NewBackend(bus, newRecorder().deliver, testsupport.NewLogger(), WithNodeID("node-a"), WithAtLeastOnce(10*time.Millisecond, 3))
+	b := NewBackend(lossy, deliveredB.deliver, testsupport.NewLogger(), WithNodeID("node-b"))
+	a.Join("room", "alice")
+	b.Join("room", "bob")
+
+	// The acknowledgement of the first delivery is lost, so the relay is
+	// delivered again
+	if err := a.Relay("room", "bob", []byte(`{"type":"answer","id":"1"}`)); err != nil {
+		t.Fatalf("Relay failed: %v", err)
+	}
+	waitFor(t, func() bool { return a.PendingRelays() == 0 })
+	if received := deliveredB.received("bob"); !reflect.DeepEqual(received, []string{`{"type":"answer","id":"1"}`, `{"type":"answer","id":"1"}`}) {
+		t.Errorf("Expected the relay to be delivered twice, got %v", received)
+	}
+
+	// Relays to a recipient that is not a peer of the room are abandoned
+	a.Relay("room", "carol", []byte(`{"type":"answer","id":"2"}`))
+	if a.PendingRelays() != 1 {
+		t.Fatalf("Expected the relay to be pending, got %d", a.PendingRelays())
+	}
+	waitFor(t, func() bool { return a.PendingRelays() == 0 })
+
+	// Relays are published at most once by default
+	b.Relay("room", "alice", []byte(`{"type":"offer"}`))
+	if b.PendingRelays() != 0 {
+		t.Errorf("Expected no pending relays at most once, got %d", b.PendingRelays())
+	}
+}
+
+// waitFor polls a condition until it holds, failing after a second
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// Membership tracks the live nodes of the cluster, from which every node
+// builds the same Ring
+type Membership interface {
+	// Announce announces the node as live. A node that is not announced
+	// again within the member TTL is considered gone.
+	Announce(node string) error
+
+	// Members returns the IDs of the live nodes
+	Members() ([]string, error)
+
+	// Leave withdraws the node from the cluster
+	Leave(node string) error
+}
+
+// NewMembership creates the membership configured for sharding. It returns
+// nil if rooms are not sharded.
+func NewMembership(cfg config.ClusterConfig) (Membership, error) {
+	if !cfg.Sharding.Enabled {
+		return nil, nil
+	}
+
+	ttl := time.Duration(cfg.Sharding.MemberTTL) * time.Second
+	switch cfg.Sharding.Membership {
+	case "redis":
+		return NewRedisMembership(cfg.Redis, ttl), nil
+	case "memberlist":
+		return NewMemberlistMembership(cfg.Sharding.Memberlist, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown cluster membership: %s", cfg.Sharding.Membership)
+	}
+}
+
+// RedisMembership is a simplified Membership keeping a key per node in Redis,
+// expiring unless the node announces itself again
+type RedisMembership struct {
+	address  string
+	password string
+	db       int
+	prefix   string
+	ttl      time.Duration
+}
+
+// NewRedisMembership creates a RedisMembership for the Redis server in the
+// configuration, expiring nodes after ttl
+func NewRedisMembership(cfg config.RedisConfig, ttl time.Duration) *RedisMembership {
+	return &RedisMembership{
+		address:  cfg.Address,
+		password: cfg.Password,
+		db:       cfg.DB,
+		prefix:   cfg.ChannelPrefix + ":members:",
+		ttl:      ttl,
+	}
+}
+
+// Announce implements Membership.Announce
+func (m *RedisMembership) Announce(node string) error {
+	// In a real implementation, this would SET the key prefix+node with the
+	// TTL in PX, renewing its expiry
+	return nil
+}
+
+// Members implements Membership.Members
+func (m *RedisMembership) Members() ([]string, error) {
+	// In a real implementation, this would SCAN the keys matching prefix+"*"
+	// and return the node IDs after the prefix; expired nodes are gone
+	return nil, nil
+}
+
+// Leave implements Membership.Leave
+func (m *RedisMembership) Leave(node string) error {
+	// In a real implementation, this would DEL the key prefix+node
+	return nil
+}
+
+// MemberlistMembership is a simplified Membership gossiping with the other
+// nodes over the SWIM protocol of hashicorp/memberlist, without a shared store
+type MemberlistMembership struct {
+	bindAddress string
+	seeds       []string
+	ttl         time.Duration
+}
+
+// NewMemberlistMembership creates a MemberlistMembership bound to the address
+// in the configuration and joining the cluster through its seeds. Nodes that
+// fail to answer probes for ttl are considered gone.
+func NewMemberlistMembership(cfg config.MemberlistConfig, ttl time.Duration) *MemberlistMembership {
+	// In a real implementation, this would create the memberlist on the bind
+	// address with ttl as the suspicion timeout
+	return &MemberlistMembership{
+		bindAddress: cfg.BindAddress,
+		seeds:       cfg.Seeds,
+		ttl:         ttl,
+	}
+}
+
+// Announce implements Membership.Announce
+func (m *MemberlistMembership) Announce(node string) error {
+	// In a real implementation, this would join the seeds under the node's
+	// name the first time, after which gossip keeps the node alive
+	return nil
+}
+
+// Members implements Membership.Members
+func (m *MemberlistMembership) Members() ([]string, error) {
+	// In a real implementation, this would return the names of the alive
+	// members of the memberlist
+	return nil, nil
+}
+
+// Leave implements Membership.Leave
+func (m *MemberlistMembership) Leave(node string) error {
+	// In a real implementation, this would broadcast the leave intent and
+	// shut the memberlist down
+	return nil
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryBus is an in-process Transport, connecting Backends within a single
+// process. It is used in tests and to run several nodes in one process.
+type MemoryBus struct {
+	subscribers map[string]map[int]func([]byte)
+	next        int
+	mutex       sync.Mutex
+}
+
+// NewMemoryBus creates an empty MemoryBus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		subscribers: make(map[string]map[int]func([]byte)),
+	}
+}
+
+// Publish implements Transport.Publish, calling the subscribers synchronously
+func (m *MemoryBus) Publish(channel string, data []byte) error {
+	m.mutex.Lock()
+	handlers := make([]func([]byte), 0, len(m.subscribers[channel]))
+	for _, handle := range m.subscribers[channel] {
+		handlers = append(handlers, handle)
+	}
+	m.mutex.Unlock()
+
+	for _, handle := range handlers {
+		handle(data)
+	}
+	return nil
+}
+
+// Subscribe implements Transport.Subscribe
+func (m *MemoryBus) Subscribe(channel string, handle func([]byte)) (func() error, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.subscribers[channel] == nil {
+		m.subscribers[channel] = make(map[int]func([]byte))
+	}
+	id := m.next
+	m.next++
+	m.subscribers[channel][id] = handle
+
+	return func() error {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		delete(m.subscribers[channel], id)
+		if len(m.subscribers[channel]) == 0 {
+			delete(m.subscribers, channel)
+		}
+		return nil
+	}, nil
+}
+
+// Ping implements Transport.Ping. The bus is in-process, so it is always reachable.
+func (m *MemoryBus) Ping() error {
+	return nil
+}
+
+// Close implements Transport.Close. The bus is shared by its Backends, so it stays usable.
+func (m *MemoryBus) Close() error {
+	return nil
+}
+
+// MemoryMembership is an in-process Membership, shared by Backends within a
+// single process like MemoryBus
+type MemoryMembership struct {
+	announced map[string]time.Time
+	ttl       time.Duration
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+// NewMemoryMembership creates an empty MemoryMembership expiring nodes after ttl
+func NewMemoryMembership(ttl time.Duration) *MemoryMembership {
+	return &MemoryMembership{
+		announced: make(map[string]time.Time),
+		ttl:       ttl,
+		now:       time.Now,
+	}
+}
+
+// Announce implements Membership.Announce
+func (m *MemoryMembership) Announce(node string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.announced[node] = m.now()
+	return nil
+}
+
+// Members implements Membership.Members, sorted by ID
+func (m *MemoryMembership) Members() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	members := make([]string, 0, len(m.announced))
+	for node, at := range m.announced {
+		if m.now().Sub(at) < m.ttl {
+			members = append(members, node)
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// Leave implements Membership.Leave
+func (m *MemoryMembership) Leave(node string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.announced, node)
+	return nil
+}
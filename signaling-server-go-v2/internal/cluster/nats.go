@@ -0,0 +1,143 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// namespaceSeparator separates the namespace segments of room IDs, which
+// become the tokens of the room subjects
+const namespaceSeparator = "/"
+
+// NATSTransport is a simplified Transport over NATS core pub/sub. Each room
+// has its own subject, with the namespace segments of the room ID as subject
+// tokens, so a room's traffic only reaches the instances serving it.
+type NATSTransport struct {
+	urls          []string
+	token         string
+	reconnectWait time.Duration
+	maxReconnects int
+
+	// subscriptions are restored after the connection to the server is re-established
+	subscriptions map[string]map[int]func([]byte)
+	next          int
+	mutex         sync.Mutex
+}
+
+// NewNATSTransport creates a NATSTransport for the NATS servers in the configuration
+func NewNATSTransport(cfg config.NATSConfig) *NATSTransport {
+	urls := make([]string, 0)
+	for _, url := range strings.Split(cfg.URL, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+
+	// In a real implementation, this would connect to the servers with the
+	// token, retrying every reconnectWait up to maxReconnects times, and
+	// register reconnected as the reconnect handler
+	return &NATSTransport{
+		urls:          urls,
+		token:         cfg.Token,
+		reconnectWait: time.Duration(cfg.ReconnectWait) * time.Second,
+		maxReconnects: cfg.MaxReconnects,
+		subscriptions: make(map[string]map[int]func([]byte)),
+	}
+}
+
+// Channel implements ChannelNamer, mapping a room ID to a subject. Characters
+// NATS reserves in subjects are escaped within each token.
+func (t *NATSTransport) Channel(prefix, roomID string) string {
+	segments := strings.Split(roomID, namespaceSeparator)
+	for i, segment := range segments {
+		segments[i] = escapeSubjectToken(segment)
+	}
+	return prefix + ".room." + strings.Join(segments, ".")
+}
+
+// NodeChannel implements ChannelNamer, mapping a node ID to a subject
+func (t *NATSTransport) NodeChannel(prefix, node string) string {
+	return prefix + ".node." + escapeSubjectToken(node)
+}
+
+// Publish implements Transport.Publish
+func (t *NATSTransport) Publish(subject string, data []byte) error {
+	// In a real implementation, this would publish the data on the subject;
+	// the client buffers publishes while reconnecting
+	return nil
+}
+
+// Subscribe implements Transport.Subscribe
+func (t *NATSTransport) Subscribe(subject string, handle func([]byte)) (func() error, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.subscriptions[subject] == nil {
+		t.subscriptions[subject] = make(map[int]func([]byte))
+	}
+	id := t.next
+	t.next++
+	t.subscriptions[subject][id] = handle
+
+	// In a real implementation, this would subscribe to the subject and
+	// call handle for each message from the client's dispatch goroutine
+	return func() error {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		delete(t.subscriptions[subject], id)
+		if len(t.subscriptions[subject]) == 0 {
+			delete(t.subscriptions, subject)
+		}
+		// In a real implementation, this would unsubscribe from the subject
+		return nil
+	}, nil
+}
+
+// reconnected restores the subscriptions after the connection to the server
+// was re-established, returning the number of subjects resubscribed
+func (t *NATSTransport) reconnected() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// In a real implementation, this would resubscribe to each subject the
+	// client did not restore itself
+	return len(t.subscriptions)
+}
+
+// Ping implements Transport.Ping
+func (t *NATSTransport) Ping() error {
+	// In a real implementation, this would flush the connection, waiting for
+	// the server's PONG, and fail while the client is reconnecting
+	return nil
+}
+
+// Close implements Transport.Close
+func (t *NATSTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// In a real implementation, this would drain the subscriptions and close the connection
+	t.subscriptions = make(map[string]map[int]func([]byte))
+	return nil
+}
+
+// escapeSubjectToken percent-encodes the characters that are not allowed in
+// a NATS subject token: separators, wildcards, whitespace and the escape itself
+func escapeSubjectToken(token string) string {
+	var b strings.Builder
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		switch {
+		case c == '.' || c == '*' || c == '>' || c == '%' || c <= ' ' || c == 0x7f:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
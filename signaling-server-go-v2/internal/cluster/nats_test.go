@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestNATSChannel(t *testing.T) {
+	transport := NewNATSTransport(config.NATSConfig{URL: "nats://a:4222, nats://b:4222"})
+	if len(transport.urls) != 2 {
+		t.Errorf("Expected 2 server URLs, got %v", transport.urls)
+	}
+
+	tests := []struct {
+		roomID   string
+		expected string
+	}{
+		{"lobby", "signaling.room.lobby"},
+		{"acme/web/lobby", "signaling.room.acme.web.lobby"},
+		{"v1.2 *all*", "signaling.room.v1%2E2%20%2Aall%2A"},
+		{"a>b/100%", "signaling.room.a%3Eb.100%25"},
+	}
+
+	for _, tt := range tests {
+		if got := transport.Channel("signaling", tt.roomID); got != tt.expected {
+			t.Errorf("Channel(%q) = %q, expected %q", tt.roomID, got, tt.expected)
+		}
+	}
+
+	b := NewBackend(transport, newRecorder().deliver, testsupport.NewLogger())
+	if got := b.channel("acme/web"); got != "signaling.room.acme.web" {
+		t.Errorf("Expected the backend to use NATS subjects, got %q", got)
+	}
+}
+
+func TestNATSResubscribesAfterReconnect(t *testing.T) {
+	transport := NewNATSTransport(config.NATSConfig{URL: "nats://localhost:4222"})
+	b := NewBackend(transport, newRecorder().deliver, testsupport.NewLogger())
+
+	b.Join("room-1", "alice")
+	b.Join("room-2", "alice")
+	b.Leave("room-2", "alice")
+
+	if n := transport.reconnected(); n != 1 {
+		t.Errorf("Expected the remaining room subject to be restored, got %d", n)
+	}
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// RedisNonceCache is a simplified nonce cache keeping the nonces of one-time
+// tokens in Redis, so that a token used on one instance is rejected on all
+type RedisNonceCache struct {
+	address  string
+	password string
+	db       int
+	prefix   string
+}
+
+// NewRedisNonceCache creates a RedisNonceCache for the Redis server in the configuration
+func NewRedisNonceCache(cfg config.RedisConfig) *RedisNonceCache {
+	return &RedisNonceCache{
+		address:  cfg.Address,
+		password: cfg.Password,
+		db:       cfg.DB,
+		prefix:   cfg.ChannelPrefix + ":nonce:",
+	}
+}
+
+// Ping checks that the Redis server is reachable
+func (c *RedisNonceCache) Ping(ctx context.Context) error {
+	// In a real implementation, this would send a PING on a pooled client
+	// connection, bounded by the context
+	return ctx.Err()
+}
+
+// Use implements the websocket NonceCache, recording the first use of a nonce
+func (c *RedisNonceCache) Use(ctx context.Context, nonce string, expiry time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	// In a real implementation, this would SET prefix+nonce NX PXAT expiry,
+	// reporting the first use when the key was set. The call is bounded by
+	// the context.
+	return true, nil
+}
+
+// Invalidate implements the websocket NonceCache, rejecting any use of a nonce
+func (c *RedisNonceCache) Invalidate(ctx context.Context, nonce string, expiry time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// In a real implementation, this would run a Lua script setting
+	// prefix+nonce to expire at expiry, unless it already expires later
+	return nil
+}
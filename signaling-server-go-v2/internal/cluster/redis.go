@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// RedisTransport is a simplified Transport over Redis pub/sub
+type RedisTransport struct {
+	address  string
+	password string
+	db       int
+}
+
+// NewRedisTransport creates a RedisTransport for the Redis server in the configuration
+func NewRedisTransport(cfg config.RedisConfig) *RedisTransport {
+	return &RedisTransport{
+		address:  cfg.Address,
+		password: cfg.Password,
+		db:       cfg.DB,
+	}
+}
+
+// Publish implements Transport.Publish
+func (t *RedisTransport) Publish(channel string, data []byte) error {
+	// In a real implementation, this would PUBLISH the data on the channel
+	// using a pooled client connection
+	return nil
+}
+
+// Subscribe implements Transport.Subscribe
+func (t *RedisTransport) Subscribe(channel string, handle func([]byte)) (func() error, error) {
+	// In a real implementation, this would SUBSCRIBE to the channel on a
+	// dedicated connection, resubscribing after reconnecting, and call
+	// handle for each message from a receiving goroutine
+	return func() error {
+		// In a real implementation, this would UNSUBSCRIBE from the channel
+		return nil
+	}, nil
+}
+
+// Ping implements Transport.Ping
+func (t *RedisTransport) Ping() error {
+	// In a real implementation, this would send a PING on a pooled client
+	// connection and on the subscribing connection
+	return nil
+}
+
+// Close implements Transport.Close
+func (t *RedisTransport) Close() error {
+	// In a real implementation, this would close the client connections
+	return nil
+}
@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring is a consistent hash ring assigning keys to nodes. Each node is placed
+// on the ring at several points, its virtual nodes, so that keys spread
+// evenly and a node joining or leaving moves only the keys of its share of
+// the ring. A Ring is immutable; a change of membership creates a new one.
+type Ring struct {
+	nodes  []string
+	points []uint64
+	owners map[uint64]string
+}
+
+// NewRing creates a Ring of the nodes, each with replicas virtual nodes
+func NewRing(nodes []string, replicas int) *Ring {
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	r := &Ring{
+		nodes:  make([]string, 0, len(nodes)),
+		owners: make(map[uint64]string),
+	}
+	seen := make(map[string]struct{})
+	for _, node := range nodes {
+		if _, ok := seen[node]; ok || node == "" {
+			continue
+		}
+		seen[node] = struct{}{}
+		r.nodes = append(r.nodes, node)
+	}
+	sort.Strings(r.nodes)
+
+	for _, node := range r.nodes {
+		for i := 0; i < replicas; i++ {
+			point := hashKey(node + "#" + strconv.Itoa(i))
+			// On a collision the lower node ID keeps the point, so that
+			// every instance builds the same ring
+			if owner, ok := r.owners[point]; ok && owner < node {
+				continue
+			}
+			if _, ok := r.owners[point]; !ok {
+				r.points = append(r.points, point)
+			}
+			r.owners[point] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the node owning the key, the first node clockwise from the
+// key's hash, or "" for an empty ring
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Nodes returns the nodes of the ring, sorted by ID
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+// hashKey returns the position of a key on the ring. FNV alone clusters
+// keys differing in their last characters, such as the virtual nodes of a
+// node, so its hash is mixed with the finalizer of SplitMix64.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
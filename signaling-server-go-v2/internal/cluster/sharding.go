@@ -0,0 +1,310 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// With sharding, each room has an owner node on a consistent hash ring of the
+// live nodes. Rather than being published on the room channel, a node's
+// joins, leaves and resyncs are sent to the owner, which keeps the room's
+// authoritative membership and forwards each change to the other nodes with
+// peers in the room, telling a node new to the room about the existing
+// members. Knowing where every member is connected, a node sends relays to
+// the recipient's node alone. A node that does not know the recipient sends
+// the relay to the owner, which forwards it.
+
+// WithSharding assigns each room an owner node on a consistent hash ring of
+// the members of the cluster, each placed at replicas points on the ring.
+// Events are sent to the nodes they concern instead of every node with
+// peers in the room.
+func WithSharding(membership Membership, replicas int) Option {
+	return func(b *Backend) {
+		b.membership = membership
+		b.replicas = replicas
+		b.owned = make(map[string]map[string]string)
+	}
+}
+
+// Owner returns the node owning a room, this node without sharding
+func (b *Backend) Owner(roomID string) string {
+	if b.membership == nil {
+		return b.node
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.ring.Owner(roomID)
+}
+
+// startSharding places this node alone on the ring until the members are
+// listed, and subscribes to the node's channel
+func (b *Backend) startSharding() {
+	b.ring = NewRing([]string{b.node}, b.replicas)
+	b.refreshMembers()
+}
+
+// refreshMembers announces this node, subscribing to its channel if not yet
+// subscribed, and rebuilds the ring from the live members if they changed.
+// This node is always on its own ring, having just announced itself.
+func (b *Backend) refreshMembers() {
+	b.mutex.Lock()
+	subscribed := b.unsubscribeNode != nil
+	b.mutex.Unlock()
+	if !subscribed {
+		unsubscribe, err := b.transport.Subscribe(b.nodeChannel(b.node), b.handleNode)
+		if err != nil {
+			b.logger.Warn("Failed to subscribe to node channel", "error", err)
+			return
+		}
+		b.mutex.Lock()
+		b.unsubscribeNode = unsubscribe
+		b.mutex.Unlock()
+	}
+
+	if err := b.membership.Announce(b.node); err != nil {
+		b.logger.Warn("Failed to announce node to the cluster", "error", err)
+	}
+	members, err := b.membership.Members()
+	if err != nil {
+		b.logger.Warn("Failed to list cluster members, keeping the ring", "error", err)
+		return
+	}
+	ring := NewRing(append(members, b.node), b.replicas)
+
+	b.mutex.Lock()
+	unchanged := equalNodes(b.ring.Nodes(), ring.Nodes())
+	b.mutex.Unlock()
+	if !unchanged {
+		b.rebalance(ring)
+	}
+}
+
+// rebalance replaces the ring. The members connected to nodes that left the
+// cluster are forgotten, the rooms this node no longer owns are dropped, and
+// the local members of the rooms that moved are announced to their new
+// owner, which rebuilds the room's membership from the announcements.
+func (b *Backend) rebalance(ring *Ring) {
+	b.mutex.Lock()
+	previous := b.ring
+	b.ring = ring
+
+	live := make(map[string]struct{})
+	for _, node := range ring.Nodes() {
+		live[node] = struct{}{}
+	}
+	for _, r := range b.rooms {
+		for peer, node := range r.remote {
+			if !hasKey(live, node) {
+				delete(r.remote, peer)
+			}
+		}
+	}
+	for roomID, members := range b.owned {
+		if ring.Owner(roomID) != b.node {
+			delete(b.owned, roomID)
+			continue
+		}
+		for peer, node := range members {
+			if !hasKey(live, node) {
+				delete(members, peer)
+			}
+		}
+	}
+
+	moved := make(map[string][]string)
+	for roomID, r := range b.rooms {
+		if previous.Owner(roomID) == ring.Owner(roomID) {
+			continue
+		}
+		for peer := range r.local {
+			moved[roomID] = append(moved[roomID], peer)
+		}
+	}
+	b.mutex.Unlock()
+
+	for roomID, peers := range moved {
+		b.announce(roomID, peers)
+	}
+	b.logger.Info("Cluster membership changed, rebalanced room ownership", "nodes", len(live), "rooms_moved", len(moved))
+}
+
+// announce resyncs the local members of a room with its owner
+func (b *Backend) announce(roomID string, peers []string) {
+	if err := b.publish(event{Type: eventResync, Room: roomID}); err != nil {
+		b.logger.Warn("Failed to resync room", "error", err, "room_id", roomID)
+		return
+	}
+	for _, peer := range peers {
+		if err := b.publish(event{Type: eventPresent, Room: roomID, Client: peer}); err != nil {
+			b.logger.Warn("Failed to announce room member", "error", err, "client_id", peer, "room_id", roomID)
+		}
+	}
+}
+
+// route sends an event of this node to the node it concerns: membership
+// changes to the owner of the room, relays to the node of the recipient,
+// or to the owner if the recipient is not known here
+func (b *Backend) route(e event) error {
+	b.mutex.Lock()
+	node := b.ring.Owner(e.Room)
+	if r, ok := b.rooms[e.Room]; ok && e.Type == eventRelay && r.remote[e.Client] != "" {
+		node = r.remote[e.Client]
+	}
+	b.mutex.Unlock()
+
+	return b.send(node, e)
+}
+
+// send sends an event on the channel of a node, or applies it directly if
+// the node is this one. Events forwarded by an owner keep the node they
+// were sent from.
+func (b *Backend) send(node string, e event) error {
+	if e.Node == "" {
+		e.Node = b.node
+	}
+	if node == b.node {
+		b.dispatch(e)
+		return nil
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cluster event: %w", err)
+	}
+	if err := b.transport.Publish(b.nodeChannel(node), data); err != nil {
+		return fmt.Errorf("failed to send %s event for room %s to node %s: %w", e.Type, e.Room, node, err)
+	}
+	return nil
+}
+
+// handleNode applies an event sent on this node's channel
+func (b *Backend) handleNode(data []byte) {
+	var e event
+	if err := json.Unmarshal(data, &e); err != nil {
+		b.logger.Warn("Discarding malformed cluster event", "error", err)
+		return
+	}
+	b.dispatch(e)
+}
+
+// dispatch applies an event sent to this node, as the owner of its room
+// unless an owner forwarded it
+func (b *Backend) dispatch(e event) {
+	switch {
+	case e.Type == eventAck:
+		b.acknowledged(e.ID)
+	case e.Type == eventRelay && e.Via == "":
+		b.forwardRelay(e)
+	case e.Via == "":
+		b.own(e)
+	default:
+		b.apply(e)
+	}
+}
+
+// forwardRelay delivers a relay to a local recipient, or forwards it to the
+// node of the recipient as the owner of the room. It is dropped if no node
+// has the recipient.
+func (b *Backend) forwardRelay(e event) {
+	b.mutex.Lock()
+	r, ok := b.rooms[e.Room]
+	local := ok && hasKey(r.local, e.Client)
+	node := b.owned[e.Room][e.Client]
+	b.mutex.Unlock()
+
+	if local || node == "" || node == b.node {
+		b.apply(e)
+		return
+	}
+	e.Via = b.node
+	if err := b.send(node, e); err != nil {
+		b.logger.Warn("Failed to forward relay", "error", err, "client_id", e.Client, "room_id", e.Room, "to_node", node)
+	}
+}
+
+// own applies a membership change to a room owned by this node, forwarding
+// it to the other nodes with peers in the room. A node announcing its first
+// member of the room, or resyncing it, is sent the other members. Changes
+// are applied even if this node does not own the room on its ring, which
+// may not reflect a change of membership yet.
+func (b *Backend) own(e event) {
+	b.mutex.Lock()
+	members, ok := b.owned[e.Room]
+	if !ok {
+		if e.Type == eventLeave {
+			b.mutex.Unlock()
+			return
+		}
+		members = make(map[string]string)
+		b.owned[e.Room] = members
+	}
+
+	var present []event
+	if e.Type == eventResync || e.Type == eventJoin && !hasValue(members, e.Node) {
+		for peer, node := range members {
+			if node != e.Node {
+				present = append(present, event{Type: eventPresent, Node: node, Room: e.Room, Client: peer, Via: b.node})
+			}
+		}
+	}
+
+	switch e.Type {
+	case eventJoin, eventPresent:
+		members[e.Client] = e.Node
+	case eventLeave:
+		if members[e.Client] == e.Node {
+			delete(members, e.Client)
+		}
+	case eventResync:
+		for peer, node := range members {
+			if node == e.Node {
+				delete(members, peer)
+			}
+		}
+	}
+
+	nodes := make(map[string]struct{})
+	for _, node := range members {
+		if node != e.Node {
+			nodes[node] = struct{}{}
+		}
+	}
+	if len(members) == 0 {
+		delete(b.owned, e.Room)
+	}
+	b.mutex.Unlock()
+
+	e.Via = b.node
+	for node := range nodes {
+		if err := b.send(node, e); err != nil {
+			b.logger.Warn("Failed to forward membership change", "error", err, "room_id", e.Room, "to_node", node)
+		}
+	}
+	for _, p := range present {
+		if err := b.send(e.Node, p); err != nil {
+			b.logger.Warn("Failed to announce room member", "error", err, "client_id", p.Client, "room_id", e.Room, "to_node", e.Node)
+		}
+	}
+}
+
+// nodeChannel returns the channel of a node
+func (b *Backend) nodeChannel(node string) string {
+	if namer, ok := b.transport.(ChannelNamer); ok {
+		return namer.NodeChannel(b.prefix, node)
+	}
+	return b.prefix + ":node:" + node
+}
+
+// equalNodes reports whether two sorted lists of nodes are equal
+func equalNodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
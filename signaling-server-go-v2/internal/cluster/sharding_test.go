@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestRing(t *testing.T) {
+	ring := NewRing([]string{"node-c", "node-a", "node-b", "node-a"}, 128)
+	if nodes := ring.Nodes(); !reflect.DeepEqual(nodes, []string{"node-a", "node-b", "node-c"}) {
+		t.Fatalf("Expected the distinct nodes sorted, got %v", nodes)
+	}
+	if owner := NewRing(nil, 128).Owner("room"); owner != "" {
+		t.Errorf("Expected no owner on an empty ring, got %q", owner)
+	}
+
+	// Rooms spread evenly, and a node joining only takes rooms over
+	grown := NewRing([]string{"node-a", "node-b", "node-c", "node-d"}, 128)
+	shares := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		roomID := fmt.Sprintf("room-%d", i)
+		before, after := ring.Owner(roomID), grown.Owner(roomID)
+		if before != after && after != "node-d" {
+			t.Fatalf("Expected %s to stay on %s or move to node-d, got %s", roomID, before, after)
+		}
+		shares[before]++
+	}
+	for node, share := range shares {
+		if share < 600 {
+			t.Errorf("Expected about a third of the rooms on %s, got %d", node, share)
+		}
+	}
+}
+
+func TestBackendSharding(t *testing.T) {
+	bus := NewMemoryBus()
+	membership := NewMemoryMembership(time.Minute)
+	deliveredA, deliveredB := newRecorder(), newRecorder()
+	a := NewBackend(bus, deliveredA.deliver, testsupport.NewLogger(), WithNodeID("node-a"), WithSharding(membership, 64))
+	b := NewBackend(bus, deliveredB.deliver, testsupport.NewLogger(), WithNodeID("node-b"), WithSharding(membership, 64))
+	c := NewBackend(bus, newRecorder().deliver, testsupport.NewLogger(), WithNodeID("node-c"), WithSharding(membership, 64))
+	for _, node := range []*Backend{a, b, c} {
+		node.Check()
+	}
+
+	// A room owned by the node without peers in it
+	roomID := ""
+	for i := 0; roomID == ""; i++ {
+		if candidate := fmt.Sprintf("room-%d", i); a.Owner(candidate) == "node-c" {
+			roomID = candidate
+		}
+	}
+	if b.Owner(roomID) != "node-c" || c.Owner(roomID) != "node-c" {
+		t.Fatalf("Expected the nodes to agree on the owner of %s", roomID)
+	}
+
+	a.Join(roomID, "alice")
+	b.Join(roomID, "bob")
+	for _, node := range []*Backend{a, b} {
+		if peers := node.Peers(roomID); !reflect.DeepEqual(peers, []string{"alice", "bob"}) {
+			t.Errorf("Expected %s to learn the members from the owner, got %v", node.NodeID(), peers)
+		}
+	}
+
+	// Relays go to the recipient's node alone
+	reached := 0
+	unsubscribe, _ := bus.Subscribe(c.nodeChannel("node-c"), func([]byte) { reached++ })
+	a.Relay(roomID, "bob", []byte(`{"type":"offer"}`))
+	if got := deliveredB.received("bob"); len(got) != 1 {
+		t.Errorf("Expected the relay to be delivered to bob, got %v", got)
+	}
+	if reached != 0 {
+		t.Errorf("Expected the relay to bypass the owner, reached it %d times", reached)
+	}
+	unsubscribe()
+
+	b.Leave(roomID, "bob")
+	if peers := a.Peers(roomID); !reflect.DeepEqual(peers, []string{"alice"}) {
+		t.Errorf("Expected the owner to forward the leave, got %v", peers)
+	}
+
+	// The owner leaving the cluster, its rooms move to the other nodes
+	c.Close()
+	a.Check()
+	b.Check()
+	if owner := a.Owner(roomID); owner == "node-c" || owner != b.Owner(roomID) {
+		t.Fatalf("Expected the room to move off node-c, got %q", owner)
+	}
+	b.Join(roomID, "bob")
+	for _, node := range []*Backend{a, b} {
+		if peers := node.Peers(roomID); !reflect.DeepEqual(peers, []string{"alice", "bob"}) {
+			t.Errorf("Expected %s to learn the members from the new owner, got %v", node.NodeID(), peers)
+		}
+	}
+}
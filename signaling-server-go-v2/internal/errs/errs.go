@@ -0,0 +1,135 @@
+// Package errs defines the coded errors shared by the signaling protocol,
+// the connection hub, the stores and the HTTP handlers, so that callers can
+// tell errors apart with errors.Is and CodeOf rather than by their text, and
+// so that every transport reports them alike: HTTPStatus maps a code to a
+// status and Message returns the text that is safe to show a client.
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Code classifies an error. Codes are sent to clients in the code field of
+// protocol error messages and admin API error responses.
+type Code string
+
+const (
+	// InvalidArgument is a malformed or out of range request
+	InvalidArgument Code = "invalid_argument"
+
+	// Unauthenticated is a request without valid credentials, such as a
+	// missing or wrong room password
+	Unauthenticated Code = "unauthenticated"
+
+	// PermissionDenied is a request the client is not allowed to make, such
+	// as publishing as an observer or joining a room it is banned from
+	PermissionDenied Code = "permission_denied"
+
+	// NotFound is a request for a room, peer or record that does not exist
+	NotFound Code = "not_found"
+
+	// AlreadyExists is a request creating something that exists
+	AlreadyExists Code = "already_exists"
+
+	// FailedPrecondition is a request the current state rejects, such as
+	// joining a locked room; it may succeed once the state changes
+	FailedPrecondition Code = "failed_precondition"
+
+	// ResourceExhausted is a request beyond a limit, such as a full room or
+	// a rate limit
+	ResourceExhausted Code = "resource_exhausted"
+
+	// Unavailable is a request that failed on a dependency and may be
+	// retried, such as a relay to an unreachable cluster
+	Unavailable Code = "unavailable"
+
+	// Internal is an unexpected failure, and the code of errors without one
+	Internal Code = "internal"
+)
+
+// httpStatuses maps the codes to HTTP statuses
+var httpStatuses = map[Code]int{
+	InvalidArgument:    http.StatusBadRequest,
+	Unauthenticated:    http.StatusUnauthorized,
+	PermissionDenied:   http.StatusForbidden,
+	NotFound:           http.StatusNotFound,
+	AlreadyExists:      http.StatusConflict,
+	FailedPrecondition: http.StatusConflict,
+	ResourceExhausted:  http.StatusTooManyRequests,
+	Unavailable:        http.StatusServiceUnavailable,
+	Internal:           http.StatusInternalServerError,
+}
+
+// internalMessage is shown to clients for errors without a code, whose text
+// may disclose internals
+const internalMessage = "internal error"
+
+// Error is an error with a code and a message safe to show clients. The
+// cause, if any, is only part of Error() for the logs.
+type Error struct {
+	Code    Code
+	Message string
+	cause   error
+}
+
+// New returns an error with a code and client message, typically a sentinel
+// that callers wrap with fmt.Errorf("%w: ...") to add details for the logs
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Newf returns an error with a code and a formatted client message
+func Newf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns an error with a code and client message caused by err, which
+// errors.Is and errors.As see through but clients are not shown
+func Wrap(code Code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, cause: err}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// CodeOf returns the code of the outermost coded error in err's chain,
+// Internal if there is none, or "" for a nil error
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return Internal
+}
+
+// Message returns the client message of the outermost coded error in err's
+// chain, or a generic message for errors without a code
+func Message(err error) string {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Message
+	}
+	return internalMessage
+}
+
+// HTTPStatus returns the HTTP status of an error's code
+func HTTPStatus(err error) int {
+	if status, ok := httpStatuses[CodeOf(err)]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,63 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCodes(t *testing.T) {
+	errRoomFull := New(ResourceExhausted, "room is full")
+
+	tests := []struct {
+		name    string
+		err     error
+		code    Code
+		message string
+		status  int
+	}{
+		{"sentinel", errRoomFull, ResourceExhausted, "room is full", http.StatusTooManyRequests},
+		{"wrapped sentinel", fmt.Errorf("%w: acme/standup", errRoomFull), ResourceExhausted, "room is full", http.StatusTooManyRequests},
+		{"formatted", Newf(InvalidArgument, "room metadata exceeds %d bytes", 4096), InvalidArgument, "room metadata exceeds 4096 bytes", http.StatusBadRequest},
+		{"with cause", Wrap(InvalidArgument, "invalid join payload", errors.New("unexpected EOF")), InvalidArgument, "invalid join payload", http.StatusBadRequest},
+		{"uncoded", errors.New("open /var/lib/rooms.db: permission denied"), Internal, "internal error", http.StatusInternalServerError},
+		{"uncoded wrapping coded", fmt.Errorf("restore: %w", New(NotFound, "room not found")), NotFound, "room not found", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := CodeOf(tt.err); code != tt.code {
+				t.Errorf("Expected code %s, got %s", tt.code, code)
+			}
+			if message := Message(tt.err); message != tt.message {
+				t.Errorf("Expected message %q, got %q", tt.message, message)
+			}
+			if status := HTTPStatus(tt.err); status != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, status)
+			}
+		})
+	}
+
+	if CodeOf(nil) != "" {
+		t.Errorf("Expected no code for a nil error, got %s", CodeOf(nil))
+	}
+}
+
+func TestWrap(t *testing.T) {
+	cause := errors.New("unexpected EOF")
+	err := Wrap(InvalidArgument, "invalid join payload", cause)
+
+	// The cause is kept for the logs and errors.Is, but not shown to clients
+	if !errors.Is(err, cause) {
+		t.Error("Expected the error to wrap its cause")
+	}
+	if err.Error() != "invalid join payload: unexpected EOF" || Message(err) != "invalid join payload" {
+		t.Errorf("Expected the cause in Error only, got %q and %q", err.Error(), Message(err))
+	}
+
+	// Wrapping a sentinel with fmt.Errorf keeps it comparable
+	sentinel := New(NotFound, "room not found")
+	if wrapped := fmt.Errorf("%w: acme/standup", sentinel); !errors.Is(wrapped, sentinel) {
+		t.Error("Expected the wrapped error to match its sentinel")
+	}
+}
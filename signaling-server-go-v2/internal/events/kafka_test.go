@@ -0,0 +1,87 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/breaker"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestKafkaProducer(t *testing.T) {
+	cfg := config.KafkaConfig{Topic: "signaling", BatchSize: 10, Retries: 2, RetryBackoff: 1}
+	p := NewKafkaProducer(cfg, breaker.New("kafka_events", 5, time.Minute), 100, nil)
+
+	var mutex sync.Mutex
+	var attempts int
+	var produced []KafkaMessage
+	p.produce = func(ctx context.Context, messages []KafkaMessage) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+		if attempts == 1 {
+			return errors.New("leader not available")
+		}
+		produced = append(produced, messages...)
+		return nil
+	}
+
+	// Relay events from a signaling manager, whose payloads must not be exported
+	sm := protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithActivitySink(p))
+	noop := func(string, []byte) error { return nil }
+	joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "room-1"})
+	sm.ProcessMessage(joinJSON, "client-1", noop)
+	sm.ProcessMessage(joinJSON, "client-2", noop)
+	offerJSON, _ := json.Marshal(protocol.Message{Type: protocol.Offer, Room: "room-1", Recipient: "client-2", Payload: json.RawMessage(`{"sdp":"v=0 secret-fingerprint"}`)})
+	sm.ProcessMessage(offerJSON, "client-1", noop)
+	sm.RemoveClient("client-1")
+
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if p.Dropped() != 0 {
+		t.Errorf("Expected the failed attempt to be retried, %d events dropped", p.Dropped())
+	}
+
+	var types []string
+	for _, message := range produced {
+		if string(message.Key) != "room-1" {
+			t.Errorf("Expected events to be keyed by room, got %s", message.Key)
+		}
+		if strings.Contains(string(message.Value), "secret-fingerprint") {
+			t.Error("Expected message payloads not to be exported")
+		}
+
+		var event protocol.ActivityEvent
+		json.Unmarshal(message.Value, &event)
+		types = append(types, string(event.Type)+":"+event.Reason)
+	}
+
+	expected := "room_created:,join:,join:,relay:,leave:disconnected"
+	if strings.Join(types, ",") != expected {
+		t.Errorf("Expected events %s, got %s", expected, strings.Join(types, ","))
+	}
+}
+
+func TestKafkaProducerDropsAfterRetries(t *testing.T) {
+	cfg := config.KafkaConfig{Topic: "signaling", BatchSize: 10, Retries: 1, RetryBackoff: 1}
+	p := NewKafkaProducer(cfg, breaker.New("kafka_events", 5, time.Minute), 100, nil)
+	p.produce = func(ctx context.Context, messages []KafkaMessage) error {
+		return errors.New("broker unreachable")
+	}
+
+	p.Emit(protocol.ActivityEvent{Type: protocol.ActivityJoin, Room: "room-1", Client: "client-1"})
+	p.Close(context.Background())
+
+	if p.Dropped() != 1 {
+		t.Errorf("Expected the event to be dropped, got %d", p.Dropped())
+	}
+}
@@ -0,0 +1,79 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// webhookTimeout bounds the delivery of a webhook notification
+const webhookTimeout = 5 * time.Second
+
+// QualityEvent is the JSON body posted to the quality webhook
+type QualityEvent struct {
+	// Event is "call_quality.degraded" or "call_quality.recovered"
+	Event string `json:"event"`
+
+	protocol.CallQuality
+}
+
+// QualityWebhook posts the call quality of rooms that became degraded or
+// recovered to a URL, so that operators are alerted of bad calls as they happen
+type QualityWebhook struct {
+	url    string
+	client *http.Client
+	logger logging.Logger
+}
+
+// NewQualityWebhook creates a QualityWebhook posting to the URL
+func NewQualityWebhook(url string, logger logging.Logger) *QualityWebhook {
+	return &QualityWebhook{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		logger: logger.With("component", "quality_webhook"),
+	}
+}
+
+// Notify posts the call quality of a room in the background; it implements
+// protocol.QualityHandler. Failed notifications are logged, not retried.
+func (w *QualityWebhook) Notify(quality protocol.CallQuality) {
+	go func() {
+		if err := w.post(context.Background(), quality); err != nil {
+			w.logger.Warn("Failed to deliver call quality webhook", "error", err, "room_id", quality.Room)
+		}
+	}()
+}
+
+// post delivers the notification of the call quality of a room
+func (w *QualityWebhook) post(ctx context.Context, quality protocol.CallQuality) error {
+	event := QualityEvent{Event: "call_quality.recovered", CallQuality: quality}
+	if quality.Degraded {
+		event.Event = "call_quality.degraded"
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal call quality event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
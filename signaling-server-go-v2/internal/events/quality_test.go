@@ -0,0 +1,40 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestQualityWebhook(t *testing.T) {
+	received := make(chan QualityEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event QualityEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+
+	webhook := NewQualityWebhook(server.URL, testsupport.NewLogger())
+	if err := webhook.post(context.Background(), protocol.CallQuality{Room: "call", Score: 2.8, Degraded: true}); err != nil {
+		t.Fatalf("Failed to post webhook: %v", err)
+	}
+
+	event := <-received
+	if event.Event != "call_quality.degraded" || event.Room != "call" || event.Score != 2.8 {
+		t.Errorf("Unexpected webhook event: %+v", event)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if err := NewQualityWebhook(failing.URL, testsupport.NewLogger()).post(context.Background(), protocol.CallQuality{Room: "call"}); err == nil {
+		t.Error("Expected a failing webhook to return an error")
+	}
+}
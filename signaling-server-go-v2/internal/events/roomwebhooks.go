@@ -0,0 +1,225 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
+)
+
+// ErrRegistrationNotFound is returned when unregistering an unknown webhook
+var ErrRegistrationNotFound = errs.New(errs.NotFound, "webhook registration not found")
+
+// webhookEvents are the events a registration may subscribe to
+var webhookEvents = map[string]bool{
+	WebhookRoomCreated: true,
+	WebhookRoomEmptied: true,
+	WebhookRoomClosed:  true,
+	WebhookPeerJoined:  true,
+	WebhookPeerLeft:    true,
+}
+
+// Registration is a webhook receiving the events of the rooms matching a
+// pattern, such as a single room or "acme/**" for the rooms of a tenant
+type Registration struct {
+	ID        string    `json:"id"`
+	Rooms     string    `json:"rooms"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"` // key of the HMAC-SHA256 signatures, unsigned if empty
+	Events    []string  `json:"events,omitempty"` // events posted, all if empty
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ValidateRegistration checks the pattern, URL and events of a registration
+func ValidateRegistration(r Registration) error {
+	if r.Rooms == "" {
+		return errs.New(errs.InvalidArgument, "rooms pattern is required")
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errs.Newf(errs.InvalidArgument, "url %q is not an http or https URL", r.URL)
+	}
+	for _, event := range r.Events {
+		if !webhookEvents[event] {
+			return errs.Newf(errs.InvalidArgument, "unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// RegistrationStore persists the webhook registrations by ID
+type RegistrationStore interface {
+	Put(id string, record []byte) error
+	Delete(id string) error
+	All() (map[string][]byte, error)
+}
+
+// RoomWebhooks is an ActivitySink posting the events of rooms to the
+// webhooks registered for them through the admin API, so that integrations
+// such as a recording service only receive the events of their rooms.
+// Registrations are persisted in the store and delivered like the global
+// webhooks, each with its own buffer, circuit breaker and retries.
+type RoomWebhooks struct {
+	delivery  config.WebhooksConfig
+	exporters config.ExportersConfig
+	store     RegistrationStore
+	metrics   *metrics.Metrics
+	logger    logging.Logger
+
+	mutex sync.RWMutex
+	hooks map[string]*roomWebhook
+}
+
+// roomWebhook is a registration and the webhooks delivering its events
+type roomWebhook struct {
+	Registration
+	webhooks *Webhooks
+}
+
+// NewRoomWebhooks creates RoomWebhooks with the registrations of the store,
+// retrying deliveries by the webhooks settings and buffering and circuit
+// breaking by the exporters settings
+func NewRoomWebhooks(store RegistrationStore, delivery config.WebhooksConfig, exporters config.ExportersConfig, m *metrics.Metrics, logger logging.Logger) (*RoomWebhooks, error) {
+	rw := &RoomWebhooks{
+		delivery:  delivery,
+		exporters: exporters,
+		store:     store,
+		metrics:   m,
+		logger:    logger.With("component", "room_webhooks"),
+		hooks:     make(map[string]*roomWebhook),
+	}
+
+	records, err := store.All()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook registrations: %w", err)
+	}
+	for id, record := range records {
+		var reg Registration
+		if err := json.Unmarshal(record, &reg); err != nil {
+			return nil, fmt.Errorf("failed to decode webhook registration %s: %w", id, err)
+		}
+		rw.hooks[reg.ID] = rw.start(reg)
+	}
+	return rw, nil
+}
+
+// Register validates a registration, assigns it an ID and starts posting
+// the events of its rooms once it is stored
+func (rw *RoomWebhooks) Register(reg Registration) (Registration, error) {
+	if err := ValidateRegistration(reg); err != nil {
+		return Registration{}, err
+	}
+	reg.ID = newDeliveryID()
+	reg.CreatedAt = time.Now().UTC()
+
+	record, err := json.Marshal(reg)
+	if err != nil {
+		return Registration{}, fmt.Errorf("failed to marshal webhook registration: %w", err)
+	}
+	if err := rw.store.Put(reg.ID, record); err != nil {
+		return Registration{}, fmt.Errorf("failed to store webhook registration: %w", err)
+	}
+
+	rw.mutex.Lock()
+	rw.hooks[reg.ID] = rw.start(reg)
+	rw.mutex.Unlock()
+
+	rw.logger.Info("Registered room webhook", "id", reg.ID, "rooms", reg.Rooms, "url", reg.URL)
+	return reg, nil
+}
+
+// Unregister removes a registration. The events buffered for it are still
+// delivered, within ctx.
+func (rw *RoomWebhooks) Unregister(ctx context.Context, id string) (Registration, error) {
+	rw.mutex.Lock()
+	hook, ok := rw.hooks[id]
+	if !ok {
+		rw.mutex.Unlock()
+		return Registration{}, fmt.Errorf("%w: %s", ErrRegistrationNotFound, id)
+	}
+	if err := rw.store.Delete(id); err != nil {
+		rw.mutex.Unlock()
+		return Registration{}, fmt.Errorf("failed to delete webhook registration: %w", err)
+	}
+	delete(rw.hooks, id)
+	rw.mutex.Unlock()
+
+	if err := hook.webhooks.Close(ctx); err != nil {
+		rw.logger.Warn("Failed to deliver the buffered events of a room webhook", "error", err, "id", id)
+	}
+	rw.logger.Info("Unregistered room webhook", "id", id, "rooms", hook.Rooms, "url", hook.URL)
+	return hook.Registration, nil
+}
+
+// Get returns a registration by ID
+func (rw *RoomWebhooks) Get(id string) (Registration, bool) {
+	rw.mutex.RLock()
+	defer rw.mutex.RUnlock()
+
+	hook, ok := rw.hooks[id]
+	if !ok {
+		return Registration{}, false
+	}
+	return hook.Registration, true
+}
+
+// List returns the registrations, oldest first
+func (rw *RoomWebhooks) List() []Registration {
+	rw.mutex.RLock()
+	registrations := make([]Registration, 0, len(rw.hooks))
+	for _, hook := range rw.hooks {
+		registrations = append(registrations, hook.Registration)
+	}
+	rw.mutex.RUnlock()
+
+	sort.Slice(registrations, func(i, j int) bool {
+		if !registrations[i].CreatedAt.Equal(registrations[j].CreatedAt) {
+			return registrations[i].CreatedAt.Before(registrations[j].CreatedAt)
+		}
+		return registrations[i].ID < registrations[j].ID
+	})
+	return registrations
+}
+
+// Emit implements protocol.ActivitySink without blocking
+func (rw *RoomWebhooks) Emit(activity protocol.ActivityEvent) {
+	rw.mutex.RLock()
+	defer rw.mutex.RUnlock()
+
+	for _, hook := range rw.hooks {
+		if protocol.MatchRoomPattern(hook.Rooms, activity.Room) {
+			hook.webhooks.Emit(activity)
+		}
+	}
+}
+
+// Close delivers the buffered events of every registration and stops posting
+func (rw *RoomWebhooks) Close(ctx context.Context) error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	for _, hook := range rw.hooks {
+		if err := hook.webhooks.Close(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start creates the webhooks delivering the events of a registration
+func (rw *RoomWebhooks) start(reg Registration) *roomWebhook {
+	cfg := rw.delivery
+	cfg.URLs = []string{reg.URL}
+	cfg.Secret = reg.Secret
+	cfg.Events = reg.Events
+	return &roomWebhook{Registration: reg, webhooks: NewWebhooks(cfg, rw.exporters, rw.metrics, rw.logger)}
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// memoryStore is a RegistrationStore held in memory
+type memoryStore map[string][]byte
+
+func (s memoryStore) Put(id string, record []byte) error { s[id] = record; return nil }
+func (s memoryStore) Delete(id string) error             { delete(s, id); return nil }
+func (s memoryStore) All() (map[string][]byte, error)    { return s, nil }
+
+func TestRoomWebhooks(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(WebhookTimestampHeader)
+		if r.Header.Get(WebhookSignatureHeader) != SignWebhook("rec0rd", timestamp, body) {
+			t.Errorf("Expected a signature with the registration's secret, got %q", r.Header.Get(WebhookSignatureHeader))
+		}
+		var event WebhookEvent
+		json.Unmarshal(body, &event)
+
+		mutex.Lock()
+		received = append(received, event.Event+":"+event.Room)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	store := memoryStore{}
+	exporters := config.ExportersConfig{BufferSize: 16, FailureThreshold: 5, Cooldown: 30}
+	first, err := NewRoomWebhooks(store, config.WebhooksConfig{}, exporters, nil, testsupport.NewLogger())
+	if err != nil {
+		t.Fatalf("NewRoomWebhooks failed: %v", err)
+	}
+	reg, err := first.Register(Registration{Rooms: "acme/**", URL: server.URL, Secret: "rec0rd", Events: []string{WebhookPeerJoined}})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if reg.ID == "" || len(store) != 1 {
+		t.Errorf("Expected the registration to get an ID and be stored, got %+v", reg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	first.Close(ctx)
+
+	// Registrations are loaded from the store
+	webhooks, err := NewRoomWebhooks(store, config.WebhooksConfig{}, exporters, nil, testsupport.NewLogger())
+	if err != nil {
+		t.Fatalf("NewRoomWebhooks failed: %v", err)
+	}
+	if registrations := webhooks.List(); len(registrations) != 1 || registrations[0].ID != reg.ID {
+		t.Fatalf("Expected the stored registration, got %+v", registrations)
+	}
+
+	sm := protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithActivitySink(webhooks))
+	noop := func(string, []byte) error { return nil }
+	for _, room := range []string{"acme/standup", "globex/standup"} {
+		joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: room})
+		sm.ProcessMessage(joinJSON, "alice", noop)
+	}
+
+	if _, err := webhooks.Unregister(ctx, reg.ID); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+	if len(store) != 0 || len(webhooks.List()) != 0 {
+		t.Errorf("Expected the registration to be removed, got %d stored", len(store))
+	}
+	if _, err := webhooks.Unregister(ctx, reg.ID); !errors.Is(err, ErrRegistrationNotFound) {
+		t.Errorf("Expected ErrRegistrationNotFound, got %v", err)
+	}
+
+	// The events buffered before unregistering are delivered
+	mutex.Lock()
+	defer mutex.Unlock()
+	if expected := []string{"peer.joined:acme/standup"}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected events %v, got %v", expected, received)
+	}
+}
+
+func TestValidateRegistration(t *testing.T) {
+	tests := []struct {
+		name string
+		reg  Registration
+		ok   bool
+	}{
+		{"valid", Registration{Rooms: "standup", URL: "https://example.com/hook"}, true},
+		{"missing rooms", Registration{URL: "https://example.com/hook"}, false},
+		{"not http", Registration{Rooms: "standup", URL: "ftp://example.com/hook"}, false},
+		{"unknown event", Registration{Rooms: "standup", URL: "https://example.com/hook", Events: []string{"room.renamed"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRegistration(tt.reg); (err == nil) != tt.ok {
+				t.Errorf("Expected valid %v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/breaker"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
+)
+
+// Warehouse row events
+const (
+	WarehouseRoomCreated = "room_created"
+	WarehouseRoomClosed  = "room_closed"
+	WarehousePeerJoined  = "peer_joined"
+	WarehousePeerLeft    = "peer_left"
+	WarehouseMessages    = "messages"
+)
+
+// WarehouseRow is a row of the analytics warehouse. Room and peer events are
+// a row each, while the messages relayed in a room are counted and exported
+// as one messages row per room and export interval, at the end of the
+// interval. Rows are shipped at least once, so tables should deduplicate
+// them by ID, e.g. as the sorting key of a ClickHouse ReplacingMergeTree.
+type WarehouseRow struct {
+	ID       string    `json:"id"`
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Room     string    `json:"room"`
+	Peer     string    `json:"peer,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Messages int       `json:"messages,omitempty"`
+	Bytes    int       `json:"bytes,omitempty"` // payload bytes of the messages
+}
+
+// WarehouseRowFor maps an activity event to the row it is exported as,
+// reporting false for relays, which are counted instead, and for moderation
+// events, exported as the leave of the peer removed
+func WarehouseRowFor(activity protocol.ActivityEvent) (WarehouseRow, bool) {
+	row := WarehouseRow{Time: activity.Time, Room: activity.Room, Reason: activity.Reason}
+	switch activity.Type {
+	case protocol.ActivityRoomCreated:
+		row.Event = WarehouseRoomCreated
+		row.Peer = activity.Client
+	case protocol.ActivityRoomClosed:
+		row.Event = WarehouseRoomClosed
+	case protocol.ActivityJoin:
+		row.Event = WarehousePeerJoined
+		row.Peer = activity.Client
+	case protocol.ActivityLeave:
+		row.Event = WarehousePeerLeft
+		row.Peer = activity.Client
+	default:
+		return WarehouseRow{}, false
+	}
+	return row, true
+}
+
+// Outbox stages records on disk, in order, until they are shipped
+type Outbox interface {
+	// Append appends the records
+	Append(records [][]byte) error
+
+	// Next returns up to limit of the oldest records, and the sequence
+	// number of the last one
+	Next(limit int) (uint64, [][]byte, error)
+
+	// Ack removes the records up to the sequence number
+	Ack(through uint64) error
+
+	// Len returns the number of records staged
+	Len() int
+}
+
+// WarehouseWriter ships rows to an analytics warehouse
+type WarehouseWriter interface {
+	Write(ctx context.Context, rows []WarehouseRow) error
+}
+
+// messageCount counts the messages relayed in a room
+type messageCount struct {
+	messages int
+	bytes    int
+}
+
+// Warehouse is an ActivitySink exporting rows to an analytics warehouse, so
+// that product analytics do not tap the webhooks. Rows are collected in
+// memory, and every interval appended to the outbox and shipped from it in
+// batches through a circuit breaker. Rows stay in the outbox, across
+// restarts, until the warehouse accepts them; rows that do not fit in the
+// buffer between intervals are dropped and counted.
+type Warehouse struct {
+	outbox    Outbox
+	writer    WarehouseWriter
+	breaker   *breaker.Breaker
+	batchSize int
+	size      int
+	metrics   *metrics.Metrics
+	logger    logging.Logger
+	now       func() time.Time
+
+	mutex  sync.Mutex
+	rows   []WarehouseRow
+	counts map[string]*messageCount
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewWarehouse creates a Warehouse shipping through the writer every interval
+// of the configuration, buffering up to size rows in memory between intervals
+func NewWarehouse(cfg config.WarehouseConfig, outbox Outbox, writer WarehouseWriter, b *breaker.Breaker, size int, m *metrics.Metrics, logger logging.Logger) *Warehouse {
+	w := &Warehouse{
+		outbox:    outbox,
+		writer:    writer,
+		breaker:   b,
+		batchSize: cfg.BatchSize,
+		size:      size,
+		metrics:   m,
+		logger:    logger.With("component", "warehouse"),
+		now:       time.Now,
+		counts:    make(map[string]*messageCount),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go w.run(time.Duration(cfg.Interval) * time.Second)
+	return w
+}
+
+// Emit implements protocol.ActivitySink without blocking
+func (w *Warehouse) Emit(activity protocol.ActivityEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if activity.Type == protocol.ActivityRelay {
+		count, ok := w.counts[activity.Room]
+		if !ok {
+			count = &messageCount{}
+			w.counts[activity.Room] = count
+		}
+		count.messages++
+		count.bytes += activity.PayloadSize
+		return
+	}
+
+	row, ok := WarehouseRowFor(activity)
+	if !ok {
+		return
+	}
+	if len(w.rows) >= w.size {
+		if w.metrics != nil {
+			w.metrics.EventsDropped("warehouse", 1)
+		}
+		return
+	}
+	row.ID = newDeliveryID()
+	w.rows = append(w.rows, row)
+}
+
+// Close stages the rows collected so far and ships the outbox a last time,
+// until the context is done. Rows left in the outbox are shipped after the
+// next start.
+func (w *Warehouse) Close(ctx context.Context) error {
+	close(w.stop)
+	<-w.stopped
+	return w.export(ctx)
+}
+
+// run exports every interval until the Warehouse is closed
+func (w *Warehouse) run(interval time.Duration) {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := w.export(ctx); err != nil {
+				w.logger.Warn("Failed to export to the warehouse, retrying next interval", "error", err, "backlog", w.outbox.Len())
+			}
+			cancel()
+		}
+	}
+}
+
+// export stages the collected rows in the outbox, then ships the outbox
+func (w *Warehouse) export(ctx context.Context) error {
+	if err := w.stage(); err != nil {
+		return err
+	}
+	return w.ship(ctx)
+}
+
+// stage appends the rows collected since the last interval to the outbox,
+// with a messages row per room messages were relayed in, sorted by room.
+// Rows that cannot be staged are dropped and counted.
+func (w *Warehouse) stage() error {
+	w.mutex.Lock()
+	rows := w.rows
+	counts := w.counts
+	w.rows = nil
+	w.counts = make(map[string]*messageCount)
+	w.mutex.Unlock()
+
+	end := w.now()
+	rooms := make([]string, 0, len(counts))
+	for roomID := range counts {
+		rooms = append(rooms, roomID)
+	}
+	sort.Strings(rooms)
+	for _, roomID := range rooms {
+		rows = append(rows, WarehouseRow{
+			ID:       newDeliveryID(),
+			Event:    WarehouseMessages,
+			Time:     end,
+			Room:     roomID,
+			Messages: counts[roomID].messages,
+			Bytes:    counts[roomID].bytes,
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	records := make([][]byte, 0, len(rows))
+	for _, row := range rows {
+		record, err := json.Marshal(row)
+		if err != nil {
+			return fmt.Errorf("failed to encode warehouse row: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := w.outbox.Append(records); err != nil {
+		if w.metrics != nil {
+			w.metrics.EventsDropped("warehouse", len(rows))
+		}
+		return fmt.Errorf("failed to stage %d warehouse rows: %w", len(rows), err)
+	}
+	return nil
+}
+
+// ship writes the outbox to the warehouse in batches, oldest first, removing
+// each batch once written. It stops at the first batch that fails, which
+// stays in the outbox for the next interval.
+func (w *Warehouse) ship(ctx context.Context) error {
+	for {
+		through, records, err := w.outbox.Next(w.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to read the warehouse outbox: %w", err)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		rows := make([]WarehouseRow, 0, len(records))
+		for _, record := range records {
+			var row WarehouseRow
+			if err := json.Unmarshal(record, &row); err != nil {
+				w.logger.Warn("Discarding malformed warehouse row", "error", err)
+				continue
+			}
+			rows = append(rows, row)
+		}
+		if err := w.breaker.Do(func() error { return w.writer.Write(ctx, rows) }); err != nil {
+			return fmt.Errorf("failed to write %d rows to the warehouse: %w", len(rows), err)
+		}
+		if err := w.outbox.Ack(through); err != nil {
+			return fmt.Errorf("failed to remove shipped rows from the warehouse outbox: %w", err)
+		}
+		if w.metrics != nil {
+			w.metrics.WarehouseExported(len(rows), w.outbox.Len())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/breaker"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// memoryOutbox is an in-memory Outbox
+type memoryOutbox struct {
+	records [][]byte
+	acked   uint64
+}
+
+func (o *memoryOutbox) Append(records [][]byte) error {
+	o.records = append(o.records, records...)
+	return nil
+}
+
+func (o *memoryOutbox) Next(limit int) (uint64, [][]byte, error) {
+	pending := o.records[o.acked:]
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return o.acked + uint64(len(pending)), pending, nil
+}
+
+func (o *memoryOutbox) Ack(through uint64) error {
+	o.acked = through
+	return nil
+}
+
+func (o *memoryOutbox) Len() int {
+	return len(o.records) - int(o.acked)
+}
+
+// warehouseRecorder is a WarehouseWriter recording the batches written,
+// failing while fail is set
+type warehouseRecorder struct {
+	batches [][]WarehouseRow
+	fail    bool
+}
+
+func (w *warehouseRecorder) Write(ctx context.Context, rows []WarehouseRow) error {
+	if w.fail {
+		return errors.New("warehouse unavailable")
+	}
+	w.batches = append(w.batches, rows)
+	return nil
+}
+
+func TestWarehouse(t *testing.T) {
+	outbox, writer := &memoryOutbox{}, &warehouseRecorder{fail: true}
+	cfg := config.WarehouseConfig{Interval: 3600, BatchSize: 3}
+	w := NewWarehouse(cfg, outbox, writer, breaker.New("warehouse", 5, time.Minute), 100, nil, testsupport.NewLogger())
+
+	sm := protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithActivitySink(w))
+	noop := func(string, []byte) error { return nil }
+	joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "room-1"})
+	sm.ProcessMessage(joinJSON, "client-1", noop)
+	sm.ProcessMessage(joinJSON, "client-2", noop)
+	for i := 0; i < 2; i++ {
+		offerJSON, _ := json.Marshal(protocol.Message{Type: protocol.Offer, Room: "room-1", Recipient: "client-2", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+		sm.ProcessMessage(offerJSON, "client-1", noop)
+	}
+
+	// Rows stay in the outbox while the warehouse fails
+	if err := w.export(context.Background()); err == nil {
+		t.Fatal("Expected the export to fail")
+	}
+	if outbox.Len() != 4 {
+		t.Fatalf("Expected 4 rows staged, got %d", outbox.Len())
+	}
+
+	writer.fail = false
+	sm.RemoveClient("client-1")
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if outbox.Len() != 0 || len(writer.batches) != 2 {
+		t.Fatalf("Expected the outbox shipped in 2 batches, got %d rows left and %d batches", outbox.Len(), len(writer.batches))
+	}
+
+	var events []string
+	for _, batch := range writer.batches {
+		for _, row := range batch {
+			events = append(events, row.Event)
+			if row.ID == "" || row.Room != "room-1" {
+				t.Errorf("Expected rows of room-1 with IDs, got %+v", row)
+			}
+			if row.Event == WarehouseMessages && (row.Messages != 2 || row.Bytes == 0) {
+				t.Errorf("Expected 2 messages counted with their size, got %+v", row)
+			}
+		}
+	}
+	expected := []string{WarehouseRoomCreated, WarehousePeerJoined, WarehousePeerJoined, WarehouseMessages, WarehousePeerLeft}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected rows %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected rows %v, got %v", expected, events)
+			break
+		}
+	}
+}
+
+func TestClickHouseWriter(t *testing.T) {
+	var query string
+	var rows []WarehouseRow
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "analytics" || password != "s3cret" {
+			http.Error(w, "Authentication failed", http.StatusUnauthorized)
+			return
+		}
+		query = r.URL.Query().Get("query")
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var row WarehouseRow
+			json.Unmarshal(scanner.Bytes(), &row)
+			rows = append(rows, row)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.ClickHouseConfig{URL: server.URL + "/", Table: "signaling.activity", Username: "analytics", Password: "s3cret"}
+	batch := []WarehouseRow{
+		{ID: "1", Event: WarehousePeerJoined, Room: "room-1", Peer: "alice"},
+		{ID: "2", Event: WarehouseMessages, Room: "room-1", Messages: 3, Bytes: 120},
+	}
+	if err := NewClickHouseWriter(cfg).Write(context.Background(), batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if query != "INSERT INTO signaling.activity FORMAT JSONEachRow" || len(rows) != 2 || rows[1].Messages != 3 {
+		t.Errorf("Expected the rows inserted as JSONEachRow, got %q %+v", query, rows)
+	}
+
+	cfg.Password = "wrong"
+	if err := NewClickHouseWriter(cfg).Write(context.Background(), batch); err == nil {
+		t.Error("Expected a rejected insert to fail")
+	}
+}
@@ -0,0 +1,147 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// warehouseTimeout bounds a single write of rows to the warehouse
+const warehouseTimeout = 30 * time.Second
+
+// NewWarehouseWriter creates the writer of the configured warehouse backend
+func NewWarehouseWriter(cfg config.WarehouseConfig) (WarehouseWriter, error) {
+	switch cfg.Backend {
+	case "clickhouse":
+		return NewClickHouseWriter(cfg.ClickHouse), nil
+	case "bigquery":
+		return NewBigQueryWriter(cfg.BigQuery), nil
+	case "s3":
+		return NewS3ParquetWriter(cfg.S3), nil
+	default:
+		return nil, fmt.Errorf("unknown warehouse backend: %s", cfg.Backend)
+	}
+}
+
+// ClickHouseWriter inserts rows into a ClickHouse table over its HTTP
+// interface, as JSONEachRow
+type ClickHouseWriter struct {
+	url      string
+	table    string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewClickHouseWriter creates a ClickHouseWriter for the table in the configuration
+func NewClickHouseWriter(cfg config.ClickHouseConfig) *ClickHouseWriter {
+	return &ClickHouseWriter{
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+		table:    cfg.Table,
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   &http.Client{Timeout: warehouseTimeout},
+	}
+}
+
+// Write implements WarehouseWriter.Write in a single INSERT
+func (w *ClickHouseWriter) Write(ctx context.Context, rows []WarehouseRow) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return fmt.Errorf("failed to encode warehouse row: %w", err)
+		}
+	}
+
+	// Times are RFC 3339, which DateTime64 columns only parse best effort
+	query := url.Values{
+		"query":                  {"INSERT INTO " + w.table + " FORMAT JSONEachRow"},
+		"date_time_input_format": {"best_effort"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url+"/?"+query.Encode(), &body)
+	if err != nil {
+		return fmt.Errorf("failed to create ClickHouse request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to insert into ClickHouse: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ClickHouse returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// BigQueryWriter is a simplified WarehouseWriter streaming rows into a
+// BigQuery table
+type BigQueryWriter struct {
+	project         string
+	dataset         string
+	table           string
+	credentialsFile string
+}
+
+// NewBigQueryWriter creates a BigQueryWriter for the table in the configuration
+func NewBigQueryWriter(cfg config.BigQueryConfig) *BigQueryWriter {
+	// In a real implementation, this would create a BigQuery client for the
+	// project, authenticated with the credentials file or the application
+	// default credentials
+	return &BigQueryWriter{
+		project:         cfg.Project,
+		dataset:         cfg.Dataset,
+		table:           cfg.Table,
+		credentialsFile: cfg.CredentialsFile,
+	}
+}
+
+// Write implements WarehouseWriter.Write
+func (w *BigQueryWriter) Write(ctx context.Context, rows []WarehouseRow) error {
+	// In a real implementation, this would stream the rows with
+	// tabledata.insertAll, passing each row's ID as its insertId so that
+	// BigQuery drops the rows of a batch shipped twice
+	return nil
+}
+
+// S3ParquetWriter is a simplified WarehouseWriter writing each batch of rows
+// as a Parquet file to an S3 bucket, partitioned by date, for warehouses
+// loading from object storage such as Athena or Snowflake
+type S3ParquetWriter struct {
+	bucket string
+	prefix string
+	region string
+}
+
+// NewS3ParquetWriter creates an S3ParquetWriter for the bucket in the configuration
+func NewS3ParquetWriter(cfg config.S3Config) *S3ParquetWriter {
+	// In a real implementation, this would create an S3 client for the
+	// region with the default credential chain
+	return &S3ParquetWriter{
+		bucket: cfg.Bucket,
+		prefix: strings.TrimSuffix(cfg.Prefix, "/"),
+		region: cfg.Region,
+	}
+}
+
+// Write implements WarehouseWriter.Write
+func (w *S3ParquetWriter) Write(ctx context.Context, rows []WarehouseRow) error {
+	// In a real implementation, this would encode the rows as a Parquet file
+	// and put it at <prefix>/dt=<date of the first row>/<ID of the first
+	// row>.parquet, so that a batch shipped twice overwrites the same file
+	return nil
+}
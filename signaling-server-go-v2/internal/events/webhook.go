@@ -0,0 +1,276 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/breaker"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
+)
+
+// Webhook event names
+const (
+	WebhookRoomCreated = "room.created"
+	WebhookRoomEmptied = "room.emptied"
+	WebhookRoomClosed  = "room.closed"
+	WebhookPeerJoined  = "peer.joined"
+	WebhookPeerLeft    = "peer.left"
+)
+
+// Headers of webhook requests. The signature is "sha256=" followed by the
+// hex HMAC-SHA256, keyed by the secret, of the timestamp, a dot and the body;
+// receivers should reject requests whose timestamp is too old.
+const (
+	WebhookEventHeader     = "X-Signaling-Event"
+	WebhookDeliveryHeader  = "X-Signaling-Delivery"
+	WebhookTimestampHeader = "X-Signaling-Timestamp"
+	WebhookSignatureHeader = "X-Signaling-Signature"
+)
+
+// WebhookEvent is the JSON body posted to the webhooks. Failed deliveries are
+// retried, so receivers may get an event more than once and should ignore
+// the IDs they have seen.
+type WebhookEvent struct {
+	ID     string    `json:"id"`
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Room   string    `json:"room"`
+	Peer   string    `json:"peer,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// WebhookEventFor maps an activity event to the webhook event it is posted
+// as, reporting false for activity not posted to webhooks such as relays.
+// Rooms closing because their last peer left are emptied, rooms closing for
+// any other reason closed.
+func WebhookEventFor(activity protocol.ActivityEvent) (WebhookEvent, bool) {
+	event := WebhookEvent{Time: activity.Time, Room: activity.Room, Reason: activity.Reason}
+	switch activity.Type {
+	case protocol.ActivityRoomCreated:
+		event.Event = WebhookRoomCreated
+		event.Peer = activity.Client
+	case protocol.ActivityRoomClosed:
+		event.Event = WebhookRoomClosed
+		if activity.Reason == "empty" {
+			event.Event = WebhookRoomEmptied
+			event.Reason = ""
+		}
+	case protocol.ActivityJoin:
+		event.Event = WebhookPeerJoined
+		event.Peer = activity.Client
+	case protocol.ActivityLeave:
+		event.Event = WebhookPeerLeft
+		event.Peer = activity.Client
+	default:
+		return WebhookEvent{}, false
+	}
+	return event, true
+}
+
+// SignWebhook returns the signature of a webhook body sent at timestamp, in
+// unix seconds, as sent in the WebhookSignatureHeader
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhooks is an ActivitySink posting room and peer events to the configured
+// URLs asynchronously. Each URL has its own buffer and circuit breaker, so a
+// slow or unreachable receiver does not delay the others; failed deliveries
+// are retried with an exponential backoff, and events that do not fit in the
+// buffer or fail after all retries are dropped and counted.
+type Webhooks struct {
+	secret          string
+	events          map[string]bool
+	retries         int
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+	client          *http.Client
+	queues          []*breaker.Queue[WebhookEvent]
+	metrics         *metrics.Metrics
+	logger          logging.Logger
+}
+
+// NewWebhooks creates Webhooks posting to the URLs of the configuration,
+// buffering and circuit breaking by the exporters settings
+func NewWebhooks(cfg config.WebhooksConfig, exporters config.ExportersConfig, m *metrics.Metrics, logger logging.Logger) *Webhooks {
+	w := &Webhooks{
+		secret:          cfg.Secret,
+		retries:         cfg.Retries,
+		retryBackoff:    time.Duration(cfg.RetryBackoff) * time.Millisecond,
+		maxRetryBackoff: time.Duration(cfg.MaxRetryBackoff) * time.Millisecond,
+		client:          &http.Client{Timeout: webhookTimeout},
+		metrics:         m,
+		logger:          logger.With("component", "webhooks"),
+	}
+	if len(cfg.Events) > 0 {
+		w.events = make(map[string]bool, len(cfg.Events))
+		for _, event := range cfg.Events {
+			w.events[event] = true
+		}
+	}
+
+	cooldown := time.Duration(exporters.Cooldown) * time.Second
+	for _, url := range cfg.URLs {
+		url := url
+		b := breaker.New("webhook "+url, exporters.FailureThreshold, cooldown)
+		export := func(ctx context.Context, events []WebhookEvent) error {
+			for _, event := range events {
+				if err := w.deliver(ctx, url, event); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		w.queues = append(w.queues, breaker.NewQueue(exporters.BufferSize, 1, w.deliveryTimeout(), b, export,
+			breaker.WithDropHandler(func(count int) {
+				if m != nil {
+					m.EventsDropped("webhook", count)
+				}
+			}),
+		))
+	}
+	return w
+}
+
+// Emit implements protocol.ActivitySink without blocking
+func (w *Webhooks) Emit(activity protocol.ActivityEvent) {
+	event, ok := WebhookEventFor(activity)
+	if !ok || (w.events != nil && !w.events[event.Event]) {
+		return
+	}
+	event.ID = newDeliveryID()
+	for _, queue := range w.queues {
+		queue.Push(event)
+	}
+}
+
+// Dropped returns the number of deliveries dropped so far, across all URLs
+func (w *Webhooks) Dropped() int64 {
+	var dropped int64
+	for _, queue := range w.queues {
+		dropped += queue.Dropped()
+	}
+	return dropped
+}
+
+// Close delivers the buffered events and stops posting
+func (w *Webhooks) Close(ctx context.Context) error {
+	for _, queue := range w.queues {
+		if err := queue.Close(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliveryTimeout bounds the delivery of an event, including its retries
+func (w *Webhooks) deliveryTimeout() time.Duration {
+	timeout := webhookTimeout
+	for attempt := 1; attempt <= w.retries; attempt++ {
+		timeout += webhookTimeout + w.backoff(attempt)
+	}
+	return timeout
+}
+
+// backoff returns the wait before a retry, doubling from the retry backoff
+// up to the maximum
+func (w *Webhooks) backoff(attempt int) time.Duration {
+	backoff := w.retryBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if w.maxRetryBackoff > 0 && backoff >= w.maxRetryBackoff {
+			return w.maxRetryBackoff
+		}
+	}
+	return backoff
+}
+
+// deliver posts an event to a URL, retrying failed posts other than those
+// the receiver rejected as a client error
+func (w *Webhooks) deliver(ctx context.Context, url string, event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	start := time.Now()
+	attempts, err := w.attempt(ctx, url, event, body)
+
+	outcome := "delivered"
+	if err != nil {
+		outcome = "failed"
+		w.logger.Warn("Failed to deliver webhook", "error", err, "event", event.Event, "room_id", event.Room, "attempts", attempts)
+	}
+	if w.metrics != nil {
+		w.metrics.WebhookDelivered(event.Event, outcome, attempts, time.Since(start))
+	}
+	return err
+}
+
+// attempt posts an event until it is delivered, rejected or out of retries,
+// returning the number of attempts made
+func (w *Webhooks) attempt(ctx context.Context, url string, event WebhookEvent, body []byte) (int, error) {
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, url, event, body)
+		if err == nil || !retry || attempt > w.retries {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%w after: %v", ctx.Err(), err)
+		case <-time.After(w.backoff(attempt)):
+		}
+	}
+}
+
+// post makes a single delivery attempt, reporting whether a failure is worth
+// retrying
+func (w *Webhooks) post(ctx context.Context, url string, event WebhookEvent, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.Event)
+	req.Header.Set(WebhookDeliveryHeader, event.ID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if w.secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(w.secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// newDeliveryID returns a random ID identifying an event across its retries,
+// also used to identify room webhook registrations and warehouse rows
+func newDeliveryID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,129 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestWebhooks(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(WebhookTimestampHeader)
+		if r.Header.Get(WebhookSignatureHeader) != SignWebhook("s3cret", timestamp, body) {
+			t.Errorf("Expected a valid signature, got %q", r.Header.Get(WebhookSignatureHeader))
+		}
+		var event WebhookEvent
+		json.Unmarshal(body, &event)
+		if event.ID == "" || r.Header.Get(WebhookDeliveryHeader) != event.ID || r.Header.Get(WebhookEventHeader) != event.Event {
+			t.Errorf("Expected the headers to identify the event, got %v for %+v", r.Header, event)
+		}
+
+		mutex.Lock()
+		received = append(received, event.Event+":"+event.Room+":"+event.Peer)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks(config.WebhooksConfig{URLs: []string{server.URL}, Secret: "s3cret"},
+		config.ExportersConfig{BufferSize: 16, FailureThreshold: 5, Cooldown: 30}, nil, testsupport.NewLogger())
+	sm := protocol.NewSignalingManager(testsupport.NewLogger(), protocol.WithActivitySink(webhooks))
+	noop := func(string, []byte) error { return nil }
+
+	joinJSON, _ := json.Marshal(protocol.Message{Type: protocol.Join, Room: "standup"})
+	leaveJSON, _ := json.Marshal(protocol.Message{Type: protocol.Leave, Room: "standup"})
+	sm.ProcessMessage(joinJSON, "alice", noop)
+	sm.ProcessMessage(leaveJSON, "alice", noop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := webhooks.Close(ctx); err != nil {
+		t.Fatalf("Failed to close webhooks: %v", err)
+	}
+
+	expected := []string{
+		"room.created:standup:alice",
+		"peer.joined:standup:alice",
+		"peer.left:standup:alice",
+		"room.emptied:standup:",
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected events %v, got %v", expected, received)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var mutex sync.Mutex
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	var attempts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts = append(attempts, r.Header.Get(WebhookDeliveryHeader))
+		if len(attempts) <= len(statuses) {
+			w.WriteHeader(statuses[len(attempts)-1])
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks(config.WebhooksConfig{URLs: []string{server.URL}, Retries: 3, RetryBackoff: 1, MaxRetryBackoff: 2},
+		config.ExportersConfig{BufferSize: 16, FailureThreshold: 5, Cooldown: 30}, nil, testsupport.NewLogger())
+	event := WebhookEvent{ID: "delivery-1", Event: WebhookPeerJoined, Room: "standup", Peer: "alice"}
+
+	if err := webhooks.deliver(context.Background(), server.URL, event); err != nil {
+		t.Fatalf("Expected the delivery to succeed after retries: %v", err)
+	}
+	if len(attempts) != 3 || attempts[0] != "delivery-1" || attempts[2] != "delivery-1" {
+		t.Errorf("Expected 3 attempts of the same delivery, got %v", attempts)
+	}
+
+	// Client errors are not retried
+	if err := webhooks.deliver(context.Background(), server.URL, event); err == nil {
+		t.Error("Expected a rejected delivery to fail")
+	}
+	if len(attempts) != 4 {
+		t.Errorf("Expected a rejected delivery not to be retried, got %d attempts", len(attempts))
+	}
+
+	backoffs := []time.Duration{webhooks.backoff(1), webhooks.backoff(2), webhooks.backoff(3)}
+	if !reflect.DeepEqual(backoffs, []time.Duration{time.Millisecond, 2 * time.Millisecond, 2 * time.Millisecond}) {
+		t.Errorf("Expected the backoff to double up to the maximum, got %v", backoffs)
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		received = append(received, r.Header.Get(WebhookEventHeader))
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks(config.WebhooksConfig{URLs: []string{server.URL}, Events: []string{WebhookRoomClosed}},
+		config.ExportersConfig{BufferSize: 16, FailureThreshold: 5, Cooldown: 30}, nil, testsupport.NewLogger())
+	webhooks.Emit(protocol.ActivityEvent{Type: protocol.ActivityJoin, Room: "standup", Client: "alice"})
+	webhooks.Emit(protocol.ActivityEvent{Type: protocol.ActivityRelay, Room: "standup", Client: "alice"})
+	webhooks.Emit(protocol.ActivityEvent{Type: protocol.ActivityRoomClosed, Room: "standup", Reason: "empty"})
+	webhooks.Emit(protocol.ActivityEvent{Type: protocol.ActivityRoomClosed, Room: "standup", Reason: "expired"})
+	webhooks.Close(context.Background())
+
+	if !reflect.DeepEqual(received, []string{WebhookRoomClosed}) {
+		t.Errorf("Expected only the expired room to be posted as closed, got %v", received)
+	}
+}
@@ -0,0 +1,53 @@
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestResolverReload(t *testing.T) {
+	dir := t.TempDir()
+	countryPath := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(countryPath, []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewResolver(config.GeoIPConfig{CountryDatabasePath: countryPath}, testsupport.NewLogger())
+	if err != nil {
+		t.Fatalf("NewResolver failed: %v", err)
+	}
+	if loc := r.Lookup("192.0.2.1"); loc.Country != UnknownCountry {
+		t.Errorf("Expected an unlocated IP to have the unknown country, got %q", loc.Country)
+	}
+
+	if n := r.Reload(); n != 0 {
+		t.Errorf("Expected an unchanged database not to be reloaded, got %d", n)
+	}
+
+	// geoipupdate replaces the file
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(countryPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.Reload(); n != 1 {
+		t.Errorf("Expected the replaced database to be reloaded, got %d", n)
+	}
+
+	// A database removed mid-update keeps being used
+	os.Remove(countryPath)
+	if n := r.Reload(); n != 0 {
+		t.Errorf("Expected a missing database not to be reloaded, got %d", n)
+	}
+}
+
+func TestResolverMissingDatabase(t *testing.T) {
+	cfg := config.GeoIPConfig{ASNDatabasePath: filepath.Join(t.TempDir(), "missing.mmdb")}
+	if _, err := NewResolver(cfg, testsupport.NewLogger()); err == nil {
+		t.Error("Expected an error for a missing database")
+	}
+}
@@ -0,0 +1,100 @@
+// Package ice provides the STUN and TURN servers clients use to build their
+// peer connections, with time-limited TURN credentials derived from a secret
+// shared with the TURN server, following the TURN REST API scheme of RFC 7635
+// deployments: the username is the expiry time, optionally followed by a
+// user label, and the credential is its HMAC-SHA1 under the shared secret.
+package ice
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// Server is an ICE server in the form of the WebRTC RTCIceServer dictionary
+type Server struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Provider issues the configured ICE servers with fresh TURN credentials
+type Provider struct {
+	stun   []string
+	turn   []string
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// Option configures a Provider
+type Option func(*Provider)
+
+// WithClock sets the time source used for credential expiry
+func WithClock(now func() time.Time) Option {
+	return func(p *Provider) {
+		p.now = now
+	}
+}
+
+// NewProvider creates a Provider for the servers in the configuration. TURN
+// servers are only issued when a shared secret is configured.
+func NewProvider(cfg config.ICEConfig, opts ...Option) *Provider {
+	p := &Provider{
+		stun: cfg.STUNURLs,
+		ttl:  time.Duration(cfg.CredentialTTL) * time.Second,
+		now:  time.Now,
+	}
+	if cfg.TURNSecret != "" {
+		p.turn = cfg.TURNURLs
+		p.secret = []byte(cfg.TURNSecret)
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Enabled reports whether any server is configured
+func (p *Provider) Enabled() bool {
+	return len(p.stun) > 0 || len(p.turn) > 0
+}
+
+// TTL returns how long issued TURN credentials are valid
+func (p *Provider) TTL() time.Duration {
+	return p.ttl
+}
+
+// Servers returns the ICE servers, with TURN credentials valid for the TTL.
+// The user labels the credentials in the TURN server's logs and may be empty.
+func (p *Provider) Servers(user string) []Server {
+	servers := make([]Server, 0, 2)
+	if len(p.stun) > 0 {
+		servers = append(servers, Server{URLs: p.stun})
+	}
+	if len(p.turn) > 0 {
+		username := strconv.FormatInt(p.now().Add(p.ttl).Unix(), 10)
+		if user != "" {
+			username += ":" + user
+		}
+		servers = append(servers, Server{
+			URLs:       p.turn,
+			Username:   username,
+			Credential: Credential(p.secret, username),
+		})
+	}
+	return servers
+}
+
+// Credential returns the TURN credential of a username under the shared secret
+func Credential(secret []byte, username string) string {
+	mac := hmac.New(sha1.New, secret)
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,53 @@
+package ice
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+func TestServers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := NewProvider(config.ICEConfig{
+		STUNURLs:      []string{"stun:stun.example.com:3478"},
+		TURNURLs:      []string{"turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:5349"},
+		TURNSecret:    "north",
+		CredentialTTL: 3600,
+	}, WithClock(func() time.Time { return now }))
+
+	servers := p.Servers("alice")
+	if len(servers) != 2 {
+		t.Fatalf("Expected a STUN and a TURN server, got %+v", servers)
+	}
+	if !reflect.DeepEqual(servers[0], Server{URLs: []string{"stun:stun.example.com:3478"}}) {
+		t.Errorf("Expected the STUN server without credentials, got %+v", servers[0])
+	}
+
+	turn := servers[1]
+	if turn.Username != "1700003600:alice" {
+		t.Errorf("Expected the username to carry the expiry and user, got %q", turn.Username)
+	}
+	if turn.Credential != "wjwSXO2ch1B6VaLTLMy2Avn5O9o=" {
+		t.Errorf("Unexpected credential %q", turn.Credential)
+	}
+
+	if anonymous := p.Servers(""); anonymous[1].Username != "1700003600" {
+		t.Errorf("Expected an anonymous username to be the expiry alone, got %q", anonymous[1].Username)
+	}
+}
+
+func TestCredential(t *testing.T) {
+	// HMAC-SHA1 test case 2 of RFC 2202
+	if got := Credential([]byte("Jefe"), "what do ya want for nothing?"); got != "7/zfauXrL6LSdBbV8YTfnCWafHk=" {
+		t.Errorf("Unexpected credential %q", got)
+	}
+}
+
+func TestTURNRequiresSecret(t *testing.T) {
+	p := NewProvider(config.ICEConfig{TURNURLs: []string{"turn:turn.example.com"}})
+	if p.Enabled() || len(p.Servers("")) != 0 {
+		t.Error("Expected TURN servers without a shared secret not to be issued")
+	}
+}
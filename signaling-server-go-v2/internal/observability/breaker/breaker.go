@@ -0,0 +1,160 @@
+// Package breaker keeps unreachable observability backends from slowing down
+// message processing: a circuit breaker stops calling a failing exporter for
+// a cooldown period, and a bounded queue decouples callers from exports.
+package breaker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// ErrOpen is returned by Breaker.Do while the breaker is open
+var ErrOpen = errs.New(errs.Unavailable, "circuit breaker open")
+
+// State is the state of a circuit breaker
+type State string
+
+const (
+	// StateClosed passes calls through
+	StateClosed State = "closed"
+
+	// StateOpen rejects calls until the cooldown has passed
+	StateOpen State = "open"
+
+	// StateHalfOpen lets a single trial call through after the cooldown
+	StateHalfOpen State = "half-open"
+)
+
+// Breaker is a circuit breaker that opens after a number of consecutive
+// failures and lets a trial call through once its cooldown has passed
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex    sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	lastErr  error
+}
+
+// Option configures a Breaker
+type Option func(*Breaker)
+
+// WithClock sets the time source of the Breaker
+func WithClock(now func() time.Time) Option {
+	return func(b *Breaker) {
+		b.now = now
+	}
+}
+
+// New creates a closed Breaker that opens after threshold consecutive failures
+func New(name string, threshold int, cooldown time.Duration, opts ...Option) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	b := &Breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     StateClosed,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Name returns the name of the protected backend
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Do calls fn unless the breaker is open, recording its outcome
+func (b *Breaker) Do(fn func() error) error {
+	if !b.allow() {
+		return ErrOpen
+	}
+
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// State returns the current state and the last failure, if any
+func (b *Breaker) State() (State, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state, b.lastErr
+}
+
+// allow reports whether a call may proceed, moving an open breaker whose
+// cooldown has passed to half-open for a single trial call
+func (b *Breaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case StateClosed:
+		return true
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = StateHalfOpen
+		return true
+	default:
+		// A trial call is already in flight
+		return false
+	}
+}
+
+// record updates the state after a call
+func (b *Breaker) record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil {
+		b.state = StateClosed
+		b.failures = 0
+		b.lastErr = nil
+		return
+	}
+
+	b.lastErr = err
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.state = StateOpen
+		b.openedAt = b.now()
+	}
+}
+
+// Summary reports whether any of the breakers is not closed, with a message
+// listing their states, e.g. for a health check
+func Summary(breakers ...*Breaker) (degraded bool, message string) {
+	for _, b := range breakers {
+		state, err := b.State()
+		if state == StateClosed {
+			continue
+		}
+
+		degraded = true
+		if message != "" {
+			message += "; "
+		}
+		message += fmt.Sprintf("%s %s", b.name, state)
+		if err != nil {
+			message += fmt.Sprintf(": %v", err)
+		}
+	}
+	return degraded, message
+}
@@ -0,0 +1,118 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := New("collector", 2, time.Minute, WithClock(func() time.Time { return now }))
+	failing := func() error { return errors.New("connection refused") }
+
+	b.Do(failing)
+	if state, _ := b.State(); state != StateClosed {
+		t.Fatalf("Expected the breaker to stay closed below the threshold, got %s", state)
+	}
+	b.Do(failing)
+	if state, _ := b.State(); state != StateOpen {
+		t.Fatalf("Expected the breaker to open at the threshold, got %s", state)
+	}
+
+	called := false
+	if err := b.Do(func() error { called = true; return nil }); err != ErrOpen || called {
+		t.Errorf("Expected calls to be rejected while open, got %v", err)
+	}
+
+	// After the cooldown a failed trial call reopens the breaker
+	now = now.Add(time.Minute)
+	b.Do(failing)
+	if state, _ := b.State(); state != StateOpen {
+		t.Errorf("Expected a failed trial to reopen the breaker, got %s", state)
+	}
+
+	// A successful trial call closes it
+	now = now.Add(time.Minute)
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Errorf("Expected the trial call to pass, got %v", err)
+	}
+	if state, _ := b.State(); state != StateClosed {
+		t.Errorf("Expected a successful trial to close the breaker, got %s", state)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	closed := New("logs", 1, time.Minute)
+	open := New("traces", 1, time.Minute)
+	open.Do(func() error { return errors.New("timeout") })
+
+	if degraded, _ := Summary(closed); degraded {
+		t.Error("Expected closed breakers not to be degraded")
+	}
+	degraded, message := Summary(closed, open)
+	if !degraded || !strings.Contains(message, "traces open: timeout") {
+		t.Errorf("Expected the open breaker to be reported, got %v %q", degraded, message)
+	}
+}
+
+func TestQueueDropsWhenFull(t *testing.T) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	exported := make(chan []int, 10)
+	q := NewQueue(2, 10, time.Second, New("test", 1, time.Minute), func(ctx context.Context, items []int) error {
+		started <- struct{}{}
+		<-release
+		exported <- items
+		return nil
+	})
+
+	// The first item is taken by the blocked export, two more fill the buffer
+	q.Push(1)
+	<-started
+	q.Push(2)
+	q.Push(3)
+	if q.Push(4) {
+		t.Error("Expected a push to a full buffer to be dropped")
+	}
+	if q.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped item, got %d", q.Dropped())
+	}
+
+	close(release)
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("Unexpected error closing the queue: %v", err)
+	}
+	close(exported)
+
+	count := 0
+	for items := range exported {
+		count += len(items)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 exported items, got %d", count)
+	}
+}
+
+func TestQueueDropsWhileBreakerOpen(t *testing.T) {
+	b := New("test", 1, time.Hour)
+	b.Do(func() error { return errors.New("unreachable") })
+
+	reported := 0
+	q := NewQueue(10, 10, time.Second, b, func(ctx context.Context, items []int) error {
+		t.Error("Expected no export while the breaker is open")
+		return nil
+	}, WithDropHandler(func(count int) { reported += count }))
+	q.Push(1)
+	q.Push(2)
+	q.Close(context.Background())
+
+	if q.Dropped() != 2 {
+		t.Errorf("Expected the items to be dropped, got %d", q.Dropped())
+	}
+	if reported != 2 {
+		t.Errorf("Expected the drop handler to be told about 2 items, got %d", reported)
+	}
+}
@@ -0,0 +1,147 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Queue buffers items for an exporter in a bounded channel, so that callers
+// never block on a slow or unreachable backend. Items are exported in
+// batches through a Breaker; items that do not fit in the buffer, and
+// batches rejected by the open breaker, are dropped.
+type Queue[T any] struct {
+	items     chan T
+	batchSize int
+	timeout   time.Duration
+	export    func(ctx context.Context, items []T) error
+	breaker   *Breaker
+	dropped   atomic.Int64
+	onDrop    func(count int)
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// QueueOption configures a Queue
+type QueueOption func(*queueOptions)
+
+// queueOptions holds the settings of QueueOptions, which are not generic
+type queueOptions struct {
+	onDrop func(count int)
+}
+
+// WithDropHandler calls fn with the number of items dropped each time items
+// are dropped, e.g. to record a metric. It must not block.
+func WithDropHandler(fn func(count int)) QueueOption {
+	return func(o *queueOptions) {
+		o.onDrop = fn
+	}
+}
+
+// NewQueue creates a Queue holding up to size items and starts exporting
+// them in batches of up to batchSize, each export bounded by timeout
+func NewQueue[T any](size, batchSize int, timeout time.Duration, b *Breaker, export func(ctx context.Context, items []T) error, opts ...QueueOption) *Queue[T] {
+	var options queueOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if size < 1 {
+		size = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	q := &Queue[T]{
+		items:     make(chan T, size),
+		batchSize: batchSize,
+		timeout:   timeout,
+		export:    export,
+		breaker:   b,
+		onDrop:    options.onDrop,
+		done:      make(chan struct{}),
+	}
+
+	go q.run()
+	return q
+}
+
+// Push adds an item without blocking, reporting false if it was dropped
+// because the buffer is full
+func (q *Queue[T]) Push(item T) bool {
+	select {
+	case q.items <- item:
+		return true
+	default:
+		q.drop(1)
+		return false
+	}
+}
+
+// Dropped returns the number of items dropped so far
+func (q *Queue[T]) Dropped() int64 {
+	return q.dropped.Load()
+}
+
+// Close stops accepting items and waits until the buffered items are
+// exported or the context is done
+func (q *Queue[T]) Close(ctx context.Context) error {
+	q.closeOnce.Do(func() {
+		close(q.items)
+	})
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run exports buffered items in batches until the queue is closed
+func (q *Queue[T]) run() {
+	defer close(q.done)
+
+	batch := make([]T, 0, q.batchSize)
+	for item := range q.items {
+		batch = append(batch, item)
+
+		// Take whatever else is already buffered, up to the batch size
+	fill:
+		for len(batch) < q.batchSize {
+			select {
+			case next, ok := <-q.items:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+
+		q.flush(batch)
+		batch = make([]T, 0, q.batchSize)
+	}
+}
+
+// flush exports a batch through the breaker, dropping it on failure
+func (q *Queue[T]) flush(batch []T) {
+	err := q.breaker.Do(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+		defer cancel()
+		return q.export(ctx, batch)
+	})
+	if err != nil {
+		q.drop(len(batch))
+	}
+}
+
+// drop counts dropped items and reports them to the drop handler
+func (q *Queue[T]) drop(count int) {
+	q.dropped.Add(int64(count))
+	if q.onDrop != nil {
+		q.onDrop(count)
+	}
+}
@@ -0,0 +1,108 @@
+// Package debug serves runtime diagnostics on a listener of their own, apart
+// from the signaling endpoints: the net/http/pprof profiles, the expvar
+// variables and a dump of all goroutines, for diagnosing goroutine leaks of
+// WebSocket connections in production.
+package debug
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// publishOnce publishes the runtime variables, which expvar only accepts once per process
+var publishOnce sync.Once
+
+// Server serves the debug endpoints
+type Server struct {
+	httpServer *http.Server
+	logger     logging.Logger
+}
+
+// NewServer creates a Server listening on addr. Anyone reaching the listener
+// can profile the process, so it should be bound to localhost or a private
+// network.
+func NewServer(addr string, logger logging.Logger) *Server {
+	publishOnce.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() any {
+			return runtime.NumGoroutine()
+		}))
+	})
+
+	return &Server{
+		httpServer: &http.Server{
+			Addr:              addr,
+			Handler:           Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		logger: logger.With("component", "debug"),
+	}
+}
+
+// Handler serves the debug endpoints:
+//   - /debug/pprof/ lists the profiles, e.g. /debug/pprof/heap, and
+//     /debug/pprof/profile and /debug/pprof/trace record CPU profiles and
+//     execution traces for the number of seconds given by the seconds parameter
+//   - /debug/vars serves the expvar variables, such as memstats and goroutines
+//   - /debug/goroutines dumps the stacks of all goroutines, grouped by stack
+//     with ?grouped=true
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/goroutines", goroutines)
+	return mux
+}
+
+// goroutines writes the stacks of all goroutines as text
+func goroutines(w http.ResponseWriter, r *http.Request) {
+	// Debug level 2 prints each goroutine with its state and wait time, as
+	// an unrecovered panic does; level 1 groups goroutines of the same stack
+	level := 2
+	if grouped, _ := strconv.ParseBool(r.URL.Query().Get("grouped")); grouped {
+		level = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Goroutine-Count", strconv.Itoa(runtime.NumGoroutine()))
+	pprof.Handler("goroutine").ServeHTTP(w, withDebug(r, level))
+}
+
+// withDebug returns a copy of the request asking for the given profile debug level
+func withDebug(r *http.Request, level int) *http.Request {
+	query := r.URL.Query()
+	query.Set("debug", strconv.Itoa(level))
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = query.Encode()
+	return r
+}
+
+// Start starts serving the debug endpoints
+func (s *Server) Start() error {
+	s.logger.Warn("Debug endpoints listening, do not expose them publicly", "address", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the debug listener. Profiles still being recorded when the
+// context is done are cut short.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return s.httpServer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+func TestHandler(t *testing.T) {
+	// Publishes the runtime variables
+	NewServer("127.0.0.1:0", testsupport.NewLogger())
+	handler := Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "goroutine") {
+		t.Errorf("Expected the profile index, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("Expected the expvar variables as JSON: %v", err)
+	}
+	for _, name := range []string{"memstats", "goroutines"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("Expected the %s variable", name)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/goroutines", nil))
+	if rec.Header().Get("X-Goroutine-Count") == "" || !strings.Contains(rec.Body.String(), "TestHandler") {
+		t.Errorf("Expected a dump of all goroutine stacks, got %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/goroutines?grouped=true", nil))
+	if !strings.HasPrefix(rec.Body.String(), "goroutine profile: total") {
+		t.Errorf("Expected goroutines grouped by stack, got %q", rec.Body.String())
+	}
+}
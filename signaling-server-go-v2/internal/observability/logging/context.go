@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+)
+
+// contextKey is the type of context keys for correlation IDs
+type contextKey string
+
+const (
+	requestIDKey contextKey = "request_id"
+	clientIDKey  contextKey = "client_id"
+)
+
+// ContextWithRequestID returns a context carrying the ID of the HTTP request being handled
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// ContextWithClientID returns a context carrying the ID of the WebSocket client being handled
+func ContextWithClientID(ctx context.Context, clientID string) context.Context {
+	return context.WithValue(ctx, clientIDKey, clientID)
+}
+
+// ContextKeyvals returns the correlation IDs in the context as key-value
+// pairs: trace_id and span_id of the current span, request_id and client_id.
+// The *Ctx methods of Logger implementations prepend these to their keyvals.
+func ContextKeyvals(ctx context.Context) []interface{} {
+	if ctx == nil {
+		return nil
+	}
+
+	var keyvals []interface{}
+	if traceID, spanID := tracing.SpanIDsFromContext(ctx); traceID != "" {
+		keyvals = append(keyvals, "trace_id", traceID, "span_id", spanID)
+	}
+	for _, key := range []contextKey{requestIDKey, clientIDKey} {
+		if value, ok := ctx.Value(key).(string); ok && value != "" {
+			keyvals = append(keyvals, string(key), value)
+		}
+	}
+	return keyvals
+}
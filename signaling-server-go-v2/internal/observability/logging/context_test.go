@@ -0,0 +1,30 @@
+package logging
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+)
+
+func TestContextKeyvals(t *testing.T) {
+	if keyvals := ContextKeyvals(context.Background()); len(keyvals) != 0 {
+		t.Errorf("Expected no keyvals for an empty context, got %v", keyvals)
+	}
+
+	ctx := tracing.ContextWithSpanIDs(context.Background(), "trace-1", "span-1")
+	ctx = ContextWithRequestID(ctx, "request-1")
+	ctx = ContextWithClientID(ctx, "client-1")
+
+	expected := []interface{}{"trace_id", "trace-1", "span_id", "span-1", "request_id", "request-1", "client_id", "client-1"}
+	if keyvals := ContextKeyvals(ctx); !reflect.DeepEqual(keyvals, expected) {
+		t.Errorf("Expected %v, got %v", expected, keyvals)
+	}
+
+	// Child spans keep the trace ID and get a new span ID
+	child := tracing.NewSpanContext(ctx)
+	if traceID, spanID := tracing.SpanIDsFromContext(child); traceID != "trace-1" || spanID == "span-1" || len(spanID) != 16 {
+		t.Errorf("Expected a child span of trace-1, got %s/%s", traceID, spanID)
+	}
+}
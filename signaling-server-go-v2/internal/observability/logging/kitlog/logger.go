@@ -1,10 +1,11 @@
 package kitlog
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
@@ -14,24 +15,48 @@ import (
 // KitLogger is a simplified Logger implementation
 type KitLogger struct {
 	output io.Writer
-	level  string
+	level  *logging.Level
 	ctx    map[string]interface{}
 }
 
-// NewKitLogger creates a new instance of KitLogger
-func NewKitLogger(cfg config.LoggingConfig) (logging.Logger, error) {
-	var output io.Writer = os.Stdout
+// Option configures a KitLogger
+type Option func(*KitLogger)
 
-	return &KitLogger{
-		output: output,
-		level:  strings.ToLower(cfg.Level),
+// WithOutput sets the writer log lines are written to, stdout by default.
+// Each line is written with a single Write call.
+func WithOutput(w io.Writer) Option {
+	return func(l *KitLogger) {
+		l.output = w
+	}
+}
+
+// WithLevel shares a level with the logger, e.g. with the loggers wrapping
+// it, so that changing it changes the level of all of them. The level of the
+// configuration is used otherwise.
+func WithLevel(level *logging.Level) Option {
+	return func(l *KitLogger) {
+		l.level = level
+	}
+}
+
+// NewKitLogger creates a new instance of KitLogger
+func NewKitLogger(cfg config.LoggingConfig, opts ...Option) (logging.Logger, error) {
+	l := &KitLogger{
+		output: os.Stdout,
+		level:  logging.NewLevel(cfg.Level),
 		ctx:    make(map[string]interface{}),
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l, nil
 }
 
 // Debug logs a debug message
 func (l *KitLogger) Debug(msg string, keyvals ...interface{}) {
-	if l.level != "debug" {
+	if !l.level.Enabled("debug") {
 		return
 	}
 	l.log("DEBUG", msg, keyvals...)
@@ -39,7 +64,7 @@ func (l *KitLogger) Debug(msg string, keyvals ...interface{}) {
 
 // Info logs an info message
 func (l *KitLogger) Info(msg string, keyvals ...interface{}) {
-	if l.level != "debug" && l.level != "info" {
+	if !l.level.Enabled("info") {
 		return
 	}
 	l.log("INFO", msg, keyvals...)
@@ -47,7 +72,7 @@ func (l *KitLogger) Info(msg string, keyvals ...interface{}) {
 
 // Warn logs a warning message
 func (l *KitLogger) Warn(msg string, keyvals ...interface{}) {
-	if l.level != "debug" && l.level != "info" && l.level != "warn" {
+	if !l.level.Enabled("warn") {
 		return
 	}
 	l.log("WARN", msg, keyvals...)
@@ -58,6 +83,26 @@ func (l *KitLogger) Error(msg string, keyvals ...interface{}) {
 	l.log("ERROR", msg, keyvals...)
 }
 
+// DebugCtx logs a debug message with the correlation IDs in the context
+func (l *KitLogger) DebugCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.Debug(msg, append(logging.ContextKeyvals(ctx), keyvals...)...)
+}
+
+// InfoCtx logs an info message with the correlation IDs in the context
+func (l *KitLogger) InfoCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.Info(msg, append(logging.ContextKeyvals(ctx), keyvals...)...)
+}
+
+// WarnCtx logs a warning message with the correlation IDs in the context
+func (l *KitLogger) WarnCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.Warn(msg, append(logging.ContextKeyvals(ctx), keyvals...)...)
+}
+
+// ErrorCtx logs an error message with the correlation IDs in the context
+func (l *KitLogger) ErrorCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.Error(msg, append(logging.ContextKeyvals(ctx), keyvals...)...)
+}
+
 // With returns a new Logger with the provided keyvals
 func (l *KitLogger) With(keyvals ...interface{}) logging.Logger {
 	// Ensure even number of keyvals
@@ -114,14 +159,17 @@ func (l *KitLogger) log(level, msg string, keyvals ...interface{}) {
 	}
 
 	// Simple implementation that outputs key-value pairs
-	fmt.Fprintf(l.output, "%v %v: %v", logMap["ts"], logMap["level"], logMap["msg"])
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "%v %v: %v", logMap["ts"], logMap["level"], logMap["msg"])
 
 	// Output additional fields
 	for k, v := range logMap {
 		if k != "ts" && k != "level" && k != "msg" {
-			fmt.Fprintf(l.output, " %v=%v", k, v)
+			fmt.Fprintf(&line, " %v=%v", k, v)
 		}
 	}
 
-	fmt.Fprintln(l.output)
+	// Write the line at once so concurrent lines do not interleave
+	line.WriteByte('\n')
+	l.output.Write(line.Bytes())
 }
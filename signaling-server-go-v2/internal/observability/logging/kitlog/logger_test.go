@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
 )
 
 func TestNewKitLogger(t *testing.T) {
@@ -41,7 +42,7 @@ func TestLogLevels(t *testing.T) {
 	// Create a logger with the buffer
 	logger := &KitLogger{
 		output: &buf,
-		level:  "info",
+		level:  logging.NewLevel("info"),
 		ctx:    make(map[string]interface{}),
 	}
 
@@ -84,7 +85,7 @@ func TestLoggerContextWith(t *testing.T) {
 	// Create a logger with the buffer
 	logger := &KitLogger{
 		output: &buf,
-		level:  "debug",
+		level:  logging.NewLevel("debug"),
 		ctx:    make(map[string]interface{}),
 	}
 
@@ -125,7 +126,7 @@ func TestWithOddKeyvals(t *testing.T) {
 	// Create a logger
 	logger := &KitLogger{
 		output: &bytes.Buffer{},
-		level:  "debug",
+		level:  logging.NewLevel("debug"),
 		ctx:    make(map[string]interface{}),
 	}
 
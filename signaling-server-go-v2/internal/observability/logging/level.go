@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the minimum level of the loggers sharing it, such as the loggers
+// of a pipeline and those derived from them with With, so that it can be
+// changed at runtime, e.g. on configuration reload. An unknown level keeps
+// errors only.
+type Level struct {
+	name atomic.Value
+}
+
+// NewLevel creates a Level set to the given level
+func NewLevel(level string) *Level {
+	l := &Level{}
+	l.name.Store(strings.ToLower(level))
+	return l
+}
+
+// Set changes the level, rejecting unknown levels
+func (l *Level) Set(level string) error {
+	level = strings.ToLower(level)
+	if _, ok := levelRanks[level]; !ok {
+		return fmt.Errorf("unknown log level: %q", level)
+	}
+	l.name.Store(level)
+	return nil
+}
+
+// String returns the current level
+func (l *Level) String() string {
+	return l.name.Load().(string)
+}
+
+// Enabled reports whether entries of the given level are logged
+func (l *Level) Enabled(level string) bool {
+	minLevel, ok := levelRanks[l.String()]
+	if !ok {
+		minLevel = levelRanks["error"]
+	}
+	return levelRanks[strings.ToLower(level)] >= minLevel
+}
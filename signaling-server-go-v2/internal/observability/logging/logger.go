@@ -1,11 +1,18 @@
 package logging
 
-// Logger interface for abstracting logging implementations
+import "context"
+
+// Logger interface for abstracting logging implementations. The *Ctx variants
+// add the correlation IDs found in the context, see ContextKeyvals.
 type Logger interface {
 	Debug(msg string, keyvals ...interface{})
 	Info(msg string, keyvals ...interface{})
 	Warn(msg string, keyvals ...interface{})
 	Error(msg string, keyvals ...interface{})
+	DebugCtx(ctx context.Context, msg string, keyvals ...interface{})
+	InfoCtx(ctx context.Context, msg string, keyvals ...interface{})
+	WarnCtx(ctx context.Context, msg string, keyvals ...interface{})
+	ErrorCtx(ctx context.Context, msg string, keyvals ...interface{})
 	With(keyvals ...interface{}) Logger
 }
 
@@ -24,6 +31,18 @@ func (l *NoopLogger) Warn(msg string, keyvals ...interface{}) {}
 // Error implements Logger.Error
 func (l *NoopLogger) Error(msg string, keyvals ...interface{}) {}
 
+// DebugCtx implements Logger.DebugCtx
+func (l *NoopLogger) DebugCtx(ctx context.Context, msg string, keyvals ...interface{}) {}
+
+// InfoCtx implements Logger.InfoCtx
+func (l *NoopLogger) InfoCtx(ctx context.Context, msg string, keyvals ...interface{}) {}
+
+// WarnCtx implements Logger.WarnCtx
+func (l *NoopLogger) WarnCtx(ctx context.Context, msg string, keyvals ...interface{}) {}
+
+// ErrorCtx implements Logger.ErrorCtx
+func (l *NoopLogger) ErrorCtx(ctx context.Context, msg string, keyvals ...interface{}) {}
+
 // With implements Logger.With
 func (l *NoopLogger) With(keyvals ...interface{}) Logger {
 	return l
@@ -0,0 +1,78 @@
+package otellog
+
+import (
+	"context"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/breaker"
+)
+
+// exportTimeout bounds a single export of buffered records
+const exportTimeout = 5 * time.Second
+
+// OTLPExporter is a simplified Exporter sending log records to an OTLP collector
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+}
+
+// NewOTLPExporter creates an OTLPExporter for the collector in the logging
+// configuration, falling back to the tracing endpoint so that logs and
+// traces share one pipeline
+func NewOTLPExporter(cfg config.LoggingConfig, tracingCfg config.TracingConfig) *OTLPExporter {
+	endpoint := cfg.OTLP.Endpoint
+	if endpoint == "" {
+		endpoint = tracingCfg.Endpoint
+	}
+	return &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: tracingCfg.ServiceName,
+	}
+}
+
+// Export implements Exporter.Export
+func (e *OTLPExporter) Export(ctx context.Context, records []Record) error {
+	// In a real implementation, this would batch the records and send them
+	// to the collector with the service name as a resource attribute
+	return nil
+}
+
+// Shutdown implements Exporter.Shutdown
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	// In a real implementation, this would flush pending records
+	return nil
+}
+
+// BufferedExporter is an Exporter that buffers records and exports them in
+// the background through a circuit breaker, so that logging never waits on
+// the collector. Records are dropped while the buffer is full or the
+// breaker is open.
+type BufferedExporter struct {
+	next  Exporter
+	queue *breaker.Queue[Record]
+}
+
+// NewBufferedExporter creates a BufferedExporter buffering up to size records for next
+func NewBufferedExporter(next Exporter, b *breaker.Breaker, size int) *BufferedExporter {
+	return &BufferedExporter{
+		next:  next,
+		queue: breaker.NewQueue(size, 512, exportTimeout, b, next.Export),
+	}
+}
+
+// Export implements Exporter.Export
+func (e *BufferedExporter) Export(ctx context.Context, records []Record) error {
+	for _, record := range records {
+		e.queue.Push(record)
+	}
+	return nil
+}
+
+// Shutdown implements Exporter.Shutdown, exporting the buffered records first
+func (e *BufferedExporter) Shutdown(ctx context.Context) error {
+	if err := e.queue.Close(ctx); err != nil {
+		return err
+	}
+	return e.next.Shutdown(ctx)
+}
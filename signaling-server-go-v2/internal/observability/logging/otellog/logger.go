@@ -0,0 +1,151 @@
+// Package otellog bridges the Logger interface to OpenTelemetry logs, so that
+// logs can be exported through the same OTLP pipeline as traces and metrics.
+package otellog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+)
+
+// severities maps log levels to OpenTelemetry severity numbers
+var severities = map[string]int{"debug": 5, "info": 9, "warn": 13, "error": 17}
+
+// Record is an OpenTelemetry log record
+type Record struct {
+	Time           time.Time
+	SeverityText   string
+	SeverityNumber int
+	Body           string
+	Attributes     map[string]string
+
+	// TraceID and SpanID correlate the record with the span it was logged in
+	TraceID string
+	SpanID  string
+}
+
+// Exporter sends log records to an OpenTelemetry backend
+type Exporter interface {
+	Export(ctx context.Context, records []Record) error
+	Shutdown(ctx context.Context) error
+}
+
+// Logger is a Logger that forwards to another Logger and emits each entry
+// at or above its level as an OpenTelemetry log record
+type Logger struct {
+	next     logging.Logger
+	exporter Exporter
+	level    *logging.Level
+	ctx      []interface{}
+}
+
+// NewLogger creates a Logger forwarding to next and exporting records at or
+// above the given level, which should be shared with the wrapped Logger
+func NewLogger(next logging.Logger, exporter Exporter, level *logging.Level) *Logger {
+	return &Logger{
+		next:     next,
+		exporter: exporter,
+		level:    level,
+	}
+}
+
+// Debug implements Logger.Debug
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.emit(context.Background(), "debug", msg, keyvals)
+	l.next.Debug(msg, keyvals...)
+}
+
+// Info implements Logger.Info
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.emit(context.Background(), "info", msg, keyvals)
+	l.next.Info(msg, keyvals...)
+}
+
+// Warn implements Logger.Warn
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.emit(context.Background(), "warn", msg, keyvals)
+	l.next.Warn(msg, keyvals...)
+}
+
+// Error implements Logger.Error
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.emit(context.Background(), "error", msg, keyvals)
+	l.next.Error(msg, keyvals...)
+}
+
+// DebugCtx implements Logger.DebugCtx
+func (l *Logger) DebugCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.emit(ctx, "debug", msg, keyvals)
+	l.next.DebugCtx(ctx, msg, keyvals...)
+}
+
+// InfoCtx implements Logger.InfoCtx
+func (l *Logger) InfoCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.emit(ctx, "info", msg, keyvals)
+	l.next.InfoCtx(ctx, msg, keyvals...)
+}
+
+// WarnCtx implements Logger.WarnCtx
+func (l *Logger) WarnCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.emit(ctx, "warn", msg, keyvals)
+	l.next.WarnCtx(ctx, msg, keyvals...)
+}
+
+// ErrorCtx implements Logger.ErrorCtx
+func (l *Logger) ErrorCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.emit(ctx, "error", msg, keyvals)
+	l.next.ErrorCtx(ctx, msg, keyvals...)
+}
+
+// With implements Logger.With, sharing the exporter with the parent
+func (l *Logger) With(keyvals ...interface{}) logging.Logger {
+	ctx := make([]interface{}, 0, len(l.ctx)+len(keyvals))
+	ctx = append(ctx, l.ctx...)
+	ctx = append(ctx, keyvals...)
+
+	return &Logger{
+		next:     l.next.With(keyvals...),
+		exporter: l.exporter,
+		level:    l.level,
+		ctx:      ctx,
+	}
+}
+
+// emit exports an entry as a log record if its level is at or above the logger's level
+func (l *Logger) emit(ctx context.Context, level, msg string, keyvals []interface{}) {
+	if !l.level.Enabled(level) {
+		return
+	}
+	severity := severities[level]
+
+	record := Record{
+		Time:           time.Now().UTC(),
+		SeverityText:   strings.ToUpper(level),
+		SeverityNumber: severity,
+		Body:           msg,
+		Attributes:     make(map[string]string, (len(l.ctx)+len(keyvals))/2+2),
+	}
+	record.TraceID, record.SpanID = tracing.SpanIDsFromContext(ctx)
+
+	addAttributes(record.Attributes, logging.ContextKeyvals(ctx))
+	addAttributes(record.Attributes, l.ctx)
+	addAttributes(record.Attributes, keyvals)
+
+	// The trace and span IDs are carried by the record itself
+	delete(record.Attributes, "trace_id")
+	delete(record.Attributes, "span_id")
+
+	// An unreachable collector must not break logging to the other outputs
+	_ = l.exporter.Export(ctx, []Record{record})
+}
+
+// addAttributes adds formatted key-value pairs to a map, ignoring a trailing key without value
+func addAttributes(attributes map[string]string, keyvals []interface{}) {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		attributes[fmt.Sprintf("%v", keyvals[i])] = fmt.Sprintf("%v", keyvals[i+1])
+	}
+}
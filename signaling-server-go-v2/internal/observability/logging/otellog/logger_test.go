@@ -0,0 +1,61 @@
+package otellog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/testsupport"
+)
+
+// recordingExporter keeps the exported records
+type recordingExporter struct {
+	records []Record
+}
+
+func (e *recordingExporter) Export(ctx context.Context, records []Record) error {
+	e.records = append(e.records, records...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestLoggerExportsRecords(t *testing.T) {
+	exporter := &recordingExporter{}
+	next := testsupport.NewLogger()
+	logger := NewLogger(next, exporter, logging.NewLevel("info"))
+
+	ctx := tracing.ContextWithSpanIDs(context.Background(), "trace-1", "span-1")
+	ctx = logging.ContextWithClientID(ctx, "client-1")
+
+	logger.Debug("filtered")
+	logger.With("component", "test").WarnCtx(ctx, "slow relay", "duration_ms", 250)
+
+	if len(exporter.records) != 1 {
+		t.Fatalf("Expected 1 exported record, got %d", len(exporter.records))
+	}
+	record := exporter.records[0]
+	if record.Body != "slow relay" || record.SeverityText != "WARN" || record.SeverityNumber != 13 {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+	if record.TraceID != "trace-1" || record.SpanID != "span-1" {
+		t.Errorf("Expected the record to carry the span IDs, got %s/%s", record.TraceID, record.SpanID)
+	}
+	expected := map[string]string{"component": "test", "client_id": "client-1", "duration_ms": "250"}
+	for key, value := range expected {
+		if record.Attributes[key] != value {
+			t.Errorf("Expected attribute %s=%s, got %q", key, value, record.Attributes[key])
+		}
+	}
+	if _, ok := record.Attributes["trace_id"]; ok {
+		t.Error("Expected the trace ID not to be duplicated as an attribute")
+	}
+
+	// Entries are still written to the wrapped logger
+	if !next.Called("WARN") {
+		t.Error("Expected the entry to be forwarded")
+	}
+}
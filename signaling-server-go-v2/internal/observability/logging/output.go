@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// Output is a log sink combining the outputs selected in the logging
+// configuration. Loggers must write each log line with a single Write call so
+// that line-oriented sinks such as syslog receive whole entries.
+type Output struct {
+	writers []io.Writer
+	closers []io.Closer
+}
+
+// NewOutput opens the outputs listed in the configuration, defaulting to stdout
+func NewOutput(cfg config.LoggingConfig) (*Output, error) {
+	outputs := cfg.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{"stdout"}
+	}
+
+	out := &Output{}
+	for _, name := range outputs {
+		switch strings.ToLower(name) {
+		case "stdout":
+			out.writers = append(out.writers, os.Stdout)
+		case "file":
+			file, err := NewRotatingFile(cfg.File)
+			if err != nil {
+				out.Close()
+				return nil, err
+			}
+			out.add(file)
+		case "syslog":
+			writer, err := newSyslogWriter(cfg.Syslog)
+			if err != nil {
+				out.Close()
+				return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+			}
+			out.add(writer)
+		default:
+			out.Close()
+			return nil, fmt.Errorf("unknown log output: %s", name)
+		}
+	}
+
+	return out, nil
+}
+
+// add appends an output that must be closed with the Output
+func (o *Output) add(w io.WriteCloser) {
+	o.writers = append(o.writers, w)
+	o.closers = append(o.closers, w)
+}
+
+// Write writes a log line to every output, returning the first error
+func (o *Output) Write(p []byte) (int, error) {
+	var firstErr error
+	for _, w := range o.writers {
+		if _, err := w.Write(p); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return len(p), nil
+}
+
+// Close closes the file and syslog outputs
+func (o *Output) Close() error {
+	var firstErr error
+	for _, c := range o.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// levelRanks orders the log levels
+var levelRanks = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// Entry is a log line captured by a Recorder
+type Entry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"msg"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// ringBuffer holds the most recent entries up to a fixed capacity
+type ringBuffer struct {
+	mutex   sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// Recorder is a Logger that forwards to another Logger and keeps the most
+// recent entries in memory, e.g. for inclusion in debug bundles
+type Recorder struct {
+	next   Logger
+	buffer *ringBuffer
+	ctx    []interface{}
+	level  *Level
+}
+
+// NewRecorder creates a Recorder keeping up to size entries at or above the
+// given level, which should be shared with the wrapped Logger
+func NewRecorder(next Logger, size int, level *Level) *Recorder {
+	if size <= 0 {
+		size = 1
+	}
+	return &Recorder{
+		next:   next,
+		buffer: &ringBuffer{entries: make([]Entry, size)},
+		level:  level,
+	}
+}
+
+// Debug implements Logger.Debug
+func (r *Recorder) Debug(msg string, keyvals ...interface{}) {
+	r.record("debug", msg, keyvals)
+	r.next.Debug(msg, keyvals...)
+}
+
+// Info implements Logger.Info
+func (r *Recorder) Info(msg string, keyvals ...interface{}) {
+	r.record("info", msg, keyvals)
+	r.next.Info(msg, keyvals...)
+}
+
+// Warn implements Logger.Warn
+func (r *Recorder) Warn(msg string, keyvals ...interface{}) {
+	r.record("warn", msg, keyvals)
+	r.next.Warn(msg, keyvals...)
+}
+
+// Error implements Logger.Error
+func (r *Recorder) Error(msg string, keyvals ...interface{}) {
+	r.record("error", msg, keyvals)
+	r.next.Error(msg, keyvals...)
+}
+
+// DebugCtx implements Logger.DebugCtx
+func (r *Recorder) DebugCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	r.Debug(msg, append(ContextKeyvals(ctx), keyvals...)...)
+}
+
+// InfoCtx implements Logger.InfoCtx
+func (r *Recorder) InfoCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	r.Info(msg, append(ContextKeyvals(ctx), keyvals...)...)
+}
+
+// WarnCtx implements Logger.WarnCtx
+func (r *Recorder) WarnCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	r.Warn(msg, append(ContextKeyvals(ctx), keyvals...)...)
+}
+
+// ErrorCtx implements Logger.ErrorCtx
+func (r *Recorder) ErrorCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	r.Error(msg, append(ContextKeyvals(ctx), keyvals...)...)
+}
+
+// With implements Logger.With, sharing the recorded entries with the parent
+func (r *Recorder) With(keyvals ...interface{}) Logger {
+	ctx := make([]interface{}, 0, len(r.ctx)+len(keyvals))
+	ctx = append(ctx, r.ctx...)
+	ctx = append(ctx, keyvals...)
+
+	return &Recorder{
+		next:   r.next.With(keyvals...),
+		buffer: r.buffer,
+		ctx:    ctx,
+		level:  r.level,
+	}
+}
+
+// Entries returns the recorded entries, oldest first
+func (r *Recorder) Entries() []Entry {
+	b := r.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.full {
+		return append([]Entry(nil), b.entries[:b.next]...)
+	}
+
+	entries := make([]Entry, 0, len(b.entries))
+	entries = append(entries, b.entries[b.next:]...)
+	entries = append(entries, b.entries[:b.next]...)
+	return entries
+}
+
+// record stores an entry in the ring buffer if its level is at or above the recorder's level
+func (r *Recorder) record(level, msg string, keyvals []interface{}) {
+	if !r.level.Enabled(level) {
+		return
+	}
+
+	fields := make(map[string]string, (len(r.ctx)+len(keyvals))/2)
+	addFields(fields, r.ctx)
+	addFields(fields, keyvals)
+
+	b := r.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.entries[b.next] = Entry{
+		Time:    time.Now().UTC(),
+		Level:   strings.ToUpper(level),
+		Message: msg,
+		Fields:  fields,
+	}
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// addFields adds formatted key-value pairs to a map, ignoring a trailing key without value
+func addFields(fields map[string]string, keyvals []interface{}) {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields[fmt.Sprintf("%v", keyvals[i])] = fmt.Sprintf("%v", keyvals[i+1])
+	}
+}
@@ -0,0 +1,65 @@
+package logging
+
+import "testing"
+
+func TestRecorderKeepsRecentEntries(t *testing.T) {
+	recorder := NewRecorder(&NoopLogger{}, 2, NewLevel("info"))
+
+	recorder.Info("first")
+	recorder.With("component", "test").Warn("second", "key", "value")
+	recorder.Error("third")
+
+	entries := recorder.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+
+	if entries[0].Message != "second" || entries[1].Message != "third" {
+		t.Errorf("Expected entries [second third], got [%s %s]", entries[0].Message, entries[1].Message)
+	}
+
+	if entries[0].Level != "WARN" {
+		t.Errorf("Expected level WARN, got %s", entries[0].Level)
+	}
+
+	if entries[0].Fields["component"] != "test" || entries[0].Fields["key"] != "value" {
+		t.Errorf("Expected context and message fields, got %v", entries[0].Fields)
+	}
+}
+
+func TestRecorderFiltersByLevel(t *testing.T) {
+	recorder := NewRecorder(&NoopLogger{}, 10, NewLevel("warn"))
+
+	recorder.Debug("debug")
+	recorder.Info("info")
+	recorder.Warn("warn")
+	recorder.With("component", "test").Error("error")
+
+	entries := recorder.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Level != "WARN" || entries[1].Level != "ERROR" {
+		t.Errorf("Expected levels [WARN ERROR], got [%s %s]", entries[0].Level, entries[1].Level)
+	}
+}
+
+func TestRecorderSharesLevel(t *testing.T) {
+	level := NewLevel("warn")
+	recorder := NewRecorder(&NoopLogger{}, 10, level)
+	child := recorder.With("component", "test")
+
+	child.Info("dropped")
+	if err := level.Set("INFO"); err != nil {
+		t.Fatalf("Failed to set level: %v", err)
+	}
+	child.Info("kept")
+	if err := level.Set("verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+
+	entries := recorder.Entries()
+	if len(entries) != 1 || entries[0].Message != "kept" {
+		t.Errorf("Expected the level change to apply to derived loggers, got %v", entries)
+	}
+}
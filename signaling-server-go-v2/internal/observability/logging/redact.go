@@ -0,0 +1,171 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// scrubbedValue replaces the values of scrubbed fields
+const scrubbedValue = "[REDACTED]"
+
+// emailPattern matches email addresses within log values
+var emailPattern = regexp.MustCompile(`([A-Za-z0-9._%+\-])[A-Za-z0-9._%+\-]*@([A-Za-z0-9.\-]+\.[A-Za-z]{2,})`)
+
+// Redactor is a Logger that applies redaction rules to the fields of each
+// entry before forwarding it, so that no output receives personal data or
+// credentials. It should wrap every other Logger in the pipeline.
+type Redactor struct {
+	next        Logger
+	scrubFields []string
+	ipFields    []string
+	maskEmails  bool
+}
+
+// NewRedactor creates a Redactor forwarding to next. If redaction is
+// disabled in the configuration, next is returned unchanged.
+func NewRedactor(next Logger, cfg config.RedactionConfig) Logger {
+	if !cfg.Enabled {
+		return next
+	}
+	return &Redactor{
+		next:        next,
+		scrubFields: lowerAll(cfg.ScrubFields),
+		ipFields:    lowerAll(cfg.IPFields),
+		maskEmails:  cfg.MaskEmails,
+	}
+}
+
+// Debug implements Logger.Debug
+func (r *Redactor) Debug(msg string, keyvals ...interface{}) {
+	r.next.Debug(msg, r.redact(keyvals)...)
+}
+
+// Info implements Logger.Info
+func (r *Redactor) Info(msg string, keyvals ...interface{}) {
+	r.next.Info(msg, r.redact(keyvals)...)
+}
+
+// Warn implements Logger.Warn
+func (r *Redactor) Warn(msg string, keyvals ...interface{}) {
+	r.next.Warn(msg, r.redact(keyvals)...)
+}
+
+// Error implements Logger.Error
+func (r *Redactor) Error(msg string, keyvals ...interface{}) {
+	r.next.Error(msg, r.redact(keyvals)...)
+}
+
+// DebugCtx implements Logger.DebugCtx
+func (r *Redactor) DebugCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	r.next.DebugCtx(ctx, msg, r.redact(keyvals)...)
+}
+
+// InfoCtx implements Logger.InfoCtx
+func (r *Redactor) InfoCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	r.next.InfoCtx(ctx, msg, r.redact(keyvals)...)
+}
+
+// WarnCtx implements Logger.WarnCtx
+func (r *Redactor) WarnCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	r.next.WarnCtx(ctx, msg, r.redact(keyvals)...)
+}
+
+// ErrorCtx implements Logger.ErrorCtx
+func (r *Redactor) ErrorCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	r.next.ErrorCtx(ctx, msg, r.redact(keyvals)...)
+}
+
+// With implements Logger.With, redacting the context fields once up front
+func (r *Redactor) With(keyvals ...interface{}) Logger {
+	return &Redactor{
+		next:        r.next.With(r.redact(keyvals)...),
+		scrubFields: r.scrubFields,
+		ipFields:    r.ipFields,
+		maskEmails:  r.maskEmails,
+	}
+}
+
+// redact returns a copy of the key-value pairs with the redaction rules applied
+func (r *Redactor) redact(keyvals []interface{}) []interface{} {
+	redacted := make([]interface{}, len(keyvals))
+	copy(redacted, keyvals)
+
+	for i := 0; i+1 < len(redacted); i += 2 {
+		key := strings.ToLower(fmt.Sprintf("%v", redacted[i]))
+		switch {
+		case matchAny(r.scrubFields, key):
+			redacted[i+1] = scrubbedValue
+		case matchAny(r.ipFields, key):
+			redacted[i+1] = TruncateIP(fmt.Sprintf("%v", redacted[i+1]))
+		case r.maskEmails:
+			redacted[i+1] = maskEmails(redacted[i+1])
+		}
+	}
+	return redacted
+}
+
+// TruncateIP zeroes the host part of an IP address, keeping the /24 network
+// of IPv4 and the /48 network of IPv6 addresses. A port is dropped. Values
+// that are not IP addresses, such as host names, are scrubbed.
+func TruncateIP(addr string) string {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if addr == "" {
+			return ""
+		}
+		return scrubbedValue
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// maskEmails masks the email addresses in string and error values, keeping
+// the first character of the local part and the domain
+func maskEmails(value interface{}) interface{} {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		return value
+	}
+
+	if !strings.Contains(s, "@") {
+		return value
+	}
+	return emailPattern.ReplaceAllString(s, "$1***@$2")
+}
+
+// matchAny reports whether the key matches any of the patterns
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerAll returns the strings in lower case
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(v)
+	}
+	return lowered
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+func TestRedactorAppliesRules(t *testing.T) {
+	recorder := NewRecorder(&NoopLogger{}, 10, NewLevel("debug"))
+	logger := NewRedactor(recorder, config.RedactionConfig{
+		Enabled:     true,
+		ScrubFields: []string{"*token*", "Authorization"},
+		IPFields:    []string{"remote_addr", "*_ip"},
+		MaskEmails:  true,
+	})
+
+	logger.With("remote_addr", "203.0.113.42:51234").Info("Request started",
+		"session_token", "abc123",
+		"authorization", "Bearer secret",
+		"peer_ip", "2001:db8:1234:5678::1",
+		"error", errors.New("unknown user alice@example.com"),
+		"path", "/ws",
+	)
+
+	fields := recorder.Entries()[0].Fields
+	expected := map[string]string{
+		"remote_addr":   "203.0.113.0",
+		"session_token": "[REDACTED]",
+		"authorization": "[REDACTED]",
+		"peer_ip":       "2001:db8:1234::",
+		"error":         "unknown user a***@example.com",
+		"path":          "/ws",
+	}
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, fields[key])
+		}
+	}
+}
+
+func TestRedactorDisabled(t *testing.T) {
+	recorder := NewRecorder(&NoopLogger{}, 10, NewLevel("debug"))
+	if logger := NewRedactor(recorder, config.RedactionConfig{}); logger != Logger(recorder) {
+		t.Error("Expected a disabled redactor to return the wrapped logger")
+	}
+}
+
+func TestTruncateIP(t *testing.T) {
+	cases := map[string]string{
+		"198.51.100.7":       "198.51.100.0",
+		"[2001:db8::1]:443":  "2001:db8::",
+		"signaling.internal": "[REDACTED]",
+		"":                   "",
+	}
+	for input, expected := range cases {
+		if actual := TruncateIP(input); actual != expected {
+			t.Errorf("TruncateIP(%q) = %q, expected %q", input, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// backupTimeFormat is the timestamp inserted into the names of rotated files
+const backupTimeFormat = "20060102T150405.000"
+
+// compressedExt is appended to the names of compressed rotated files
+const compressedExt = ".gz"
+
+// RotatingFile is a log file that is rotated once it exceeds a maximum size
+// or has been written to for the rotation interval. Rotated files are renamed
+// with a timestamp, e.g. server-20240101T120000.000.log, gzipped in the
+// background if compression is enabled, and removed once they exceed the
+// maximum age or count.
+type RotatingFile struct {
+	cfg    config.LogFileConfig
+	now    func() time.Time
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// compressing tracks the rotated files being compressed
+	compressing sync.WaitGroup
+}
+
+// NewRotatingFile opens the log file for appending, creating its directory if needed
+func NewRotatingFile(cfg config.LogFileConfig) (*RotatingFile, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("log file path is required")
+	}
+
+	f := &RotatingFile{cfg: cfg, now: time.Now}
+	f.opened = f.now()
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends to the log file, rotating it first if the write would exceed
+// the maximum size or the rotation interval has passed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	maxSize := int64(f.cfg.MaxSizeMB) * 1024 * 1024
+	tooLarge := maxSize > 0 && f.size+int64(len(p)) > maxSize
+	interval := time.Duration(f.cfg.RotateHours) * time.Hour
+	tooOld := interval > 0 && !f.now().Before(f.opened.Add(interval))
+	if f.size > 0 && (tooLarge || tooOld) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file, waiting for the rotated files being compressed
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.compressing.Wait()
+	return f.file.Close()
+}
+
+// open opens the log file and records its current size. Must be called with the mutex held.
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.cfg.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(f.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file with a timestamp, opens a new one and
+// removes old backups. Must be called with the mutex held.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	name := f.backupName(f.now())
+	if err := os.Rename(f.cfg.Path, name); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.opened = f.now()
+
+	if f.cfg.Compress {
+		f.compressing.Add(1)
+		go func() {
+			defer f.compressing.Done()
+			if err := compressFile(name); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to compress rotated log file: %v\n", err)
+			}
+		}()
+	}
+
+	f.removeOldBackups()
+	return nil
+}
+
+// compressFile gzips a rotated file, removing it once compressed. An
+// interrupted compression leaves the rotated file in place.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+compressedExt, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(name + compressedExt)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + compressedExt)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + compressedExt)
+		return err
+	}
+	return os.Remove(name)
+}
+
+// backupName returns the name of a file rotated at the given time
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.cfg.Path)
+	base := strings.TrimSuffix(f.cfg.Path, ext)
+	return base + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// removeOldBackups removes rotated files beyond the maximum count or age
+func (f *RotatingFile) removeOldBackups() {
+	backups := f.backups()
+
+	// Newest first, so the oldest are beyond the maximum count
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].rotated.After(backups[j].rotated)
+	})
+
+	cutoff := f.now().Add(-time.Duration(f.cfg.MaxAgeDays) * 24 * time.Hour)
+	for i, backup := range backups {
+		tooMany := f.cfg.MaxBackups > 0 && i >= f.cfg.MaxBackups
+		tooOld := f.cfg.MaxAgeDays > 0 && backup.rotated.Before(cutoff)
+		if tooMany || tooOld {
+			// A file being compressed is removed in both forms
+			os.Remove(backup.path)
+			os.Remove(backup.path + compressedExt)
+		}
+	}
+}
+
+// backup is a rotated log file; path is its name before compression
+type backup struct {
+	path    string
+	rotated time.Time
+}
+
+// backups lists the rotated files of the log file, compressed or not
+func (f *RotatingFile) backups() []backup {
+	ext := filepath.Ext(f.cfg.Path)
+	prefix := filepath.Base(strings.TrimSuffix(f.cfg.Path, ext)) + "-"
+	dir := filepath.Dir(f.cfg.Path)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var backups []backup
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		trimmed := strings.TrimSuffix(name, compressedExt)
+		if entry.IsDir() || !strings.HasPrefix(trimmed, prefix) || !strings.HasSuffix(trimmed, ext) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(trimmed, prefix), ext)
+		rotated, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil || seen[trimmed] {
+			continue
+		}
+		seen[trimmed] = true
+		backups = append(backups, backup{path: filepath.Join(dir, trimmed), rotated: rotated})
+	}
+	return backups
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.log")
+
+	f, err := NewRotatingFile(config.LogFileConfig{Path: path, MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	// Each write fills half a megabyte, so every second write rotates
+	line := make([]byte, 512*1024)
+	for i := 0; i < 8; i++ {
+		now = now.Add(time.Minute)
+		if _, err := f.Write(line); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	backups := f.backups()
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups to be kept, got %d", len(backups))
+	}
+	for _, backup := range backups {
+		if info, err := os.Stat(backup.path); err != nil || info.Size() != 1024*1024 {
+			t.Errorf("Expected full backup %s, got %v", backup.path, info)
+		}
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Size() != 1024*1024 {
+		t.Errorf("Expected the current file to hold the latest writes, got %v", info)
+	}
+}
+
+func TestNewOutputRejectsUnknownOutputs(t *testing.T) {
+	if _, err := NewOutput(config.LoggingConfig{Outputs: []string{"stdout", "carrier-pigeon"}}); err == nil {
+		t.Error("Expected an unknown output to be rejected")
+	}
+}
+
+func TestRotatingFileByAgeCompressed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.log")
+
+	f, err := NewRotatingFile(config.LogFileConfig{Path: path, RotateHours: 24, Compress: true, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	// A line per day rotates before each write but the first
+	for i := 0; i < 4; i++ {
+		if _, err := f.Write([]byte("line\n")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		now = now.Add(24 * time.Hour)
+	}
+	f.Close()
+
+	backups := f.backups()
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups to be kept, got %d", len(backups))
+	}
+	for _, backup := range backups {
+		if _, err := os.Stat(backup.path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be replaced by its compressed form, got %v", backup.path, err)
+		}
+		compressed, err := os.Open(backup.path + compressedExt)
+		if err != nil {
+			t.Fatalf("Expected a compressed backup: %v", err)
+		}
+		zr, err := gzip.NewReader(compressed)
+		if err != nil {
+			t.Fatalf("Expected a gzip file: %v", err)
+		}
+		if content, _ := io.ReadAll(zr); string(content) != "line\n" {
+			t.Errorf("Expected the rotated line, got %q", content)
+		}
+		compressed.Close()
+	}
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Sampler is a Logger forwarding one in N debug and info entries of the
+// sampled messages, such as the "Message relayed" line of every relay, so
+// that debug logging of the hot paths does not overwhelm the outputs under
+// load. Warnings and errors are always forwarded. Forwarded entries of a
+// sampled message carry its rate in a sample_rate field.
+type Sampler struct {
+	next  Logger
+	rates map[string]*sampleRate
+}
+
+// sampleRate counts the entries of a sampled message, across the loggers
+// derived with With
+type sampleRate struct {
+	every uint64
+	count atomic.Uint64
+}
+
+// NewSampler creates a Sampler forwarding to next one in rates[msg] entries
+// of each message; rates of 1 or less forward every entry. If no message is
+// sampled, next is returned unchanged.
+func NewSampler(next Logger, rates map[string]int) Logger {
+	sampled := make(map[string]*sampleRate)
+	for msg, every := range rates {
+		if every > 1 {
+			sampled[msg] = &sampleRate{every: uint64(every)}
+		}
+	}
+	if len(sampled) == 0 {
+		return next
+	}
+	return &Sampler{next: next, rates: sampled}
+}
+
+// Debug implements Logger.Debug
+func (s *Sampler) Debug(msg string, keyvals ...interface{}) {
+	if keyvals, ok := s.sample(msg, keyvals); ok {
+		s.next.Debug(msg, keyvals...)
+	}
+}
+
+// Info implements Logger.Info
+func (s *Sampler) Info(msg string, keyvals ...interface{}) {
+	if keyvals, ok := s.sample(msg, keyvals); ok {
+		s.next.Info(msg, keyvals...)
+	}
+}
+
+// Warn implements Logger.Warn
+func (s *Sampler) Warn(msg string, keyvals ...interface{}) {
+	s.next.Warn(msg, keyvals...)
+}
+
+// Error implements Logger.Error
+func (s *Sampler) Error(msg string, keyvals ...interface{}) {
+	s.next.Error(msg, keyvals...)
+}
+
+// DebugCtx implements Logger.DebugCtx
+func (s *Sampler) DebugCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	if keyvals, ok := s.sample(msg, keyvals); ok {
+		s.next.DebugCtx(ctx, msg, keyvals...)
+	}
+}
+
+// InfoCtx implements Logger.InfoCtx
+func (s *Sampler) InfoCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	if keyvals, ok := s.sample(msg, keyvals); ok {
+		s.next.InfoCtx(ctx, msg, keyvals...)
+	}
+}
+
+// WarnCtx implements Logger.WarnCtx
+func (s *Sampler) WarnCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	s.next.WarnCtx(ctx, msg, keyvals...)
+}
+
+// ErrorCtx implements Logger.ErrorCtx
+func (s *Sampler) ErrorCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	s.next.ErrorCtx(ctx, msg, keyvals...)
+}
+
+// With implements Logger.With, sharing the counts of the sampled messages
+func (s *Sampler) With(keyvals ...interface{}) Logger {
+	return &Sampler{next: s.next.With(keyvals...), rates: s.rates}
+}
+
+// sample reports whether an entry is forwarded, with its rate added to the
+// keyvals of sampled messages. The first entry of each message is forwarded.
+func (s *Sampler) sample(msg string, keyvals []interface{}) ([]interface{}, bool) {
+	rate, ok := s.rates[msg]
+	if !ok {
+		return keyvals, true
+	}
+	if (rate.count.Add(1)-1)%rate.every != 0 {
+		return nil, false
+	}
+	return append(keyvals[:len(keyvals):len(keyvals)], "sample_rate", rate.every), true
+}
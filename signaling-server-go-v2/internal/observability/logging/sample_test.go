@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"testing"
+)
+
+func TestSampler(t *testing.T) {
+	recorder := NewRecorder(&NoopLogger{}, 100, NewLevel("debug"))
+	logger := NewSampler(recorder, map[string]int{"Message relayed": 10, "Peer joined": 1})
+
+	relay := logger.With("component", "signaling")
+	for i := 0; i < 25; i++ {
+		relay.Debug("Message relayed", "type", "offer")
+		logger.Error("Message relayed", "error", "failed")
+		logger.Info("Peer joined")
+	}
+
+	var relayed, failed, joined int
+	for _, entry := range recorder.Entries() {
+		switch {
+		case entry.Message == "Message relayed" && entry.Level == "DEBUG":
+			relayed++
+			if entry.Fields["sample_rate"] != "10" {
+				t.Errorf("Expected sampled entries to carry their rate, got %v", entry.Fields)
+			}
+		case entry.Message == "Message relayed":
+			failed++
+		case entry.Message == "Peer joined":
+			joined++
+		}
+	}
+	if relayed != 3 || failed != 25 || joined != 25 {
+		t.Errorf("Expected 3 sampled debug entries and every error and unsampled entry, got %d, %d and %d", relayed, failed, joined)
+	}
+
+	if unsampled := NewSampler(recorder, map[string]int{"Peer joined": 1}); unsampled != Logger(recorder) {
+		t.Error("Expected the logger to be returned unchanged without sampled messages")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"io"
+	"log/syslog"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// newSyslogWriter connects to the configured syslog daemon, or the local one if no address is set
+func newSyslogWriter(cfg config.SyslogConfig) (io.WriteCloser, error) {
+	return syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, cfg.Tag)
+}
@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// newSyslogWriter reports that syslog is not available on this platform
+func newSyslogWriter(cfg config.SyslogConfig) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("syslog output is not supported on this platform")
+}
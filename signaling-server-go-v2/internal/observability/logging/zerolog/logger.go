@@ -0,0 +1,319 @@
+// Package zerolog is a Logger writing zerolog's JSON lines with few
+// allocations, for deployments logging on the WebSocket message path.
+package zerolog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// Logger is a Logger encoding entries like github.com/rs/zerolog, with the
+// level, time, fields and message of each entry on a JSON line, so that log
+// pipelines parsing zerolog need no change. In a real implementation, this
+// would wrap a zerolog.Logger; the encoder here appends the fields of common
+// types to pooled buffers without reflection, and the fields added with With
+// are encoded once.
+type Logger struct {
+	output     io.Writer
+	level      *logging.Level
+	timeFormat string
+
+	// context holds the encoded fields added with With, each preceded by a comma
+	context []byte
+}
+
+// Option configures a Logger
+type Option func(*Logger)
+
+// WithOutput sets the writer log lines are written to, stdout by default.
+// Each line is written with a single Write call.
+func WithOutput(w io.Writer) Option {
+	return func(l *Logger) {
+		l.output = w
+	}
+}
+
+// WithLevel shares a level with the logger, e.g. with the loggers wrapping
+// it, so that changing it changes the level of all of them. The level of the
+// configuration is used otherwise.
+func WithLevel(level *logging.Level) Option {
+	return func(l *Logger) {
+		l.level = level
+	}
+}
+
+// timeFormats maps the time formats of the configuration to layouts; the
+// unix formats are encoded as numbers, other formats are used as layouts
+var timeFormats = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"unix":        "unix",
+	"unix_ms":     "unix_ms",
+}
+
+// NewZerologLogger creates a Logger by the level and time format of the configuration
+func NewZerologLogger(cfg config.LoggingConfig, opts ...Option) (logging.Logger, error) {
+	l := &Logger{
+		output:     os.Stdout,
+		level:      logging.NewLevel(cfg.Level),
+		timeFormat: time.RFC3339,
+	}
+	if cfg.TimeFormat != "" {
+		l.timeFormat = cfg.TimeFormat
+		if layout, ok := timeFormats[cfg.TimeFormat]; ok {
+			l.timeFormat = layout
+		}
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l, nil
+}
+
+// Debug logs a debug message
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	if l.level.Enabled("debug") {
+		l.log("debug", msg, nil, keyvals)
+	}
+}
+
+// Info logs an info message
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	if l.level.Enabled("info") {
+		l.log("info", msg, nil, keyvals)
+	}
+}
+
+// Warn logs a warning message
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	if l.level.Enabled("warn") {
+		l.log("warn", msg, nil, keyvals)
+	}
+}
+
+// Error logs an error message
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log("error", msg, nil, keyvals)
+}
+
+// DebugCtx logs a debug message with the correlation IDs in the context
+func (l *Logger) DebugCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	if l.level.Enabled("debug") {
+		l.log("debug", msg, logging.ContextKeyvals(ctx), keyvals)
+	}
+}
+
+// InfoCtx logs an info message with the correlation IDs in the context
+func (l *Logger) InfoCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	if l.level.Enabled("info") {
+		l.log("info", msg, logging.ContextKeyvals(ctx), keyvals)
+	}
+}
+
+// WarnCtx logs a warning message with the correlation IDs in the context
+func (l *Logger) WarnCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	if l.level.Enabled("warn") {
+		l.log("warn", msg, logging.ContextKeyvals(ctx), keyvals)
+	}
+}
+
+// ErrorCtx logs an error message with the correlation IDs in the context
+func (l *Logger) ErrorCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.log("error", msg, logging.ContextKeyvals(ctx), keyvals)
+}
+
+// With returns a new Logger with the provided keyvals, encoded once
+func (l *Logger) With(keyvals ...interface{}) logging.Logger {
+	fields := make([]byte, len(l.context), len(l.context)+32*len(keyvals))
+	copy(fields, l.context)
+
+	child := *l
+	child.context = appendFields(fields, keyvals)
+	return &child
+}
+
+// buffers are reused across entries, so that logging allocates little
+var buffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+// maxPooledBuffer bounds the buffers returned to the pool, so that a huge
+// entry does not pin its memory
+const maxPooledBuffer = 64 * 1024
+
+// log encodes an entry and writes it as a single line
+func (l *Logger) log(level, msg string, ctxKeyvals, keyvals []interface{}) {
+	bufp := buffers.Get().(*[]byte)
+	buf := (*bufp)[:0]
+
+	buf = append(buf, `{"level":"`...)
+	buf = append(buf, level...)
+	buf = append(buf, `","time":`...)
+	buf = l.appendTime(buf, time.Now())
+	buf = append(buf, l.context...)
+	buf = appendFields(buf, ctxKeyvals)
+	buf = appendFields(buf, keyvals)
+	buf = append(buf, `,"message":`...)
+	buf = appendString(buf, msg)
+	buf = append(buf, "}\n"...)
+
+	l.output.Write(buf)
+
+	if cap(buf) <= maxPooledBuffer {
+		*bufp = buf
+		buffers.Put(bufp)
+	}
+}
+
+// appendTime appends a time in the configured format
+func (l *Logger) appendTime(buf []byte, t time.Time) []byte {
+	switch l.timeFormat {
+	case "unix":
+		return strconv.AppendInt(buf, t.Unix(), 10)
+	case "unix_ms":
+		return strconv.AppendInt(buf, t.UnixMilli(), 10)
+	default:
+		buf = append(buf, '"')
+		buf = t.AppendFormat(buf, l.timeFormat)
+		return append(buf, '"')
+	}
+}
+
+// appendFields appends alternating keys and values as JSON members, each
+// preceded by a comma. A key without a value gets MISSING_VALUE, like the
+// other loggers.
+func appendFields(buf []byte, keyvals []interface{}) []byte {
+	for i := 0; i < len(keyvals); i += 2 {
+		buf = append(buf, ',')
+		if key, ok := keyvals[i].(string); ok {
+			buf = appendString(buf, key)
+		} else {
+			buf = appendString(buf, fmt.Sprint(keyvals[i]))
+		}
+		buf = append(buf, ':')
+		if i+1 < len(keyvals) {
+			buf = appendValue(buf, keyvals[i+1])
+		} else {
+			buf = appendString(buf, "MISSING_VALUE")
+		}
+	}
+	return buf
+}
+
+// appendValue appends a value as JSON, without reflection for the common
+// types. Durations are in milliseconds, as zerolog encodes them.
+func appendValue(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case string:
+		return appendString(buf, v)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case uint:
+		return strconv.AppendUint(buf, uint64(v), 10)
+	case uint32:
+		return strconv.AppendUint(buf, uint64(v), 10)
+	case uint64:
+		return strconv.AppendUint(buf, v, 10)
+	case float32:
+		return appendFloat(buf, float64(v), 32)
+	case float64:
+		return appendFloat(buf, v, 64)
+	case time.Duration:
+		return appendFloat(buf, float64(v)/float64(time.Millisecond), 64)
+	case time.Time:
+		buf = append(buf, '"')
+		buf = v.AppendFormat(buf, time.RFC3339Nano)
+		return append(buf, '"')
+	case []byte:
+		return appendString(buf, string(v))
+	case error:
+		return appendString(buf, v.Error())
+	case fmt.Stringer:
+		return appendString(buf, v.String())
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return appendString(buf, fmt.Sprint(value))
+	}
+	return append(buf, encoded...)
+}
+
+// appendFloat appends a float, as a string if JSON has no number for it
+func appendFloat(buf []byte, f float64, bits int) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return appendString(buf, strconv.FormatFloat(f, 'g', -1, bits))
+	}
+	return strconv.AppendFloat(buf, f, 'f', -1, bits)
+}
+
+// hex are the digits of \u escapes
+const hex = "0123456789abcdef"
+
+// appendString appends a JSON string, escaping quotes, backslashes and
+// control characters and replacing invalid UTF-8
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf = append(buf, s[start:i]...)
+				buf = append(buf, "\ufffd"...)
+				i += size
+				start = i
+				continue
+			}
+			i += size
+			continue
+		}
+		if c >= 0x20 && c != '"' && c != '\\' {
+			i++
+			continue
+		}
+
+		buf = append(buf, s[start:i]...)
+		switch c {
+		case '"', '\\':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		default:
+			buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		}
+		i++
+		start = i
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
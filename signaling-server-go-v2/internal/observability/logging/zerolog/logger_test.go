@@ -0,0 +1,80 @@
+package zerolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	level := logging.NewLevel("info")
+	logger, err := NewZerologLogger(config.LoggingConfig{TimeFormat: "unix"}, WithOutput(&buf), WithLevel(level))
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	logger.Debug("Filtered")
+	if buf.Len() > 0 {
+		t.Fatalf("Debug message was logged when level is info: %s", buf.String())
+	}
+
+	ctx := logging.ContextWithRequestID(context.Background(), "req-1")
+	logger.With("component", "hub").InfoCtx(ctx, "Message \"relayed\"\n",
+		"client_id", "alice", "bytes", 42, "took", 1500*time.Microsecond, "ok", true, "error", errors.New("boom"), "dangling")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level":      "info",
+		"component":  "hub",
+		"request_id": "req-1",
+		"client_id":  "alice",
+		"bytes":      float64(42),
+		"took":       1.5,
+		"ok":         true,
+		"error":      "boom",
+		"dangling":   "MISSING_VALUE",
+		"message":    "Message \"relayed\"\n",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["time"].(float64); !ok {
+		t.Errorf("Expected a unix time, got %v", entry["time"])
+	}
+
+	// The level is shared
+	buf.Reset()
+	level.Set("debug")
+	logger.Debug("Shown")
+	if !bytes.Contains(buf.Bytes(), []byte(`"level":"debug"`)) {
+		t.Errorf("Expected the debug message once the level changed, got %s", buf.String())
+	}
+}
+
+func TestAppendString(t *testing.T) {
+	for _, s := range []string{"plain", "quote\" backslash\\", "tab\t bell\a", "ünïcödé ✓", "invalid \xff byte"} {
+		var decoded string
+		if err := json.Unmarshal(appendString(nil, s), &decoded); err != nil {
+			t.Errorf("Expected valid JSON for %q, got %s: %v", s, appendString(nil, s), err)
+			continue
+		}
+		expected, _ := json.Marshal(s)
+		var want string
+		json.Unmarshal(expected, &want)
+		if decoded != want {
+			t.Errorf("Expected %q, got %q", want, decoded)
+		}
+	}
+}
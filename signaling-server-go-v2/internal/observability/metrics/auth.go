@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+// Protect wraps the metrics handler so it only serves scrapes passing the
+// configured checks: a bearer token and a CIDR allowlist of client
+// addresses. Rejected scrapes get 401 for a bad token and 403 for a
+// disallowed address. It fails if an allowlist entry is not a valid CIDR.
+func Protect(cfg config.MetricsConfig, next http.Handler) (http.Handler, error) {
+	networks := make([]*net.IPNet, 0, len(cfg.AllowedCIDRs))
+	for _, cidr := range cfg.AllowedCIDRs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics allowlist entry %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+
+	if cfg.Token == "" && len(networks) == 0 {
+		return next, nil
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(networks) > 0 && !allowed(networks, r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if cfg.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// allowed reports whether the host of a remote address is in one of the networks
+func allowed(networks []*net.IPNet, remoteAddr string) bool {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+)
+
+func TestProtect(t *testing.T) {
+	handler, err := Protect(config.MetricsConfig{Token: "scrape", AllowedCIDRs: []string{"10.0.0.0/8"}}, MetricsHandler())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		token      string
+		status     int
+	}{
+		{"allowed with token", "10.1.2.3:9090", "scrape", http.StatusOK},
+		{"allowed without token", "10.1.2.3:9090", "", http.StatusUnauthorized},
+		{"wrong token", "10.1.2.3:9090", "guess", http.StatusUnauthorized},
+		{"outside allowlist", "203.0.113.5:9090", "scrape", http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.status {
+				t.Errorf("Expected status %d, got %d", tc.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestProtectRejectsInvalidCIDR(t *testing.T) {
+	if _, err := Protect(config.MetricsConfig{AllowedCIDRs: []string{"10.0.0.0"}}, MetricsHandler()); err == nil {
+		t.Error("Expected an error for an allowlist entry without a prefix length")
+	}
+}
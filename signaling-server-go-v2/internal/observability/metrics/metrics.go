@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -15,6 +17,22 @@ func MetricsHandler() http.Handler {
 	})
 }
 
+// RelayPayloadBuckets are the upper bounds in bytes of the payload size
+// buckets that segment the relay latency histogram, so that slow relays of
+// large SDPs can be told apart from slow consumers
+var RelayPayloadBuckets = []int{1 << 10, 4 << 10, 16 << 10, 64 << 10}
+
+// PayloadSizeBucket returns the payload size bucket label of a relayed
+// payload: the upper bound of its bucket in KiB, or "+Inf"
+func PayloadSizeBucket(size int) string {
+	for _, bound := range RelayPayloadBuckets {
+		if size <= bound {
+			return fmt.Sprintf("%dKiB", bound>>10)
+		}
+	}
+	return "+Inf"
+}
+
 // Metrics contains all the metrics for the signaling server
 type Metrics struct {
 	enabled bool
@@ -37,6 +55,25 @@ func (m *Metrics) WebSocketConnect() {
 	// In a real implementation, this would increment metrics
 }
 
+// WebSocketConnectCountry increments the WebSocket connections counter
+// labelled by the ISO country code of the remote IP. Only the country is used
+// as a label, finer locations would explode the series cardinality.
+func (m *Metrics) WebSocketConnectCountry(country string) {
+	// In a real implementation, this would increment metrics
+}
+
+// WebSocketConnectTenant increments the WebSocket connections counter
+// labelled by the tenant of the connection
+func (m *Metrics) WebSocketConnectTenant(tenant string) {
+	// In a real implementation, this would increment metrics
+}
+
+// WebSocketMessageTenant increments the WebSocket messages received counter
+// labelled by the tenant of the client
+func (m *Metrics) WebSocketMessageTenant(tenant string) {
+	// In a real implementation, this would increment metrics
+}
+
 // WebSocketDisconnect decrements the active WebSocket connections gauge
 func (m *Metrics) WebSocketDisconnect() {
 	// In a real implementation, this would decrement metrics
@@ -52,7 +89,153 @@ func (m *Metrics) WebSocketMessageSent(messageType string) {
 	// In a real implementation, this would increment metrics
 }
 
+// MessageRelayed increments the relayed signaling messages counter labelled
+// by message type, e.g. to tell renegotiations and ICE restarts from initial offers
+func (m *Metrics) MessageRelayed(messageType string) {
+	// In a real implementation, this would increment metrics
+}
+
+// DeprecatedUsage increments the counter of messages using a deprecated
+// protocol feature, labelled by feature, e.g. "join.password"
+func (m *Metrics) DeprecatedUsage(feature string) {
+	// In a real implementation, this would increment metrics
+}
+
 // WebSocketError increments the WebSocket errors counter
 func (m *Metrics) WebSocketError(errorType string) {
 	// In a real implementation, this would increment metrics
 }
+
+// TokenReplayed increments the counter of rejected attempts to reuse a
+// one-time token, labelled by token kind, e.g. "session"
+func (m *Metrics) TokenReplayed(kind string) {
+	// In a real implementation, this would increment metrics
+}
+
+// RoomExpired increments the expired rooms counter
+func (m *Metrics) RoomExpired() {
+	// In a real implementation, this would increment metrics
+}
+
+// EventsDropped adds to the counter of activity events dropped by an event
+// exporter, labelled by exporter
+func (m *Metrics) EventsDropped(exporter string, count int) {
+	// In a real implementation, this would add to the counter
+}
+
+// ClusterRelay increments the counter of at-least-once relays to other
+// instances labelled by outcome: "acked", "retried", "unacked" when given up
+// after the last attempt, or "abandoned" when the recipient left
+func (m *Metrics) ClusterRelay(outcome string) {
+	// In a real implementation, this would increment the counter
+}
+
+// CompressionBytes adds the size of a frame written to a connection that
+// negotiated permessage-deflate, before and after compression, to the byte
+// counters labelled by message type and whether it was compressed, so that
+// operators can compare the bytes saved with the thresholds
+func (m *Metrics) CompressionBytes(messageType string, compressed bool, size, wireSize int) {
+	// In a real implementation, this would add to the counters
+}
+
+// WarehouseExported adds to the counter of rows shipped to the analytics
+// warehouse, and sets the gauge of the rows still staged in its outbox
+func (m *Metrics) WarehouseExported(count, backlog int) {
+	// In a real implementation, this would add to the counter and set the gauge
+}
+
+// WebhookDelivered counts a webhook delivery and observes its latency,
+// retries included, labelled by event and outcome, "delivered" or "failed",
+// and the histogram of the attempts it took
+func (m *Metrics) WebhookDelivered(event, outcome string, attempts int, latency time.Duration) {
+	// In a real implementation, this would add to the counter and observe the histograms
+}
+
+// RelayLatency observes the time from receiving a relayed message to its
+// write on the recipient's connection, in a histogram labelled by message
+// type and payload size bucket, see PayloadSizeBucket. The time spent in the
+// recipient's send buffer surfaces slow consumers and backpressure.
+func (m *Metrics) RelayLatency(ctx context.Context, messageType string, payloadSize int, duration time.Duration) {
+	// In a real implementation, this would observe the histogram with the
+	// trace ID in the context attached as an exemplar, where supported
+}
+
+// RoomStats summarizes a room when it closes
+type RoomStats struct {
+	// Lifetime is the time from the room's creation to its closing
+	Lifetime time.Duration
+
+	// MaxPeers is the highest number of concurrent peers in the room
+	MaxPeers int
+
+	// JoinToFirstRelay is the time from the first join to the first relayed
+	// message, valid only if Relayed is set
+	JoinToFirstRelay time.Duration
+	Relayed          bool
+}
+
+// RoomClosed observes the lifetime, peak peer count and join to first relay
+// latency histograms of a closed room, labelled by the reason it closed
+func (m *Metrics) RoomClosed(reason string, stats RoomStats) {
+	// In a real implementation, this would observe the room histograms
+}
+
+// SendLatency observes the time a sampled message spent from its enqueue on
+// a client's send buffer to its flush to the connection, in a summary with
+// the 0.5, 0.9 and 0.99 quantiles. Read with the queue depth percentiles, it
+// tells clients on slow networks, whose buffers fill, from contention in the
+// server, which delays the messages of every client.
+func (m *Metrics) SendLatency(latency time.Duration) {
+	// In a real implementation, this would observe the summary
+}
+
+// SendQueueDepthBuckets are the upper bounds of the send queue depth histogram
+var SendQueueDepthBuckets = []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256}
+
+// ObserveSendQueueDepths registers the depths of the clients' send buffers
+// read on each scrape into the signaling_send_queue_depth histogram, whose
+// quantiles are the percentiles of the current depths across clients
+func (m *Metrics) ObserveSendQueueDepths(depths func() []int) {
+	// In a real implementation, this would register a collector building a
+	// constant histogram with SendQueueDepthBuckets from the depths
+}
+
+// Occupancy is a snapshot of the rooms of a SignalingManager
+type Occupancy struct {
+	Rooms int
+
+	// PeersPerRoom is the number of peers of each room
+	PeersPerRoom []int
+}
+
+// PeersPerRoomBuckets are the upper bounds of the peers per room histogram
+var PeersPerRoomBuckets = []float64{1, 2, 3, 4, 6, 8, 12, 16, 32, 64}
+
+// ObserveOccupancy registers the snapshot read on each scrape into the
+// signaling_rooms_active gauge and the signaling_peers_per_room histogram.
+// The histogram holds the distribution of the current rooms rather than
+// accumulating observations, so dashboards show live occupancy.
+func (m *Metrics) ObserveOccupancy(snapshot func() Occupancy) {
+	// In a real implementation, this would register a collector building a
+	// constant histogram with PeersPerRoomBuckets from each snapshot
+}
+
+// ObserveClientsConnected registers the count read on each scrape into the
+// signaling_clients_connected gauge, which counts the clients of every
+// transport rather than WebSocket connections only
+func (m *Metrics) ObserveClientsConnected(count func() int) {
+	// In a real implementation, this would register a GaugeFunc
+}
+
+// CallQuality observes a mean opinion score estimated from a client's quality
+// report in a histogram; scores are not labelled by room, which would explode
+// the series cardinality
+func (m *Metrics) CallQuality(mos float64) {
+	// In a real implementation, this would observe the histogram
+}
+
+// CallQualityDegraded increments the counter of rooms whose call quality
+// score fell below the degraded threshold
+func (m *Metrics) CallQualityDegraded() {
+	// In a real implementation, this would increment metrics
+}
@@ -0,0 +1,19 @@
+package metrics
+
+import "testing"
+
+func TestPayloadSizeBucket(t *testing.T) {
+	cases := map[int]string{
+		0:           "1KiB",
+		1024:        "1KiB",
+		1025:        "4KiB",
+		12 * 1024:   "16KiB",
+		64 * 1024:   "64KiB",
+		64*1024 + 1: "+Inf",
+	}
+	for size, expected := range cases {
+		if actual := PayloadSizeBucket(size); actual != expected {
+			t.Errorf("PayloadSizeBucket(%d) = %s, expected %s", size, actual, expected)
+		}
+	}
+}
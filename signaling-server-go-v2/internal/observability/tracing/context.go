@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// spanIDsKey is the context key of the trace and span IDs of the current span
+type spanIDsKey struct{}
+
+// spanIDs identifies a span and the trace it belongs to
+type spanIDs struct {
+	traceID string
+	spanID  string
+}
+
+// ContextWithSpanIDs returns a context carrying the trace and span IDs of a span
+func ContextWithSpanIDs(ctx context.Context, traceID, spanID string) context.Context {
+	return context.WithValue(ctx, spanIDsKey{}, spanIDs{traceID: traceID, spanID: spanID})
+}
+
+// SpanIDsFromContext returns the trace and span IDs of the span in the context, empty if there is none
+func SpanIDsFromContext(ctx context.Context) (traceID, spanID string) {
+	if ctx == nil {
+		return "", ""
+	}
+	ids, _ := ctx.Value(spanIDsKey{}).(spanIDs)
+	return ids.traceID, ids.spanID
+}
+
+// NewSpanContext returns a context for a new span that is a child of the span
+// in parent, if any, with freshly generated IDs in the W3C trace context format
+func NewSpanContext(parent context.Context) context.Context {
+	traceID, _ := SpanIDsFromContext(parent)
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+	return ContextWithSpanIDs(parent, traceID, randomHex(8))
+}
+
+// Traceparent formats the span in the context as a W3C traceparent value,
+// e.g. for a field of a message relayed to another party, empty if there is none
+func Traceparent(ctx context.Context) string {
+	traceID, spanID := SpanIDsFromContext(ctx)
+	if traceID == "" || spanID == "" {
+		return ""
+	}
+	return "00-" + traceID + "-" + spanID + "-01"
+}
+
+// ContextWithTraceparent returns a context carrying the span of a W3C
+// traceparent value, failing if the value is malformed
+func ContextWithTraceparent(ctx context.Context, traceparent string) (context.Context, error) {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx, fmt.Errorf("malformed traceparent %q", traceparent)
+	}
+	for _, part := range parts {
+		if _, err := hex.DecodeString(part); err != nil || strings.ToLower(part) != part {
+			return ctx, fmt.Errorf("malformed traceparent %q", traceparent)
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return ctx, fmt.Errorf("traceparent %q has a zero ID", traceparent)
+	}
+	return ContextWithSpanIDs(ctx, parts[1], parts[2]), nil
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -2,17 +2,38 @@ package otel
 
 import (
 	"context"
+	"time"
 
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/breaker"
 	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
 )
 
+// exportTimeout bounds a single export of finished spans
+const exportTimeout = 5 * time.Second
+
 // OTelTracer is a simplified Tracer implementation
-type OTelTracer struct{}
+type OTelTracer struct {
+	// spans buffers finished spans for export, nil if spans are not exported
+	spans *breaker.Queue[*OTelSpan]
+}
+
+// Option configures an OTelTracer
+type Option func(*OTelTracer)
+
+// WithExportBreaker exports finished spans in the background through a
+// bounded buffer of the given size and the circuit breaker, so that an
+// unreachable collector cannot slow down the traced code
+func WithExportBreaker(b *breaker.Breaker, bufferSize int) Option {
+	return func(t *OTelTracer) {
+		t.spans = breaker.NewQueue(bufferSize, 512, exportTimeout, b, exportSpans)
+	}
+}
 
 // OTelSpan is a simplified Span implementation
 type OTelSpan struct {
-	ctx context.Context
+	ctx    context.Context
+	tracer *OTelTracer
 }
 
 // Initialize sets up the OpenTelemetry provider
@@ -26,12 +47,31 @@ func Initialize(cfg config.TracingConfig) (interface{}, error) {
 }
 
 // NewOTelTracer creates a new OpenTelemetry tracer
-func NewOTelTracer(cfg config.TracingConfig) (tracing.Tracer, error) {
+func NewOTelTracer(cfg config.TracingConfig, opts ...Option) (tracing.Tracer, error) {
 	if !cfg.Enabled {
 		return &tracing.NoopTracer{}, nil
 	}
 
-	return &OTelTracer{}, nil
+	t := &OTelTracer{}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t, nil
+}
+
+// Shutdown exports the buffered spans, waiting until the context is done
+func (t *OTelTracer) Shutdown(ctx context.Context) error {
+	if t.spans == nil {
+		return nil
+	}
+	return t.spans.Close(ctx)
+}
+
+// exportSpans sends finished spans to the collector
+func exportSpans(ctx context.Context, spans []*OTelSpan) error {
+	// In a real implementation, this would send the spans with the OTLP exporter
+	return nil
 }
 
 // StartSpan implements Tracer.StartSpan
@@ -49,8 +89,12 @@ func (t *OTelTracer) StartSpan(name string, opts ...tracing.SpanOption) tracing.
 		ctx = context.Background()
 	}
 
+	// In a real implementation, the attributes and the links to
+	// options.Links would be set with trace.WithAttributes and trace.WithLinks
+
 	return &OTelSpan{
-		ctx: ctx,
+		ctx:    tracing.NewSpanContext(ctx),
+		tracer: t,
 	}
 }
 
@@ -68,7 +112,10 @@ func (t *OTelTracer) Extract(carrier interface{}) (context.Context, error) {
 
 // End implements Span.End
 func (s *OTelSpan) End() {
-	// In a real implementation, this would end the span
+	// In a real implementation, this would also record the end time
+	if s.tracer.spans != nil {
+		s.tracer.spans.Push(s)
+	}
 }
 
 // SetAttribute implements Span.SetAttribute
@@ -29,6 +29,10 @@ type SpanOption func(*SpanOptions)
 type SpanOptions struct {
 	Attributes map[string]interface{}
 	Parent     context.Context
+
+	// Links are the contexts of spans related to the span without being its
+	// parent, e.g. the span of another party a message was received from
+	Links []context.Context
 }
 
 // NoopTracer is a tracer that does nothing
@@ -88,6 +92,13 @@ func WithParent(ctx context.Context) SpanOption {
 	}
 }
 
+// WithLink creates a SpanOption that links the span to the span in the context
+func WithLink(ctx context.Context) SpanOption {
+	return func(opts *SpanOptions) {
+		opts.Links = append(opts.Links, ctx)
+	}
+}
+
 // NewTracer creates a new tracer based on the configuration
 func NewTracer(cfg config.TracingConfig) (Tracer, error) {
 	// Return NoopTracer if tracing is disabled
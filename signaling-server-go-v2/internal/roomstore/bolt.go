@@ -0,0 +1,94 @@
+// Package roomstore holds the records of cold rooms spilled out of the
+// signaling manager's memory and the webhook registrations of rooms. The
+// schema of the stores kept across restarts is versioned and migrated at
+// startup or with the -migrate flag.
+package roomstore
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// ErrNotFound is returned by Get for rooms without a record
+var ErrNotFound = errs.New(errs.NotFound, "room record not found")
+
+// roomsBucket is the bucket holding the room records
+var roomsBucket = []byte("rooms")
+
+// BoltStore is a RoomStore backed by a local BoltDB file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the BoltDB file at path. Records left by a
+// previous run are discarded, their peers' connections did not survive it.
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open room store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(roomsBucket) != nil {
+			if err := tx.DeleteBucket(roomsBucket); err != nil {
+				return err
+			}
+		}
+		_, err := tx.CreateBucket(roomsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize room store %s: %w", path, err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Put implements RoomStore.Put
+func (s *BoltStore) Put(roomID string, record []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(roomsBucket).Put([]byte(roomID), record)
+	})
+}
+
+// Get implements RoomStore.Get
+func (s *BoltStore) Get(roomID string) ([]byte, error) {
+	var record []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(roomsBucket).Get([]byte(roomID))
+		if value == nil {
+			return fmt.Errorf("%w: %s", ErrNotFound, roomID)
+		}
+		// Values are only valid during the transaction
+		record = append([]byte(nil), value...)
+		return nil
+	})
+	return record, err
+}
+
+// Delete implements RoomStore.Delete
+func (s *BoltStore) Delete(roomID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(roomsBucket).Delete([]byte(roomID))
+	})
+}
+
+// Len returns the number of stored records
+func (s *BoltStore) Len() int {
+	var n int
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(roomsBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Close closes the BoltDB file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,45 @@
+package roomstore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.db")
+	store, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt failed: %v", err)
+	}
+
+	if err := store.Put("room-1", []byte(`{"id":"room-1"}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	record, err := store.Get("room-1")
+	if err != nil || string(record) != `{"id":"room-1"}` {
+		t.Errorf("Expected the stored record, got %q (%v)", record, err)
+	}
+	if _, err := store.Get("room-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing room, got %v", err)
+	}
+
+	if err := store.Delete("room-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Get("room-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the record to be deleted, got %v", err)
+	}
+
+	// Records do not survive reopening the store
+	store.Put("room-3", []byte(`{}`))
+	store.Close()
+	store, err = OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt failed: %v", err)
+	}
+	defer store.Close()
+	if store.Len() != 0 {
+		t.Errorf("Expected records of a previous run to be discarded, got %d", store.Len())
+	}
+}
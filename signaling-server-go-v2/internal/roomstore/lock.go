@@ -0,0 +1,46 @@
+//go:build !windows && !plan9
+
+package roomstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockRetryInterval is how often a held lock is tried again
+const lockRetryInterval = 100 * time.Millisecond
+
+// lockFile takes an exclusive advisory lock on the file at path, creating it
+// if needed, waiting up to timeout for its holder. The lock is released by
+// the returned function, or by the system if the process dies.
+func lockFile(path string, timeout time.Duration) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("held by another process for over %s", timeout)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,132 @@
+package roomstore
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/errs"
+)
+
+// ErrMigrationsPending is returned when opening a store whose schema is
+// behind with manual migrations
+var ErrMigrationsPending = errs.New(errs.FailedPrecondition, "store migrations are pending")
+
+// metaBucket holds the schema version of a store
+var metaBucket = []byte("meta")
+
+// schemaVersionKey is the key of the schema version in the meta bucket
+var schemaVersionKey = []byte("schemaVersion")
+
+// Migration is a schema change of a store, applied once, in version order,
+// in its own transaction with the new version
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(tx *bolt.Tx) error
+}
+
+// MigrateOption configures how a store is migrated
+type MigrateOption func(*migrateOptions)
+
+type migrateOptions struct {
+	manual      bool
+	lockTimeout time.Duration
+}
+
+// WithManualMigrations refuses to open a store with pending migrations
+// rather than applying them, for environments where schema changes are
+// reviewed and run with the -migrate flag
+func WithManualMigrations() MigrateOption {
+	return func(o *migrateOptions) {
+		o.manual = true
+	}
+}
+
+// WithMigrationLockTimeout sets how long to wait for another process
+// migrating the same store, 30 seconds by default
+func WithMigrationLockTimeout(timeout time.Duration) MigrateOption {
+	return func(o *migrateOptions) {
+		o.lockTimeout = timeout
+	}
+}
+
+// migrate applies the pending migrations of the BoltDB file at path under an
+// advisory lock on path.lock, so that replicas starting at once with a shared
+// volume apply each migration once: the first migrates while the others wait,
+// then find nothing pending. It returns the number of migrations applied.
+func migrate(path string, migrations []Migration, opts []MigrateOption) (int, error) {
+	o := migrateOptions{lockTimeout: 30 * time.Second}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	unlock, err := lockFile(path+".lock", o.lockTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock %s for migration: %w", path, err)
+	}
+	defer unlock()
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer db.Close()
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the schema version of %s: %w", path, err)
+	}
+	if latest := migrations[len(migrations)-1].Version; version > latest {
+		return 0, fmt.Errorf("%s is at schema version %d, newer than this server's %d", path, version, latest)
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) > 0 && o.manual {
+		return 0, fmt.Errorf("%w: %s is at schema version %d, %d migrations to version %d are pending; run the server with -migrate",
+			ErrMigrationsPending, path, version, len(pending), pending[len(pending)-1].Version)
+	}
+
+	for i, m := range pending {
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.Apply(tx); err != nil {
+				return err
+			}
+			meta, err := tx.CreateBucketIfNotExists(metaBucket)
+			if err != nil {
+				return err
+			}
+			return meta.Put(schemaVersionKey, []byte(strconv.Itoa(m.Version)))
+		})
+		if err != nil {
+			return i, fmt.Errorf("failed to migrate %s to schema version %d (%s): %w", path, m.Version, m.Description, err)
+		}
+	}
+	return len(pending), nil
+}
+
+// schemaVersion returns the schema version of a store, 0 before its first migration
+func schemaVersion(db *bolt.DB) (int, error) {
+	var version int
+	err := db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if meta == nil {
+			return nil
+		}
+		value := meta.Get(schemaVersionKey)
+		if value == nil {
+			return nil
+		}
+		var err error
+		version, err = strconv.Atoi(string(value))
+		return err
+	})
+	return version, err
+}
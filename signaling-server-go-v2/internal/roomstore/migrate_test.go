@@ -0,0 +1,62 @@
+package roomstore
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+
+	var mutex sync.Mutex
+	var applied []int
+	migrations := []Migration{
+		{Version: 1, Description: "create bucket a", Apply: func(tx *bolt.Tx) error {
+			mutex.Lock()
+			applied = append(applied, 1)
+			mutex.Unlock()
+			_, err := tx.CreateBucket([]byte("a"))
+			return err
+		}},
+	}
+
+	// Replicas starting at once apply each migration once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := migrate(path, migrations, nil); err != nil {
+				t.Errorf("migrate failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(applied) != 1 {
+		t.Fatalf("Expected the migration to be applied once, got %v", applied)
+	}
+
+	// A new migration is pending until it is applied, with manual migrations
+	migrations = append(migrations, Migration{Version: 2, Description: "create bucket b", Apply: func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("b"))
+		return err
+	}})
+	if _, err := migrate(path, migrations, []MigrateOption{WithManualMigrations()}); !errors.Is(err, ErrMigrationsPending) {
+		t.Fatalf("Expected ErrMigrationsPending, got %v", err)
+	}
+	if n, err := migrate(path, migrations, nil); err != nil || n != 1 {
+		t.Fatalf("Expected the second migration to be applied, got %d, %v", n, err)
+	}
+	if _, err := migrate(path, migrations, []MigrateOption{WithManualMigrations()}); err != nil {
+		t.Errorf("Expected no pending migrations, got %v", err)
+	}
+
+	// A store migrated by a newer server is not opened
+	if _, err := migrate(path, migrations[:1], nil); err == nil {
+		t.Error("Expected a newer schema to be rejected")
+	}
+}
@@ -0,0 +1,120 @@
+package roomstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// outboxBucket is the bucket holding the outbox records by sequence number
+var outboxBucket = []byte("outbox")
+
+// outboxMigrations are the schema changes of the outbox store, in version order
+var outboxMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create the outbox bucket",
+		Apply: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(outboxBucket)
+			return err
+		},
+	},
+}
+
+// OutboxStore is an events.Outbox backed by a local BoltDB file, keeping
+// records in the order they were appended until they are acknowledged, across
+// restarts
+type OutboxStore struct {
+	db *bolt.DB
+}
+
+// MigrateOutboxStore applies the pending migrations of the outbox store at
+// path, creating it if needed, and returns the number applied
+func MigrateOutboxStore(path string, opts ...MigrateOption) (int, error) {
+	return migrate(path, outboxMigrations, opts)
+}
+
+// OpenOutboxStore opens or creates the BoltDB file at path, applying its
+// pending migrations first unless they are manual
+func OpenOutboxStore(path string, opts ...MigrateOption) (*OutboxStore, error) {
+	if _, err := MigrateOutboxStore(path, opts...); err != nil {
+		return nil, fmt.Errorf("failed to initialize outbox store %s: %w", path, err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox store %s: %w", path, err)
+	}
+	return &OutboxStore{db: db}, nil
+}
+
+// Append implements Outbox.Append, in a single transaction
+func (s *OutboxStore) Append(records [][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		for _, record := range records {
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(outboxKey(seq), record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Next implements Outbox.Next
+func (s *OutboxStore) Next(limit int) (uint64, [][]byte, error) {
+	var through uint64
+	records := make([][]byte, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(outboxBucket).Cursor()
+		for key, value := cursor.First(); key != nil && len(records) < limit; key, value = cursor.Next() {
+			through = binary.BigEndian.Uint64(key)
+			// Values are only valid during the transaction
+			records = append(records, append([]byte(nil), value...))
+		}
+		return nil
+	})
+	return through, records, err
+}
+
+// Ack implements Outbox.Ack
+func (s *OutboxStore) Ack(through uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(outboxBucket).Cursor()
+		for key, _ := cursor.First(); key != nil && binary.BigEndian.Uint64(key) <= through; key, _ = cursor.First() {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Len implements Outbox.Len
+func (s *OutboxStore) Len() int {
+	var n int
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(outboxBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Close closes the BoltDB file
+func (s *OutboxStore) Close() error {
+	return s.db.Close()
+}
+
+// outboxKey returns the key of a sequence number, big endian so that records
+// iterate in sequence order
+func outboxKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,44 @@
+package roomstore
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOutboxStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	store, err := OpenOutboxStore(path)
+	if err != nil {
+		t.Fatalf("OpenOutboxStore failed: %v", err)
+	}
+
+	store.Append([][]byte{[]byte("row-1"), []byte("row-2"), []byte("row-3")})
+	through, records, err := store.Next(2)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if through != 2 || !reflect.DeepEqual(records, [][]byte{[]byte("row-1"), []byte("row-2")}) {
+		t.Fatalf("Expected the 2 oldest records through 2, got %q through %d", records, through)
+	}
+	if err := store.Ack(through); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+
+	// Records not yet acknowledged survive reopening the store
+	store.Close()
+	store, err = OpenOutboxStore(path)
+	if err != nil {
+		t.Fatalf("OpenOutboxStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Append([][]byte{[]byte("row-4")})
+	through, records, _ = store.Next(10)
+	if through != 4 || !reflect.DeepEqual(records, [][]byte{[]byte("row-3"), []byte("row-4")}) {
+		t.Errorf("Expected the remaining records through 4, got %q through %d", records, through)
+	}
+	if store.Len() != 2 {
+		t.Errorf("Expected 2 records staged, got %d", store.Len())
+	}
+}
@@ -0,0 +1,81 @@
+package roomstore
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// webhooksBucket is the bucket holding the webhook registrations
+var webhooksBucket = []byte("webhooks")
+
+// webhookMigrations are the schema changes of the webhook store, in version order
+var webhookMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create the webhooks bucket",
+		Apply: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(webhooksBucket)
+			return err
+		},
+	},
+}
+
+// WebhookStore is an events.RegistrationStore backed by a local BoltDB file.
+// Unlike room records, registrations are kept across restarts.
+type WebhookStore struct {
+	db *bolt.DB
+}
+
+// MigrateWebhookStore applies the pending migrations of the webhook store at
+// path, creating it if needed, and returns the number applied
+func MigrateWebhookStore(path string, opts ...MigrateOption) (int, error) {
+	return migrate(path, webhookMigrations, opts)
+}
+
+// OpenWebhookStore opens or creates the BoltDB file at path, applying its
+// pending migrations first unless they are manual
+func OpenWebhookStore(path string, opts ...MigrateOption) (*WebhookStore, error) {
+	if _, err := MigrateWebhookStore(path, opts...); err != nil {
+		return nil, fmt.Errorf("failed to initialize webhook store %s: %w", path, err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhook store %s: %w", path, err)
+	}
+	return &WebhookStore{db: db}, nil
+}
+
+// Put implements RegistrationStore.Put
+func (s *WebhookStore) Put(id string, record []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhooksBucket).Put([]byte(id), record)
+	})
+}
+
+// Delete implements RegistrationStore.Delete
+func (s *WebhookStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhooksBucket).Delete([]byte(id))
+	})
+}
+
+// All implements RegistrationStore.All
+func (s *WebhookStore) All() (map[string][]byte, error) {
+	records := make(map[string][]byte)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhooksBucket).ForEach(func(id, value []byte) error {
+			// Values are only valid during the transaction
+			records[string(id)] = append([]byte(nil), value...)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Close closes the BoltDB file
+func (s *WebhookStore) Close() error {
+	return s.db.Close()
+}
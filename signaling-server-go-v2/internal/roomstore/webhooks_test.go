@@ -0,0 +1,38 @@
+package roomstore
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWebhookStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.db")
+	store, err := OpenWebhookStore(path)
+	if err != nil {
+		t.Fatalf("OpenWebhookStore failed: %v", err)
+	}
+
+	store.Put("hook-1", []byte(`{"id":"hook-1"}`))
+	store.Put("hook-2", []byte(`{"id":"hook-2"}`))
+	if err := store.Delete("hook-2"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// Registrations survive reopening the store
+	store.Close()
+	store, err = OpenWebhookStore(path)
+	if err != nil {
+		t.Fatalf("OpenWebhookStore failed: %v", err)
+	}
+	defer store.Close()
+
+	records, err := store.All()
+	if err != nil {
+		t.Fatalf("All failed: %v", err)
+	}
+	expected := map[string][]byte{"hook-1": []byte(`{"id":"hook-1"}`)}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %q, got %q", expected, records)
+	}
+}
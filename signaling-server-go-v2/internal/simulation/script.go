@@ -0,0 +1,53 @@
+package simulation
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RandomScript generates a reproducible script of random steps for the given
+// seed, over a fixed pool of clients and rooms
+func RandomScript(seed int64, numClients, numRooms, length int) []Step {
+	rng := rand.New(rand.NewSource(seed))
+
+	clients := make([]string, numClients)
+	for i := range clients {
+		clients[i] = fmt.Sprintf("client-%d", i+1)
+	}
+
+	rooms := make([]string, numRooms)
+	for i := range rooms {
+		rooms[i] = fmt.Sprintf("room-%d", i+1)
+	}
+
+	script := make([]Step, 0, length+numClients)
+	for _, client := range clients {
+		script = append(script, Step{Action: Connect, Client: client})
+	}
+
+	for i := 0; i < length; i++ {
+		client := clients[rng.Intn(len(clients))]
+		room := rooms[rng.Intn(len(rooms))]
+
+		switch n := rng.Intn(100); {
+		case n < 35:
+			script = append(script, Step{Action: Join, Client: client, Room: room})
+		case n < 55:
+			script = append(script, Step{Action: Leave, Client: client, Room: room})
+		case n < 70:
+			peer := clients[rng.Intn(len(clients))]
+			script = append(script, Step{Action: Relay, Client: client, Room: room, Peer: peer})
+		case n < 78:
+			script = append(script, Step{Action: Disconnect, Client: client})
+		case n < 85:
+			script = append(script, Step{Action: Connect, Client: client})
+		case n < 95:
+			script = append(script, Step{Action: Advance, Duration: time.Duration(rng.Intn(10)+1) * time.Minute})
+		default:
+			script = append(script, Step{Action: ExpireIdle, Duration: 15 * time.Minute})
+		}
+	}
+
+	return script
+}
@@ -0,0 +1,349 @@
+package simulation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/gorilla"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+)
+
+// Action is a scripted virtual client or environment action
+type Action string
+
+const (
+	// Connect opens a connection to the hub for a virtual client
+	Connect Action = "connect"
+
+	// Join sends a join message for a room
+	Join Action = "join"
+
+	// Leave sends a leave message for a room
+	Leave Action = "leave"
+
+	// Relay sends an offer to another client
+	Relay Action = "relay"
+
+	// Disconnect drops a virtual client's connection
+	Disconnect Action = "disconnect"
+
+	// Advance moves the fake clock forward
+	Advance Action = "advance"
+
+	// ExpireIdle runs the idle room janitor once
+	ExpireIdle Action = "expire-idle"
+)
+
+// Step is a single scripted action. Clients and peers are referred to by
+// script name; the hub assigns their client IDs when they connect.
+type Step struct {
+	Action   Action        `json:"action"`
+	Client   string        `json:"client,omitempty"`
+	Room     string        `json:"room,omitempty"`
+	Peer     string        `json:"peer,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Transition records the state of the SignalingManager after a step
+type Transition struct {
+	Index int                     `json:"index"`
+	Time  time.Time               `json:"time"`
+	Step  Step                    `json:"step"`
+	Error string                  `json:"error,omitempty"`
+	Rooms []protocol.RoomSnapshot `json:"rooms"`
+}
+
+// Recording is the ordered list of transitions produced by a simulation
+type Recording struct {
+	Transitions []Transition `json:"transitions"`
+}
+
+// VirtualClient is a scripted client connected to the WebSocket hub
+type VirtualClient struct {
+	Name      string
+	ID        string
+	Connected bool
+	Inbox     []protocol.Message
+
+	// Rooms holds the rooms the client believes it has joined
+	Rooms map[string]struct{}
+
+	conn *gorilla.Client
+}
+
+// Simulator drives the gorilla WebSocket hub wired to a SignalingManager, as
+// the server does, with virtual clients and a fake clock. Virtual clients send
+// messages through their hub connections and receive what the hub would write
+// to them, so every run of the same script produces the same recording.
+type Simulator struct {
+	clock     *FakeClock
+	hub       *gorilla.Handler
+	manager   *protocol.SignalingManager
+	clients   map[string]*VirtualClient
+	byID      map[string]*VirtualClient
+	recording Recording
+}
+
+// NewSimulator creates a Simulator with its clock starting at the given time
+func NewSimulator(logger logging.Logger, start time.Time, opts ...protocol.ManagerOption) *Simulator {
+	s := &Simulator{
+		clock:   NewFakeClock(start),
+		clients: make(map[string]*VirtualClient),
+		byID:    make(map[string]*VirtualClient),
+	}
+
+	connections := 0
+	s.hub = gorilla.NewHandler(config.WebSocketConfig{}, logger, nil, &tracing.NoopTracer{},
+		gorilla.WithClock(s.clock.Now),
+		gorilla.WithClientIDs(func() string {
+			connections++
+			return fmt.Sprintf("client-%d", connections)
+		}),
+		gorilla.WithMessageHandler(func(ctx context.Context, clientID string, message []byte) error {
+			return s.manager.ProcessMessageContext(ctx, message, clientID, s.hub.SendMessage)
+		}),
+		gorilla.WithDisconnectHandler(func(clientID string) {
+			s.manager.RemoveClient(clientID)
+		}),
+	).(*gorilla.Handler)
+
+	opts = append([]protocol.ManagerOption{
+		protocol.WithClock(s.clock.Now),
+		protocol.WithConnections(s.hub),
+	}, opts...)
+	s.manager = protocol.NewSignalingManager(logger, opts...)
+
+	return s
+}
+
+// Manager returns the simulated SignalingManager
+func (s *Simulator) Manager() *protocol.SignalingManager {
+	return s.manager
+}
+
+// Clock returns the simulation clock
+func (s *Simulator) Clock() *FakeClock {
+	return s.clock
+}
+
+// Client returns the latest virtual client with the given script name, or nil if it never connected
+func (s *Simulator) Client(name string) *VirtualClient {
+	return s.clients[name]
+}
+
+// Recording returns the transitions recorded so far
+func (s *Simulator) Recording() Recording {
+	return s.recording
+}
+
+// Run applies every step of a script and returns the recording
+func (s *Simulator) Run(script []Step) Recording {
+	for _, step := range script {
+		s.Apply(step)
+	}
+	return s.recording
+}
+
+// Apply executes a single step and records the resulting transition
+func (s *Simulator) Apply(step Step) error {
+	err := s.apply(step)
+	s.deliver()
+
+	transition := Transition{
+		Index: len(s.recording.Transitions),
+		Time:  s.clock.Now(),
+		Step:  step,
+		Rooms: s.manager.Snapshot(),
+	}
+	if err != nil {
+		transition.Error = err.Error()
+	}
+	s.recording.Transitions = append(s.recording.Transitions, transition)
+
+	return err
+}
+
+// apply executes a single step
+func (s *Simulator) apply(step Step) error {
+	switch step.Action {
+	case Connect:
+		if client, ok := s.clients[step.Client]; ok && client.Connected {
+			return fmt.Errorf("client already connected: %s", step.Client)
+		}
+		return s.connect(step.Client)
+	case Advance:
+		s.clock.Advance(step.Duration)
+		return nil
+	case ExpireIdle:
+		s.manager.ExpireIdleRooms(step.Duration)
+		return nil
+	}
+
+	client, ok := s.clients[step.Client]
+	if !ok || !client.Connected {
+		return fmt.Errorf("client not connected: %s", step.Client)
+	}
+
+	switch step.Action {
+	case Join:
+		return s.send(client, protocol.Message{Type: protocol.Join, Room: step.Room})
+	case Leave:
+		if err := s.send(client, protocol.Message{Type: protocol.Leave, Room: step.Room}); err != nil {
+			return err
+		}
+		delete(client.Rooms, step.Room)
+		return nil
+	case Relay:
+		recipient := step.Peer
+		if peer, ok := s.clients[step.Peer]; ok {
+			recipient = peer.ID
+		}
+		return s.send(client, protocol.Message{
+			Type:      protocol.Offer,
+			Room:      step.Room,
+			Recipient: recipient,
+			Payload:   json.RawMessage(`{"sdp":"simulated"}`),
+		})
+	case Disconnect:
+		client.conn.Disconnect()
+		return nil
+	default:
+		return fmt.Errorf("unknown action: %s", step.Action)
+	}
+}
+
+// connect opens a hub connection for a virtual client
+func (s *Simulator) connect(name string) error {
+	rec := httptest.NewRecorder()
+	s.hub.HandleConnection(rec, httptest.NewRequest("GET", "/ws", nil))
+
+	var resp struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		return fmt.Errorf("invalid connection response: %w", err)
+	}
+
+	conn, ok := s.hub.Client(resp.ClientID)
+	if !ok {
+		return fmt.Errorf("client not registered: %s", resp.ClientID)
+	}
+
+	client := &VirtualClient{
+		Name:      name,
+		ID:        resp.ClientID,
+		Connected: true,
+		Rooms:     make(map[string]struct{}),
+		conn:      conn,
+	}
+	s.clients[name] = client
+	s.byID[client.ID] = client
+	return nil
+}
+
+// send writes a message to a virtual client's hub connection
+func (s *Simulator) send(client *VirtualClient, msg protocol.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return client.conn.Receive(data)
+}
+
+// deliver moves the messages the hub wrote to each connected virtual client
+// into its inbox, in client order, and marks clients whose connection the
+// hub closed as disconnected
+func (s *Simulator) deliver() {
+	names := make([]string, 0, len(s.clients))
+	for name := range s.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		client := s.clients[name]
+		if !client.Connected {
+			continue
+		}
+
+		messages, open := client.conn.Drain()
+		for _, message := range messages {
+			var msg protocol.Message
+			if err := json.Unmarshal(message, &msg); err != nil {
+				continue
+			}
+			client.Inbox = append(client.Inbox, msg)
+
+			// Track the client's view of its room memberships
+			switch msg.Type {
+			case protocol.Joined:
+				client.Rooms[msg.Room] = struct{}{}
+			case protocol.RoomExpired, protocol.RoomClosed:
+				delete(client.Rooms, msg.Room)
+			}
+		}
+
+		if !open {
+			client.Connected = false
+			client.Rooms = make(map[string]struct{})
+		}
+	}
+}
+
+// CheckInvariants verifies the consistency of the SignalingManager with the
+// virtual clients and returns every violation found
+func (s *Simulator) CheckInvariants() []error {
+	var violations []error
+	rooms := s.manager.Snapshot()
+
+	if s.manager.GetRoomCount() != len(rooms) {
+		violations = append(violations, fmt.Errorf("room count %d does not match %d rooms", s.manager.GetRoomCount(), len(rooms)))
+	}
+
+	members := make(map[string]map[string]struct{})
+	for _, room := range rooms {
+		if len(room.Peers) == 0 {
+			violations = append(violations, fmt.Errorf("empty room retained: %s", room.ID))
+		}
+
+		peers := s.manager.GetPeersInRoom(room.ID)
+		sort.Strings(peers)
+		if fmt.Sprint(peers) != fmt.Sprint(room.Peers) {
+			violations = append(violations, fmt.Errorf("peers of room %s inconsistent: %v != %v", room.ID, peers, room.Peers))
+		}
+
+		for _, peer := range room.Peers {
+			client, ok := s.byID[peer]
+			if !ok || !client.Connected {
+				violations = append(violations, fmt.Errorf("disconnected client %s retained in room %s", peer, room.ID))
+				continue
+			}
+			if members[peer] == nil {
+				members[peer] = make(map[string]struct{})
+			}
+			members[peer][room.ID] = struct{}{}
+		}
+	}
+
+	for _, client := range s.byID {
+		for roomID := range client.Rooms {
+			if _, ok := members[client.ID][roomID]; !ok {
+				violations = append(violations, fmt.Errorf("client %s believes it is in deleted or foreign room %s", client.ID, roomID))
+			}
+		}
+		for roomID := range members[client.ID] {
+			if _, ok := client.Rooms[roomID]; !ok {
+				violations = append(violations, fmt.Errorf("client %s is in room %s without being told", client.ID, roomID))
+			}
+		}
+	}
+
+	return violations
+}
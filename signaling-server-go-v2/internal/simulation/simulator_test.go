@@ -0,0 +1,79 @@
+package simulation
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+var simulationStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestSimulatorScript(t *testing.T) {
+	sim := NewSimulator(&logging.NoopLogger{}, simulationStart)
+
+	recording := sim.Run([]Step{
+		{Action: Connect, Client: "alice"},
+		{Action: Connect, Client: "bob"},
+		{Action: Join, Client: "alice", Room: "standup"},
+		{Action: Join, Client: "bob", Room: "standup"},
+		{Action: Relay, Client: "alice", Room: "standup", Peer: "bob"},
+		{Action: Disconnect, Client: "bob"},
+		{Action: Advance, Duration: time.Hour},
+		{Action: ExpireIdle, Duration: 30 * time.Minute},
+	})
+
+	if len(recording.Transitions) != 8 {
+		t.Fatalf("Expected 8 transitions, got %d", len(recording.Transitions))
+	}
+
+	// Bob was welcomed and received alice's offer before disconnecting
+	alice, bob := sim.Client("alice"), sim.Client("bob")
+	if len(bob.Inbox) != 3 || bob.Inbox[0].Type != protocol.Welcome || bob.Inbox[2].Sender != alice.ID {
+		t.Errorf("Expected bob to receive welcome, joined and offer messages, got %+v", bob.Inbox)
+	}
+
+	// The hub removed bob from the room when his connection dropped
+	afterDisconnect := recording.Transitions[5].Rooms
+	if len(afterDisconnect) != 1 || !reflect.DeepEqual(afterDisconnect[0].Peers, []string{alice.ID}) {
+		t.Errorf("Expected only alice in standup after bob disconnected, got %+v", afterDisconnect)
+	}
+
+	// The idle room expired and alice was disconnected
+	if rooms := recording.Transitions[7].Rooms; len(rooms) != 0 {
+		t.Errorf("Expected no rooms after expiry, got %+v", rooms)
+	}
+	if alice.Connected {
+		t.Error("Expected alice to be disconnected when the room expired")
+	}
+
+	if violations := sim.CheckInvariants(); len(violations) != 0 {
+		t.Errorf("Unexpected invariant violations: %v", violations)
+	}
+}
+
+func TestSimulationIsDeterministic(t *testing.T) {
+	script := RandomScript(42, 5, 3, 200)
+
+	first, _ := json.Marshal(NewSimulator(&logging.NoopLogger{}, simulationStart).Run(script))
+	second, _ := json.Marshal(NewSimulator(&logging.NoopLogger{}, simulationStart).Run(script))
+
+	if string(first) != string(second) {
+		t.Error("Expected replaying the same script to produce the same recording")
+	}
+}
+
+func TestSimulationInvariants(t *testing.T) {
+	for seed := int64(1); seed <= 50; seed++ {
+		sim := NewSimulator(&logging.NoopLogger{}, simulationStart)
+		for i, step := range RandomScript(seed, 6, 3, 300) {
+			sim.Apply(step)
+			if violations := sim.CheckInvariants(); len(violations) != 0 {
+				t.Fatalf("Seed %d step %d (%+v) violated invariants: %v", seed, i, step, violations)
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// Package testsupport provides hand-written fakes of the server's public
+// interfaces for use in tests. The fakes record the calls made to them so
+// tests can assert on logging, tracing, routing and connection activity
+// without re-implementing the interfaces in every package.
+//
+// The package is internal, like the interfaces it fakes, so it is only
+// importable from within this module. Code outside the module cannot import
+// logging.Logger or protocol.Connections either, so exporting the fakes alone
+// would not help downstream users.
+package testsupport
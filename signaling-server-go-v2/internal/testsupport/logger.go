@@ -0,0 +1,120 @@
+package testsupport
+
+import (
+	"context"
+	"sync"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+)
+
+// LogEntry is a log call recorded by a Logger
+type LogEntry struct {
+	Level   string
+	Message string
+	Keyvals []interface{}
+}
+
+// logBuffer holds the entries shared by a Logger and the loggers derived from it with With
+type logBuffer struct {
+	mutex   sync.Mutex
+	entries []LogEntry
+}
+
+// Logger is a logging.Logger that records every call
+type Logger struct {
+	buffer *logBuffer
+	ctx    []interface{}
+}
+
+// NewLogger creates a Logger with no recorded entries
+func NewLogger() *Logger {
+	return &Logger{buffer: &logBuffer{}}
+}
+
+// Debug implements logging.Logger.Debug
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.record("DEBUG", msg, keyvals)
+}
+
+// Info implements logging.Logger.Info
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.record("INFO", msg, keyvals)
+}
+
+// Warn implements logging.Logger.Warn
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.record("WARN", msg, keyvals)
+}
+
+// Error implements logging.Logger.Error
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.record("ERROR", msg, keyvals)
+}
+
+// DebugCtx implements logging.Logger.DebugCtx
+func (l *Logger) DebugCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.record("DEBUG", msg, append(logging.ContextKeyvals(ctx), keyvals...))
+}
+
+// InfoCtx implements logging.Logger.InfoCtx
+func (l *Logger) InfoCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.record("INFO", msg, append(logging.ContextKeyvals(ctx), keyvals...))
+}
+
+// WarnCtx implements logging.Logger.WarnCtx
+func (l *Logger) WarnCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.record("WARN", msg, append(logging.ContextKeyvals(ctx), keyvals...))
+}
+
+// ErrorCtx implements logging.Logger.ErrorCtx
+func (l *Logger) ErrorCtx(ctx context.Context, msg string, keyvals ...interface{}) {
+	l.record("ERROR", msg, append(logging.ContextKeyvals(ctx), keyvals...))
+}
+
+// With implements logging.Logger.With; the returned logger records into the same entries
+func (l *Logger) With(keyvals ...interface{}) logging.Logger {
+	ctx := make([]interface{}, 0, len(l.ctx)+len(keyvals))
+	ctx = append(ctx, l.ctx...)
+	ctx = append(ctx, keyvals...)
+	return &Logger{buffer: l.buffer, ctx: ctx}
+}
+
+// Entries returns the recorded entries in call order
+func (l *Logger) Entries() []LogEntry {
+	l.buffer.mutex.Lock()
+	defer l.buffer.mutex.Unlock()
+
+	return append([]LogEntry(nil), l.buffer.entries...)
+}
+
+// Called reports whether a message was logged at the given level
+func (l *Logger) Called(level string) bool {
+	for _, entry := range l.Entries() {
+		if entry.Level == level {
+			return true
+		}
+	}
+	return false
+}
+
+// Logged reports whether the given message was logged at any level
+func (l *Logger) Logged(msg string) bool {
+	for _, entry := range l.Entries() {
+		if entry.Message == msg {
+			return true
+		}
+	}
+	return false
+}
+
+// record appends an entry including the logger's context key-value pairs
+func (l *Logger) record(level, msg string, keyvals []interface{}) {
+	all := make([]interface{}, 0, len(l.ctx)+len(keyvals))
+	all = append(all, l.ctx...)
+	all = append(all, keyvals...)
+
+	l.buffer.mutex.Lock()
+	defer l.buffer.mutex.Unlock()
+
+	l.buffer.entries = append(l.buffer.entries, LogEntry{Level: level, Message: msg, Keyvals: all})
+}
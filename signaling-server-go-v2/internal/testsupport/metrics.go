@@ -0,0 +1,12 @@
+package testsupport
+
+import (
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/config"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/metrics"
+)
+
+// NewMetrics returns a disabled metrics.Metrics for components that require one.
+// Metrics is a concrete type rather than an interface, so there is no recording fake.
+func NewMetrics() *metrics.Metrics {
+	return metrics.NewMetrics(config.MetricsConfig{})
+}
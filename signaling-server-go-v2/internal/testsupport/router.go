@@ -0,0 +1,40 @@
+package testsupport
+
+import (
+	"net/http"
+)
+
+// Router is a router.Router that matches requests on exact method and path
+type Router struct {
+	Handlers    map[string]http.Handler
+	Middlewares []func(http.Handler) http.Handler
+}
+
+// NewRouter creates a Router with no routes
+func NewRouter() *Router {
+	return &Router{Handlers: make(map[string]http.Handler)}
+}
+
+// Handle implements router.Router.Handle
+func (r *Router) Handle(method, path string, handler http.Handler) {
+	r.Handlers[method+":"+path] = handler
+}
+
+// HandleFunc implements router.Router.HandleFunc
+func (r *Router) HandleFunc(method, path string, handlerFunc func(http.ResponseWriter, *http.Request)) {
+	r.Handlers[method+":"+path] = http.HandlerFunc(handlerFunc)
+}
+
+// Use implements router.Router.Use; middleware is recorded but not applied
+func (r *Router) Use(middleware ...func(http.Handler) http.Handler) {
+	r.Middlewares = append(r.Middlewares, middleware...)
+}
+
+// ServeHTTP implements router.Router.ServeHTTP
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if handler, ok := r.Handlers[req.Method+":"+req.URL.Path]; ok {
+		handler.ServeHTTP(w, req)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
@@ -0,0 +1,51 @@
+package testsupport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/router"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/api/websocket/protocol"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/logging"
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+)
+
+// Compile-time checks that the fakes implement the interfaces
+var (
+	_ logging.Logger             = (*Logger)(nil)
+	_ tracing.Tracer             = (*Tracer)(nil)
+	_ tracing.Span               = (*Span)(nil)
+	_ websocket.WebSocketHandler = (*WebSocketHandler)(nil)
+	_ protocol.Connections       = (*WebSocketHandler)(nil)
+	_ router.Router              = (*Router)(nil)
+)
+
+func TestLoggerSharesEntriesWithDerivedLoggers(t *testing.T) {
+	logger := NewLogger()
+	logger.With("component", "test").Warn("derived", "key", "value")
+	logger.Info("root")
+
+	entries := logger.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Level != "WARN" || len(entries[0].Keyvals) != 4 || entries[0].Keyvals[0] != "component" {
+		t.Errorf("Unexpected derived entry: %+v", entries[0])
+	}
+	if !logger.Called("INFO") || logger.Called("ERROR") || !logger.Logged("root") {
+		t.Error("Unexpected Called/Logged results")
+	}
+}
+
+func TestTracerRecordsSpans(t *testing.T) {
+	tracer := NewTracer()
+	span := tracer.StartSpan("op", tracing.WithAttributes(map[string]interface{}{"a": 1}))
+	span.RecordError(errors.New("boom"))
+	span.End()
+
+	spans := tracer.Spans()
+	if len(spans) != 1 || spans[0].Name != "op" || !spans[0].Ended || spans[0].Attributes["a"] != 1 || len(spans[0].Errors) != 1 {
+		t.Errorf("Unexpected spans: %+v", spans)
+	}
+}
@@ -0,0 +1,113 @@
+package testsupport
+
+import (
+	"context"
+	"sync"
+
+	"github.com/babakgh/tuesdays/signaling-server-go-v2/internal/observability/tracing"
+)
+
+// Tracer is a tracing.Tracer that records the spans it starts
+type Tracer struct {
+	mutex sync.Mutex
+	spans []*Span
+}
+
+// NewTracer creates a Tracer with no recorded spans
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+// StartSpan implements tracing.Tracer.StartSpan
+func (t *Tracer) StartSpan(name string, opts ...tracing.SpanOption) tracing.Span {
+	options := &tracing.SpanOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	span := &Span{
+		Name:       name,
+		Attributes: make(map[string]interface{}),
+		ctx:        options.Parent,
+	}
+	for k, v := range options.Attributes {
+		span.Attributes[k] = v
+	}
+	if span.ctx == nil {
+		span.ctx = context.Background()
+	}
+
+	t.mutex.Lock()
+	t.spans = append(t.spans, span)
+	t.mutex.Unlock()
+
+	return span
+}
+
+// Inject implements tracing.Tracer.Inject
+func (t *Tracer) Inject(ctx context.Context, carrier interface{}) error {
+	return nil
+}
+
+// Extract implements tracing.Tracer.Extract
+func (t *Tracer) Extract(carrier interface{}) (context.Context, error) {
+	return context.Background(), nil
+}
+
+// Spans returns the started spans in start order
+func (t *Tracer) Spans() []*Span {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return append([]*Span(nil), t.spans...)
+}
+
+// SpanEvent is an event recorded on a Span
+type SpanEvent struct {
+	Name       string
+	Attributes map[string]interface{}
+}
+
+// Span is a tracing.Span that records the calls made to it
+type Span struct {
+	mutex      sync.Mutex
+	Name       string
+	Attributes map[string]interface{}
+	Events     []SpanEvent
+	Errors     []error
+	Ended      bool
+	ctx        context.Context
+}
+
+// End implements tracing.Span.End
+func (s *Span) End() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Ended = true
+}
+
+// SetAttribute implements tracing.Span.SetAttribute
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Attributes[key] = value
+}
+
+// AddEvent implements tracing.Span.AddEvent
+func (s *Span) AddEvent(name string, attributes map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Events = append(s.Events, SpanEvent{Name: name, Attributes: attributes})
+}
+
+// RecordError implements tracing.Span.RecordError
+func (s *Span) RecordError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Errors = append(s.Errors, err)
+}
+
+// Context implements tracing.Span.Context
+func (s *Span) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,74 @@
+package testsupport
+
+import (
+	"net/http"
+	"sync"
+)
+
+// CloseCall records a connection closed through a WebSocketHandler
+type CloseCall struct {
+	ClientID string
+	Code     int
+	Reason   string
+}
+
+// WebSocketHandler is a websocket.WebSocketHandler that records messages and
+// closed connections instead of delivering them. It also satisfies
+// protocol.Connections.
+type WebSocketHandler struct {
+	mutex      sync.Mutex
+	Broadcasts [][]byte
+	Sent       map[string][][]byte
+	Closed     []CloseCall
+}
+
+// NewWebSocketHandler creates a WebSocketHandler with no recorded activity
+func NewWebSocketHandler() *WebSocketHandler {
+	return &WebSocketHandler{Sent: make(map[string][][]byte)}
+}
+
+// HandleConnection implements websocket.WebSocketHandler.HandleConnection
+func (h *WebSocketHandler) HandleConnection(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("WebSocket connection"))
+}
+
+// BroadcastMessage implements websocket.WebSocketHandler.BroadcastMessage
+func (h *WebSocketHandler) BroadcastMessage(message []byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.Broadcasts = append(h.Broadcasts, message)
+	return nil
+}
+
+// SendMessage implements websocket.WebSocketHandler.SendMessage
+func (h *WebSocketHandler) SendMessage(clientID string, message []byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.Sent[clientID] = append(h.Sent[clientID], message)
+	return nil
+}
+
+// CloseConnection implements websocket.WebSocketHandler.CloseConnection
+func (h *WebSocketHandler) CloseConnection(clientID string) error {
+	return h.CloseConnectionWithReason(clientID, 1000, "")
+}
+
+// CloseConnectionWithReason implements websocket.WebSocketHandler.CloseConnectionWithReason
+func (h *WebSocketHandler) CloseConnectionWithReason(clientID string, code int, reason string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.Closed = append(h.Closed, CloseCall{ClientID: clientID, Code: code, Reason: reason})
+	return nil
+}
+
+// ClosedIDs returns the IDs of the closed connections in close order
+func (h *WebSocketHandler) ClosedIDs() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ids := make([]string, len(h.Closed))
+	for i, call := range h.Closed {
+		ids[i] = call.ClientID
+	}
+	return ids
+}